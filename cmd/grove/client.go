@@ -1,59 +1,76 @@
 package main
 
 import (
-	"bufio"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"io"
-	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"syscall"
 	"time"
 
-	"github.com/gandalfthegui/grove/internal/proto"
+	"github.com/gandalfthegui/grove/internal/registry"
+	"github.com/gandalfthegui/grove/pkg/client"
+	"github.com/gandalfthegui/grove/pkg/proto"
+	"golang.org/x/term"
 )
 
-// rootDir returns the groved data directory.
-// Precedence: GROVE_ROOT env var > ~/.grove
+// rootDir returns the groved data directory of the daemon this invocation
+// talks to; see profile.go.
 func rootDir() string {
-	if env := os.Getenv("GROVE_ROOT"); env != "" {
-		abs, err := filepath.Abs(env)
-		if err == nil {
-			return abs
-		}
-		return env
-	}
-	home, _ := os.UserHomeDir()
-	return filepath.Join(home, ".grove")
+	return currentTarget().Root
 }
 
-// daemonSocket returns the Unix socket path and ensures the daemon is running.
+// daemonSocket returns the Unix socket path and ensures the daemon is
+// running.  A daemon named by GROVE_SOCKET is not started: grove does not
+// know its root.
 func daemonSocket() string {
-	root := rootDir()
-	sock := filepath.Join(root, "groved.sock")
-	ensureDaemon(root, sock)
-	return sock
+	t := currentTarget()
+	if t.SocketOverride {
+		if !pingDaemon(t.Socket) {
+			fmt.Fprintf(os.Stderr, "grove: no daemon is answering on %s (GROVE_SOCKET)\n", t.Socket)
+			os.Exit(1)
+		}
+		return t.Socket
+	}
+	ensureDaemon(t.Root, t.Socket)
+	return t.Socket
 }
 
 // ensureDaemon starts groved in the background if the socket doesn't exist
-// or is not responding to pings.  root is passed via --root so the daemon
-// uses the same data directory that grove is targeting.
+// or is not responding to pings, with the arguments grove daemon install
+// would give it (see daemonArgs), and its output appended to daemon.log as
+// a LaunchAgent's is.
+//
+// Starts are serialised with a lock file: one invocation spawns the daemon,
+// and any others started at the same time wait for it and then find the
+// daemon answering.  A socket left behind by a crashed daemon is removed by
+// the new daemon, not here.
 func ensureDaemon(root, socketPath string) {
 	if pingDaemon(socketPath) {
 		return
 	}
 
+	unlock := lockDaemonStart(root)
+	defer unlock()
+	if pingDaemon(socketPath) {
+		return
+	}
+
 	exe, _ := os.Executable()
 	daemonBin := filepath.Join(filepath.Dir(exe), "groved")
 	if _, err := os.Stat(daemonBin); err != nil {
 		daemonBin = "groved"
 	}
 
-	cmd := exec.Command(daemonBin, "--root", root)
-	cmd.Stdout = nil
-	cmd.Stderr = nil
+	cmd := exec.Command(daemonBin, daemonArgs(root)...)
+	if logFd, err := os.OpenFile(filepath.Join(root, "daemon.log"), os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0o644); err == nil {
+		// The daemon has its own copy once started.
+		defer logFd.Close()
+		cmd.Stdout, cmd.Stderr = logFd, logFd
+	}
 	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
 	if err := cmd.Start(); err != nil {
 		fmt.Fprintf(os.Stderr, "grove: could not start daemon: %v\n", err)
@@ -73,108 +90,222 @@ func ensureDaemon(root, socketPath string) {
 	os.Exit(1)
 }
 
-// pingDaemon returns true if the daemon is alive and responding.
-func pingDaemon(socketPath string) bool {
-	conn, err := net.DialTimeout("unix", socketPath, 500*time.Millisecond)
-	if err != nil {
-		return false
+// daemonArgs returns the arguments groved runs with, after the binary, for
+// the daemon of root, from the environment and ~/.grove/config.yaml.
+func daemonArgs(root string) []string {
+	return buildDaemonArgs(root, registry.PathFromEnv(), loadUserConfig().DaemonArgs)
+}
+
+// buildDaemonArgs returns groved's arguments for the daemon of root, the
+// same whether grove starts it on demand or grove daemon install writes
+// them into a LaunchAgent, so that it behaves the same however it was
+// started.  projectDirs (GROVE_PROJECTS_PATH) become --projects-dir flags,
+// since a LaunchAgent does not pass on the environment, and extra
+// (daemon_args in config.yaml) follow as they are.
+func buildDaemonArgs(root string, projectDirs, extra []string) []string {
+	args := []string{"--root", root}
+	for _, dir := range projectDirs {
+		args = append(args, "--projects-dir", dir)
 	}
-	defer conn.Close()
+	return append(args, extra...)
+}
 
-	conn.SetDeadline(time.Now().Add(500 * time.Millisecond))
-	if err := writeRequest(conn, proto.Request{Type: proto.ReqPing}); err != nil {
-		return false
+// lockDaemonStart takes the lock that serialises starting the daemon,
+// waiting for any invocation that holds it.  If the lock cannot be taken the
+// start goes ahead unserialised; the daemon's own root lock still keeps a
+// second one from running.
+func lockDaemonStart(root string) (unlock func()) {
+	noop := func() {}
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return noop
+	}
+	f, err := os.OpenFile(filepath.Join(root, "groved.start.lock"), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return noop
 	}
-	resp, err := readResponse(conn)
-	return err == nil && resp.OK
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return noop
+	}
+	return func() { f.Close() }
+}
+
+// pingDaemon returns true if the daemon is alive and responding.
+func pingDaemon(socketPath string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	return client.New(socketPath).Ping(ctx) == nil
+}
+
+// daemonClient returns a client for the daemon, starting it if need be.
+func daemonClient() *client.Client {
+	return client.New(daemonSocket())
 }
 
 // tryRequest sends a request to the daemon and returns the response.
 // Unlike mustRequest it returns an error instead of exiting, so callers
 // can tolerate a daemon that isn't running.
 func tryRequest(req proto.Request) (proto.Response, error) {
-	root := rootDir()
-	sock := filepath.Join(root, "groved.sock")
-	conn, err := net.Dial("unix", sock)
+	resp, err := client.New(currentTarget().Socket).Do(context.Background(), req)
 	if err != nil {
-		return proto.Response{}, err
+		return resp, errors.New(errorText(err))
 	}
-	defer conn.Close()
+	return resp, nil
+}
 
-	if err := writeRequest(conn, req); err != nil {
-		return proto.Response{}, err
-	}
-	resp, err := readResponse(conn)
-	if err != nil {
-		return proto.Response{}, err
-	}
-	if !resp.OK {
-		return resp, fmt.Errorf("%s", resp.Error)
+// errorText is the message for a failed request: for a refusal, the
+// daemon's error followed, if the daemon said what to do about it, by its
+// hint on a line of its own.
+func errorText(err error) string {
+	var refused *client.Error
+	if !errors.As(err, &refused) || refused.Hint == "" {
+		return err.Error()
 	}
-	return resp, nil
+	return refused.Message + "\n" + formatHint(refused.Hint)
+}
+
+// formatHint renders a hint so that it stands out from the error above it.
+func formatHint(hint string) string {
+	return fmt.Sprintf("%shint:%s %s", colorYellow+colorBold, colorReset, hint)
 }
 
 // mustRequest sends a request to the daemon and returns the response, exiting
 // on any error.
 func mustRequest(req proto.Request) proto.Response {
-	socketPath := daemonSocket()
-	conn, err := net.Dial("unix", socketPath)
+	resp, err := daemonClient().Do(context.Background(), req)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+		fmt.Fprintf(os.Stderr, "grove: %s\n", errorText(err))
 		os.Exit(1)
 	}
-	defer conn.Close()
+	return resp
+}
 
-	if err := writeRequest(conn, req); err != nil {
-		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
-		os.Exit(1)
+// streamCommand sends req to the daemon, streams its output until the
+// result trailer, prints a summary, and exits non-zero unless every command
+// passed. Used by cmdFinish and cmdCheck; verb is their porcelain verb.
+//
+// With asJSON the streamed output goes to stderr and stdout carries only the
+// trailer JSON, so scripts can parse it directly.
+func streamCommand(req proto.Request, verb string, asJSON bool) {
+	out := os.Stdout
+	if asJSON {
+		out = os.Stderr
 	}
+	req.Interactive = interactiveStream(out)
+	res, raw := streamRequest(req, out)
+	instanceID := req.InstanceID
 
-	resp, err := readResponse(conn)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
-		os.Exit(1)
+	switch {
+	case asJSON:
+		fmt.Fprintln(resultOut, string(raw))
+	case porcelain:
+		printStreamResult(res)
+		printResult(streamedResult(verb, instanceID, res.OK))
+	default:
+		printStreamResult(res)
 	}
-	if !resp.OK {
-		fmt.Fprintf(os.Stderr, "grove: %s\n", resp.Error)
+	if !res.OK {
 		os.Exit(1)
 	}
-	return resp
 }
 
-// streamCommand sends a request to the daemon and streams its output to
-// stdout until the connection closes. Used by cmdFinish and cmdCheck.
-func streamCommand(reqType string, instanceID string) {
-	socketPath := daemonSocket()
-	conn, err := net.Dial("unix", socketPath)
+// streamRequest sends req, copies the streamed output to out, and returns the
+// result trailer and its raw JSON.  Exits with an error message if the
+// request is refused or the stream ends without a trailer.
+func streamRequest(req proto.Request, out io.Writer) (proto.StreamResult, []byte) {
+	var in io.Reader
+	stop := func() {}
+	if req.Interactive {
+		in, stop = terminalInput()
+	}
+	res, raw, err := daemonClient().RunStream(context.Background(), req, &pipeWriter{w: out}, in)
+	stop()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+		fmt.Fprintf(os.Stderr, "grove: %s\n", errorText(err))
 		os.Exit(1)
 	}
-	defer conn.Close()
+	return *res, raw
+}
 
-	if err := writeRequest(conn, proto.Request{Type: reqType, InstanceID: instanceID}); err != nil {
-		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
-		os.Exit(1)
+// printStreamResult prints one line per command and an overall summary.
+// Nothing is printed when no commands ran and nothing went wrong.
+func printStreamResult(res proto.StreamResult) {
+	if len(res.Commands) == 0 && res.Error == "" {
+		return
+	}
+	cmdW := 0
+	for _, c := range res.Commands {
+		if len(c.Label()) > cmdW {
+			cmdW = len(c.Label())
+		}
+	}
+	if cmdW > 50 {
+		cmdW = 50
 	}
 
-	resp, err := readResponse(conn)
-	if err != nil || !resp.OK {
-		msg := resp.Error
-		if msg == "" && err != nil {
-			msg = err.Error()
+	fmt.Println()
+	for _, c := range res.Commands {
+		mark, color, status := "✓", colorGreen, ""
+		if c.ExitCode != 0 {
+			mark, color = "✗", colorRed
+			status = fmt.Sprintf("exit %d", c.ExitCode)
+			if c.ExitCode < 0 {
+				status = "did not run"
+			}
 		}
-		fmt.Fprintf(os.Stderr, "grove: %s\n", msg)
-		os.Exit(1)
+		fmt.Printf("  %s%s%s  %-*s  %s%6s%s  %s%s%s\n",
+			color, mark, colorReset,
+			cmdW, truncate(c.Label(), cmdW),
+			colorDim, formatUptime(msToSecs(c.DurationMs)), colorReset,
+			colorRed, status, colorReset)
+	}
+	if res.Error != "" {
+		fmt.Printf("  %s✗  %s%s\n", colorRed, res.Error, colorReset)
+	}
+	if res.Hint != "" {
+		fmt.Printf("     %s\n", formatHint(res.Hint))
 	}
 
-	io.Copy(os.Stdout, conn)
+	color := colorGreen
+	if !res.OK {
+		color = colorRed
+	}
+	fmt.Printf("\n%s%s%s\n\n", color+colorBold, resultSummary(res), colorReset)
+}
+
+// resultSummary renders e.g. "2 passed, 1 failed in 3m12s".
+func resultSummary(res proto.StreamResult) string {
+	var passed, failed int
+	for _, c := range res.Commands {
+		if c.ExitCode == 0 {
+			passed++
+		} else {
+			failed++
+		}
+	}
+	return fmt.Sprintf("%d passed, %d failed in %s", passed, failed, formatUptime(msToSecs(res.DurationMs)))
+}
+
+// msToSecs rounds a millisecond duration to whole seconds.
+func msToSecs(ms int64) int64 {
+	return (ms + 500) / 1000
 }
 
 // findInstance looks up a single instance by ID from a live daemon list.
 // Returns nil and prints an error if the instance is not found.
 func findInstance(instanceID string) *proto.InstanceInfo {
-	resp := mustRequest(proto.Request{Type: proto.ReqList})
+	return findListed(mustRequest(proto.Request{Type: proto.ReqList}), instanceID)
+}
+
+// findInstanceWithChanges is findInstance with the instance's change summary
+// filled in.  An older daemon ignores the extra fields and lists every
+// instance without one, which findListed copes with.
+func findInstanceWithChanges(instanceID string) *proto.InstanceInfo {
+	resp := mustRequest(proto.Request{Type: proto.ReqList, InstanceID: instanceID, Changes: true})
+	return findListed(resp, instanceID)
+}
+
+func findListed(resp proto.Response, instanceID string) *proto.InstanceInfo {
 	for i := range resp.Instances {
 		if resp.Instances[i].ID == instanceID {
 			return &resp.Instances[i]
@@ -183,29 +314,14 @@ func findInstance(instanceID string) *proto.InstanceInfo {
 	return nil
 }
 
-func writeRequest(conn net.Conn, req proto.Request) error {
-	data, err := json.Marshal(req)
-	if err != nil {
-		return err
-	}
-	data = append(data, '\n')
-	_, err = conn.Write(data)
-	return err
-}
-
-func readResponse(conn net.Conn) (proto.Response, error) {
-	scanner := bufio.NewScanner(conn)
-	if !scanner.Scan() {
-		if err := scanner.Err(); err != nil {
-			return proto.Response{}, err
-		}
-		return proto.Response{}, io.EOF
+// terminalSize returns the size of the controlling terminal, or zeros when
+// stdout is not a terminal (e.g. grove start -d from a script).
+func terminalSize() (cols, rows uint16) {
+	w, h, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || w <= 0 || h <= 0 {
+		return 0, 0
 	}
-	var resp proto.Response
-	if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
-		return proto.Response{}, fmt.Errorf("bad response: %w", err)
-	}
-	return resp, nil
+	return uint16(w), uint16(h)
 }
 
 // warnIfDockerUnavailable prints a human-readable error to stderr when Docker
@@ -1,64 +1,164 @@
 package main
 
 import (
-	"encoding/binary"
+	"context"
 	"fmt"
 	"io"
-	"net"
 	"os"
+	"os/exec"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
 
-	"github.com/gandalfthegui/grove/internal/proto"
+	"github.com/gandalfthegui/grove/pkg/client"
+	"github.com/gandalfthegui/grove/pkg/proto"
 	"golang.org/x/term"
 )
 
 func cmdAttach() {
-	if len(os.Args) < 3 {
-		fmt.Fprintln(os.Stderr, "usage: grove attach <instance-id>")
+	args, inTmux := stripBoolFlag(os.Args[2:], "tmux", "tmux")
+	args, detachOnIdle := stripDetachOnIdle(args)
+	args, agents, err := stripValueFlag(args, "agent")
+	var commands []string
+	if err == nil {
+		args, commands, err = stripValueFlag(args, "command")
+	}
+	if err != nil || len(args) < 1 || len(agents) > 1 || len(commands) > 1 {
+		fmt.Fprintln(os.Stderr, "usage: grove attach <instance-id> [--agent <name> | --command <cmd>] [--detach-on-idle <duration>] [--tmux]")
 		os.Exit(1)
 	}
-	doAttach(os.Args[2])
+	var opts client.AttachOptions
+	if len(agents) == 1 {
+		opts.Agent = agents[0]
+	}
+	if len(commands) == 1 {
+		opts.Command = commands[0]
+		if strings.TrimSpace(opts.Command) == "" {
+			fmt.Fprintln(os.Stderr, "grove: --command: want a command to run")
+			os.Exit(1)
+		}
+		switch {
+		case opts.Agent != "":
+			fmt.Fprintln(os.Stderr, "grove: --command runs in a terminal of its own; drop --agent")
+			os.Exit(1)
+		case inTmux:
+			fmt.Fprintln(os.Stderr, "grove: --command cannot be combined with --tmux")
+			os.Exit(1)
+		case detachOnIdle > 0:
+			fmt.Fprintln(os.Stderr, "grove: --detach-on-idle watches the agent; it cannot be combined with --command")
+			os.Exit(1)
+		}
+	}
+	instanceID := resolveInstanceArg(args[0])
+	if inTmux {
+		if opts.Agent != "" {
+			fmt.Fprintln(os.Stderr, "grove: --tmux opens the primary agent's window; drop --agent")
+			os.Exit(1)
+		}
+		attachInTmux(instanceID, detachOnIdle)
+		return
+	}
+	doAttachAgent(instanceID, opts, detachOnIdle)
 }
 
-// doAttach connects the terminal to the instance PTY and blocks until the
-// user detaches (Ctrl-]) or the agent exits.
-func doAttach(instanceID string) {
-	socketPath := daemonSocket()
-	conn, err := net.Dial("unix", socketPath)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "grove: cannot connect to daemon: %v\n", err)
+// attachInTmux switches to, or opens, the tmux window attached to
+// instanceID.
+func attachInTmux(instanceID string, detachOnIdle time.Duration) {
+	if err := checkTmux(exec.LookPath); err != nil {
+		fmt.Fprintf(os.Stderr, "grove: --tmux: %v\n", err)
+		os.Exit(1)
+	}
+	inst := findInstance(instanceID)
+	if inst == nil {
+		fmt.Fprintf(os.Stderr, "grove: instance not found: %s (was it dropped?)\n", instanceID)
 		os.Exit(1)
 	}
-	// Note: conn is NOT deferred-closed here; the attach loop owns its lifetime.
+	if inst.State == proto.StateBroken {
+		fmt.Fprintf(os.Stderr, "grove: instance %s is BROKEN: its worktree was deleted; grove drop %s removes it\n", instanceID, instanceID)
+		os.Exit(1)
+	}
+	if proto.IsTerminal(inst.State) {
+		fmt.Fprintf(os.Stderr, "grove: instance %s is %s; restart it first\n", instanceID, inst.State)
+		os.Exit(1)
+	}
+	idle := ""
+	if detachOnIdle > 0 {
+		idle = detachOnIdle.String()
+	}
+	if err := tmuxAttach(*inst, idle); err != nil {
+		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+		os.Exit(1)
+	}
+	rememberInstance(instanceID)
+}
 
-	if err := writeRequest(conn, proto.Request{
-		Type:       proto.ReqAttach,
-		InstanceID: instanceID,
-	}); err != nil {
+// stripDetachOnIdle removes --detach-on-idle <duration> from args and
+// returns the remaining args and the duration, or 0 if it was not given.
+// Exits on a malformed duration.
+func stripDetachOnIdle(args []string) ([]string, time.Duration) {
+	rest, values, err := stripValueFlag(args, "detach-on-idle")
+	if err == nil && len(values) > 1 {
+		err = fmt.Errorf("--detach-on-idle given more than once")
+	}
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
 		os.Exit(1)
 	}
+	if len(values) == 0 {
+		return rest, 0
+	}
+	d, err := time.ParseDuration(values[0])
+	if err != nil || d <= 0 {
+		fmt.Fprintf(os.Stderr, "grove: --detach-on-idle: want a duration such as 10m, got %q\n", values[0])
+		os.Exit(1)
+	}
+	return rest, d
+}
 
-	resp, err := readResponse(conn)
-	if err != nil || !resp.OK {
-		msg := "attach failed"
-		if err != nil {
-			msg = err.Error()
-		} else if resp.Error != "" {
-			msg = resp.Error
-		}
-		fmt.Fprintf(os.Stderr, "grove: %s\n", msg)
-		conn.Close()
+// idleFor returns how long the agent has been waiting for input according
+// to st, counting only time since the session started at attachedAt: an
+// agent that was already idle when the user attached is not left at once.
+func idleFor(st proto.AttachState, attachedAt, now time.Time) time.Duration {
+	if st.State != proto.StateWaiting {
+		return 0
+	}
+	since := time.Unix(st.StateSince, 0)
+	if since.Before(attachedAt) {
+		since = attachedAt
+	}
+	return now.Sub(since)
+}
+
+// doAttach connects the terminal to the instance PTY and blocks until the
+// user detaches (Ctrl-]) or the agent exits.  With detachOnIdle set it also
+// detaches once the agent has waited for input that long, and notifies the
+// user.
+func doAttach(instanceID string, detachOnIdle time.Duration) {
+	doAttachAgent(instanceID, client.AttachOptions{}, detachOnIdle)
+}
+
+// doAttachAgent is doAttach for the terminal opts picks: one of the helpers
+// in grove.yaml's agents list, or a command run for this session alone, in
+// which case the process exits with the command's exit code once it ends.
+func doAttachAgent(instanceID string, opts client.AttachOptions, detachOnIdle time.Duration) {
+	// Note: sess is NOT deferred-closed here; the attach loop owns its lifetime.
+	sess, err := daemonClient().Attach(context.Background(), instanceID, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grove: %s\n", errorText(err))
 		os.Exit(1)
 	}
+	rememberInstance(instanceID)
+	if detachOnIdle > 0 && !sess.Reports() {
+		fmt.Fprintln(os.Stderr, "grove: the daemon does not report the agent's state; --detach-on-idle is ignored (restart the daemon to update it)")
+	}
 
 	fd := int(os.Stdin.Fd())
 	oldState, err := term.MakeRaw(fd)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "grove: cannot set raw mode: %v\n", err)
-		conn.Close()
+		sess.Close()
 		os.Exit(1)
 	}
 
@@ -70,20 +170,47 @@ func doAttach(instanceID string) {
 	}
 	defer restore()
 
-	fmt.Fprintf(os.Stdout, "\r\n[grove] attached to %s  (detach: Ctrl-])\r\n", instanceID)
+	switch {
+	case opts.Command != "":
+		fmt.Fprintf(os.Stdout, "\r\n[grove] running %q in %s  (stop: Ctrl-])\r\n", opts.Command, instanceID)
+	case opts.Agent != "":
+		fmt.Fprintf(os.Stdout, "\r\n[grove] attached to %s (agent %s)  (detach: Ctrl-])\r\n", instanceID, opts.Agent)
+	default:
+		fmt.Fprintf(os.Stdout, "\r\n[grove] attached to %s  (detach: Ctrl-])\r\n", instanceID)
+	}
 
 	done := make(chan struct{}, 1)
-
-	// Goroutine 1: copy PTY output (server → client) to stdout.
-	go func() {
-		io.Copy(os.Stdout, conn)
+	finish := func() {
 		select {
 		case done <- struct{}{}:
 		default:
 		}
+	}
+
+	// The stdin, resize, and idle goroutines all write to sess, which keeps
+	// their frames from interleaving.  Any write error means the daemon end
+	// is gone, so it ends the session instead of being silently dropped.
+	sendSize := func() bool {
+		cols, rows, err := term.GetSize(fd)
+		if err != nil {
+			return true
+		}
+		if err := sess.Resize(uint16(cols), uint16(rows)); err != nil {
+			finish()
+			return false
+		}
+		return true
+	}
+
+	// Goroutine 1: copy the agent's output to stdout.
+	readerDone := make(chan struct{})
+	go func() {
+		defer close(readerDone)
+		defer finish()
+		io.Copy(os.Stdout, sess)
 	}()
 
-	// Goroutine 2: read stdin, watch for Ctrl-], frame and send to server.
+	// Goroutine 2: read stdin, watch for Ctrl-], send the rest to the agent.
 	go func() {
 		buf := make([]byte, 256)
 		for {
@@ -91,57 +218,154 @@ func doAttach(instanceID string) {
 			if n > 0 {
 				for i := 0; i < n; i++ {
 					if buf[i] == 0x1D {
-						proto.WriteFrame(conn, proto.AttachFrameDetach, nil)
-						select {
-						case done <- struct{}{}:
-						default:
-						}
+						sess.Detach()
+						finish()
 						return
 					}
 				}
-				proto.WriteFrame(conn, proto.AttachFrameData, buf[:n])
+				if _, err := sess.Write(buf[:n]); err != nil {
+					finish()
+					return
+				}
 			}
 			if err != nil {
-				select {
-				case done <- struct{}{}:
-				default:
-				}
+				finish()
 				return
 			}
 		}
 	}()
 
-	// Forward terminal resize events.
-	winchCh := make(chan os.Signal, 1)
-	signal.Notify(winchCh, syscall.SIGWINCH)
+	// Goroutine 3: with --detach-on-idle, detach once the agent has waited
+	// for input long enough.  idle is only read once idleDone is closed.
+	var idle time.Duration
+	idleDone := make(chan struct{})
+	stopIdle := make(chan struct{})
 	go func() {
-		for range winchCh {
-			cols, rows, err := term.GetSize(fd)
-			if err == nil {
-				payload := make([]byte, 4)
-				binary.BigEndian.PutUint16(payload[0:2], uint16(cols))
-				binary.BigEndian.PutUint16(payload[2:4], uint16(rows))
-				proto.WriteFrame(conn, proto.AttachFrameResize, payload)
+		defer close(idleDone)
+		if detachOnIdle == 0 || !sess.Reports() {
+			return
+		}
+		attachedAt := time.Now()
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopIdle:
+				return
+			case now := <-ticker.C:
+				if d := idleFor(sess.State(), attachedAt, now); d >= detachOnIdle {
+					idle = d
+					sess.Detach()
+					return
+				}
 			}
 		}
 	}()
 
+	// Forward terminal resize events, at most resizeRate a second.
+	winchCh := make(chan os.Signal, 1)
+	signal.Notify(winchCh, syscall.SIGWINCH)
+	go coalesceResizes(winchCh, time.Second/resizeRate, sendSize)
+
 	// Send initial window size.
-	if cols, rows, err := term.GetSize(fd); err == nil {
-		payload := make([]byte, 4)
-		binary.BigEndian.PutUint16(payload[0:2], uint16(cols))
-		binary.BigEndian.PutUint16(payload[2:4], uint16(rows))
-		proto.WriteFrame(conn, proto.AttachFrameResize, payload)
-	}
+	sendSize()
 
 	<-done
 	signal.Stop(winchCh)
-	conn.Close()
+	close(stopIdle)
+	<-idleDone
+	sess.Close()
+	<-readerDone
 
 	// Restore terminal before printing the detach message so the output
 	// is not in raw mode.
 	restore()
 	// Reset terminal modes the agent may have left on (focus reporting, bracketed paste, etc.).
 	fmt.Fprint(os.Stdout, "\033[?1004l\033[?2004l")
-	fmt.Fprintf(os.Stdout, "\n[grove] detached from %s\n", instanceID)
+	if opts.Command != "" {
+		os.Exit(commandExitCode(sess.Exit()))
+	}
+	if e := sess.Exit(); e != nil {
+		fmt.Fprintf(os.Stdout, "\n[grove] agent exited with code %d; instance %s is %s\n", e.ExitCode, instanceID, e.State)
+		markTmuxWindow(instanceID, e.State)
+	} else {
+		fmt.Fprintf(os.Stdout, "\n[grove] detached from %s\n", instanceID)
+	}
+
+	if idle > 0 {
+		reportIdleDetach(instanceID, idle)
+	}
+}
+
+// commandExitCode returns the exit code grove attach --command exits with:
+// the command's, or 1 if it was stopped or its code is unknown.
+func commandExitCode(e *client.AttachExit) int {
+	if e == nil || e.ExitCode < 0 {
+		return 1
+	}
+	return e.ExitCode
+}
+
+// reportIdleDetach summarises an instance left by --detach-on-idle and
+// notifies the user.
+func reportIdleDetach(instanceID string, idle time.Duration) {
+	msg := fmt.Sprintf("instance %s has been waiting for input for %s", instanceID, formatUptime(int64(idle.Seconds())))
+	inst := findInstanceWithChanges(instanceID)
+	if inst == nil {
+		inst = &proto.InstanceInfo{ID: instanceID, State: proto.StateWaiting}
+	}
+	fmt.Printf("[grove] %s\n", msg)
+	fmt.Printf("  %sProject:%s %s  %sBranch:%s %s\n", colorDim, colorReset, inst.Project, colorDim, colorReset, inst.Branch)
+	if inst.Summary != "" {
+		fmt.Printf("  %sSummary:%s %s\n", colorDim, colorReset, inst.Summary)
+	}
+	if c := inst.Changes; c != nil && c.Shortstat != "" {
+		fmt.Printf("  %sChanges:%s %s (%s)\n", colorDim, colorReset, c.Shortstat, strings.Join(c.Files, ", "))
+	}
+	if inst.LastCheck != nil {
+		fmt.Printf("  %sLast check:%s %s\n", colorDim, colorReset, resultSummary(inst.LastCheck.Result))
+	}
+	if err := notifyUser(loadUserConfig().Notify, *inst, msg); err != nil {
+		fmt.Fprintf(os.Stderr, "grove: notify: %v\n", err)
+	}
+}
+
+// resizeRate is the most resize frames a second grove attach sends.
+// Dragging a terminal corner raises hundreds of SIGWINCHs; each frame costs
+// the agent a redraw, and on a slow link the frames delay keystrokes.
+const resizeRate = 30
+
+// coalesceResizes calls send for the signals on winch, at most once per
+// interval: a signal that comes sooner after the last call is held until
+// the interval is up, and further signals meanwhile are folded into it.
+// send reads the terminal's size when it is called, so the final size is
+// always sent.  It returns when send fails, or once winch is closed and
+// any held signal has been sent.
+func coalesceResizes(winch <-chan os.Signal, interval time.Duration, send func() bool) {
+	var last time.Time
+	var held <-chan time.Time
+	for {
+		select {
+		case _, ok := <-winch:
+			if !ok {
+				if held != nil {
+					send()
+				}
+				return
+			}
+			if held != nil {
+				continue
+			}
+			if wait := interval - time.Since(last); wait > 0 {
+				held = time.After(wait)
+				continue
+			}
+		case <-held:
+			held = nil
+		}
+		last = time.Now()
+		if !send() {
+			return
+		}
+	}
 }
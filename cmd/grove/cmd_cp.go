@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+
+	"github.com/gandalfthegui/grove/internal/archive"
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+// instancePathRe matches the "<instance-id>:<path>" side of a grove cp
+// argument.  IDs are lowercase alphanumerics, so local paths such as
+// "./a:b" or "/tmp/x:y" are never mistaken for instance paths.
+var instancePathRe = regexp.MustCompile(`^([0-9a-z]+):(.*)$`)
+
+// parseInstancePath splits "<id>:<path>" into its parts.  ok is false for a
+// plain local path.
+func parseInstancePath(arg string) (id, path string, ok bool) {
+	m := instancePathRe.FindStringSubmatch(arg)
+	if m == nil {
+		return "", "", false
+	}
+	return m[1], m[2], true
+}
+
+// cmdCp handles: grove cp [--via-daemon] <id>:<path> <local> | <local> <id>:<path>
+//
+// Paths inside the instance are relative to its worktree.  When the worktree
+// is reachable on this filesystem the copy is done locally; otherwise (or
+// with --via-daemon) the daemon streams a tar archive over the socket.
+func cmdCp() {
+	rawArgs, viaDaemon := stripBoolFlag(os.Args[2:], "via-daemon", "via-daemon")
+	if len(rawArgs) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: grove cp [--via-daemon] <id>:<path> <local> | <local> <id>:<path>")
+		os.Exit(1)
+	}
+	srcID, srcPath, srcRemote := parseInstancePath(rawArgs[0])
+	dstID, dstPath, dstRemote := parseInstancePath(rawArgs[1])
+	if srcRemote == dstRemote {
+		fmt.Fprintln(os.Stderr, "grove: exactly one of source or destination must be <instance-id>:<path>")
+		os.Exit(1)
+	}
+
+	direction, id, instPath, localPath := proto.CopyOut, srcID, srcPath, rawArgs[1]
+	if dstRemote {
+		direction, id, instPath, localPath = proto.CopyIn, dstID, dstPath, rawArgs[0]
+	}
+
+	inst := resolveInstance(id)
+
+	var err error
+	if _, statErr := os.Stat(inst.WorktreeDir); statErr == nil && !viaDaemon {
+		err = copyLocal(inst.WorktreeDir, direction, instPath, localPath)
+	} else {
+		err = copyViaDaemon(id, direction, instPath, localPath)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// copyLocal copies directly between the worktree and localPath.
+func copyLocal(worktreeDir, direction, instPath, localPath string) error {
+	target, err := archive.SafeJoin(worktreeDir, instPath)
+	if err != nil {
+		return err
+	}
+	src, dst := target, localPath
+	if direction == proto.CopyIn {
+		src, dst = localPath, target
+	}
+	if _, err := os.Lstat(src); err != nil {
+		return fmt.Errorf("no such file: %s", src)
+	}
+
+	pr, pw := io.Pipe()
+	go func() { pw.CloseWithError(archive.Write(pw, src)) }()
+	err = archive.Extract(pr, dst)
+	pr.Close()
+	return err
+}
+
+// copyViaDaemon asks the daemon to stream the copy over the socket.
+func copyViaDaemon(instanceID, direction, instPath, localPath string) error {
+	if direction == proto.CopyIn {
+		if _, err := os.Lstat(localPath); err != nil {
+			return fmt.Errorf("no such file: %s", localPath)
+		}
+	}
+
+	_, conn, err := daemonClient().Open(context.Background(), proto.Request{
+		Type:       proto.ReqCopy,
+		InstanceID: instanceID,
+		Path:       instPath,
+		Direction:  direction,
+	})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if direction == proto.CopyOut {
+		return archive.Extract(conn, localPath)
+	}
+
+	if err := archive.Write(conn, localPath); err != nil {
+		return err
+	}
+	resp, err := conn.ReadResponse()
+	if err != nil {
+		return err
+	}
+	if !resp.OK {
+		return fmt.Errorf("%s", resp.Error)
+	}
+	return nil
+}
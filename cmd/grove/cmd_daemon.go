@@ -2,14 +2,18 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
 )
 
 func cmdDaemon() {
@@ -23,7 +27,13 @@ func cmdDaemon() {
 	case "uninstall":
 		cmdDaemonUninstall()
 	case "status":
-		cmdDaemonStatus()
+		rawArgs, verbose := stripBoolFlag(os.Args[3:], "v", "verbose")
+		if len(rawArgs) != 0 {
+			fmt.Fprintln(os.Stderr, "usage: grove daemon status [--verbose]")
+			os.Exit(1)
+		}
+		printProfiles()
+		cmdDaemonStatus(verbose)
 	case "logs":
 		cmdDaemonLogs()
 	default:
@@ -32,39 +42,246 @@ func cmdDaemon() {
 	}
 }
 
+// daemonTools are the programs groved runs, and so must find on its PATH.
+var daemonTools = []string{"git", "docker"}
+
+// toolsMissingFromPath returns the names that no directory in envPath holds
+// as an executable file.
+func toolsMissingFromPath(envPath string, names []string) []string {
+	var missing []string
+	for _, name := range names {
+		found := false
+		for _, dir := range filepath.SplitList(envPath) {
+			if dir == "" {
+				continue
+			}
+			if fi, err := os.Stat(filepath.Join(dir, name)); err == nil && !fi.IsDir() && fi.Mode()&0o111 != 0 {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// printProfiles lists the daemon profiles and whether each daemon is
+// running, for grove daemon status.
+func printProfiles() {
+	home, _ := os.UserHomeDir()
+	fmt.Print(renderProfiles(profileStatuses(home, currentTarget(), pingDaemon), home))
+	fmt.Println()
+}
+
+// printDaemonHealth prints which daemon this invocation talks to, then the
+// running daemon's PATH, where it found each tool it needs and the free
+// disk space, flagging any problem.  It reports whether there was none.
+func printDaemonHealth() bool {
+	t := currentTarget()
+	fmt.Printf("  %sdaemon:%s %s\n", colorDim, colorReset, t.describe())
+	fmt.Printf("  %sroot:%s   %s\n", colorDim, colorReset, t.Root)
+	socket := t.Socket
+	if t.SocketOverride {
+		socket += " (from GROVE_SOCKET)"
+	}
+	fmt.Printf("  %ssocket:%s %s\n", colorDim, colorReset, socket)
+	resp, err := tryRequest(proto.Request{Type: proto.ReqPing})
+	if err != nil || resp.Health == nil {
+		fmt.Printf("  %shealth:%s unavailable (daemon not reachable)\n", colorDim, colorReset)
+		return false
+	}
+	h := resp.Health
+	fmt.Printf("  %sPATH:%s   %s\n", colorDim, colorReset, h.Path)
+	for _, name := range daemonTools {
+		if path, ok := h.Tools[name]; ok {
+			fmt.Printf("  %s%-6s%s  %s\n", colorDim, name+":", colorReset, path)
+		} else {
+			fmt.Printf("  %s%-6s%s  %snot found%s\n", colorDim, name+":", colorReset, colorRed, colorReset)
+		}
+	}
+	if len(h.Missing) > 0 {
+		fmt.Printf("\n%s⚠  degraded:%s the daemon cannot find %s on its PATH.\n", colorYellow+colorBold, colorReset, strings.Join(h.Missing, ", "))
+		fmt.Printf("  Re-run %sgrove daemon install%s from a shell where they work.\n", colorBold, colorReset)
+	}
+	if h.Web != "" {
+		fmt.Printf("  %sweb:%s    http://%s/ (grove web prints the URL with its token)\n", colorDim, colorReset, h.Web)
+	}
+	fmt.Print(renderDiskSpace(h))
+	fmt.Print(renderLocks(h, time.Now()))
+	low := false
+	for _, s := range h.Disk {
+		low = low || s.Low(h.DiskWarn) || s.Low(h.DiskMin)
+	}
+	if low {
+		fmt.Printf("\n%s⚠  low on disk space:%s free some with %sgrove prune%s or %sdocker system prune%s.\n",
+			colorYellow+colorBold, colorReset, colorBold, colorReset, colorBold, colorReset)
+	}
+	fmt.Println()
+	return len(h.Missing) == 0 && !low
+}
+
+// renderDiskSpace describes the free space on each filesystem in h, flagging
+// those below the daemon's thresholds.
+func renderDiskSpace(h *proto.DaemonHealth) string {
+	var b strings.Builder
+	for i, s := range h.Disk {
+		label := ""
+		if i == 0 {
+			label = "disk:"
+		}
+		free := fmt.Sprintf("%s free of %s", proto.FormatBytes(s.Free), proto.FormatBytes(s.Total))
+		switch {
+		case s.Error != "":
+			free = colorDim + "unknown: " + s.Error + colorReset
+		case s.Low(h.DiskMin):
+			free += fmt.Sprintf("  %sbelow %s, starts are refused%s", colorRed, proto.FormatBytes(h.DiskMin), colorReset)
+		case s.Low(h.DiskWarn):
+			free += fmt.Sprintf("  %sbelow %s%s", colorYellow, proto.FormatBytes(h.DiskWarn), colorReset)
+		}
+		fmt.Fprintf(&b, "  %s%-6s%s  %-6s  %s  %s\n", colorDim, label, colorReset, s.Name, free, s.Path)
+	}
+	return b.String()
+}
+
+// renderLocks lists the project locks in h, with how long each has been
+// held and what is waiting for it.
+func renderLocks(h *proto.DaemonHealth, now time.Time) string {
+	var b strings.Builder
+	for i, l := range h.Locks {
+		label := ""
+		if i == 0 {
+			label = "locks:"
+		}
+		holder := colorDim + "free" + colorReset
+		if l.Holder != "" {
+			holder = fmt.Sprintf("held by %s for %s", l.Holder, formatAge(now.Sub(time.Unix(l.Since, 0))))
+		}
+		fmt.Fprintf(&b, "  %s%-6s%s  %s  %s\n", colorDim, label, colorReset, l.Project, holder)
+		for _, w := range l.Waiting {
+			fmt.Fprintf(&b, "          %swaiting:%s %s for %s\n", colorYellow, colorReset, w.What, formatAge(now.Sub(time.Unix(w.Since, 0))))
+		}
+	}
+	return b.String()
+}
+
+// cmdDoctor checks the daemon: that it is reachable, finds the tools it
+// needs and has the disk space to start instances, and that the directories
+// its instances refer to exist.  It exits 1 if not.
+func cmdDoctor() {
+	if len(os.Args) > 2 {
+		fmt.Fprintln(os.Stderr, "usage: grove doctor")
+		os.Exit(1)
+	}
+	ok := printDaemonHealth()
+	if resp, err := tryRequest(proto.Request{Type: proto.ReqList}); err == nil {
+		if missing := missingInstancePaths(resp.Instances, dirExists); len(missing) > 0 {
+			fmt.Printf("%s⚠  missing directories:%s\n", colorYellow+colorBold, colorReset)
+			for _, m := range missing {
+				fmt.Printf("  %s\n", m)
+			}
+			fmt.Printf("  %sgrove drop <id>%s removes an instance whose worktree is gone.\n\n", colorBold, colorReset)
+			ok = false
+		}
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+// missingInstancePaths describes the worktrees and main checkouts recorded
+// for instances that exists reports missing, one line each: the instance,
+// which directory, its path and the instance's state.
+func missingInstancePaths(instances []proto.InstanceInfo, exists func(string) bool) []string {
+	var missing []string
+	for _, inst := range instances {
+		for _, p := range []struct{ what, path string }{
+			{"worktree", inst.WorktreeDir},
+			{"checkout", inst.MainDir},
+		} {
+			if p.path == "" || exists(p.path) || p.what == "worktree" && inst.WorktreeRemoved {
+				continue
+			}
+			missing = append(missing, fmt.Sprintf("%-10s  %-8s  %s (%s)", inst.ID, p.what, p.path, inst.State))
+		}
+	}
+	return missing
+}
+
+func dirExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// cmdGC handles: grove gc
+//
+// Asks the daemon to remove leftovers that no instance can use: the
+// container.scratch volumes of containers that are gone, and git's records
+// of worktrees whose directories are gone.
+func cmdGC() {
+	if len(os.Args) > 2 {
+		fmt.Fprintln(os.Stderr, "usage: grove gc")
+		os.Exit(1)
+	}
+	res, _ := streamRequest(proto.Request{Type: proto.ReqGC}, os.Stdout)
+	if !res.OK {
+		fmt.Fprintf(os.Stderr, "grove: gc: %s\n", res.Error)
+		os.Exit(1)
+	}
+}
+
 func cmdDaemonLogs() {
+	const usage = "usage: grove daemon logs [-f] [-n N | --since <duration>]"
 	fs := flag.NewFlagSet("daemon logs", flag.ExitOnError)
 	follow := fs.Bool("f", false, "follow log output")
 	fs.BoolVar(follow, "follow", false, "follow log output")
 	tailLines := fs.Int("n", 0, "print only the last N lines (0 = full file)")
 	fs.IntVar(tailLines, "tail", 0, "print only the last N lines (0 = full file)")
+	since := fs.Duration("since", 0, "print only lines logged in the last `duration`, e.g. 1h")
 	fs.Usage = func() {
-		fmt.Fprintln(os.Stderr, "usage: grove daemon logs [-f] [-n N]")
+		fmt.Fprintln(os.Stderr, usage)
 	}
-	fs.Parse(os.Args[3:])
-	if len(fs.Args()) != 0 {
-		fmt.Fprintln(os.Stderr, "usage: grove daemon logs [-f] [-n N]")
+	if args, _ := parseArgs(fs, os.Args[3:]); len(args) != 0 {
+		fmt.Fprintln(os.Stderr, usage)
 		os.Exit(1)
 	}
 	if *tailLines < 0 {
 		fmt.Fprintln(os.Stderr, "grove: -n/--tail must be >= 0")
 		os.Exit(1)
 	}
+	if *since < 0 || (*since > 0 && *tailLines > 0) {
+		fmt.Fprintln(os.Stderr, "grove: --since takes a positive duration and cannot be combined with -n")
+		os.Exit(1)
+	}
 
 	logPath := filepath.Join(rootDir(), "daemon.log")
 	var err error
-	if *tailLines > 0 {
+	switch {
+	case *tailLines > 0:
 		err = printLastLines(logPath, *tailLines, os.Stdout)
-	} else {
+	case *since > 0:
+		err = printLinesSince(logPath, time.Now().Add(-*since), os.Stdout)
+	default:
 		err = copyFileToStdout(logPath)
 	}
+	exitIfBrokenPipe(err)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
 		os.Exit(1)
 	}
 
 	if *follow {
-		if err := followFile(logPath); err != nil {
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+		defer signal.Stop(sigCh)
+		stop := make(chan struct{})
+		go func() {
+			<-sigCh
+			close(stop)
+		}()
+		if err := followFile(logPath, os.Stdout, time.Second, stop); err != nil {
+			exitIfBrokenPipe(err)
 			fmt.Fprintf(os.Stderr, "grove: %v\n", err)
 			os.Exit(1)
 		}
@@ -122,7 +339,11 @@ func printLastLines(path string, n int, w io.Writer) error {
 	return nil
 }
 
-func followFile(path string) error {
+// printLinesSince prints the daemon log from the first line logged at or
+// after cutoff (see logLineTime).  Lines without a timestamp, such as the
+// rest of a multi-line message, go with the line before them.  If no line
+// has a timestamp it prints the whole log.
+func printLinesSince(path string, cutoff time.Time, w io.Writer) error {
 	f, err := os.Open(path)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -132,42 +353,137 @@ func followFile(path string) error {
 	}
 	defer f.Close()
 
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	var held []string // the lines so far, while none had a timestamp
+	dated, printing := false, false
+	for scanner.Scan() {
+		line := scanner.Text()
+		if t, ok := logLineTime(line); ok {
+			dated = true
+			held = nil
+			if !printing && !t.Before(cutoff) {
+				printing = true
+			}
+		} else if !dated {
+			held = append(held, line)
+		}
+		if printing {
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				return err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read daemon log: %w", err)
+	}
+	for _, line := range held {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// logLineTime returns when a daemon log line was written, from the standard
+// log prefix ("2006/01/02 15:04:05", local time, optionally with
+// microseconds) or the "time" field of a JSON (slog) line.
+func logLineTime(line string) (time.Time, bool) {
+	if strings.HasPrefix(line, "{") {
+		var rec struct {
+			Time time.Time `json:"time"`
+		}
+		if json.Unmarshal([]byte(line), &rec) == nil && !rec.Time.IsZero() {
+			return rec.Time, true
+		}
+		return time.Time{}, false
+	}
+	for _, layout := range []string{"2006/01/02 15:04:05.000000", "2006/01/02 15:04:05"} {
+		if len(line) < len(layout) {
+			continue
+		}
+		if t, err := time.ParseInLocation(layout, line[:len(layout)], time.Local); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// followFile copies what is appended to path to w, checking every interval,
+// until stop is closed.  The path is watched, not just the open file: when
+// the file is truncated it starts from the top, and when it is replaced
+// (rotated, or moved away and recreated) it finishes the old file and
+// switches to the new one.
+func followFile(path string, w io.Writer, interval time.Duration, stop <-chan struct{}) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("daemon log not found at %s", path)
+		}
+		return fmt.Errorf("open daemon log: %w", err)
+	}
+	defer func() { f.Close() }()
+
 	offset, err := f.Seek(0, io.SeekEnd)
 	if err != nil {
 		return fmt.Errorf("seek daemon log: %w", err)
 	}
 
-	ticker := time.NewTicker(1 * time.Second)
-	defer ticker.Stop()
-
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-	defer signal.Stop(sigCh)
+	// copyNew copies f from offset to its current end.
+	copyNew := func() error {
+		info, err := f.Stat()
+		if err != nil {
+			return fmt.Errorf("stat daemon log: %w", err)
+		}
+		size := info.Size()
+		if size < offset {
+			offset = 0
+		}
+		if size <= offset {
+			return nil
+		}
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			return fmt.Errorf("seek daemon log: %w", err)
+		}
+		if _, err := io.CopyN(w, f, size-offset); err != nil && err != io.EOF {
+			return fmt.Errorf("read daemon log: %w", err)
+		}
+		offset = size
+		return nil
+	}
 
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 	for {
 		select {
-		case <-sigCh:
+		case <-stop:
 			return nil
 		case <-ticker.C:
-			info, err := f.Stat()
+			if err := copyNew(); err != nil {
+				return err
+			}
+			// Between a move and the daemon creating a new file, there is
+			// nothing at path yet: keep the old file until there is.
+			pathInfo, err := os.Stat(path)
 			if err != nil {
-				return fmt.Errorf("stat daemon log: %w", err)
+				continue
 			}
-
-			size := info.Size()
-			if size < offset {
-				offset = 0
+			fdInfo, err := f.Stat()
+			if err != nil {
+				return fmt.Errorf("stat daemon log: %w", err)
 			}
-			if size <= offset {
+			if os.SameFile(pathInfo, fdInfo) {
 				continue
 			}
-			if _, err := f.Seek(offset, io.SeekStart); err != nil {
-				return fmt.Errorf("seek daemon log: %w", err)
+			next, err := os.Open(path)
+			if err != nil {
+				continue
 			}
-			if _, err := io.CopyN(os.Stdout, f, size-offset); err != nil && err != io.EOF {
-				return fmt.Errorf("read daemon log: %w", err)
+			f.Close()
+			f, offset = next, 0
+			if err := copyNew(); err != nil {
+				return err
 			}
-			offset = size
 		}
 	}
 }
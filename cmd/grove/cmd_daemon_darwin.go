@@ -3,6 +3,7 @@
 package main
 
 import (
+	"encoding/xml"
 	"fmt"
 	"os"
 	"os/exec"
@@ -11,11 +12,18 @@ import (
 	"time"
 )
 
-const launchAgentLabel = "com.grove.daemon"
+// launchAgentLabel is the LaunchAgent of the current profile's daemon, so
+// that each profile can have one.
+func launchAgentLabel() string {
+	if p := currentTarget().Profile; p != "" && p != defaultProfile {
+		return "com.grove.daemon." + p
+	}
+	return "com.grove.daemon"
+}
 
 func launchAgentPlistPath() string {
 	home, _ := os.UserHomeDir()
-	return filepath.Join(home, "Library", "LaunchAgents", launchAgentLabel+".plist")
+	return filepath.Join(home, "Library", "LaunchAgents", launchAgentLabel()+".plist")
 }
 
 func cmdDaemonInstall() {
@@ -33,8 +41,18 @@ func cmdDaemonInstall() {
 	root := rootDir()
 	logFile := filepath.Join(root, "daemon.log")
 	socketPath := filepath.Join(root, "groved.sock")
+	if currentTarget().SocketOverride {
+		fmt.Fprintln(os.Stderr, "grove: GROVE_SOCKET is set; the LaunchAgent's daemon would listen in its root instead — unset it")
+		os.Exit(1)
+	}
 
-	plist := buildPlist(daemonBin, root, logFile, os.Getenv("PATH"))
+	envPath := os.Getenv("PATH")
+	if missing := toolsMissingFromPath(envPath, daemonTools); len(missing) > 0 {
+		fmt.Fprintf(os.Stderr, "%s⚠  %s not found on PATH%s\n", colorYellow+colorBold, strings.Join(missing, ", "), colorReset)
+		fmt.Fprintf(os.Stderr, "  The LaunchAgent runs with this shell's PATH, so groved will not find them either.\n")
+		fmt.Fprintf(os.Stderr, "  Fix PATH (e.g. add /opt/homebrew/bin) and re-run grove daemon install.\n\n")
+	}
+	plist := buildPlist(daemonBin, daemonArgs(root), logFile, envPath)
 
 	plistPath := launchAgentPlistPath()
 	if err := os.MkdirAll(filepath.Dir(plistPath), 0o755); err != nil {
@@ -45,10 +63,15 @@ func cmdDaemonInstall() {
 		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
 		os.Exit(1)
 	}
+	if err := lintPlist(plistPath); err != nil {
+		os.Remove(plistPath)
+		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+		os.Exit(1)
+	}
 
 	uid := fmt.Sprintf("%d", os.Getuid())
 	// Unload existing instance silently (ignore errors).
-	exec.Command("launchctl", "bootout", "gui/"+uid+"/"+launchAgentLabel).Run()
+	exec.Command("launchctl", "bootout", "gui/"+uid+"/"+launchAgentLabel()).Run()
 
 	// Load the new plist.
 	out, err := exec.Command("launchctl", "bootstrap", "gui/"+uid, plistPath).CombinedOutput()
@@ -78,7 +101,7 @@ func cmdDaemonInstall() {
 
 func cmdDaemonUninstall() {
 	uid := fmt.Sprintf("%d", os.Getuid())
-	exec.Command("launchctl", "bootout", "gui/"+uid+"/"+launchAgentLabel).Run()
+	exec.Command("launchctl", "bootout", "gui/"+uid+"/"+launchAgentLabel()).Run()
 
 	plistPath := launchAgentPlistPath()
 	os.Remove(plistPath)
@@ -86,26 +109,33 @@ func cmdDaemonUninstall() {
 	fmt.Printf("\n%s✓  groved LaunchAgent removed%s\n\n", colorGreen+colorBold, colorReset)
 }
 
-func cmdDaemonStatus() {
+// cmdDaemonStatus adds the current profile's LaunchAgent to the profile
+// list of grove daemon status, and with verbose the running daemon's PATH
+// and tools.
+func cmdDaemonStatus(verbose bool) {
 	plistPath := launchAgentPlistPath()
 	if _, err := os.Stat(plistPath); os.IsNotExist(err) {
-		fmt.Printf("%snot installed%s\n", colorDim, colorReset)
-		return
-	}
-
-	root := rootDir()
-	sock := filepath.Join(root, "groved.sock")
-	if pingDaemon(sock) {
-		fmt.Printf("%s✓  running%s\n\n  %splist:%s %s%s%s\n", colorGreen+colorBold, colorReset, colorDim, colorReset, colorCyan, plistPath, colorReset)
+		fmt.Printf("  %sLaunchAgent:%s %snot installed%s\n", colorDim, colorReset, colorDim, colorReset)
+	} else if pingDaemon(currentTarget().Socket) {
+		fmt.Printf("  %sLaunchAgent:%s %s✓  running%s  %s%s%s\n", colorDim, colorReset, colorGreen+colorBold, colorReset, colorCyan, plistPath, colorReset)
 	} else {
-		fmt.Printf("%s⚠  installed but not running%s\n\n  %splist:%s %s%s%s\n", colorYellow+colorBold, colorReset, colorDim, colorReset, colorCyan, plistPath, colorReset)
+		fmt.Printf("  %sLaunchAgent:%s %s⚠  installed but not running%s  %s%s%s\n", colorDim, colorReset, colorYellow+colorBold, colorReset, colorCyan, plistPath, colorReset)
+	}
+	if verbose {
+		fmt.Println()
+		printDaemonHealth()
 	}
 }
 
-// buildPlist generates the LaunchAgent plist XML.
+// buildPlist generates the LaunchAgent plist XML, running daemonBin with
+// args (see buildDaemonArgs).
 // envPath is embedded as EnvironmentVariables.PATH so the daemon inherits the
 // user's full shell PATH (launchd provides only a minimal default PATH).
-func buildPlist(daemonBin, rootDir, logFile string, envPath string) string {
+func buildPlist(daemonBin string, args []string, logFile string, envPath string) string {
+	var progArgs strings.Builder
+	for _, arg := range args {
+		fmt.Fprintf(&progArgs, "\t\t<string>%s</string>\n", xmlEscape(arg))
+	}
 	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
 <!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
 <plist version="1.0">
@@ -115,9 +145,7 @@ func buildPlist(daemonBin, rootDir, logFile string, envPath string) string {
 	<key>ProgramArguments</key>
 	<array>
 		<string>%s</string>
-		<string>--root</string>
-		<string>%s</string>
-	</array>
+%s	</array>
 	<key>EnvironmentVariables</key>
 	<dict>
 		<key>PATH</key>
@@ -136,13 +164,30 @@ func buildPlist(daemonBin, rootDir, logFile string, envPath string) string {
 	<string>%s</string>
 </dict>
 </plist>
-`, xmlEscape(launchAgentLabel), xmlEscape(daemonBin), xmlEscape(rootDir),
+`, xmlEscape(launchAgentLabel()), xmlEscape(daemonBin), progArgs.String(),
 		xmlEscape(envPath), xmlEscape(logFile), xmlEscape(logFile))
 }
 
+// xmlEscape escapes s for XML text or an attribute value with
+// xml.EscapeText: markup characters, both quotes, and characters XML cannot
+// hold at all (replaced with U+FFFD), so any path makes a plist launchd
+// can load.
 func xmlEscape(s string) string {
-	s = strings.ReplaceAll(s, "&", "&amp;")
-	s = strings.ReplaceAll(s, "<", "&lt;")
-	s = strings.ReplaceAll(s, ">", "&gt;")
-	return s
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s)) // writing to a strings.Builder cannot fail
+	return b.String()
+}
+
+// lintPlist checks the plist at path with plutil -lint, which says what is
+// wrong where launchctl bootstrap only says it failed.  Without plutil it
+// does nothing.
+func lintPlist(path string) error {
+	plutil, err := exec.LookPath("plutil")
+	if err != nil {
+		return nil
+	}
+	if out, err := exec.Command(plutil, "-lint", path).CombinedOutput(); err != nil {
+		return fmt.Errorf("generated plist is invalid: %s", strings.TrimSpace(string(out)))
+	}
+	return nil
 }
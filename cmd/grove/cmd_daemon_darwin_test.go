@@ -3,9 +3,13 @@
 package main
 
 import (
+	"encoding/xml"
+	"io"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestXmlEscape(t *testing.T) {
@@ -17,6 +21,10 @@ func TestXmlEscape(t *testing.T) {
 		{"a&b", "a&amp;b"},
 		{"<tag>", "&lt;tag&gt;"},
 		{"a<b&c>d", "a&lt;b&amp;c&gt;d"},
+		{`say "hi"`, "say &#34;hi&#34;"},
+		{"it's", "it&#39;s"},
+		{"ünïcode ☃", "ünïcode ☃"},
+		{"bell\a", "bell\uFFFD"},
 		{"", ""},
 	}
 	for _, tc := range cases {
@@ -25,7 +33,7 @@ func TestXmlEscape(t *testing.T) {
 }
 
 func TestBuildPlistContainsFields(t *testing.T) {
-	plist := buildPlist("/usr/local/bin/groved", "/home/user/.grove", "/home/user/.grove/daemon.log", "/usr/bin:/usr/local/bin")
+	plist := buildPlist("/usr/local/bin/groved", buildDaemonArgs("/home/user/.grove", nil, nil), "/home/user/.grove/daemon.log", "/usr/bin:/usr/local/bin")
 	assert.Contains(t, plist, "com.grove.daemon")
 	assert.Contains(t, plist, "/usr/local/bin/groved")
 	assert.Contains(t, plist, "/home/user/.grove")
@@ -33,8 +41,54 @@ func TestBuildPlistContainsFields(t *testing.T) {
 }
 
 func TestBuildPlistEscapesSpecialChars(t *testing.T) {
-	plist := buildPlist("/path/to/groved", "/root&dir", "/log<file>", "/usr/bin")
+	plist := buildPlist("/path/to/groved", buildDaemonArgs("/root&dir", nil, nil), "/log<file>", "/usr/bin")
 	assert.Contains(t, plist, "&amp;")
 	assert.Contains(t, plist, "&lt;")
 	assert.Contains(t, plist, "&gt;")
 }
+
+func TestBuildPlistProjectDirs(t *testing.T) {
+	plist := buildPlist("/usr/local/bin/groved", buildDaemonArgs("/home/user/.grove", []string{"/team/projects"}, []string{"--disk-warn", "20GiB"}),
+		"/home/user/.grove/daemon.log", "/usr/bin")
+	assert.Contains(t, plist, "<string>/usr/local/bin/groved</string>\n\t\t<string>--root</string>\n\t\t<string>/home/user/.grove</string>\n"+
+		"\t\t<string>--projects-dir</string>\n\t\t<string>/team/projects</string>\n"+
+		"\t\t<string>--disk-warn</string>\n\t\t<string>20GiB</string>\n\t</array>", "the arguments grove starts the daemon with")
+}
+
+// TestBuildPlistExoticPaths checks that paths survive a round trip through
+// the generated XML unchanged.
+func TestBuildPlistExoticPaths(t *testing.T) {
+	paths := []string{
+		"/Users/Jane Doe/bin/groved",
+		"/Volumes/R&D/.grove",
+		`/Users/o'brien/"quoted"/daemon.log`,
+		"/Users/zoë/プロジェクト:/opt/homebrew/bin",
+		"/team/<projects>",
+	}
+	plist := buildPlist(paths[0], buildDaemonArgs(paths[1], paths[4:], nil), paths[2], paths[3])
+
+	dec := xml.NewDecoder(strings.NewReader(plist))
+	dec.Strict = true
+	var strs []string
+	inString := false
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			inString = tok.Name.Local == "string"
+		case xml.EndElement:
+			inString = false
+		case xml.CharData:
+			if inString {
+				strs = append(strs, string(tok))
+			}
+		}
+	}
+	for _, p := range paths {
+		assert.Contains(t, strs, p)
+	}
+}
@@ -19,8 +19,11 @@ func cmdDaemonUninstall() {
 	os.Exit(1)
 }
 
-func cmdDaemonStatus() {
-	fmt.Fprintln(os.Stderr, "grove: daemon status is macOS-only (uses LaunchAgent)")
-	fmt.Fprintln(os.Stderr, "  On Linux, manage groved with systemd — see docs/TECHNICAL.md")
-	os.Exit(1)
+// cmdDaemonStatus adds to the profile list of grove daemon status.  There
+// is no LaunchAgent to report on outside macOS; with verbose it shows the
+// running daemon's PATH and tools.
+func cmdDaemonStatus(verbose bool) {
+	if verbose {
+		printDaemonHealth()
+	}
 }
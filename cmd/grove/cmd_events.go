@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+// cmdEvents handles: grove events [--json]
+//
+// Prints instance events (e.g. an agent reporting READY) as they happen
+// until interrupted.  --json prints each event as one JSON object per line.
+func cmdEvents() {
+	rawArgs, asJSON := stripBoolFlag(os.Args[2:], "json", "json")
+	if len(rawArgs) != 0 {
+		fmt.Fprintln(os.Stderr, "usage: grove events [--json]")
+		os.Exit(1)
+	}
+
+	events, err := daemonClient().Events(context.Background())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grove: %s\n", errorText(err))
+		os.Exit(1)
+	}
+	defer events.Close()
+
+	for {
+		ev, err := events.Next()
+		if err != nil {
+			break
+		}
+		if asJSON {
+			fmt.Println(string(events.Raw()))
+			continue
+		}
+		fmt.Println(formatEvent(ev))
+	}
+	fmt.Fprintln(os.Stderr, "grove: daemon closed the event stream")
+	os.Exit(1)
+}
+
+// formatEvent renders one event as a single human-readable line.
+func formatEvent(ev proto.Event) string {
+	ts := time.Unix(ev.Time, 0).Format("15:04:05")
+	line := fmt.Sprintf("%s%s%s  %s%-4s%s %s%-8s%s %s/%s",
+		colorDim, ts, colorReset,
+		colorCyan, ev.InstanceID, colorReset,
+		colorState(ev.State), ev.State, colorReset,
+		ev.Project, ev.Branch)
+	if ev.Summary != "" {
+		line += "  " + ev.Summary
+	}
+	if ev.Changes != nil && ev.Changes.Shortstat != "" {
+		line += "  " + colorDim + "(" + ev.Changes.Shortstat + ")" + colorReset
+	}
+	return line
+}
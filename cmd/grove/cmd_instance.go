@@ -2,16 +2,28 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
-	"net"
 	"os"
 	"os/exec"
+	"os/signal"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"syscall"
+	"text/template"
 	"time"
+	"unicode"
 
-	"github.com/gandalfthegui/grove/internal/proto"
+	"github.com/gandalfthegui/grove/internal/termtext"
+	"github.com/gandalfthegui/grove/pkg/client"
+	"github.com/gandalfthegui/grove/pkg/proto"
 )
 
 // stripBoolFlag removes every occurrence of the given short/long flag from
@@ -31,40 +43,168 @@ func stripBoolFlag(args []string, short, long string) ([]string, bool) {
 	return out, found
 }
 
+// stripValueFlag removes every occurrence of --name <value> / --name=<value>
+// (single or double dash) from args and returns (filtered, values). Like
+// stripBoolFlag, this lets the flag appear anywhere and be repeated.
+func stripValueFlag(args []string, name string) ([]string, []string, error) {
+	out := make([]string, 0, len(args))
+	var values []string
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if a == "-"+name || a == "--"+name {
+			if i+1 >= len(args) {
+				return nil, nil, fmt.Errorf("flag %s requires a value", a)
+			}
+			values = append(values, args[i+1])
+			i++
+			continue
+		}
+		if v, ok := strings.CutPrefix(a, "--"+name+"="); ok {
+			values = append(values, v)
+			continue
+		}
+		if v, ok := strings.CutPrefix(a, "-"+name+"="); ok {
+			values = append(values, v)
+			continue
+		}
+		out = append(out, a)
+	}
+	return out, values, nil
+}
+
+// parseArgs parses args with fs and returns the positional arguments.
+// Unlike fs.Parse it does not stop at the first positional argument, so
+// flags may follow it (`grove logs 3 -f`).  A "--" ends flag parsing:
+// everything after it is positional.  Errors are handled according to fs's
+// ErrorHandling; with ContinueOnError they are returned.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := fs.Args()
+		if consumed := len(args) - len(rest); consumed > 0 && args[consumed-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		if len(rest) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
 func cmdStart() {
+	var f startFlags
 	rawArgs, detach := stripBoolFlag(os.Args[2:], "d", "detach")
+	rawArgs, attach := stripBoolFlag(rawArgs, "attach", "attach")
+	rawArgs, showEffective := stripBoolFlag(rawArgs, "show-effective", "show-effective")
+	rawArgs, pin := stripBoolFlag(rawArgs, "pin", "pin")
+	rawArgs, dryRun := stripBoolFlag(rawArgs, "dry-run", "dry-run")
+	rawArgs, commitConfig := stripBoolFlag(rawArgs, "commit", "commit")
+	rawArgs, f.detachOnIdle = stripDetachOnIdle(rawArgs)
+	rawArgs, f.base = stripOnceFlag(rawArgs, "base")
+	rawArgs, f.agent = stripOnceFlag(rawArgs, "agent")
+	rawArgs, f.task = stripOnceFlag(rawArgs, "task")
+	rawArgs, f.ttl = stripTTL(rawArgs)
+	rawArgs, f.mode = stripMode(rawArgs)
+	rawArgs, labelArgs, err := stripValueFlag(rawArgs, "label")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+		os.Exit(1)
+	}
+	if f.labels, err = parseLabels(labelArgs); err != nil {
+		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+		os.Exit(1)
+	}
+	f.detach, f.attach = detach, attach
 	fs := flag.NewFlagSet("start", flag.ExitOnError)
 	fs.Usage = func() {
-		fmt.Fprintln(os.Stderr, "usage: grove start <project|#> <branch> [-d]")
+		fmt.Fprintln(os.Stderr, "usage: grove start <project|#> <branch> [-d | --attach | --detach-on-idle <duration>] [--base <ref>] [--agent <command>] [--task <text>] [--ttl <duration>] [--mode plan|normal|auto] [--pin] [--label key=value ...] [--commit] [--show-effective | --dry-run]")
 	}
-	fs.Parse(rawArgs)
-	args := fs.Args()
+	args, _ := parseArgs(fs, rawArgs)
 	if len(args) < 2 {
-		fmt.Fprintln(os.Stderr, "usage: grove start <project|#> <branch> [-d]")
+		fs.Usage()
 		os.Exit(1)
 	}
-	project := resolveProject(args[0])
-	branch := args[1]
-
-	agentEnv := ensureAgentCredentials(project)
-
-	socketPath := daemonSocket()
-	conn, err := net.Dial("unix", socketPath)
+	if detach && (attach || f.detachOnIdle > 0) {
+		fmt.Fprintln(os.Stderr, "grove: -d cannot be combined with --attach or --detach-on-idle")
+		os.Exit(1)
+	}
+	// The main checkout is cloned here on a first start, for its defaults.
+	info, err := resolveProjectInfo(proto.Request{Type: proto.ReqProjectResolve, Project: args[0], Checkout: true})
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
 		os.Exit(1)
 	}
+	project := info.Name
+	branch := args[1]
+	opts := mergeStartOptions(info, branch, f)
+	if showEffective {
+		fmt.Print(renderStartOptions(opts, info))
+		return
+	}
 
-	if err := writeRequest(conn, proto.Request{
-		Type:     proto.ReqStart,
-		Project:  project,
-		Branch:   branch,
-		AgentEnv: agentEnv,
-	}); err != nil {
-		conn.Close()
-		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+	req := proto.Request{
+		Type:      proto.ReqStart,
+		Project:   project,
+		Branch:    branch,
+		Base:      opts.Base,
+		Agent:     opts.Agent,
+		Task:      opts.Task,
+		Labels:    opts.Labels,
+		TTL:       int64(opts.TTL / time.Second),
+		TTLAction: opts.TTLAction,
+		Mode:      opts.Mode,
+		Pin:       pin,
+	}
+	if dryRun {
+		dryRunStart(req)
+		return
+	}
+	req.AgentEnv = ensureAgentCredentials(opts.agentCommand(info))
+	startInstance(req, opts.Detach, opts.DetachOnIdle, commitConfig)
+}
+
+// dryRunStart sends ReqStart req as a dry run and prints the daemon's
+// report, exiting 1 if any check failed.  Credentials are never asked
+// for: missing ones are reported.
+func dryRunStart(req proto.Request) {
+	req.DryRun = true
+	req.AgentEnv = shellCredentials()
+	res, _ := streamRequest(req, os.Stdout)
+	if !res.OK {
+		fmt.Fprintf(os.Stderr, "grove: %s\n", res.Error)
 		os.Exit(1)
 	}
+}
+
+// startInstance sends ReqStart req with the terminal's size, showing a
+// throbber until the daemon answers, then streams the setup output and
+// attaches unless detach is set.  If the project has no grove.yaml it
+// offers to create one, and commits it if commitConfig is set.  Exits on
+// failure.
+//
+// Ctrl-C cancels the start: the daemon stops what it is running, removes
+// what it made and answers with the stage it got to.  A second Ctrl-C
+// leaves at once, which cancels it too.
+func startInstance(req proto.Request, detach bool, detachOnIdle time.Duration, commitConfig bool) {
+	c := daemonClient()
+	req.Cols, req.Rows = terminalSize()
+	req.StartID = newStartID()
+
+	var cancelling atomic.Bool
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		for range sigs {
+			if cancelling.Swap(true) {
+				os.Exit(130)
+			}
+			c.CancelStart(context.Background(), req.StartID)
+		}
+	}()
 
 	// Show a throbber while the daemon starts the container and shell (clone, container, start commands, agent install).
 	stopThrobber := make(chan struct{})
@@ -80,150 +220,448 @@ func cmdStart() {
 				fmt.Fprint(os.Stderr, "\r  \033[K")
 				return
 			default:
-				fmt.Fprintf(os.Stderr, "\r  Starting instance %c  ", frames[i])
+				if cancelling.Load() {
+					fmt.Fprintf(os.Stderr, "\r  Cancelling the start %c\033[K", frames[i])
+				} else {
+					fmt.Fprintf(os.Stderr, "\r  Starting instance %c  %s(start %s; Ctrl-C cancels)%s", frames[i], colorDim, req.StartID, colorReset)
+				}
 				i = (i + 1) % len(frames)
 				time.Sleep(120 * time.Millisecond)
 			}
 		}
 	}()
 
-	resp, err := readResponse(conn)
+	resp, setup, err := c.Open(context.Background(), req)
 	close(stopThrobber)
 	<-throbberDone
+	signal.Stop(sigs)
+	close(sigs)
 	if err != nil {
-		conn.Close()
-		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
-		os.Exit(1)
-	}
-	if !resp.OK {
-		conn.Close()
-		if resp.InitPath != "" {
+		var refused *client.Error
+		if !errors.As(err, &refused) {
+			fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+			os.Exit(1)
+		}
+		if resp.ErrorCode == proto.ErrCodeConfigMissing || resp.InitPath != "" {
 			// Project exists but has no grove.yaml — prompt the user to create one.
-			promptCreateProjectConfig(resp.InitPath, project)
+			promptCreateProjectConfig(resp, req.Project, commitConfig)
 			os.Exit(1)
 		}
-		fmt.Fprintf(os.Stderr, "grove: %s\n", resp.Error)
-		fmt.Fprintf(os.Stderr, "grove: check daemon logs with: grove daemon logs -n 100\n")
+		fmt.Fprintf(os.Stderr, "grove: %s\n", errorText(err))
+		if resp.Hint == "" && resp.ErrorCode != proto.ErrCodeStartCancelled {
+			fmt.Fprintf(os.Stderr, "grove: check daemon logs with: grove daemon logs -n 100\n")
+		}
 		os.Exit(1)
 	}
 
 	// Stream any setup output (clone, pull, bootstrap) the daemon buffered.
-	io.Copy(os.Stdout, conn)
-	conn.Close()
+	io.Copy(&pipeWriter{w: os.Stdout}, setup)
+	setup.Close()
+
+	printResult(startedResult(resp.InstanceID))
+	rememberInstance(resp.InstanceID)
+
+	if !detach && !porcelain { // --porcelain is for scripts: imply -d
+		doAttach(resp.InstanceID, detachOnIdle)
+	}
+}
 
-	fmt.Printf("\n%s✓  Started instance%s %s%s%s\n\n", colorGreen+colorBold, colorReset, colorCyan, resp.InstanceID, colorReset)
+// newStartID returns a start ID for ReqStart, which grove cancel-start
+// takes to cancel the start.
+func newStartID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
 
-	if !detach {
-		doAttach(resp.InstanceID)
+// cmdCancelStart asks the daemon to cancel a start under way, named by the
+// start ID its grove start shows.  That grove start reports the outcome.
+func cmdCancelStart() {
+	if len(os.Args) != 3 {
+		fmt.Fprintln(os.Stderr, "usage: grove cancel-start <start-id>")
+		os.Exit(1)
 	}
+	mustRequest(proto.Request{Type: proto.ReqCancelStart, StartID: os.Args[2]})
+	fmt.Printf("Cancelling start %s; its grove start reports once what it made is removed.\n", os.Args[2])
 }
 
 func cmdList() {
+	rawArgs, selector := labelSelector(os.Args[2:])
 	fs := flag.NewFlagSet("list", flag.ExitOnError)
 	activeOnly := fs.Bool("active", false, "show only active instances (exclude FINISHED)")
+	output := fs.String("o", "", "output format: wide adds the time to live, remote status, image, base commit, labels and the most recent note")
+	format := fs.String("format", "", "print each instance with a Go template, e.g. '{{.ID}}\\t{{.State}}'")
+	sortKey := fs.String("sort", "created", "order by created, uptime, project or state")
+	reverse := fs.Bool("reverse", false, "reverse the order")
+	absolute := fs.Bool("absolute", false, "show creation times as RFC 3339 rather than e.g. \"2h ago\"")
 	fs.Usage = func() {
-		fmt.Fprintln(os.Stderr, "usage: grove list [--active]")
+		fmt.Fprintln(os.Stderr, "usage: grove list [--active] [-o wide | --format <template>] [--sort created|uptime|project|state] [--reverse] [--absolute] [--label key=value ...]")
+	}
+	if args, _ := parseArgs(fs, rawArgs); len(args) != 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if *output != "" && *output != "wide" {
+		fmt.Fprintf(os.Stderr, "grove: unknown output format %q (want: wide)\n", *output)
+		os.Exit(1)
+	}
+	if *output != "" && *format != "" {
+		fmt.Fprintln(os.Stderr, "grove: -o and --format cannot be combined")
+		os.Exit(1)
+	}
+	wide := *output == "wide"
+	now := time.Now()
+	less, err := listLess(*sortKey, now)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+		os.Exit(1)
+	}
+	var tmpl *template.Template
+	if *format != "" {
+		if tmpl, err = parseListFormat(*format, now); err != nil {
+			fmt.Fprintf(os.Stderr, "grove: %v\n", listFormatError(err))
+			os.Exit(1)
+		}
 	}
-	fs.Parse(os.Args[2:])
 
-	resp := mustRequest(proto.Request{Type: proto.ReqList})
+	resp := mustRequest(proto.Request{Type: proto.ReqList, MainAhead: wide})
 
 	var instances []proto.InstanceInfo
 	for _, inst := range resp.Instances {
 		if *activeOnly && inst.State == proto.StateFinished {
 			continue
 		}
+		if !matchLabels(inst.Labels, selector) {
+			continue
+		}
 		instances = append(instances, inst)
 	}
+	sortInstances(instances, less, *reverse)
+
+	if tmpl != nil {
+		// No header and no "no instances": the output is for scripts.
+		out, err := renderListFormat(tmpl, instances)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "grove: %v\n", listFormatError(err))
+			os.Exit(1)
+		}
+		fmt.Print(out)
+		return
+	}
 
 	if len(instances) == 0 {
 		fmt.Printf("%sno instances%s\n", colorDim, colorReset)
 		return
 	}
 
-	fmt.Printf("%s%-10s  %-12s  %-10s  %s%s\n", colorBold, "ID", "PROJECT", "STATE", "BRANCH", colorReset)
-	fmt.Printf("%s%-10s  %-12s  %-10s  %s%s\n", colorDim, "----------", "------------", "----------", "------", colorReset)
+	createdW := 8
+	if *absolute {
+		createdW = len(time.RFC3339) // as long as any time it formats
+	}
+	if wide {
+		fmt.Printf("%s%-10s  %-12s  %-10s  %-8s  %-6s  %-*s  %-8s  %-16s  %-32s  %-12s  %s%s\n", colorBold, "ID", "PROJECT", "STATE", "IN-STATE", "MODE", createdW, "CREATED", "TTL", "REMOTE", "IMAGE", "BASE", "BRANCH", colorReset)
+		fmt.Printf("%s%-10s  %-12s  %-10s  %-8s  %-6s  %s  %-8s  %-16s  %-32s  %-12s  %s%s\n", colorDim, "----------", "------------", "----------", "--------", "------", strings.Repeat("-", createdW), "--------", "----------------", "--------------------------------", "------------", "------", colorReset)
+	} else {
+		fmt.Printf("%s%-10s  %-12s  %-10s  %-8s  %-6s  %-*s  %s%s\n", colorBold, "ID", "PROJECT", "STATE", "IN-STATE", "MODE", createdW, "CREATED", "BRANCH", colorReset)
+		fmt.Printf("%s%-10s  %-12s  %-10s  %-8s  %-6s  %s  %s%s\n", colorDim, "----------", "------------", "----------", "--------", "------", strings.Repeat("-", createdW), "------", colorReset)
+	}
+	drifted := false
 	for _, inst := range instances {
 		color := colorState(inst.State)
 		reset := ""
 		if color != "" {
 			reset = "\033[0m"
 		}
-		fmt.Printf("%-10s  %-12s  %s%-10s%s  %s\n", inst.ID, inst.Project, color, inst.State, reset, inst.Branch)
+		id := inst.ID
+		if inst.ImageDrift != "" {
+			id += "*"
+			drifted = true
+		}
+		fmt.Printf("%-10s  %-12s  %s%-10s%s  %-8s  %s  %-*s  ", id, inst.Project, color, inst.State, reset, formatInState(inst, now),
+			formatMode(inst), createdW, formatCreated(inst, now, *absolute))
+		if wide {
+			fmt.Printf("%-8s  %-16s  %-32s  %-12s  ", formatTTL(inst, now), formatRemote(inst.Remote, now), formatImage(inst.Image), formatBase(inst))
+		}
+		fmt.Print(inst.Branch)
+		if inst.StackStopped {
+			fmt.Printf("  %s(stack stopped)%s", colorDim, colorReset)
+		}
+		if removed := formatRemoved(inst); removed != "" {
+			fmt.Printf("  %s(%s)%s", colorYellow, removed, colorReset)
+		}
+		if inst.Pinned {
+			fmt.Print("  " + pinMarker)
+		}
+		if wide && len(inst.Labels) > 0 {
+			fmt.Printf("  %s", strings.Join(formatLabels(inst.Labels), ","))
+		}
+		if wide && len(inst.Notes) > 0 {
+			fmt.Printf("  %s%s%s", colorDim, inst.Notes[len(inst.Notes)-1].Text, colorReset)
+		}
+		fmt.Println()
+	}
+	if drifted {
+		fmt.Printf("\n%s%s%s\n", colorDim, imageDriftFootnote, colorReset)
+	}
+	if note := brokenFootnote(instances); note != "" {
+		fmt.Printf("\n%s%s%s\n", colorRed, note, colorReset)
+	}
+}
+
+// formatRemoved says what grove container rm and grove worktree rm have
+// removed of inst, for grove list, or returns "".
+func formatRemoved(inst proto.InstanceInfo) string {
+	switch {
+	case inst.ContainerRemoved && inst.WorktreeRemoved:
+		return "container and worktree removed"
+	case inst.ContainerRemoved:
+		return "container removed"
+	case inst.WorktreeRemoved:
+		return "worktree removed"
+	}
+	return ""
+}
+
+// brokenFootnote tells grove list and grove watch users what to do about
+// BROKEN instances, or returns "" if there are none.
+func brokenFootnote(instances []proto.InstanceInfo) string {
+	var ids []string
+	for _, inst := range instances {
+		if inst.State == proto.StateBroken {
+			ids = append(ids, inst.ID)
+		}
+	}
+	switch len(ids) {
+	case 0:
+		return ""
+	case 1:
+		return fmt.Sprintf("BROKEN: the worktree of %s was deleted; grove drop %s removes the instance", ids[0], ids[0])
+	}
+	return fmt.Sprintf("BROKEN: the worktrees of %s were deleted; grove drop <id> removes each instance", strings.Join(ids, ", "))
+}
+
+// imageDriftFootnote explains the * grove list puts after an instance whose
+// container runs another image than its project's config names.
+const imageDriftFootnote = "* the container runs an older image than the project's config names; " +
+	"grove restart <id> --recreate-container (once stopped) rebuilds it, keeping the worktree"
+
+// formatImage renders an instance's container image for grove list -o wide
+// and grove status: the reference and, if known, the short digest.
+func formatImage(img *proto.ContainerImage) string {
+	if img == nil {
+		return "-"
+	}
+	if d := img.ShortDigest(); d != "" {
+		return img.Ref + " (" + d + ")"
+	}
+	return img.Ref
+}
+
+// formatBase renders the commit an instance's branch started from for grove
+// list -o wide: its short SHA and, if the main checkout has moved on since,
+// by how many commits, e.g. "3f2a9c1 +4".
+func formatBase(inst proto.InstanceInfo) string {
+	switch {
+	case inst.BaseSHA == "":
+		return "-"
+	case inst.MainAhead > 0:
+		return fmt.Sprintf("%s +%d", inst.ShortBaseSHA(), inst.MainAhead)
+	}
+	return inst.ShortBaseSHA()
+}
+
+// formatTmpfs renders container.tmpfs mounts for grove status, e.g.
+// "/tmp (size=1g)".
+func formatTmpfs(tmpfs []string) string {
+	out := make([]string, len(tmpfs))
+	for i, t := range tmpfs {
+		dir, opts, _ := strings.Cut(t, ":")
+		out[i] = dir
+		if opts != "" {
+			out[i] += " (" + opts + ")"
+		}
 	}
+	return strings.Join(out, ", ")
 }
 
+// cmdStop handles: grove stop <instance-id> [--stack] | --all [--stack] [--label key=value ...]
+//
+// Kills the agent; the container keeps running for restart.  --stack also
+// stops a compose instance's services, which restart starts again.
 func cmdStop() {
-	if len(os.Args) < 3 {
-		fmt.Fprintln(os.Stderr, "usage: grove stop <instance-id>")
+	rawArgs, selector := labelSelector(os.Args[2:])
+	rawArgs, all := stripBoolFlag(rawArgs, "all", "all")
+	rawArgs, stack := stripBoolFlag(rawArgs, "stack", "stack")
+	if all {
+		if len(rawArgs) != 0 {
+			fmt.Fprintln(os.Stderr, "usage: grove stop --all [--stack] [--label key=value ...]")
+			os.Exit(1)
+		}
+		stopAll(selector, stack)
+		return
+	}
+	if len(rawArgs) < 1 || len(selector) > 0 {
+		fmt.Fprintln(os.Stderr, "usage: grove stop <instance-id> [--stack] | --all [--stack] [--label key=value ...]")
 		os.Exit(1)
 	}
-	instanceID := os.Args[2]
+	instanceID := resolveInstanceArg(rawArgs[0])
 
 	mustRequest(proto.Request{
 		Type:       proto.ReqStop,
 		InstanceID: instanceID,
+		StopStack:  stack,
 	})
 
-	fmt.Printf("\n%s✓  Stopped%s %s%s%s\n\n", colorGreen+colorBold, colorReset, colorCyan, instanceID, colorReset)
+	printResult(stoppedResult(instanceID))
 }
 
+// stopAll stops every live instance whose labels match selector, but for
+// pinned ones, and with stack the services of those that run a compose
+// stack.
+func stopAll(selector map[string]string, stack bool) {
+	resp := mustRequest(proto.Request{Type: proto.ReqList})
+
+	stopped, pinned := 0, 0
+	for _, inst := range resp.Instances {
+		if proto.IsTerminal(inst.State) || !matchLabels(inst.Labels, selector) {
+			continue
+		}
+		if inst.Pinned {
+			pinned++
+			continue
+		}
+		mustRequest(proto.Request{Type: proto.ReqStop, InstanceID: inst.ID, StopStack: stack && inst.ComposeProject != ""})
+		printResultItem(stoppedResult(inst.ID))
+		stopped++
+	}
+	if stopped == 0 {
+		fmt.Printf("%snothing to stop%s\n", colorDim, colorReset)
+	}
+	skippedPinned(pinned)
+}
+
+// cmdRestart handles: grove restart <instance-id> [-d] [--fresh] [--current-config] [--recreate-container]
+//
+// Relaunches the agent of a stopped instance in its container.
+// --recreate-container first replaces the container with one from the
+// project's current config (e.g. a new image), keeping the worktree.
 func cmdRestart() {
+	const usage = "usage: grove restart <instance-id> [-d] [--fresh] [--current-config] [--recreate-container]"
 	rawArgs, detach := stripBoolFlag(os.Args[2:], "d", "detach")
+	rawArgs, fresh := stripBoolFlag(rawArgs, "fresh", "fresh")
+	rawArgs, currentConfig := stripBoolFlag(rawArgs, "current-config", "current-config")
+	rawArgs, recreate := stripBoolFlag(rawArgs, "recreate-container", "recreate-container")
 	fs := flag.NewFlagSet("restart", flag.ExitOnError)
 	fs.Usage = func() {
-		fmt.Fprintln(os.Stderr, "usage: grove restart <instance-id> [-d]")
+		fmt.Fprintln(os.Stderr, usage)
 	}
-	fs.Parse(rawArgs)
-	args := fs.Args()
+	args, _ := parseArgs(fs, rawArgs)
 	if len(args) < 1 {
-		fmt.Fprintln(os.Stderr, "usage: grove restart <instance-id> [-d]")
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(1)
+	}
+	inst := resolveInstance(args[0])
+	instanceID := inst.ID
+	agentEnv := ensureAgentCredentials(instanceAgentCommand(inst))
+
+	c := daemonClient()
+	cols, rows := terminalSize()
+	if recreate {
+		fmt.Fprintf(os.Stderr, "%sRecreating the container of %s …%s\n", colorDim, instanceID, colorReset)
+	}
+	_, setup, err := c.Open(context.Background(), proto.Request{
+		Type:              proto.ReqRestart,
+		InstanceID:        instanceID,
+		AgentEnv:          agentEnv,
+		Cols:              cols,
+		Rows:              rows,
+		Fresh:             fresh,
+		CurrentConfig:     currentConfig,
+		RecreateContainer: recreate,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grove: %s\n", errorText(err))
 		os.Exit(1)
 	}
-	instanceID := args[0]
 
-	var agentEnv map[string]string
-	if inst := findInstance(instanceID); inst != nil {
-		agentEnv = ensureAgentCredentials(inst.Project)
+	// Stream any setup output from recreating the container.
+	io.Copy(&pipeWriter{w: os.Stdout}, setup)
+	setup.Close()
+
+	printResult(restartedResult(instanceID))
+	rememberInstance(instanceID)
+
+	if !detach && !porcelain {
+		doAttach(instanceID, 0)
 	}
+}
 
-	mustRequest(proto.Request{
-		Type:       proto.ReqRestart,
-		InstanceID: instanceID,
-		AgentEnv:   agentEnv,
+// cmdReopen handles: grove reopen <instance-id> [-d] [--fresh] [--current-config]
+//
+// Picks a FINISHED instance back up: the daemon brings its container back if
+// needed and relaunches the agent (resuming its session unless --fresh).
+// Both use the config the instance was started with unless --current-config.
+func cmdReopen() {
+	rawArgs, detach := stripBoolFlag(os.Args[2:], "d", "detach")
+	rawArgs, fresh := stripBoolFlag(rawArgs, "fresh", "fresh")
+	rawArgs, currentConfig := stripBoolFlag(rawArgs, "current-config", "current-config")
+	if len(rawArgs) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: grove reopen <instance-id> [-d] [--fresh] [--current-config]")
+		os.Exit(1)
+	}
+	inst := resolveInstance(rawArgs[0])
+	instanceID := inst.ID
+	agentEnv := ensureAgentCredentials(instanceAgentCommand(inst))
+
+	c := daemonClient()
+	cols, rows := terminalSize()
+	fmt.Fprintf(os.Stderr, "%sReopening %s …%s\n", colorDim, instanceID, colorReset)
+	_, setup, err := c.Open(context.Background(), proto.Request{
+		Type:          proto.ReqReopen,
+		InstanceID:    instanceID,
+		AgentEnv:      agentEnv,
+		Cols:          cols,
+		Rows:          rows,
+		Fresh:         fresh,
+		CurrentConfig: currentConfig,
 	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grove: %s\n", errorText(err))
+		os.Exit(1)
+	}
+
+	// Stream any setup output from recreating the container.
+	io.Copy(&pipeWriter{w: os.Stdout}, setup)
+	setup.Close()
 
-	fmt.Printf("\n%s✓  Restarted%s %s%s%s\n\n", colorGreen+colorBold, colorReset, colorCyan, instanceID, colorReset)
+	printResult(reopenedResult(instanceID))
 
-	if !detach {
-		doAttach(instanceID)
+	if !detach && !porcelain {
+		doAttach(instanceID, 0)
 	}
 }
 
 func cmdDrop() {
+	const usage = "usage: grove drop <instance-id> [-f] [--keep-worktree] [--keep-container]"
 	rawArgs, force := stripBoolFlag(os.Args[2:], "f", "force")
+	rawArgs, keepWorktree := stripBoolFlag(rawArgs, "keep-worktree", "keep-worktree")
+	rawArgs, keepContainer := stripBoolFlag(rawArgs, "keep-container", "keep-container")
 	fs := flag.NewFlagSet("drop", flag.ExitOnError)
-	fs.Usage = func() { fmt.Fprintln(os.Stderr, "usage: grove drop <instance-id> [-f]") }
-	fs.Parse(rawArgs)
-	args := fs.Args()
+	fs.Usage = func() { fmt.Fprintln(os.Stderr, usage) }
+	args, _ := parseArgs(fs, rawArgs)
 	if len(args) < 1 {
-		fmt.Fprintln(os.Stderr, "usage: grove drop <instance-id> [-f]")
-		os.Exit(1)
-	}
-	instanceID := args[0]
-
-	found := findInstance(instanceID)
-	if found == nil {
-		fmt.Fprintf(os.Stderr, "grove: instance not found: %s\n", instanceID)
+		fmt.Fprintln(os.Stderr, usage)
 		os.Exit(1)
 	}
+	found := resolveInstance(args[0])
+	instanceID := found.ID
 
 	if !force {
 		fmt.Printf("\n%sInstance%s %s%s%s\n\n", colorBold, colorReset, colorCyan, instanceID, colorReset)
 		fmt.Printf("  %sProject:%s  %s%s%s\n", colorDim, colorReset, colorCyan, found.Project, colorReset)
 		fmt.Printf("  %sWorktree:%s %s%s%s\n", colorDim, colorReset, colorCyan, found.WorktreeDir, colorReset)
 		fmt.Printf("  %sBranch:%s   %s%s%s\n\n", colorDim, colorReset, colorCyan, found.Branch, colorReset)
-		fmt.Printf("%sDelete instance %q and worktree?%s [y/N] ", colorBold, found.Project, colorReset)
+		fmt.Printf("%s%s?%s [y/N] ", colorBold, dropQuestion(found.Project, keepWorktree || found.WorktreeRemoved, keepContainer || found.ContainerRemoved), colorReset)
 
 		reader := bufio.NewReader(os.Stdin)
 		answer, _ := reader.ReadString('\n')
@@ -232,41 +670,443 @@ func cmdDrop() {
 			fmt.Printf("%saborted%s\n", colorDim, colorReset)
 			return
 		}
+		if found.Pinned {
+			fmt.Printf("%s%s Instance %s is pinned. Drop it anyway?%s [y/N] ", colorYellow+colorBold, pinMarker, instanceID, colorReset)
+			answer, _ = reader.ReadString('\n')
+			answer = strings.TrimSpace(answer)
+			if answer != "y" && answer != "Y" {
+				fmt.Printf("%saborted%s\n", colorDim, colorReset)
+				return
+			}
+		}
 	}
 
-	mustRequest(proto.Request{
-		Type:       proto.ReqDrop,
-		InstanceID: instanceID,
+	resp := mustRequest(proto.Request{
+		Type:          proto.ReqDrop,
+		InstanceID:    instanceID,
+		KeepWorktree:  keepWorktree,
+		KeepContainer: keepContainer,
 	})
-	fmt.Printf("\n%s✓  Dropped%s %s%s%s\n\n", colorGreen+colorBold, colorReset, colorCyan, instanceID, colorReset)
+	printResult(droppedResult(instanceID))
+	if printResidue(resp.Residue) {
+		defer os.Exit(1)
+	}
+	if porcelain {
+		return
+	}
+	if keepWorktree && !found.WorktreeRemoved {
+		fmt.Printf("  %sKept worktree %s (branch %s)%s\n", colorDim, found.WorktreeDir, found.Branch, colorReset)
+	}
+	switch {
+	case !keepContainer || found.ContainerRemoved:
+	case found.ComposeProject != "":
+		fmt.Printf("  %sKept compose project %s; docker compose -p %s down removes it%s\n", colorDim, found.ComposeProject, found.ComposeProject, colorReset)
+	case found.ContainerID != "":
+		fmt.Printf("  %sKept container %s; docker rm -f %s removes it%s\n", colorDim, found.ContainerID, found.ContainerID, colorReset)
+	}
+}
+
+// printResidue prints to stderr what dropping an instance left behind,
+// reporting whether there was any.
+func printResidue(residue []string) bool {
+	for _, left := range residue {
+		fmt.Fprintf(os.Stderr, "%swarning: %s%s\n", colorYellow, left, colorReset)
+	}
+	return len(residue) > 0
+}
+
+// dropQuestion is grove drop's confirmation question for an instance of
+// project, naming what goes with it.
+func dropQuestion(project string, keepWorktree, keepContainer bool) string {
+	var parts []string
+	if !keepWorktree {
+		parts = append(parts, "worktree")
+	}
+	if !keepContainer {
+		parts = append(parts, "container")
+	}
+	if len(parts) == 0 {
+		return fmt.Sprintf("Delete instance %q", project)
+	}
+	return fmt.Sprintf("Delete instance %q and its %s", project, strings.Join(parts, " and "))
 }
 
 func cmdFinish() {
-	if len(os.Args) < 3 {
-		fmt.Fprintln(os.Stderr, "usage: grove finish <instance-id>")
+	fs := flag.NewFlagSet("finish", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the result as JSON")
+	currentConfig := fs.Bool("current-config", false, "run the finish steps the project's config has now")
+	fs.Usage = func() { fmt.Fprintln(os.Stderr, "usage: grove finish <instance-id> [--json] [--current-config]") }
+	args, _ := parseArgs(fs, os.Args[2:])
+	if len(args) != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	streamCommand(proto.Request{
+		Type:          proto.ReqFinish,
+		InstanceID:    resolveInstanceArg(args[0]),
+		CurrentConfig: *currentConfig,
+	}, "finished", *asJSON)
+}
+
+// cmdNote handles: grove note <instance-id> ["text" | --clear]
+//
+// With text, appends a timestamped note to the instance. With --clear,
+// removes all notes. With neither, prints the existing notes.
+func cmdNote() {
+	rawArgs, clear := stripBoolFlag(os.Args[2:], "clear", "clear")
+	if len(rawArgs) < 1 {
+		fmt.Fprintln(os.Stderr, "usage: grove note <instance-id> [\"text\" | --clear]")
+		os.Exit(1)
+	}
+	inst := resolveInstance(rawArgs[0])
+	instanceID := inst.ID
+	text := strings.TrimSpace(strings.Join(rawArgs[1:], " "))
+
+	if clear {
+		mustRequest(proto.Request{Type: proto.ReqNote, InstanceID: instanceID, Clear: true})
+		printResult(notesClearedResult(instanceID))
+		return
+	}
+
+	if text == "" {
+		if len(inst.Notes) == 0 {
+			fmt.Printf("%sno notes%s\n", colorDim, colorReset)
+			return
+		}
+		printNotes(inst.Notes)
+		return
+	}
+
+	mustRequest(proto.Request{Type: proto.ReqNote, InstanceID: instanceID, Text: text})
+	printResult(notedResult(instanceID))
+}
+
+// printNotes writes notes oldest-first, each prefixed with its timestamp.
+func printNotes(notes []proto.Note) {
+	for _, n := range notes {
+		ts := time.Unix(n.Time, 0).Format("2006-01-02 15:04")
+		fmt.Printf("  %s%s%s  %s\n", colorDim, ts, colorReset, n.Text)
+	}
+}
+
+// cmdStatus handles: grove status <instance-id> [--json]
+//
+// Prints everything the daemon knows about a single instance; with --json,
+// its record as the daemon returns it.
+func cmdStatus() {
+	rawArgs, asJSON := stripBoolFlag(os.Args[2:], "json", "json")
+	rawArgs, showEnv := stripBoolFlag(rawArgs, "env", "env")
+	rawArgs, measure := stripBoolFlag(rawArgs, "usage", "usage")
+	if len(rawArgs) < 1 {
+		fmt.Fprintln(os.Stderr, "usage: grove status <instance-id> [--json] [--env] [--usage]")
+		os.Exit(1)
+	}
+	instanceID := resolveInstanceArg(rawArgs[0])
+
+	inst := findListed(mustRequest(proto.Request{Type: proto.ReqList, InstanceID: instanceID, MainAhead: true}), instanceID)
+	if inst == nil {
+		fmt.Fprintf(os.Stderr, "grove: instance not found: %s\n", instanceID)
 		os.Exit(1)
 	}
-	streamCommand(proto.ReqFinish, os.Args[2])
+	if measure {
+		// Measure the agent's usage now rather than show the last measurement.
+		resp := mustRequest(proto.Request{Type: proto.ReqUsage, InstanceID: inst.ID})
+		if len(resp.Instances) == 1 {
+			inst = &resp.Instances[0]
+		}
+	}
+	if asJSON {
+		data, _ := json.MarshalIndent(inst, "", "  ")
+		fmt.Println(string(data))
+		return
+	}
+
+	color := colorState(inst.State)
+
+	fmt.Printf("\n%sInstance%s %s%s%s\n\n", colorBold, colorReset, colorCyan, inst.ID, colorReset)
+	fmt.Printf("  %sProject:%s   %s\n", colorDim, colorReset, inst.Project)
+	fmt.Printf("  %sBranch:%s    %s\n", colorDim, colorReset, inst.Branch)
+	if inst.BaseSHA != "" {
+		fmt.Printf("  %sBase:%s      %s", colorDim, colorReset, inst.BaseSHA)
+		if inst.MainAhead > 0 {
+			fmt.Printf(" %s(the main checkout has %d commit(s) since)%s", colorDim, inst.MainAhead, colorReset)
+		}
+		fmt.Println()
+	}
+	fmt.Printf("  %sState:%s     %s%s%s", colorDim, colorReset, color, inst.State, colorReset)
+	if inst.StateSince > 0 {
+		fmt.Printf(" %s(for %s)%s", colorDim, formatInState(*inst, time.Now()), colorReset)
+	}
+	fmt.Println()
+	fmt.Printf("  %sUptime:%s    %s\n", colorDim, colorReset, formatUptime(instanceUptime(*inst, time.Now())))
+	if inst.ExpiresAt != 0 {
+		left := formatTTL(*inst, time.Now())
+		if left != "expired" {
+			left = "in " + left
+		}
+		fmt.Printf("  %sExpires:%s   %s%s, then %s%s %s(at %s; grove ttl %s <duration> changes it)%s\n", colorDim, colorReset,
+			colorYellow, left, ttlAction(*inst), colorReset,
+			colorDim, time.Unix(inst.ExpiresAt, 0).Format("2006-01-02 15:04"), inst.ID, colorReset)
+	}
+	fmt.Printf("  %sWorktree:%s  %s", colorDim, colorReset, inst.WorktreeDir)
+	if inst.WorktreeRemoved {
+		fmt.Printf(" %s(removed; branch kept, reopen or restart --recreate-container checks it out again)%s", colorYellow, colorReset)
+	}
+	fmt.Println()
+	if inst.ContainerID != "" {
+		fmt.Printf("  %sContainer:%s %s", colorDim, colorReset, inst.ContainerID)
+		if inst.ContainerRemoved {
+			fmt.Printf(" %s(removed; reopen or restart --recreate-container creates it again)%s", colorYellow, colorReset)
+		}
+		fmt.Println()
+	}
+	if inst.ComposeProject != "" {
+		fmt.Printf("  %sStack:%s     %s", colorDim, colorReset, inst.ComposeProject)
+		if inst.StackStopped {
+			fmt.Printf(" %s(stopped; grove restart starts it)%s", colorYellow, colorReset)
+		}
+		fmt.Println()
+	}
+	if img := inst.Image; img != nil {
+		fmt.Printf("  %sImage:%s     %s", colorDim, colorReset, formatImage(img))
+		if img.Platform != "" {
+			fmt.Printf(" %s%s%s", colorDim, img.Platform, colorReset)
+		}
+		fmt.Println()
+		if img.HostPlatform != "" {
+			fmt.Printf("  %s⚠  runs under emulation on this %s host — expect 3–10x slowdown%s\n",
+				colorYellow, img.HostPlatform, colorReset)
+		}
+	}
+	if inst.ImageDrift != "" {
+		fmt.Printf("  %s%s is configured now; grove restart %s --recreate-container (once stopped) moves to it%s\n",
+			colorYellow, inst.ImageDrift, inst.ID, colorReset)
+	}
+	if l := inst.Launch; l != nil {
+		if len(l.Tmpfs) > 0 {
+			fmt.Printf("  %sTmpfs:%s     %s\n", colorDim, colorReset, formatTmpfs(l.Tmpfs))
+		}
+		if len(l.Scratch) > 0 {
+			fmt.Printf("  %sScratch:%s   %s %s(volumes, removed with the container)%s\n", colorDim, colorReset,
+				strings.Join(l.Scratch, ", "), colorDim, colorReset)
+		}
+	}
+	if inst.Task != "" {
+		fmt.Printf("  %sTask:%s      %s\n", colorDim, colorReset, inst.Task)
+	}
+	if inst.Mode != "" {
+		fmt.Printf("  %sMode:%s      %s\n", colorDim, colorReset, formatMode(*inst))
+	}
+	if inst.Pinned {
+		fmt.Printf("  %sPinned:%s    %s left out of prune, stop --all and its time to live\n", colorDim, colorReset, pinMarker)
+	}
+	if inst.Summary != "" {
+		fmt.Printf("  %sSummary:%s   %s\n", colorDim, colorReset, inst.Summary)
+	}
+	if inst.Remote != nil {
+		fmt.Printf("  %sRemote:%s    %s %s(checked %s)%s\n", colorDim, colorReset,
+			formatRemote(inst.Remote, time.Now()),
+			colorDim, time.Unix(inst.Remote.CheckedAt, 0).Format("2006-01-02 15:04"), colorReset)
+	}
+	if pf := inst.PendingFinish; pf != nil {
+		how := ""
+		if pf.Resumed {
+			how = ", resumed after a daemon restart"
+		}
+		fmt.Printf("  %sFinishing:%s %s%d command(s) left: %s%s %s(since %s%s)%s\n", colorDim, colorReset,
+			colorYellow, len(pf.Remaining), strings.Join(pf.Remaining, ", "), colorReset,
+			colorDim, time.Unix(pf.Started, 0).Format("2006-01-02 15:04"), how, colorReset)
+	}
+	if lf := inst.LastFinish; lf != nil {
+		color := colorGreen
+		if !lf.Result.OK {
+			color = colorRed
+		}
+		fmt.Printf("  %sFinished:%s  %s%s%s %s(%s)%s\n", colorDim, colorReset,
+			color, resultSummary(lf.Result), colorReset,
+			colorDim, time.Unix(lf.Time, 0).Format("2006-01-02 15:04"), colorReset)
+	}
+	if lc := inst.LastCheck; lc != nil {
+		color, how := colorGreen, ""
+		if !lc.Result.OK {
+			color = colorRed
+		}
+		if lc.Auto {
+			how = ", automatic"
+		}
+		fmt.Printf("  %sChecked:%s   %s%s%s %s(%s%s)%s\n", colorDim, colorReset,
+			color, resultSummary(lc.Result), colorReset,
+			colorDim, time.Unix(lc.Time, 0).Format("2006-01-02 15:04"), how, colorReset)
+	}
+	if u := inst.Usage; u != nil {
+		fmt.Printf("  %sUsage:%s     %s %s(measured %s)%s\n", colorDim, colorReset,
+			formatUsage(u), colorDim, time.Unix(u.Time, 0).Format("2006-01-02 15:04"), colorReset)
+	}
+	if len(inst.StartTimings) > 0 {
+		fmt.Println()
+		fmt.Print(renderStartTimings(inst.StartTimings))
+	}
+	if len(inst.Agents) > 0 {
+		fmt.Printf("\n  %sAgents:%s\n", colorDim, colorReset)
+		fmt.Print(renderHelperAgents(inst.Agents, time.Now()))
+	}
+	if len(inst.Runs) > 0 {
+		fmt.Printf("\n  %sRuns:%s\n", colorDim, colorReset)
+		for _, r := range inst.Runs {
+			ts := time.Unix(r.Time, 0).Format("2006-01-02 15:04")
+			how := "fresh"
+			if r.Resumed {
+				how = "resumed"
+			}
+			fmt.Printf("  %s%s%s  %-7s  %s\n", colorDim, ts, colorReset, how, strings.Join(r.Argv, " "))
+		}
+	}
+	if len(inst.Notes) > 0 {
+		fmt.Printf("\n  %sNotes:%s\n", colorDim, colorReset)
+		printNotes(inst.Notes)
+	}
+	if showEnv {
+		fmt.Println()
+		if inst.Environment == nil {
+			fmt.Printf("  %sNo launch recorded yet.%s\n", colorDim, colorReset)
+		} else {
+			fmt.Print(renderEnvironment(inst.Environment))
+		}
+	}
+	fmt.Println()
+}
+
+// formatUsage renders an agent's usage: its cost, or why it is unknown.
+func formatUsage(u *proto.AgentUsage) string {
+	if !u.Known {
+		return fmt.Sprintf("unknown %s(%s)%s", colorDim, u.Reason, colorReset)
+	}
+	return formatCost(u.CostUSD)
+}
+
+// formatCost renders a cost in US dollars.
+func formatCost(usd float64) string {
+	return fmt.Sprintf("$%.2f", usd)
+}
+
+// renderEnvironment renders the command line and environment an agent was
+// launched with for grove status --env.
+func renderEnvironment(env *proto.AgentEnvironment) string {
+	var b strings.Builder
+	words := make([]string, len(env.Argv))
+	for i, a := range env.Argv {
+		words[i] = displayWord(a)
+	}
+	fmt.Fprintf(&b, "  %sLaunched:%s  %s\n", colorDim, colorReset, strings.Join(words, " "))
+	fmt.Fprintf(&b, "\n  %sEnvironment:%s\n", colorDim, colorReset)
+	for _, kv := range env.Env {
+		k, v, _ := strings.Cut(kv, "=")
+		fmt.Fprintf(&b, "    %s%s%s=%s\n", colorBold, k, colorReset, displayWord(v))
+	}
+	return b.String()
+}
+
+// displayWord quotes s for display if it is empty or contains spaces, shell
+// metacharacters or control characters, which would otherwise garble the
+// terminal.
+func displayWord(s string) string {
+	switch {
+	case strings.IndexFunc(s, func(r rune) bool { return !unicode.IsPrint(r) }) >= 0:
+		return strconv.Quote(s)
+	case s == "" || strings.ContainsAny(s, " \t'\"\\$`;&|<>(){}*?!#~"):
+		return shellQuote(s)
+	}
+	return s
 }
 
 func cmdCheck() {
-	if len(os.Args) < 3 {
-		fmt.Fprintln(os.Stderr, "usage: grove check <instance-id>")
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the result as JSON")
+	currentConfig := fs.Bool("current-config", false, "run the check commands the project's config has now")
+	force := fs.Bool("force", false, "clear a CHECKING state left by a check that is no longer running")
+	watch := fs.Bool("watch", false, "run the checks again whenever files in the worktree change, until Ctrl-C")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: grove check <instance-id> [--json] [--current-config] [--force | --watch]")
+	}
+	args, _ := parseArgs(fs, os.Args[2:])
+	if len(args) != 1 || (*watch && (*asJSON || *force)) {
+		fs.Usage()
+		os.Exit(1)
+	}
+	instanceID := resolveInstanceArg(args[0])
+	rememberInstance(instanceID)
+	if *watch {
+		watchChecks(proto.Request{Type: proto.ReqCheck, InstanceID: instanceID, CurrentConfig: *currentConfig, Watch: true})
+		return
+	}
+	streamCommand(proto.Request{
+		Type:          proto.ReqCheck,
+		InstanceID:    instanceID,
+		CurrentConfig: *currentConfig,
+		Force:         *force,
+	}, "checked", *asJSON)
+}
+
+// watchChecks streams grove check --watch until Ctrl-C, or until the
+// daemon stops watching because the instance can no longer be checked.
+func watchChecks(req proto.Request) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	fmt.Fprintf(os.Stderr, "%sWatching instance %s for changes; Ctrl-C stops.%s\n", colorDim, req.InstanceID, colorReset)
+	res, _, err := daemonClient().RunStream(ctx, req, &pipeWriter{w: os.Stdout}, nil)
+	if ctx.Err() != nil {
+		fmt.Fprintf(os.Stderr, "\n%sstopped watching%s\n", colorDim, colorReset)
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grove: %s\n", errorText(err))
 		os.Exit(1)
 	}
-	streamCommand(proto.ReqCheck, os.Args[2])
+	printStreamResult(*res)
+	os.Exit(1)
 }
 
+// cmdDir handles: grove dir <instance-id> | --project <name|#> | --all
+//
+// Prints only the path on stdout and exits non-zero (with the reason on
+// stderr) when it cannot be resolved, so shell wrappers like the gcd function
+// from 'grove shell-init' can safely cd to the output.  --all prints one path
+// per line: every instance worktree that exists, oldest instance first.
 func cmdDir() {
-	if len(os.Args) < 3 {
-		fmt.Fprintln(os.Stderr, "usage: grove dir <instance-id>")
+	rawArgs, all := stripBoolFlag(os.Args[2:], "all", "all")
+	rawArgs, projects, err := stripValueFlag(rawArgs, "project")
+	if err == nil {
+		var short []string
+		rawArgs, short, err = stripValueFlag(rawArgs, "p")
+		projects = append(projects, short...)
+	}
+	// Exactly one of <instance-id>, --project or --all must be given.
+	byProject := len(projects) == 1 && len(rawArgs) == 0 && !all
+	byInstance := len(projects) == 0 && len(rawArgs) == 1 && !all
+	byAll := len(projects) == 0 && len(rawArgs) == 0 && all
+	if err != nil || (!byProject && !byInstance && !byAll) {
+		fmt.Fprintln(os.Stderr, "usage: grove dir <instance-id> | --project <name|#> | --all")
 		os.Exit(1)
 	}
-	id := os.Args[2]
 
-	inst := findInstance(id)
-	if inst == nil {
-		fmt.Fprintf(os.Stderr, "grove: instance not found: %s\n", id)
+	if byAll {
+		for _, inst := range mustRequest(proto.Request{Type: proto.ReqList}).Instances {
+			if fi, err := os.Stat(inst.WorktreeDir); err == nil && fi.IsDir() {
+				fmt.Println(inst.WorktreeDir)
+			}
+		}
+		return
+	}
+
+	if byProject {
+		fmt.Println(projectMainDir(resolveProject(projects[0])))
+		return
+	}
+
+	inst := resolveInstance(rawArgs[0])
+	id := inst.ID
+	if _, err := os.Stat(inst.WorktreeDir); err != nil {
+		fmt.Fprintf(os.Stderr, "grove: worktree for instance %s is missing: %s\n", id, inst.WorktreeDir)
 		os.Exit(1)
 	}
 	fmt.Println(inst.WorktreeDir)
@@ -277,19 +1117,19 @@ func cmdShell() {
 		fmt.Fprintln(os.Stderr, "usage: grove shell <instance-id> [shell]")
 		os.Exit(1)
 	}
-	instanceID := os.Args[2]
+	inst := resolveInstance(os.Args[2])
+	instanceID := inst.ID
 	shell := "sh"
 	if len(os.Args) >= 4 {
 		shell = os.Args[3]
 	}
 
-	inst := findInstance(instanceID)
-	if inst == nil {
-		fmt.Fprintf(os.Stderr, "grove: instance not found: %s\n", instanceID)
+	if inst.ContainerID == "" {
+		fmt.Fprintf(os.Stderr, "grove: instance %s has no container\n", instanceID)
 		os.Exit(1)
 	}
-	if inst.ContainerID == "" {
-		fmt.Fprintf(os.Stderr, "grove: instance not found: %s\n", instanceID)
+	if inst.ContainerRemoved {
+		fmt.Fprintf(os.Stderr, "grove: the container of instance %s was removed (grove restart %s --recreate-container creates it again)\n", instanceID, instanceID)
 		os.Exit(1)
 	}
 
@@ -310,70 +1150,147 @@ func cmdLogs() {
 	fs := flag.NewFlagSet("logs", flag.ExitOnError)
 	follow := fs.Bool("f", false, "follow log output")
 	fs.BoolVar(follow, "follow", false, "follow log output")
+	setup := fs.Bool("setup", false, "print only the setup output (clone, container start, start commands)")
+	agent := fs.Bool("agent", false, "print the whole log without the setup output")
+	helper := fs.String("helper", "", "print the output of the named helper agent from grove.yaml's agents list")
+	plain := fs.Bool("plain", false, "print the text the output leaves on screen, without escape sequences and redraws")
+	all := fs.Bool("all", false, "merge the output of every instance, each line prefixed with its instance")
+	since := fs.Duration("since", 0, "with --all: only output from the last `duration` (default 10m)")
+	project := fs.String("project", "", "with --all: only this project's instances")
 	fs.Usage = func() {
-		fmt.Fprintln(os.Stderr, "usage: grove logs <instance-id> [-f]")
+		fmt.Fprintln(os.Stderr, "usage: grove logs <instance-id> [-f | --setup | --agent] [--helper <name>] [--plain]")
+		fmt.Fprintln(os.Stderr, "       grove logs --all [-f] [--since <duration>] [--project <name>]")
+	}
+	remaining, _ := parseArgs(fs, os.Args[2:])
+	if *all {
+		if len(remaining) != 0 || *setup || *agent || *helper != "" || *plain || *since < 0 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		logsAll(*project, *since, *follow)
+		return
 	}
-	fs.Parse(os.Args[2:])
-	remaining := fs.Args()
-	if len(remaining) < 1 {
-		fmt.Fprintln(os.Stderr, "usage: grove logs <instance-id> [-f]")
+	if len(remaining) != 1 || *since != 0 || *project != "" || (*setup && *agent) || (*follow && (*setup || *agent || *plain)) || (*helper != "" && (*setup || *agent)) {
+		fs.Usage()
 		os.Exit(1)
 	}
-	instanceID := remaining[0]
+	instanceID := resolveInstanceArg(remaining[0])
 
-	reqType := proto.ReqLogs
-	if *follow {
-		reqType = proto.ReqLogsFollow
+	section := ""
+	switch {
+	case *setup:
+		section = proto.LogSectionSetup
+	case *agent:
+		section = proto.LogSectionAgent
 	}
 
-	socketPath := daemonSocket()
-	conn, err := net.Dial("unix", socketPath)
+	logs, err := daemonClient().Logs(context.Background(), instanceID, client.LogsOptions{Follow: *follow, Section: section, Helper: *helper})
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "grove: cannot connect to daemon: %v\n", err)
+		fmt.Fprintf(os.Stderr, "grove: %s\n", errorText(err))
 		os.Exit(1)
 	}
-	defer conn.Close()
-
-	if err := writeRequest(conn, proto.Request{Type: reqType, InstanceID: instanceID}); err != nil {
-		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+	defer logs.Close()
+	if !*plain {
+		copyOutput(os.Stdout, logs)
+		return
+	}
+	data, err := io.ReadAll(logs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grove: %s\n", errorText(err))
 		os.Exit(1)
 	}
-	resp, err := readResponse(conn)
-	if err != nil || !resp.OK {
-		msg := "logs failed"
-		if resp.Error != "" {
-			msg = resp.Error
+	if lines := termtext.Render(data); len(lines) > 0 {
+		_, err = io.WriteString(os.Stdout, strings.Join(lines, "\n")+"\n")
+		exitIfBrokenPipe(err)
+	}
+}
+
+// defaultLogsAllSince is how far back grove logs --all looks without
+// --since.
+const defaultLogsAllSince = 10 * time.Minute
+
+// logsAll prints the agent output of every instance, or of project's, as
+// it came in the last since (defaultLogsAllSince if zero), each line
+// prefixed with its instance; with follow it goes on with new output.
+func logsAll(project string, since time.Duration, follow bool) {
+	if project != "" {
+		// A deleted project's instances are still kept by name.
+		if info, err := lookupProject(project); err == nil {
+			project = info.Name
 		}
-		fmt.Fprintf(os.Stderr, "grove: %s\n", msg)
+	}
+	if since == 0 {
+		since = defaultLogsAllSince
+	}
+	opts := client.LogsAllOptions{Project: project, Since: time.Now().Add(-since), Follow: follow}
+	lines, err := daemonClient().LogsAll(context.Background(), opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grove: %s\n", errorText(err))
 		os.Exit(1)
 	}
-	io.Copy(os.Stdout, conn)
+	defer lines.Close()
+	for {
+		line, err := lines.Next()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "grove: %s\n", errorText(err))
+			os.Exit(1)
+		}
+		_, err = fmt.Fprintln(os.Stdout, formatLogLine(line))
+		exitIfBrokenPipe(err)
+	}
+}
+
+// formatLogLine renders a line of grove logs --all: "[3/feat-x] text".
+func formatLogLine(line proto.LogLine) string {
+	source := line.InstanceID
+	if line.Branch != "" {
+		source += "/" + line.Branch
+	}
+	return "[" + source + "] " + line.Text
 }
 
 func cmdPrune() {
+	rawArgs, selector := labelSelector(os.Args[2:])
 	fs := flag.NewFlagSet("prune", flag.ExitOnError)
-	includeFinished := fs.Bool("finished", false, "also drop FINISHED instances")
+	includeFinished := fs.Bool("finished", false, "also drop FINISHED and FINISH_FAILED instances (scratch ones are dropped anyway)")
 	fs.Usage = func() {
-		fmt.Fprintln(os.Stderr, "usage: grove prune [--finished]")
+		fmt.Fprintln(os.Stderr, "usage: grove prune [--finished] [--label key=value ...]")
+	}
+	if args, _ := parseArgs(fs, rawArgs); len(args) != 0 {
+		fs.Usage()
+		os.Exit(1)
 	}
-	fs.Parse(os.Args[2:])
 
 	resp := mustRequest(proto.Request{Type: proto.ReqList})
 
 	var dead []proto.InstanceInfo
+	pinned := 0
 	for _, inst := range resp.Instances {
+		if !matchLabels(inst.Labels, selector) {
+			continue
+		}
 		switch inst.State {
-		case proto.StateExited, proto.StateCrashed, proto.StateKilled:
-			dead = append(dead, inst)
-		case proto.StateFinished:
-			if *includeFinished {
-				dead = append(dead, inst)
+		case proto.StateExited, proto.StateCrashed, proto.StateKilled, proto.StateFailedSetup, proto.StateStalled, proto.StateBroken:
+		case proto.StateFinished, proto.StateFinishFailed:
+			if !*includeFinished && inst.Scratch == nil {
+				continue
 			}
+		default:
+			continue
 		}
+		if inst.Pinned {
+			pinned++
+			continue
+		}
+		dead = append(dead, inst)
 	}
 
 	if len(dead) == 0 {
 		fmt.Printf("%snothing to prune%s\n", colorDim, colorReset)
+		skippedPinned(pinned)
 		return
 	}
 
@@ -385,7 +1302,11 @@ func cmdPrune() {
 		fmt.Printf("    %sBranch:%s    %s%s%s\n", colorDim, colorReset, colorCyan, inst.Branch, colorReset)
 		fmt.Printf("    %sState:%s     %s\n\n", colorDim, colorReset, inst.State)
 	}
-	fmt.Printf("  This will drop %d instance(s) and their worktrees.\n\n", len(dead))
+	fmt.Printf("  This will drop %d instance(s) and their worktrees.\n", len(dead))
+	if pinned > 0 {
+		fmt.Printf("  %s%d pinned instance(s) are left alone.%s\n", colorDim, pinned, colorReset)
+	}
+	fmt.Println()
 	fmt.Printf("%sContinue?%s [y/N] ", colorBold, colorReset)
 
 	reader := bufio.NewReader(os.Stdin)
@@ -396,9 +1317,16 @@ func cmdPrune() {
 		return
 	}
 
+	leftBehind := false
 	for _, inst := range dead {
-		mustRequest(proto.Request{Type: proto.ReqDrop, InstanceID: inst.ID})
-		fmt.Printf("%s✓  Dropped%s %s%s%s\n", colorGreen+colorBold, colorReset, colorCyan, inst.ID, colorReset)
+		resp := mustRequest(proto.Request{Type: proto.ReqDrop, InstanceID: inst.ID})
+		printResultItem(droppedResult(inst.ID))
+		if printResidue(resp.Residue) {
+			leftBehind = true
+		}
 	}
 	fmt.Println()
+	if leftBehind {
+		os.Exit(1)
+	}
 }
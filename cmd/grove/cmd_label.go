@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+// cmdLabel handles: grove label <instance-id> [key=value ...] [--remove key ...]
+//
+// Sets or removes labels on an existing instance. With no arguments after the
+// ID, prints the instance's current labels.
+func cmdLabel() {
+	rawArgs, removeKeys, err := stripValueFlag(os.Args[2:], "remove")
+	if err != nil || len(rawArgs) < 1 {
+		fmt.Fprintln(os.Stderr, "usage: grove label <instance-id> [key=value ...] [--remove key ...]")
+		os.Exit(1)
+	}
+	inst := resolveInstance(rawArgs[0])
+	instanceID := inst.ID
+
+	labels, err := parseLabels(rawArgs[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+		os.Exit(1)
+	}
+
+	if len(labels) == 0 && len(removeKeys) == 0 {
+		if len(inst.Labels) == 0 {
+			fmt.Printf("%sno labels%s\n", colorDim, colorReset)
+			return
+		}
+		for _, l := range formatLabels(inst.Labels) {
+			fmt.Println(l)
+		}
+		return
+	}
+
+	mustRequest(proto.Request{
+		Type:         proto.ReqLabel,
+		InstanceID:   instanceID,
+		Labels:       labels,
+		RemoveLabels: removeKeys,
+	})
+	printResult(labeledResult(instanceID))
+}
+
+// parseLabels converts "key=value" arguments into a map.  Returns nil when
+// args is empty.
+func parseLabels(args []string) (map[string]string, error) {
+	if len(args) == 0 {
+		return nil, nil
+	}
+	labels := make(map[string]string, len(args))
+	for _, a := range args {
+		k, v, ok := strings.Cut(a, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("invalid label %q: want key=value", a)
+		}
+		labels[k] = v
+	}
+	return labels, nil
+}
+
+// matchLabels reports whether labels contains every key=value pair in
+// selector.  An empty selector matches everything.
+func matchLabels(labels, selector map[string]string) bool {
+	for k, v := range selector {
+		if got, ok := labels[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
+// formatLabels returns labels as sorted "key=value" strings.
+func formatLabels(labels map[string]string) []string {
+	out := make([]string, 0, len(labels))
+	for k, v := range labels {
+		out = append(out, k+"="+v)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// labelSelector strips every --label key=value flag from args and returns
+// the remaining args plus the parsed selector.  Exits on a malformed label.
+func labelSelector(args []string) ([]string, map[string]string) {
+	rest, values, err := stripValueFlag(args, "label")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+		os.Exit(1)
+	}
+	selector, err := parseLabels(values)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+		os.Exit(1)
+	}
+	return rest, selector
+}
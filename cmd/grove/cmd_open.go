@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// waitEditors lists editors whose CLI accepts --wait to block until the
+// window is closed.  Terminal editors block anyway and must not get the flag.
+var waitEditors = map[string]bool{
+	"code": true, "code-insiders": true, "cursor": true, "windsurf": true,
+	"subl": true, "zed": true, "mate": true,
+}
+
+// jetbrainsApps maps JetBrains CLI launcher names to their macOS app bundle
+// names, used when the launcher is not on PATH.
+var jetbrainsApps = map[string]string{
+	"idea":      "IntelliJ IDEA",
+	"goland":    "GoLand",
+	"pycharm":   "PyCharm",
+	"webstorm":  "WebStorm",
+	"rubymine":  "RubyMine",
+	"clion":     "CLion",
+	"phpstorm":  "PhpStorm",
+	"rider":     "Rider",
+	"rustrover": "RustRover",
+	"fleet":     "Fleet",
+}
+
+// cmdOpen handles: grove open <instance-id> [--editor <cmd>] [--wait]
+//
+// Opens the instance worktree in the user's editor.
+func cmdOpen() {
+	rawArgs, wait := stripBoolFlag(os.Args[2:], "w", "wait")
+	rawArgs, editors, err := stripValueFlag(rawArgs, "editor")
+	if err != nil || len(rawArgs) != 1 || len(editors) > 1 {
+		fmt.Fprintln(os.Stderr, "usage: grove open <instance-id> [--editor <cmd>] [--wait]")
+		os.Exit(1)
+	}
+	inst := resolveInstance(rawArgs[0])
+	instanceID := inst.ID
+	if _, err := os.Stat(inst.WorktreeDir); err != nil {
+		fmt.Fprintf(os.Stderr, "grove: worktree for instance %s is missing: %s\n", instanceID, inst.WorktreeDir)
+		os.Exit(1)
+	}
+
+	override := ""
+	if len(editors) == 1 {
+		override = editors[0]
+	}
+	editor := resolveEditor(override, loadUserConfig().Editor, exec.LookPath)
+	if editor == "" {
+		fmt.Fprintln(os.Stderr, "grove: no editor configured")
+		fmt.Fprintln(os.Stderr, "  set $GROVE_EDITOR, $VISUAL or $EDITOR, add 'editor: <cmd>' to ~/.grove/config.yaml, or pass --editor")
+		os.Exit(1)
+	}
+
+	argv := editorCommand(editor, inst.WorktreeDir, wait, exec.LookPath, runtime.GOOS)
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			os.Exit(exitErr.ExitCode())
+		}
+		fmt.Fprintf(os.Stderr, "grove: could not launch editor %q: %v\n", argv[0], err)
+		os.Exit(1)
+	}
+}
+
+// resolveEditor picks the editor command.  Precedence: --editor, then
+// $GROVE_EDITOR, then ~/.grove/config.yaml, then $VISUAL, then $EDITOR, then
+// the first of code/cursor found on PATH.  Returns "" if none is available.
+func resolveEditor(override, configured string, lookPath func(string) (string, error)) string {
+	for _, e := range []string{override, os.Getenv("GROVE_EDITOR"), configured, os.Getenv("VISUAL"), os.Getenv("EDITOR")} {
+		if strings.TrimSpace(e) != "" {
+			return e
+		}
+	}
+	for _, e := range []string{"code", "cursor"} {
+		if _, err := lookPath(e); err == nil {
+			return e
+		}
+	}
+	return ""
+}
+
+// editorCommand builds the argv for opening dir with editor.  editor may
+// include arguments (e.g. "code -n").  --wait is appended only for editors
+// known to support it.  JetBrains IDEs use their CLI launcher when it is on
+// PATH and fall back to "open -na <App>.app --args" on macOS.
+func editorCommand(editor, dir string, wait bool, lookPath func(string) (string, error), goos string) []string {
+	fields := strings.Fields(editor)
+	name := strings.ToLower(filepath.Base(fields[0]))
+	args := fields[1:]
+
+	if app, ok := jetbrainsApps[name]; ok {
+		if _, err := lookPath(fields[0]); err != nil && goos == "darwin" {
+			open := []string{"open", "-na", app + ".app"}
+			if wait {
+				open = []string{"open", "-W", "-na", app + ".app"}
+			}
+			return append(append(open, "--args"), append(args, dir)...)
+		}
+		if wait {
+			args = append(args, "--wait")
+		}
+		return append([]string{fields[0]}, append(args, dir)...)
+	}
+
+	if wait && waitEditors[name] {
+		args = append(args, "--wait")
+	}
+	return append([]string{fields[0]}, append(args, dir)...)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gandalfthegui/grove/internal/termtext"
+	"github.com/gandalfthegui/grove/pkg/client"
+	"golang.org/x/term"
+)
+
+// peekTailBytes is how much of the end of an agent's output grove peek
+// asks for: enough for several redraws of a full-screen interface.
+const peekTailBytes = 256 << 10
+
+// cmdPeek handles: grove peek <instance-id> [--lines N]
+//
+// Prints what the agent's screen would show now, roughly: the end of its
+// recent output with the redraws played out (see internal/termtext), so
+// that a spinner or an interface drawn over and over comes out once.
+func cmdPeek() {
+	fs := flag.NewFlagSet("peek", flag.ExitOnError)
+	lines := fs.Int("lines", 40, "number of lines to print")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: grove peek <instance-id> [--lines N]")
+	}
+	remaining, _ := parseArgs(fs, os.Args[2:])
+	if len(remaining) != 1 || *lines < 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	instanceID := resolveInstanceArg(remaining[0])
+
+	screen, err := peekLines(instanceID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grove: %s\n", errorText(err))
+		os.Exit(1)
+	}
+	if len(screen) == 0 {
+		fmt.Printf("%sno output yet%s\n", colorDim, colorReset)
+		return
+	}
+	_, err = io.WriteString(os.Stdout, strings.Join(termtext.Tail(screen, *lines), "\n")+"\n")
+	exitIfBrokenPipe(err)
+}
+
+// peekLines returns the rendered lines of the end of an instance's output.
+func peekLines(instanceID string) ([]string, error) {
+	logs, err := daemonClient().Logs(context.Background(), instanceID, client.LogsOptions{Tail: peekTailBytes})
+	if err != nil {
+		return nil, err
+	}
+	defer logs.Close()
+	data, err := io.ReadAll(logs)
+	if err != nil {
+		return nil, err
+	}
+	return termtext.Render(data), nil
+}
+
+// watchPeek is grove watch's peek overlay.  The p key asks for an
+// instance ID; Enter then shows that instance's screen, as grove peek
+// prints it, over the dashboard.  Every refresh fetches it again, so it
+// follows the agent unless scrolled up.
+type watchPeek struct {
+	prompting bool
+	id        string // typed so far, or being shown
+	shown     bool
+	scroll    int    // lines scrolled up from the bottom
+	esc       int    // 1 after ESC, 2 after ESC [: arrow keys come as such
+	msg       string // why the last peek failed
+}
+
+// key handles a key pressed on the dashboard, reporting false if it was
+// not for the peek.
+func (p *watchPeek) key(b byte) bool {
+	switch {
+	case p.prompting:
+		switch b {
+		case '\r', '\n':
+			p.prompting = false
+			if p.id != "" {
+				p.shown, p.scroll, p.msg = true, 0, ""
+			}
+		case 0x1b:
+			p.prompting, p.id = false, ""
+		case 0x7f, '\b':
+			if p.id != "" {
+				p.id = p.id[:len(p.id)-1]
+			}
+		default:
+			if b > ' ' && b < 0x7f {
+				p.id += string(b)
+			}
+		}
+		return true
+
+	case p.shown:
+		page := peekPage()
+		switch {
+		case p.esc == 1 && b == '[':
+			p.esc = 2
+			return true
+		case p.esc == 2:
+			p.esc = 0
+			switch b {
+			case 'A':
+				p.scroll++
+			case 'B':
+				p.scroll--
+			}
+		case b == 0x1b:
+			p.esc = 1
+			return true
+		case b == 'k':
+			p.scroll++
+		case b == 'j':
+			p.scroll--
+		case b == 'b':
+			p.scroll += page
+		case b == ' ':
+			p.scroll -= page
+		case b == 'g':
+			p.scroll = 1 << 30 // clamped when drawn
+		case b == 'G':
+			p.scroll = 0
+		case b == 'q' || b == 'p':
+			p.shown, p.id = false, ""
+		}
+		p.esc = 0
+		p.scroll = max(p.scroll, 0)
+		return true
+
+	case b == 'p':
+		p.prompting, p.id = true, ""
+		return true
+	}
+	return false
+}
+
+// status is the line shown under the dashboard while asking for an ID, or
+// after a failed peek.
+func (p *watchPeek) status() string {
+	if p.prompting {
+		return "peek at instance: " + p.id + "█  (Enter: show, Esc: cancel)"
+	}
+	return p.msg
+}
+
+// draw draws the overlay over the whole terminal.
+func (p *watchPeek) draw(fd int) {
+	width, height, err := term.GetSize(fd)
+	if err != nil || width < 40 {
+		width, height = 120, 40
+	}
+	lines, err := peekLines(p.id)
+	if err != nil {
+		p.shown, p.msg = false, fmt.Sprintf("peek %s: %s", p.id, errorText(err))
+		return
+	}
+	fmt.Print(renderPeek(p.id, lines, width, height, &p.scroll))
+}
+
+// peekPage is how many lines space and b scroll the overlay by.
+func peekPage() int {
+	_, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || height < 10 {
+		return 20
+	}
+	return height - 3
+}
+
+// renderPeek renders the peek overlay for an instance's screen lines on a
+// terminal of width by height, scrolled up *scroll lines from the bottom;
+// a scroll past the top is pulled back to it.
+func renderPeek(id string, lines []string, width, height int, scroll *int) string {
+	rows := max(height-2, 1)
+	*scroll = max(min(*scroll, len(lines)-rows), 0)
+	end := len(lines) - *scroll
+	start := max(end-rows, 0)
+
+	var buf strings.Builder
+	buf.WriteString("\033[H")
+	fmt.Fprintf(&buf, "%speek %s%s  %sj/k, space/b: scroll  g/G: top/bottom  q: back to the dashboard%s\033[K\n",
+		colorBold, id, colorReset, colorDim, colorReset)
+	for _, line := range lines[start:end] {
+		if r := []rune(line); len(r) > width {
+			line = string(r[:width])
+		}
+		buf.WriteString(line + "\033[K\n")
+	}
+	for i := end - start; i < rows; i++ {
+		buf.WriteString("\033[K\n")
+	}
+	footer := "no output yet"
+	if len(lines) > 0 {
+		where := "the end"
+		if *scroll > 0 {
+			where = strconv.Itoa(*scroll) + " above the end"
+		}
+		footer = fmt.Sprintf("lines %d-%d of %d, %s", start+1, end, len(lines), where)
+	}
+	fmt.Fprintf(&buf, "%s%s%s\033[J", colorDim, footer, colorReset)
+	return buf.String()
+}
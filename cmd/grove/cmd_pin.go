@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+// pinMarker marks pinned instances in grove list, watch and status.
+const pinMarker = "📌"
+
+// cmdPin handles: grove pin <instance-id> and grove unpin <instance-id>
+//
+// A pinned instance is left out of grove prune and grove stop --all, and
+// its time to live does not run out while it is pinned; grove drop asks
+// twice before dropping it.
+func cmdPin(pin bool) {
+	name := "pin"
+	if !pin {
+		name = "unpin"
+	}
+	if len(os.Args) != 3 {
+		fmt.Fprintf(os.Stderr, "usage: grove %s <instance-id>\n", name)
+		os.Exit(1)
+	}
+	instanceID := resolveInstanceArg(os.Args[2])
+
+	resp := mustRequest(proto.Request{Type: proto.ReqPin, InstanceID: instanceID, Pin: pin})
+	if !pin {
+		printResult(unpinnedResult(instanceID))
+		return
+	}
+	printResult(pinnedResult(instanceID))
+	if len(resp.Instances) == 1 && resp.Instances[0].ExpiresAt != 0 {
+		fmt.Printf("  %sIts time to live (%s) waits until it is unpinned.%s\n", colorDim, formatTTL(resp.Instances[0], time.Now()), colorReset)
+	}
+}
+
+// skippedPinned prints how many pinned instances a bulk command left
+// alone, if any.
+func skippedPinned(n int) {
+	if n > 0 {
+		fmt.Printf("%sskipped %d pinned instance(s) (grove unpin <id> to include them)%s\n", colorDim, n, colorReset)
+	}
+}
@@ -2,56 +2,92 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"os/exec"
+	"os/signal"
 	"path/filepath"
-	"strconv"
+	"sort"
 	"strings"
+	"syscall"
+	"time"
 
-	"github.com/gandalfthegui/grove/internal/proto"
+	"github.com/gandalfthegui/grove/internal/registry"
+	"github.com/gandalfthegui/grove/pkg/proto"
 	"gopkg.in/yaml.v3"
 )
 
 func cmdProject() {
 	if len(os.Args) < 3 {
-		fmt.Fprintln(os.Stderr, "usage: grove project <create|list|delete|dir>")
+		fmt.Fprintln(os.Stderr, "usage: grove project <create|update|list|fetch|delete|dir|move|shell|export|import-bundle>")
 		os.Exit(1)
 	}
 	switch os.Args[2] {
 	case "create":
 		cmdProjectCreate()
+	case "update":
+		cmdProjectUpdate()
 	case "list":
 		cmdProjectList()
+	case "fetch":
+		cmdProjectFetch()
 	case "delete":
 		cmdProjectDelete()
 	case "dir":
 		cmdProjectDir()
+	case "move":
+		cmdProjectMove()
+	case "shell":
+		cmdProjectShell()
+	case "export":
+		cmdProjectExport()
+	case "import-bundle":
+		cmdProjectImportBundle()
 	default:
 		fmt.Fprintf(os.Stderr, "grove: unknown project subcommand %q\n", os.Args[2])
 		os.Exit(1)
 	}
 }
 
-// cmdProjectCreate handles: grove project create <name> [--repo <url>]
+// cmdProjectCreate handles: grove project create <name> [--repo <url>] [--data-dir <path>]
 //
 // Writes a minimal registration (name + repo URL) to
-// ~/.grove/projects/<name>/project.yaml. All other config (container, agent,
-// start, finish, check) belongs in grove.yaml in the project repo.
+// ~/.grove/projects/<name>/project.yaml — never to a shared directory from
+// GROVE_PROJECTS_PATH. All other config (container, agent, start, finish,
+// check) belongs in grove.yaml in the project repo.  --data-dir keeps the
+// main checkout and worktrees in an existing directory elsewhere, e.g. on
+// an external volume.
 func cmdProjectCreate() {
-	if len(os.Args) < 4 || os.Args[3] == "" || os.Args[3][0] == '-' {
-		fmt.Fprintln(os.Stderr, "usage: grove project create <name> [--repo <url>]")
-		os.Exit(1)
-	}
-	name := os.Args[3]
-
 	fs := flag.NewFlagSet("project create", flag.ExitOnError)
 	repo := fs.String("repo", "", "git remote URL (can be added later)")
+	dataDir := fs.String("data-dir", "", "existing directory to keep the main checkout and worktrees in")
 	fs.Usage = func() {
-		fmt.Fprintln(os.Stderr, "usage: grove project create <name> [--repo <url>]")
+		fmt.Fprintln(os.Stderr, "usage: grove project create <name> [--repo <url>] [--data-dir <path>]")
 		fs.PrintDefaults()
 	}
-	fs.Parse(os.Args[4:])
+	args, _ := parseArgs(fs, os.Args[3:])
+	if len(args) != 1 || args[0] == "" {
+		fs.Usage()
+		os.Exit(1)
+	}
+	name := args[0]
+	if err := registry.ValidName(name); err != nil {
+		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *dataDir != "" {
+		dir, err := existingDir(*dataDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "grove: --data-dir: %v\n", err)
+			os.Exit(1)
+		}
+		*dataDir = dir
+	}
 
 	projectDir := filepath.Join(rootDir(), "projects", name)
 	if _, err := os.Stat(filepath.Join(projectDir, "project.yaml")); err == nil {
@@ -65,13 +101,23 @@ func cmdProjectCreate() {
 
 	yamlPath := filepath.Join(projectDir, "project.yaml")
 	content := fmt.Sprintf("name: %s\nrepo: %s\n", name, *repo)
+	if *dataDir != "" {
+		content += fmt.Sprintf("data_dir: %s\n", *dataDir)
+	}
 	if err := os.WriteFile(yamlPath, []byte(content), 0o644); err != nil {
 		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
 		os.Exit(1)
 	}
 
-	fmt.Printf("\n%s✓  Created project%s %s%q%s\n\n", colorGreen+colorBold, colorReset, colorCyan, name, colorReset)
+	printResult(projectCreatedResult(name))
 	fmt.Printf("%sConfig:%s %s%s%s\n\n", colorBold, colorReset, colorCyan, yamlPath, colorReset)
+	if *repo != "" {
+		// The daemon clones in the background if prefetch_on_register is
+		// set; without a daemon the first start clones.
+		if resp, err := tryRequest(proto.Request{Type: proto.ReqProjectFetch, Project: name, Prefetch: true}); err == nil && resp.Prefetching {
+			fmt.Printf("%sCloning in the background; grove project list shows when it is done.%s\n\n", colorDim, colorReset)
+		}
+	}
 	fmt.Printf("%sNext step:%s\n\n", colorBold, colorReset)
 	if *repo == "" {
 		fmt.Printf("  %s1.%s Edit the file to set your repo URL\n", colorBold, colorReset)
@@ -82,124 +128,314 @@ func cmdProjectCreate() {
 	fmt.Printf("     %sgrove start %s <branch>%s\n\n", colorDim, name, colorReset)
 }
 
-// projectEntry holds the parsed fields grove cares about from a registration.
-type projectEntry struct {
-	name string
-	repo string
+// cmdProjectUpdate handles: grove project update <name|#> --repo <url>
+//
+// Points a project at a new repo URL, e.g. after the repo was renamed or
+// moved, or to switch between SSH and https: it rewrites repo: in the
+// registration and the main checkout's origin remote if it is cloned.
+// Shared registrations are left to whoever maintains them.
+func cmdProjectUpdate() {
+	fs := flag.NewFlagSet("project update", flag.ExitOnError)
+	repo := fs.String("repo", "", "new git remote URL")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: grove project update <name|#> --repo <url>")
+		fs.PrintDefaults()
+	}
+	args, _ := parseArgs(fs, os.Args[3:])
+	if len(args) != 1 || args[0] == "" || *repo == "" {
+		fs.Usage()
+		os.Exit(1)
+	}
+	e, err := registry.Resolve(projectDirs(), args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+		os.Exit(1)
+	}
+	personal := registry.PersonalDir(rootDir())
+	if filepath.Clean(e.Source) != filepath.Clean(personal) {
+		fmt.Fprintf(os.Stderr, "grove: project %q is registered in shared directory %s; update it there\n", e.Name, e.Source)
+		os.Exit(1)
+	}
+	if err := registry.SetRepo(personal, e.Name, *repo); err != nil {
+		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+		os.Exit(1)
+	}
+	mainDir := filepath.Join(registry.DataDir(rootDir(), e), "main")
+	if _, err := os.Stat(filepath.Join(mainDir, ".git")); err == nil {
+		if out, err := exec.Command("git", "-C", mainDir, "remote", "set-url", "origin", *repo).CombinedOutput(); err != nil {
+			fmt.Fprintf(os.Stderr, "grove: updated the registration, but not the main checkout's origin: %v: %s\n", err, strings.TrimSpace(string(out)))
+			os.Exit(1)
+		}
+	}
+	printResult(projectUpdatedResult(e.Name))
+	fmt.Printf("%sRepo:%s %s%s%s\n\n", colorBold, colorReset, colorCyan, *repo, colorReset)
 }
 
-// loadProjectEntries scans ~/.grove/projects/ and returns all registered
-// projects in directory order (alphabetical by folder name).
-func loadProjectEntries() []projectEntry {
-	projectsDir := filepath.Join(rootDir(), "projects")
-	dirEntries, err := os.ReadDir(projectsDir)
-	if err != nil {
-		return nil
+// projectDirs returns the registration search path: ~/.grove/projects, then
+// each directory in GROVE_PROJECTS_PATH.  It is only used when the daemon is
+// unreachable; otherwise the daemon's own search path applies.
+func projectDirs() []string {
+	return registry.SearchDirs(rootDir(), registry.PathFromEnv())
+}
+
+// listProjects returns all registered projects sorted by name, as the daemon
+// sees them, or from the local filesystem if the daemon is unreachable.
+func listProjects() []proto.ProjectInfo {
+	resp, err := tryRequest(proto.Request{Type: proto.ReqProjects})
+	if err == nil {
+		return resp.Projects
 	}
+	return loadProjectEntries()
+}
 
-	var entries []projectEntry
-	for _, e := range dirEntries {
-		if !e.IsDir() {
-			continue
-		}
-		data, err := os.ReadFile(filepath.Join(projectsDir, e.Name(), "project.yaml"))
-		if err != nil {
-			continue
-		}
-		var p struct {
-			Name string `yaml:"name"`
-			Repo string `yaml:"repo"`
-		}
-		if err := yaml.Unmarshal(data, &p); err != nil {
-			continue
-		}
-		name := p.Name
-		if name == "" {
-			name = e.Name()
-		}
-		repo := p.Repo
-		if repo == "" {
-			repo = "(no repo)"
-		}
-		entries = append(entries, projectEntry{name, repo})
+// lookupProject resolves a project argument that may be a 1-based index
+// (e.g. "1", "2") or a project name, via the daemon if it is reachable.
+func lookupProject(arg string) (proto.ProjectInfo, error) {
+	return resolveProjectInfo(proto.Request{Type: proto.ReqProjectResolve, Project: arg})
+}
+
+// resolveProjectInfo is lookupProject for a ReqProjectResolve request req.
+func resolveProjectInfo(req proto.Request) (proto.ProjectInfo, error) {
+	arg := req.Project
+	resp, err := tryRequest(req)
+	if err == nil && resp.Project != nil {
+		return *resp.Project, nil
 	}
-	return entries
+	if resp.Error != "" {
+		return proto.ProjectInfo{}, err // the daemon answered: not found
+	}
+	e, err := registry.Resolve(projectDirs(), arg)
+	if err != nil {
+		return proto.ProjectInfo{}, err
+	}
+	return localProjectInfo(e), nil
 }
 
 // resolveProject resolves a project argument that may be a 1-based index
-// (e.g. "1", "2") or a literal project name. Exits with an error message
-// if a numeric index is out of range.
+// (e.g. "1", "2") or a project name. Exits with an error message if there
+// is no such project.
 func resolveProject(arg string) string {
-	n, err := strconv.Atoi(arg)
+	info, err := lookupProject(arg)
 	if err != nil {
-		return arg // not a number — use as-is
-	}
-	entries := loadProjectEntries()
-	if n < 1 || n > len(entries) {
-		fmt.Fprintf(os.Stderr, "grove: project index %d out of range (have %d project(s))\n", n, len(entries))
+		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
 		os.Exit(1)
 	}
-	return entries[n-1].name
+	return info.Name
+}
+
+// loadProjectEntries scans the local registration search path, for use when
+// the daemon is unreachable.  Instance counts are not known and left zero.
+func loadProjectEntries() []proto.ProjectInfo {
+	var entries []proto.ProjectInfo
+	for _, e := range registry.List(projectDirs()) {
+		entries = append(entries, localProjectInfo(e))
+	}
+	return entries
 }
 
-// cmdProjectList handles: grove project list
+func localProjectInfo(e registry.Entry) proto.ProjectInfo {
+	info := proto.ProjectInfo{Name: e.Name, Repo: e.Repo, Source: e.Source}
+	mainDir := filepath.Join(registry.DataDir(rootDir(), e), "main")
+	if _, err := os.Stat(filepath.Join(mainDir, ".git")); err == nil {
+		info.Cloned = true
+	}
+	if projectConfigPath(mainDir) != "" {
+		info.HasConfig = true
+		info.AgentCommand = detectAgentCommand(e.Name)
+	}
+	return info
+}
+
+// cmdProjectList handles: grove project list [--verbose] [--json]
 //
-// Scans ~/.grove/projects/ and prints a numbered summary table.
-// This is a pure filesystem operation — no daemon required.
+// Prints a numbered summary table of the projects the daemon knows about.
+// Works without a daemon too, by scanning the local registration search path.
+// --verbose adds what shows whether a project is in use: its instances by
+// state, when the newest was started, and the disk its main checkout and
+// worktrees take, which the daemon measures (slow the first time for big
+// projects).  Without a daemon those are unknown, and the plain table is
+// printed instead.  --json prints the projects as the daemon describes them.
 func cmdProjectList() {
-	entries := loadProjectEntries()
+	rawArgs, verbose := stripBoolFlag(os.Args[3:], "v", "verbose")
+	rawArgs, asJSON := stripBoolFlag(rawArgs, "json", "json")
+	if len(rawArgs) != 0 {
+		fmt.Fprintln(os.Stderr, "usage: grove project list [--verbose] [--json]")
+		os.Exit(1)
+	}
+	entries := []proto.ProjectInfo{}
+	resp, err := tryRequest(proto.Request{Type: proto.ReqProjects, DiskUsage: verbose})
+	if err == nil {
+		entries = append(entries, resp.Projects...)
+	} else {
+		entries = append(entries, loadProjectEntries()...)
+	}
+	if asJSON {
+		data, _ := json.MarshalIndent(entries, "", "  ")
+		fmt.Println(string(data))
+		return
+	}
 	if len(entries) == 0 {
 		fmt.Printf("%sno projects defined%s\n", colorDim, colorReset)
 		return
 	}
 
-	fmt.Printf("%s%-4s  %-20s  %s%s\n", colorBold, "#", "NAME", "REPO", colorReset)
-	fmt.Printf("%s%-4s  %-20s  %s%s\n", colorDim, "----", "--------------------", "----", colorReset)
+	now := time.Now()
+	if verbose && err == nil {
+		fmt.Print(renderProjectsVerbose(entries, now))
+		return
+	}
+	fmt.Printf("%s%-4s  %-20s  %-20s  %-10s  %s%s\n", colorBold, "#", "NAME", "SOURCE", "CLONED", "REPO", colorReset)
+	fmt.Printf("%s%-4s  %-20s  %-20s  %-10s  %s%s\n", colorDim, "----", "--------------------", "--------------------", "----------", "----", colorReset)
+	for i, e := range entries {
+		repo := e.Repo
+		if repo == "" {
+			repo = "(no repo)"
+		}
+		fmt.Printf("%-4d  %-20s  %-20s  %-10s  %s\n", i+1, e.Name, projectSource(e.Source), formatCloned(e, now), repo)
+	}
+	if verbose {
+		fmt.Printf("%sthe daemon is not reachable; instances and disk usage are unknown%s\n", colorDim, colorReset)
+	}
+}
+
+// renderProjectsVerbose renders the table of grove project list --verbose.
+func renderProjectsVerbose(entries []proto.ProjectInfo, now time.Time) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s%-4s  %-20s  %-10s  %-10s  %-10s  %-10s  %s%s\n", colorBold, "#", "NAME", "CLONED", "MAIN", "WORKTREES", "LAST START", "INSTANCES", colorReset)
+	fmt.Fprintf(&b, "%s%-4s  %-20s  %-10s  %-10s  %-10s  %-10s  %s%s\n", colorDim, "----", "--------------------", "----------", "----------", "----------", "----------", "---------", colorReset)
 	for i, e := range entries {
-		fmt.Printf("%-4d  %-20s  %s\n", i+1, e.name, e.repo)
+		mainSize, worktreesSize := "-", "-"
+		if e.MeasuredAt != 0 {
+			if e.Cloned {
+				mainSize = proto.FormatBytes(uint64(e.MainBytes))
+			}
+			worktreesSize = proto.FormatBytes(uint64(e.WorktreesBytes))
+		}
+		lastStart := "-"
+		if e.LastStartAt != 0 {
+			lastStart = formatAge(now.Sub(time.Unix(e.LastStartAt, 0))) + " ago"
+		}
+		fmt.Fprintf(&b, "%-4d  %-20s  %-10s  %-10s  %-10s  %-10s  %s\n", i+1, truncate(e.Name, 20), formatCloned(e, now),
+			mainSize, worktreesSize, lastStart, formatInstanceStates(e))
+	}
+	return b.String()
+}
+
+// formatInstanceStates renders a project's instances for the INSTANCES
+// column of grove project list --verbose, e.g. "3 (2 RUNNING, 1 EXITED)".
+func formatInstanceStates(info proto.ProjectInfo) string {
+	if info.Instances == 0 {
+		return colorDim + "none" + colorReset
+	}
+	states := make([]string, 0, len(info.InstanceStates))
+	for state := range info.InstanceStates {
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		if info.InstanceStates[states[i]] != info.InstanceStates[states[j]] {
+			return info.InstanceStates[states[i]] > info.InstanceStates[states[j]]
+		}
+		return states[i] < states[j]
+	})
+	for i, state := range states {
+		states[i] = fmt.Sprintf("%d %s", info.InstanceStates[state], state)
+	}
+	return fmt.Sprintf("%d (%s)", info.Instances, strings.Join(states, ", "))
+}
+
+// formatCloned renders whether a project's main checkout exists for the
+// CLONED column of grove project list: "no", or how long ago it was last
+// fetched ("yes" if that is not known).
+func formatCloned(info proto.ProjectInfo, now time.Time) string {
+	switch {
+	case !info.Cloned:
+		return "no"
+	case info.FetchedAt == 0:
+		return "yes"
 	}
+	return formatAge(now.Sub(time.Unix(info.FetchedAt, 0))) + " ago"
 }
 
-// cmdProjectDelete handles: grove project delete <name>
+// cmdProjectFetch handles: grove project fetch <name|#>
 //
-// Prompts for confirmation (project and all worktrees are removed), then
-// deletes the entire project directory under ~/.grove/projects/<name>/.
-func cmdProjectDelete() {
-	if len(os.Args) < 4 || os.Args[3] == "" {
-		fmt.Fprintln(os.Stderr, "usage: grove project delete <name|#>")
+// Has the daemon clone the project's main checkout, or pull it if it
+// exists, streaming the git output, so that the next grove start need not.
+func cmdProjectFetch() {
+	if len(os.Args) != 4 || os.Args[3] == "" {
+		fmt.Fprintln(os.Stderr, "usage: grove project fetch <name|#>")
 		os.Exit(1)
 	}
-	name := resolveProject(os.Args[3])
-
-	projectDir := filepath.Join(rootDir(), "projects", name)
-	yamlPath := filepath.Join(projectDir, "project.yaml")
-	if _, err := os.Stat(yamlPath); err != nil {
-		if os.IsNotExist(err) {
-			fmt.Fprintf(os.Stderr, "grove: project %q not found\n", name)
-		} else {
-			fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+	info, err := lookupProject(os.Args[3])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+		os.Exit(1)
+	}
+	res, _ := streamRequest(proto.Request{Type: proto.ReqProjectFetch, Project: info.Name}, os.Stdout)
+	if !res.OK {
+		fmt.Fprintf(os.Stderr, "grove: could not fetch project %q: %s\n", info.Name, res.Error)
+		if res.Hint != "" {
+			fmt.Fprintln(os.Stderr, formatHint(res.Hint))
 		}
 		os.Exit(1)
 	}
+	printResult(projectFetchedResult(info.Name))
+}
+
+// projectSource renders a registration's directory for the SOURCE column:
+// "personal" for ~/.grove/projects, otherwise the path with $HOME as ~.
+func projectSource(dir string) string {
+	if filepath.Clean(dir) == filepath.Clean(registry.PersonalDir(rootDir())) {
+		return "personal"
+	}
+	home, _ := os.UserHomeDir()
+	return tildePath(dir, home)
+}
+
+// cmdProjectDelete handles: grove project delete <name> [--keep-volumes] [--keep-images]
+//
+// Prompts for confirmation, then has the daemon drop the project's
+// instances, remove its leftover containers, Docker volumes and built images,
+// and delete ~/.grove/projects/<name>/, streaming its progress.
+func cmdProjectDelete() {
+	rawArgs, keepVolumes := stripBoolFlag(os.Args[3:], "keep-volumes", "keep-volumes")
+	rawArgs, keepImages := stripBoolFlag(rawArgs, "keep-images", "keep-images")
+	if len(rawArgs) != 1 || rawArgs[0] == "" {
+		fmt.Fprintln(os.Stderr, "usage: grove project delete <name|#> [--keep-volumes] [--keep-images]")
+		os.Exit(1)
+	}
+	info, err := lookupProject(rawArgs[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+		os.Exit(1)
+	}
+	name := info.Name
 
-	// Count live instances so the warning can be specific.
-	var instanceCount int
-	if resp, err := tryRequest(proto.Request{Type: proto.ReqList}); err == nil {
-		for _, inst := range resp.Instances {
-			if inst.Project == name {
-				instanceCount++
-			}
-		}
+	if filepath.Clean(info.Source) != filepath.Clean(registry.PersonalDir(rootDir())) {
+		fmt.Fprintf(os.Stderr, "grove: project %q is registered in shared directory %s; remove it there\n", name, info.Source)
+		os.Exit(1)
 	}
 
+	// The instance count (from the daemon) makes the warning specific.
+	instanceCount := info.Instances
+
 	fmt.Printf("\n%s⚠  Remove project%s %s%q%s\n\n", colorYellow+colorBold, colorReset, colorCyan, name, colorReset)
 	if instanceCount > 0 {
 		fmt.Printf("  This will %sstop and remove %d instance(s)%s, delete all worktrees,\n", colorBold, instanceCount, colorReset)
-		fmt.Printf("  and remove the project.\n\n")
+		fmt.Printf("  and remove the project.\n")
 	} else {
-		fmt.Printf("  This will delete the project and %sall its worktrees%s.\n\n", colorBold, colorReset)
+		fmt.Printf("  This will delete the project and %sall its worktrees%s.\n", colorBold, colorReset)
+	}
+	var removed []string
+	if !keepVolumes {
+		removed = append(removed, "Docker volumes")
+	}
+	if !keepImages {
+		removed = append(removed, "built images")
 	}
-	fmt.Printf("%sContinue?%s [y/N] ", colorBold, colorReset)
+	if len(removed) > 0 {
+		fmt.Printf("  Its %s are removed too.\n", strings.Join(removed, " and "))
+	}
+	fmt.Printf("\n%sContinue?%s [y/N] ", colorBold, colorReset)
 
 	reader := bufio.NewReader(os.Stdin)
 	answer, _ := reader.ReadString('\n')
@@ -208,22 +444,123 @@ func cmdProjectDelete() {
 		fmt.Printf("%saborted%s\n", colorDim, colorReset)
 		return
 	}
+	fmt.Println()
 
-	// Drop all instances belonging to this project before removing the
-	// project directory, so they don't linger in watch/list.
-	if resp, err := tryRequest(proto.Request{Type: proto.ReqList}); err == nil {
-		for _, inst := range resp.Instances {
-			if inst.Project == name {
-				tryRequest(proto.Request{Type: proto.ReqDrop, InstanceID: inst.ID})
-			}
+	res, _ := streamRequest(proto.Request{
+		Type:        proto.ReqProjectDelete,
+		Project:     name,
+		KeepVolumes: keepVolumes,
+		KeepImages:  keepImages,
+	}, os.Stdout)
+	if !res.OK {
+		fmt.Fprintf(os.Stderr, "\ngrove: project %q was only partly deleted: %s\n", name, res.Error)
+		os.Exit(1)
+	}
+	printResult(projectDeletedResult(name))
+}
+
+// cmdProjectExport handles: grove project export --all | <name|#>...
+//
+// Writes a bundle of registrations (see registry.Bundle) to stdout, for
+// grove project import-bundle on another machine.  --all exports the
+// personal registrations; shared ones are reached through
+// GROVE_PROJECTS_PATH there as here, but can be named.  Keys that look like
+// secrets are left out, with a warning.
+func cmdProjectExport() {
+	rawArgs, all := stripBoolFlag(os.Args[3:], "all", "all")
+	if all == (len(rawArgs) > 0) {
+		fmt.Fprintln(os.Stderr, "usage: grove project export --all | <name|#>...")
+		os.Exit(1)
+	}
+	var entries []registry.Entry
+	if all {
+		entries = registry.List([]string{registry.PersonalDir(rootDir())})
+	}
+	for _, arg := range rawArgs {
+		e, err := registry.Resolve(projectDirs(), arg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+			os.Exit(1)
 		}
+		entries = append(entries, e)
 	}
 
-	if err := os.RemoveAll(projectDir); err != nil {
+	bundle := registry.Bundle{Version: registry.BundleVersion}
+	for _, e := range entries {
+		reg, removed, err := registry.Export(e)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+			os.Exit(1)
+		}
+		for _, key := range removed {
+			fmt.Fprintf(os.Stderr, "%swarning: left %s of project %q out of the bundle: it looks like a secret%s\n", colorYellow, key, e.Name, colorReset)
+		}
+		bundle.Projects = append(bundle.Projects, *reg)
+	}
+	data, err := yaml.Marshal(&bundle)
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
 		os.Exit(1)
 	}
-	fmt.Printf("\n%s✓  Deleted project%s %s%q%s\n\n", colorGreen+colorBold, colorReset, colorCyan, name, colorReset)
+	os.Stdout.Write(data)
+}
+
+// cmdProjectImportBundle handles: grove project import-bundle <file|-> [--overwrite]
+//
+// Registers the projects in a bundle from grove project export in
+// ~/.grove/projects.  A project that is registered already, here or in a
+// shared directory, is skipped unless --overwrite is given, which replaces
+// a personal registration (or shadows a shared one).
+func cmdProjectImportBundle() {
+	rawArgs, overwrite := stripBoolFlag(os.Args[3:], "overwrite", "overwrite")
+	if len(rawArgs) != 1 || rawArgs[0] == "" {
+		fmt.Fprintln(os.Stderr, "usage: grove project import-bundle <file|-> [--overwrite]")
+		os.Exit(1)
+	}
+	var data []byte
+	var err error
+	if rawArgs[0] == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(rawArgs[0])
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+		os.Exit(1)
+	}
+	bundle, err := registry.ParseBundle(data)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+		os.Exit(1)
+	}
+
+	personal := registry.PersonalDir(rootDir())
+	counts := map[string]int{}
+	for i := range bundle.Projects {
+		reg := &bundle.Projects[i]
+		name := registry.BundleName(reg)
+		outcome := registry.ImportSkipped
+		e, err := registry.Find(projectDirs(), name)
+		shared := err == nil && filepath.Clean(e.Source) != filepath.Clean(personal)
+		if !shared || overwrite {
+			if outcome, err = registry.Import(personal, reg, overwrite); err != nil {
+				fmt.Fprintf(os.Stderr, "grove: project %q: %v\n", name, err)
+				os.Exit(1)
+			}
+		}
+		counts[outcome]++
+		switch {
+		case outcome == registry.ImportCreated:
+			printResult(projectCreatedResult(name))
+		case outcome == registry.ImportReplaced:
+			printResult(projectUpdatedResult(name))
+		case shared:
+			fmt.Printf("%sskipped %q: registered in shared directory %s (--overwrite shadows it)%s\n", colorDim, name, e.Source, colorReset)
+		default:
+			fmt.Printf("%sskipped %q: already registered (--overwrite replaces it)%s\n", colorDim, name, colorReset)
+		}
+	}
+	fmt.Printf("\n%d created, %d replaced, %d skipped\n", counts[registry.ImportCreated], counts[registry.ImportReplaced], counts[registry.ImportSkipped])
 }
 
 func cmdProjectDir() {
@@ -232,5 +569,222 @@ func cmdProjectDir() {
 		os.Exit(1)
 	}
 	project := resolveProject(os.Args[3])
-	fmt.Println(filepath.Join(rootDir(), "projects", project, "main"))
+	fmt.Println(projectMainDir(project))
+}
+
+// cmdProjectMove handles: grove project move <name|#> <path>
+//
+// Has the daemon move the project's main checkout and worktrees into path,
+// an existing directory such as one on an external volume, and record it
+// as the registration's data_dir.  Moving them back to
+// ~/.grove/projects/<name> removes the data_dir.  None of the project's
+// instances may be live; their containers are removed, for restart
+// --recreate-container to make anew against the moved worktree.
+func cmdProjectMove() {
+	if len(os.Args) != 5 || os.Args[3] == "" || os.Args[4] == "" {
+		fmt.Fprintln(os.Stderr, "usage: grove project move <name|#> <path>")
+		os.Exit(1)
+	}
+	info, err := lookupProject(os.Args[3])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+		os.Exit(1)
+	}
+	dir, err := existingDir(os.Args[4])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+		os.Exit(1)
+	}
+	res, _ := streamRequest(proto.Request{Type: proto.ReqProjectMove, Project: info.Name, DataDir: dir}, os.Stdout)
+	if !res.OK {
+		fmt.Fprintf(os.Stderr, "grove: could not move project %q: %s\n", info.Name, res.Error)
+		os.Exit(1)
+	}
+	printResult(projectMovedResult(info.Name))
+}
+
+// existingDir returns the absolute path of dir, which must be an existing
+// directory.
+func existingDir(dir string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	if fi, err := os.Stat(abs); err != nil || !fi.IsDir() {
+		return "", fmt.Errorf("%s is not an existing directory", abs)
+	}
+	return abs, nil
+}
+
+// cmdProjectShell handles: grove project shell <name|#> [--no-lock]
+//
+// Runs $SHELL in the project's main checkout, holding the project's lock
+// in the daemon meanwhile, so that git work there (a cherry-pick, a branch
+// checkout) does not race a start's pull or worktree add.  --no-lock skips
+// the lock, for looking around without changing anything.
+func cmdProjectShell() {
+	rawArgs, noLock := stripBoolFlag(os.Args[3:], "no-lock", "no-lock")
+	if len(rawArgs) != 1 || rawArgs[0] == "" {
+		fmt.Fprintln(os.Stderr, "usage: grove project shell <name|#> [--no-lock]")
+		os.Exit(1)
+	}
+	name := resolveProject(rawArgs[0])
+	mainDir := projectMainDir(name)
+
+	if !noLock {
+		_, lock, err := daemonClient().Open(context.Background(), proto.Request{Type: proto.ReqProjectLock, Project: name})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "grove: %s\n", errorText(err))
+			os.Exit(1)
+		}
+		defer lock.Close()
+		fmt.Fprintf(os.Stderr, "%s[grove] project %s is locked until you exit this shell; starts and pulls wait for it%s\n", colorDim, name, colorReset)
+	}
+
+	shell := os.Getenv("SHELL")
+	if shell == "" {
+		shell = "/bin/sh"
+	}
+	cmd := exec.Command(shell)
+	cmd.Dir = mainDir
+	cmd.Env = append(os.Environ(), "GROVE_PROJECT="+name)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	// Ctrl-C and Ctrl-\ in the shell reach grove too; grove must outlive
+	// the shell to hold the lock.  (The shell gets the default handlers.)
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGQUIT)
+	err := cmd.Run()
+	signal.Stop(sigs)
+	code := 0
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		code = exitErr.ExitCode()
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+		code = 1
+	}
+	if !noLock {
+		fmt.Fprintf(os.Stderr, "%s[grove] project %s is unlocked%s\n", colorDim, name, colorReset)
+	}
+	if code != 0 {
+		// os.Exit skips the deferred Close; exiting closes the connection.
+		os.Exit(code)
+	}
+}
+
+// projectMainDir returns the main checkout path for a registered project.
+// Exits with an error if the project is not registered or has not been
+// cloned yet, so the path is always safe to cd into.
+func projectMainDir(name string) string {
+	e, err := registry.Find(projectDirs(), name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grove: project %q not found\n", name)
+		os.Exit(1)
+	}
+	// The checkout lives in the personal root even for shared
+	// registrations, unless the registration has a data_dir.
+	dataDir := registry.DataDir(rootDir(), e)
+	if _, err := os.Stat(dataDir); err != nil && e.DataDir != "" {
+		fmt.Fprintf(os.Stderr, "grove: project %q keeps its data in %s, which is not available; is its volume mounted?\n", name, dataDir)
+		os.Exit(1)
+	}
+	mainDir := filepath.Join(dataDir, "main")
+	if _, err := os.Stat(mainDir); err != nil {
+		fmt.Fprintf(os.Stderr, "grove: project %q has not been cloned yet (start an instance first)\n", name)
+		os.Exit(1)
+	}
+	return mainDir
+}
+
+// cmdWorktrees handles: grove worktrees <project|#> [--json] [--prune-orphans]
+//
+// Lists the project's git worktrees as the daemon finds them, each matched
+// with the instance it belongs to.  --prune-orphans removes, after
+// confirmation, the worktrees no instance refers to.
+func cmdWorktrees() {
+	fs := flag.NewFlagSet("worktrees", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the worktrees as JSON")
+	prune := fs.Bool("prune-orphans", false, "remove worktrees no instance refers to, after confirmation")
+	fs.Usage = func() { fmt.Fprintln(os.Stderr, "usage: grove worktrees <project|#> [--json] [--prune-orphans]") }
+	args, _ := parseArgs(fs, os.Args[2:])
+	if len(args) != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	name := resolveProject(args[0])
+	resp := mustRequest(proto.Request{Type: proto.ReqWorktrees, Project: name})
+
+	if *prune {
+		var orphans []string
+		for _, wt := range resp.Worktrees {
+			if wt.Status == proto.WorktreeOrphan {
+				orphans = append(orphans, wt.Path)
+			}
+		}
+		if len(orphans) == 0 {
+			fmt.Printf("%sno orphaned worktrees%s\n", colorDim, colorReset)
+			return
+		}
+		fmt.Printf("\n%s⚠  Prune%s — these worktrees belong to no instance and will be removed (their branches are kept):\n\n", colorYellow+colorBold, colorReset)
+		for _, path := range orphans {
+			fmt.Printf("  %s%s%s\n", colorCyan, path, colorReset)
+		}
+		fmt.Printf("\n%sContinue?%s [y/N] ", colorBold, colorReset)
+		reader := bufio.NewReader(os.Stdin)
+		answer, _ := reader.ReadString('\n')
+		answer = strings.TrimSpace(answer)
+		if answer != "y" && answer != "Y" {
+			fmt.Printf("%saborted%s\n", colorDim, colorReset)
+			return
+		}
+		fmt.Println()
+		var err error
+		resp, err = tryRequest(proto.Request{Type: proto.ReqWorktrees, Project: name, PruneWorktrees: orphans})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if *asJSON {
+		data, _ := json.MarshalIndent(resp.Worktrees, "", "  ")
+		fmt.Println(string(data))
+	} else {
+		fmt.Print(renderWorktrees(resp.Worktrees))
+	}
+	if !resp.OK {
+		fmt.Fprintf(os.Stderr, "grove: %s\n", resp.Error)
+		os.Exit(1)
+	}
+}
+
+// renderWorktrees renders grove worktrees' table.  Worktrees that need
+// attention are coloured: orphans yellow, missing ones red.
+func renderWorktrees(wts []proto.WorktreeInfo) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s%-8s  %-4s  %-24s  %s%s\n", colorBold, "STATUS", "ID", "BRANCH", "PATH", colorReset)
+	fmt.Fprintf(&b, "%s%-8s  %-4s  %-24s  %s%s\n", colorDim, "--------", "----", "------------------------", "----", colorReset)
+	for _, wt := range wts {
+		color := ""
+		switch wt.Status {
+		case proto.WorktreeOrphan, proto.WorktreeUnlisted:
+			color = colorYellow
+		case proto.WorktreeMissing:
+			color = colorRed
+		}
+		id, branch := wt.InstanceID, wt.Branch
+		if id == "" {
+			id = "-"
+		}
+		if branch == "" {
+			branch = "(detached)"
+		}
+		path := wt.Path
+		if wt.Locked {
+			path += " (locked)"
+		}
+		fmt.Fprintf(&b, "%s%-8s%s  %-4s  %-24s  %s\n", color, wt.Status, colorReset, id, truncate(branch, 24), path)
+	}
+	return b.String()
 }
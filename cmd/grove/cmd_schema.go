@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/gandalfthegui/grove/internal/daemon"
+)
+
+// Exit codes of grove validate.
+const (
+	validateOK       = 0
+	validateErrors   = 1 // the file would not load
+	validateWarnings = 2 // it loads, but some settings look wrong
+)
+
+// cmdSchema handles: grove schema
+//
+// Prints the JSON Schema of grove.yaml, for editors and CI.
+func cmdSchema() {
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: grove schema")
+		os.Exit(1)
+	}
+	os.Stdout.Write(daemon.ConfigSchema())
+}
+
+// cmdValidate handles: grove validate [path]
+//
+// Checks a grove.yaml against the schema and for settings that load but are
+// probably mistakes.  Without a path it checks .grove/grove.yaml or
+// grove.yaml in the current directory.  It needs neither the daemon nor a
+// registered project.
+func cmdValidate() {
+	args := os.Args[2:]
+	if len(args) > 1 {
+		fmt.Fprintln(os.Stderr, "usage: grove validate [path]")
+		os.Exit(1)
+	}
+	path := ""
+	if len(args) == 1 {
+		path = args[0]
+	} else {
+		for _, p := range []string{".grove/grove.yaml", "grove.yaml"} {
+			if _, err := os.Stat(p); err == nil {
+				path = p
+				break
+			}
+		}
+		if path == "" {
+			fmt.Fprintln(os.Stderr, "grove: no .grove/grove.yaml or grove.yaml in the current directory")
+			os.Exit(validateErrors)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+		os.Exit(validateErrors)
+	}
+	os.Exit(reportValidation(path, daemon.ValidateConfig(data)))
+}
+
+// reportValidation prints r for the file at path and returns the exit code
+// of grove validate.
+func reportValidation(path string, r daemon.ConfigReport) int {
+	for _, e := range r.Errors {
+		fmt.Fprintf(os.Stderr, "%s: error: %s\n", path, e)
+	}
+	for _, w := range r.Warnings {
+		fmt.Fprintf(os.Stderr, "%s: warning: %s\n", path, w)
+	}
+	switch {
+	case len(r.Errors) > 0:
+		return validateErrors
+	case len(r.Warnings) > 0:
+		return validateWarnings
+	}
+	fmt.Printf("%s: ok\n", path)
+	return validateOK
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+// cmdScratch handles: grove scratch <path|url> [--branch <name>] [--in-place] [-d | --attach | --detach-on-idle <duration>] [--agent <command>] [--task <text>] [--label key=value ...]
+//
+// Starts an instance on a repo URL or local directory without registering
+// a project.  The daemon clones it under ~/.grove/scratch/<id>, or with
+// --in-place mounts the directory itself; grove drop removes the clone.
+func cmdScratch() {
+	rawArgs, detach := stripBoolFlag(os.Args[2:], "d", "detach")
+	rawArgs, attach := stripBoolFlag(rawArgs, "attach", "attach")
+	rawArgs, inPlace := stripBoolFlag(rawArgs, "in-place", "in-place")
+	rawArgs, detachOnIdle := stripDetachOnIdle(rawArgs)
+	rawArgs, branch := stripOnceFlag(rawArgs, "branch")
+	rawArgs, agent := stripOnceFlag(rawArgs, "agent")
+	rawArgs, task := stripOnceFlag(rawArgs, "task")
+	rawArgs, labelArgs, err := stripValueFlag(rawArgs, "label")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+		os.Exit(1)
+	}
+	labels, err := parseLabels(labelArgs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+		os.Exit(1)
+	}
+	fs := flag.NewFlagSet("scratch", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: grove scratch <path|url> [--branch <name>] [--in-place] [-d | --attach | --detach-on-idle <duration>] [--agent <command>] [--task <text>] [--label key=value ...]")
+	}
+	args, _ := parseArgs(fs, rawArgs)
+	if len(args) != 1 || args[0] == "" {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if detach && (attach || detachOnIdle > 0) {
+		fmt.Fprintln(os.Stderr, "grove: -d cannot be combined with --attach or --detach-on-idle")
+		os.Exit(1)
+	}
+	if inPlace && branch != nil {
+		fmt.Fprintln(os.Stderr, "grove: --in-place works on the branch checked out in the directory; drop --branch")
+		os.Exit(1)
+	}
+
+	// The daemon does not share our working directory.
+	source := args[0]
+	if _, err := os.Stat(source); err == nil {
+		if source, err = filepath.Abs(source); err != nil {
+			fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	req := proto.Request{Type: proto.ReqStart, Scratch: source, InPlace: inPlace, Labels: labels}
+	if branch != nil {
+		req.Branch = *branch
+	}
+	if agent != nil {
+		req.Agent = *agent
+	}
+	if task != nil {
+		req.Task = *task
+	}
+	req.AgentEnv = ensureAgentCredentials(startOptions{Agent: req.Agent}.agentCommand(proto.ProjectInfo{}))
+	startInstance(req, detach, detachOnIdle, false)
+}
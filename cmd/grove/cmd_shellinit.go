@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// cmdShellInit handles: grove shell-init <bash|zsh|fish>
+//
+// Prints a shell snippet defining gcd, a cd wrapper around 'grove dir', plus
+// tab completion for instance IDs and project names.  Intended usage:
+//
+//	eval "$(grove shell-init bash)"     # ~/.bashrc
+//	eval "$(grove shell-init zsh)"      # ~/.zshrc
+//	grove shell-init fish | source      # ~/.config/fish/config.fish
+func cmdShellInit() {
+	if len(os.Args) != 3 {
+		fmt.Fprintln(os.Stderr, "usage: grove shell-init <bash|zsh|fish>")
+		os.Exit(1)
+	}
+	snippet, ok := shellInitSnippet(os.Args[2])
+	if !ok {
+		fmt.Fprintf(os.Stderr, "grove: unsupported shell %q (want bash, zsh, or fish)\n", os.Args[2])
+		os.Exit(1)
+	}
+	fmt.Print(snippet)
+}
+
+// shellInitSnippet returns the gcd definition for shell.
+func shellInitSnippet(shell string) (string, bool) {
+	switch shell {
+	case "bash":
+		return shellInitPOSIX + shellInitBashCompletion, true
+	case "zsh":
+		return shellInitPOSIX + shellInitZshCompletion, true
+	case "fish":
+		return shellInitFish, true
+	}
+	return "", false
+}
+
+// shellInitPOSIX defines gcd for bash and zsh.  grove dir prints nothing on
+// stdout and exits non-zero when the target cannot be resolved, so the cd
+// only runs on success and the error message reaches the terminal.
+const shellInitPOSIX = `# grove shell integration
+#   gcd <instance-id>        cd into an instance worktree
+#   gcd -p <project|#>       cd into a project's main checkout
+gcd() {
+  local dir
+  dir="$(command grove dir "$@")" || return $?
+  [ -n "$dir" ] || return 1
+  cd -- "$dir"
+}
+
+_grove_instance_ids() {
+  command grove list 2>/dev/null | awk 'NR > 2 { print $1 }'
+}
+
+_grove_project_names() {
+  command grove project list 2>/dev/null | awk 'NR > 2 { print $2 }'
+}
+`
+
+const shellInitBashCompletion = `
+_gcd_complete() {
+  local cur prev
+  cur="${COMP_WORDS[COMP_CWORD]}"
+  prev="${COMP_WORDS[COMP_CWORD-1]}"
+  if [ "$prev" = "-p" ] || [ "$prev" = "--project" ]; then
+    COMPREPLY=($(compgen -W "$(_grove_project_names)" -- "$cur"))
+  else
+    COMPREPLY=($(compgen -W "$(_grove_instance_ids)" -- "$cur"))
+  fi
+}
+complete -F _gcd_complete gcd
+`
+
+const shellInitZshCompletion = `
+_gcd_complete() {
+  if [[ "${words[CURRENT-1]}" == "-p" || "${words[CURRENT-1]}" == "--project" ]]; then
+    compadd -- ${(f)"$(_grove_project_names)"}
+  else
+    compadd -- ${(f)"$(_grove_instance_ids)"}
+  fi
+}
+if (( $+functions[compdef] )); then
+  compdef _gcd_complete gcd
+fi
+`
+
+const shellInitFish = `# grove shell integration
+#   gcd <instance-id>        cd into an instance worktree
+#   gcd -p <project|#>       cd into a project's main checkout
+function gcd
+    set -l dir (command grove dir $argv)
+    or return $status
+    test -n "$dir"; or return 1
+    cd -- $dir
+end
+
+complete -c gcd -f -n 'not __fish_seen_argument -s p -l project' -a '(command grove list 2>/dev/null | awk "NR > 2 { print \$1 }")'
+complete -c gcd -f -s p -l project -r -a '(command grove project list 2>/dev/null | awk "NR > 2 { print \$2 }")'
+`
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/gandalfthegui/grove/internal/linediff"
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+// cmdShowConfig handles: grove show-config <instance-id> [--diff]
+//
+// Prints the effective config the instance was started with (or last
+// relaunched with --current-config).  With --diff, compares it with the
+// config the instance would get from its project now.
+func cmdShowConfig() {
+	rawArgs, diff := stripBoolFlag(os.Args[2:], "diff", "diff")
+	if len(rawArgs) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: grove show-config <instance-id> [--diff]")
+		os.Exit(1)
+	}
+	instanceID := resolveInstanceArg(rawArgs[0])
+
+	resp := mustRequest(proto.Request{Type: proto.ReqShowConfig, InstanceID: instanceID})
+	if !diff {
+		fmt.Print(resp.Config)
+		return
+	}
+	if resp.ProjectConfig == "" {
+		fmt.Fprintf(os.Stderr, "grove: could not load the current config of instance %s's project\n", instanceID)
+		os.Exit(1)
+	}
+	if resp.Config == resp.ProjectConfig {
+		fmt.Printf("%sno changes: the project's config is the one instance %s runs with%s\n", colorDim, instanceID, colorReset)
+		return
+	}
+
+	fmt.Printf("%s--- instance %s (recorded)%s\n", colorBold, instanceID, colorReset)
+	fmt.Printf("%s+++ project (current)%s\n", colorBold, colorReset)
+	for _, line := range linediff.Lines(linediff.Split(resp.Config), linediff.Split(resp.ProjectConfig)) {
+		switch line[0] {
+		case '-':
+			fmt.Printf("%s%s%s\n", colorRed, line, colorReset)
+		case '+':
+			fmt.Printf("%s%s%s\n", colorGreen, line, colorReset)
+		default:
+			fmt.Printf("%s%s%s\n", colorDim, line, colorReset)
+		}
+	}
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gandalfthegui/grove/internal/history"
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+// cmdStats handles:
+//
+//	grove stats [project]
+//	grove stats --timings [--project <name>] [--csv]
+//	grove stats --record on|off
+//
+// Without --timings it prints, per project (or for one, given by name or
+// number), the median time each stage of grove start took over the
+// instances the daemon knows of.  Instances started by daemons that did
+// not record timings are left out.  Below that it prints what the agents
+// of each project cost, for projects with a usage command (see
+// agent.usage_command).
+//
+// --timings summarises the timing history instead (see package history),
+// which outlives dropped instances and also holds check runs and agent
+// sessions.  --record turns that history on or off; it is off by default.
+func cmdStats() {
+	const usage = "usage: grove stats [project] | grove stats --timings [--project <name>] [--csv] | grove stats --record on|off"
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	timings := fs.Bool("timings", false, "summarise the timing history: p50/p90 of start stages, checks and agent sessions")
+	project := fs.String("project", "", "only this project")
+	csvOut := fs.Bool("csv", false, "with --timings: print CSV, for spreadsheets")
+	record := fs.String("record", "", "on or off: record the timing history, or stop and delete it")
+	fs.Usage = func() { fmt.Fprintln(os.Stderr, usage) }
+	args, _ := parseArgs(fs, os.Args[2:])
+	if len(args) > 1 || (len(args) == 1 && *project != "") {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if len(args) == 1 {
+		*project = args[0]
+	}
+	if *record != "" {
+		if *timings || *csvOut || *project != "" {
+			fs.Usage()
+			os.Exit(1)
+		}
+		setTimingHistory(*record)
+		return
+	}
+	if *csvOut && !*timings {
+		fmt.Fprintln(os.Stderr, "grove: --csv needs --timings")
+		os.Exit(1)
+	}
+	if *project != "" {
+		// A deleted project's instances and history are still kept by name.
+		if info, err := lookupProject(*project); err == nil {
+			*project = info.Name
+		}
+	}
+	if *timings {
+		printTimingHistory(*project, *csvOut)
+		return
+	}
+
+	resp := mustRequest(proto.Request{Type: proto.ReqList})
+	instances := resp.Instances
+	if *project != "" {
+		instances = nil
+		for _, inst := range resp.Instances {
+			if inst.Project == *project {
+				instances = append(instances, inst)
+			}
+		}
+	}
+	stats := startStats(instances)
+	if len(stats) == 0 {
+		fmt.Println("no recorded starts")
+	} else {
+		fmt.Print(renderStartStats(stats))
+	}
+	if usage := usageStats(instances); len(usage) > 0 {
+		fmt.Println()
+		fmt.Print(renderUsageStats(usage))
+	}
+}
+
+// setTimingHistory handles grove stats --record on|off.
+func setTimingHistory(state string) {
+	root := rootDir()
+	switch state {
+	case "on":
+		if err := history.Enable(root); err != nil {
+			fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Recording start, check and agent session timings in %s.\n", history.Path(root))
+		fmt.Println("Only project names and durations are kept, and nothing leaves this machine.")
+		fmt.Printf("%sgrove stats --timings%s summarises them; %sgrove stats --record off%s stops and deletes them.\n", colorBold, colorReset, colorBold, colorReset)
+	case "off":
+		if err := history.Disable(root); err != nil {
+			fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("Timing history off; what was recorded is deleted.")
+	default:
+		fmt.Fprintf(os.Stderr, "grove: --record takes on or off, not %q\n", state)
+		os.Exit(1)
+	}
+}
+
+// printTimingHistory handles grove stats --timings.
+func printTimingHistory(project string, csvOut bool) {
+	f, err := os.Open(history.Path(rootDir()))
+	if errors.Is(err, os.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "grove: the timing history is off; %sgrove stats --record on%s starts recording it\n", colorBold, colorReset)
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+		os.Exit(1)
+	}
+	recs, err := history.Read(f)
+	f.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grove: read timing history: %v\n", err)
+		os.Exit(1)
+	}
+	if project != "" {
+		var mine []history.Record
+		for _, rec := range recs {
+			if rec.Project == project {
+				mine = append(mine, rec)
+			}
+		}
+		recs = mine
+	}
+	projects := history.Summarize(recs)
+	if csvOut {
+		exitIfBrokenPipe(writeTimingsCSV(os.Stdout, projects))
+		return
+	}
+	if len(projects) == 0 {
+		fmt.Println("no recorded timings")
+		return
+	}
+	fmt.Print(renderTimings(projects))
+}
+
+// timingRows lists the rows of p's timing summary: the whole start, then
+// its stages in order, then check runs and agent sessions.  Timings never
+// recorded are left out.
+func timingRows(p history.Project) []timingRow {
+	var rows []timingRow
+	add := func(name string, stage bool, d history.Distribution) {
+		if d.N > 0 {
+			rows = append(rows, timingRow{name, stage, d})
+		}
+	}
+	add("start", false, p.Start)
+	stages := append([]string(nil), proto.StartStages...)
+	var other []string
+	for stage := range p.Stages {
+		if !slices.Contains(stages, stage) {
+			other = append(other, stage)
+		}
+	}
+	sort.Strings(other)
+	for _, stage := range append(stages, other...) {
+		add(stage, true, p.Stages[stage])
+	}
+	add("check", false, p.Check)
+	add("session", false, p.Session)
+	return rows
+}
+
+// timingRow is one line of grove stats --timings: a start stage, or a
+// whole start, check run or agent session.
+type timingRow struct {
+	Name  string
+	Stage bool
+	Dist  history.Distribution
+}
+
+// renderTimings prints projects as a table per project for grove stats
+// --timings, flagging each project's slowest start stage.
+func renderTimings(projects []history.Project) string {
+	var b strings.Builder
+	for i, p := range projects {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "%s%-20s  %5s  %7s  %7s%s\n", colorBold, p.Project, "N", "P50", "P90", colorReset)
+		for _, r := range timingRows(p) {
+			name := "  " + r.Name
+			if r.Stage {
+				name = "    " + r.Name
+			}
+			fmt.Fprintf(&b, "%-20s  %5d  %7s  %7s", name, r.Dist.N, formatStageDuration(r.Dist.P50), formatStageDuration(r.Dist.P90))
+			if r.Stage && r.Name == p.Slowest {
+				fmt.Fprintf(&b, "  %s← slowest stage%s", colorYellow, colorReset)
+			}
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+// writeTimingsCSV writes projects as CSV for grove stats --timings --csv:
+// one row per project and timing, stages named "stage:<name>", durations
+// in milliseconds.
+func writeTimingsCSV(w io.Writer, projects []history.Project) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"project", "timing", "n", "p50_ms", "p90_ms", "slowest_stage"})
+	for _, p := range projects {
+		for _, r := range timingRows(p) {
+			name, slowest := r.Name, ""
+			if r.Stage {
+				name = "stage:" + r.Name
+				if r.Name == p.Slowest {
+					slowest = "yes"
+				}
+			}
+			cw.Write([]string{p.Project, name, strconv.Itoa(r.Dist.N),
+				strconv.FormatInt(r.Dist.P50.Milliseconds(), 10), strconv.FormatInt(r.Dist.P90.Milliseconds(), 10), slowest})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// projectStartStats are the median stage durations of a project's starts.
+type projectStartStats struct {
+	Project string
+	Starts  int
+	Stages  map[string]time.Duration // median per stage, for stages that ran
+	Total   time.Duration            // median of the whole starts
+}
+
+// startStats summarises the recorded start timings of instances per
+// project, ordered by project name.
+func startStats(instances []proto.InstanceInfo) []projectStartStats {
+	type samples struct {
+		stages map[string][]time.Duration
+		totals []time.Duration
+	}
+	byProject := map[string]*samples{}
+	for _, inst := range instances {
+		if len(inst.StartTimings) == 0 {
+			continue
+		}
+		s := byProject[inst.Project]
+		if s == nil {
+			s = &samples{stages: map[string][]time.Duration{}}
+			byProject[inst.Project] = s
+		}
+		for _, t := range inst.StartTimings {
+			s.stages[t.Stage] = append(s.stages[t.Stage], time.Duration(t.Duration)*time.Millisecond)
+		}
+		s.totals = append(s.totals, proto.StartDuration(inst.StartTimings))
+	}
+
+	stats := make([]projectStartStats, 0, len(byProject))
+	for project, s := range byProject {
+		ps := projectStartStats{Project: project, Starts: len(s.totals), Stages: map[string]time.Duration{}, Total: median(s.totals)}
+		for stage, ds := range s.stages {
+			ps.Stages[stage] = median(ds)
+		}
+		stats = append(stats, ps)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Project < stats[j].Project })
+	return stats
+}
+
+// median returns the median of ds, which must not be empty.  ds is sorted.
+func median(ds []time.Duration) time.Duration {
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+	n := len(ds)
+	if n%2 == 1 {
+		return ds[n/2]
+	}
+	return (ds[n/2-1] + ds[n/2]) / 2
+}
+
+// renderStartStats prints stats as a table for grove stats.
+func renderStartStats(stats []projectStartStats) string {
+	projW := len("PROJECT")
+	for _, s := range stats {
+		projW = max(projW, len(s.Project))
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s%-*s  %6s", colorBold, projW, "PROJECT", "STARTS")
+	for _, stage := range proto.StartStages {
+		fmt.Fprintf(&b, "  %*s", stageColumnWidth(stage), strings.ToUpper(stage))
+	}
+	fmt.Fprintf(&b, "  %7s%s\n", "TOTAL", colorReset)
+	for _, s := range stats {
+		fmt.Fprintf(&b, "%-*s  %6d", projW, s.Project, s.Starts)
+		for _, stage := range proto.StartStages {
+			cell := "-"
+			if d, ok := s.Stages[stage]; ok {
+				cell = formatStageDuration(d)
+			}
+			fmt.Fprintf(&b, "  %*s", stageColumnWidth(stage), cell)
+		}
+		fmt.Fprintf(&b, "  %7s\n", formatStageDuration(s.Total))
+	}
+	return b.String()
+}
+
+// stageColumnWidth is the width of stage's column in grove stats: its
+// heading, and room for a duration.
+func stageColumnWidth(stage string) int {
+	return max(len(stage), 7)
+}
+
+// projectUsage is what a project's agents have cost, over the instances
+// the daemon knows of whose usage was measured.
+type projectUsage struct {
+	Project string
+	Known   int // instances whose cost is known
+	Unknown int // instances whose cost could not be measured
+	CostUSD float64
+}
+
+// usageStats totals the measured usage of instances per project, ordered
+// by project name.
+func usageStats(instances []proto.InstanceInfo) []projectUsage {
+	byProject := map[string]*projectUsage{}
+	for _, inst := range instances {
+		if inst.Usage == nil {
+			continue
+		}
+		u := byProject[inst.Project]
+		if u == nil {
+			u = &projectUsage{Project: inst.Project}
+			byProject[inst.Project] = u
+		}
+		if inst.Usage.Known {
+			u.Known++
+			u.CostUSD += inst.Usage.CostUSD
+		} else {
+			u.Unknown++
+		}
+	}
+	usage := make([]projectUsage, 0, len(byProject))
+	for _, u := range byProject {
+		usage = append(usage, *u)
+	}
+	sort.Slice(usage, func(i, j int) bool { return usage[i].Project < usage[j].Project })
+	return usage
+}
+
+// renderUsageStats prints usage as a table for grove stats.
+func renderUsageStats(usage []projectUsage) string {
+	projW := len("PROJECT")
+	for _, u := range usage {
+		projW = max(projW, len(u.Project))
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s%-*s  %9s  %9s  %7s%s\n", colorBold, projW, "PROJECT", "INSTANCES", "COST", "UNKNOWN", colorReset)
+	for _, u := range usage {
+		cost := "-"
+		if u.Known > 0 {
+			cost = formatCost(u.CostUSD)
+		}
+		fmt.Fprintf(&b, "%-*s  %9d  %9s  %7d\n", projW, u.Project, u.Known+u.Unknown, cost, u.Unknown)
+	}
+	return b.String()
+}
+
+// renderStartTimings prints an instance's start timings for grove status,
+// one stage per line after the total.
+func renderStartTimings(timings []proto.StageTiming) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "  %sStart:%s     %s\n", colorDim, colorReset, formatStageDuration(proto.StartDuration(timings)))
+	for _, t := range timings {
+		fmt.Fprintf(&b, "    %s%-13s%s %7s\n", colorDim, t.Stage, colorReset,
+			formatStageDuration(time.Duration(t.Duration)*time.Millisecond))
+	}
+	return b.String()
+}
+
+// formatStageDuration renders d to the precision that matters for it:
+// "350ms", "2.1s", "4m12s".
+func formatStageDuration(d time.Duration) string {
+	switch {
+	case d < time.Second:
+		return fmt.Sprintf("%dms", d.Milliseconds())
+	case d < time.Minute:
+		return fmt.Sprintf("%.1fs", d.Seconds())
+	}
+	return d.Round(time.Second).String()
+}
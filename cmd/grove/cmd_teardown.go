@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+// cmdContainer handles: grove container rm <instance-id>
+//
+// Removes a stopped instance's container, or its compose stack, keeping the
+// worktree and the instance.  restart --recreate-container and reopen
+// create a new one.
+func cmdContainer() {
+	const usage = "usage: grove container rm <instance-id>"
+	if len(os.Args) != 4 || os.Args[2] != "rm" {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(1)
+	}
+	instanceID := resolveInstanceArg(os.Args[3])
+	mustRequest(proto.Request{Type: proto.ReqContainerRm, InstanceID: instanceID})
+	printResult(containerRemovedResult(instanceID))
+}
+
+// cmdWorktree handles: grove worktree rm <instance-id> [-f]
+//
+// Removes a stopped instance's worktree, keeping its branch, its container
+// and the instance.  Uncommitted changes in the worktree are lost, so it
+// asks first unless -f.  restart --recreate-container and reopen check the
+// branch out again.
+func cmdWorktree() {
+	const usage = "usage: grove worktree rm <instance-id> [-f]"
+	args, force := stripBoolFlag(os.Args[2:], "f", "force")
+	if len(args) != 2 || args[0] != "rm" {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(1)
+	}
+	found := resolveInstance(args[1])
+	instanceID := found.ID
+
+	if !force {
+		fmt.Printf("\n%sInstance%s %s%s%s\n\n", colorBold, colorReset, colorCyan, instanceID, colorReset)
+		fmt.Printf("  %sWorktree:%s %s%s%s\n", colorDim, colorReset, colorCyan, found.WorktreeDir, colorReset)
+		fmt.Printf("  %sBranch:%s   %s%s%s (kept)\n\n", colorDim, colorReset, colorCyan, found.Branch, colorReset)
+		fmt.Printf("%sDelete the worktree, with any uncommitted changes?%s [y/N] ", colorBold, colorReset)
+
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		answer = strings.TrimSpace(answer)
+		if answer != "y" && answer != "Y" {
+			fmt.Printf("%saborted%s\n", colorDim, colorReset)
+			return
+		}
+	}
+
+	mustRequest(proto.Request{Type: proto.ReqWorktreeRm, InstanceID: instanceID})
+	printResult(worktreeRemovedResult(instanceID))
+}
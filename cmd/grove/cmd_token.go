@@ -4,10 +4,12 @@ import (
 	"bufio"
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 
 	"github.com/gandalfthegui/grove/internal/envfile"
+	"github.com/gandalfthegui/grove/pkg/proto"
 	"gopkg.in/yaml.v3"
 )
 
@@ -39,28 +41,9 @@ func cmdToken() {
 		return
 	}
 
-	// Re-write the env file, stripping any existing CLAUDE_CODE_OAUTH_TOKEN
-	// lines so we don't accumulate duplicates.
-	existing, _ := os.ReadFile(envPath)
-	var kept []string
-	for _, line := range strings.Split(string(existing), "\n") {
-		if strings.HasPrefix(strings.TrimSpace(line), "CLAUDE_CODE_OAUTH_TOKEN=") {
-			continue
-		}
-		kept = append(kept, line)
-	}
-	// Drop trailing blank lines before appending the new entry.
-	for len(kept) > 0 && strings.TrimSpace(kept[len(kept)-1]) == "" {
-		kept = kept[:len(kept)-1]
-	}
-	kept = append(kept, "CLAUDE_CODE_OAUTH_TOKEN="+token)
-	content := strings.Join(kept, "\n") + "\n"
-
-	if err := os.MkdirAll(root, 0o755); err != nil {
-		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
-		os.Exit(1)
-	}
-	if err := os.WriteFile(envPath, []byte(content), 0o600); err != nil {
+	// Replace any existing CLAUDE_CODE_OAUTH_TOKEN entry in place so we don't
+	// accumulate duplicates; comments and other keys are preserved.
+	if err := envfile.Set(envPath, "CLAUDE_CODE_OAUTH_TOKEN", token); err != nil {
 		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
 		os.Exit(1)
 	}
@@ -68,18 +51,17 @@ func cmdToken() {
 	fmt.Printf("\n%s✓  Token saved%s %s%s%s\n\n", colorGreen+colorBold, colorReset, colorDim, envPath, colorReset)
 }
 
-// ensureAgentCredentials checks whether the required credentials for the
-// project's agent are available. If not, it prompts the user interactively
-// and saves the token to ~/.grove/env. Returns env vars to pass through the
-// request for this session.
+// ensureAgentCredentials checks whether the credentials required by agentCmd,
+// the agent about to be launched, are available. If not, it prompts the user
+// interactively and saves the token to ~/.grove/env. Returns env vars to pass
+// through the request for this session.
 //
 // Tokens found only in the shell environment (os.Getenv) are explicitly
 // forwarded via the return map because the daemon runs as a LaunchAgent and
 // does not inherit the user's shell environment.
-func ensureAgentCredentials(project string) map[string]string {
-	agentCmd := detectAgentCommand(project)
+func ensureAgentCredentials(agentCmd string) map[string]string {
 	// Skip only when we know for certain it is not a claude agent.
-	// If detectAgentCommand returns "" (grove.yaml unreadable, e.g. first run
+	// If agentCmd is "" (grove.yaml unreadable, e.g. first run
 	// before the repo is cloned), we still check — claude is the default and
 	// skipping silently would leave the container without credentials.
 	if agentCmd != "" && agentCmd != "claude" {
@@ -98,14 +80,7 @@ func ensureAgentCredentials(project string) map[string]string {
 	// Token found only in the shell environment: forward it explicitly so the
 	// daemon (which runs without the user's shell env) can inject it into the
 	// container.
-	agentEnv := map[string]string{}
-	if v := os.Getenv("CLAUDE_CODE_OAUTH_TOKEN"); v != "" {
-		agentEnv["CLAUDE_CODE_OAUTH_TOKEN"] = v
-	}
-	if v := os.Getenv("ANTHROPIC_API_KEY"); v != "" {
-		agentEnv["ANTHROPIC_API_KEY"] = v
-	}
-	if len(agentEnv) > 0 {
+	if agentEnv := shellCredentials(); agentEnv != nil {
 		return agentEnv
 	}
 
@@ -127,21 +102,72 @@ func ensureAgentCredentials(project string) map[string]string {
 
 	// Save to ~/.grove/env so the user never has to do this again.
 	envPath := filepath.Join(root, "env")
-	f, err := os.OpenFile(envPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
-	if err == nil {
-		fmt.Fprintf(f, "CLAUDE_CODE_OAUTH_TOKEN=%s\n", token)
-		f.Close()
+	if err := envfile.Set(envPath, "CLAUDE_CODE_OAUTH_TOKEN", token); err != nil {
+		fmt.Fprintf(os.Stderr, "grove: could not save token: %v\n", err)
+	} else {
 		fmt.Printf("\n%s✓  Saved to %s%s\n\n", colorGreen, envPath, colorReset)
 	}
 
 	return map[string]string{"CLAUDE_CODE_OAUTH_TOKEN": token}
 }
 
+// shellCredentials returns the claude credentials set in the shell
+// environment, or nil if there are none.
+func shellCredentials() map[string]string {
+	var agentEnv map[string]string
+	for _, k := range []string{"CLAUDE_CODE_OAUTH_TOKEN", "ANTHROPIC_API_KEY"} {
+		if v := os.Getenv(k); v != "" {
+			if agentEnv == nil {
+				agentEnv = make(map[string]string)
+			}
+			agentEnv[k] = v
+		}
+	}
+	return agentEnv
+}
+
+// instanceAgentCommand returns the agent command inst was started with, or
+// for instances recorded before that was kept, the project's current one.
+func instanceAgentCommand(inst *proto.InstanceInfo) string {
+	if inst.Launch != nil {
+		return inst.Launch.AgentCommand
+	}
+	return projectAgentCommand(inst.Project)
+}
+
+// projectAgentCommand returns the project's agent command as the daemon
+// reads it from grove.yaml, falling back to reading the file locally if the
+// daemon is unreachable. Returns "" if it cannot be determined.
+func projectAgentCommand(project string) string {
+	info, err := lookupProject(project)
+	if err != nil {
+		return ""
+	}
+	return info.AgentCommand
+}
+
+// projectConfigPath returns the path of the in-repo config in mainDir —
+// .grove/grove.yaml, else grove.yaml — or "" if there is neither.  It mirrors
+// the daemon's lookup.
+func projectConfigPath(mainDir string) string {
+	for _, rel := range []string{filepath.Join(".grove", "grove.yaml"), "grove.yaml"} {
+		path := filepath.Join(mainDir, rel)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
 // detectAgentCommand reads the project's grove.yaml to determine the agent
 // command. Returns "" if the file doesn't exist or has no agent configured.
+// Includes are not followed; the daemon's answer (projectAgentCommand) is
+// authoritative.
 func detectAgentCommand(project string) string {
-	root := rootDir()
-	groveYAML := filepath.Join(root, "projects", project, "main", "grove.yaml")
+	groveYAML := projectConfigPath(filepath.Join(rootDir(), "projects", project, "main"))
+	if groveYAML == "" {
+		return ""
+	}
 	data, err := os.ReadFile(groveYAML)
 	if err != nil {
 		return ""
@@ -157,48 +183,110 @@ func detectAgentCommand(project string) string {
 	return cfg.Agent.Command
 }
 
-// promptCreateProjectConfig is called when the daemon reports that the project
-// has no .grove/project.yaml in its repository. It asks the user whether to
-// create a boilerplate file, writes it if they agree, then exits with
-// instructions to edit, commit, and re-run.
-func promptCreateProjectConfig(mainDir, projectName string) {
-	configPath := filepath.Join(mainDir, "grove.yaml")
+// promptCreateProjectConfig is called when the daemon reports that the
+// project has no grove.yaml in its main checkout; resp is its refusal.  If
+// the repo has one on another branch it suggests merging that instead.  It
+// asks the user whether to create a boilerplate file, writes it if they
+// agree, and commits it in the main checkout if commit is set or they agree
+// to that too, then prints instructions to edit, commit, and re-run.
+func promptCreateProjectConfig(resp proto.Response, projectName string, commit bool) {
+	mainDir := resp.InitPath
+	configPath := filepath.Join(mainDir, ".grove", "grove.yaml")
+	reader := bufio.NewReader(os.Stdin)
 
 	fmt.Printf("\n%s⚠  No grove.yaml found in %s%s\n\n", colorYellow+colorBold, projectName, colorReset)
-	fmt.Printf("  This file tells grove how to set up the container, run the agent,\n")
-	fmt.Printf("  and finish the work. Commit it once and every grove user gets the\n")
-	fmt.Printf("  same setup automatically — no per-machine configuration needed.\n\n")
-
-	fmt.Printf("%sCreate a boilerplate now?%s [Y/n] ", colorBold, colorReset)
+	if len(resp.ConfigBranches) > 0 {
+		fmt.Printf("  The repo has one on %s, though. Merge it into the main branch\n", strings.Join(resp.ConfigBranches, ", "))
+		fmt.Printf("  rather than create another, which would conflict with it:\n\n")
+		fmt.Printf("     %sgit -C %s merge %s%s\n\n", colorDim, mainDir, resp.ConfigBranches[0], colorReset)
+		if !askYesNo(reader, "Create a boilerplate anyway?", false) {
+			fmt.Printf("%saborted%s\n", colorDim, colorReset)
+			return
+		}
+	} else {
+		fmt.Printf("  This file tells grove how to set up the container, run the agent,\n")
+		fmt.Printf("  and finish the work. Commit it once and every grove user gets the\n")
+		fmt.Printf("  same setup automatically — no per-machine configuration needed.\n\n")
+		if !askYesNo(reader, "Create a boilerplate now?", true) {
+			fmt.Printf("%saborted%s\n", colorDim, colorReset)
+			return
+		}
+	}
 
-	reader := bufio.NewReader(os.Stdin)
-	answer, _ := reader.ReadString('\n')
-	answer = strings.TrimSpace(answer)
-	if answer != "" && answer != "y" && answer != "Y" {
-		fmt.Printf("%saborted%s\n", colorDim, colorReset)
+	if err := os.MkdirAll(filepath.Dir(configPath), 0o755); err != nil {
+		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
 		return
 	}
-
 	if err := os.WriteFile(configPath, []byte(projectConfigBoilerplate), 0o644); err != nil {
 		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
 		return
 	}
-
 	fmt.Printf("\n%s✓  Created%s %s%s%s\n\n", colorGreen+colorBold, colorReset, colorCyan, configPath, colorReset)
+
+	// Left uncommitted, it works on this machine only, and pulls of the
+	// main checkout fail once someone else commits one.
+	committed := false
+	if commit || askYesNo(reader, "Commit it in the main checkout now?", false) {
+		if err := commitProjectConfig(mainDir); err != nil {
+			fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+		} else {
+			committed = true
+			fmt.Printf("%s✓  Committed%s it in %s\n\n", colorGreen+colorBold, colorReset, mainDir)
+		}
+	}
+
 	fmt.Printf("%sNext steps:%s\n\n", colorBold, colorReset)
 	fmt.Printf("  %s1.%s Edit the file to match your project\n", colorBold, colorReset)
 	fmt.Printf("     %s%s%s\n\n", colorDim, configPath, colorReset)
-	fmt.Printf("  %s2.%s Commit it\n", colorBold, colorReset)
-	fmt.Printf("     %sgit -C %s add grove.yaml%s\n", colorDim, mainDir, colorReset)
-	fmt.Printf("     %sgit -C %s commit -m 'Add grove.yaml'%s\n\n", colorDim, mainDir, colorReset)
+	if committed {
+		fmt.Printf("  %s2.%s Commit your edits and push, so that everyone gets it\n", colorBold, colorReset)
+		fmt.Printf("     %sgit -C %s commit -m 'Configure grove' -- .grove/grove.yaml%s\n", colorDim, mainDir, colorReset)
+	} else {
+		fmt.Printf("  %s2.%s Commit it and push, so that everyone gets it\n", colorBold, colorReset)
+		fmt.Printf("     %sgit -C %s add .grove/grove.yaml%s\n", colorDim, mainDir, colorReset)
+		fmt.Printf("     %sgit -C %s commit -m 'Add grove config'%s\n", colorDim, mainDir, colorReset)
+	}
+	fmt.Printf("     %sgit -C %s push%s\n\n", colorDim, mainDir, colorReset)
 	fmt.Printf("  %s3.%s Re-run\n", colorBold, colorReset)
 	fmt.Printf("     %sgrove start %s <branch>%s\n\n", colorDim, projectName, colorReset)
 }
 
-// projectConfigBoilerplate is written to grove.yaml (repo root) when a project
-// has none. It is designed to be self-explanatory with enough comments and
+// askYesNo asks question and reads the answer from reader; an empty
+// answer is def.
+func askYesNo(reader *bufio.Reader, question string, def bool) bool {
+	choices := "[y/N]"
+	if def {
+		choices = "[Y/n]"
+	}
+	fmt.Printf("%s%s%s %s ", colorBold, question, colorReset, choices)
+	answer, _ := reader.ReadString('\n')
+	switch strings.TrimSpace(answer) {
+	case "":
+		return def
+	case "y", "Y":
+		return true
+	}
+	return false
+}
+
+// commitProjectConfig commits the boilerplate .grove/grove.yaml in the
+// main checkout mainDir, and nothing else.
+func commitProjectConfig(mainDir string) error {
+	for _, args := range [][]string{
+		{"add", "--", ".grove/grove.yaml"},
+		{"commit", "-q", "-m", "Add grove config", "--", ".grove/grove.yaml"},
+	} {
+		if out, err := exec.Command("git", append([]string{"-C", mainDir}, args...)...).CombinedOutput(); err != nil {
+			return fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
+
+// projectConfigBoilerplate is written to .grove/grove.yaml when a project has
+// no config. It is designed to be self-explanatory with enough comments and
 // examples that a developer can configure it without reading external docs.
-const projectConfigBoilerplate = `# grove.yaml
+const projectConfigBoilerplate = `# .grove/grove.yaml
 # ─────────────────────────────────────────────────────────────────────────────
 # Grove project configuration.
 # Commit this file so everyone using Grove gets the same setup automatically.
@@ -236,6 +324,15 @@ container:
 #   - npm install
 #   - pip install -r requirements.txt && pre-commit install
 #   - bundle install
+#
+# Any command here, under check: or under finish: can also be a mapping:
+#
+#   - run: go test ./...
+#     dir: services/api   # relative to the worktree root
+#     timeout: 5m         # give up after this long
+#     name: api-tests     # label for its output and result
+#     tty: true           # needs a terminal, e.g. to ask a question; check
+#                         # and finish then only run from an interactive shell
 start:
 
 # ── Agent ─────────────────────────────────────────────────────────────────────
@@ -249,6 +346,25 @@ start:
 agent:
   command: claude
   args: []
+  # Appended on 'grove restart' (not on first start) so the agent picks up
+  # its previous conversation; 'grove restart --fresh' skips them.
+  # Defaults: claude → --continue, aider → --restore-chat-history.
+  # Set to [] to always restart cold.
+  # resume_args: ["--continue"]
+
+# ── Signalling completion ─────────────────────────────────────────────────────
+# An agent can tell grove it believes its task is done by writing
+# .grove/status.json in the worktree root (grove keeps it out of git):
+#
+#   {"status": "done", "summary": "one line describing what changed"}
+#
+# The instance then shows as READY in 'grove list' / 'grove watch', the summary
+# is added to its notes, and 'grove events' reports it.  Writing
+# {"status": "working"} returns it to RUNNING.  To use this, tell the agent in
+# your prompt (or CLAUDE.md / AGENTS.md), e.g.:
+#
+#   When you have finished the task, run:
+#     mkdir -p .grove && echo '{"status":"done","summary":"<what you did>"}' > .grove/status.json
 
 # ── Check ─────────────────────────────────────────────────────────────────────
 # Commands run concurrently by 'grove check <id>' inside the worktree directory.
@@ -262,6 +378,19 @@ agent:
 #   - npm test
 #   - go test ./...
 #   - make lint
+#
+# To run checks automatically, use the long form:
+#
+#   check:
+#     auto: on-ready         # on-ready: when the agent reports done (see above)
+#                            # on-waiting: also whenever the agent goes idle
+#                            # never (default)
+#     report_to_agent: true  # on failure, type a summary into the agent session
+#     commands:
+#       - go test ./...
+#
+# Automatic runs write their output to the instance log ('grove logs <id>');
+# the latest result is shown by 'grove status <id>' and 'grove events'.
 check:
 
 # ── Finish ────────────────────────────────────────────────────────────────────
@@ -286,4 +415,24 @@ finish:
 
   # Or push, open a PR, squash-merge, and delete the branch in one step.
   # - git push -u origin {{branch}} && gh pr create --title "{{branch}}" --fill && gh pr merge --squash --delete-branch
+
+# ── Includes ──────────────────────────────────────────────────────────────────
+# Fragments merged before this file, in order; this file wins on conflicts.
+# Paths are relative to the repository root. Handy for sharing settings
+# across a monorepo.
+#
+# include:
+#   - tools/grove/base.yaml
+
+# ── Branch overrides ──────────────────────────────────────────────────────────
+# Settings for branches matching a glob, merged over everything above.
+# When several patterns match, later entries win. A branch's own copy of this
+# file is used for its instances, so config changes on a branch apply there.
+#
+# overrides:
+#   release/*:
+#     container:
+#       image: ubuntu:22.04
+#     check:
+#       - make release-check
 `
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gandalfthegui/grove/pkg/client"
+	"github.com/gandalfthegui/grove/pkg/proto"
+	"golang.org/x/term"
+)
+
+// cmdTop handles: grove top
+//
+// Live table of per-instance container CPU, memory, and network usage,
+// sorted by CPU.  Sampling happens in the daemon so the client needs no
+// Docker access.
+func cmdTop() {
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: grove top")
+		os.Exit(1)
+	}
+	socketPath := daemonSocket()
+
+	fd := int(os.Stdout.Fd())
+	runDashboard(func() { drawTop(fd, socketPath) }, nil)
+}
+
+func drawTop(fd int, socketPath string) {
+	width, _, err := term.GetSize(fd)
+	if err != nil || width < 40 {
+		width = 120
+	}
+
+	resp, err := client.New(socketPath).Do(context.Background(), proto.Request{Type: proto.ReqStats})
+	var refused *client.Error
+	if errors.As(err, &refused) {
+		fmt.Printf("\033[Hstats unavailable: %s\n\033[J", refused.Message)
+		return
+	}
+	if err != nil {
+		fmt.Printf("\033[Hdaemon not reachable: %v\n\033[J", err)
+		return
+	}
+
+	stats := resp.Stats
+	sortStatsByCPU(stats)
+
+	const idW, stateW, cpuW, memW, memPctW, netW = 6, 10, 7, 22, 6, 22
+	projW := 14
+	const separators = 7 * 2
+	branchW := width - (idW + projW + stateW + cpuW + memW + memPctW + netW + separators)
+	if branchW < 10 {
+		branchW = 10
+	}
+
+	var buf strings.Builder
+	buf.WriteString("\033[H")
+	fmt.Fprintf(&buf, "%s%-*s  %-*s  %-*s  %*s  %-*s  %*s  %-*s  %s%s\n", colorBold,
+		idW, "ID", projW, "PROJECT", stateW, "STATE", cpuW, "CPU%", memW, "MEM", memPctW, "MEM%", netW, "NET I/O", "BRANCH", colorReset)
+	fmt.Fprintf(&buf, "%s%s%s\n", colorDim, strings.Repeat("─", width), colorReset)
+
+	for _, s := range stats {
+		fmt.Fprintf(&buf, "%-*s  %-*s  %s%-*s%s  %*.1f  %-*s  %*.1f  %-*s  %s\n",
+			idW, s.ID,
+			projW, truncate(s.Project, projW),
+			colorState(s.State), stateW, s.State, colorReset,
+			cpuW, s.CPUPercent,
+			memW, truncate(s.MemUsage, memW),
+			memPctW, s.MemPercent,
+			netW, truncate(s.NetIO, netW),
+			truncate(s.Branch, branchW))
+	}
+	if len(stats) == 0 {
+		buf.WriteString("\n  no running containers\n")
+	}
+
+	fmt.Fprintf(&buf, "\n%s  %d container(s)  ·  %s  ·  Ctrl-C to exit%s\n",
+		colorDim, len(stats), time.Now().Format("15:04:05"), colorReset)
+	buf.WriteString("\033[J")
+	fmt.Print(buf.String())
+}
+
+// sortStatsByCPU orders stats by descending CPU usage, breaking ties by ID so
+// rows don't jump around between refreshes.
+func sortStatsByCPU(stats []proto.InstanceStats) {
+	sort.SliceStable(stats, func(i, j int) bool {
+		if stats[i].CPUPercent != stats[j].CPUPercent {
+			return stats[i].CPUPercent > stats[j].CPUPercent
+		}
+		return stats[i].ID < stats[j].ID
+	})
+}
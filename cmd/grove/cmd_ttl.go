@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+// cmdTTL handles: grove ttl <instance-id> [<duration> | off] [--action finish|drop]
+//
+// Sets an instance's time to live to duration from now, or removes it with
+// off; --action changes what the daemon does when it runs out.  With no
+// duration, prints what is left of it.
+func cmdTTL() {
+	const usage = "usage: grove ttl <instance-id> [<duration> | off] [--action finish|drop]"
+	args, action := stripOnceFlag(os.Args[2:], "action")
+	if len(args) < 1 || len(args) > 2 || (action != nil && len(args) != 2) {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(1)
+	}
+	inst := resolveInstance(args[0])
+	instanceID := inst.ID
+
+	if len(args) == 1 {
+		if inst.ExpiresAt == 0 {
+			fmt.Printf("%sno time to live%s\n", colorDim, colorReset)
+			return
+		}
+		fmt.Printf("%s, then %s\n", formatTTL(*inst, time.Now()), ttlAction(*inst))
+		return
+	}
+
+	ttl, err := parseTTL(args[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+		os.Exit(1)
+	}
+	req := proto.Request{Type: proto.ReqTTL, InstanceID: instanceID, TTL: int64(ttl / time.Second)}
+	if action != nil {
+		req.TTLAction = *action
+	}
+	resp := mustRequest(req)
+	if ttl == 0 {
+		printResult(ttlClearedResult(instanceID))
+		return
+	}
+	var expiresAt int64
+	if len(resp.Instances) == 1 {
+		expiresAt = resp.Instances[0].ExpiresAt
+	}
+	printResult(ttlSetResult(instanceID, expiresAt))
+}
+
+// ttlAction returns what the daemon does with inst when its time to live
+// runs out.
+func ttlAction(inst proto.InstanceInfo) string {
+	if inst.TTLAction == "" {
+		return proto.TTLFinish
+	}
+	return inst.TTLAction
+}
@@ -1,15 +1,17 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"net"
 	"os"
+	"os/exec"
 	"os/signal"
 	"strings"
 	"syscall"
 	"time"
 
-	"github.com/gandalfthegui/grove/internal/proto"
+	"github.com/gandalfthegui/grove/pkg/client"
+	"github.com/gandalfthegui/grove/pkg/proto"
 	"golang.org/x/term"
 )
 
@@ -54,14 +56,127 @@ var watchBanner = []string{
 }
 
 func cmdWatch() {
+	rawArgs, selector := labelSelector(os.Args[2:])
+	if len(rawArgs) != 0 {
+		fmt.Fprintln(os.Stderr, "usage: grove watch [--label key=value ...]")
+		os.Exit(1)
+	}
 	socketPath := daemonSocket()
 
 	fd := int(os.Stdout.Fd())
+	w := &watchTmux{}
+	peek := &watchPeek{}
+	runDashboard(func() {
+		if peek.shown {
+			if peek.draw(fd); peek.shown {
+				return
+			}
+		}
+		status := peek.status()
+		if status == "" {
+			status = w.status()
+		}
+		if instances, ok := drawWatch(fd, socketPath, selector, status); ok {
+			w.update(instances)
+		}
+	}, func(b byte) {
+		if !peek.key(b) {
+			w.key(b)
+		}
+	})
+}
+
+// watchTmux is grove watch's tmux integration.  The t key opens a tmux
+// window for each live instance on the dashboard; from then on every
+// refresh closes and renames windows as instances are dropped or end.
+type watchTmux struct {
+	synced  bool   // t was pressed
+	pending bool   // t was pressed since the last refresh
+	msg     string // outcome of the last sync, or why it failed
+}
+
+// key handles a key pressed on the dashboard.
+func (w *watchTmux) key(b byte) {
+	if b != 't' {
+		return
+	}
+	if err := checkTmux(exec.LookPath); err != nil {
+		w.msg = "tmux: " + err.Error()
+		return
+	}
+	w.synced, w.pending = true, true
+}
+
+// update syncs the tmux windows with the dashboard's instances, those
+// listed, if t has been pressed.
+func (w *watchTmux) update(listed []proto.InstanceInfo) {
+	if !w.synced {
+		return
+	}
+	var open func(proto.InstanceInfo) bool
+	if w.pending {
+		shown := map[string]bool{}
+		for _, inst := range listed {
+			shown[inst.ID] = true
+		}
+		open = func(inst proto.InstanceInfo) bool { return shown[inst.ID] }
+	} else {
+		open = func(proto.InstanceInfo) bool { return false }
+	}
+	w.pending = false
+	all, err := tryRequest(proto.Request{Type: proto.ReqList})
+	if err == nil {
+		var res tmuxSyncResult
+		if res, err = syncTmux(all.Instances, open); err == nil && res != (tmuxSyncResult{}) {
+			w.msg = "tmux windows: " + res.String()
+		}
+	}
+	if err != nil {
+		w.msg = "tmux: " + err.Error()
+	}
+}
+
+// status is the line shown under the dashboard.
+func (w *watchTmux) status() string {
+	if w.msg == "" && !w.synced && os.Getenv("TMUX") != "" {
+		return "t: open a tmux window per instance  p: peek at an instance"
+	}
+	if w.msg == "" {
+		return "p: peek at an instance"
+	}
+	return w.msg
+}
 
+// runDashboard takes over the terminal's alternate screen and calls draw
+// immediately, every second, and on every resize until interrupted.  If
+// onKey is set and stdin is a terminal, keys typed are passed to it, each
+// followed by a redraw.
+func runDashboard(draw func(), onKey func(byte)) {
 	// Enter alternate screen buffer; restore on exit.
 	fmt.Print("\033[?1049h\033[?25l")
 	defer fmt.Print("\033[?25h\033[?1049l")
 
+	restore := func() {}
+	keys := make(chan byte, 16)
+	if onKey != nil && term.IsTerminal(int(os.Stdin.Fd())) {
+		if r, err := setCbreak(int(os.Stdin.Fd())); err == nil {
+			restore = r
+			go func() {
+				buf := make([]byte, 16)
+				for {
+					n, err := os.Stdin.Read(buf)
+					for _, b := range buf[:n] {
+						keys <- b
+					}
+					if err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}
+	defer restore()
+
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 	winchCh := make(chan os.Signal, 1)
@@ -69,7 +184,7 @@ func cmdWatch() {
 	defer signal.Stop(sigCh)
 	defer signal.Stop(winchCh)
 
-	drawWatch(fd, socketPath)
+	draw()
 
 	ticker := time.NewTicker(time.Second)
 	defer ticker.Stop()
@@ -77,53 +192,90 @@ func cmdWatch() {
 	for {
 		select {
 		case <-sigCh:
+			restore()
 			fmt.Print("\033[?25h\033[?1049l")
 			os.Exit(0)
+		case b := <-keys:
+			onKey(b)
+			draw()
 		case <-winchCh:
-			drawWatch(fd, socketPath)
+			draw()
 		case <-ticker.C:
-			drawWatch(fd, socketPath)
+			draw()
 		}
 	}
 }
 
-func drawWatch(fd int, socketPath string) {
+// drawWatch draws the dashboard with status under it and returns the
+// instances shown, and false if the daemon could not be reached.
+func drawWatch(fd int, socketPath string, selector map[string]string, status string) ([]proto.InstanceInfo, bool) {
 	width, _, err := term.GetSize(fd)
 	if err != nil || width < 40 {
 		width = 120
 	}
 
-	conn, err := net.Dial("unix", socketPath)
+	resp, err := client.New(socketPath).Do(context.Background(), proto.Request{Type: proto.ReqList})
 	if err != nil {
 		fmt.Printf("\033[Hdaemon not reachable: %v\n\033[J", err)
-		return
+		return nil, false
+	}
+	if len(selector) > 0 {
+		matched := resp.Instances[:0]
+		for _, inst := range resp.Instances {
+			if matchLabels(inst.Labels, selector) {
+				matched = append(matched, inst)
+			}
+		}
+		resp.Instances = matched
 	}
-	defer conn.Close()
 
-	if err := writeRequest(conn, proto.Request{Type: proto.ReqList}); err != nil {
-		fmt.Printf("\033[Hdaemon not reachable: %v\n\033[J", err)
-		return
+	fmt.Print(renderWatch(resp.Instances, width, time.Now()))
+	if status != "" {
+		fmt.Printf("\033[2m  %s\033[0m\n\033[J", status)
 	}
-	resp, err := readResponse(conn)
-	if err != nil || !resp.OK {
-		fmt.Printf("\033[Hdaemon not reachable: %v\n\033[J", err)
-		return
+	return resp.Instances, true
+}
+
+// watchState is the STATE cell of inst: its state, followed by the check or
+// finish command that has been running longest and for how long, e.g.
+// "CHECKING npm-test 2m10s", and how many others are running beside it.
+func watchState(inst proto.InstanceInfo, now time.Time) string {
+	if len(inst.Running) == 0 {
+		return inst.State
+	}
+	c := inst.Running[0]
+	s := fmt.Sprintf("%s %s %s", inst.State, c.Label, now.Sub(time.Unix(c.Started, 0)).Round(time.Second))
+	if more := len(inst.Running) - 1; more > 0 {
+		s += fmt.Sprintf(" +%d", more)
 	}
+	return s
+}
 
+// renderWatch builds one full frame of the watch dashboard for a terminal
+// width columns wide.  It is pure so the layout can be tested without a
+// daemon or a terminal.
+func renderWatch(instances []proto.InstanceInfo, width int, now time.Time) string {
 	// Compute dynamic column widths based on actual content.
-	const idW, stateW, uptimeW = 10, 10, 10
+	const idW, inStateW, modeW, uptimeW, remoteW = 10, 8, 6, 10, 16
 	projW := 14 // minimum width
-	for _, inst := range resp.Instances {
+	stateW := 10
+	for _, inst := range instances {
 		if l := len(inst.Project); l > projW {
 			projW = l
 		}
+		if l := len(watchState(inst, now)); l > stateW {
+			stateW = l
+		}
 	}
 	if projW > 30 {
 		projW = 30
 	}
+	if stateW > 32 {
+		stateW = 32
+	}
 
-	const separators = 4 * 2 // 4 column gaps of 2 spaces
-	branchW := width - (idW + projW + stateW + uptimeW + separators)
+	const separators = 7 * 2 // 7 column gaps of 2 spaces
+	branchW := width - (idW + projW + stateW + inStateW + modeW + uptimeW + remoteW + separators)
 	if branchW < 15 {
 		branchW = 15
 	}
@@ -185,45 +337,54 @@ func drawWatch(fd int, socketPath string) {
 	buf.WriteString("\033[0m\n")
 
 	// Column headers.
-	fmt.Fprintf(&buf, "%-*s  %-*s  %-*s  %-*s  %s\n",
-		idW, "ID", projW, "PROJECT", stateW, "STATE", uptimeW, "UPTIME", "BRANCH")
-	fmt.Fprintf(&buf, "\033[2m%s  %s  %s  %s  %s\033[0m\n",
+	fmt.Fprintf(&buf, "%-*s  %-*s  %-*s  %-*s  %-*s  %-*s  %-*s  %s\n",
+		idW, "ID", projW, "PROJECT", stateW, "STATE", inStateW, "IN-STATE", modeW, "MODE", uptimeW, "UPTIME", remoteW, "REMOTE", "BRANCH")
+	fmt.Fprintf(&buf, "\033[2m%s  %s  %s  %s  %s  %s  %s  %s\033[0m\n",
 		strings.Repeat("─", idW),
 		strings.Repeat("─", projW),
 		strings.Repeat("─", stateW),
+		strings.Repeat("─", inStateW),
+		strings.Repeat("─", modeW),
 		strings.Repeat("─", uptimeW),
+		strings.Repeat("─", remoteW),
 		strings.Repeat("─", branchW))
 
-	now := time.Now().Unix()
 	var running int
-	for _, inst := range resp.Instances {
+	for _, inst := range instances {
 		project := truncate(inst.Project, projW)
 		branch := truncate(inst.Branch, branchW)
-		uptimeEnd := now
-		if inst.EndedAt > 0 {
-			uptimeEnd = inst.EndedAt
+		if inst.Pinned {
+			// The marker takes two columns.
+			branch = truncate(inst.Branch, branchW-3) + " " + pinMarker
 		}
-		uptime := formatUptime(uptimeEnd - inst.CreatedAt)
+		uptime := formatAge(time.Duration(instanceUptime(inst, now)) * time.Second)
 		stateColored := colorState(inst.State)
-		fmt.Fprintf(&buf, "%-*s  %-*s  %s%-*s\033[0m  %-*s  %s\n",
+		fmt.Fprintf(&buf, "%-*s  %-*s  %s%-*s\033[0m  %-*s  %s  %-*s  %-*s  %s\n",
 			idW, inst.ID,
 			projW, project,
-			stateColored, stateW, inst.State,
+			stateColored, stateW, truncate(watchState(inst, now), stateW),
+			inStateW, formatInState(inst, now),
+			formatMode(inst),
 			uptimeW, uptime,
+			remoteW, formatRemote(inst.Remote, now),
 			branch)
 		if inst.State == "RUNNING" || inst.State == "ATTACHED" {
 			running++
 		}
 	}
 
-	if len(resp.Instances) == 0 {
+	if len(instances) == 0 {
 		buf.WriteString("\n  no instances running\n")
 	}
 
+	if note := brokenFootnote(instances); note != "" {
+		fmt.Fprintf(&buf, "\n\033[31m  %s\033[0m\n", note)
+	}
+
 	// Status footer.
 	fmt.Fprintf(&buf, "\n\033[2m  %d instance(s)  ·  %d running  ·  %s\033[0m\n",
-		len(resp.Instances), running, time.Now().Format("15:04:05"))
+		len(instances), running, now.Format("15:04:05"))
 
 	buf.WriteString("\033[J")
-	fmt.Print(buf.String())
+	return buf.String()
 }
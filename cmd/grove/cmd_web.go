@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/gandalfthegui/grove/internal/daemon"
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+// webURL returns the URL of the web UI listening on addr: opening it hands
+// the browser token, which the page then keeps in a cookie.
+func webURL(addr, token string) string {
+	return "http://" + addr + "/?token=" + token
+}
+
+// cmdWeb handles: grove web [--open]
+//
+// Prints the URL of the daemon's web UI, or how to turn it on.  --open
+// opens it in the default browser.
+func cmdWeb() {
+	rawArgs, open := stripBoolFlag(os.Args[2:], "open", "open")
+	if len(rawArgs) != 0 {
+		fmt.Fprintln(os.Stderr, "usage: grove web [--open]")
+		os.Exit(1)
+	}
+	resp := mustRequest(proto.Request{Type: proto.ReqPing})
+	if resp.Health == nil || resp.Health.Web == "" {
+		fmt.Fprintln(os.Stderr, "grove: the web UI is off")
+		fmt.Fprintf(os.Stderr, "  Set web_listen: 127.0.0.1:7421 in %s and restart the daemon.\n", filepath.Join(rootDir(), "config.yaml"))
+		os.Exit(1)
+	}
+	data, err := os.ReadFile(filepath.Join(rootDir(), daemon.WebTokenFile))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+		os.Exit(1)
+	}
+	url := webURL(resp.Health.Web, strings.TrimSpace(string(data)))
+	fmt.Println(url)
+	if !open {
+		return
+	}
+	browser := "xdg-open"
+	if runtime.GOOS == "darwin" {
+		browser = "open"
+	}
+	if err := exec.Command(browser, url).Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,856 @@
+package main
+
+// commands.go – the table of grove's subcommands.
+//
+// Each command registers what main runs for it and what its help says: one
+// or more usages (a synopsis and what it does), the flags of each, and a
+// few examples.  grove help <command> and grove <command> --help render one
+// entry; the global usage renders them all, so neither can drift from the
+// commands grove dispatches.
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// command is one grove subcommand.
+type command struct {
+	name     string
+	group    string // the section of the global usage it is listed in: group*
+	run      func()
+	usages   []commandUsage
+	examples []string // full command lines, starting with "grove <name>"
+}
+
+// commandUsage is one way to call a command.
+type commandUsage struct {
+	synopsis string // without the leading "grove"; continuation lines after "\n"
+	desc     string // one or more lines
+	flags    []flagHelp
+}
+
+// flagHelp describes one flag of a usage.
+type flagHelp struct {
+	flag string // as typed, with its value, e.g. "--base <ref>"
+	desc string
+}
+
+// Sections of the global usage, in the order they are listed.
+const (
+	groupProject  = "Project commands"
+	groupInstance = "Instance commands"
+	groupDaemon   = "Daemon commands"
+	groupShell    = "Shell integration"
+	groupToken    = "Credential commands"
+)
+
+var commandGroups = []string{groupProject, groupInstance, groupDaemon, groupShell, groupToken}
+
+// groupNotes follow a section's commands in the global usage.
+var groupNotes = map[string]string{
+	groupInstance: "attach, logs, stop, check, finish, dir and drop accept - or --last for the\n" +
+		"instance last started, attached to, restarted or checked (like cd -).",
+}
+
+// globalFlags are accepted before or after any command.
+var globalFlags = []flagHelp{
+	{"--porcelain", "Print one stable line per result (e.g. \"started 3\") for scripts;\neverything else goes to stderr"},
+	{"--profile <name>", "Talk to the daemon of profile <name>, rooted in ~/.grove-<name>\n" +
+		"(or GROVE_PROFILE; GROVE_ROOT names a root directly, and\nGROVE_SOCKET a socket of an already running daemon)"},
+}
+
+// Flags several commands share.
+var (
+	flagDetach       = flagHelp{"-d, --detach", "do not attach once it has started"}
+	flagAttach       = flagHelp{"--attach", "attach even where grove.yaml's defaults say detach"}
+	flagDetachIdle   = flagHelp{"--detach-on-idle <duration>", "attach, then detach and notify once the agent has waited for input that long, e.g. 10m"}
+	flagAgent        = flagHelp{"--agent <command>", "run <command> instead of grove.yaml's agent.command"}
+	flagTask         = flagHelp{"--task <text>", "describe the work; the agent gets it as GROVE_TASK"}
+	flagLabel        = flagHelp{"--label key=value", "set a label on the instance; repeatable"}
+	flagLabelFilter  = flagHelp{"--label key=value", "only instances with this label; repeatable"}
+	flagFresh        = flagHelp{"--fresh", "start a new agent session instead of resuming the last one"}
+	flagCurrentCfg   = flagHelp{"--current-config", "use the project's config as it is now, not the one the instance was started with"}
+	flagJSON         = flagHelp{"--json", "print the result as JSON"}
+	flagForceConfirm = flagHelp{"-f, --force", "do not ask for confirmation"}
+)
+
+// commandTable returns every grove subcommand, in the order the global
+// usage lists them.
+func commandTable() []command {
+	return []command{
+		{
+			name: "project", group: groupProject, run: cmdProject,
+			usages: []commandUsage{
+				{"project create <name> [--repo <url>] [--data-dir <path>]", "Register a new project (name + repo URL)", []flagHelp{
+					{"--repo <url>", "git remote URL (can be added later)"},
+					{"--data-dir <path>", "existing directory to keep the main checkout and worktrees in, e.g. on an external volume"},
+				}},
+				{"project update <name|#> --repo <url>", "Point a project at a new repo URL (registration and main checkout)", []flagHelp{
+					{"--repo <url>", "new git remote URL"},
+				}},
+				{"project list [--verbose] [--json]", "List registered projects (numbered, with when the main checkout was fetched)", []flagHelp{
+					{"-v, --verbose", "also each project's instances by state, last start and disk usage"},
+					flagJSON,
+				}},
+				{"project fetch <name|#>", "Clone the main checkout, or pull it, ahead of the first start", nil},
+				{"project delete <name|#> [--keep-volumes] [--keep-images]", "Remove a project, its worktrees, containers, volumes and images", []flagHelp{
+					{"--keep-volumes", "leave the project's docker volumes"},
+					{"--keep-images", "leave the images built for the project"},
+				}},
+				{"project dir <name|#>", "Print the main checkout path for a project", nil},
+				{"project move <name|#> <path>", "Move the main checkout and worktrees into an existing directory (data_dir)", nil},
+				{"project shell <name|#> [--no-lock]", "Open $SHELL in the main checkout, holding the project's lock until it exits", []flagHelp{
+					{"--no-lock", "do not hold the lock: starts may change the checkout meanwhile"},
+				}},
+				{"project export --all | <name|#>...", "Print a bundle of project registrations (without secrets) for another machine", []flagHelp{
+					{"--all", "every registered project"},
+				}},
+				{"project import-bundle <file|-> [--overwrite]", "Register the projects in a bundle; existing ones are skipped unless --overwrite", []flagHelp{
+					{"--overwrite", "replace projects registered already"},
+				}},
+			},
+			examples: []string{
+				"grove project create api --repo git@github.com:acme/api.git",
+				"grove project fetch api",
+				"grove project export --all > projects.bundle",
+				"grove project import-bundle projects.bundle",
+			},
+		},
+		{
+			name: "worktrees", group: groupProject, run: cmdWorktrees,
+			usages: []commandUsage{
+				{"worktrees <name|#> [--json] [--prune-orphans]", "List the project's git worktrees and the instances they belong to,\nflagging orphans and missing worktrees", []flagHelp{
+					{"--json", "print the worktrees as JSON"},
+					{"--prune-orphans", "remove worktrees no instance refers to, after confirmation"},
+				}},
+			},
+			examples: []string{
+				"grove worktrees api",
+				"grove worktrees 1 --prune-orphans",
+			},
+		},
+		{
+			name: "validate", group: groupProject, run: cmdValidate,
+			usages: []commandUsage{
+				{"validate [path]", "Check a grove.yaml (default: the one in the current directory)\nexit 1: it would not load, exit 2: warnings only", nil},
+			},
+			examples: []string{
+				"grove validate",
+				"grove validate ../api/grove.yaml",
+			},
+		},
+		{
+			name: "schema", group: groupProject, run: cmdSchema,
+			usages: []commandUsage{
+				{"schema", "Print the JSON Schema of grove.yaml, for editors and CI", nil},
+			},
+			examples: []string{
+				"grove schema > grove.schema.json",
+				"grove schema | jq '.properties | keys'",
+			},
+		},
+
+		{
+			name: "start", group: groupInstance, run: cmdStart,
+			usages: []commandUsage{
+				{"start <project|#> <branch> [-d | --attach | --detach-on-idle <duration>] [--base <ref>]\n" +
+					"[--agent <command>] [--task <text>] [--ttl <duration>] [--mode plan|normal|auto] [--label key=value ...]\n" +
+					"[--pin] [--commit] [--show-effective | --dry-run]",
+					"Start a new agent instance on <branch> (attaches immediately; -d to skip)\n" +
+						"<project> may be a name or the number from 'project list'",
+					[]flagHelp{
+						flagDetach, flagAttach, flagDetachIdle,
+						{"--base <ref>", "branch from <ref> instead of the main checkout's HEAD"},
+						flagAgent, flagTask,
+						{"--ttl <duration>", "finish it (or drop it, per defaults.ttl_action) that long after it starts, e.g. 2h"},
+						{"--mode plan|normal|auto", "plan only, or auto-accept everything (claude and aider)"},
+						flagLabel,
+						{"--pin", "start it pinned"},
+						{"--commit", "if the project has no grove.yaml, commit the boilerplate one it offers"},
+						{"--show-effective", "print the options merged with grove.yaml's defaults and exit"},
+						{"--dry-run", "check the config, branch, repo, image, credentials and disk space the start needs,\ncreating nothing (exit 1 if a check fails)"},
+					}},
+			},
+			examples: []string{
+				"grove start api fix-login --task \"Fix the login redirect loop\"",
+				"grove start 2 feat/search -d --base develop --ttl 4h",
+				"grove start api spike --dry-run",
+			},
+		},
+		{
+			name: "scratch", group: groupInstance, run: cmdScratch,
+			usages: []commandUsage{
+				{"scratch <path|url> [--branch <name>] [--in-place] [-d | --attach | --detach-on-idle <duration>]\n" +
+					"[--agent <command>] [--task <text>] [--label key=value ...]",
+					"Start an instance on a repo URL or local directory without a project\n(cloned under ~/.grove/scratch/<id>)",
+					[]flagHelp{
+						{"--branch <name>", "the branch to work on"},
+						{"--in-place", "mount the directory itself rather than a clone of it"},
+						flagDetach, flagAttach, flagDetachIdle, flagAgent, flagTask, flagLabel,
+					}},
+			},
+			examples: []string{
+				"grove scratch https://github.com/acme/tool.git --task \"Try the new parser\"",
+				"grove scratch . --in-place -d",
+			},
+		},
+		{
+			name: "cancel-start", group: groupInstance, run: cmdCancelStart,
+			usages: []commandUsage{
+				{"cancel-start <start-id>", "Cancel a start under way and remove what it made (the ID is the one its\ngrove start shows; Ctrl-C in that grove start does the same)", nil},
+			},
+			examples: []string{
+				"grove cancel-start 3f2a9c1d",
+				"grove cancel-start 3f2a9c1d --profile work",
+			},
+		},
+		{
+			name: "attach", group: groupInstance, run: cmdAttach,
+			usages: []commandUsage{
+				{"attach <instance-id> [--agent <name> | --command <cmd>] [--detach-on-idle <duration>] [--tmux]",
+					"Attach terminal to an instance (detach: Ctrl-])",
+					[]flagHelp{
+						{"--agent <name>", "a helper agent from grove.yaml's agents list"},
+						{"--command <cmd>", "run cmd in the container in a terminal of its own and exit with its exit code"},
+						{"--detach-on-idle <duration>", "detach and notify once the agent has waited for input that long, e.g. 10m"},
+						{"--tmux", "in a tmux window"},
+					}},
+			},
+			examples: []string{
+				"grove attach 3",
+				"grove attach - --detach-on-idle 10m",
+				"grove attach 3 --command \"npm test\"",
+			},
+		},
+		{
+			name: "stop", group: groupInstance, run: cmdStop,
+			usages: []commandUsage{
+				{"stop <instance-id> [--stack]", "Kill the agent; instance stays in list as KILLED", []flagHelp{
+					{"--stack", "also stop its compose services, which restart starts again (or stop.stack in grove.yaml)"},
+				}},
+				{"stop --all [--stack] [--label k=v ...]", "Stop every live instance but pinned ones (optionally only those matching labels)", []flagHelp{
+					{"--all", "every live instance but pinned ones"},
+					{"--stack", "also stop its compose services, which restart starts again (or stop.stack in grove.yaml)"},
+					flagLabelFilter,
+				}},
+			},
+			examples: []string{
+				"grove stop 3",
+				"grove stop --all --label team=web",
+			},
+		},
+		{
+			name: "restart", group: groupInstance, run: cmdRestart,
+			usages: []commandUsage{
+				{"restart <instance-id> [-d] [--fresh] [--current-config] [--recreate-container]",
+					"Restart agent in existing worktree, resuming its session\nUses the config the instance was started with",
+					[]flagHelp{
+						flagDetach, flagFresh, flagCurrentCfg,
+						{"--recreate-container", "on a new container from the current config, e.g. a new image"},
+					}},
+			},
+			examples: []string{
+				"grove restart 3",
+				"grove restart 3 -d --fresh",
+				"grove restart 3 --recreate-container",
+			},
+		},
+		{
+			name: "reopen", group: groupInstance, run: cmdReopen,
+			usages: []commandUsage{
+				{"reopen <instance-id> [-d] [--fresh] [--current-config]",
+					"Pick a FINISHED instance back up (recreates its container if needed)\nUses the config the instance was started with",
+					[]flagHelp{flagDetach, flagFresh, flagCurrentCfg}},
+			},
+			examples: []string{
+				"grove reopen 3",
+				"grove reopen 3 -d --current-config",
+			},
+		},
+		{
+			name: "check", group: groupInstance, run: cmdCheck,
+			usages: []commandUsage{
+				{"check <instance-id> [--json] [--current-config] [--force | --watch]",
+					"Run check commands concurrently; instance returns to WAITING",
+					[]flagHelp{
+						flagJSON, flagCurrentCfg,
+						{"--force", "clear a CHECKING state left by a check that is no longer running"},
+						{"--watch", "run the checks again whenever files in the worktree change, until Ctrl-C"},
+					}},
+			},
+			examples: []string{
+				"grove check 3",
+				"grove check - --watch",
+				"grove check 3 --json | jq .ok",
+			},
+		},
+		{
+			name: "finish", group: groupInstance, run: cmdFinish,
+			usages: []commandUsage{
+				{"finish <instance-id> [--json] [--current-config]",
+					"Run finish steps; instance stays as FINISHED\nUses the config the instance was started with",
+					[]flagHelp{flagJSON, flagCurrentCfg}},
+			},
+			examples: []string{
+				"grove finish 3",
+				"grove finish 3 --current-config",
+			},
+		},
+		{
+			name: "shell", group: groupInstance, run: cmdShell,
+			usages: []commandUsage{
+				{"shell <instance-id> [shell]", "Open an interactive shell in the instance container (default: sh)", nil},
+			},
+			examples: []string{
+				"grove shell 3",
+				"grove shell 3 bash",
+			},
+		},
+		{
+			name: "cp", group: groupInstance, run: cmdCp,
+			usages: []commandUsage{
+				{"cp <id>:<path> <local>", "Copy a file or directory out of an instance worktree", nil},
+				{"cp <local> <id>:<path>", "Copy a file or directory into an instance worktree", nil},
+			},
+			examples: []string{
+				"grove cp 3:coverage.html .",
+				"grove cp fixtures/ 3:test/fixtures",
+			},
+		},
+		{
+			name: "drop", group: groupInstance, run: cmdDrop,
+			usages: []commandUsage{
+				{"drop <instance-id> [-f] [--keep-worktree] [--keep-container]",
+					"Delete the worktree, branch, container and instance permanently",
+					[]flagHelp{
+						flagForceConfirm,
+						{"--keep-worktree", "leave the worktree and branch"},
+						{"--keep-container", "leave the container"},
+					}},
+			},
+			examples: []string{
+				"grove drop 3",
+				"grove drop 3 -f --keep-worktree",
+			},
+		},
+		{
+			name: "container", group: groupInstance, run: cmdContainer,
+			usages: []commandUsage{
+				{"container rm <instance-id>", "Remove a stopped instance's container, keeping its worktree\n(reopen and restart --recreate-container create it again)", nil},
+			},
+			examples: []string{
+				"grove container rm 3",
+				"grove container rm -",
+			},
+		},
+		{
+			name: "worktree", group: groupInstance, run: cmdWorktree,
+			usages: []commandUsage{
+				{"worktree rm <instance-id> [-f]", "Remove a stopped instance's worktree, keeping its branch and container\n(reopen and restart --recreate-container create it again)", []flagHelp{
+					flagForceConfirm,
+				}},
+			},
+			examples: []string{
+				"grove worktree rm 3",
+				"grove worktree rm 3 -f",
+			},
+		},
+		{
+			name: "list", group: groupInstance, run: cmdList,
+			usages: []commandUsage{
+				{"list [--active] [-o wide | --format <template>] [--sort <key>] [--reverse] [--absolute] [--label k=v ...]",
+					"List all instances",
+					[]flagHelp{
+						{"--active", "exclude FINISHED instances"},
+						{"-o wide", "add the time to live, remote status, image, base commit, labels and latest note"},
+						{"--format <template>", "print one line per instance from a Go template, e.g. '{{.ID}}\\t{{.State}}'"},
+						{"--sort <key>", "order by created, uptime, project or state"},
+						{"--reverse", "reverse the order"},
+						{"--absolute", "show creation times as RFC 3339 rather than e.g. \"2h ago\""},
+						flagLabelFilter,
+					}},
+			},
+			examples: []string{
+				"grove list --active -o wide",
+				"grove list --sort state --label team=web",
+				"grove list --format '{{.ID}} {{.Branch}}'",
+			},
+		},
+		{
+			name: "label", group: groupInstance, run: cmdLabel,
+			usages: []commandUsage{
+				{"label <instance-id> [k=v ...] [--remove k]", "Set or remove instance labels (no arguments: print labels)", []flagHelp{
+					{"--remove <key>", "remove the label; repeatable"},
+				}},
+			},
+			examples: []string{
+				"grove label 3 team=web priority=high",
+				"grove label 3 --remove priority",
+			},
+		},
+		{
+			name: "ttl", group: groupInstance, run: cmdTTL,
+			usages: []commandUsage{
+				{"ttl <instance-id> [<duration> | off] [--action finish|drop]",
+					"Make the instance expire <duration> from now, or never (no duration: print what is left)",
+					[]flagHelp{
+						{"--action finish|drop", "what is done once it expires (default: defaults.ttl_action, else finish)"},
+					}},
+			},
+			examples: []string{
+				"grove ttl 3",
+				"grove ttl 3 2h --action drop",
+				"grove ttl 3 off",
+			},
+		},
+		{
+			name: "pin", group: groupInstance, run: func() { cmdPin(true) },
+			usages: []commandUsage{
+				{"pin <instance-id>", "Keep an instance out of prune, stop --all and its time to live (drop asks twice)", nil},
+			},
+			examples: []string{
+				"grove pin 3",
+				"grove pin -",
+			},
+		},
+		{
+			name: "unpin", group: groupInstance, run: func() { cmdPin(false) },
+			usages: []commandUsage{
+				{"unpin <instance-id>", "Undo pin", nil},
+			},
+			examples: []string{
+				"grove unpin 3",
+				"grove unpin -",
+			},
+		},
+		{
+			name: "status", group: groupInstance, run: cmdStatus,
+			usages: []commandUsage{
+				{"status <instance-id> [--json] [--env] [--usage]", "Show details, notes and start timings for an instance", []flagHelp{
+					{"--json", "print its record as the daemon returns it"},
+					{"--env", "how the agent was launched"},
+					{"--usage", "measure what the agent has cost now"},
+				}},
+			},
+			examples: []string{
+				"grove status 3",
+				"grove status 3 --json | jq .base_sha",
+			},
+		},
+		{
+			name: "stats", group: groupInstance, run: cmdStats,
+			usages: []commandUsage{
+				{"stats [project|#]", "Median time of each start stage, and agent cost, per project", nil},
+				{"stats --timings [--project <name|#>] [--csv]", "p50/p90 of start stages, checks and agent sessions from the timing history", []flagHelp{
+					{"--timings", "summarise the timing history"},
+					{"--project <name|#>", "only this project"},
+					{"--csv", "print CSV, for spreadsheets"},
+				}},
+				{"stats --record on|off", "Record the (local, opt-in) timing history, or stop and delete it", []flagHelp{
+					{"--record on|off", "on: record the timing history; off: stop and delete it"},
+				}},
+			},
+			examples: []string{
+				"grove stats api",
+				"grove stats --timings --csv > timings.csv",
+				"grove stats --record on",
+			},
+		},
+		{
+			name: "show-config", group: groupInstance, run: cmdShowConfig,
+			usages: []commandUsage{
+				{"show-config <instance-id> [--diff]", "Print the config the instance was started with", []flagHelp{
+					{"--diff", "show how it differs from the project's config now"},
+				}},
+			},
+			examples: []string{
+				"grove show-config 3",
+				"grove show-config 3 --diff",
+			},
+		},
+		{
+			name: "note", group: groupInstance, run: cmdNote,
+			usages: []commandUsage{
+				{"note <instance-id> [\"text\" | --clear]", "Append a note to an instance (no text: print notes)", []flagHelp{
+					{"--clear", "remove all notes"},
+				}},
+			},
+			examples: []string{
+				"grove note 3 \"waiting on the API review\"",
+				"grove note 3",
+			},
+		},
+		{
+			name: "logs", group: groupInstance, run: cmdLogs,
+			usages: []commandUsage{
+				{"logs <instance-id> [-f] [--helper <name>]", "Print buffered output for an instance", []flagHelp{
+					{"-f", "follow log output"},
+					{"--helper <name>", "the output of the named helper agent from grove.yaml's agents list"},
+				}},
+				{"logs <instance-id> --setup|--agent [--plain]", "Print the setup part of its log file, or everything but it", []flagHelp{
+					{"--setup", "only the setup output (clone, container start, start commands)"},
+					{"--agent", "the whole log without the setup output"},
+					{"--plain", "the text left on screen, without escape sequences and redraws"},
+				}},
+				{"logs --all [-f] [--since D] [--project <name>]", "Merge every instance's recent output, line by line", []flagHelp{
+					{"--all", "each line prefixed with its instance: [3/feat-x]"},
+					{"-f", "follow new output, of instances started meanwhile too"},
+					{"--since D", "only output from the last D, e.g. 30m (default 10m)"},
+					{"--project <name>", "only this project's instances"},
+				}},
+			},
+			examples: []string{
+				"grove logs 3 -f",
+				"grove logs 3 --setup --plain",
+				"grove logs 3 --helper reviewer",
+				"grove logs --all --since 30m --project api",
+			},
+		},
+		{
+			name: "peek", group: groupInstance, run: cmdPeek,
+			usages: []commandUsage{
+				{"peek <instance-id> [--lines N]", "Print the last N lines of what the agent's screen shows now", []flagHelp{
+					{"--lines N", "number of lines to print (default 40)"},
+				}},
+			},
+			examples: []string{
+				"grove peek 3",
+				"grove peek 3 --lines 10",
+			},
+		},
+		{
+			name: "watch", group: groupInstance, run: cmdWatch,
+			usages: []commandUsage{
+				{"watch [--label k=v ...]", "Live dashboard (refreshes every second, Ctrl-C to exit; t: a tmux window per instance;\np: peek at an instance)", []flagHelp{
+					flagLabelFilter,
+				}},
+			},
+			examples: []string{
+				"grove watch",
+				"grove watch --label team=web",
+			},
+		},
+		{
+			name: "tmux", group: groupInstance, run: cmdTmux,
+			usages: []commandUsage{
+				{"tmux sync", "Open tmux windows for live instances, close those of dropped ones", nil},
+			},
+			examples: []string{
+				"grove tmux sync",
+				"watch -n 60 grove tmux sync",
+			},
+		},
+		{
+			name: "top", group: groupInstance, run: cmdTop,
+			usages: []commandUsage{
+				{"top", "Live per-instance container CPU, memory, and network usage", nil},
+			},
+			examples: []string{
+				"grove top",
+				"grove top --profile work",
+			},
+		},
+		{
+			name: "events", group: groupInstance, run: cmdEvents,
+			usages: []commandUsage{
+				{"events [--json]", "Stream instance events (e.g. an agent reporting READY, a check command ending) as they happen", []flagHelp{
+					{"--json", "one JSON object per event, for scripts"},
+				}},
+			},
+			examples: []string{
+				"grove events",
+				"grove events --json | jq 'select(.type == \"ready\")'",
+			},
+		},
+		{
+			name: "prune", group: groupInstance, run: cmdPrune,
+			usages: []commandUsage{
+				{"prune [--finished] [--label k=v ...]", "Drop all exited/crashed instances, and FINISHED scratch ones;\npinned instances are skipped", []flagHelp{
+					{"--finished", "also drop FINISHED and FINISH_FAILED instances"},
+					flagLabelFilter,
+				}},
+			},
+			examples: []string{
+				"grove prune",
+				"grove prune --finished --label team=web",
+			},
+		},
+		{
+			name: "open", group: groupInstance, run: cmdOpen,
+			usages: []commandUsage{
+				{"open <instance-id> [--editor <cmd>] [--wait]", "Open the worktree in your editor ($GROVE_EDITOR, $VISUAL, $EDITOR, code, cursor)", []flagHelp{
+					{"--editor <cmd>", "this editor instead"},
+					{"-w, --wait", "wait until the editor's window is closed"},
+				}},
+			},
+			examples: []string{
+				"grove open 3",
+				"grove open 3 --editor code --wait",
+			},
+		},
+		{
+			name: "dir", group: groupInstance, run: cmdDir,
+			usages: []commandUsage{
+				{"dir <instance-id>", "Print the worktree path for an instance", nil},
+				{"dir --project <name|#>", "Print the main checkout path for a project", []flagHelp{
+					{"-p, --project <name|#>", "the project's main checkout"},
+				}},
+				{"dir --all", "Print the worktree path of every instance whose worktree exists", []flagHelp{
+					{"--all", "every instance whose worktree exists"},
+				}},
+			},
+			examples: []string{
+				"cd \"$(grove dir 3)\"",
+				"grove dir --project api",
+				"grove dir --all | xargs -I{} git -C {} status --short",
+			},
+		},
+
+		{
+			name: "daemon", group: groupDaemon, run: cmdDaemon,
+			usages: []commandUsage{
+				{"daemon install", "Register groved as a login LaunchAgent", nil},
+				{"daemon uninstall", "Remove the LaunchAgent", nil},
+				{"daemon status [--verbose]", "List the known profiles and whether their daemons are running,\nand on macOS whether the LaunchAgent is installed", []flagHelp{
+					{"-v, --verbose", "the daemon's PATH, git/docker paths and free disk space"},
+				}},
+				{"daemon logs [-f] [-n N | --since D]", "Print daemon log", []flagHelp{
+					{"-f", "follow log output"},
+					{"-n N", "print only the last N lines"},
+					{"--since <duration>", "print only what was logged in the last <duration>, e.g. 1h"},
+				}},
+			},
+			examples: []string{
+				"grove daemon install",
+				"grove daemon status --verbose",
+				"grove daemon logs -f --since 1h",
+			},
+		},
+		{
+			name: "doctor", group: groupDaemon, run: cmdDoctor,
+			usages: []commandUsage{
+				{"doctor", "Check the daemon's tools, free disk space and instance directories (exit 1 on a problem)", nil},
+			},
+			examples: []string{
+				"grove doctor",
+				"grove doctor || grove daemon logs -n 50",
+			},
+		},
+		{
+			name: "gc", group: groupDaemon, run: cmdGC,
+			usages: []commandUsage{
+				{"gc", "Remove scratch volumes (container.scratch) whose containers are gone,\nand prune git records of worktrees whose directories are gone", nil},
+			},
+			examples: []string{
+				"grove gc",
+				"grove gc --profile work",
+			},
+		},
+		{
+			name: "web", group: groupDaemon, run: cmdWeb,
+			usages: []commandUsage{
+				{"web [--open]", "Print the URL of the daemon's web UI, token included", []flagHelp{
+					{"--open", "open it in a browser"},
+				}},
+			},
+			examples: []string{
+				"grove web",
+				"grove web --open",
+			},
+		},
+
+		{
+			name: "shell-init", group: groupShell, run: cmdShellInit,
+			usages: []commandUsage{
+				{"shell-init <bash|zsh|fish>", "Print the gcd cd-wrapper and completions", nil},
+			},
+			examples: []string{
+				"eval \"$(grove shell-init bash)\"",
+				"grove shell-init fish | source",
+			},
+		},
+
+		{
+			name: "token", group: groupToken, run: cmdToken,
+			usages: []commandUsage{
+				{"token", "Set or replace the CLAUDE_CODE_OAUTH_TOKEN in ~/.grove/env", nil},
+			},
+			examples: []string{
+				"grove token",
+				"grove token --profile work",
+			},
+		},
+	}
+}
+
+// findCommand returns the command called name, or nil.
+func findCommand(name string) *command {
+	for _, c := range commandTable() {
+		if c.name == name {
+			return &c
+		}
+	}
+	return nil
+}
+
+// helpUsageCol is the column the descriptions of the global usage start in.
+const helpUsageCol = 33
+
+// writeUsage writes the global usage: every command's usages, by group.
+func writeUsage(w io.Writer) {
+	fmt.Fprintln(w, "grove – supervise AI coding agent instances")
+	cmds := commandTable()
+	for _, group := range commandGroups {
+		fmt.Fprintf(w, "\n%s:\n", group)
+		for _, c := range cmds {
+			if c.group != group {
+				continue
+			}
+			for _, u := range c.usages {
+				writeUsageLine(w, u.synopsis, u.desc)
+			}
+		}
+		if note := groupNotes[group]; note != "" {
+			fmt.Fprintf(w, "\n%s\n", indentLines(note, "  "))
+		}
+	}
+	fmt.Fprintln(w, "\nGlobal flags:")
+	for _, f := range globalFlags {
+		writeUsageLine(w, f.flag, f.desc)
+	}
+	fmt.Fprintln(w, "\nRun 'grove help <command>' (or grove <command> --help) for its flags and examples.")
+}
+
+// writeUsageLine writes a synopsis and its description in two columns,
+// the description starting on a line of its own if the synopsis is too
+// long or runs over several lines.
+func writeUsageLine(w io.Writer, synopsis, desc string) {
+	lines := strings.Split(synopsis, "\n")
+	for i, l := range lines {
+		if i > 0 {
+			l = "      " + l
+		}
+		lines[i] = "  " + l
+	}
+	last := lines[len(lines)-1]
+	pad := strings.Repeat(" ", helpUsageCol)
+	descLines := strings.Split(desc, "\n")
+	if len(lines) == 1 && len(last) < helpUsageCol-1 {
+		lines[0] = fmt.Sprintf("%-*s%s", helpUsageCol, last, descLines[0])
+		descLines = descLines[1:]
+	}
+	for _, l := range lines {
+		fmt.Fprintln(w, l)
+	}
+	for _, l := range descLines {
+		fmt.Fprintln(w, pad+l)
+	}
+}
+
+// writeCommandHelp writes c's help: its usages, the flags they take and its
+// examples.  With sub set, e.g. "create" for grove help project create,
+// only the usages and examples of that subcommand are shown, if it has
+// any.
+func writeCommandHelp(w io.Writer, c *command, sub string) {
+	usages, examples := c.usages, c.examples
+	if sub != "" {
+		prefix := c.name + " " + sub
+		if u := filterUsages(usages, prefix); len(u) > 0 {
+			usages = u
+			examples = filterPrefix(examples, "grove "+prefix)
+		}
+	}
+
+	fmt.Fprintln(w, "Usage:")
+	var flags []flagHelp
+	seen := map[string]bool{}
+	for _, u := range usages {
+		fmt.Fprintf(w, "  grove %s\n", strings.ReplaceAll(u.synopsis, "\n", "\n        "))
+		fmt.Fprintln(w, indentLines(u.desc, "      "))
+		for _, f := range u.flags {
+			if !seen[f.flag] {
+				seen[f.flag] = true
+				flags = append(flags, f)
+			}
+		}
+	}
+	if len(flags) > 0 {
+		width := 0
+		for _, f := range flags {
+			width = max(width, len(f.flag))
+		}
+		fmt.Fprintln(w, "\nFlags:")
+		for _, f := range flags {
+			desc := strings.ReplaceAll(f.desc, "\n", "\n"+strings.Repeat(" ", width+4))
+			fmt.Fprintf(w, "  %-*s  %s\n", width, f.flag, desc)
+		}
+	}
+	if len(examples) > 0 {
+		fmt.Fprintln(w, "\nExamples:")
+		for _, e := range examples {
+			fmt.Fprintf(w, "  %s\n", e)
+		}
+	}
+}
+
+// filterUsages returns the usages whose synopsis starts with prefix, a
+// command and subcommand.
+func filterUsages(usages []commandUsage, prefix string) []commandUsage {
+	var out []commandUsage
+	for _, u := range usages {
+		if u.synopsis == prefix || strings.HasPrefix(u.synopsis, prefix+" ") {
+			out = append(out, u)
+		}
+	}
+	return out
+}
+
+// filterPrefix returns the examples that run the command line prefix.
+func filterPrefix(examples []string, prefix string) []string {
+	var out []string
+	for _, e := range examples {
+		if e == prefix || strings.HasPrefix(e, prefix+" ") {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// indentLines puts indent before every line of s.
+func indentLines(s, indent string) string {
+	return indent + strings.ReplaceAll(s, "\n", "\n"+indent)
+}
+
+// wantsHelp reports whether args, those after the command name, ask for
+// its help: -h or --help before any "--".
+func wantsHelp(args []string) bool {
+	for _, a := range args {
+		switch a {
+		case "--":
+			return false
+		case "-h", "-help", "--help":
+			return true
+		}
+	}
+	return false
+}
+
+// cmdHelp handles: grove help [<command> [<subcommand>]]
+func cmdHelp() {
+	if len(os.Args) < 3 {
+		writeUsage(os.Stdout)
+		return
+	}
+	c := findCommand(os.Args[2])
+	if c == nil {
+		fmt.Fprintf(os.Stderr, "grove: unknown command %q; grove help lists them\n", os.Args[2])
+		os.Exit(1)
+	}
+	sub := ""
+	if len(os.Args) > 3 {
+		sub = os.Args[3]
+	}
+	writeCommandHelp(os.Stdout, c, sub)
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// userConfig holds per-user CLI preferences from ~/.grove/config.yaml.
+// Project configuration lives in grove.yaml; this file is only for settings
+// that belong to the person running grove.
+type userConfig struct {
+	Editor string `yaml:"editor"` // command used by 'grove open', e.g. "code" or "nvim"
+	Notify string `yaml:"notify"` // shell command run to notify the user, e.g. by 'grove attach --detach-on-idle'; may use {{shortstat}} and {{files}}
+
+	// DaemonArgs are extra groved flags, e.g. [--disk-warn, 20GiB], for
+	// the daemon grove starts on demand and the one grove daemon install
+	// sets up alike; see buildDaemonArgs.
+	DaemonArgs []string `yaml:"daemon_args"`
+}
+
+// loadUserConfig reads ~/.grove/config.yaml.  A missing or unparseable file
+// yields the zero config so preferences never block a command.
+func loadUserConfig() userConfig {
+	var cfg userConfig
+	data, err := os.ReadFile(filepath.Join(rootDir(), "config.yaml"))
+	if err != nil {
+		return cfg
+	}
+	_ = yaml.Unmarshal(data, &cfg)
+	return cfg
+}
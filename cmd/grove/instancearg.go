@@ -0,0 +1,150 @@
+package main
+
+// instancearg.go – resolving the instance a command's argument names.
+//
+// An instance argument is an ID, as grove list shows it, possibly typed as
+// "#3" or "03", or "-" for the last instance used (see state.go).  When it
+// names no instance, grove does not just say so: it suggests the instances
+// the user may have meant, those whose ID is one keystroke away and those
+// whose branch starts with the argument, and lists the live ones.
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+// maxListedInstances bounds the live instances listed when an argument
+// names none.
+const maxListedInstances = 10
+
+// resolveInstanceArg returns the ID of the instance arg names; see
+// resolveInstance.
+func resolveInstanceArg(arg string) string {
+	return resolveInstance(arg).ID
+}
+
+// resolveInstance returns the instance arg names.  If there is none, it
+// says so, with suggestions and the live instances, and grove exits 1.
+func resolveInstance(arg string) *proto.InstanceInfo {
+	resp := mustRequest(proto.Request{Type: proto.ReqList})
+	if arg == lastInstanceArg {
+		last := loadState().LastInstance
+		if inst := findListed(resp, last); inst != nil && last != "" {
+			return inst
+		}
+		if last == "" {
+			fmt.Fprintln(os.Stderr, "grove: no instance used yet; give an instance ID")
+		} else {
+			fmt.Fprintf(os.Stderr, "grove: the last instance used (%s) no longer exists; give an instance ID\n", last)
+		}
+		fmt.Fprint(os.Stderr, instanceList("current instances", resp.Instances, 0))
+		os.Exit(1)
+	}
+	if inst := findListed(resp, normalizeInstanceArg(arg)); inst != nil {
+		return inst
+	}
+	fmt.Fprint(os.Stderr, instanceNotFound(arg, resp.Instances))
+	os.Exit(1)
+	return nil
+}
+
+// normalizeInstanceArg turns "#3", "03" and " 3" into "3".  Anything else
+// is returned as it is.
+func normalizeInstanceArg(arg string) string {
+	s := strings.TrimPrefix(strings.TrimSpace(arg), "#")
+	if n, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return strconv.FormatUint(n, 10)
+	}
+	return arg
+}
+
+// instanceNotFound explains that arg names none of instances: which of
+// them it may have meant, and which are live.
+func instanceNotFound(arg string, instances []proto.InstanceInfo) string {
+	if len(instances) == 0 {
+		return fmt.Sprintf("grove: no instance %s; there are no instances (grove start creates one)\n", arg)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "grove: no instance %s\n", arg)
+	if similar := similarInstances(arg, instances); len(similar) == 1 {
+		b.WriteString(instanceList("did you mean", similar, 0))
+	} else if len(similar) > 1 {
+		b.WriteString(instanceList("did you mean one of", similar, 0))
+	}
+	var live []proto.InstanceInfo
+	for _, inst := range instances {
+		if !proto.IsTerminal(inst.State) {
+			live = append(live, inst)
+		}
+	}
+	if len(live) == 0 {
+		b.WriteString("\nno live instances; grove list shows them all\n")
+	} else {
+		b.WriteString(instanceList("live instances", live, maxListedInstances))
+	}
+	return b.String()
+}
+
+// similarInstances returns the instances arg may have been meant for:
+// those whose ID is one edit away from it, then those whose branch starts
+// with it, in the order given.
+func similarInstances(arg string, instances []proto.InstanceInfo) []proto.InstanceInfo {
+	id := normalizeInstanceArg(arg)
+	var out []proto.InstanceInfo
+	for _, inst := range instances {
+		if withinOneEdit(id, inst.ID) {
+			out = append(out, inst)
+		}
+	}
+	for _, inst := range instances {
+		if arg != "" && strings.HasPrefix(inst.Branch, arg) && !withinOneEdit(id, inst.ID) {
+			out = append(out, inst)
+		}
+	}
+	return out
+}
+
+// withinOneEdit reports whether a becomes b by inserting, deleting or
+// replacing at most one byte.  Replacing the only byte does not count: any
+// one-digit ID would be close to any other.
+func withinOneEdit(a, b string) bool {
+	if len(a) == 1 && len(b) == 1 {
+		return a == b
+	}
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	if len(b)-len(a) > 1 {
+		return false
+	}
+	i := 0
+	for i < len(a) && a[i] == b[i] {
+		i++
+	}
+	if len(a) == len(b) {
+		return i == len(a) || a[i+1:] == b[i+1:]
+	}
+	return a[i:] == b[i+1:]
+}
+
+// instanceList renders instances under title, one per line, as at most
+// limit lines (0: all of them).
+func instanceList(title string, instances []proto.InstanceInfo, limit int) string {
+	if len(instances) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "\n%s:\n", title)
+	for i, inst := range instances {
+		if limit > 0 && i == limit {
+			fmt.Fprintf(&b, "  … and %d more (grove list)\n", len(instances)-limit)
+			break
+		}
+		fmt.Fprintf(&b, "  %-4s %s/%s  %s\n", inst.ID, inst.Project, inst.Branch, inst.State)
+	}
+	return b.String()
+}
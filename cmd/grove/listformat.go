@@ -0,0 +1,81 @@
+package main
+
+// listformat.go – grove list --format: one line per instance from a Go
+// template evaluated against proto.InstanceInfo, like docker ps --format.
+//
+//	grove list --format '{{.ID}}\t{{.Branch | truncate 20}}\t{{since .StateSince}}'
+//
+// \t and \n in the format are turned into a tab and a newline, so the
+// format can be given in single quotes.
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+// listFormatFuncs returns the functions available to --format templates.
+// Times are unix timestamps as in proto.InstanceInfo; 0 renders as "-".
+func listFormatFuncs(now time.Time) template.FuncMap {
+	return template.FuncMap{
+		// since renders the time elapsed since a timestamp, e.g. "3m05s".
+		"since": func(ts int64) string {
+			if ts == 0 {
+				return "-"
+			}
+			return formatUptime(now.Unix() - ts)
+		},
+		// time renders a timestamp as local date and time.
+		"time": func(ts int64) string {
+			if ts == 0 {
+				return "-"
+			}
+			return time.Unix(ts, 0).Format("2006-01-02 15:04:05")
+		},
+		// truncate shortens s to n characters, ending in "..." if cut.
+		"truncate": func(n int, s string) string { return truncate(s, n) },
+		"join":     strings.Join,
+		"labels":   func(l map[string]string) string { return strings.Join(formatLabels(l), ",") },
+		"upper":    strings.ToUpper,
+		"lower":    strings.ToLower,
+		"json": func(v any) (string, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+	}
+}
+
+// parseListFormat parses a --format template.
+func parseListFormat(format string, now time.Time) (*template.Template, error) {
+	format = strings.NewReplacer(`\t`, "\t", `\n`, "\n").Replace(format)
+	return template.New("format").Funcs(listFormatFuncs(now)).Parse(format)
+}
+
+// renderListFormat evaluates t for each instance, one line each.
+func renderListFormat(t *template.Template, instances []proto.InstanceInfo) (string, error) {
+	var buf bytes.Buffer
+	for _, inst := range instances {
+		if err := t.Execute(&buf, inst); err != nil {
+			return "", err
+		}
+		buf.WriteByte('\n')
+	}
+	return buf.String(), nil
+}
+
+// listFormatError explains a --format template that did not parse or run.
+func listFormatError(err error) error {
+	t := reflect.TypeOf(proto.InstanceInfo{})
+	fields := make([]string, t.NumField())
+	for i := range fields {
+		fields[i] = "." + t.Field(i).Name
+	}
+	return fmt.Errorf("invalid --format: %v\navailable fields: %s\nfunctions: since, time, truncate, join, labels, upper, lower, json",
+		err, strings.Join(fields, " "))
+}
@@ -0,0 +1,47 @@
+package main
+
+// listsort.go – grove list --sort and --reverse.  The daemon returns
+// instances oldest first, which is also the order among equals.
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+// listSortKeys are the orders grove list --sort accepts.
+var listSortKeys = []string{"created", "uptime", "project", "state"}
+
+// listLess returns the ascending order for --sort key, or an error naming
+// the keys there are.
+func listLess(key string, now time.Time) (func(a, b proto.InstanceInfo) bool, error) {
+	switch key {
+	case "created":
+		return proto.CreatedBefore, nil
+	case "uptime":
+		return func(a, b proto.InstanceInfo) bool { return instanceUptime(a, now) < instanceUptime(b, now) }, nil
+	case "project":
+		return func(a, b proto.InstanceInfo) bool { return a.Project < b.Project }, nil
+	case "state":
+		return func(a, b proto.InstanceInfo) bool { return a.State < b.State }, nil
+	}
+	return nil, fmt.Errorf("unknown sort key %q (want: %s)", key, strings.Join(listSortKeys, ", "))
+}
+
+// sortInstances sorts instances with less, descending if reverse.
+// Instances less does not tell apart stay oldest first.
+func sortInstances(instances []proto.InstanceInfo, less func(a, b proto.InstanceInfo) bool, reverse bool) {
+	sort.Slice(instances, func(i, j int) bool {
+		a, b := instances[i], instances[j]
+		if reverse {
+			a, b = b, a
+		}
+		if less(a, b) != less(b, a) {
+			return less(a, b)
+		}
+		return proto.CreatedBefore(instances[i], instances[j])
+	})
+}
@@ -1,10 +1,22 @@
 package main
 
 import (
+	"bytes"
+	"flag"
+	"io"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/gandalfthegui/grove/internal/daemon"
+	"github.com/gandalfthegui/grove/internal/history"
+	"github.com/gandalfthegui/grove/pkg/proto"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -30,6 +42,132 @@ func TestFormatUptime(t *testing.T) {
 	}
 }
 
+func TestFormatInState(t *testing.T) {
+	now := time.Unix(1000, 0)
+	assert.Equal(t, "-", formatInState(proto.InstanceInfo{}, now), "daemon did not say")
+	assert.Equal(t, "2m05s", formatInState(proto.InstanceInfo{StateSince: 875}, now))
+}
+
+func TestFormatTTL(t *testing.T) {
+	now := time.Unix(1000, 0)
+	assert.Equal(t, "-", formatTTL(proto.InstanceInfo{}, now))
+	assert.Equal(t, "1h00m", formatTTL(proto.InstanceInfo{ExpiresAt: 4600}, now))
+	assert.Equal(t, "expired", formatTTL(proto.InstanceInfo{ExpiresAt: 1000}, now))
+
+	for in, want := range map[string]time.Duration{"2h": 2 * time.Hour, "90m": 90 * time.Minute, "0": 0, "off": 0} {
+		got, err := parseTTL(in)
+		require.NoError(t, err, in)
+		assert.Equal(t, want, got, in)
+	}
+	for _, in := range []string{"", "2", "-1h", "10ms", "forever"} {
+		_, err := parseTTL(in)
+		assert.Error(t, err, in)
+	}
+}
+
+func TestListFormat(t *testing.T) {
+	now := time.Unix(1000, 0)
+	instances := []proto.InstanceInfo{
+		{ID: "1", Project: "api", State: "WAITING", StateSince: 955, Branch: "feature/very-long-branch",
+			Labels: map[string]string{"team": "core", "env": "dev"}},
+		{ID: "2", Project: "web", State: "EXITED", Remote: &proto.RemoteStatus{Pushed: true}},
+	}
+
+	tmpl, err := parseListFormat(`{{.ID}}\t{{.State | lower}}\t{{since .StateSince}}\t{{.Branch | truncate 10}}\t{{labels .Labels}}`, now)
+	require.NoError(t, err)
+	out, err := renderListFormat(tmpl, instances)
+	require.NoError(t, err)
+	assert.Equal(t, "1\twaiting\t45s\tfeature...\tenv=dev,team=core\n2\texited\t-\t\t\n", out)
+
+	tmpl, err = parseListFormat(`{{json .Remote}}`, now)
+	require.NoError(t, err)
+	out, err = renderListFormat(tmpl, instances)
+	require.NoError(t, err)
+	assert.Equal(t, "null\n{\"pushed\":true,\"checked_at\":0}\n", out)
+}
+
+func TestListFormatErrors(t *testing.T) {
+	_, err := parseListFormat("{{.ID", time.Now())
+	require.Error(t, err)
+	msg := listFormatError(err).Error()
+	assert.Contains(t, msg, "unclosed action")
+	assert.Contains(t, msg, ".ID .Project .State")
+
+	tmpl, err := parseListFormat("{{.Nope}}", time.Now())
+	require.NoError(t, err, "fields are only checked when run")
+	_, err = renderListFormat(tmpl, []proto.InstanceInfo{{ID: "1"}})
+	require.Error(t, err)
+	assert.Contains(t, listFormatError(err).Error(), "can't evaluate field Nope")
+}
+
+func TestCommandExitCode(t *testing.T) {
+	assert.Equal(t, 0, commandExitCode(&proto.AttachExit{State: proto.StateExited}))
+	assert.Equal(t, 3, commandExitCode(&proto.AttachExit{State: proto.StateCrashed, ExitCode: 3}))
+	assert.Equal(t, 1, commandExitCode(&proto.AttachExit{State: proto.StateCrashed, ExitCode: -1}), "killed by a signal")
+	assert.Equal(t, 1, commandExitCode(nil), "stopped with Ctrl-]")
+}
+
+func TestIdleFor(t *testing.T) {
+	now := time.Unix(10000, 0)
+	attachedAt := now.Add(-5 * time.Minute)
+	waiting := func(since time.Time) proto.AttachState {
+		return proto.AttachState{State: proto.StateWaiting, StateSince: since.Unix()}
+	}
+	assert.Equal(t, 2*time.Minute, idleFor(waiting(now.Add(-2*time.Minute)), attachedAt, now))
+	assert.Equal(t, 5*time.Minute, idleFor(waiting(now.Add(-time.Hour)), attachedAt, now),
+		"idle before the attach does not count")
+	assert.Zero(t, idleFor(proto.AttachState{State: proto.StateRunning, StateSince: attachedAt.Unix()}, attachedAt, now))
+}
+
+func TestStripDetachOnIdle(t *testing.T) {
+	args, d := stripDetachOnIdle([]string{"3", "--detach-on-idle", "10m"})
+	assert.Equal(t, []string{"3"}, args)
+	assert.Equal(t, 10*time.Minute, d)
+
+	args, d = stripDetachOnIdle([]string{"3"})
+	assert.Equal(t, []string{"3"}, args)
+	assert.Zero(t, d)
+}
+
+func TestNotifyUser(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "notified")
+	inst := proto.InstanceInfo{ID: "3", Project: "api", Branch: "feat/a", State: "WAITING"}
+	cmd := `echo "$GROVE_INSTANCE $GROVE_PROJECT $GROVE_BRANCH $GROVE_STATE: $GROVE_MESSAGE" > ` + out
+	require.NoError(t, notifyUser(cmd, inst, "waiting for 10m00s"))
+	data, err := os.ReadFile(out)
+	require.NoError(t, err)
+	assert.Equal(t, "3 api feat/a WAITING: waiting for 10m00s\n", string(data))
+
+	assert.Error(t, notifyUser("exit 1", inst, "x"))
+}
+
+func TestNotifyUserChanges(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "notified")
+	cmd := `printf '%s|%s' "{{shortstat}}" "{{files}}" > ` + out
+	inst := proto.InstanceInfo{ID: "3", Changes: &proto.ChangeSummary{
+		Shortstat: "2 files changed, 5 insertions(+)",
+		Files:     []string{"a b.go", "$(touch x).go"},
+	}}
+	require.NoError(t, notifyUser(cmd, inst, "waiting"))
+	data, err := os.ReadFile(out)
+	require.NoError(t, err)
+	assert.Equal(t, "2 files changed, 5 insertions(+)|a b.go, $(touch x).go", string(data), "file names are not run through sh")
+
+	// Without a summary the variables are empty.
+	require.NoError(t, notifyUser(cmd, proto.InstanceInfo{ID: "3"}, "waiting"))
+	data, err = os.ReadFile(out)
+	require.NoError(t, err)
+	assert.Equal(t, "|", string(data))
+}
+
+func TestFormatEventChanges(t *testing.T) {
+	ev := proto.Event{Time: 1_700_000_000, Type: proto.EventReady, InstanceID: "3", Project: "api", Branch: "feat/a",
+		State: proto.StateReady, Summary: "done", Changes: &proto.ChangeSummary{Shortstat: "1 file changed, 2 insertions(+)"}}
+	assert.Contains(t, formatEvent(ev), "done  "+colorDim+"(1 file changed, 2 insertions(+))")
+	ev.Changes = nil
+	assert.NotContains(t, formatEvent(ev), "(")
+}
+
 func TestTruncate(t *testing.T) {
 	cases := []struct {
 		s    string
@@ -51,7 +189,7 @@ func TestTruncate(t *testing.T) {
 
 func TestColorState(t *testing.T) {
 	// Each known state returns a non-empty ANSI escape.
-	for _, state := range []string{"RUNNING", "WAITING", "ATTACHED", "EXITED", "CRASHED", "KILLED", "FINISHED"} {
+	for _, state := range []string{"RUNNING", "WAITING", "ATTACHED", "CHECKING", "READY", "EXITED", "CRASHED", "KILLED", "FINISHED"} {
 		assert.NotEmpty(t, colorState(state), "expected color for state %q", state)
 	}
 	// Unknown state returns empty string (no color).
@@ -71,9 +209,31 @@ func TestLoadProjectEntries(t *testing.T) {
 
 	entries := loadProjectEntries()
 	require.Len(t, entries, 3)
-	assert.Equal(t, "alpha", entries[0].name)
-	assert.Equal(t, "beta", entries[1].name)
-	assert.Equal(t, "gamma", entries[2].name)
+	assert.Equal(t, "alpha", entries[0].Name)
+	assert.Equal(t, "beta", entries[1].Name)
+	assert.Equal(t, "gamma", entries[2].Name)
+}
+
+func TestLoadProjectEntriesSearchPath(t *testing.T) {
+	dir, shared := t.TempDir(), t.TempDir()
+	t.Setenv("GROVE_ROOT", dir)
+	t.Setenv("GROVE_PROJECTS_PATH", shared)
+
+	for _, p := range []struct{ root, name, repo string }{
+		{filepath.Join(dir, "projects"), "web", "mine"},
+		{shared, "web", "theirs"},
+		{shared, "api", "theirs"},
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Join(p.root, p.name), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(p.root, p.name, "project.yaml"), []byte("repo: "+p.repo+"\n"), 0o644))
+	}
+
+	entries := loadProjectEntries()
+	require.Len(t, entries, 2)
+	assert.Equal(t, proto.ProjectInfo{Name: "api", Repo: "theirs", Source: shared}, entries[0])
+	assert.Equal(t, "mine", entries[1].Repo, "personal registration wins")
+	assert.Equal(t, "personal", projectSource(entries[1].Source))
+	assert.Equal(t, shared, projectSource(entries[0].Source))
 }
 
 func TestLoadProjectEntriesEmpty(t *testing.T) {
@@ -95,7 +255,7 @@ func TestLoadProjectEntriesSkipsNonYAML(t *testing.T) {
 
 	entries := loadProjectEntries()
 	require.Len(t, entries, 1)
-	assert.Equal(t, "real", entries[0].name)
+	assert.Equal(t, "real", entries[0].Name)
 }
 
 func TestResolveProjectByName(t *testing.T) {
@@ -122,3 +282,1362 @@ func TestResolveProjectByNumber(t *testing.T) {
 	assert.Equal(t, "alpha", resolveProject("1"))
 	assert.Equal(t, "beta", resolveProject("2"))
 }
+
+func TestResolveProjectLocalFallback(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("GROVE_ROOT", dir) // no daemon socket here
+	t.Setenv("GROVE_PROJECTS_PATH", "")
+
+	projectDir := filepath.Join(dir, "projects", "my-app")
+	require.NoError(t, os.MkdirAll(filepath.Join(projectDir, "main"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(projectDir, "project.yaml"), []byte("repo: r\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(projectDir, "main", "grove.yaml"), []byte("agent:\n  command: aider\n"), 0o644))
+
+	info, err := lookupProject("1")
+	require.NoError(t, err)
+	assert.Equal(t, "my-app", info.Name)
+	assert.True(t, info.HasConfig)
+	assert.Equal(t, "aider", projectAgentCommand("my-app"))
+
+	_, err = lookupProject("missing")
+	assert.Error(t, err)
+}
+
+func TestProjectConfigPath(t *testing.T) {
+	dir := t.TempDir()
+	assert.Equal(t, "", projectConfigPath(dir))
+
+	legacy := filepath.Join(dir, "grove.yaml")
+	require.NoError(t, os.WriteFile(legacy, []byte("agent:\n  command: sh\n"), 0o644))
+	assert.Equal(t, legacy, projectConfigPath(dir))
+
+	preferred := filepath.Join(dir, ".grove", "grove.yaml")
+	require.NoError(t, os.MkdirAll(filepath.Dir(preferred), 0o755))
+	require.NoError(t, os.WriteFile(preferred, []byte("agent:\n  command: sh\n"), 0o644))
+	assert.Equal(t, preferred, projectConfigPath(dir))
+}
+
+func TestStripValueFlag(t *testing.T) {
+	args := []string{"proj", "--label", "a=1", "branch", "-label=b=2", "-d"}
+	rest, values, err := stripValueFlag(args, "label")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"proj", "branch", "-d"}, rest)
+	assert.Equal(t, []string{"a=1", "b=2"}, values)
+
+	_, _, err = stripValueFlag([]string{"proj", "--label"}, "label")
+	assert.Error(t, err, "trailing flag without a value must fail")
+}
+
+func TestParseLabels(t *testing.T) {
+	labels, err := parseLabels([]string{"team=payments", "experiment=prompt-v2", "empty="})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"team": "payments", "experiment": "prompt-v2", "empty": ""}, labels)
+
+	for _, bad := range []string{"novalue", "=x"} {
+		_, err := parseLabels([]string{bad})
+		assert.Error(t, err, "expected error for %q", bad)
+	}
+}
+
+func TestMatchLabels(t *testing.T) {
+	labels := map[string]string{"team": "payments", "experiment": "v2"}
+
+	assert.True(t, matchLabels(labels, nil))
+	assert.True(t, matchLabels(labels, map[string]string{"team": "payments"}))
+	assert.True(t, matchLabels(labels, map[string]string{"team": "payments", "experiment": "v2"}))
+	assert.False(t, matchLabels(labels, map[string]string{"team": "search"}))
+	assert.False(t, matchLabels(nil, map[string]string{"team": "payments"}))
+}
+
+func TestShellInitSnippet(t *testing.T) {
+	for _, shell := range []string{"bash", "zsh", "fish"} {
+		snippet, ok := shellInitSnippet(shell)
+		assert.True(t, ok, shell)
+		assert.Contains(t, snippet, "gcd", shell)
+		assert.Contains(t, snippet, "grove dir", shell)
+	}
+	_, ok := shellInitSnippet("tcsh")
+	assert.False(t, ok)
+}
+
+func TestParseInstancePath(t *testing.T) {
+	cases := []struct {
+		arg        string
+		id, path   string
+		isInstance bool
+	}{
+		{"3:reports/out.html", "3", "reports/out.html", true},
+		{"ab:", "ab", "", true},
+		{"./local.txt", "", "", false},
+		{"/tmp/a:b", "", "", false},
+		{"local", "", "", false},
+	}
+	for _, tc := range cases {
+		id, path, ok := parseInstancePath(tc.arg)
+		assert.Equal(t, tc.isInstance, ok, tc.arg)
+		assert.Equal(t, tc.id, id, tc.arg)
+		assert.Equal(t, tc.path, path, tc.arg)
+	}
+}
+
+func TestResolveEditor(t *testing.T) {
+	noPath := func(string) (string, error) { return "", os.ErrNotExist }
+	t.Setenv("GROVE_EDITOR", "")
+	t.Setenv("VISUAL", "")
+	t.Setenv("EDITOR", "vim")
+
+	assert.Equal(t, "zed", resolveEditor("zed", "code", noPath), "--editor wins")
+	assert.Equal(t, "code", resolveEditor("", "code", noPath), "config beats $EDITOR")
+	assert.Equal(t, "vim", resolveEditor("", "", noPath))
+
+	t.Setenv("GROVE_EDITOR", "cursor")
+	assert.Equal(t, "cursor", resolveEditor("", "code", noPath), "$GROVE_EDITOR beats config")
+
+	t.Setenv("GROVE_EDITOR", "")
+	t.Setenv("EDITOR", "")
+	onlyCode := func(name string) (string, error) {
+		if name == "code" {
+			return "/usr/bin/code", nil
+		}
+		return "", os.ErrNotExist
+	}
+	assert.Equal(t, "code", resolveEditor("", "", onlyCode))
+	assert.Equal(t, "", resolveEditor("", "", noPath))
+}
+
+func TestEditorCommand(t *testing.T) {
+	found := func(string) (string, error) { return "/bin/x", nil }
+	missing := func(string) (string, error) { return "", os.ErrNotExist }
+
+	assert.Equal(t, []string{"code", "-n", "--wait", "/wt"}, editorCommand("code -n", "/wt", true, found, "linux"))
+	assert.Equal(t, []string{"vim", "/wt"}, editorCommand("vim", "/wt", true, found, "linux"), "terminal editors never get --wait")
+	assert.Equal(t, []string{"goland", "--wait", "/wt"}, editorCommand("goland", "/wt", true, found, "darwin"))
+	assert.Equal(t, []string{"open", "-W", "-na", "GoLand.app", "--args", "/wt"}, editorCommand("goland", "/wt", true, missing, "darwin"))
+}
+
+func TestTmuxWindowName(t *testing.T) {
+	assert.Equal(t, "grove-app-3", tmuxWindowName(proto.InstanceInfo{ID: "3", Project: "app", State: proto.StateWaiting}))
+	assert.Equal(t, "grove-app-3 [crashed]", tmuxWindowName(proto.InstanceInfo{ID: "3", Project: "app", State: proto.StateCrashed}))
+	assert.Equal(t, "grove-app-3 [finish_failed]", tmuxWindowName(proto.InstanceInfo{ID: "3", Project: "app", State: proto.StateFinishFailed}))
+}
+
+func TestCheckTmux(t *testing.T) {
+	found := func(string) (string, error) { return "/usr/bin/tmux", nil }
+	missing := func(string) (string, error) { return "", os.ErrNotExist }
+	t.Setenv("TMUX", "")
+	assert.ErrorContains(t, checkTmux(missing), "tmux is not installed")
+	assert.ErrorContains(t, checkTmux(found), "not inside a tmux session")
+	t.Setenv("TMUX", "/tmp/tmux-1000/default,1234,0")
+	assert.NoError(t, checkTmux(found))
+}
+
+func TestParseTmuxWindows(t *testing.T) {
+	out := "@1\t\t0\tzsh\n@2\t3\t0\tgrove-app-3\n@4\t5\t1\tgrove-app-5 [crashed]"
+	assert.Equal(t, []tmuxWindow{
+		{ID: "@2", Instance: "3", Name: "grove-app-3"},
+		{ID: "@4", Instance: "5", Name: "grove-app-5 [crashed]", Dead: true},
+	}, parseTmuxWindows(out))
+}
+
+func TestPlanTmuxSync(t *testing.T) {
+	windows := []tmuxWindow{
+		{ID: "@1", Instance: "1", Name: "grove-app-1"},
+		{ID: "@2", Instance: "2", Name: "grove-app-2"},
+		{ID: "@3", Instance: "9", Name: "grove-app-9"},
+	}
+	instances := []proto.InstanceInfo{
+		{ID: "1", Project: "app", State: proto.StateRunning},
+		{ID: "2", Project: "app", State: proto.StateCrashed},
+		{ID: "4", Project: "web", State: proto.StateWaiting},
+		{ID: "5", Project: "web", State: proto.StateExited},
+		{ID: "6", Project: "api", State: proto.StateRunning},
+	}
+	plan := planTmuxSync(windows, instances, nil)
+	assert.Equal(t, []string{"4", "6"}, instanceIDs(plan.Open), "live instances without a window; ended ones get none")
+	assert.Equal(t, []tmuxWindow{windows[2]}, plan.Close, "instance 9 was dropped")
+	assert.Equal(t, map[string]string{"@2": "grove-app-2 [crashed]"}, plan.Rename)
+
+	plan = planTmuxSync(windows, instances, func(inst proto.InstanceInfo) bool { return inst.Project == "web" })
+	assert.Equal(t, []string{"4"}, instanceIDs(plan.Open))
+	assert.Len(t, plan.Close, 1, "closing does not depend on open")
+}
+
+func instanceIDs(instances []proto.InstanceInfo) []string {
+	var ids []string
+	for _, inst := range instances {
+		ids = append(ids, inst.ID)
+	}
+	return ids
+}
+
+func TestRenderWatch(t *testing.T) {
+	now := time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)
+	start := now.Add(-90 * time.Second).Unix()
+
+	cases := []struct {
+		name      string
+		instances []proto.InstanceInfo
+		width     int
+		contains  []string
+		absent    []string
+	}{
+		{
+			name:     "empty",
+			width:    120,
+			contains: []string{"no instances running", "0 instance(s)  ·  0 running  ·  15:04:05"},
+		},
+		{
+			name: "running and exited",
+			instances: []proto.InstanceInfo{
+				{ID: "1", Project: "api", Branch: "feat/a", State: "RUNNING", CreatedAt: start},
+				{ID: "2", Project: "web", Branch: "feat/b", State: "EXITED", CreatedAt: start, EndedAt: start + 30},
+			},
+			width:    120,
+			contains: []string{"feat/a", "1m  ", "30s", "2 instance(s)  ·  1 running", "REMOTE"},
+			absent:   []string{"no instances running"},
+		},
+		{
+			name: "remote status",
+			instances: []proto.InstanceInfo{
+				{ID: "1", Project: "api", Branch: "feat/a", State: "FINISHED", CreatedAt: start,
+					Remote: &proto.RemoteStatus{Pushed: true, CheckedAt: now.Unix()}},
+				{ID: "2", Project: "api", Branch: "feat/b", State: "FINISHED", CreatedAt: start},
+			},
+			width:    120,
+			contains: []string{"pushed", "-  "},
+			absent:   []string{"local-only"},
+		},
+		{
+			name: "time in state",
+			instances: []proto.InstanceInfo{
+				{ID: "1", Project: "api", Branch: "feat/a", State: "WAITING", CreatedAt: start, StateSince: now.Unix() - 45},
+			},
+			width:    120,
+			contains: []string{"IN-STATE", "45s", "1m  "},
+		},
+		{
+			name: "agent mode",
+			instances: []proto.InstanceInfo{
+				{ID: "1", Project: "api", Branch: "feat/a", State: "RUNNING", CreatedAt: start, Mode: proto.ModeAuto},
+				{ID: "2", Project: "api", Branch: "feat/b", State: "RUNNING", CreatedAt: start},
+			},
+			width:    120,
+			contains: []string{"MODE", colorYellow + "auto  " + colorReset, "-       1m"},
+		},
+		{
+			name: "pinned",
+			instances: []proto.InstanceInfo{
+				{ID: "1", Project: "api", Branch: "feat/a", State: "RUNNING", CreatedAt: start, Pinned: true},
+			},
+			width:    120,
+			contains: []string{"feat/a " + pinMarker},
+		},
+		{
+			name: "running check command",
+			instances: []proto.InstanceInfo{
+				{ID: "1", Project: "api", Branch: "feat/a", State: "CHECKING", CreatedAt: start,
+					Running: []proto.CommandProgress{
+						{Kind: proto.CommandKindCheck, Label: "npm-test", Started: now.Unix() - 130},
+						{Kind: proto.CommandKindCheck, Label: "lint", Started: now.Unix() - 20},
+					}},
+			},
+			width:    120,
+			contains: []string{"CHECKING npm-test 2m10s +1\033[0m  -"},
+		},
+		{
+			name: "long branch truncated to narrow terminal",
+			instances: []proto.InstanceInfo{
+				{ID: "1", Project: "api", Branch: strings.Repeat("b", 40), State: "ATTACHED", CreatedAt: start},
+			},
+			width:    40,
+			contains: []string{strings.Repeat("b", 12) + "...", "1 running"},
+			absent:   []string{strings.Repeat("b", 16)},
+		},
+		{
+			name: "long project capped",
+			instances: []proto.InstanceInfo{
+				{ID: "1", Project: strings.Repeat("p", 50), Branch: "main", State: "WAITING", CreatedAt: start},
+			},
+			width:    120,
+			contains: []string{strings.Repeat("p", 27) + "...", "0 running"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			out := renderWatch(tc.instances, tc.width, now)
+			assert.True(t, strings.HasPrefix(out, "\033[H"))
+			assert.True(t, strings.HasSuffix(out, "\033[J"))
+			for _, s := range tc.contains {
+				assert.Contains(t, out, s)
+			}
+			for _, s := range tc.absent {
+				assert.NotContains(t, out, s)
+			}
+		})
+	}
+}
+
+func TestResultSummary(t *testing.T) {
+	res := proto.StreamResult{
+		Commands: []proto.CommandResult{
+			{Command: "make test", ExitCode: 0},
+			{Command: "make vet", ExitCode: 0},
+			{Command: "make lint", ExitCode: 2},
+		},
+		DurationMs: 192_400,
+	}
+	assert.Equal(t, "2 passed, 1 failed in 3m12s", resultSummary(res))
+	assert.Equal(t, "0 passed, 0 failed in 1s", resultSummary(proto.StreamResult{DurationMs: 600}))
+}
+
+func TestFormatRemote(t *testing.T) {
+	now := time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)
+	fresh := now.Add(-time.Minute).Unix()
+	stale := now.Add(-3 * time.Hour).Unix()
+
+	assert.Equal(t, "-", formatRemote(nil, now))
+	assert.Equal(t, "pushed", formatRemote(&proto.RemoteStatus{Pushed: true, CheckedAt: fresh}, now))
+	assert.Equal(t, "local-only", formatRemote(&proto.RemoteStatus{CheckedAt: fresh}, now))
+	assert.Equal(t, "pushed (3h)", formatRemote(&proto.RemoteStatus{Pushed: true, CheckedAt: stale}, now))
+	assert.Equal(t, "local-only (2d)", formatRemote(&proto.RemoteStatus{CheckedAt: now.Add(-50 * time.Hour).Unix()}, now))
+}
+
+func TestFormatImage(t *testing.T) {
+	assert.Equal(t, "-", formatImage(nil))
+	assert.Equal(t, "node:22 (0123456789ab)", formatImage(&proto.ContainerImage{Ref: "node:22", Digest: "sha256:0123456789abcdef"}))
+	assert.Equal(t, "node:22", formatImage(&proto.ContainerImage{Ref: "node:22"}))
+}
+
+func TestFormatBase(t *testing.T) {
+	assert.Equal(t, "-", formatBase(proto.InstanceInfo{}))
+	sha := "3f2a9c1d8e7b6a5f4e3d2c1b0a9f8e7d6c5b4a39"
+	assert.Equal(t, "3f2a9c1", formatBase(proto.InstanceInfo{BaseSHA: sha}))
+	assert.Equal(t, "3f2a9c1 +4", formatBase(proto.InstanceInfo{BaseSHA: sha, MainAhead: 4}))
+}
+
+func TestFormatLogLine(t *testing.T) {
+	assert.Equal(t, "[3/feat-x] hello", formatLogLine(proto.LogLine{InstanceID: "3", Branch: "feat-x", Text: "hello"}))
+	assert.Equal(t, "[s1] hello", formatLogLine(proto.LogLine{InstanceID: "s1", Text: "hello"}), "a scratch instance without a branch")
+}
+
+func TestFormatTmpfs(t *testing.T) {
+	assert.Equal(t, "/tmp (size=1g), /run", formatTmpfs([]string{"/tmp:size=1g", "/run"}))
+}
+
+// TestPorcelainGolden pins the --porcelain result lines, which scripts
+// depend on, to testdata/porcelain.golden.  Changing an existing line is a
+// breaking change; new kinds of result are added to both lists.
+func TestPorcelainGolden(t *testing.T) {
+	results := []result{
+		startedResult("3"),
+		stoppedResult("3"),
+		restartedResult("3"),
+		reopenedResult("3"),
+		droppedResult("3"),
+		containerRemovedResult("3"),
+		worktreeRemovedResult("3"),
+		notedResult("3"),
+		notesClearedResult("3"),
+		labeledResult("3"),
+		ttlSetResult("3", 1760000000),
+		ttlClearedResult("3"),
+		pinnedResult("3"),
+		unpinnedResult("3"),
+		projectCreatedResult("my-app"),
+		projectDeletedResult("my-app"),
+		projectFetchedResult("my-app"),
+		projectUpdatedResult("my-app"),
+		projectMovedResult("my-app"),
+		streamedResult("finished", "3", true),
+		streamedResult("checked", "3", false),
+	}
+	var got strings.Builder
+	for _, r := range results {
+		got.WriteString(r.porcelainLine() + "\n")
+	}
+
+	want, err := os.ReadFile(filepath.Join("testdata", "porcelain.golden"))
+	require.NoError(t, err)
+	assert.Equal(t, string(want), got.String())
+}
+
+func TestCommandName(t *testing.T) {
+	assert.Equal(t, "start", commandName([]string{"start", "my-app", "feat/x"}))
+	assert.Equal(t, "project create", commandName([]string{"project", "create", "my-app"}))
+	assert.Equal(t, "project", commandName([]string{"project"}))
+	assert.Equal(t, "", commandName(nil))
+}
+
+func TestToolsMissingFromPath(t *testing.T) {
+	bin := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(bin, "git"), []byte("#!/bin/sh\n"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(bin, "docker"), []byte("not executable"), 0o644))
+	require.NoError(t, os.Mkdir(filepath.Join(bin, "make"), 0o755))
+
+	envPath := strings.Join([]string{"", t.TempDir(), bin}, string(filepath.ListSeparator))
+	assert.Equal(t, []string{"docker", "make"}, toolsMissingFromPath(envPath, []string{"git", "docker", "make"}))
+	assert.Nil(t, toolsMissingFromPath(envPath, []string{"git"}))
+	assert.Equal(t, []string{"git"}, toolsMissingFromPath("", []string{"git"}))
+}
+
+func TestParseArgs(t *testing.T) {
+	newFlags := func() (*flag.FlagSet, *bool, *string) {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		follow := fs.Bool("f", false, "")
+		output := fs.String("o", "", "")
+		return fs, follow, output
+	}
+
+	cases := []struct {
+		args   []string
+		want   []string
+		follow bool
+		output string
+	}{
+		{[]string{"3"}, []string{"3"}, false, ""},
+		{[]string{"-f", "3"}, []string{"3"}, true, ""},
+		{[]string{"3", "-f"}, []string{"3"}, true, ""},
+		{[]string{"3", "--f", "-o", "wide", "4"}, []string{"3", "4"}, true, "wide"},
+		{[]string{"3", "--", "-f"}, []string{"3", "-f"}, false, ""},
+		{[]string{"--", "-o", "x"}, []string{"-o", "x"}, false, ""},
+		{nil, nil, false, ""},
+	}
+	for _, c := range cases {
+		fs, follow, output := newFlags()
+		got, err := parseArgs(fs, c.args)
+		require.NoError(t, err, "%v", c.args)
+		assert.Equal(t, c.want, got, "%v", c.args)
+		assert.Equal(t, c.follow, *follow, "%v", c.args)
+		assert.Equal(t, c.output, *output, "%v", c.args)
+	}
+
+	fs, _, _ := newFlags()
+	_, err := parseArgs(fs, []string{"3", "--bogus"})
+	assert.ErrorContains(t, err, "flag provided but not defined: -bogus")
+	fs, _, _ = newFlags()
+	_, err = parseArgs(fs, []string{"3", "-o"})
+	assert.ErrorContains(t, err, "flag needs an argument")
+}
+
+func TestSetupLastInstance(t *testing.T) {
+	assert.Equal(t, []string{"attach", "-"}, setupLastInstance([]string{"attach", "--last"}))
+	assert.Equal(t, []string{"logs", "-", "-f"}, setupLastInstance([]string{"logs", "-last", "-f"}))
+	assert.Equal(t, []string{"drop", "-", "-f"}, setupLastInstance([]string{"drop", "-", "-f"}))
+	assert.Equal(t, []string{"list", "--last"}, setupLastInstance([]string{"list", "--last"}), "only for commands that take an instance")
+	assert.Empty(t, setupLastInstance(nil))
+}
+
+func TestStateRoundTrip(t *testing.T) {
+	t.Setenv("GROVE_ROOT", t.TempDir())
+	assert.Equal(t, cliState{}, loadState())
+
+	rememberInstance("3")
+	rememberInstance("a")
+	assert.Equal(t, "a", loadState().LastInstance)
+
+	entries, err := os.ReadDir(rootDir())
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "no temporary files left behind")
+	assert.Equal(t, "state.json", entries[0].Name())
+}
+
+func TestProjectConfigBoilerplateValidates(t *testing.T) {
+	r := daemon.ValidateConfig([]byte(projectConfigBoilerplate))
+	assert.Empty(t, r.Errors)
+	assert.Empty(t, r.Warnings)
+}
+
+func TestReportValidationExitCode(t *testing.T) {
+	assert.Equal(t, validateOK, reportValidation("grove.yaml", daemon.ConfigReport{}))
+	assert.Equal(t, validateWarnings, reportValidation("grove.yaml", daemon.ConfigReport{Warnings: []string{"w"}}))
+	assert.Equal(t, validateErrors, reportValidation("grove.yaml", daemon.ConfigReport{Errors: []string{"e"}, Warnings: []string{"w"}}))
+}
+
+func TestPipeWriterClosedReader(t *testing.T) {
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	defer w.Close()
+	r.Close()
+
+	_, err = w.Write([]byte("x"))
+	assert.True(t, isBrokenPipe(err), "got %v", err)
+	assert.False(t, isBrokenPipe(io.ErrUnexpectedEOF))
+
+	pw := &pipeWriter{w: w}
+	n, err := pw.Write([]byte("first"))
+	assert.NoError(t, err)
+	assert.Equal(t, 5, n)
+	n, err = pw.Write([]byte("second"))
+	assert.NoError(t, err, "the rest of the output is discarded")
+	assert.Equal(t, 6, n)
+	assert.True(t, pw.closed)
+
+	var buf bytes.Buffer
+	pw = &pipeWriter{w: &buf}
+	pw.Write([]byte("kept"))
+	assert.Equal(t, "kept", buf.String())
+}
+
+func TestFormatAge(t *testing.T) {
+	cases := []struct {
+		d    time.Duration
+		want string
+	}{
+		{-time.Second, "0s"},
+		{0, "0s"},
+		{59 * time.Second, "59s"},
+		{60 * time.Second, "1m"},
+		{59*time.Minute + 59*time.Second, "59m"},
+		{61 * time.Minute, "1h"},
+		{23*time.Hour + 59*time.Minute, "23h"},
+		{25 * time.Hour, "1d"},
+		{6*24*time.Hour + 23*time.Hour, "6d"},
+		{7 * 24 * time.Hour, "1w"},
+		{20 * 24 * time.Hour, "2w"},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.want, formatAge(c.d), "%s", c.d)
+	}
+}
+
+func TestFormatCreated(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	inst := proto.InstanceInfo{CreatedAt: now.Add(-2 * time.Hour).Unix()}
+	assert.Equal(t, "2h ago", formatCreated(inst, now, false))
+	assert.Equal(t, time.Unix(inst.CreatedAt, 0).Format(time.RFC3339), formatCreated(inst, now, true))
+	assert.Equal(t, "-", formatCreated(proto.InstanceInfo{}, now, false))
+}
+
+func TestSortInstances(t *testing.T) {
+	now := time.Unix(10_000, 0)
+	base := []proto.InstanceInfo{
+		{ID: "1", Project: "web", State: "RUNNING", CreatedAt: 1000},
+		{ID: "2", Project: "api", State: "EXITED", CreatedAt: 2000, EndedAt: 2100},
+		{ID: "3", Project: "web", State: "EXITED", CreatedAt: 3000, Seq: 4},
+		{ID: "4", Project: "api", State: "RUNNING", CreatedAt: 3000, Seq: 3},
+	}
+	ids := func(instances []proto.InstanceInfo) string {
+		var s []string
+		for _, inst := range instances {
+			s = append(s, inst.ID)
+		}
+		return strings.Join(s, ",")
+	}
+	cases := []struct {
+		key     string
+		reverse bool
+		want    string
+	}{
+		{"created", false, "1,2,4,3"},
+		{"created", true, "3,4,2,1"},
+		{"uptime", false, "2,4,3,1"},
+		{"uptime", true, "1,4,3,2"},
+		{"project", false, "2,4,1,3"},
+		{"project", true, "1,3,2,4"}, // equals stay oldest first
+		{"state", false, "2,3,1,4"},
+	}
+	for _, c := range cases {
+		less, err := listLess(c.key, now)
+		require.NoError(t, err)
+		instances := append([]proto.InstanceInfo(nil), base...)
+		sortInstances(instances, less, c.reverse)
+		assert.Equal(t, c.want, ids(instances), "--sort %s reverse=%v", c.key, c.reverse)
+	}
+
+	_, err := listLess("branch", now)
+	assert.ErrorContains(t, err, "want: created, uptime, project, state")
+}
+
+func TestMergeStartOptions(t *testing.T) {
+	str := func(s string) *string { return &s }
+	info := proto.ProjectInfo{
+		Name:         "web",
+		AgentCommand: "claude",
+		Defaults: &proto.StartDefaults{
+			Detach:       true,
+			Base:         "develop",
+			Labels:       map[string]string{"team": "core", "kind": "feature"},
+			Agent:        "aider --model sonnet",
+			TaskTemplate: "Work on {{branch}} in {{project}}",
+			TTL:          7200,
+			TTLAction:    proto.TTLDrop,
+			Mode:         proto.ModePlan,
+		},
+	}
+
+	o := mergeStartOptions(info, "feat/x", startFlags{})
+	assert.Equal(t, proto.ModePlan, o.Mode)
+	assert.Equal(t, 2*time.Hour, o.TTL)
+	assert.Equal(t, proto.TTLDrop, o.TTLAction)
+	assert.True(t, o.Detach)
+	assert.Equal(t, "develop", o.Base)
+	assert.Equal(t, "Work on feat/x in web", o.Task)
+	assert.Equal(t, "aider", o.agentCommand(info))
+	assert.Equal(t, map[string]string{"team": "core", "kind": "feature"}, o.Labels)
+	assert.Equal(t, fromConfig, o.from["base"])
+
+	noTTL := time.Duration(0)
+	o = mergeStartOptions(info, "feat/x", startFlags{
+		attach: true,
+		base:   str("main"),
+		agent:  str(""),
+		task:   str(""),
+		ttl:    &noTTL,
+		mode:   str(proto.ModeAuto),
+		labels: map[string]string{"kind": "bugfix"},
+	})
+	assert.Equal(t, proto.ModeAuto, o.Mode)
+	assert.False(t, o.Detach, "--attach undoes defaults.detach")
+	assert.Equal(t, "main", o.Base)
+	assert.Equal(t, "", o.Agent, "an explicit empty value clears the default")
+	assert.Equal(t, "claude", o.agentCommand(info))
+	assert.Equal(t, "", o.Task)
+	assert.Zero(t, o.TTL, "--ttl 0 clears defaults.ttl")
+	assert.Equal(t, map[string]string{"team": "core", "kind": "bugfix"}, o.Labels)
+	assert.Equal(t, fromFlag, o.from["label kind"])
+	assert.Equal(t, fromConfig, o.from["label team"])
+
+	o = mergeStartOptions(proto.ProjectInfo{Name: "web"}, "main", startFlags{})
+	assert.False(t, o.Detach)
+	assert.Empty(t, o.from, "no defaults and no flags")
+}
+
+func TestRenderStartOptions(t *testing.T) {
+	info := proto.ProjectInfo{
+		Name:         "web",
+		AgentCommand: "claude",
+		Defaults:     &proto.StartDefaults{Detach: true, Labels: map[string]string{"team": "core"}, TTL: 7200},
+	}
+	o := mergeStartOptions(info, "feat/x", startFlags{labels: map[string]string{"kind": "bugfix"}})
+	assert.Equal(t, `project:  web
+branch:   feat/x
+detach:   yes  (grove.yaml)
+base:     HEAD of the main checkout
+agent:    claude  (agent.command)
+labels:   kind=bugfix (flag), team=core (grove.yaml)
+task:     -
+ttl:      2h0m0s, then finish  (grove.yaml)
+mode:     normal
+`, renderStartOptions(o, info))
+}
+
+func TestRenderDiskSpace(t *testing.T) {
+	h := &proto.DaemonHealth{
+		Disk: []proto.DiskSpace{
+			{Name: "grove", Path: "/home/u/.grove", Free: 1 << 30, Total: 100 << 30},
+			{Name: "docker", Path: "/var/lib/docker", Free: 5 << 30, Total: 100 << 30},
+			{Name: "docker", Path: "/vm", Error: "no such file or directory"},
+		},
+		DiskWarn: 10 << 30,
+		DiskMin:  2 << 30,
+	}
+	lines := strings.Split(strings.TrimSuffix(renderDiskSpace(h), "\n"), "\n")
+	require.Len(t, lines, 3)
+	assert.Contains(t, lines[0], "disk:")
+	assert.Contains(t, lines[0], "1.0 GiB free of 100.0 GiB  "+colorRed+"below 2.0 GiB, starts are refused")
+	assert.Contains(t, lines[1], "5.0 GiB free of 100.0 GiB  "+colorYellow+"below 10.0 GiB")
+	assert.Contains(t, lines[2], "unknown: no such file or directory")
+	assert.NotContains(t, lines[1], "disk:")
+}
+
+func TestRenderLocks(t *testing.T) {
+	now := time.Unix(100000, 0)
+	h := &proto.DaemonHealth{Locks: []proto.LockInfo{
+		{Project: "api", Holder: "start 4", Since: now.Unix() - 75, Waiting: []proto.LockWaiter{
+			{What: "drop 2", Since: now.Unix() - 12},
+		}},
+		{Project: "web", Waiting: []proto.LockWaiter{{What: "fetch", Since: now.Unix()}}},
+	}}
+	lines := strings.Split(strings.TrimSuffix(renderLocks(h, now), "\n"), "\n")
+	require.Len(t, lines, 4)
+	assert.Contains(t, lines[0], "locks:")
+	assert.Contains(t, lines[0], "api  held by start 4 for 1m")
+	assert.Contains(t, lines[1], "drop 2 for 12s")
+	assert.Contains(t, lines[2], "web  "+colorDim+"free")
+	assert.NotContains(t, lines[2], "locks:")
+	assert.Empty(t, renderLocks(&proto.DaemonHealth{}, now))
+}
+
+func TestStartStats(t *testing.T) {
+	timings := func(clone, image, container int64) []proto.StageTiming {
+		ts := []proto.StageTiming{{Stage: proto.StageClone, Duration: clone}}
+		if image >= 0 {
+			ts = append(ts, proto.StageTiming{Stage: proto.StageImage, Duration: image})
+		}
+		return append(ts, proto.StageTiming{Stage: proto.StageContainer, Duration: container})
+	}
+	stats := startStats([]proto.InstanceInfo{
+		{Project: "web", StartTimings: timings(1000, 60000, 2000)},
+		{Project: "api", StartTimings: timings(500, -1, 1000)},
+		{Project: "web", StartTimings: timings(3000, 0, 4000)},
+		{Project: "web", StartTimings: timings(2000, 10, 3000)},
+		{Project: "web"}, // started by an older daemon
+		{Project: "api", StartTimings: timings(1500, -1, 3000)},
+	})
+
+	require.Len(t, stats, 2)
+	assert.Equal(t, "api", stats[0].Project)
+	assert.Equal(t, 2, stats[0].Starts)
+	assert.Equal(t, time.Second, stats[0].Stages[proto.StageClone], "even count: the mean of the middle two")
+	_, ok := stats[0].Stages[proto.StageImage]
+	assert.False(t, ok, "compose starts have no image stage")
+	assert.Equal(t, 3*time.Second, stats[0].Total)
+
+	assert.Equal(t, "web", stats[1].Project)
+	assert.Equal(t, 3, stats[1].Starts)
+	assert.Equal(t, 2*time.Second, stats[1].Stages[proto.StageClone])
+	assert.Equal(t, 10*time.Millisecond, stats[1].Stages[proto.StageImage])
+	assert.Equal(t, 7*time.Second, stats[1].Total, "the median start, not the sum of medians")
+}
+
+func TestRenderStartStats(t *testing.T) {
+	out := renderStartStats([]projectStartStats{{
+		Project: "my-app",
+		Starts:  3,
+		Stages:  map[string]time.Duration{proto.StageClone: 2100 * time.Millisecond, proto.StageStart: 4*time.Minute + 12*time.Second},
+		Total:   4*time.Minute + 15*time.Second,
+	}})
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	require.Len(t, lines, 2)
+	assert.Equal(t, []string{"PROJECT", "STARTS", "CLONE", "WORKTREE", "IMAGE", "CONTAINER", "START", "AGENT-INSTALL", "AGENT-LAUNCH", "TOTAL"},
+		strings.Fields(strings.NewReplacer(colorBold, "", colorReset, "").Replace(lines[0])))
+	assert.Equal(t, []string{"my-app", "3", "2.1s", "-", "-", "-", "4m12s", "-", "-", "4m15s"}, strings.Fields(lines[1]))
+}
+
+func TestUsageStats(t *testing.T) {
+	usage := usageStats([]proto.InstanceInfo{
+		{Project: "web", Usage: &proto.AgentUsage{Known: true, CostUSD: 1.5}},
+		{Project: "api", Usage: &proto.AgentUsage{Known: true, CostUSD: 0.25}},
+		{Project: "api", Usage: &proto.AgentUsage{Known: true, CostUSD: 2}},
+		{Project: "api", Usage: &proto.AgentUsage{Reason: "usage command failed"}},
+		{Project: "api"},
+		{Project: "docs", Usage: &proto.AgentUsage{Reason: "usage command printed nothing"}},
+	})
+	assert.Equal(t, []projectUsage{
+		{Project: "api", Known: 2, Unknown: 1, CostUSD: 2.25},
+		{Project: "docs", Unknown: 1},
+		{Project: "web", Known: 1, CostUSD: 1.5},
+	}, usage)
+
+	lines := strings.Split(strings.TrimSuffix(renderUsageStats(usage), "\n"), "\n")
+	require.Len(t, lines, 4)
+	assert.Equal(t, []string{"PROJECT", "INSTANCES", "COST", "UNKNOWN"},
+		strings.Fields(strings.NewReplacer(colorBold, "", colorReset, "").Replace(lines[0])))
+	assert.Equal(t, []string{"api", "3", "$2.25", "1"}, strings.Fields(lines[1]))
+	assert.Equal(t, []string{"docs", "1", "-", "1"}, strings.Fields(lines[2]))
+}
+
+func timingsTestProjects() []history.Project {
+	return history.Summarize([]history.Record{
+		{Kind: history.KindStart, Project: "api", DurationMs: 45000, Stages: []proto.StageTiming{
+			{Stage: proto.StageClone, Duration: 2100},
+			{Stage: proto.StageImage, Duration: 42900},
+		}},
+		{Kind: history.KindCheck, Project: "api", DurationMs: 800},
+		{Kind: history.KindSession, Project: "web", DurationMs: 600000},
+	})
+}
+
+func TestRenderTimings(t *testing.T) {
+	out := strings.NewReplacer(colorBold, "", colorReset, "", colorYellow, "").Replace(renderTimings(timingsTestProjects()))
+	var rows [][]string
+	for _, line := range strings.Split(strings.TrimSuffix(out, "\n"), "\n") {
+		rows = append(rows, strings.Fields(line))
+	}
+	assert.Equal(t, [][]string{
+		{"api", "N", "P50", "P90"},
+		{"start", "1", "45.0s", "45.0s"},
+		{"clone", "1", "2.1s", "2.1s"},
+		{"image", "1", "42.9s", "42.9s", "←", "slowest", "stage"},
+		{"check", "1", "800ms", "800ms"},
+		{},
+		{"web", "N", "P50", "P90"},
+		{"session", "1", "10m0s", "10m0s"},
+	}, rows)
+}
+
+func TestWriteTimingsCSV(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, writeTimingsCSV(&buf, timingsTestProjects()))
+	assert.Equal(t, `project,timing,n,p50_ms,p90_ms,slowest_stage
+api,start,1,45000,45000,
+api,stage:clone,1,2100,2100,
+api,stage:image,1,42900,42900,yes
+api,check,1,800,800,
+web,session,1,600000,600000,
+`, buf.String())
+}
+
+func TestRenderStartTimings(t *testing.T) {
+	out := renderStartTimings([]proto.StageTiming{
+		{Stage: proto.StageClone, Duration: 2100},
+		{Stage: proto.StageAgentLaunch, Duration: 250},
+	})
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	require.Len(t, lines, 3)
+	assert.Contains(t, lines[0], "Start:")
+	assert.Contains(t, lines[0], "2.4s")
+	assert.Contains(t, lines[1], proto.StageClone)
+	assert.Contains(t, lines[1], "2.1s")
+	assert.Contains(t, lines[2], "250ms")
+}
+
+func TestRenderHelperAgents(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	out := renderHelperAgents([]proto.AgentInfo{
+		{Name: "reviewer", Argv: []string{"claude", "--permission-mode", "plan"}, State: proto.StateRunning, StateSince: now.Unix() - 90},
+		{Name: "docs", Argv: []string{"sh"}, State: proto.StateExited},
+	}, now)
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	require.Len(t, lines, 2)
+	assert.Contains(t, lines[0], "reviewer")
+	assert.Contains(t, lines[0], proto.StateRunning)
+	assert.Contains(t, lines[0], "(for 1m30s)")
+	assert.Contains(t, lines[0], "claude --permission-mode plan")
+	assert.True(t, strings.HasPrefix(lines[1], "  docs      "), "names are aligned: %q", lines[1])
+	assert.Contains(t, lines[1], "(for -)")
+}
+
+func TestRenderWorktrees(t *testing.T) {
+	out := renderWorktrees([]proto.WorktreeInfo{
+		{Path: "/p/main", Branch: "main", Status: proto.WorktreeMain},
+		{Path: "/p/worktrees/1", Branch: "feat/x", InstanceID: "1", Status: proto.WorktreeOK},
+		{Path: "/p/worktrees/9", Status: proto.WorktreeOrphan, Locked: true},
+		{Path: "/p/worktrees/2", Branch: "feat/y", InstanceID: "2", Status: proto.WorktreeMissing},
+	})
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	require.Len(t, lines, 6)
+	assert.Contains(t, lines[0], "STATUS")
+	assert.Contains(t, lines[3], "ok      "+colorReset+"  1     feat/x")
+	assert.Contains(t, lines[4], colorYellow+"orphan")
+	assert.Contains(t, lines[4], "-     (detached)")
+	assert.True(t, strings.HasSuffix(lines[4], "/p/worktrees/9 (locked)"))
+	assert.Contains(t, lines[5], colorRed+"missing")
+}
+
+func TestFormatStageDuration(t *testing.T) {
+	for d, want := range map[time.Duration]string{
+		0:                                      "0ms",
+		350 * time.Millisecond:                 "350ms",
+		2140 * time.Millisecond:                "2.1s",
+		4*time.Minute + 11600*time.Millisecond: "4m12s",
+	} {
+		assert.Equal(t, want, formatStageDuration(d), "%v", d)
+	}
+}
+
+func TestFormatCloned(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	assert.Equal(t, "no", formatCloned(proto.ProjectInfo{}, now))
+	assert.Equal(t, "yes", formatCloned(proto.ProjectInfo{Cloned: true}, now))
+	assert.Equal(t, "3h ago", formatCloned(proto.ProjectInfo{Cloned: true, FetchedAt: now.Add(-3 * time.Hour).Unix()}, now))
+}
+
+func TestRenderProjectsVerbose(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	assert.Equal(t, colorDim+"none"+colorReset, formatInstanceStates(proto.ProjectInfo{}))
+	assert.Equal(t, "4 (2 EXITED, 1 FINISHED, 1 RUNNING)", formatInstanceStates(proto.ProjectInfo{
+		Instances:      4,
+		InstanceStates: map[string]int{proto.StateRunning: 1, proto.StateExited: 2, proto.StateFinished: 1},
+	}))
+
+	lines := strings.Split(strings.TrimSuffix(renderProjectsVerbose([]proto.ProjectInfo{
+		{Name: "api", Cloned: true, FetchedAt: now.Add(-time.Hour).Unix(), Instances: 1,
+			InstanceStates: map[string]int{proto.StateRunning: 1}, LastStartAt: now.Add(-2 * 24 * time.Hour).Unix(),
+			MainBytes: 3 << 20, WorktreesBytes: 5 << 30, MeasuredAt: now.Unix()},
+		{Name: "old", MeasuredAt: now.Unix()},
+		{Name: "unmeasured", Cloned: true},
+	}, now), "\n"), "\n")
+	require.Len(t, lines, 5)
+	assert.Regexp(t, `^1\s+api\s+1h ago\s+3\.0 MiB\s+5\.0 GiB\s+2d ago\s+1 \(1 RUNNING\)$`, lines[2])
+	assert.Regexp(t, `^2\s+old\s+no\s+-\s+0 B\s+-\s+`, lines[3], "no main checkout to measure")
+	assert.Regexp(t, `^3\s+unmeasured\s+yes\s+-\s+-\s+-\s+`, lines[4])
+}
+
+func TestRenderEnvironment(t *testing.T) {
+	out := renderEnvironment(&proto.AgentEnvironment{
+		Argv: []string{"docker", "exec", "-e", "PROMPT_COMMAND=PS1=\"\x1b[2m$ \"", "grove-3", "claude"},
+		Env:  []string{"EMPTY=", "GROVE_TASK=fix it", "PATH=/usr/bin:/bin"},
+	})
+	assert.Contains(t, out, `docker exec -e "PROMPT_COMMAND=PS1=\"\x1b[2m$ \"" grove-3 claude`)
+	assert.NotContains(t, out, "\x1b[2m$")
+	assert.Contains(t, out, "=''\n")
+	assert.Contains(t, out, "='fix it'\n")
+	assert.Contains(t, out, "=/usr/bin:/bin\n")
+}
+
+func TestCoalesceResizes(t *testing.T) {
+	const interval = 20 * time.Millisecond
+	winch := make(chan os.Signal)
+	var size, sent atomic.Int32
+	var lastSent atomic.Int32
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		coalesceResizes(winch, interval, func() bool {
+			sent.Add(1)
+			lastSent.Store(size.Load())
+			return true
+		})
+	}()
+
+	start := time.Now()
+	for i := 1; i <= 1000; i++ {
+		size.Store(int32(i))
+		winch <- syscall.SIGWINCH
+		if i%100 == 0 {
+			time.Sleep(interval / 4)
+		}
+	}
+	elapsed := time.Since(start)
+	close(winch)
+	<-done
+
+	assert.LessOrEqual(t, int(sent.Load()), int(elapsed/interval)+2, "at most one resize per interval, plus the first and the last")
+	assert.Equal(t, int32(1000), lastSent.Load(), "the final size is sent")
+}
+
+func TestLogLineTime(t *testing.T) {
+	want := time.Date(2026, 3, 4, 15, 4, 5, 0, time.Local)
+	for _, line := range []string{
+		"2026/03/04 15:04:05 daemon started",
+		"2026/03/04 15:04:05.000000 daemon started",
+	} {
+		got, ok := logLineTime(line)
+		if assert.True(t, ok, line) {
+			assert.True(t, want.Equal(got), line)
+		}
+	}
+	got, ok := logLineTime(`{"time":"2026-03-04T15:04:05Z","level":"INFO","msg":"daemon started"}`)
+	if assert.True(t, ok) {
+		assert.True(t, time.Date(2026, 3, 4, 15, 4, 5, 0, time.UTC).Equal(got))
+	}
+	for _, line := range []string{"", "  at main.go:12", "2026/03/04", `{"msg":"no time"}`, "{not json"} {
+		_, ok := logLineTime(line)
+		assert.False(t, ok, line)
+	}
+}
+
+func TestPrintLinesSince(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "daemon.log")
+	write := func(s string) {
+		require.NoError(t, os.WriteFile(path, []byte(s), 0o644))
+	}
+	cutoff := time.Date(2026, 3, 4, 12, 0, 0, 0, time.Local)
+	since := func() string {
+		var buf bytes.Buffer
+		require.NoError(t, printLinesSince(path, cutoff, &buf))
+		return buf.String()
+	}
+
+	write("2026/03/04 11:00:00 old\n  old detail\n2026/03/04 12:00:00 new\n  new detail\n2026/03/04 13:00:00 newer\n")
+	assert.Equal(t, "2026/03/04 12:00:00 new\n  new detail\n2026/03/04 13:00:00 newer\n", since())
+
+	write("2026/03/04 11:00:00 old\n")
+	assert.Equal(t, "", since(), "nothing since the cutoff")
+
+	write("no stamps\nat all\n")
+	assert.Equal(t, "no stamps\nat all\n", since(), "unparseable logs are printed whole")
+
+	err := printLinesSince(filepath.Join(t.TempDir(), "missing.log"), cutoff, io.Discard)
+	assert.ErrorContains(t, err, "daemon log not found")
+}
+
+func TestFollowFileRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "daemon.log")
+	require.NoError(t, os.WriteFile(path, []byte("before\n"), 0o644))
+	appendLog := func(s string) {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		require.NoError(t, err)
+		_, err = f.WriteString(s)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+
+	out := &syncBuffer{}
+	output := out.String
+	stop := make(chan struct{})
+	done := make(chan error, 1)
+	go func() { done <- followFile(path, out, 5*time.Millisecond, stop) }()
+
+	// Wait until the file has been opened (the first tick has passed).
+	time.Sleep(20 * time.Millisecond)
+	appendLog("one\n")
+	require.Eventually(t, func() bool { return output() == "one\n" }, time.Second, 5*time.Millisecond)
+
+	// Rotated: the old file is moved away, then written once more, and a
+	// new file appears at the path.
+	require.NoError(t, os.Rename(path, path+".1"))
+	f, err := os.OpenFile(path+".1", os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = f.WriteString("two\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	appendLog("three\n")
+	require.Eventually(t, func() bool { return output() == "one\ntwo\nthree\n" }, time.Second, 5*time.Millisecond)
+
+	// Truncated in place.
+	require.NoError(t, os.Truncate(path, 0))
+	time.Sleep(20 * time.Millisecond)
+	appendLog("four\n")
+	require.Eventually(t, func() bool { return output() == "one\ntwo\nthree\nfour\n" }, time.Second, 5*time.Millisecond)
+
+	close(stop)
+	require.NoError(t, <-done)
+}
+
+// syncBuffer is a bytes.Buffer that can be written and read concurrently.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestBrokenFootnote(t *testing.T) {
+	assert.Equal(t, "", brokenFootnote([]proto.InstanceInfo{{ID: "1", State: proto.StateRunning}}))
+	assert.Equal(t, "BROKEN: the worktree of 2 was deleted; grove drop 2 removes the instance",
+		brokenFootnote([]proto.InstanceInfo{{ID: "1", State: proto.StateRunning}, {ID: "2", State: proto.StateBroken}}))
+	assert.Equal(t, "BROKEN: the worktrees of 2, 3 were deleted; grove drop <id> removes each instance",
+		brokenFootnote([]proto.InstanceInfo{{ID: "2", State: proto.StateBroken}, {ID: "3", State: proto.StateBroken}}))
+}
+
+func TestMissingInstancePaths(t *testing.T) {
+	instances := []proto.InstanceInfo{
+		{ID: "1", State: proto.StateRunning, WorktreeDir: "/w/1", MainDir: "/main"},
+		{ID: "2", State: proto.StateBroken, WorktreeDir: "/w/2", MainDir: "/main"},
+		{ID: "3", State: proto.StateExited, WorktreeDir: "/w/3", MainDir: "/gone"},
+	}
+	exists := func(path string) bool { return path != "/w/2" && path != "/gone" }
+	assert.Equal(t, []string{
+		"2           worktree  /w/2 (BROKEN)",
+		"3           checkout  /gone (EXITED)",
+	}, missingInstancePaths(instances, exists))
+	assert.Empty(t, missingInstancePaths(instances, func(string) bool { return true }))
+}
+
+func TestResolveTarget(t *testing.T) {
+	env := func(vars map[string]string) func(string) string {
+		return func(k string) string { return vars[k] }
+	}
+	none := env(nil)
+
+	tgt, err := resolveTarget("", none, "/home/u")
+	require.NoError(t, err)
+	assert.Equal(t, daemonTarget{Profile: "default", Root: "/home/u/.grove", Socket: "/home/u/.grove/groved.sock", From: "default"}, tgt)
+	assert.Equal(t, "profile default", tgt.describe())
+
+	tgt, err = resolveTarget("dev", env(map[string]string{"GROVE_ROOT": "/r", "GROVE_PROFILE": "x"}), "/home/u")
+	require.NoError(t, err)
+	assert.Equal(t, "/home/u/.grove-dev", tgt.Root)
+	assert.Equal(t, "profile dev (from --profile)", tgt.describe())
+
+	tgt, err = resolveTarget("", env(map[string]string{"GROVE_ROOT": "/r", "GROVE_PROFILE": "x"}), "/home/u")
+	require.NoError(t, err)
+	assert.Equal(t, daemonTarget{Root: "/r", Socket: "/r/groved.sock", From: "GROVE_ROOT"}, tgt)
+	assert.Equal(t, "root /r (from GROVE_ROOT)", tgt.describe())
+
+	tgt, err = resolveTarget("", env(map[string]string{"GROVE_PROFILE": "x"}), "/home/u")
+	require.NoError(t, err)
+	assert.Equal(t, "/home/u/.grove-x", tgt.Root)
+	assert.Equal(t, "GROVE_PROFILE", tgt.From)
+
+	tgt, err = resolveTarget("dev", env(map[string]string{"GROVE_SOCKET": "/tmp/g.sock"}), "/home/u")
+	require.NoError(t, err)
+	assert.Equal(t, "/home/u/.grove-dev", tgt.Root)
+	assert.Equal(t, "/tmp/g.sock", tgt.Socket)
+	assert.True(t, tgt.SocketOverride)
+
+	_, err = resolveTarget("../x", none, "/home/u")
+	assert.ErrorContains(t, err, `--profile: profile name "../x"`)
+	_, err = resolveTarget("", env(map[string]string{"GROVE_PROFILE": "a b"}), "/home/u")
+	assert.ErrorContains(t, err, "GROVE_PROFILE")
+}
+
+func TestProfileStatuses(t *testing.T) {
+	home := t.TempDir()
+	for _, dir := range []string{".grove", ".grove-dev", ".grove-b", ".grove-no way"} {
+		require.NoError(t, os.Mkdir(filepath.Join(home, dir), 0o755))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(home, ".grove-file"), nil, 0o644))
+	assert.Equal(t, []string{"default", "b", "dev"}, knownProfiles(home))
+
+	running := filepath.Join(home, ".grove", "groved.sock")
+	ping := func(sock string) bool { return sock == running }
+	current, err := resolveTarget("dev", func(string) string { return "" }, home)
+	require.NoError(t, err)
+	statuses := profileStatuses(home, current, ping)
+	require.Len(t, statuses, 3)
+	assert.True(t, statuses[0].Running)
+	assert.False(t, statuses[0].Current)
+	assert.True(t, statuses[2].Current)
+	assert.False(t, statuses[2].Running)
+
+	out := renderProfiles(statuses, home)
+	assert.Contains(t, out, "  default  "+colorGreen+"running")
+	assert.Contains(t, out, "~/.grove-dev\n")
+	assert.Contains(t, out, "* dev      ")
+
+	// A GROVE_ROOT that is no profile's is listed after the profiles.
+	current, err = resolveTarget("", func(k string) string {
+		return map[string]string{"GROVE_ROOT": "/elsewhere"}[k]
+	}, home)
+	require.NoError(t, err)
+	statuses = profileStatuses(home, current, ping)
+	require.Len(t, statuses, 4)
+	assert.Equal(t, profileStatus{Root: "/elsewhere", Socket: "/elsewhere/groved.sock", Current: true}, statuses[3])
+	assert.Contains(t, renderProfiles(statuses, home), "* -        ")
+}
+
+func TestDropQuestion(t *testing.T) {
+	assert.Equal(t, `Delete instance "app" and its worktree and container`, dropQuestion("app", false, false))
+	assert.Equal(t, `Delete instance "app" and its container`, dropQuestion("app", true, false))
+	assert.Equal(t, `Delete instance "app" and its worktree`, dropQuestion("app", false, true))
+	assert.Equal(t, `Delete instance "app"`, dropQuestion("app", true, true))
+}
+
+func TestFormatRemoved(t *testing.T) {
+	assert.Equal(t, "", formatRemoved(proto.InstanceInfo{}))
+	assert.Equal(t, "container removed", formatRemoved(proto.InstanceInfo{ContainerRemoved: true}))
+	assert.Equal(t, "worktree removed", formatRemoved(proto.InstanceInfo{WorktreeRemoved: true}))
+	assert.Equal(t, "container and worktree removed", formatRemoved(proto.InstanceInfo{ContainerRemoved: true, WorktreeRemoved: true}))
+
+	removed := []proto.InstanceInfo{{ID: "4", State: proto.StateFinished, WorktreeDir: "/w/4", MainDir: "/main", WorktreeRemoved: true}}
+	assert.Empty(t, missingInstancePaths(removed, func(path string) bool { return path != "/w/4" }), "removed on purpose")
+}
+
+func TestRenderPeek(t *testing.T) {
+	var lines []string
+	for i := 1; i <= 10; i++ {
+		lines = append(lines, "line "+strconv.Itoa(i))
+	}
+	lines[9] = strings.Repeat("x", 50)
+
+	scroll := 0
+	out := renderPeek("3", lines, 40, 6, &scroll)
+	assert.Contains(t, out, "line 7\033[K\nline 8\033[K\nline 9\033[K\n"+strings.Repeat("x", 40)+"\033[K\n", "the last rows, cut to the width")
+	assert.NotContains(t, out, "line 6")
+	assert.Contains(t, out, "lines 7-10 of 10, the end")
+
+	scroll = 2
+	out = renderPeek("3", lines, 40, 6, &scroll)
+	assert.Contains(t, out, "line 5\033[K\n")
+	assert.NotContains(t, out, "line 9")
+	assert.Contains(t, out, "lines 5-8 of 10, 2 above the end")
+
+	scroll = 100
+	out = renderPeek("3", lines, 40, 6, &scroll)
+	assert.Equal(t, 6, scroll, "pulled back to the top")
+	assert.Contains(t, out, "lines 1-4 of 10")
+
+	scroll = 0
+	assert.Contains(t, renderPeek("3", nil, 40, 6, &scroll), "no output yet")
+}
+
+func TestWatchPeekKeys(t *testing.T) {
+	var p watchPeek
+	assert.False(t, p.key('t'), "left to the tmux integration")
+	for _, b := range []byte("p12\x7f3\r") {
+		assert.True(t, p.key(b))
+	}
+	assert.True(t, p.shown)
+	assert.Equal(t, "13", p.id)
+
+	for _, b := range []byte("kk\x1b[A\x1b[Bj") {
+		p.key(b)
+	}
+	assert.Equal(t, 1, p.scroll)
+	p.key('G')
+	p.key('j')
+	assert.Equal(t, 0, p.scroll, "not below the end")
+
+	p.key('q')
+	assert.False(t, p.shown)
+	p.key('p')
+	assert.Contains(t, p.status(), "peek at instance: ")
+	p.key(0x1b)
+	assert.False(t, p.prompting)
+	assert.Empty(t, p.status())
+}
+
+func TestBuildDaemonArgs(t *testing.T) {
+	assert.Equal(t, []string{"--root", "/home/u/.grove"}, buildDaemonArgs("/home/u/.grove", nil, nil))
+	assert.Equal(t, []string{"--root", "/r", "--projects-dir", "/team/a", "--projects-dir", "/team/b", "--disk-warn", "20GiB"},
+		buildDaemonArgs("/r", []string{"/team/a", "/team/b"}, []string{"--disk-warn", "20GiB"}))
+
+	root := t.TempDir()
+	t.Setenv("GROVE_ROOT", root)
+	t.Setenv("GROVE_PROJECTS_PATH", "/team/a")
+	require.NoError(t, os.WriteFile(filepath.Join(root, "config.yaml"), []byte("daemon_args: [--debug]\n"), 0o644))
+	assert.Equal(t, []string{"--root", root, "--projects-dir", "/team/a", "--debug"}, daemonArgs(root))
+}
+
+// synopsisFlags returns the flags a synopsis names, e.g. "--base" and "-d".
+func synopsisFlags(synopsis string) []string {
+	var flags []string
+	for _, tok := range strings.FieldsFunc(synopsis, func(r rune) bool { return strings.ContainsRune(" \n[]|\"", r) }) {
+		if len(tok) > 1 && tok[0] == '-' && (tok[1] == '-' || 'a' <= tok[1] && tok[1] <= 'z') {
+			flags = append(flags, tok)
+		}
+	}
+	return flags
+}
+
+// TestCommandTable checks that every command can be run and has help, and
+// that its usages' flag lists and synopses agree.
+func TestCommandTable(t *testing.T) {
+	groups := map[string]bool{}
+	for _, g := range commandGroups {
+		groups[g] = true
+	}
+	var global bytes.Buffer
+	writeUsage(&global)
+	seen := map[string]bool{}
+	for _, c := range commandTable() {
+		assert.False(t, seen[c.name], "%s listed twice", c.name)
+		seen[c.name] = true
+		assert.NotNil(t, c.run, c.name)
+		assert.True(t, groups[c.group], "%s: unknown group %q", c.name, c.group)
+		assert.GreaterOrEqual(t, len(c.examples), 2, "%s: examples", c.name)
+		for _, e := range c.examples {
+			assert.Contains(t, e, "grove "+c.name, "%s: example %q", c.name, e)
+		}
+		require.NotEmpty(t, c.usages, c.name)
+		for _, u := range c.usages {
+			assert.True(t, u.synopsis == c.name || strings.HasPrefix(u.synopsis, c.name+" "), "%s: synopsis %q", c.name, u.synopsis)
+			assert.NotEmpty(t, u.desc, u.synopsis)
+			assert.Contains(t, global.String(), "  "+strings.Split(u.synopsis, "\n")[0], "in the global usage")
+
+			named := synopsisFlags(u.synopsis)
+			described := map[string]bool{}
+			for _, f := range u.flags {
+				found := false
+				for _, alias := range strings.Split(f.flag, ", ") {
+					name := strings.Fields(alias)[0]
+					described[name] = true
+					for _, n := range named {
+						found = found || n == name
+					}
+				}
+				assert.True(t, found, "%s: flag %q is not in the synopsis", u.synopsis, f.flag)
+			}
+			for _, n := range named {
+				assert.True(t, described[n], "%s: flag %s is not described", u.synopsis, n)
+			}
+		}
+	}
+	for _, name := range []string{"start", "list", "daemon", "pin", "unpin", "token"} {
+		assert.NotNil(t, findCommand(name), name)
+	}
+	assert.Nil(t, findCommand("help"), "handled by main")
+	assert.Nil(t, findCommand("nope"))
+}
+
+func TestWriteUsageLine(t *testing.T) {
+	var b bytes.Buffer
+	writeUsageLine(&b, "top", "Live usage\nper instance")
+	writeUsageLine(&b, "project import-bundle <file|-> [--overwrite]", "Register")
+	writeUsageLine(&b, "start <project|#>\n[--pin]", "Start")
+	pad := strings.Repeat(" ", helpUsageCol)
+	assert.Equal(t, "  top"+strings.Repeat(" ", helpUsageCol-5)+"Live usage\n"+pad+"per instance\n"+
+		"  project import-bundle <file|-> [--overwrite]\n"+pad+"Register\n"+
+		"  start <project|#>\n        [--pin]\n"+pad+"Start\n", b.String())
+}
+
+func TestWriteCommandHelp(t *testing.T) {
+	help := func(name, sub string) string {
+		var b bytes.Buffer
+		writeCommandHelp(&b, findCommand(name), sub)
+		return b.String()
+	}
+	out := help("start", "")
+	assert.True(t, strings.HasPrefix(out, "Usage:\n  grove start <project|#> <branch>"), out)
+	assert.Contains(t, out, "\nFlags:\n  -d, --detach ")
+	assert.Contains(t, out, "\nExamples:\n  grove start ")
+
+	out = help("project", "create")
+	assert.Contains(t, out, "grove project create <name>")
+	assert.NotContains(t, out, "project delete")
+	assert.Contains(t, out, "--data-dir <path>")
+	assert.NotContains(t, out, "--keep-volumes", "only the subcommand's flags")
+	assert.Contains(t, out, "grove project create api")
+	assert.NotContains(t, out, "grove project fetch api")
+
+	assert.Contains(t, help("project", "nope"), "project delete", "an unknown subcommand shows them all")
+
+	out = help("stop", "")
+	assert.Equal(t, 1, strings.Count(out, "  --stack "), "a flag two usages share is described once")
+}
+
+func TestWantsHelp(t *testing.T) {
+	assert.True(t, wantsHelp([]string{"--help"}))
+	assert.True(t, wantsHelp([]string{"3", "-h"}))
+	assert.False(t, wantsHelp([]string{"3", "-d"}))
+	assert.False(t, wantsHelp([]string{"3", "--", "-h"}), "after --, -h is an argument")
+	assert.False(t, wantsHelp(nil))
+}
+
+func TestNormalizeInstanceArg(t *testing.T) {
+	assert.Equal(t, "3", normalizeInstanceArg("3"))
+	assert.Equal(t, "3", normalizeInstanceArg("#3"))
+	assert.Equal(t, "3", normalizeInstanceArg("03"))
+	assert.Equal(t, "3", normalizeInstanceArg(" 3 "))
+	assert.Equal(t, "fix-login", normalizeInstanceArg("fix-login"))
+	assert.Equal(t, "-", normalizeInstanceArg("-"))
+}
+
+func TestWithinOneEdit(t *testing.T) {
+	assert.True(t, withinOneEdit("11", "1"), "one extra")
+	assert.True(t, withinOneEdit("1", "12"))
+	assert.True(t, withinOneEdit("13", "12"), "one replaced")
+	assert.True(t, withinOneEdit("12", "12"))
+	assert.False(t, withinOneEdit("7", "1"), "any one digit is a replacement away from any other")
+	assert.False(t, withinOneEdit("123", "1"))
+	assert.False(t, withinOneEdit("21", "12"), "two replaced")
+}
+
+func TestInstanceNotFound(t *testing.T) {
+	instances := []proto.InstanceInfo{
+		{ID: "1", Project: "api", Branch: "fix-login", State: proto.StateRunning},
+		{ID: "2", Project: "api", Branch: "fix-logout", State: proto.StateExited},
+		{ID: "12", Project: "web", Branch: "feat/search", State: proto.StateWaiting},
+	}
+
+	out := instanceNotFound("11", instances)
+	assert.True(t, strings.HasPrefix(out, "grove: no instance 11\n\ndid you mean one of:\n  1    api/fix-login  RUNNING\n  12   web/feat/search  WAITING\n"), out)
+	assert.Contains(t, out, "\nlive instances:\n  1    api/fix-login  RUNNING\n  12   web/feat/search  WAITING\n")
+	assert.NotContains(t, out, "fix-logout", "not live")
+
+	out = instanceNotFound("fix-log", instances)
+	assert.Contains(t, out, "did you mean one of:\n  1    api/fix-login  RUNNING\n  2    api/fix-logout  EXITED\n", "an ambiguous prefix lists every match")
+	out = instanceNotFound("feat/", instances)
+	assert.Contains(t, out, "did you mean:\n  12   web/feat/search  WAITING\n")
+	assert.NotContains(t, instanceNotFound("9", instances), "did you mean")
+
+	assert.Equal(t, "grove: no instance 3; there are no instances (grove start creates one)\n", instanceNotFound("3", nil))
+	out = instanceNotFound("3", instances[1:2])
+	assert.Contains(t, out, "no live instances; grove list shows them all")
+}
+
+func TestInstanceList(t *testing.T) {
+	var instances []proto.InstanceInfo
+	for i := 1; i <= 12; i++ {
+		instances = append(instances, proto.InstanceInfo{ID: strconv.Itoa(i), Project: "p", Branch: "b", State: proto.StateRunning})
+	}
+	out := instanceList("live instances", instances, maxListedInstances)
+	assert.Equal(t, maxListedInstances+3, strings.Count(out, "\n"))
+	assert.True(t, strings.HasSuffix(out, "  … and 2 more (grove list)\n"), out)
+	assert.Empty(t, instanceList("none", nil, 0))
+}
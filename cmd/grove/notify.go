@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+// notifyTemplate maps the {{...}} variables a notify command may use to the
+// environment variables holding their values.  Expanding to a variable
+// reference rather than the value keeps file names from being parsed by sh.
+var notifyTemplate = strings.NewReplacer(
+	"{{shortstat}}", "${GROVE_SHORTSTAT}",
+	"{{files}}", "${GROVE_FILES}",
+)
+
+// notifyUser tells the user about inst: it runs the notify command from
+// ~/.grove/config.yaml through sh, with GROVE_INSTANCE, GROVE_PROJECT,
+// GROVE_BRANCH, GROVE_STATE and GROVE_MESSAGE set, e.g.
+//
+//	notify: osascript -e "display notification \"$GROVE_MESSAGE {{shortstat}}\" with title \"grove\""
+//
+// When inst carries a change summary GROVE_SHORTSTAT and GROVE_FILES (the
+// most changed paths, comma-separated) are set too; {{shortstat}} and
+// {{files}} in the command stand for them.  Without a summary they are
+// empty.  Without a notify command it rings the terminal bell.
+func notifyUser(command string, inst proto.InstanceInfo, msg string) error {
+	if command == "" {
+		_, err := os.Stdout.WriteString("\a")
+		return err
+	}
+	var shortstat, files string
+	if c := inst.Changes; c != nil {
+		shortstat, files = c.Shortstat, strings.Join(c.Files, ", ")
+	}
+	cmd := exec.Command("sh", "-c", notifyTemplate.Replace(command))
+	cmd.Env = append(os.Environ(),
+		"GROVE_INSTANCE="+inst.ID,
+		"GROVE_PROJECT="+inst.Project,
+		"GROVE_BRANCH="+inst.Branch,
+		"GROVE_STATE="+inst.State,
+		"GROVE_MESSAGE="+msg,
+		"GROVE_SHORTSTAT="+shortstat,
+		"GROVE_FILES="+files,
+	)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
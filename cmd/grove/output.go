@@ -0,0 +1,207 @@
+package main
+
+// output.go – reporting the results of mutating commands, for people and
+// for scripts.
+//
+// By default a result is a banner such as "✓  Started instance 3".  With
+// --porcelain (anywhere on the command line) a command instead prints
+// exactly one line per result to stdout, "<verb> <field> …", e.g.
+//
+//	started 3
+//	dropped 3
+//	finished 3 ok
+//
+// Everything else — progress, setup output, prompts, summaries — goes to
+// stderr.  These lines are a stable interface: existing verbs and fields are
+// never changed, only new fields appended.  See TestPorcelainGolden.
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// porcelainCommands are the commands whose results are reported through
+// printResult; --porcelain sends the rest of their output to stderr.
+// Project subcommands are listed as "project <sub>".
+var porcelainCommands = map[string]bool{
+	"project create":        true,
+	"project delete":        true,
+	"project fetch":         true,
+	"project move":          true,
+	"project update":        true,
+	"project import-bundle": true,
+	"start":                 true,
+	"scratch":               true,
+	"stop":                  true,
+	"restart":               true,
+	"reopen":                true,
+	"drop":                  true,
+	"finish":                true,
+	"check":                 true,
+	"prune":                 true,
+	"note":                  true,
+	"label":                 true,
+	"ttl":                   true,
+}
+
+var (
+	// porcelain is set by --porcelain.
+	porcelain bool
+	// resultOut receives porcelain result lines: the real stdout, even
+	// after setupPorcelain has pointed os.Stdout at stderr.
+	resultOut io.Writer = os.Stdout
+)
+
+// setupPorcelain strips --porcelain from args (os.Args[1:]) and returns the
+// rest.  If it was given and the command reports results, os.Stdout is
+// redirected to stderr so that only printResult writes to the real stdout.
+func setupPorcelain(args []string) []string {
+	args, porcelain = stripBoolFlag(args, "porcelain", "porcelain")
+	if porcelain && porcelainCommands[commandName(args)] {
+		resultOut = os.Stdout
+		os.Stdout = os.Stderr
+	}
+	return args
+}
+
+// commandName returns the command args invoke, e.g. "start" or
+// "project create".
+func commandName(args []string) string {
+	switch {
+	case len(args) == 0:
+		return ""
+	case args[0] == "project" && len(args) > 1:
+		return "project " + args[1]
+	}
+	return args[0]
+}
+
+// result is the outcome of a mutating command.
+type result struct {
+	Title   string   // banner title, e.g. "Started instance"
+	Subject string   // banner subject, e.g. the instance ID
+	Verb    string   // porcelain verb, e.g. "started"
+	Fields  []string // porcelain fields, e.g. the instance ID
+}
+
+// porcelainLine renders r as its porcelain line, without the newline.
+func (r result) porcelainLine() string {
+	return strings.Join(append([]string{r.Verb}, r.Fields...), " ")
+}
+
+// printResult reports a command's single result: a banner set off by blank
+// lines, or the porcelain line.
+func printResult(r result) {
+	if porcelain {
+		fmt.Fprintln(resultOut, r.porcelainLine())
+		return
+	}
+	fmt.Printf("\n%s✓  %s%s %s%s%s\n\n", colorGreen+colorBold, r.Title, colorReset, colorCyan, r.Subject, colorReset)
+}
+
+// printResultItem reports one of several results, e.g. each instance
+// stopped by stop --all, as a banner line without blank lines around it.
+func printResultItem(r result) {
+	if porcelain {
+		fmt.Fprintln(resultOut, r.porcelainLine())
+		return
+	}
+	fmt.Printf("%s✓  %s%s %s%s%s\n", colorGreen+colorBold, r.Title, colorReset, colorCyan, r.Subject, colorReset)
+}
+
+// Results, one constructor per kind, so each porcelain line is defined once.
+
+func startedResult(id string) result {
+	return result{Title: "Started instance", Subject: id, Verb: "started", Fields: []string{id}}
+}
+
+func stoppedResult(id string) result {
+	return result{Title: "Stopped", Subject: id, Verb: "stopped", Fields: []string{id}}
+}
+
+func restartedResult(id string) result {
+	return result{Title: "Restarted", Subject: id, Verb: "restarted", Fields: []string{id}}
+}
+
+func reopenedResult(id string) result {
+	return result{Title: "Reopened", Subject: id, Verb: "reopened", Fields: []string{id}}
+}
+
+func droppedResult(id string) result {
+	return result{Title: "Dropped", Subject: id, Verb: "dropped", Fields: []string{id}}
+}
+
+func containerRemovedResult(id string) result {
+	return result{Title: "Removed the container of", Subject: id, Verb: "container-removed", Fields: []string{id}}
+}
+
+func worktreeRemovedResult(id string) result {
+	return result{Title: "Removed the worktree of", Subject: id, Verb: "worktree-removed", Fields: []string{id}}
+}
+
+func notedResult(id string) result {
+	return result{Title: "Noted", Subject: id, Verb: "noted", Fields: []string{id}}
+}
+
+func notesClearedResult(id string) result {
+	return result{Title: "Cleared notes", Subject: id, Verb: "notes-cleared", Fields: []string{id}}
+}
+
+func labeledResult(id string) result {
+	return result{Title: "Labeled", Subject: id, Verb: "labeled", Fields: []string{id}}
+}
+
+// ttlSetResult's porcelain line has the unix time the instance expires.
+func ttlSetResult(id string, expiresAt int64) result {
+	subject := id
+	if expiresAt != 0 {
+		subject += ", expiring at " + time.Unix(expiresAt, 0).Format("2006-01-02 15:04")
+	}
+	return result{Title: "Set the time to live of", Subject: subject, Verb: "ttl-set", Fields: []string{id, strconv.FormatInt(expiresAt, 10)}}
+}
+
+func pinnedResult(id string) result {
+	return result{Title: "Pinned", Subject: id, Verb: "pinned", Fields: []string{id}}
+}
+
+func unpinnedResult(id string) result {
+	return result{Title: "Unpinned", Subject: id, Verb: "unpinned", Fields: []string{id}}
+}
+
+func ttlClearedResult(id string) result {
+	return result{Title: "Removed the time to live of", Subject: id, Verb: "ttl-cleared", Fields: []string{id}}
+}
+
+func projectCreatedResult(name string) result {
+	return result{Title: "Created project", Subject: fmt.Sprintf("%q", name), Verb: "project-created", Fields: []string{name}}
+}
+
+func projectDeletedResult(name string) result {
+	return result{Title: "Deleted project", Subject: fmt.Sprintf("%q", name), Verb: "project-deleted", Fields: []string{name}}
+}
+
+func projectUpdatedResult(name string) result {
+	return result{Title: "Updated project", Subject: fmt.Sprintf("%q", name), Verb: "project-updated", Fields: []string{name}}
+}
+
+func projectMovedResult(name string) result {
+	return result{Title: "Moved project", Subject: fmt.Sprintf("%q", name), Verb: "project-moved", Fields: []string{name}}
+}
+
+func projectFetchedResult(name string) result {
+	return result{Title: "Fetched project", Subject: fmt.Sprintf("%q", name), Verb: "project-fetched", Fields: []string{name}}
+}
+
+// streamedResult is the porcelain line for check and finish, which print a
+// per-command summary instead of a banner: "<verb> <id> ok|failed".
+func streamedResult(verb, id string, ok bool) result {
+	outcome := "ok"
+	if !ok {
+		outcome = "failed"
+	}
+	return result{Verb: verb, Fields: []string{id, outcome}}
+}
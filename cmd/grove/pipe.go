@@ -0,0 +1,62 @@
+package main
+
+// pipe.go – output cut short by the reader, as in grove logs 3 | head -5.
+//
+// Go programs die of SIGPIPE when a write to stdout finds the pipe closed,
+// which a shell reports as a failure.  grove ignores SIGPIPE instead, so such
+// writes fail with EPIPE, and treats that as the reader having seen enough:
+// streaming commands stop quietly and exit 0, while commands whose output is
+// incidental to their work (start, check, finish) carry on to the end.
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// ignoreSIGPIPE makes writes to a closed stdout return EPIPE rather than
+// kill grove.
+func ignoreSIGPIPE() {
+	signal.Ignore(syscall.SIGPIPE)
+}
+
+// isBrokenPipe reports whether err comes from writing to a pipe whose reader
+// has gone.
+func isBrokenPipe(err error) bool {
+	return errors.Is(err, syscall.EPIPE)
+}
+
+// exitIfBrokenPipe exits 0 if err comes from a closed output pipe.
+func exitIfBrokenPipe(err error) {
+	if isBrokenPipe(err) {
+		os.Exit(0)
+	}
+}
+
+// copyOutput copies a streamed response from r to w, exiting 0 once the
+// reader of w has gone.
+func copyOutput(w io.Writer, r io.Reader) {
+	_, err := io.Copy(w, r)
+	exitIfBrokenPipe(err)
+}
+
+// pipeWriter writes to w until the reader of w has gone, and then discards
+// the rest, so that the command producing the output still runs to the end.
+type pipeWriter struct {
+	w      io.Writer
+	closed bool
+}
+
+func (p *pipeWriter) Write(b []byte) (int, error) {
+	if p.closed {
+		return len(b), nil
+	}
+	n, err := p.w.Write(b)
+	if isBrokenPipe(err) {
+		p.closed = true
+		return len(b), nil
+	}
+	return n, err
+}
@@ -0,0 +1,234 @@
+package main
+
+// profile.go – which daemon an invocation talks to.
+//
+// Each daemon has a root directory of its own: its socket, instances,
+// projects and logs.  A profile names one: the default profile lives in
+// ~/.grove, profile <name> in ~/.grove-<name>, so a stable daemon and a
+// development build can run side by side.  The root is chosen, first match
+// wins, by
+//
+//	--profile <name>       a global flag, like --porcelain
+//	GROVE_ROOT=<dir>       any directory
+//	GROVE_PROFILE=<name>
+//	~/.grove
+//
+// GROVE_SOCKET=<path> then points the CLI at a socket of its own choosing,
+// e.g. a daemon run by hand with another root; grove does not start a
+// daemon for it.  setupProfile works all of this out once, in main, and
+// rootDir and daemonSocket return what it found.
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// defaultProfile is the name of the profile in ~/.grove.
+const defaultProfile = "default"
+
+// profilePattern is what a profile name may look like; it becomes part of
+// a directory name.
+var profilePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+// daemonTarget is the daemon an invocation talks to.
+type daemonTarget struct {
+	Profile string // the profile's name; "" when GROVE_ROOT chose Root
+	Root    string // the daemon's data directory
+	Socket  string // the socket the CLI connects to
+	From    string // what chose Root: "--profile", "GROVE_ROOT", "GROVE_PROFILE" or "default"
+	// SocketOverride is set when GROVE_SOCKET chose Socket.  grove does
+	// not start a daemon for it.
+	SocketOverride bool
+}
+
+// describe says which daemon t is, e.g. "profile dev (from --profile)".
+func (t daemonTarget) describe() string {
+	what := "profile " + t.Profile
+	if t.Profile == "" {
+		what = "root " + t.Root
+	}
+	if t.From != "default" {
+		what += " (from " + t.From + ")"
+	}
+	return what
+}
+
+// profileRoot returns the root directory of profile name under home.
+func profileRoot(home, name string) string {
+	if name == defaultProfile {
+		return filepath.Join(home, ".grove")
+	}
+	return filepath.Join(home, ".grove-"+name)
+}
+
+// resolveTarget works out the daemon to talk to from the --profile flag,
+// empty if it was not given, and the environment.
+func resolveTarget(flag string, getenv func(string) string, home string) (daemonTarget, error) {
+	var t daemonTarget
+	switch {
+	case flag != "":
+		t.Profile, t.From = flag, "--profile"
+	case getenv("GROVE_ROOT") != "":
+		t.Root, t.From = getenv("GROVE_ROOT"), "GROVE_ROOT"
+		if abs, err := filepath.Abs(t.Root); err == nil {
+			t.Root = abs
+		}
+	case getenv("GROVE_PROFILE") != "":
+		t.Profile, t.From = getenv("GROVE_PROFILE"), "GROVE_PROFILE"
+	default:
+		t.Profile, t.From = defaultProfile, "default"
+	}
+	if t.Root == "" {
+		if !profilePattern.MatchString(t.Profile) {
+			return daemonTarget{}, fmt.Errorf("%s: profile name %q may only contain letters, digits, '-' and '_'", t.From, t.Profile)
+		}
+		t.Root = profileRoot(home, t.Profile)
+	}
+
+	t.Socket = filepath.Join(t.Root, "groved.sock")
+	if sock := getenv("GROVE_SOCKET"); sock != "" {
+		t.Socket, t.SocketOverride = sock, true
+		if abs, err := filepath.Abs(sock); err == nil {
+			t.Socket = abs
+		}
+	}
+	return t, nil
+}
+
+// activeTarget is the daemon this invocation talks to, once setupProfile
+// has run.
+var activeTarget *daemonTarget
+
+// setupProfile strips the global --profile flag from args and resolves the
+// daemon this invocation talks to.  It exits on a bad profile name.
+func setupProfile(args []string) []string {
+	args, names, err := stripValueFlag(args, "profile")
+	if err == nil && len(names) > 1 {
+		err = fmt.Errorf("--profile given more than once")
+	}
+	flag := ""
+	if err == nil && len(names) == 1 {
+		flag = names[0]
+		if flag == "" {
+			err = fmt.Errorf("--profile: want a profile name")
+		}
+	}
+	var t daemonTarget
+	if err == nil {
+		home, _ := os.UserHomeDir()
+		t, err = resolveTarget(flag, os.Getenv, home)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+		os.Exit(1)
+	}
+	activeTarget = &t
+	return args
+}
+
+// currentTarget returns the daemon this invocation talks to.  Before
+// setupProfile has run, as in tests, it is resolved from the environment
+// on each call.
+func currentTarget() daemonTarget {
+	if activeTarget != nil {
+		return *activeTarget
+	}
+	home, _ := os.UserHomeDir()
+	t, err := resolveTarget("", os.Getenv, home)
+	if err != nil {
+		t, _ = resolveTarget(defaultProfile, os.Getenv, home)
+	}
+	return t
+}
+
+// knownProfiles lists the profiles with a root directory under home: the
+// default profile first, then the others by name.
+func knownProfiles(home string) []string {
+	var profiles []string
+	if dirExists(profileRoot(home, defaultProfile)) {
+		profiles = append(profiles, defaultProfile)
+	}
+	matches, _ := filepath.Glob(filepath.Join(home, ".grove-*"))
+	sort.Strings(matches)
+	for _, m := range matches {
+		name := strings.TrimPrefix(filepath.Base(m), ".grove-")
+		if profilePattern.MatchString(name) && name != defaultProfile && dirExists(m) {
+			profiles = append(profiles, name)
+		}
+	}
+	return profiles
+}
+
+// profileStatus is one line of grove daemon status.
+type profileStatus struct {
+	Name    string // profile name, or "" for a root that is not a profile's
+	Root    string
+	Socket  string
+	Running bool
+	Current bool // the daemon this invocation talks to
+}
+
+// profileStatuses lists the known profiles under home and whether their
+// daemons answer, with the current target added if it is not one of them.
+func profileStatuses(home string, current daemonTarget, ping func(string) bool) []profileStatus {
+	var statuses []profileStatus
+	found := false
+	for _, name := range knownProfiles(home) {
+		root := profileRoot(home, name)
+		s := profileStatus{Name: name, Root: root, Socket: filepath.Join(root, "groved.sock")}
+		if root == current.Root && current.Socket == s.Socket {
+			s.Current, found = true, true
+		}
+		s.Running = ping(s.Socket)
+		statuses = append(statuses, s)
+	}
+	if !found {
+		statuses = append(statuses, profileStatus{
+			Name: current.Profile, Root: current.Root, Socket: current.Socket,
+			Running: ping(current.Socket), Current: true,
+		})
+	}
+	return statuses
+}
+
+// renderProfiles prints statuses as a table for grove daemon status,
+// marking the current daemon with a *.
+func renderProfiles(statuses []profileStatus, home string) string {
+	nameW := len("PROFILE")
+	for _, s := range statuses {
+		nameW = max(nameW, len(s.Name))
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s  %-*s  %-11s  %s%s\n", colorBold, nameW, "PROFILE", "DAEMON", "ROOT", colorReset)
+	for _, s := range statuses {
+		mark, name := " ", s.Name
+		if s.Current {
+			mark = "*"
+		}
+		if name == "" {
+			name = "-"
+		}
+		state := colorDim + "not running" + colorReset
+		if s.Running {
+			state = colorGreen + "running    " + colorReset
+		}
+		where := tildePath(s.Root, home)
+		if s.Socket != filepath.Join(s.Root, "groved.sock") {
+			where += "  " + colorDim + "socket " + s.Socket + colorReset
+		}
+		fmt.Fprintf(&b, "%s %-*s  %s  %s\n", mark, nameW, name, state, where)
+	}
+	return b.String()
+}
+
+// tildePath abbreviates a path under home to ~/....
+func tildePath(path, home string) string {
+	if rel, err := filepath.Rel(home, path); err == nil && home != "" && !strings.HasPrefix(rel, "..") {
+		return filepath.Join("~", rel)
+	}
+	return path
+}
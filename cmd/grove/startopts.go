@@ -0,0 +1,263 @@
+package main
+
+// startopts.go – the options grove start runs with.  A project's grove.yaml
+// may set defaults for them (its defaults section, fetched with the
+// project); flags given on the command line always win, and
+// grove start --show-effective prints the result without starting anything.
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+// startFlags are the grove start flags that have project defaults.  A nil
+// pointer means the flag was not given; an empty value given explicitly
+// clears the default.
+type startFlags struct {
+	detach       bool // -d
+	attach       bool // --attach
+	detachOnIdle time.Duration
+	base         *string
+	agent        *string
+	task         *string
+	ttl          *time.Duration // --ttl; 0 clears the default
+	mode         *string
+	labels       map[string]string
+}
+
+// startOptions are the merged settings for one grove start.  from records
+// where each came from ("flag" or "grove.yaml"), keyed by option name or
+// "label <key>"; options at their built-in default are absent.
+type startOptions struct {
+	Project      string
+	Branch       string
+	Detach       bool
+	DetachOnIdle time.Duration
+	Base         string
+	Agent        string
+	Task         string
+	TTL          time.Duration // 0: no time to live
+	TTLAction    string
+	Mode         string // "": normal
+	Labels       map[string]string
+	from         map[string]string
+}
+
+const (
+	fromFlag   = "flag"
+	fromConfig = "grove.yaml"
+)
+
+// mergeStartOptions lays flags f over the defaults of project info for a
+// start on branch.
+func mergeStartOptions(info proto.ProjectInfo, branch string, f startFlags) startOptions {
+	var d proto.StartDefaults
+	if info.Defaults != nil {
+		d = *info.Defaults
+	}
+	o := startOptions{
+		Project:      info.Name,
+		Branch:       branch,
+		DetachOnIdle: f.detachOnIdle,
+		Labels:       map[string]string{},
+		from:         map[string]string{},
+	}
+
+	switch {
+	case f.detach:
+		o.Detach, o.from["detach"] = true, fromFlag
+	case f.attach || f.detachOnIdle > 0:
+		o.from["detach"] = fromFlag
+	case d.Detach:
+		o.Detach, o.from["detach"] = true, fromConfig
+	}
+
+	pick := func(name string, flag *string, def string) string {
+		switch {
+		case flag != nil:
+			o.from[name] = fromFlag
+			return *flag
+		case def != "":
+			o.from[name] = fromConfig
+		}
+		return def
+	}
+	o.Base = pick("base", f.base, d.Base)
+	o.Agent = pick("agent", f.agent, d.Agent)
+	o.Task = pick("task", f.task, expandTaskTemplate(d.TaskTemplate, info.Name, branch))
+	switch {
+	case f.ttl != nil:
+		o.TTL, o.from["ttl"] = *f.ttl, fromFlag
+	case d.TTL > 0:
+		o.TTL, o.from["ttl"] = time.Duration(d.TTL)*time.Second, fromConfig
+	}
+	o.TTLAction = d.TTLAction
+	o.Mode = pick("mode", f.mode, d.Mode)
+
+	for k, v := range d.Labels {
+		o.Labels[k], o.from["label "+k] = v, fromConfig
+	}
+	for k, v := range f.labels {
+		o.Labels[k], o.from["label "+k] = v, fromFlag
+	}
+	return o
+}
+
+// expandTaskTemplate fills {{project}} and {{branch}} into a task_template.
+func expandTaskTemplate(tmpl, project, branch string) string {
+	return strings.NewReplacer("{{project}}", project, "{{branch}}", branch).Replace(tmpl)
+}
+
+// agentCommand returns the agent grove start will run: the first word of
+// the overriding command line, else the project's agent.command.
+func (o startOptions) agentCommand(info proto.ProjectInfo) string {
+	if fields := strings.Fields(o.Agent); len(fields) > 0 {
+		return fields[0]
+	}
+	return info.AgentCommand
+}
+
+// renderStartOptions prints o for grove start --show-effective, each value
+// followed by where it came from.
+func renderStartOptions(o startOptions, info proto.ProjectInfo) string {
+	var b strings.Builder
+	line := func(name, value, from string) {
+		if from != "" {
+			value += "  (" + from + ")"
+		}
+		fmt.Fprintf(&b, "%-9s %s\n", name+":", value)
+	}
+	line("project", o.Project, "")
+	line("branch", o.Branch, "")
+
+	detach := "no, attach after starting"
+	switch {
+	case o.Detach:
+		detach = "yes"
+	case o.DetachOnIdle > 0:
+		detach = "no, detach after " + o.DetachOnIdle.String() + " idle"
+	}
+	line("detach", detach, o.from["detach"])
+
+	base := o.Base
+	if base == "" {
+		base = "HEAD of the main checkout"
+	}
+	line("base", base, o.from["base"])
+
+	switch {
+	case o.Agent != "":
+		line("agent", o.Agent, o.from["agent"])
+	case info.AgentCommand != "":
+		line("agent", info.AgentCommand, "agent.command")
+	default:
+		line("agent", "-", "")
+	}
+
+	keys := make([]string, 0, len(o.Labels))
+	for k := range o.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	labels := make([]string, len(keys))
+	for i, k := range keys {
+		labels[i] = fmt.Sprintf("%s=%s (%s)", k, o.Labels[k], o.from["label "+k])
+	}
+	if len(labels) == 0 {
+		labels = []string{"-"}
+	}
+	line("labels", strings.Join(labels, ", "), "")
+
+	task := o.Task
+	if task == "" {
+		task = "-"
+	}
+	line("task", task, o.from["task"])
+
+	ttl := "-"
+	if o.TTL > 0 {
+		action := o.TTLAction
+		if action == "" {
+			action = proto.TTLFinish
+		}
+		ttl = o.TTL.String() + ", then " + action
+	}
+	line("ttl", ttl, o.from["ttl"])
+
+	mode := o.Mode
+	if mode == "" {
+		mode = proto.ModeNormal
+	}
+	line("mode", mode, o.from["mode"])
+	return b.String()
+}
+
+// stripMode removes --mode <mode> from args and returns the remaining args
+// and the mode, or nil if it was not given.  Exits on an unknown mode.
+func stripMode(args []string) ([]string, *string) {
+	rest, mode := stripOnceFlag(args, "mode")
+	if mode != nil {
+		switch *mode {
+		case proto.ModePlan, proto.ModeNormal, proto.ModeAuto:
+		default:
+			fmt.Fprintf(os.Stderr, "grove: --mode: want %s, %s or %s, got %q\n", proto.ModePlan, proto.ModeNormal, proto.ModeAuto, *mode)
+			os.Exit(1)
+		}
+	}
+	return rest, mode
+}
+
+// stripTTL removes --ttl <duration> from args and returns the remaining args
+// and the duration, or nil if it was not given.  Exits on a malformed
+// duration.
+func stripTTL(args []string) ([]string, *time.Duration) {
+	rest, value := stripOnceFlag(args, "ttl")
+	if value == nil {
+		return rest, nil
+	}
+	ttl, err := parseTTL(*value)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grove: --ttl: %v\n", err)
+		os.Exit(1)
+	}
+	return rest, &ttl
+}
+
+// parseTTL parses a time to live: a duration such as 2h, or 0 or off for
+// none.
+func parseTTL(s string) (time.Duration, error) {
+	if s == "off" {
+		return 0, nil
+	}
+	ttl, err := time.ParseDuration(s)
+	if err != nil || ttl < 0 {
+		return 0, fmt.Errorf("want a duration such as 2h, or off, got %q", s)
+	}
+	if ttl > 0 && ttl < time.Second {
+		return 0, fmt.Errorf("%s is less than a second", s)
+	}
+	return ttl, nil
+}
+
+// stripOnceFlag removes --name <value> from args like stripValueFlag and
+// returns the value, or nil if the flag was not given.  It exits if the flag
+// is malformed or repeated.
+func stripOnceFlag(args []string, name string) ([]string, *string) {
+	rest, values, err := stripValueFlag(args, name)
+	if err == nil && len(values) > 1 {
+		err = fmt.Errorf("--%s given more than once", name)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+		os.Exit(1)
+	}
+	if len(values) == 0 {
+		return rest, nil
+	}
+	return rest, &values[0]
+}
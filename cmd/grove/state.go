@@ -0,0 +1,103 @@
+package main
+
+// state.go – per-user CLI state kept between invocations, currently just the
+// last instance used, so `grove attach -` (or --last) works like `cd -`.
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// cliState is stored in <root>/state.json.
+type cliState struct {
+	LastInstance string `json:"last_instance,omitempty"` // updated by start, attach, restart and check
+}
+
+// lastInstanceCommands accept "-" or --last in place of an instance ID.
+var lastInstanceCommands = map[string]bool{
+	"attach": true,
+	"logs":   true,
+	"stop":   true,
+	"check":  true,
+	"finish": true,
+	"dir":    true,
+	"drop":   true,
+}
+
+// lastInstanceArg is what --last is turned into before a command parses its
+// arguments: a plain positional argument that flag parsing leaves alone.
+const lastInstanceArg = "-"
+
+// setupLastInstance rewrites --last in args (os.Args[1:]) to "-" for the
+// commands that accept it, so each only has to resolve its instance
+// argument with resolveInstanceArg.
+func setupLastInstance(args []string) []string {
+	if len(args) == 0 || !lastInstanceCommands[args[0]] {
+		return args
+	}
+	out := make([]string, len(args))
+	for i, a := range args {
+		if a == "--last" || a == "-last" {
+			a = lastInstanceArg
+		}
+		out[i] = a
+	}
+	return out
+}
+
+func statePath() string {
+	return filepath.Join(rootDir(), "state.json")
+}
+
+// loadState reads the CLI state.  A missing or unreadable file yields the
+// zero state.
+func loadState() cliState {
+	var st cliState
+	if data, err := os.ReadFile(statePath()); err == nil {
+		_ = json.Unmarshal(data, &st)
+	}
+	return st
+}
+
+// saveState writes st atomically — to a temporary file renamed over the
+// old one — so concurrent invocations never see a partial file.
+func saveState(st cliState) error {
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(statePath())
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".state-*.json")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), statePath()); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+// rememberInstance records id as the last instance used.  Failing to do so
+// never fails the command.
+func rememberInstance(id string) {
+	st := loadState()
+	if st.LastInstance == id {
+		return
+	}
+	st.LastInstance = id
+	_ = saveState(st)
+}
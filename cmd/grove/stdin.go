@@ -0,0 +1,68 @@
+package main
+
+// stdin.go – passing the terminal's input to check and finish commands
+// marked tty: true in grove.yaml.
+//
+// Unlike attach, the terminal is only put in cbreak mode: keys are sent as
+// they are typed and not echoed locally (the command's terminal echoes
+// them), but output processing and Ctrl-C keep working as usual, since most
+// of the stream is ordinary command output.
+
+import (
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+	"golang.org/x/term"
+)
+
+// interactiveStream reports whether a check or finish run can offer its
+// commands a terminal: both stdin and out must be one.
+func interactiveStream(out *os.File) bool {
+	return term.IsTerminal(int(os.Stdin.Fd())) && term.IsTerminal(int(out.Fd()))
+}
+
+// setCbreak turns off canonical mode and echo on the terminal fd and
+// returns a function restoring its previous settings.
+func setCbreak(fd int) (restore func(), err error) {
+	old, err := unix.IoctlGetTermios(fd, ioctlReadTermios)
+	if err != nil {
+		return nil, err
+	}
+	t := *old
+	t.Lflag &^= unix.ICANON | unix.ECHO
+	t.Cc[unix.VMIN] = 1
+	t.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(fd, ioctlWriteTermios, &t); err != nil {
+		return nil, err
+	}
+	return func() { unix.IoctlSetTermios(fd, ioctlWriteTermios, old) }, nil
+}
+
+// terminalInput puts the terminal in cbreak mode and returns stdin, for
+// the client to forward to the daemon, and a function that restores the
+// terminal.  A signal that ends grove restores it too.
+func terminalInput() (in io.Reader, stop func()) {
+	fd := int(os.Stdin.Fd())
+	restore, err := setCbreak(fd)
+	if err != nil {
+		restore = func() {}
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		if _, ok := <-sigs; ok {
+			restore()
+			os.Exit(130)
+		}
+	}()
+
+	return os.Stdin, func() {
+		signal.Stop(sigs)
+		close(sigs)
+		restore()
+	}
+}
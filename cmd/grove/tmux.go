@@ -0,0 +1,279 @@
+package main
+
+// tmux.go – one tmux window per instance, for people who live in tmux.
+// grove attach --tmux, grove watch's t key and grove tmux sync all shell out
+// to the tmux CLI.  A window is named grove-<project>-<id>, gets a suffix
+// such as [crashed] once its instance reaches a terminal state, and carries
+// the instance ID in the @grove-instance window option: that, not the name,
+// is how grove finds its windows again.
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+// tmuxInstanceOption is the window option holding a grove window's instance ID.
+const tmuxInstanceOption = "@grove-instance"
+
+// runTmux runs the tmux CLI and returns its trimmed stdout.  Tests replace it.
+var runTmux = func(args ...string) (string, error) {
+	out, err := exec.Command("tmux", args...).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return "", fmt.Errorf("tmux %s: %s", args[0], strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", fmt.Errorf("tmux %s: %v", args[0], err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// checkTmux returns an error saying what is missing if grove cannot open
+// tmux windows: tmux itself, or a tmux session to open them in.
+func checkTmux(lookPath func(string) (string, error)) error {
+	if _, err := lookPath("tmux"); err != nil {
+		return errors.New("tmux is not installed (not found on PATH)")
+	}
+	if os.Getenv("TMUX") == "" {
+		return errors.New("not inside a tmux session; run grove from a tmux window")
+	}
+	return nil
+}
+
+// tmuxWindowName returns the name of inst's window: grove-<project>-<id>,
+// with the state in brackets once the instance has ended.
+func tmuxWindowName(inst proto.InstanceInfo) string {
+	name := "grove-" + inst.Project + "-" + inst.ID
+	if proto.IsTerminal(inst.State) {
+		name += " [" + strings.ToLower(inst.State) + "]"
+	}
+	return name
+}
+
+// tmuxWindow is a grove window in the current tmux session.
+type tmuxWindow struct {
+	ID       string // tmux's window ID, e.g. @3
+	Instance string
+	Name     string
+	Dead     bool // its attach command has exited (remain-on-exit)
+}
+
+// listTmuxWindows returns the grove windows of the current tmux session.
+func listTmuxWindows() ([]tmuxWindow, error) {
+	out, err := runTmux("list-windows", "-F", "#{window_id}\t#{"+tmuxInstanceOption+"}\t#{pane_dead}\t#{window_name}")
+	if err != nil {
+		return nil, err
+	}
+	return parseTmuxWindows(out), nil
+}
+
+// parseTmuxWindows parses the output of listTmuxWindows' list-windows,
+// skipping windows that are not grove's.
+func parseTmuxWindows(out string) []tmuxWindow {
+	var windows []tmuxWindow
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.SplitN(line, "\t", 4)
+		if len(fields) != 4 || fields[1] == "" {
+			continue
+		}
+		windows = append(windows, tmuxWindow{ID: fields[0], Instance: fields[1], Dead: fields[2] == "1", Name: fields[3]})
+	}
+	return windows
+}
+
+// tmuxAttachCommand returns the shell command a window runs to attach to
+// instanceID: this grove binary, with the daemon it talks to passed on
+// since the tmux server's environment need not choose the same one.
+func tmuxAttachCommand(instanceID string, detachOnIdle string) string {
+	exe, err := os.Executable()
+	if err != nil {
+		exe = "grove"
+	}
+	cmd := "exec " + shellQuote(exe) + " attach " + shellQuote(instanceID)
+	if detachOnIdle != "" {
+		cmd += " --detach-on-idle " + shellQuote(detachOnIdle)
+	}
+	t := currentTarget()
+	if t.SocketOverride {
+		cmd = "GROVE_SOCKET=" + shellQuote(t.Socket) + " " + cmd
+	}
+	if t.From != "default" {
+		cmd = "GROVE_ROOT=" + shellQuote(t.Root) + " " + cmd
+	}
+	return cmd
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// openTmuxWindow opens a window attached to inst and returns its ID.  With
+// background set it does not switch to it.
+func openTmuxWindow(inst proto.InstanceInfo, background bool, detachOnIdle string) (string, error) {
+	args := []string{"new-window", "-P", "-F", "#{window_id}", "-n", tmuxWindowName(inst)}
+	if background {
+		args = append(args, "-d")
+	}
+	id, err := runTmux(append(args, tmuxAttachCommand(inst.ID, detachOnIdle))...)
+	if err != nil {
+		return "", err
+	}
+	_, err = runTmux("set-option", "-w", "-t", id, tmuxInstanceOption, inst.ID)
+	return id, err
+}
+
+// tmuxAttach switches to inst's window, opening it if there is none and
+// restarting the attach if it had ended.
+func tmuxAttach(inst proto.InstanceInfo, detachOnIdle string) error {
+	windows, err := listTmuxWindows()
+	if err != nil {
+		return err
+	}
+	for _, w := range windows {
+		if w.Instance != inst.ID {
+			continue
+		}
+		if w.Dead {
+			// Kept open by markTmuxWindow; a detach may close it again.
+			runTmux("set-option", "-wu", "-t", w.ID, "remain-on-exit")
+			if _, err := runTmux("respawn-pane", "-t", w.ID, tmuxAttachCommand(inst.ID, detachOnIdle)); err != nil {
+				return err
+			}
+		}
+		if name := tmuxWindowName(inst); w.Name != name {
+			if _, err := runTmux("rename-window", "-t", w.ID, name); err != nil {
+				return err
+			}
+		}
+		_, err := runTmux("select-window", "-t", w.ID)
+		return err
+	}
+	_, err = openTmuxWindow(inst, false, detachOnIdle)
+	return err
+}
+
+// tmuxSyncResult counts what syncTmux did.
+type tmuxSyncResult struct {
+	Opened, Closed, Renamed int
+}
+
+func (r tmuxSyncResult) String() string {
+	return fmt.Sprintf("%d opened, %d closed, %d renamed", r.Opened, r.Closed, r.Renamed)
+}
+
+// tmuxSyncPlan is what syncTmux will do to bring the session's grove
+// windows in line with instances.
+type tmuxSyncPlan struct {
+	Open   []proto.InstanceInfo // live instances without a window
+	Close  []tmuxWindow         // windows of dropped instances
+	Rename map[string]string    // window ID → new name
+}
+
+// planTmuxSync compares the grove windows with instances, every instance
+// the daemon knows about.  open picks the live instances to open windows
+// for; nil means all of them.
+func planTmuxSync(windows []tmuxWindow, instances []proto.InstanceInfo, open func(proto.InstanceInfo) bool) tmuxSyncPlan {
+	plan := tmuxSyncPlan{Rename: map[string]string{}}
+	byID := make(map[string]proto.InstanceInfo, len(instances))
+	for _, inst := range instances {
+		byID[inst.ID] = inst
+	}
+	hasWindow := map[string]bool{}
+	for _, w := range windows {
+		inst, ok := byID[w.Instance]
+		if !ok {
+			plan.Close = append(plan.Close, w)
+			continue
+		}
+		hasWindow[inst.ID] = true
+		if name := tmuxWindowName(inst); w.Name != name {
+			plan.Rename[w.ID] = name
+		}
+	}
+	for _, inst := range instances {
+		if hasWindow[inst.ID] || proto.IsTerminal(inst.State) || (open != nil && !open(inst)) {
+			continue
+		}
+		plan.Open = append(plan.Open, inst)
+	}
+	return plan
+}
+
+// syncTmux opens windows in the background for live instances that have
+// none (those open picks, or all if nil), closes the windows of dropped
+// instances and renames the rest after their instances' states.
+func syncTmux(instances []proto.InstanceInfo, open func(proto.InstanceInfo) bool) (tmuxSyncResult, error) {
+	var res tmuxSyncResult
+	windows, err := listTmuxWindows()
+	if err != nil {
+		return res, err
+	}
+	plan := planTmuxSync(windows, instances, open)
+	for _, w := range plan.Close {
+		if _, err := runTmux("kill-window", "-t", w.ID); err != nil {
+			return res, err
+		}
+		res.Closed++
+	}
+	for id, name := range plan.Rename {
+		if _, err := runTmux("rename-window", "-t", id, name); err != nil {
+			return res, err
+		}
+		res.Renamed++
+	}
+	for _, inst := range plan.Open {
+		if _, err := openTmuxWindow(inst, true, ""); err != nil {
+			return res, err
+		}
+		res.Opened++
+	}
+	return res, nil
+}
+
+// markTmuxWindow renames the tmux window grove attach is running in, if it
+// is instanceID's, after state, the state its agent ended in, and keeps the
+// window open so its last output stays readable.  A plain detach closes the
+// window as usual.
+func markTmuxWindow(instanceID, state string) {
+	pane := os.Getenv("TMUX_PANE")
+	if pane == "" {
+		return
+	}
+	if id, err := runTmux("show-options", "-wqv", "-t", pane, tmuxInstanceOption); err != nil || id != instanceID {
+		return
+	}
+	inst := findInstance(instanceID)
+	if inst == nil {
+		return
+	}
+	inst.State = state
+	runTmux("set-option", "-w", "-t", pane, "remain-on-exit", "on")
+	runTmux("rename-window", "-t", pane, tmuxWindowName(*inst))
+}
+
+// cmdTmux handles: grove tmux sync
+//
+// Reconciles the current tmux session's grove windows with the daemon's
+// instances.
+func cmdTmux() {
+	if len(os.Args) != 3 || os.Args[2] != "sync" {
+		fmt.Fprintln(os.Stderr, "usage: grove tmux sync")
+		os.Exit(1)
+	}
+	if err := checkTmux(exec.LookPath); err != nil {
+		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+		os.Exit(1)
+	}
+	resp := mustRequest(proto.Request{Type: proto.ReqList})
+	res, err := syncTmux(resp.Instances, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grove: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("tmux windows: %s\n", res)
+}
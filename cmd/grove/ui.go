@@ -1,6 +1,12 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
 
 const (
 	colorBold   = "\033[1m"
@@ -22,6 +28,8 @@ func colorState(state string) string {
 		return "\033[36m"
 	case "CHECKING":
 		return "\033[36m"
+	case "READY":
+		return "\033[1;35m"
 	case "EXITED":
 		return "\033[2m"
 	case "CRASHED":
@@ -30,6 +38,10 @@ func colorState(state string) string {
 		return "\033[33m"
 	case "FINISHED":
 		return "\033[2m"
+	case "FINISH_FAILED", "FAILED_SETUP", "STALLED":
+		return "\033[31m"
+	case "BROKEN":
+		return "\033[1;31m"
 	default:
 		return ""
 	}
@@ -48,6 +60,121 @@ func formatUptime(secs int64) string {
 	return fmt.Sprintf("%dh%02dm", secs/3600, (secs%3600)/60)
 }
 
+// formatInState renders how long an instance has been in its current state
+// for the IN-STATE column, or "-" if the daemon does not know.
+func formatInState(inst proto.InstanceInfo, now time.Time) string {
+	if inst.StateSince == 0 {
+		return "-"
+	}
+	return formatUptime(now.Unix() - inst.StateSince)
+}
+
+// formatTTL renders how long inst has left to live for the TTL column:
+// "-" if it has no time to live, "expired" once the daemon is acting on it.
+func formatTTL(inst proto.InstanceInfo, now time.Time) string {
+	switch {
+	case inst.ExpiresAt == 0:
+		return "-"
+	case inst.ExpiresAt <= now.Unix():
+		return "expired"
+	}
+	return formatUptime(inst.ExpiresAt - now.Unix())
+}
+
+// formatMode renders inst's agent mode for the MODE column, padded to its
+// width: "-" for an agent without modes, auto in yellow, since such an
+// agent changes files without review.
+func formatMode(inst proto.InstanceInfo) string {
+	switch inst.Mode {
+	case "":
+		return fmt.Sprintf("%-6s", "-")
+	case proto.ModeAuto:
+		return fmt.Sprintf("%s%-6s%s", colorYellow, inst.Mode, colorReset)
+	}
+	return fmt.Sprintf("%-6s", inst.Mode)
+}
+
+// renderHelperAgents renders the helper agents section of grove status, one
+// line per agent: its name, state and how long it has been in it, and its
+// command line.
+func renderHelperAgents(agents []proto.AgentInfo, now time.Time) string {
+	width := 0
+	for _, a := range agents {
+		width = max(width, len(a.Name))
+	}
+	var b strings.Builder
+	for _, a := range agents {
+		inState := "-"
+		if a.StateSince > 0 {
+			inState = formatUptime(now.Unix() - a.StateSince)
+		}
+		fmt.Fprintf(&b, "  %-*s  %s%-7s%s %s(for %s)%s  %s\n", width, a.Name,
+			colorState(a.State), a.State, colorReset, colorDim, inState, colorReset, strings.Join(a.Argv, " "))
+	}
+	return b.String()
+}
+
+// remoteStaleAfter is how old a remote status may be before its age is
+// shown.  The daemon refreshes every few minutes, so an older value means the
+// remote could not be reached.
+const remoteStaleAfter = 15 * time.Minute
+
+// formatRemote renders an instance's branch status on origin for the REMOTE
+// column: "pushed", "local-only", or "-" if never queried.  A stale value is
+// suffixed with its age, e.g. "pushed (3h)".
+func formatRemote(r *proto.RemoteStatus, now time.Time) string {
+	if r == nil {
+		return "-"
+	}
+	s := "local-only"
+	if r.Pushed {
+		s = "pushed"
+	}
+	if age := now.Sub(time.Unix(r.CheckedAt, 0)); age > remoteStaleAfter {
+		s += " (" + formatAge(age) + ")"
+	}
+	return s
+}
+
+// formatAge renders d in its largest whole unit: "45s", "59m", "3h", "2d",
+// "5w".
+func formatAge(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", max(0, int(d.Seconds())))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	case d < 7*24*time.Hour:
+		return fmt.Sprintf("%dd", int(d.Hours()/24))
+	}
+	return fmt.Sprintf("%dw", int(d.Hours()/(7*24)))
+}
+
+// instanceUptime returns how many seconds inst has been up: until its agent
+// ended, or until now.
+func instanceUptime(inst proto.InstanceInfo, now time.Time) int64 {
+	end := now.Unix()
+	if inst.EndedAt > 0 {
+		end = inst.EndedAt
+	}
+	return end - inst.CreatedAt
+}
+
+// formatCreated renders when inst was created for the CREATED column of
+// grove list: "2h ago", or with absolute the local time in RFC 3339.
+func formatCreated(inst proto.InstanceInfo, now time.Time, absolute bool) string {
+	if inst.CreatedAt == 0 {
+		return "-"
+	}
+	created := time.Unix(inst.CreatedAt, 0)
+	if absolute {
+		return created.Format(time.RFC3339)
+	}
+	return formatAge(now.Sub(created)) + " ago"
+}
+
 func truncate(s string, n int) string {
 	if n <= 0 {
 		return ""
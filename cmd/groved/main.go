@@ -2,22 +2,47 @@
 //
 // Usage:
 //
-//	groved [--root <dir>]
+//	groved [--root <dir>] [--projects-dir <dir> ...] [--debug[=trace]] [--pprof-addr <host:port>]
+//	       [--disk-warn <size>] [--disk-min <size>] [--lock-wait-notice <duration>]
+//
+// Project registrations are read from <root>/projects and then from each
+// --projects-dir in order (default: GROVE_PROJECTS_PATH); see package
+// registry.
 //
 // The daemon listens on a Unix domain socket at <root>/groved.sock and
 // handles commands from the grove CLI.  It is normally started automatically
 // by grove; you do not need to run it by hand.
+//
+// To debug it, stop the running daemon and run groved --debug in a terminal:
+// it logs every request and response (secrets redacted) to stderr, and with
+// --debug=trace every attach frame too.  SIGUSR1 switches this logging off
+// and on.  --pprof-addr serves net/http/pprof on a loopback address.
+//
+// Starts warn when the filesystem of the root or of Docker's data root has
+// less than --disk-warn free (default 10GiB), and are refused below
+// --disk-min (default 2GiB); 0 turns either check off.
+//
+// An operation that waits longer than --lock-wait-notice (default 3s) for
+// another on the same project says so, in the log and to its client if it
+// streams output; 0 turns this off.
 package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
+	"net"
+	"net/http"
+	_ "net/http/pprof"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"syscall"
 
 	"github.com/gandalfthegui/grove/internal/daemon"
+	"github.com/gandalfthegui/grove/internal/registry"
+	"github.com/gandalfthegui/grove/pkg/proto"
 )
 
 func main() {
@@ -31,9 +56,39 @@ func main() {
 	}
 
 	rootDir := flag.String("root", defaultRoot, "groved data directory (env: GROVE_ROOT)")
+	var projectDirs stringList
+	flag.Var(&projectDirs, "projects-dir", "extra project registration directory, searched after <root>/projects; repeatable (env: GROVE_PROJECTS_PATH)")
+	var debug debugFlag
+	flag.Var(&debug, "debug", "log requests and responses; --debug=trace also logs attach frames (SIGUSR1 toggles)")
+	pprofAddr := flag.String("pprof-addr", "", "serve net/http/pprof on this loopback address, e.g. localhost:6060")
+	diskWarn, diskMin := byteSize(daemon.DefaultDiskWarn), byteSize(daemon.DefaultDiskMin)
+	flag.Var(&diskWarn, "disk-warn", "warn when starting with less free disk space than this, e.g. 10GiB (0: never)")
+	flag.Var(&diskMin, "disk-min", "refuse to start with less free disk space than this, e.g. 2GiB (0: never)")
+	lockWaitNotice := flag.Duration("lock-wait-notice", daemon.DefaultLockWaitNotice, "say so when an operation waits longer than this for another on its project (0: never)")
 	flag.Parse()
+	daemon.SetDebugLevel(daemon.DebugLevel(debug))
+	daemon.SetDiskThresholds(uint64(diskWarn), uint64(diskMin))
+	daemon.SetLockWaitNotice(*lockWaitNotice)
+	if *pprofAddr != "" {
+		if err := checkLoopback(*pprofAddr); err != nil {
+			log.Fatalf("--pprof-addr: %v", err)
+		}
+	}
+	if len(projectDirs) == 0 {
+		projectDirs = registry.PathFromEnv()
+	}
 
-	d, err := daemon.New(*rootDir)
+	// Take the root lock before touching any state, so that of several
+	// daemons started at once only one loads instances and listens.
+	if _, err := daemon.LockRoot(*rootDir); err != nil {
+		log.Printf("daemon init: %v", err)
+		os.Exit(0)
+	}
+	// The exact command line, to tell a daemon grove started on demand from
+	// one a LaunchAgent or systemd unit started with other flags.
+	log.Printf("groved started (pid %d): %q", os.Getpid(), os.Args)
+
+	d, err := daemon.New(*rootDir, projectDirs)
 	if err != nil {
 		log.Printf("daemon init: %v", err)
 		// Exit 0 so launchd / systemd does not restart the daemon in a tight
@@ -45,6 +100,23 @@ func main() {
 
 	socketPath := filepath.Join(*rootDir, "groved.sock")
 
+	if *pprofAddr != "" {
+		go func() {
+			log.Printf("pprof listening on http://%s/debug/pprof/", *pprofAddr)
+			if err := http.ListenAndServe(*pprofAddr, nil); err != nil {
+				log.Printf("pprof: %v", err)
+			}
+		}()
+	}
+
+	usr1 := make(chan os.Signal, 1)
+	signal.Notify(usr1, syscall.SIGUSR1)
+	go func() {
+		for range usr1 {
+			log.Printf("SIGUSR1: debug logging %s", daemon.ToggleDebug())
+		}
+	}()
+
 	// Graceful shutdown on SIGINT / SIGTERM.
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
@@ -59,3 +131,53 @@ func main() {
 		log.Fatalf("daemon run: %v", err)
 	}
 }
+
+// debugFlag is the value of --debug: a boolean flag that also accepts
+// "trace".
+type debugFlag daemon.DebugLevel
+
+func (f *debugFlag) String() string { return daemon.DebugLevel(*f).String() }
+
+func (f *debugFlag) Set(v string) error {
+	l, err := daemon.ParseDebugLevel(v)
+	*f = debugFlag(l)
+	return err
+}
+
+func (f *debugFlag) IsBoolFlag() bool { return true }
+
+// byteSize is the value of a flag giving a size such as 2GiB.
+type byteSize uint64
+
+func (s *byteSize) String() string { return proto.FormatBytes(uint64(*s)) }
+
+func (s *byteSize) Set(v string) error {
+	n, err := proto.ParseBytes(v)
+	*s = byteSize(n)
+	return err
+}
+
+// checkLoopback refuses an address pprof would serve beyond this machine.
+func checkLoopback(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+	return fmt.Errorf("%s is not a loopback address", addr)
+}
+
+// stringList is a flag.Value collecting every occurrence of a repeated flag.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
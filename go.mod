@@ -5,6 +5,7 @@ go 1.22
 require (
 	github.com/creack/pty v1.1.21
 	github.com/stretchr/testify v1.11.1
+	golang.org/x/sys v0.17.0
 	golang.org/x/term v0.17.0
 	gopkg.in/yaml.v3 v3.0.1
 )
@@ -12,5 +13,4 @@ require (
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	golang.org/x/sys v0.17.0 // indirect
 )
@@ -0,0 +1,194 @@
+// Package archive streams files and directory trees as tar archives.  It is
+// shared by the daemon (internal/daemon) and the CLI (cmd/grove) to copy
+// files into and out of instance worktrees.
+package archive
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SafeJoin resolves rel against root and returns the absolute path.  rel is
+// always interpreted relative to root (a leading "/" is ignored).  It returns
+// an error if the lexical result, or the real path after resolving symlinks
+// in its existing prefix, lies outside root.
+func SafeJoin(root, rel string) (string, error) {
+	cleaned := filepath.Clean(strings.TrimLeft(rel, "/"))
+	if cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("path %q escapes the worktree", rel)
+	}
+	target := filepath.Join(root, cleaned)
+
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+	realTarget, err := evalExistingPrefix(target)
+	if err != nil {
+		return "", err
+	}
+	if !within(realRoot, realTarget) {
+		return "", fmt.Errorf("path %q escapes the worktree", rel)
+	}
+	return target, nil
+}
+
+// Write writes src (a file, symlink, or directory tree) to w as a tar
+// archive.  Entry names are rooted at filepath.Base(src).
+func Write(w io.Writer, src string) error {
+	tw := tar.NewWriter(w)
+	base := filepath.Dir(src)
+
+	err := filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		var link string
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(fi, link)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(base, path)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if fi.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// Extract reads a tar archive produced by Write from r and materialises it
+// at dest, following cp semantics: if dest is an existing directory the
+// archive root is created inside it; otherwise the archive root is created
+// as dest itself.  Entries that would land outside dest are rejected.
+func Extract(r io.Reader, dest string) error {
+	parent, rename := dest, ""
+	if fi, err := os.Stat(dest); err != nil || !fi.IsDir() {
+		parent, rename = filepath.Dir(dest), filepath.Base(dest)
+	}
+	realParent, err := filepath.EvalSymlinks(parent)
+	if err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := filepath.Clean(filepath.FromSlash(hdr.Name))
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("archive entry %q escapes the destination", hdr.Name)
+		}
+		if rename != "" {
+			_, rest, _ := strings.Cut(name, string(filepath.Separator))
+			name = filepath.Join(rename, rest)
+		}
+		target := filepath.Join(realParent, name)
+
+		// The parent must resolve inside the destination so an earlier
+		// symlink entry cannot redirect later writes elsewhere.
+		realDir, err := evalExistingPrefix(filepath.Dir(target))
+		if err != nil {
+			return err
+		}
+		if !within(realParent, realDir) {
+			return fmt.Errorf("archive entry %q escapes the destination", hdr.Name)
+		}
+
+		mode := os.FileMode(hdr.Mode).Perm()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, mode|0o700); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			// Never write through an existing symlink at the target itself.
+			if fi, err := os.Lstat(target); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+				os.Remove(target)
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			os.Remove(target)
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return err
+			}
+		default:
+			// Devices, FIFOs, hard links etc. have no place in a worktree copy.
+		}
+	}
+}
+
+// evalExistingPrefix resolves symlinks in the longest existing prefix of
+// path and re-appends the non-existent remainder.
+func evalExistingPrefix(path string) (string, error) {
+	var missing []string
+	for {
+		real, err := filepath.EvalSymlinks(path)
+		if err == nil {
+			return filepath.Join(append([]string{real}, missing...)...), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return "", err
+		}
+		missing = append([]string{filepath.Base(path)}, missing...)
+		path = parent
+	}
+}
+
+// within reports whether path is root or lies beneath it.
+func within(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
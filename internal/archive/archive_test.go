@@ -0,0 +1,101 @@
+package archive_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gandalfthegui/grove/internal/archive"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSafeJoin(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "sub"), 0o755))
+
+	got, err := archive.SafeJoin(root, "sub/file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(root, "sub", "file.txt"), got)
+
+	// Leading slash is relative to the root, not the filesystem.
+	got, err = archive.SafeJoin(root, "/sub")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(root, "sub"), got)
+
+	for _, bad := range []string{"..", "../etc/passwd", "sub/../../x"} {
+		_, err := archive.SafeJoin(root, bad)
+		assert.Error(t, err, "expected %q to be rejected", bad)
+	}
+}
+
+func TestSafeJoinRejectsSymlinkEscape(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	require.NoError(t, os.Symlink(outside, filepath.Join(root, "link")))
+
+	_, err := archive.SafeJoin(root, "link/secret")
+	assert.Error(t, err)
+}
+
+func TestWriteExtractFileIntoDir(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "report.html")
+	require.NoError(t, os.WriteFile(src, []byte("<h1>ok</h1>"), 0o644))
+	dest := t.TempDir()
+
+	var buf bytes.Buffer
+	require.NoError(t, archive.Write(&buf, src))
+	require.NoError(t, archive.Extract(&buf, dest))
+
+	data, err := os.ReadFile(filepath.Join(dest, "report.html"))
+	require.NoError(t, err)
+	assert.Equal(t, "<h1>ok</h1>", string(data))
+}
+
+func TestWriteExtractDirRenamed(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "fixtures")
+	require.NoError(t, os.MkdirAll(filepath.Join(src, "nested"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "nested", "a.json"), []byte("{}"), 0o644))
+	dest := filepath.Join(t.TempDir(), "copied")
+
+	var buf bytes.Buffer
+	require.NoError(t, archive.Write(&buf, src))
+	require.NoError(t, archive.Extract(&buf, dest))
+
+	data, err := os.ReadFile(filepath.Join(dest, "nested", "a.json"))
+	require.NoError(t, err)
+	assert.Equal(t, "{}", string(data))
+}
+
+func TestExtractRejectsTraversal(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "../evil", Mode: 0o644, Size: 1, Typeflag: tar.TypeReg}))
+	_, err := tw.Write([]byte("x"))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+
+	dest := t.TempDir()
+	assert.Error(t, archive.Extract(&buf, dest))
+	_, err = os.Stat(filepath.Join(filepath.Dir(dest), "evil"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestExtractRejectsWriteThroughSymlinkEntry(t *testing.T) {
+	outside := t.TempDir()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "d/", Mode: 0o755, Typeflag: tar.TypeDir}))
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "d/link", Linkname: outside, Typeflag: tar.TypeSymlink}))
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "d/link/pwned", Mode: 0o644, Size: 1, Typeflag: tar.TypeReg}))
+	_, err := tw.Write([]byte("x"))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+
+	assert.Error(t, archive.Extract(&buf, t.TempDir()))
+	_, err = os.Stat(filepath.Join(outside, "pwned"))
+	assert.True(t, os.IsNotExist(err))
+}
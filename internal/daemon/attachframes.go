@@ -0,0 +1,106 @@
+package daemon
+
+// attachframes.go – v2 attach sessions.
+//
+// A v1 attach streams raw PTY output to the client, which leaves no room for
+// anything else.  A client that sets Request.AttachV2 gets the output as
+// data frames instead, with control frames in between: the agent's state
+// whenever it changes, its exit status, and pings (see proto).
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+// attachStateInterval is how often a v2 attach client is sent a state frame
+// if the state changed, or a ping if not.  A variable so tests can shorten
+// it.
+var attachStateInterval = 2 * time.Second
+
+// maxDataFrame is the largest data frame sent to a v2 attach client; the
+// replayed log buffer is split into several.
+const maxDataFrame = 32 << 10
+
+// writeFrame sends one frame to inst's v2 attach client through fw.
+func (inst *Instance) writeFrame(fw *proto.FrameWriter, frameType byte, payload []byte) error {
+	traceFrame(inst.ID, "out", frameType, len(payload))
+	return fw.WriteFrame(frameType, payload)
+}
+
+// dataFrameWriter writes to a v2 attach client as AttachFrameData frames.
+type dataFrameWriter struct {
+	inst *Instance
+	fw   *proto.FrameWriter
+}
+
+func (w dataFrameWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), maxDataFrame)
+		if err := w.inst.writeFrame(w.fw, proto.AttachFrameData, p[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// writeJSONFrame sends v as the JSON payload of a frame of type frameType.
+func (inst *Instance) writeJSONFrame(fw *proto.FrameWriter, frameType byte, v any) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return inst.writeFrame(fw, frameType, payload)
+}
+
+// attachState returns the agent's state for an attached client: the state
+// grove list would show if no client were attached.  The caller holds
+// inst.mu.
+func (inst *Instance) attachState(now time.Time) proto.AttachState {
+	state, since := inst.currentState(now)
+	if state == proto.StateAttached {
+		state = proto.StateRunning
+		if !inst.lastOutputTime.IsZero() && now.Sub(inst.lastOutputTime) > waitingIdleThreshold {
+			state, since = proto.StateWaiting, inst.lastOutputTime
+		}
+	}
+	st := proto.AttachState{State: state}
+	if !since.IsZero() {
+		st.StateSince = since.Unix()
+	}
+	return st
+}
+
+// sendAttachState sends state frames, or pings while the state is
+// unchanged, to a v2 attach client through fw until done is closed or the
+// client is gone.  state reports the attached agent's state; it is called
+// with inst.mu held.
+func (inst *Instance) sendAttachState(fw *proto.FrameWriter, done <-chan struct{}, state func(time.Time) proto.AttachState) {
+	ticker := time.NewTicker(attachStateInterval)
+	defer ticker.Stop()
+	var last proto.AttachState
+	for {
+		inst.mu.Lock()
+		st := state(time.Now())
+		inst.mu.Unlock()
+		var err error
+		if st != last {
+			err = inst.writeJSONFrame(fw, proto.AttachFrameState, st)
+			last = st
+		} else {
+			err = inst.writeFrame(fw, proto.AttachFramePing, nil)
+		}
+		if err != nil {
+			return
+		}
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
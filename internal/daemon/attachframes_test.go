@@ -0,0 +1,235 @@
+package daemon
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/creack/pty"
+	"github.com/gandalfthegui/grove/pkg/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAttachState(t *testing.T) {
+	now := time.Now()
+	attachedAt := now.Add(-time.Minute)
+	inst := &Instance{state: proto.StateAttached, stateChangedAt: attachedAt, lastOutputTime: now}
+	assert.Equal(t, proto.AttachState{State: proto.StateRunning, StateSince: attachedAt.Unix()}, inst.attachState(now))
+
+	later := now.Add(time.Minute)
+	assert.Equal(t, proto.AttachState{State: proto.StateWaiting, StateSince: now.Unix()}, inst.attachState(later),
+		"an attached agent that has gone quiet is waiting")
+
+	inst.setState(proto.StateChecking)
+	assert.Equal(t, proto.StateChecking, inst.attachState(later).State)
+}
+
+func TestDataFrameWriterSplits(t *testing.T) {
+	var buf bytes.Buffer
+	data := bytes.Repeat([]byte("x"), maxDataFrame+10)
+	n, err := dataFrameWriter{&Instance{ID: "1"}, proto.NewFrameWriter(&buf)}.Write(data)
+	require.NoError(t, err)
+	assert.Equal(t, len(data), n)
+
+	var got []byte
+	for buf.Len() > 0 {
+		frameType, payload, err := proto.ReadFrame(&buf)
+		require.NoError(t, err)
+		assert.Equal(t, proto.AttachFrameData, frameType)
+		assert.LessOrEqual(t, len(payload), maxDataFrame)
+		got = append(got, payload...)
+	}
+	assert.Equal(t, data, got)
+}
+
+func TestFramedAttach(t *testing.T) {
+	old := attachStateInterval
+	attachStateInterval = 10 * time.Millisecond
+	t.Cleanup(func() { attachStateInterval = old })
+
+	ptyIn, ptm, err := os.Pipe()
+	require.NoError(t, err)
+	defer ptyIn.Close()
+	inst := &Instance{ID: "1", state: proto.StateRunning, ptm: ptm, logBuf: []byte("earlier output")}
+
+	server, client := net.Pipe()
+	defer client.Close()
+	done := make(chan struct{})
+	go func() {
+		inst.Attach(server, true)
+		close(done)
+	}()
+
+	frameType, payload, err := proto.ReadFrame(client)
+	require.NoError(t, err)
+	assert.Equal(t, proto.AttachFrameData, frameType)
+	assert.Equal(t, "earlier output", string(payload), "the replay is framed")
+
+	frameType, payload, err = proto.ReadFrame(client)
+	require.NoError(t, err)
+	assert.Equal(t, proto.AttachFrameState, frameType)
+	var st proto.AttachState
+	require.NoError(t, json.Unmarshal(payload, &st))
+	assert.Equal(t, proto.StateRunning, st.State)
+
+	// While the state is unchanged the daemon pings; the client's detach
+	// ends the session.
+	frameType, payload, err = proto.ReadFrame(client)
+	require.NoError(t, err)
+	assert.Equal(t, proto.AttachFramePing, frameType)
+	assert.Empty(t, payload)
+	require.NoError(t, proto.WriteFrame(client, proto.AttachFrameDetach, nil))
+	go func() {
+		// Drain any frame in flight so the daemon side never blocks.
+		for {
+			if _, _, err := proto.ReadFrame(client); err != nil {
+				return
+			}
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("attach did not end on detach")
+	}
+	assert.Equal(t, proto.StateRunning, inst.Info().State)
+	assert.Nil(t, inst.attachedOut)
+}
+
+// TestAttachReadyDetach attaches to a READY instance and detaches: it stays
+// READY unless the agent output anything meanwhile.
+func TestAttachReadyDetach(t *testing.T) {
+	for _, output := range []bool{false, true} {
+		ptyIn, ptm, err := os.Pipe()
+		require.NoError(t, err)
+		defer ptyIn.Close()
+		inst := &Instance{ID: "1", state: proto.StateReady, ptm: ptm, lastOutputTime: time.Now().Add(-time.Minute)}
+
+		server, client := net.Pipe()
+		done := make(chan struct{})
+		go func() {
+			inst.Attach(server, false)
+			close(done)
+		}()
+		require.Eventually(t, func() bool {
+			return inst.Info().State == proto.StateAttached
+		}, 2*time.Second, 10*time.Millisecond)
+		if output {
+			inst.mu.Lock()
+			inst.lastOutputTime = time.Now()
+			inst.mu.Unlock()
+		}
+		require.NoError(t, proto.WriteFrame(client, proto.AttachFrameDetach, nil))
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("attach did not end on detach")
+		}
+		client.Close()
+		want := proto.StateReady
+		if output {
+			want = proto.StateRunning
+		}
+		assert.Equal(t, want, inst.Info().State, "output while attached: %t", output)
+	}
+}
+
+func TestAttachV2ExitFrame(t *testing.T) {
+	inst := &Instance{ID: "1", LogFile: filepath.Join(t.TempDir(), "1.log")}
+	cmd := exec.Command("sh", "-c", "printf bye; exit 3")
+	ptm, err := pty.Start(cmd)
+	require.NoError(t, err)
+
+	server, client := net.Pipe()
+	defer client.Close()
+	inst.mu.Lock()
+	inst.ptm = ptm
+	inst.setState(proto.StateAttached)
+	fw := proto.NewFrameWriter(server)
+	inst.attachedConn = server
+	inst.attachedOut = dataFrameWriter{inst, fw}
+	inst.attachedFrames = fw
+	inst.mu.Unlock()
+	go inst.ptyReader(cmd)
+
+	var out bytes.Buffer
+	for {
+		frameType, payload, err := proto.ReadFrame(client)
+		require.NoError(t, err, "the connection is closed only after the exit frame")
+		if frameType == proto.AttachFrameData {
+			out.Write(payload)
+			continue
+		}
+		require.Equal(t, proto.AttachFrameExit, frameType)
+		var exit proto.AttachExit
+		require.NoError(t, json.Unmarshal(payload, &exit))
+		assert.Equal(t, proto.AttachExit{State: proto.StateCrashed, ExitCode: 3}, exit)
+		break
+	}
+	assert.Contains(t, out.String(), "bye")
+	_, _, err = proto.ReadFrame(client)
+	assert.Error(t, err)
+}
+
+func TestAttachClientGoneDuringReplay(t *testing.T) {
+	ptm, pts, err := pty.Open()
+	require.NoError(t, err)
+	defer ptm.Close()
+	defer pts.Close()
+	inst := &Instance{ID: "1", state: proto.StateRunning, ptm: ptm, logBuf: []byte("earlier output")}
+
+	server, client := net.Pipe()
+	client.Close()
+	inst.Attach(server, true)
+
+	require.Eventually(t, func() bool {
+		return inst.Info().State == proto.StateRunning
+	}, 2*time.Second, 10*time.Millisecond, "the session ends")
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+	assert.Nil(t, inst.attachedConn)
+	assert.Nil(t, inst.attachedOut)
+}
+
+func TestResizeStorm(t *testing.T) {
+	var calls []pty.Winsize
+	old := setPTYSize
+	setPTYSize = func(_ *os.File, ws *pty.Winsize) error {
+		calls = append(calls, *ws)
+		return nil
+	}
+	t.Cleanup(func() { setPTYSize = old })
+
+	ptm, err := os.Open(os.DevNull)
+	require.NoError(t, err)
+	defer ptm.Close()
+	inst := &Instance{ID: "1"}
+
+	// 1000 resizes that arrive together, as when a client on a slow link
+	// catches up, then a keystroke and a detach.
+	var frames bytes.Buffer
+	for i := 1; i <= 1000; i++ {
+		require.NoError(t, proto.WriteFrame(&frames, proto.AttachFrameResize, proto.ResizePayload(uint16(80+i%50), uint16(24+i%20))))
+	}
+	require.NoError(t, proto.WriteFrame(&frames, proto.AttachFrameResize, proto.ResizePayload(132, 43)))
+	require.NoError(t, proto.WriteFrame(&frames, proto.AttachFrameDetach, nil))
+
+	client, server := net.Pipe()
+	go func() {
+		client.Write(frames.Bytes())
+		client.Close()
+	}()
+	inst.readAttachInput(server, func() *os.File { return ptm })
+
+	require.NotEmpty(t, calls)
+	assert.LessOrEqual(t, len(calls), 1+frames.Len()/4096, "at most one resize per read from the connection")
+	assert.Equal(t, pty.Winsize{Cols: 132, Rows: 43}, calls[len(calls)-1])
+	assert.Equal(t, uint16(132), inst.cols)
+	assert.Equal(t, uint16(43), inst.rows)
+}
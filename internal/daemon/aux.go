@@ -0,0 +1,133 @@
+package daemon
+
+// aux.go – one-shot commands in an instance's container.
+//
+// grove attach <id> --command "git add -p" runs a command in a PTY of its
+// own in the instance's container, in the container's workdir (the
+// worktree), and attaches the client to it.  The agent's PTY is not
+// touched.  The session lives exactly as long as its client: when the
+// command exits the client gets its exit status, and when the client
+// detaches or goes away the command is killed.  Sessions still running
+// when the instance is dropped are killed with it.
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/creack/pty"
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+// auxSession is one grove attach --command session.  Its fields are set
+// before it is registered and not changed after.
+type auxSession struct {
+	command string
+	pid     int
+	ptm     *os.File
+	started time.Time
+}
+
+// auxCommand returns the docker exec command that runs command, a shell
+// command line, in the instance's container.  Like grove shell it runs as
+// root.
+func (inst *Instance) auxCommand(command string) *exec.Cmd {
+	return dockerCommand("exec", "-it", "-u", "root", "-e", "HOME=/root", "-e", "TERM=xterm-256color",
+		inst.ContainerID, "sh", "-c", command)
+}
+
+// startAux starts command in a new PTY, the size of the last attached
+// terminal, and registers the session.
+func (inst *Instance) startAux(command string) (*auxSession, *exec.Cmd, error) {
+	cmd := inst.auxCommand(command)
+	ptm, err := pty.StartWithSize(cmd, inst.ptySize())
+	if err != nil {
+		return nil, nil, fmt.Errorf("pty.Start: %w", err)
+	}
+	s := &auxSession{command: command, pid: cmd.Process.Pid, ptm: ptm, started: time.Now()}
+	inst.mu.Lock()
+	inst.aux = append(inst.aux, s)
+	inst.mu.Unlock()
+	log.Printf("instance %s: running %q for an attached client", inst.ID, command)
+	return s, cmd, nil
+}
+
+// runAux passes a v2 attach client's input and output to and from s until
+// its command exits, then sends the client its exit status.  If the client
+// detaches or disconnects first, the command is killed.
+func (inst *Instance) runAux(s *auxSession, cmd *exec.Cmd, conn net.Conn) {
+	fw := proto.NewFrameWriter(conn)
+	out := dataFrameWriter{inst, fw}
+
+	clientDone := make(chan struct{})
+	go func() {
+		defer close(clientDone)
+		inst.readAttachInput(conn, func() *os.File { return s.ptm })
+	}()
+
+	outputDone := make(chan struct{})
+	go func() {
+		defer close(outputDone)
+		buf := make([]byte, 4096)
+		for {
+			n, err := s.ptm.Read(buf)
+			if n > 0 {
+				out.Write(buf[:n])
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	exited := make(chan error, 1)
+	go func() {
+		<-outputDone
+		exited <- cmd.Wait()
+	}()
+
+	var waitErr error
+	select {
+	case waitErr = <-exited:
+		exit := proto.AttachExit{State: proto.StateExited, ExitCode: exitCode(waitErr)}
+		if waitErr != nil {
+			exit.State = proto.StateCrashed
+		}
+		inst.writeJSONFrame(fw, proto.AttachFrameExit, exit)
+		s.ptm.Close()
+	case <-clientDone:
+		killProcessGroup(s.pid)
+		s.ptm.Close()
+		waitErr = <-exited
+	}
+	conn.Close()
+	inst.forgetAux(s)
+	log.Printf("instance %s: %q ended after %s (%v)", inst.ID, s.command, time.Since(s.started).Round(time.Second), waitErr)
+}
+
+// forgetAux unregisters s.
+func (inst *Instance) forgetAux(s *auxSession) {
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+	for i, a := range inst.aux {
+		if a == s {
+			inst.aux = append(inst.aux[:i], inst.aux[i+1:]...)
+			return
+		}
+	}
+}
+
+// destroyAux kills every running aux session.  runAux then closes its
+// client and unregisters it.
+func (inst *Instance) destroyAux() {
+	inst.mu.Lock()
+	sessions := append([]*auxSession(nil), inst.aux...)
+	inst.mu.Unlock()
+	for _, s := range sessions {
+		killProcessGroup(s.pid)
+		s.ptm.Close()
+	}
+}
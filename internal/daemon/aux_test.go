@@ -0,0 +1,98 @@
+package daemon
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// runAuxTest starts command as an aux session of inst and serves it on one
+// end of a pipe, returning the client's end and a channel closed once the
+// session has ended.
+func runAuxTest(t *testing.T, inst *Instance, command string) (net.Conn, <-chan struct{}) {
+	t.Helper()
+	s, cmd, err := inst.startAux(command)
+	require.NoError(t, err)
+	server, clientConn := net.Pipe()
+	t.Cleanup(func() { clientConn.Close() })
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		inst.runAux(s, cmd, server)
+	}()
+	return clientConn, done
+}
+
+func TestAuxSessionExit(t *testing.T) {
+	fakeExecDocker(t)
+	inst := helperTestInstance(t)
+
+	conn, done := runAuxTest(t, inst, "echo staged; exit 3")
+	var out strings.Builder
+	var exit proto.AttachExit
+	for {
+		frameType, payload, err := proto.ReadFrame(conn)
+		require.NoError(t, err)
+		if frameType == proto.AttachFrameData {
+			out.Write(payload)
+		}
+		if frameType == proto.AttachFrameExit {
+			require.NoError(t, json.Unmarshal(payload, &exit))
+			break
+		}
+	}
+	<-done
+	assert.Contains(t, out.String(), "staged")
+	assert.Equal(t, proto.AttachExit{State: proto.StateCrashed, ExitCode: 3}, exit)
+	assert.Empty(t, inst.aux, "the session is forgotten once it ends")
+	assert.Equal(t, proto.StateRunning, inst.Info().State, "the agent is not touched")
+}
+
+func TestAuxSessionKilledOnDetach(t *testing.T) {
+	fakeExecDocker(t)
+	inst := helperTestInstance(t)
+
+	conn, done := runAuxTest(t, inst, "echo ready; sleep 30")
+	inst.mu.Lock()
+	pid := inst.aux[0].pid
+	inst.mu.Unlock()
+	for {
+		_, payload, err := proto.ReadFrame(conn)
+		require.NoError(t, err)
+		if strings.Contains(string(payload), "ready") {
+			break
+		}
+	}
+
+	go proto.WriteFrame(conn, proto.AttachFrameDetach, nil)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the session outlived its client")
+	}
+	assert.Empty(t, inst.aux)
+	assert.Error(t, syscall.Kill(pid, 0), "the command is killed")
+}
+
+func TestDestroyAux(t *testing.T) {
+	fakeExecDocker(t)
+	inst := helperTestInstance(t)
+
+	conn, done := runAuxTest(t, inst, "sleep 30")
+	go io.Copy(io.Discard, conn)
+	inst.destroyAux()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("destroyAux left the session running")
+	}
+	assert.Empty(t, inst.aux)
+}
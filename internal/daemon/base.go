@@ -0,0 +1,65 @@
+package daemon
+
+// base.go – the commit each instance started from.
+//
+// When handleStart creates an instance's worktree it records the commit
+// the branch shares with the main checkout's HEAD as the instance's base:
+// for a new branch, that HEAD itself.  It is kept in the instance's
+// record, so that "the agent's branch is missing yesterday's fix" can be
+// settled with git log <base>..main, and grove list -o wide and grove
+// status say how far the main checkout has moved on since.
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// mainAheadTimeout bounds the git command that counts the commits the main
+// checkout has gained since an instance's base.
+const mainAheadTimeout = 2 * time.Second
+
+// mergeBase returns the commit the branch checked out in worktreeDir shares
+// with the main checkout's HEAD in mainDir.
+func mergeBase(ctx context.Context, mainDir, worktreeDir string) (string, error) {
+	head, err := gitCommandContext(ctx, "-C", mainDir, "rev-parse", "HEAD").Output()
+	if err != nil {
+		return "", fmt.Errorf("git rev-parse HEAD: %w", err)
+	}
+	mb, err := gitCommandContext(ctx, "-C", worktreeDir, "merge-base", "HEAD", strings.TrimSpace(string(head))).Output()
+	if err != nil {
+		return "", fmt.Errorf("git merge-base: %w", err)
+	}
+	return strings.TrimSpace(string(mb)), nil
+}
+
+// setBase records sha as inst's base.  Whatever moves the branch onto a
+// newer main, such as a rebase, calls it again.
+func (inst *Instance) setBase(sha string) {
+	inst.mu.Lock()
+	inst.baseSHA = sha
+	inst.mu.Unlock()
+}
+
+// mainAhead returns how many commits the main checkout's HEAD has that
+// inst's base has not, or 0 if that cannot be worked out.
+func (inst *Instance) mainAhead() int {
+	inst.mu.Lock()
+	base, mainDir := inst.baseSHA, inst.MainDir
+	inst.mu.Unlock()
+	if base == "" {
+		return 0
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), mainAheadTimeout)
+	defer cancel()
+	out, err := gitCommandContext(ctx, "-C", mainDir, "rev-list", "--count", base+"..HEAD").Output()
+	if err != nil {
+		log.Printf("instance %s: counting commits since base %s: %v", inst.ID, base, err)
+		return 0
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(string(out)))
+	return n
+}
@@ -0,0 +1,46 @@
+package daemon
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBase(t *testing.T) {
+	d, inst := teardownTest(t, proto.StateRunning)
+	commit := func(dir, msg string) {
+		git(t, "-C", dir, "-c", "user.name=t", "-c", "user.email=t@t", "commit", "-q", "--allow-empty", "-m", msg)
+	}
+	head := git(t, "-C", inst.MainDir, "rev-parse", "HEAD")
+
+	// The branch's own commits and main's later ones leave the base alone.
+	commit(inst.WorktreeDir, "on feat")
+	commit(inst.MainDir, "main 1")
+	commit(inst.MainDir, "main 2")
+	base, err := mergeBase(context.Background(), inst.MainDir, inst.WorktreeDir)
+	require.NoError(t, err)
+	assert.Equal(t, head, base)
+
+	assert.Zero(t, inst.mainAhead(), "no base recorded")
+	inst.setBase(base)
+	assert.Equal(t, 2, inst.mainAhead())
+	assert.Equal(t, base, inst.Info().BaseSHA)
+
+	list := func(req proto.Request) proto.InstanceInfo {
+		resp := teardownRequest(t, d.handleList, req)
+		require.Len(t, resp.Instances, 1)
+		return resp.Instances[0]
+	}
+	assert.Zero(t, list(proto.Request{Type: proto.ReqList}).MainAhead, "only when asked for")
+	info := list(proto.Request{Type: proto.ReqList, MainAhead: true})
+	assert.Equal(t, 2, info.MainAhead)
+	assert.Equal(t, head[:7], info.ShortBaseSHA())
+
+	inst.setBase("0123456789abcdef0123456789abcdef01234567")
+	assert.Zero(t, inst.mainAhead(), "unknown commit")
+	_, err = mergeBase(context.Background(), inst.MainDir, t.TempDir())
+	assert.Error(t, err)
+}
@@ -0,0 +1,89 @@
+package daemon
+
+// broken.go – noticing a worktree deleted out from under its instance.
+//
+// A worktree can vanish while its instance is live: an rm -rf, or a cleanup
+// script.  The agent's bind mount then points at nothing, and attach, check
+// and finish fail with container errors that do not say why.  The daemon
+// looks for each live instance's worktree every worktreeCheckInterval and,
+// when it is gone, stops the agent and marks the instance BROKEN with a
+// note.  grove drop removes it.
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+const worktreeCheckInterval = 10 * time.Second
+
+// worktreeWatchLoop marks live instances whose worktree has disappeared
+// BROKEN.  It runs for the life of the daemon.
+func (d *Daemon) worktreeWatchLoop() {
+	ticker := time.NewTicker(worktreeCheckInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		d.mu.Lock()
+		insts := make([]*Instance, 0, len(d.instances))
+		for _, inst := range d.instances {
+			insts = append(insts, inst)
+		}
+		d.mu.Unlock()
+
+		for _, inst := range insts {
+			if inst.checkWorktree() {
+				inst.persistMeta(filepath.Join(d.rootDir, "instances"))
+			}
+		}
+	}
+}
+
+// checkWorktree marks inst BROKEN, stopping its agent, if it is live and
+// its worktree directory is gone.  It reports whether it did.
+func (inst *Instance) checkWorktree() bool {
+	inst.mu.Lock()
+	live := !proto.IsTerminal(inst.state)
+	inst.mu.Unlock()
+	if !live || inst.WorktreeDir == "" {
+		return false
+	}
+	if _, err := os.Stat(inst.WorktreeDir); !os.IsNotExist(err) {
+		return false
+	}
+
+	why := "worktree " + inst.WorktreeDir + " is gone"
+	inst.mu.Lock()
+	if proto.IsTerminal(inst.state) || inst.broken != "" {
+		inst.mu.Unlock()
+		return false
+	}
+	inst.broken = why
+	inst.notes = append(inst.notes, proto.Note{Time: time.Now().Unix(), Text: "broken: " + why + "; grove drop " + inst.ID + " removes the instance"})
+	running := inst.ptm != nil
+	processDone := inst.processDone
+	if !running {
+		inst.setState(proto.StateBroken)
+		inst.endReason = proto.EndBroken
+	}
+	inst.mu.Unlock()
+
+	log.Printf("instance %s: %s; stopping the agent", inst.ID, why)
+	if running {
+		// ptyReader records BROKEN once the agent is gone.
+		inst.destroy()
+		if processDone != nil {
+			<-processDone
+		}
+	} else {
+		inst.destroyHelpers()
+	}
+	return true
+}
+
+// brokenHint is the hint for requests refused because an instance is BROKEN.
+func brokenHint(id string) string {
+	return "The instance is BROKEN: its worktree was deleted.  grove drop " + id + " removes the instance and its container."
+}
@@ -0,0 +1,55 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+func TestCheckWorktreeRunning(t *testing.T) {
+	fakeExecDocker(t)
+	worktree := t.TempDir()
+	instancesDir := t.TempDir()
+	inst := &Instance{ID: "1", ContainerID: "c1", WorktreeDir: worktree, LogFile: filepath.Join(t.TempDir(), "1.log"), InstancesDir: instancesDir}
+	require.NoError(t, inst.startAgent("sleep", []string{"30"}, nil))
+
+	assert.False(t, inst.checkWorktree(), "the worktree is there")
+	assert.Equal(t, proto.StateRunning, inst.Info().State)
+
+	require.NoError(t, os.RemoveAll(worktree))
+	assert.True(t, inst.checkWorktree())
+	info := inst.Info()
+	assert.Equal(t, proto.StateBroken, info.State)
+	assert.Equal(t, proto.EndBroken, info.EndReason)
+	require.NotEmpty(t, info.Notes)
+	assert.True(t, strings.HasPrefix(info.Notes[len(info.Notes)-1].Text, "broken: worktree "+worktree+" is gone"))
+	assert.False(t, inst.checkWorktree(), "already BROKEN")
+
+	d := &Daemon{rootDir: filepath.Dir(instancesDir), instances: make(map[string]*Instance)}
+	require.NoError(t, os.Rename(instancesDir, filepath.Join(d.rootDir, "instances")))
+	require.NoError(t, d.loadPersistedInstances())
+	assert.Equal(t, proto.StateBroken, d.instances["1"].Info().State, "persisted")
+}
+
+func TestCheckWorktreeIdle(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "gone")
+	for _, tc := range []struct {
+		state string
+		want  string
+	}{
+		{proto.StateReady, proto.StateBroken},
+		{proto.StateChecking, proto.StateBroken},
+		{proto.StateExited, proto.StateExited},
+		{proto.StateFinished, proto.StateFinished},
+	} {
+		inst := &Instance{ID: "1", WorktreeDir: missing, state: tc.state}
+		assert.Equal(t, tc.want == proto.StateBroken, inst.checkWorktree(), tc.state)
+		assert.Equal(t, tc.want, inst.Info().State, tc.state)
+	}
+}
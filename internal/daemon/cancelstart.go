@@ -0,0 +1,116 @@
+package daemon
+
+// cancelstart.go – cancelling a grove start that is under way.
+//
+// A start clones, pulls, adds a worktree, pulls an image, runs a container
+// and its start commands, any of which can take minutes.  Each start has a
+// context that is cancelled when its client goes away (Ctrl-C in grove
+// start closes the connection) or when grove cancel-start names its start
+// ID.  Every stage runs git and docker with that context, so the process
+// under way is killed, and handleStart then rolls back what it had made,
+// as for a failed start, and answers with a startCancelledError.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+// Why a start was cancelled: the cause of its context.
+var (
+	errClientGone      = errors.New("as its client went away")
+	errCancelRequested = errors.New("as requested")
+)
+
+// startCancelledError is the error of a start cancelled during stage.
+type startCancelledError struct {
+	stage string
+	cause error // errClientGone or errCancelRequested
+}
+
+func (e *startCancelledError) Error() string {
+	return fmt.Sprintf("start cancelled during the %s stage, %v", e.stage, e.cause)
+}
+
+// startRegistry holds the cancel functions of the starts under way, by
+// start ID.  The zero value is ready to use.
+type startRegistry struct {
+	mu      sync.Mutex
+	cancels map[string]context.CancelCauseFunc
+}
+
+// add registers cancel as that of start id, refusing an ID already under
+// way.  remove unregisters it.
+func (r *startRegistry) add(id string, cancel context.CancelCauseFunc) (remove func(), err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.cancels[id]; ok {
+		return nil, fmt.Errorf("a start with ID %s is already under way", id)
+	}
+	if r.cancels == nil {
+		r.cancels = make(map[string]context.CancelCauseFunc)
+	}
+	r.cancels[id] = cancel
+	return func() {
+		r.mu.Lock()
+		delete(r.cancels, id)
+		r.mu.Unlock()
+	}, nil
+}
+
+// cancel cancels start id, reporting false if no such start is under way.
+func (r *startRegistry) cancel(id string) bool {
+	r.mu.Lock()
+	cancel, ok := r.cancels[id]
+	r.mu.Unlock()
+	if ok {
+		cancel(errCancelRequested)
+	}
+	return ok
+}
+
+// watchClient cancels with errClientGone when conn's client goes away.  A
+// client sends nothing after its start request, so a read returns only
+// once the connection is closed.  stop ends the watch.
+func watchClient(conn net.Conn, cancel context.CancelCauseFunc) (stop func()) {
+	var stopped atomic.Bool
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, 64)
+		for {
+			if _, err := conn.Read(buf); err != nil {
+				if !stopped.Load() {
+					cancel(errClientGone)
+				}
+				return
+			}
+		}
+	}()
+	return func() {
+		stopped.Store(true)
+		conn.SetReadDeadline(time.Now())
+		<-done
+	}
+}
+
+// handleCancelStart cancels the start whose request carried req.StartID.
+// It answers at once; the start itself answers its own client once it has
+// rolled back.
+func (d *Daemon) handleCancelStart(conn net.Conn, req proto.Request) {
+	if req.StartID == "" {
+		respond(conn, proto.Response{OK: false, Error: "start ID required"})
+		return
+	}
+	if !d.starts.cancel(req.StartID) {
+		respond(conn, proto.Response{OK: false, Error: "no start under way with ID " + req.StartID})
+		return
+	}
+	respond(conn, proto.Response{OK: true})
+}
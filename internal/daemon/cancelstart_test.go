@@ -0,0 +1,122 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// cancelStartTest returns a daemon with project app cloned and configured,
+// and a docker whose image pulls hang until killed, and the file its calls
+// are recorded in.  waitPull waits until a start is pulling.
+func cancelStartTest(t *testing.T) (d *Daemon, calls string, waitPull func()) {
+	t.Helper()
+	bin := installFakeDocker(t, "echo \"$@\" >> \"$(dirname \"$0\")/calls\"\ncase \"$1\" in\nimage) exit 1 ;;\npull) exec sleep 30 ;;\nesac\n")
+	calls = filepath.Join(bin, "calls")
+
+	d, _ = dataDirTest(t, proto.StateExited)
+	main := filepath.Join(d.rootDir, "projects", "app", "main")
+	writeRepoFiles(t, main, map[string]string{"grove.yaml": "container:\n  image: img\n"})
+	git(t, "-C", main, "add", "grove.yaml")
+	git(t, "-C", main, "-c", "user.name=t", "-c", "user.email=t@t", "commit", "-q", "-m", "config")
+
+	waitPull = func() {
+		require.Eventually(t, func() bool {
+			data, _ := os.ReadFile(calls)
+			return strings.Contains(string(data), "pull img")
+		}, 10*time.Second, 10*time.Millisecond)
+	}
+	return d, calls, waitPull
+}
+
+// assertRolledBack checks that the start of branch new left nothing behind.
+func assertRolledBack(t *testing.T, d *Daemon, calls string) {
+	t.Helper()
+	main := filepath.Join(d.rootDir, "projects", "app", "main")
+	assert.Empty(t, worktreeWithBranch(main, "new"), "worktree removed")
+	assert.Error(t, gitCommand("-C", main, "rev-parse", "--verify", "--quiet", "refs/heads/new").Run(), "branch deleted")
+	data, err := os.ReadFile(calls)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "run ", "no container")
+	assert.Len(t, d.instances, 1)
+}
+
+func TestCancelStart(t *testing.T) {
+	d, calls, waitPull := cancelStartTest(t)
+	go func() {
+		waitPull()
+		assert.True(t, d.starts.cancel("s1"))
+	}()
+
+	started := time.Now()
+	resp := teardownRequest(t, d.handleStart, proto.Request{Type: proto.ReqStart, Project: "app", Branch: "new", StartID: "s1"})
+	assert.Less(t, time.Since(started), 20*time.Second, "docker pull was killed")
+	assert.Equal(t, "start cancelled during the image stage, as requested", resp.Error)
+	assert.Equal(t, proto.ErrCodeStartCancelled, resp.ErrorCode)
+	assertRolledBack(t, d, calls)
+
+	assert.False(t, d.starts.cancel("s1"), "unregistered")
+	resp = teardownRequest(t, d.handleCancelStart, proto.Request{Type: proto.ReqCancelStart, StartID: "s1"})
+	assert.Equal(t, "no start under way with ID s1", resp.Error)
+}
+
+func TestCancelStartClientGone(t *testing.T) {
+	d, calls, waitPull := cancelStartTest(t)
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		d.handleStart(server, proto.Request{Type: proto.ReqStart, Project: "app", Branch: "new"})
+		server.Close()
+		close(done)
+	}()
+	waitPull()
+	client.Close()
+	select {
+	case <-done:
+	case <-time.After(20 * time.Second):
+		t.Fatal("the start went on without its client")
+	}
+	assertRolledBack(t, d, calls)
+}
+
+func TestStartRegistry(t *testing.T) {
+	var r startRegistry
+	ctx, cancel := context.WithCancelCause(context.Background())
+	remove, err := r.add("a", cancel)
+	require.NoError(t, err)
+	_, err = r.add("a", cancel)
+	assert.EqualError(t, err, "a start with ID a is already under way")
+
+	assert.False(t, r.cancel("b"))
+	assert.True(t, r.cancel("a"))
+	assert.Equal(t, errCancelRequested, context.Cause(ctx))
+	remove()
+	assert.False(t, r.cancel("a"))
+}
+
+func TestWatchClient(t *testing.T) {
+	client, server := net.Pipe()
+	ctx, cancel := context.WithCancelCause(context.Background())
+	stop := watchClient(server, cancel)
+	stop()
+	assert.NoError(t, ctx.Err(), "stopped before the client left")
+	client.Close()
+
+	client, server = net.Pipe()
+	stop = watchClient(server, cancel)
+	defer stop()
+	require.NoError(t, json.NewEncoder(client).Encode(proto.Request{}), "input is ignored")
+	assert.NoError(t, ctx.Err())
+	client.Close()
+	<-ctx.Done()
+	assert.Equal(t, errClientGone, context.Cause(ctx))
+}
@@ -0,0 +1,116 @@
+package daemon
+
+// changes.go – how much an instance has changed, for READY events and the
+// notifications grove attach --detach-on-idle sends, so the user can tell a
+// one-line fix from a rewrite before switching to it.  The summary is
+// worked out only when asked for and then kept for changeSummaryTTL; if git
+// is slow or fails it is left out rather than holding anything up.
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+const (
+	// changeSummaryTTL is how long a change summary is reused.
+	changeSummaryTTL = time.Minute
+
+	// changeSummaryTimeout bounds the git commands behind one summary.
+	changeSummaryTimeout = 2 * time.Second
+
+	// changeSummaryFiles is how many of the most changed paths a summary
+	// names.
+	changeSummaryFiles = 3
+)
+
+// worktreeChanges summarises the difference between the working tree in
+// worktreeDir, uncommitted changes included, and the commit its branch
+// shares with the main checkout's HEAD in mainDir (or the worktree's own
+// HEAD if there is none).
+func worktreeChanges(ctx context.Context, mainDir, worktreeDir string) (*proto.ChangeSummary, error) {
+	base := "HEAD"
+	if mb, err := mergeBase(ctx, mainDir, worktreeDir); err == nil {
+		base = mb
+	}
+	shortstat, err := gitCommandContext(ctx, "-C", worktreeDir, "diff", "--shortstat", base).Output()
+	if err != nil {
+		return nil, fmt.Errorf("git diff --shortstat: %w", err)
+	}
+	numstat, err := gitCommandContext(ctx, "-C", worktreeDir, "diff", "--numstat", base).Output()
+	if err != nil {
+		return nil, fmt.Errorf("git diff --numstat: %w", err)
+	}
+	c := parseNumstat(string(numstat))
+	c.Shortstat = strings.TrimSpace(string(shortstat))
+	return c, nil
+}
+
+// parseNumstat totals git diff --numstat output and picks the paths with
+// the most changed lines, ties broken by path.  Binary files count as
+// changed files with no lines.
+func parseNumstat(out string) *proto.ChangeSummary {
+	type fileStat struct {
+		path  string
+		lines int
+	}
+	var files []fileStat
+	c := &proto.ChangeSummary{}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		ins, _ := strconv.Atoi(fields[0]) // "-" for binary files
+		del, _ := strconv.Atoi(fields[1])
+		c.FilesChanged++
+		c.Insertions += ins
+		c.Deletions += del
+		files = append(files, fileStat{fields[2], ins + del})
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		if files[i].lines != files[j].lines {
+			return files[i].lines > files[j].lines
+		}
+		return files[i].path < files[j].path
+	})
+	for _, f := range files[:min(len(files), changeSummaryFiles)] {
+		c.Files = append(c.Files, f.path)
+	}
+	return c
+}
+
+// changeSummary returns inst's change summary as of at most
+// changeSummaryTTL before now, working it out again if it is older.  It is
+// nil if the summary could not be worked out; that too is remembered for
+// changeSummaryTTL.
+func (inst *Instance) changeSummary(now time.Time) *proto.ChangeSummary {
+	if _, removed := inst.teardownRemoved(); removed {
+		return nil
+	}
+	inst.mu.Lock()
+	if !inst.changesAt.IsZero() && now.Sub(inst.changesAt) < changeSummaryTTL {
+		c := inst.changes
+		inst.mu.Unlock()
+		return c
+	}
+	inst.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), changeSummaryTimeout)
+	defer cancel()
+	c, err := worktreeChanges(ctx, inst.MainDir, inst.WorktreeDir)
+	if err != nil {
+		log.Printf("instance %s: change summary: %v", inst.ID, err)
+	}
+
+	inst.mu.Lock()
+	inst.changes, inst.changesAt = c, now
+	inst.mu.Unlock()
+	return c
+}
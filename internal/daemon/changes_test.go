@@ -0,0 +1,65 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseNumstat(t *testing.T) {
+	out := "3\t1\ta.go\n10\t0\tb.go\n-\t-\tlogo.png\n2\t2\tc.go\n0\t4\td.go\n"
+	c := parseNumstat(out)
+	assert.Equal(t, &proto.ChangeSummary{
+		FilesChanged: 5,
+		Insertions:   15,
+		Deletions:    7,
+		Files:        []string{"b.go", "a.go", "c.go"},
+	}, c)
+
+	assert.Equal(t, &proto.ChangeSummary{}, parseNumstat(""))
+}
+
+func TestWorktreeChanges(t *testing.T) {
+	main := filepath.Join(t.TempDir(), "main")
+	wt := filepath.Join(t.TempDir(), "wt")
+	commit := []string{"-c", "user.name=t", "-c", "user.email=t@t", "commit", "-q", "-am"}
+	git(t, "init", "-q", main)
+	require.NoError(t, os.WriteFile(filepath.Join(main, "a.txt"), []byte("one\n"), 0o644))
+	git(t, "-C", main, "add", ".")
+	git(t, append([]string{"-C", main}, append(commit, "init")...)...)
+	git(t, "-C", main, "worktree", "add", "-q", "-b", "feat", wt)
+
+	// A commit on the branch and an uncommitted change both count; a
+	// commit on main since the branch was made does not.
+	require.NoError(t, os.WriteFile(filepath.Join(wt, "a.txt"), []byte("one\ntwo\nthree\n"), 0o644))
+	git(t, append([]string{"-C", wt}, append(commit, "more")...)...)
+	require.NoError(t, os.WriteFile(filepath.Join(wt, "a.txt"), []byte("uno\ntwo\nthree\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(main, "a.txt"), []byte("main\n"), 0o644))
+	git(t, append([]string{"-C", main}, append(commit, "on main")...)...)
+
+	inst := &Instance{ID: "1", MainDir: main, WorktreeDir: wt}
+	now := time.Now()
+	c := inst.changeSummary(now)
+	require.NotNil(t, c)
+	assert.Equal(t, "1 file changed, 3 insertions(+), 1 deletion(-)", c.Shortstat)
+	assert.Equal(t, 1, c.FilesChanged)
+	assert.Equal(t, 3, c.Insertions)
+	assert.Equal(t, 1, c.Deletions)
+	assert.Equal(t, []string{"a.txt"}, c.Files)
+
+	// The summary is reused until it is a minute old.
+	require.NoError(t, os.WriteFile(filepath.Join(wt, "b.txt"), []byte("b\n"), 0o644))
+	git(t, "-C", wt, "add", "b.txt")
+	assert.Same(t, c, inst.changeSummary(now.Add(30*time.Second)))
+	c = inst.changeSummary(now.Add(changeSummaryTTL))
+	assert.Equal(t, 2, c.FilesChanged)
+
+	// Failures leave the summary out.
+	inst = &Instance{ID: "2", MainDir: main, WorktreeDir: filepath.Join(t.TempDir(), "gone")}
+	assert.Nil(t, inst.changeSummary(now))
+}
@@ -0,0 +1,338 @@
+package daemon
+
+// checks.go – running check commands, for both `grove check` and the
+// automatic runs configured by check.auto in grove.yaml.
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gandalfthegui/grove/internal/history"
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+const (
+	// autoCheckInterval is how often the daemon looks for instances that are
+	// due an automatic check.
+	autoCheckInterval = time.Second
+
+	// autoCheckIdle is how long a RUNNING agent must be silent before
+	// check.auto: on-waiting fires.  It is longer than waitingIdleThreshold so
+	// an agent flapping between RUNNING and WAITING mid-task does not trigger
+	// a run on every pause.
+	autoCheckIdle = 10 * time.Second
+
+	// defaultCheckMaxDuration is how long a check may keep an instance
+	// CHECKING, unless check.max_duration says otherwise.  After that the
+	// instance returns to WAITING (or READY) even if the check commands are
+	// still running; they end with their own timeouts, if any.
+	defaultCheckMaxDuration = time.Hour
+)
+
+// beginCheck moves the instance to CHECKING, recording the check in
+// progress, and returns the state it should return to afterwards: WAITING,
+// or READY if the agent had already reported its task done.  With force a
+// CHECKING state is cleared first; the caller holds the instance's check
+// operation, so no check can be running.
+func (inst *Instance) beginCheck(maxDuration time.Duration, auto, force bool) (string, error) {
+	now := time.Now()
+	inst.mu.Lock()
+	state := inst.state
+	if state == proto.StateChecking && force {
+		state = inst.clearCheck(now, "check --force: cleared CHECKING")
+	}
+	if proto.IsTerminal(state) || state == proto.StateChecking {
+		inst.mu.Unlock()
+		if state == proto.StateChecking {
+			return "", fmt.Errorf("cannot check: instance is %s (if no check is running, grove check --force clears it)", state)
+		}
+		return "", fmt.Errorf("cannot check: instance is %s", state)
+	}
+	inst.setState(proto.StateChecking)
+	after := proto.StateWaiting
+	if state == proto.StateReady {
+		after = proto.StateReady
+	}
+	inst.checking = &proto.CheckProgress{
+		Started:  now.Unix(),
+		Deadline: now.Add(maxDuration).Unix(),
+		Return:   after,
+		Auto:     auto,
+	}
+	instancesDir := inst.InstancesDir
+	inst.mu.Unlock()
+
+	if instancesDir != "" {
+		inst.persistMeta(instancesDir)
+	}
+	return after, nil
+}
+
+// clearCheck moves an instance stuck in CHECKING back to the state its check
+// would have returned it to, adding a note that starts with why, and
+// returns that state.  The caller holds inst.mu.
+func (inst *Instance) clearCheck(now time.Time, why string) string {
+	after := proto.StateWaiting
+	text := why
+	if c := inst.checking; c != nil {
+		if c.Return != "" {
+			after = c.Return
+		}
+		text += fmt.Sprintf(" (check started %s)", time.Unix(c.Started, 0).Format("2006-01-02 15:04:05"))
+	}
+	inst.setState(after)
+	inst.checking = nil
+	inst.notes = append(inst.notes, proto.Note{Time: now.Unix(), Text: text})
+	return after
+}
+
+// abandonOverdueCheck returns inst to the state its check would have
+// returned it to if it has been CHECKING past the check's deadline, and
+// reports whether it did.
+func (inst *Instance) abandonOverdueCheck(now time.Time) bool {
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+	c := inst.checking
+	if inst.state != proto.StateChecking || c == nil || now.Unix() < c.Deadline {
+		return false
+	}
+	limit := time.Duration(c.Deadline-c.Started) * time.Second
+	inst.clearCheck(now, fmt.Sprintf("check gave up after %s in CHECKING", limit))
+	log.Printf("instance %s: check still running after %s; left CHECKING", inst.ID, limit)
+	return true
+}
+
+// runChecks runs the project's check commands concurrently, writing their
+// output to w, then records the result on the instance, leaves CHECKING for
+// after; publishCheck tells grove events about it.  stdin, if not nil, is the requesting
+// client's input for tty: commands.  The caller must have called
+// beginCheck.
+func (d *Daemon) runChecks(inst *Instance, p *Project, after string, stdin *clientStdin, w io.Writer, auto bool) proto.StreamResult {
+	started := time.Now()
+	results := make([]proto.CommandResult, len(p.Check.Commands))
+	var wg sync.WaitGroup
+	var outMu sync.Mutex
+	for i, c := range p.Check.Commands {
+		out := w
+		// A tty: command's output is a terminal's, prompts and all, so it
+		// is passed through as it is.
+		if len(p.Check.Commands) > 1 && !c.needsTerminal() {
+			out = &prefixWriter{w: w, mu: &outMu, prefix: "[" + checkLabel(i, c) + "] "}
+		}
+		wg.Add(1)
+		go func(i int, c CommandSpec, out io.Writer) {
+			defer wg.Done()
+			fmt.Fprintf(out, "$ %s\n", c.Run)
+			res, err := d.runInstanceCommand(inst, proto.CommandKindCheck, c, commandOptions(p, c, stdin), out)
+			if f, ok := out.(*prefixWriter); ok {
+				f.flush()
+			}
+			results[i] = res
+			if err != nil {
+				fmt.Fprintf(w, "error: check command %s failed: %v\n", c.label(), err)
+				log.Printf("instance %s: check command %q failed: %v", inst.ID, c.label(), err)
+			}
+		}(i, c, out)
+	}
+	wg.Wait()
+	res := streamResult(started, results)
+
+	inst.mu.Lock()
+	if inst.state == proto.StateChecking {
+		inst.setState(after)
+	}
+	inst.checking = nil
+	inst.lastCheck = &proto.CheckResult{Time: time.Now().Unix(), Auto: auto, Result: res}
+	inst.mu.Unlock()
+	inst.persistMeta(filepath.Join(d.rootDir, "instances"))
+	d.recordHistory(history.Record{Kind: history.KindCheck, Project: inst.Project, DurationMs: res.DurationMs})
+	return res
+}
+
+// publishCheck tells grove events clients how a check run on inst ended.
+func (d *Daemon) publishCheck(inst *Instance, res proto.StreamResult) {
+	info := inst.Info()
+	d.events.publish(proto.Event{
+		Time:       time.Now().Unix(),
+		Type:       proto.EventCheck,
+		InstanceID: info.ID,
+		Project:    info.Project,
+		Branch:     info.Branch,
+		BaseSHA:    info.BaseSHA,
+		State:      info.State,
+		Summary:    checkSummary(res),
+		Result:     &res,
+	})
+}
+
+// checkLabel is the output prefix of the i'th check command when several run
+// at once: its name, or else its 1-based position.
+func checkLabel(i int, c CommandSpec) string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return fmt.Sprint(i + 1)
+}
+
+// prefixWriter writes whole lines to w, each starting with prefix, so the
+// output of concurrent check commands stays readable.  Writers sharing w
+// share mu.  A trailing partial line is held until it is completed or
+// flushed.
+type prefixWriter struct {
+	w      io.Writer
+	mu     *sync.Mutex
+	prefix string
+	buf    []byte
+}
+
+func (pw *prefixWriter) Write(b []byte) (int, error) {
+	pw.buf = append(pw.buf, b...)
+	i := bytes.LastIndexByte(pw.buf, '\n')
+	if i < 0 {
+		return len(b), nil
+	}
+	var out []byte
+	for _, line := range bytes.SplitAfter(pw.buf[:i+1], []byte("\n")) {
+		if len(line) > 0 {
+			out = append(append(out, pw.prefix...), line...)
+		}
+	}
+	pw.buf = append(pw.buf[:0], pw.buf[i+1:]...)
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	if _, err := pw.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// flush writes out a held partial line, ending it with a newline.
+func (pw *prefixWriter) flush() {
+	if len(pw.buf) == 0 {
+		return
+	}
+	pw.Write([]byte("\n"))
+}
+
+// checkSummary renders a one-line outcome such as "checks failed: make lint
+// (exit 2)" for events and agent reports.
+func checkSummary(res proto.StreamResult) string {
+	if res.OK {
+		return fmt.Sprintf("checks passed (%d)", len(res.Commands))
+	}
+	var failed []string
+	for _, c := range res.Commands {
+		if c.ExitCode != 0 {
+			failed = append(failed, fmt.Sprintf("%s (exit %d)", c.Label(), c.ExitCode))
+		}
+	}
+	return "checks failed: " + strings.Join(failed, ", ")
+}
+
+// autoCheckTrigger reports which state, if any, should trigger an automatic
+// check now: READY once per "done" report, or WAITING once per idle period
+// of at least autoCheckIdle.  Returning a trigger consumes it, which is what
+// debounces repeated RUNNING↔WAITING flaps into a single run.
+func (inst *Instance) autoCheckTrigger(now time.Time) string {
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+	switch inst.state {
+	case proto.StateReady:
+		if inst.readyAt.After(inst.lastAutoCheck) {
+			inst.lastAutoCheck = now
+			return proto.StateReady
+		}
+	case proto.StateRunning, proto.StateWaiting:
+		// New output since the last automatic check, then WAITING long
+		// enough.
+		state, since := inst.currentState(now)
+		if state == proto.StateWaiting && now.Sub(since) >= autoCheckIdle &&
+			inst.lastOutputTime.After(inst.lastAutoCheck) {
+			inst.lastAutoCheck = now
+			return proto.StateWaiting
+		}
+	}
+	return ""
+}
+
+// autoCheckLoop starts automatic checks for instances whose project sets
+// check.auto, and takes instances out of checks that have run past
+// check.max_duration.  It runs for the life of the daemon.
+func (d *Daemon) autoCheckLoop() {
+	ticker := time.NewTicker(autoCheckInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		d.mu.Lock()
+		insts := make([]*Instance, 0, len(d.instances))
+		for _, inst := range d.instances {
+			insts = append(insts, inst)
+		}
+		d.mu.Unlock()
+
+		for _, inst := range insts {
+			if inst.abandonOverdueCheck(now) {
+				inst.persistMeta(filepath.Join(d.rootDir, "instances"))
+			}
+			if trigger := inst.autoCheckTrigger(now); trigger != "" {
+				go d.autoCheck(inst, trigger)
+			}
+		}
+	}
+}
+
+// autoCheck runs the checks for inst if its project's check.auto setting
+// covers trigger.  Output goes to the instance log only.
+func (d *Daemon) autoCheck(inst *Instance, trigger string) {
+	p, err := d.instanceProject(inst, false)
+	if err != nil {
+		return
+	}
+	if len(p.Check.Commands) == 0 || !p.Check.runsOn(trigger) {
+		return
+	}
+	endOp, err := inst.beginOperation(opCheck)
+	if err != nil {
+		return // a check, finish, etc. is already running
+	}
+	defer endOp()
+	after, err := inst.beginCheck(p.Check.maxDuration(), true, false)
+	if err != nil {
+		return // stopped
+	}
+
+	log.Printf("instance %s: auto-check on %s", inst.ID, trigger)
+	var w io.Writer = io.Discard
+	if logFd, err := os.OpenFile(inst.LogFile, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0o644); err == nil {
+		defer logFd.Close()
+		w = logFd
+	}
+	fmt.Fprintf(w, "\n[grove] automatic check (%s)\n", strings.ToLower(trigger))
+
+	res := d.runChecks(inst, p, after, nil, w, true)
+	d.publishCheck(inst, res)
+	if !res.OK && p.Check.ReportToAgent {
+		inst.reportToAgent("grove: automatic " + checkSummary(res) + ". Please fix the failures.")
+	}
+}
+
+// reportToAgent types msg into the agent's PTY and submits it.  It does
+// nothing while a human is attached, to avoid mixing with their input.
+func (inst *Instance) reportToAgent(msg string) {
+	inst.mu.Lock()
+	ptm := inst.ptm
+	attached := inst.state == proto.StateAttached
+	inst.mu.Unlock()
+	if ptm == nil || attached {
+		return
+	}
+	if _, err := io.WriteString(ptm, msg+"\r"); err != nil {
+		log.Printf("instance %s: report to agent: %v", inst.ID, err)
+	}
+}
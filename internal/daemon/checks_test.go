@@ -0,0 +1,135 @@
+package daemon
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAutoCheckTriggerDebounce(t *testing.T) {
+	now := time.Now()
+	inst := &Instance{state: proto.StateRunning, lastOutputTime: now}
+
+	assert.Empty(t, inst.autoCheckTrigger(now.Add(time.Second)), "not idle long enough")
+	assert.Equal(t, proto.StateWaiting, inst.autoCheckTrigger(now.Add(autoCheckIdle)))
+	assert.Empty(t, inst.autoCheckTrigger(now.Add(2*autoCheckIdle)), "one run per idle period")
+
+	// New output re-arms the trigger once the agent is idle again.
+	inst.lastOutputTime = now.Add(3 * autoCheckIdle)
+	assert.Empty(t, inst.autoCheckTrigger(now.Add(3*autoCheckIdle+time.Second)))
+	assert.Equal(t, proto.StateWaiting, inst.autoCheckTrigger(now.Add(4*autoCheckIdle)))
+}
+
+func TestAutoCheckTriggerReady(t *testing.T) {
+	now := time.Now()
+	inst := &Instance{state: proto.StateReady, readyAt: now}
+
+	assert.Equal(t, proto.StateReady, inst.autoCheckTrigger(now.Add(time.Millisecond)))
+	assert.Empty(t, inst.autoCheckTrigger(now.Add(time.Second)), "once per done report")
+
+	inst.state = proto.StateAttached
+	inst.lastOutputTime = now.Add(-time.Hour)
+	assert.Empty(t, inst.autoCheckTrigger(now.Add(time.Hour)), "never while attached")
+}
+
+func TestBeginCheck(t *testing.T) {
+	inst := &Instance{state: proto.StateRunning}
+	after, err := inst.beginCheck(time.Hour, false, false)
+	require.NoError(t, err)
+	assert.Equal(t, proto.StateWaiting, after)
+	assert.Equal(t, proto.StateChecking, inst.state)
+	require.NotNil(t, inst.Info().Checking)
+	assert.Equal(t, int64(3600), inst.checking.Deadline-inst.checking.Started)
+	assert.Equal(t, proto.StateWaiting, inst.checking.Return)
+
+	_, err = inst.beginCheck(time.Hour, false, false)
+	require.Error(t, err, "already checking")
+	assert.Contains(t, err.Error(), "--force")
+
+	inst.state = proto.StateReady
+	after, err = inst.beginCheck(time.Hour, true, false)
+	require.NoError(t, err)
+	assert.Equal(t, proto.StateReady, after)
+	assert.True(t, inst.checking.Auto)
+
+	inst.state = proto.StateExited
+	_, err = inst.beginCheck(time.Hour, false, true)
+	assert.Error(t, err)
+}
+
+func TestBeginCheckForce(t *testing.T) {
+	instancesDir := t.TempDir()
+	inst := &Instance{ID: "1", state: proto.StateReady, InstancesDir: instancesDir}
+	_, err := inst.beginCheck(time.Hour, false, false)
+	require.NoError(t, err)
+
+	// The check never finished; --force clears it and starts another.
+	after, err := inst.beginCheck(time.Hour, false, true)
+	require.NoError(t, err)
+	assert.Equal(t, proto.StateReady, after, "returns where the stale check would have")
+	assert.Equal(t, proto.StateChecking, inst.state)
+	require.Len(t, inst.notes, 1)
+	assert.Contains(t, inst.notes[0].Text, "check --force: cleared CHECKING")
+
+	data, err := os.ReadFile(filepath.Join(instancesDir, "1.json"))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"checking"`, "the check in progress is persisted")
+}
+
+func TestAbandonOverdueCheck(t *testing.T) {
+	inst := &Instance{state: proto.StateWaiting}
+	_, err := inst.beginCheck(10*time.Minute, false, false)
+	require.NoError(t, err)
+	started := time.Unix(inst.checking.Started, 0)
+
+	assert.False(t, inst.abandonOverdueCheck(started.Add(9*time.Minute)))
+	assert.Equal(t, proto.StateChecking, inst.state)
+
+	assert.True(t, inst.abandonOverdueCheck(started.Add(10*time.Minute)))
+	assert.Equal(t, proto.StateWaiting, inst.state)
+	assert.Nil(t, inst.checking)
+	require.Len(t, inst.notes, 1)
+	assert.Contains(t, inst.notes[0].Text, "check gave up after 10m0s in CHECKING")
+
+	assert.False(t, inst.abandonOverdueCheck(started.Add(time.Hour)), "only once")
+}
+
+func TestCheckSummary(t *testing.T) {
+	assert.Equal(t, "checks passed (2)", checkSummary(proto.StreamResult{
+		OK:       true,
+		Commands: []proto.CommandResult{{Command: "a"}, {Command: "b"}},
+	}))
+	assert.Equal(t, "checks failed: make lint (exit 2)", checkSummary(proto.StreamResult{
+		Commands: []proto.CommandResult{{Command: "go test"}, {Command: "make lint", ExitCode: 2}},
+	}))
+}
+
+func TestCheckSummaryUsesNames(t *testing.T) {
+	assert.Equal(t, "checks failed: api-tests (exit 1)", checkSummary(proto.StreamResult{
+		Commands: []proto.CommandResult{{Command: "go test ./...", Name: "api-tests", ExitCode: 1}},
+	}))
+}
+
+func TestPrefixWriter(t *testing.T) {
+	var buf bytes.Buffer
+	var mu sync.Mutex
+	a := &prefixWriter{w: &buf, mu: &mu, prefix: "[api] "}
+	b := &prefixWriter{w: &buf, mu: &mu, prefix: "[2] "}
+
+	a.Write([]byte("one\ntw"))
+	b.Write([]byte("lint ok\n"))
+	a.Write([]byte("o\nthree"))
+	a.flush()
+	b.flush()
+	assert.Equal(t, "[api] one\n[2] lint ok\n[api] two\n[api] three\n", buf.String())
+
+	assert.Equal(t, "api", checkLabel(0, CommandSpec{Run: "go test", Name: "api"}))
+	assert.Equal(t, "2", checkLabel(1, CommandSpec{Run: "make lint"}))
+}
@@ -0,0 +1,239 @@
+package daemon
+
+// checkwatch.go – grove check --watch: running the checks again whenever
+// files in the worktree change, like a CI loop beside the agent.
+//
+// There is no file notification API the daemon can use on both Linux and
+// macOS without a new dependency, so the watch polls instead.  Every
+// checkWatchInterval it asks git for the worktree's changed and untracked
+// files, which leaves out what .gitignore and the git.exclude patterns in
+// grove.yaml (written to info/exclude) ignore, and compares their sizes and
+// modification times with the last look.  Once a burst of changes has been
+// quiet for checkWatchQuiet the checks run, once, with each run's output
+// streamed to the client between a header and a summary.
+//
+// A run changes the instance to CHECKING and back as grove check does, but
+// grove events only hears of a run whose outcome differs from the last
+// one's, so an agent editing away does not flood it with passes.  Each
+// instance has at most one watch; it ends when the client hangs up, or when
+// the instance stops.
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+const (
+	// checkWatchInterval is how often a check watch looks at the worktree.
+	checkWatchInterval = 500 * time.Millisecond
+
+	// checkWatchQuiet is how long the worktree must go unchanged before the
+	// checks run, so that an agent writing several files runs them once.
+	checkWatchQuiet = 1500 * time.Millisecond
+)
+
+// worktreeSnapshot maps the changed and untracked files of a worktree,
+// relative to it, to their stamps (see configcache.go).
+type worktreeSnapshot map[string]fileStamp
+
+// snapshotWorktree lists dir's changed and untracked files that git does
+// not ignore, with their stamps.
+func snapshotWorktree(dir string) (worktreeSnapshot, error) {
+	out, err := gitCommand("-C", dir, "ls-files", "-z", "--modified", "--others", "--exclude-standard").Output()
+	if err != nil {
+		return nil, fmt.Errorf("git ls-files in %s: %w", dir, err)
+	}
+	snap := make(worktreeSnapshot)
+	for _, path := range bytes.Split(out, []byte{0}) {
+		if len(path) == 0 {
+			continue
+		}
+		// A deleted file has the zero stamp.
+		snap[string(path)], _ = statStamp(filepath.Join(dir, string(path)))
+	}
+	return snap, nil
+}
+
+// changedSince returns the files whose stamps differ between old and s,
+// including those only one of them has, in order.
+func (s worktreeSnapshot) changedSince(old worktreeSnapshot) []string {
+	var changed []string
+	for path, stamp := range s {
+		if prev, ok := old[path]; !ok || prev != stamp {
+			changed = append(changed, path)
+		}
+	}
+	for path := range old {
+		if _, ok := s[path]; !ok {
+			changed = append(changed, path)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// describeChanges names the first few of files for a run's header.
+func describeChanges(files []string) string {
+	const shown = 3
+	if len(files) <= shown {
+		return strings.Join(files, ", ") + " changed"
+	}
+	return fmt.Sprintf("%s and %d more changed", strings.Join(files[:shown], ", "), len(files)-shown)
+}
+
+// beginCheckWatch claims inst's one check watch.  The returned function
+// releases it.
+func (inst *Instance) beginCheckWatch() (func(), error) {
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+	if !inst.checkWatch.IsZero() {
+		return nil, fmt.Errorf("instance %s already has a check watch, since %s", inst.ID, inst.checkWatch.Format("15:04:05"))
+	}
+	inst.checkWatch = time.Now()
+	return func() {
+		inst.mu.Lock()
+		inst.checkWatch = time.Time{}
+		inst.mu.Unlock()
+	}, nil
+}
+
+// watchChecks runs p's checks on inst now and after every change to its
+// worktree, streaming their output to conn, until the client hangs up.
+func (d *Daemon) watchChecks(conn net.Conn, inst *Instance, p *Project) {
+	// The client's input is not forwarded: clientGone reads conn instead.
+	if err := requireTerminal("check --watch", p.Check.Commands); err != nil {
+		respond(conn, proto.Response{OK: false, Error: err.Error()})
+		return
+	}
+	if state := inst.Info().State; proto.IsTerminal(state) {
+		respond(conn, proto.Response{OK: false, Error: "cannot check: instance is " + state})
+		return
+	}
+	snap, err := snapshotWorktree(inst.WorktreeDir)
+	if err != nil {
+		respond(conn, proto.Response{OK: false, Error: err.Error()})
+		return
+	}
+	endWatch, err := inst.beginCheckWatch()
+	if err != nil {
+		respond(conn, proto.Response{OK: false, Error: err.Error()})
+		return
+	}
+	defer endWatch()
+
+	respond(conn, proto.Response{OK: true})
+	gone := clientGone(conn)
+	log.Printf("instance %s: watching the worktree for check --watch", inst.ID)
+	defer log.Printf("instance %s: check --watch ended", inst.ID)
+
+	var w io.Writer = newResilientWriter(conn, nil)
+	if logFd, err := os.OpenFile(inst.LogFile, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0o644); err == nil {
+		defer logFd.Close()
+		w = newResilientWriter(conn, logFd)
+	}
+
+	run := 0
+	var lastSummary string
+	// check runs the checks once, headed by why.  It reports false, having
+	// ended the stream, if the instance can no longer be checked.
+	check := func(why string) bool {
+		run++
+		fmt.Fprintf(w, "\n── check %d at %s: %s ──\n", run, time.Now().Format("15:04:05"), why)
+		res, err := d.watchRun(inst, p, w)
+		if err != nil {
+			// Busy with another check, a finish and the like: the next
+			// change tries again.
+			if !proto.IsTerminal(inst.Info().State) {
+				fmt.Fprintf(w, "skipped: %v; waiting for the next change\n", err)
+				return true
+			}
+			fmt.Fprintf(w, "%v; stopped watching\n", err)
+			proto.WriteResultTrailer(w, proto.StreamResult{OK: false, Error: err.Error()})
+			return false
+		}
+		summary := checkSummary(res)
+		if summary != lastSummary {
+			d.publishCheck(inst, res)
+			lastSummary = summary
+		}
+		fmt.Fprintf(w, "── %s in %s; watching for changes ──\n", summary, time.Duration(res.DurationMs)*time.Millisecond)
+		return true
+	}
+	if !check("first run") {
+		return
+	}
+
+	ticker := time.NewTicker(checkWatchInterval)
+	defer ticker.Stop()
+	var pending []string
+	var changedAt time.Time
+	for {
+		select {
+		case <-gone:
+			return
+		case now := <-ticker.C:
+			next, err := snapshotWorktree(inst.WorktreeDir)
+			if err != nil {
+				fmt.Fprintf(w, "error: %v; stopped watching\n", err)
+				proto.WriteResultTrailer(w, proto.StreamResult{OK: false, Error: err.Error()})
+				return
+			}
+			if changed := next.changedSince(snap); len(changed) > 0 {
+				pending = mergeChanges(pending, changed)
+				changedAt = now
+				snap = next
+				continue
+			}
+			if len(pending) == 0 || now.Sub(changedAt) < checkWatchQuiet {
+				continue
+			}
+			why := describeChanges(pending)
+			pending = nil
+			if !check(why) {
+				return
+			}
+		}
+	}
+}
+
+// watchRun runs p's checks on inst once for watchChecks.  The output goes
+// to w; the error says why the checks could not run.
+func (d *Daemon) watchRun(inst *Instance, p *Project, w io.Writer) (proto.StreamResult, error) {
+	endOp, err := inst.beginOperation(opCheck)
+	if err != nil {
+		return proto.StreamResult{}, err
+	}
+	defer endOp()
+	if resp := inst.removedError("check", true, true); resp != nil {
+		return proto.StreamResult{}, fmt.Errorf("%s", resp.Error)
+	}
+	after, err := inst.beginCheck(p.Check.maxDuration(), false, false)
+	if err != nil {
+		return proto.StreamResult{}, err
+	}
+	return d.runChecks(inst, p, after, nil, w, false), nil
+}
+
+// mergeChanges returns the files in a or b, once each, in order.
+func mergeChanges(a, b []string) []string {
+	seen := make(map[string]bool, len(a)+len(b))
+	var merged []string
+	for _, s := range append(append([]string(nil), a...), b...) {
+		if !seen[s] {
+			seen[s] = true
+			merged = append(merged, s)
+		}
+	}
+	sort.Strings(merged)
+	return merged
+}
@@ -0,0 +1,105 @@
+package daemon
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotWorktree(t *testing.T) {
+	_, inst := teardownTest(t, proto.StateWaiting)
+	dir := inst.WorktreeDir
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "tracked.go"), []byte("package a\n"), 0o644))
+	git(t, "-C", dir, "add", "tracked.go")
+	git(t, "-C", dir, "-c", "user.name=t", "-c", "user.email=t@t", "commit", "-q", "-m", "add")
+	require.NoError(t, applyGitExcludes(dir, []string{"node_modules/"}))
+
+	before, err := snapshotWorktree(dir)
+	require.NoError(t, err)
+	assert.Empty(t, before, "a clean worktree")
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "tracked.go"), []byte("package b\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "new.go"), []byte("package a\n"), 0o644))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "node_modules"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "node_modules", "x.js"), nil, 0o644))
+	after, err := snapshotWorktree(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"new.go", "tracked.go"}, after.changedSince(before), "git.exclude patterns are left out")
+
+	require.NoError(t, os.Remove(filepath.Join(dir, "tracked.go")))
+	deleted, err := snapshotWorktree(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"tracked.go"}, deleted.changedSince(after))
+	assert.Empty(t, deleted.changedSince(deleted))
+}
+
+func TestDescribeChanges(t *testing.T) {
+	assert.Equal(t, "a.go changed", describeChanges([]string{"a.go"}))
+	assert.Equal(t, "a.go, b.go, c.go and 2 more changed", describeChanges([]string{"a.go", "b.go", "c.go", "d.go", "e.go"}))
+	assert.Equal(t, []string{"a", "b", "c"}, mergeChanges([]string{"a", "c"}, []string{"b", "c"}))
+}
+
+func TestWatchChecks(t *testing.T) {
+	recordingDocker(t)
+	d, inst := teardownTest(t, proto.StateWaiting)
+	inst.LogFile = filepath.Join(d.rootDir, "instances", "1.log")
+	events := d.events.subscribe()
+	p := &Project{Check: CheckConfig{Commands: []CommandSpec{{Run: "make test"}}}}
+
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		d.watchChecks(server, inst, p)
+		server.Close()
+		close(done)
+	}()
+	require.NoError(t, client.SetReadDeadline(time.Now().Add(10*time.Second)))
+	r := bufio.NewReader(client)
+	readUntil := func(want string) {
+		t.Helper()
+		for {
+			line, err := r.ReadString('\n')
+			require.NoError(t, err)
+			if strings.Contains(line, want) {
+				return
+			}
+		}
+	}
+	readUntil(`"ok":true`)
+	readUntil("check 1 at")
+	readUntil("checks passed (1)")
+
+	_, err := inst.beginCheckWatch()
+	assert.ErrorContains(t, err, "instance 1 already has a check watch")
+
+	require.NoError(t, os.WriteFile(filepath.Join(inst.WorktreeDir, "a.go"), nil, 0o644))
+	readUntil("a.go changed")
+	readUntil("checks passed (1)")
+	assert.Equal(t, proto.StateWaiting, inst.Info().State)
+
+	client.Close()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the watch did not end when the client hung up")
+	}
+	release, err := inst.beginCheckWatch()
+	require.NoError(t, err, "released")
+	release()
+
+	ev := <-events
+	assert.Equal(t, proto.EventCheck, ev.Type)
+	select {
+	case ev := <-events:
+		t.Errorf("unchanged outcome published again: %+v", ev)
+	default:
+	}
+}
@@ -0,0 +1,120 @@
+package daemon
+
+// configcache.go – parsed config files, kept between requests.
+//
+// Every check, finish and automatic check needs an instance's config, and
+// reading it meant parsing grove.yaml and each file it includes again.  The
+// daemon keeps what it parsed instead, keyed by the file's path, and uses it
+// for as long as the files it came from (the document and its includes)
+// keep their size and modification time.  Modification times are only as
+// fine as the filesystem's clock tick, so a file modified shortly before it
+// was parsed is not trusted to show a later edit, and is parsed again until
+// it has been left alone for a while.  pullMain drops a project's entries
+// outright.
+//
+// Cached configs are shared: callers must replace their slices and maps,
+// never modify them in place (overlayConfig already works that way).
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fileStamp identifies a version of a file well enough to notice an edit.
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+func statStamp(path string) (fileStamp, bool) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}, false
+	}
+	return fileStamp{size: fi.Size(), modTime: fi.ModTime()}, true
+}
+
+// racyWindow is how long before a parse a file must have last been modified
+// for its stamp to be trusted: an edit within the same clock tick would not
+// change it.
+const racyWindow = 2 * time.Second
+
+// cachedConfig is a parsed config and the files it was parsed from.
+type cachedConfig struct {
+	p      *Project
+	files  map[string]fileStamp
+	parsed time.Time
+}
+
+// fresh reports whether none of c's files has changed since it was parsed.
+func (c *cachedConfig) fresh() bool {
+	for path, stamp := range c.files {
+		if !stamp.modTime.Before(c.parsed.Add(-racyWindow)) {
+			return false
+		}
+		if now, ok := statStamp(path); !ok || now != stamp {
+			return false
+		}
+	}
+	return true
+}
+
+// configCache maps the path of a config file to its parsed contents.
+type configCache struct {
+	mu      sync.Mutex
+	entries map[string]*cachedConfig
+	parses  int // files parsed, for tests and benchmarks
+}
+
+// configs caches in-repo grove.yaml files and instance config snapshots.
+var configs = &configCache{entries: make(map[string]*cachedConfig)}
+
+// load returns the config at path, parsing it with parse unless a fresh
+// parse is cached.  parse records in files each file it reads, path
+// included.  Failed parses are not cached.
+func (c *configCache) load(path string, parse func(files map[string]fileStamp) (*Project, error)) (*Project, error) {
+	c.mu.Lock()
+	e := c.entries[path]
+	c.mu.Unlock()
+	if e != nil && e.fresh() {
+		return e.p, nil
+	}
+
+	files := make(map[string]fileStamp)
+	parsed := time.Now()
+	p, err := parse(files)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.parses++
+	if err != nil {
+		delete(c.entries, path)
+		return nil, err
+	}
+	c.entries[path] = &cachedConfig{p: p, files: files, parsed: parsed}
+	return p, nil
+}
+
+// readFile reads path for a parse, recording its stamp in files.  The stamp
+// is taken first, so an edit during the read makes the entry stale rather
+// than caching the old contents as new.
+func readFile(path string, files map[string]fileStamp) ([]byte, error) {
+	if stamp, ok := statStamp(path); ok {
+		files[path] = stamp
+	}
+	return os.ReadFile(path)
+}
+
+// forgetDir drops every entry for a file under dir.
+func (c *configCache) forgetDir(dir string) {
+	prefix := filepath.Clean(dir) + string(filepath.Separator)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for path := range c.entries {
+		if strings.HasPrefix(path, prefix) {
+			delete(c.entries, path)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// backdate sets the modification time of files to long enough ago for the
+// config cache to trust it, each file a second apart so edits stand out.
+func backdate(t testing.TB, files ...string) {
+	t.Helper()
+	for i, f := range files {
+		when := time.Now().Add(-time.Hour - time.Duration(i)*time.Second)
+		require.NoError(t, os.Chtimes(f, when, when))
+	}
+}
+
+func parses() int {
+	configs.mu.Lock()
+	defer configs.mu.Unlock()
+	return configs.parses
+}
+
+func TestConfigCacheReusesParse(t *testing.T) {
+	dataDir := t.TempDir()
+	mainDir := filepath.Join(dataDir, "main")
+	writeRepoFiles(t, mainDir, map[string]string{
+		"grove.yaml":  "include: [shared.yaml]\nfinish: [git push]\n",
+		"shared.yaml": "check: [make test]\n",
+	})
+	doc, shared := filepath.Join(mainDir, "grove.yaml"), filepath.Join(mainDir, "shared.yaml")
+	backdate(t, doc, shared)
+
+	load := func() *Project {
+		t.Helper()
+		p := &Project{DataDir: dataDir}
+		found, err := loadInRepoConfig(p, "", "")
+		require.NoError(t, err)
+		require.True(t, found)
+		return p
+	}
+
+	before := parses()
+	load()
+	p := load()
+	assert.Equal(t, 1, parses()-before, "an unchanged config is parsed once")
+	assert.Equal(t, commands("make test"), p.Check.Commands)
+
+	// An edit to an included file is noticed, even at the same size.
+	writeRepoFiles(t, mainDir, map[string]string{"shared.yaml": "check: [make lint]\n"})
+	backdate(t, shared)
+	p = load()
+	assert.Equal(t, 2, parses()-before)
+	assert.Equal(t, commands("make lint"), p.Check.Commands)
+
+	// So is a deleted one.
+	require.NoError(t, os.Remove(shared))
+	_, err := loadInRepoConfig(&Project{DataDir: dataDir}, "", "")
+	assert.ErrorContains(t, err, "not found")
+}
+
+func TestConfigCacheRecentFilesReparsed(t *testing.T) {
+	dataDir := t.TempDir()
+	mainDir := filepath.Join(dataDir, "main")
+	writeRepoFiles(t, mainDir, map[string]string{"grove.yaml": "check: [make test]\n"})
+
+	// A file written just now could be edited again within the same clock
+	// tick, so its stamp is not trusted yet.
+	before := parses()
+	for i := 0; i < 2; i++ {
+		_, err := loadInRepoConfig(&Project{DataDir: dataDir}, "", "")
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 2, parses()-before)
+}
+
+func TestConfigCacheForgetDir(t *testing.T) {
+	dataDir := t.TempDir()
+	mainDir := filepath.Join(dataDir, "main")
+	writeRepoFiles(t, mainDir, map[string]string{"grove.yaml": "check: [make test]\n"})
+	backdate(t, filepath.Join(mainDir, "grove.yaml"))
+
+	before := parses()
+	_, err := loadInRepoConfig(&Project{DataDir: dataDir}, "", "")
+	require.NoError(t, err)
+	configs.forgetDir(mainDir + "-other")
+	_, err = loadInRepoConfig(&Project{DataDir: dataDir}, "", "")
+	require.NoError(t, err)
+	assert.Equal(t, 1, parses()-before, "only entries under the directory are dropped")
+
+	configs.forgetDir(mainDir)
+	_, err = loadInRepoConfig(&Project{DataDir: dataDir}, "", "")
+	require.NoError(t, err)
+	assert.Equal(t, 2, parses()-before)
+}
+
+func TestConfigCacheSkipsFailedParses(t *testing.T) {
+	dataDir := t.TempDir()
+	mainDir := filepath.Join(dataDir, "main")
+	writeRepoFiles(t, mainDir, map[string]string{"grove.yaml": "check: [make test\n"})
+	backdate(t, filepath.Join(mainDir, "grove.yaml"))
+
+	before := parses()
+	for i := 0; i < 2; i++ {
+		_, err := loadInRepoConfig(&Project{DataDir: dataDir}, "", "")
+		require.Error(t, err)
+	}
+	assert.Equal(t, 2, parses()-before)
+}
+
+func TestSnapshotProjectCached(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "instances"), 0o755))
+	d := &Daemon{rootDir: root, instances: make(map[string]*Instance)}
+	inst := &Instance{ID: "1", Project: "app", MainDir: filepath.Join(root, "projects", "app", "main")}
+
+	p := &Project{Finish: commands("git push")}
+	p.Container.Image = "alpine"
+	d.writeConfigSnapshot(inst, p)
+	backdate(t, configSnapshotPath(filepath.Join(root, "instances"), inst.ID))
+
+	before := parses()
+	got, err := d.snapshotProject(inst)
+	require.NoError(t, err)
+	got.Container.Image = "changed"
+
+	got, err = d.snapshotProject(inst)
+	require.NoError(t, err)
+	assert.Equal(t, 1, parses()-before)
+	assert.Equal(t, "alpine", got.Container.Image, "callers get their own copy")
+	assert.Equal(t, inst.MainDir, got.MainDir())
+}
+
+// BenchmarkHandleList lists instances whose projects all have a grove.yaml,
+// and fails if that parses any config.
+func BenchmarkHandleList(b *testing.B) {
+	root := b.TempDir()
+	d := &Daemon{rootDir: root, instances: make(map[string]*Instance)}
+	for i := 1; i <= 50; i++ {
+		mainDir := filepath.Join(root, "projects", fmt.Sprintf("app%d", i), "main")
+		writeRepoFiles(b, mainDir, map[string]string{"grove.yaml": "check: [make test]\n"})
+		id := fmt.Sprint(i)
+		d.instances[id] = &Instance{
+			ID: id, Project: fmt.Sprintf("app%d", i), Branch: "main",
+			MainDir: mainDir, WorktreeDir: mainDir, state: proto.StateWaiting,
+		}
+	}
+
+	before := parses()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		client, server := net.Pipe()
+		go func() {
+			d.handleList(server, proto.Request{Type: proto.ReqList})
+			server.Close()
+		}()
+		var resp proto.Response
+		if err := json.NewDecoder(client).Decode(&resp); err != nil || len(resp.Instances) != 50 {
+			b.Fatalf("list: %v, %d instances", err, len(resp.Instances))
+		}
+		client.Close()
+	}
+	b.StopTimer()
+	if n := parses() - before; n != 0 {
+		b.Fatalf("handleList parsed %d configs", n)
+	}
+}
+
+// BenchmarkInstanceProject loads the config of an instance without a
+// snapshot, a grove.yaml with an include, parsed afresh each time and from
+// the cache.
+func BenchmarkInstanceProject(b *testing.B) {
+	root := b.TempDir()
+	writeRepoFiles(b, filepath.Join(root, "projects", "app"), map[string]string{
+		"project.yaml": "name: app\nrepo: https://example.com/app.git\n",
+	})
+	mainDir := filepath.Join(root, "projects", "app", "main")
+	writeRepoFiles(b, mainDir, map[string]string{
+		"grove.yaml":  "include: [shared.yaml]\nfinish: [git push]\noverrides:\n  feat/*:\n    check: [make lint]\n",
+		"shared.yaml": "container:\n  image: alpine\ncheck: [make test]\nstart: [npm ci]\n",
+	})
+	backdate(b, filepath.Join(mainDir, "grove.yaml"), filepath.Join(mainDir, "shared.yaml"))
+	d := &Daemon{rootDir: root, instances: make(map[string]*Instance)}
+	inst := &Instance{ID: "1", Project: "app", Branch: "feat/x", MainDir: mainDir, WorktreeDir: mainDir}
+
+	for _, cached := range []bool{false, true} {
+		b.Run(fmt.Sprintf("cached=%t", cached), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if !cached {
+					configs.forgetDir(mainDir)
+				}
+				if _, err := d.instanceProject(inst, false); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -1,19 +1,26 @@
 package daemon
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
 )
 
 // validateDocker checks that Docker is available by running "docker info".
 func validateDocker() error {
-	cmd := exec.Command("docker", "info")
+	cmd := dockerCommand("info")
 	cmd.Stdout = io.Discard
 	cmd.Stderr = io.Discard
 	if err := cmd.Run(); err != nil {
@@ -23,22 +30,165 @@ func validateDocker() error {
 }
 
 // startContainer dispatches to the single-container or compose variant.
-// Returns the exec target container name.
-func startContainer(p *Project, instanceID, worktreeDir string, w io.Writer) (string, error) {
+// Instance labels are applied to the container as grove.label.<key> so
+// external tooling can find it.  Returns the exec target container name.
+func startContainer(ctx context.Context, p *Project, instanceID, worktreeDir string, labels map[string]string, w io.Writer) (string, error) {
 	if p.Container.Compose != "" {
-		return startComposeContainer(p, instanceID, worktreeDir, w)
+		return startComposeContainer(ctx, p, instanceID, worktreeDir, labels, w)
 	}
 	if p.Container.Image == "" {
-		groveYAML := filepath.Join(p.MainDir(), "grove.yaml")
+		groveYAML := findInRepoConfig(worktreeDir)
+		if groveYAML == "" {
+			groveYAML = findInRepoConfig(p.MainDir())
+		}
 		return "", fmt.Errorf("no container configured in %s\nadd a 'container:' section, e.g.:\n\n  container:\n    image: ubuntu:24.04\n", groveYAML)
 	}
-	return startSingleContainer(p, instanceID, worktreeDir, w)
+	return startSingleContainer(ctx, p, instanceID, worktreeDir, labels, w)
+}
+
+// pullImage pulls p's container image unless it is already present, so that
+// the pull is not hidden inside docker run.  Compose projects are left to
+// docker compose up.
+func pullImage(ctx context.Context, p *Project, w io.Writer) error {
+	image := p.Container.Image
+	if p.Container.Compose != "" || image == "" {
+		return nil
+	}
+	if out, err := dockerCommand("image", "inspect", "--format", platformFormat, image).Output(); err == nil {
+		// A copy for another platform than the configured one does not do.
+		platform := p.Container.Platform
+		if platform == "" || sameArch(strings.TrimSpace(string(out)), platform) {
+			return nil
+		}
+	}
+	args := []string{"pull", image}
+	if p.Container.Platform != "" {
+		fmt.Fprintf(w, "Pulling image %s for %s …\n", image, p.Container.Platform)
+		args = []string{"pull", "--platform", p.Container.Platform, image}
+	} else {
+		fmt.Fprintf(w, "Pulling image %s …\n", image)
+	}
+	cmd := dockerCommandContext(ctx, args...)
+	cmd.Stdout = w
+	cmd.Stderr = w
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("docker pull %s: %w", image, err)
+	}
+	return nil
+}
+
+// inspectImage returns the image containerName was created from, or nil if
+// docker cannot say.  Its platform is filled in if docker knows it, and
+// HostPlatform if that is not what the host runs natively.
+func inspectImage(containerName string) *proto.ContainerImage {
+	out, err := dockerCommand("inspect", "--format", "{{.Config.Image}}\t{{.Image}}", containerName).Output()
+	if err != nil {
+		log.Printf("docker inspect %s: %v", containerName, err)
+		return nil
+	}
+	img := parseInspectImage(out)
+	if img == nil || img.Digest == "" {
+		return img
+	}
+	if out, err := dockerCommand("image", "inspect", "--format", platformFormat, img.Digest).Output(); err == nil {
+		img.Platform = strings.TrimSpace(string(out))
+	}
+	if host := hostPlatform(); img.Platform != "" && host != "" && !sameArch(img.Platform, host) {
+		img.HostPlatform = host
+	}
+	return img
+}
+
+// platformFormat makes docker image inspect print the image's platform.
+const platformFormat = "{{.Os}}/{{.Architecture}}{{if .Variant}}/{{.Variant}}{{end}}"
+
+// hostPlatform returns the os/arch the Docker daemon runs containers on
+// natively, e.g. linux/arm64 under Docker Desktop on Apple Silicon, or ""
+// if docker cannot say.
+func hostPlatform() string {
+	out, err := dockerCommand("info", "--format", "{{.OSType}}/{{.Architecture}}").Output()
+	if err != nil {
+		return ""
+	}
+	osType, arch, ok := strings.Cut(strings.TrimSpace(string(out)), "/")
+	if !ok || osType == "" || arch == "" {
+		return ""
+	}
+	return osType + "/" + normalizeArch(arch)
+}
+
+// normalizeArch maps uname -m names, which docker info reports, to the
+// GOARCH-style names of image platforms.
+func normalizeArch(arch string) string {
+	switch arch {
+	case "x86_64", "x86-64":
+		return "amd64"
+	case "aarch64", "arm64/v8":
+		return "arm64"
+	case "armv7l", "armhf":
+		return "arm"
+	case "i386", "i686":
+		return "386"
+	}
+	return arch
+}
+
+// sameArch reports whether two os/arch[/variant] platforms have the same
+// architecture.  Variants are ignored: arm64/v8 runs natively on arm64.
+func sameArch(a, b string) bool {
+	archOf := func(platform string) string {
+		parts := strings.Split(platform, "/")
+		if len(parts) < 2 {
+			return ""
+		}
+		return normalizeArch(parts[1])
+	}
+	return archOf(a) == archOf(b)
+}
+
+// emulationWarning is the setup output for an image that runs under
+// emulation.  platform is container.platform: if it asked for this, the
+// user knows why.
+func emulationWarning(img *proto.ContainerImage, platform string) string {
+	msg := fmt.Sprintf("warning: running %s under emulation on this %s host — expect 3–10x slowdown\n",
+		img.Platform, img.HostPlatform)
+	if platform == "" {
+		msg += fmt.Sprintf("hint: %s has no %s variant; an image that has one runs natively\n", img.Ref, img.HostPlatform)
+	}
+	return msg
+}
+
+// containerEnv returns the env containerName was configured with (its
+// image's ENV plus any set at creation) as KEY=value, or nil if docker
+// cannot say.
+func containerEnv(containerName string) []string {
+	out, err := dockerCommand("inspect", "--format", "{{json .Config.Env}}", containerName).Output()
+	if err != nil {
+		log.Printf("docker inspect %s: %v", containerName, err)
+		return nil
+	}
+	var env []string
+	if err := json.Unmarshal(out, &env); err != nil {
+		log.Printf("docker inspect %s: %v", containerName, err)
+		return nil
+	}
+	return env
+}
+
+// parseInspectImage parses inspectImage's docker inspect output: the image
+// reference and the image ID, separated by a tab.
+func parseInspectImage(out []byte) *proto.ContainerImage {
+	ref, digest, ok := strings.Cut(strings.TrimSpace(string(out)), "\t")
+	if !ok || ref == "" {
+		return nil
+	}
+	return &proto.ContainerImage{Ref: ref, Digest: digest}
 }
 
 // startSingleContainer runs:
 //
-//	docker run -d --name grove-<id> -v <worktreeDir>:<workdir> -w <workdir> [mounts...] <image> sleep infinity
-func startSingleContainer(p *Project, instanceID, worktreeDir string, w io.Writer) (string, error) {
+//	docker run -d --name grove-<id> -v <worktreeDir>:<workdir> -w <workdir> [--platform <platform>] [labels...] [mounts...] [tmpfs and scratch...] <image> sleep infinity
+func startSingleContainer(ctx context.Context, p *Project, instanceID, worktreeDir string, labels map[string]string, w io.Writer) (string, error) {
 	name := "grove-" + instanceID
 	workdir := p.containerWorkdir()
 	image := p.Container.Image
@@ -48,13 +198,25 @@ func startSingleContainer(p *Project, instanceID, worktreeDir string, w io.Write
 		"-v", worktreeDir + ":" + workdir,
 		"-w", workdir,
 	}
+	if p.Container.Platform != "" {
+		args = append(args, "--platform", p.Container.Platform)
+	}
+	for _, l := range append(ownerLabels(p.Name, instanceID), containerLabels(labels)...) {
+		args = append(args, "--label", l)
+	}
 	for _, m := range buildMounts(p, w) {
 		args = append(args, "-v", m[0]+":"+m[1])
 	}
+	for _, t := range p.containerTmpfs() {
+		args = append(args, "--tmpfs", t)
+	}
+	for _, dir := range p.Container.Scratch {
+		args = append(args, "--mount", scratchMount(p.Name, instanceID, dir))
+	}
 	args = append(args, image, "sleep", "infinity")
 
 	fmt.Fprintf(w, "Starting container %s (image: %s) …\n", name, image)
-	cmd := exec.Command("docker", args...)
+	cmd := dockerCommandContext(ctx, args...)
 	out, err := cmd.CombinedOutput()
 	if len(out) > 0 {
 		w.Write(out)
@@ -65,13 +227,22 @@ func startSingleContainer(p *Project, instanceID, worktreeDir string, w io.Write
 	return name, nil
 }
 
+// scratchMount returns the --mount value for a container.scratch path: an
+// anonymous volume, labelled so that grove gc can find it once its
+// container is gone.
+func scratchMount(project, instanceID, dir string) string {
+	return fmt.Sprintf("type=volume,dst=%s,volume-label=%s=%s,volume-label=%s=%s",
+		dir, labelProject, project, labelScratch, instanceID)
+}
+
 // startComposeContainer writes a temporary override YAML that bind-mounts the
-// worktree (and any extra mounts) into the app service, then runs:
+// worktree (and any extra mounts) into the app service, adds the tmpfs and
+// scratch mounts, then runs:
 //
 //	docker compose -p grove-<id> -f <composefile> -f <overridefile> up -d
 //
 // Returns "grove-<id>-<service>-1" as the exec target.
-func startComposeContainer(p *Project, instanceID, worktreeDir string, w io.Writer) (string, error) {
+func startComposeContainer(ctx context.Context, p *Project, instanceID, worktreeDir string, labels map[string]string, w io.Writer) (string, error) {
 	project := "grove-" + instanceID
 	service := p.containerService()
 	workdir := p.containerWorkdir()
@@ -82,7 +253,24 @@ func startComposeContainer(p *Project, instanceID, worktreeDir string, w io.Writ
 	for _, m := range buildMounts(p, w) {
 		volumes += fmt.Sprintf("      - type: bind\n        source: %s\n        target: %s\n", m[0], m[1])
 	}
+	// Compose cannot label an anonymous volume, but down -v removes them.
+	for _, dir := range p.Container.Scratch {
+		volumes += fmt.Sprintf("      - type: volume\n        target: %s\n", dir)
+	}
 	overrideContent := fmt.Sprintf("services:\n  %s:\n    volumes:\n%s", service, volumes)
+	if p.Container.Platform != "" {
+		overrideContent += fmt.Sprintf("    platform: %s\n", p.Container.Platform)
+	}
+	if tmpfs := p.containerTmpfs(); len(tmpfs) > 0 {
+		overrideContent += "    tmpfs:\n"
+		for _, t := range tmpfs {
+			overrideContent += fmt.Sprintf("      - %q\n", t)
+		}
+	}
+	overrideContent += "    labels:\n"
+	for _, l := range append(ownerLabels(p.Name, instanceID), containerLabels(labels)...) {
+		overrideContent += fmt.Sprintf("      - %q\n", l)
+	}
 
 	overrideFile, err := os.CreateTemp("", "grove-compose-override-*.yml")
 	if err != nil {
@@ -98,7 +286,7 @@ func startComposeContainer(p *Project, instanceID, worktreeDir string, w io.Writ
 	defer os.Remove(overridePath)
 
 	fmt.Fprintf(w, "Starting compose stack %s (compose: %s, service: %s) …\n", project, composeFile, service)
-	cmd := exec.Command("docker", "compose",
+	cmd := dockerCommandContext(ctx, "compose",
 		"-p", project,
 		"-f", composeFile,
 		"-f", overridePath,
@@ -114,36 +302,291 @@ func startComposeContainer(p *Project, instanceID, worktreeDir string, w io.Writ
 	return project + "-" + service + "-1", nil
 }
 
+// containerLabels converts instance labels to sorted "grove.label.<key>=<value>"
+// strings suitable for docker's --label flag.
+func containerLabels(labels map[string]string) []string {
+	out := make([]string, 0, len(labels))
+	for k, v := range labels {
+		out = append(out, "grove.label."+k+"="+v)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Docker labels identifying the project and instance a container belongs to,
+// so project delete can find containers the daemon has lost track of.
+const (
+	labelProject  = "grove.project"
+	labelInstance = "grove.instance"
+	labelScratch  = "grove.scratch" // on container.scratch volumes: the instance ID
+)
+
+// ownerLabels returns the labels every grove container carries.
+func ownerLabels(project, instanceID string) []string {
+	return []string{labelProject + "=" + project, labelInstance + "=" + instanceID}
+}
+
+// buildImageRepo is the repository name of images grove builds for a project.
+func buildImageRepo(project string) string {
+	return "grove-build/" + project
+}
+
+// removeProjectContainers force-removes every container labelled as
+// belonging to project, whether or not an instance still refers to it.
+func removeProjectContainers(project string, w io.Writer) error {
+	return dockerSweep(w, "container",
+		[]string{"ps", "-aq", "--filter", "label=" + labelProject + "=" + project},
+		[]string{"rm", "-f", "-v"})
+}
+
+// removeProjectVolumes removes the volumes labelled as belonging to project.
+func removeProjectVolumes(project string, w io.Writer) error {
+	return dockerSweep(w, "volume",
+		[]string{"volume", "ls", "-q", "--filter", "label=" + labelProject + "=" + project},
+		[]string{"volume", "rm", "-f"})
+}
+
+// removeDanglingScratch removes the container.scratch volumes of single
+// containers that no container uses any more.  Dropping an instance
+// removes its volumes with its container; this catches those left behind
+// by containers removed some other way.
+func removeDanglingScratch(w io.Writer) error {
+	return dockerSweep(w, "volume",
+		[]string{"volume", "ls", "-q", "--filter", "dangling=true", "--filter", "label=" + labelScratch},
+		[]string{"volume", "rm", "-f"})
+}
+
+// removeProjectImages removes every tag of the project's built image.
+func removeProjectImages(project string, w io.Writer) error {
+	return dockerSweep(w, "image",
+		[]string{"image", "ls", "-q", buildImageRepo(project)},
+		[]string{"image", "rm", "-f"})
+}
+
+// dockerSweep runs the docker list command, which prints one ID per line,
+// then the remove command on whatever it printed, reporting to w.
+func dockerSweep(w io.Writer, what string, list, remove []string) error {
+	out, err := dockerCommand(list...).Output()
+	if err != nil {
+		return fmt.Errorf("list %ss: %w", what, err)
+	}
+	ids := uniqueFields(string(out))
+	if len(ids) == 0 {
+		fmt.Fprintf(w, "No %ss to remove\n", what)
+		return nil
+	}
+	fmt.Fprintf(w, "Removing %d %s(s) …\n", len(ids), what)
+	if out, err := dockerCommand(append(remove, ids...)...).CombinedOutput(); err != nil {
+		return fmt.Errorf("remove %ss: %w: %s", what, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// uniqueFields splits s on whitespace, dropping repeats (docker image ls
+// lists an image once per tag).
+func uniqueFields(s string) []string {
+	var out []string
+	seen := make(map[string]bool)
+	for _, f := range strings.Fields(s) {
+		if !seen[f] {
+			seen[f] = true
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
 // stopContainer tears down the container or compose stack for an instance.
 // If composeProject is non-empty, tears down the compose stack; otherwise
-// stops and removes the single container.
+// stops and removes the single container, with its anonymous (scratch) volumes.
 func stopContainer(containerName, composeProject string) {
 	if composeProject != "" {
-		exec.Command("docker", "compose", "-p", composeProject, "down", "-v").Run()
+		dockerCommand("compose", "-p", composeProject, "down", "-v").Run()
 		return
 	}
-	exec.Command("docker", "stop", containerName).Run()
-	exec.Command("docker", "rm", containerName).Run()
+	dockerCommand("stop", containerName).Run()
+	dockerCommand("rm", "-v", containerName).Run()
+}
+
+// stopStack stops the services of compose project composeProject without
+// removing them (docker compose stop), so that startStack can resume them.
+func stopStack(composeProject string) error {
+	if out, err := dockerCommand("compose", "-p", composeProject, "stop").CombinedOutput(); err != nil {
+		return fmt.Errorf("docker compose stop: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// startStack starts the services stopStack stopped, writing docker's output
+// to w.
+func startStack(composeProject string, w io.Writer) error {
+	fmt.Fprintf(w, "Starting compose services of %s …\n", composeProject)
+	cmd := dockerCommand("compose", "-p", composeProject, "start")
+	cmd.Stdout = w
+	cmd.Stderr = w
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("docker compose start: %w", err)
+	}
+	return nil
+}
+
+// containerRunning reports whether the named container exists and is running.
+func containerRunning(containerName string) bool {
+	out, err := dockerCommand("inspect", "-f", "{{.State.Running}}", containerName).Output()
+	return err == nil && strings.TrimSpace(string(out)) == "true"
+}
+
+// containerStats takes one "docker stats" sample of every running container
+// and returns the parsed results keyed by container name.
+func containerStats() (map[string]proto.InstanceStats, error) {
+	out, err := dockerCommand("stats", "--no-stream", "--format", "{{json .}}").Output()
+	if err != nil {
+		return nil, fmt.Errorf("docker stats: %w", err)
+	}
+	return parseDockerStats(out), nil
+}
+
+// parseDockerStats parses newline-delimited "docker stats --format '{{json .}}'"
+// output.  Malformed lines are skipped.
+func parseDockerStats(out []byte) map[string]proto.InstanceStats {
+	stats := make(map[string]proto.InstanceStats)
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		var row struct {
+			Name     string `json:"Name"`
+			CPUPerc  string `json:"CPUPerc"`
+			MemUsage string `json:"MemUsage"`
+			MemPerc  string `json:"MemPerc"`
+			NetIO    string `json:"NetIO"`
+		}
+		if err := json.Unmarshal([]byte(line), &row); err != nil || row.Name == "" {
+			continue
+		}
+		stats[row.Name] = proto.InstanceStats{
+			CPUPercent: parsePercent(row.CPUPerc),
+			MemUsage:   row.MemUsage,
+			MemPercent: parsePercent(row.MemPerc),
+			NetIO:      row.NetIO,
+		}
+	}
+	return stats
+}
+
+// parsePercent converts docker's "12.34%" to 12.34; unparseable input ("--"
+// for a container that is starting) yields 0.
+func parsePercent(s string) float64 {
+	v, _ := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(s), "%"), 64)
+	return v
+}
+
+// execOptions control how execInContainer runs a command.
+type execOptions struct {
+	Workdir string        // directory in the container; "" for its workdir
+	Timeout time.Duration // > 0 stops waiting for the command after this long
+	TTY     bool          // allocate a pseudo-TTY (docker exec -t)
+	Stdin   *clientStdin  // with TTY, a client's input to pass on (docker exec -i)
+	Env     []string      // KEY=VALUE pairs to set (docker exec -e)
 }
 
 // execInContainer runs cmd inside the named container using "docker exec".
-func execInContainer(containerName, cmd string, w io.Writer) error {
-	c := exec.Command("docker", "exec", containerName, "sh", "-c", cmd)
+// On timeout, or if ctx is cancelled, docker exec is killed, which may leave
+// the command running in the container.
+func execInContainer(ctx context.Context, containerName string, opts execOptions, cmd string, w io.Writer) error {
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+	args := []string{"exec"}
+	interactive := opts.TTY && opts.Stdin != nil
+	if interactive {
+		args = append(args, "-i")
+	}
+	if opts.TTY {
+		args = append(args, "-t")
+	}
+	if opts.Workdir != "" {
+		args = append(args, "-w", opts.Workdir)
+	}
+	for _, kv := range opts.Env {
+		args = append(args, "-e", kv)
+	}
+	args = append(args, containerName, "sh", "-c", cmd)
+	c := dockerCommandContext(ctx, args...)
 	c.Stdout = w
 	c.Stderr = w
-	if err := c.Run(); err != nil {
+
+	err := func() error {
+		if !interactive {
+			return c.Run()
+		}
+		stdin, err := c.StdinPipe()
+		if err != nil {
+			return err
+		}
+		if err := c.Start(); err != nil {
+			return err
+		}
+		detach := opts.Stdin.attach(stdin)
+		defer detach()
+		return c.Wait()
+	}()
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("exec in container %s: timed out after %s", containerName, opts.Timeout)
+		}
 		return fmt.Errorf("exec in container %s: %w", containerName, err)
 	}
 	return nil
 }
 
+// commandOptions returns the execOptions for running c for p, with stdin
+// the requesting client's input if it is interactive.
+func commandOptions(p *Project, c CommandSpec, stdin *clientStdin) execOptions {
+	return execOptions{Workdir: p.commandDir(c), Timeout: c.Timeout, TTY: c.TTY, Stdin: stdin}
+}
+
+// runCommandResult runs c.Run like execInContainer and also reports its
+// exit code and duration for the check/finish result trailer.
+func runCommandResult(containerName string, c CommandSpec, opts execOptions, w io.Writer) (proto.CommandResult, error) {
+	start := time.Now()
+	err := execInContainer(context.Background(), containerName, opts, c.Run, w)
+	return commandResult(c, start, err), err
+}
+
+// commandResult is the result of c, run from start until it returned err.
+func commandResult(c CommandSpec, start time.Time, err error) proto.CommandResult {
+	return proto.CommandResult{
+		Command:    c.Run,
+		Name:       c.Name,
+		ExitCode:   exitCode(err),
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+}
+
+// exitCode returns the process exit code carried by err: 0 for nil, -1 when
+// the command never ran or was killed by a signal.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var ee *exec.ExitError
+	if errors.As(err, &ee) {
+		return ee.ExitCode()
+	}
+	return -1
+}
+
 // ensureAgentInstalled checks whether agentCmd is present in the container and,
 // if not, attempts to install it automatically for known agents.
 // All output (install progress, errors) is written to w so it appears in the
 // instance log and in the user's terminal during "grove start".
-func ensureAgentInstalled(agentCmd, containerName string, w io.Writer) error {
+func ensureAgentInstalled(ctx context.Context, agentCmd, containerName string, w io.Writer) error {
 	// Fast path: agent already installed.
-	check := exec.Command("docker", "exec", containerName,
+	check := dockerCommandContext(ctx, "exec", containerName,
 		"sh", "-c", "command -v "+agentCmd+" >/dev/null 2>&1")
 	if check.Run() == nil {
 		return nil
@@ -201,7 +644,7 @@ pip install aider-chat 2>/dev/null || pip3 install aider-chat`
 	}
 
 	fmt.Fprintf(w, "Agent %q not found — auto-installing (this runs once per container)…\n", agentCmd)
-	c := exec.Command("docker", "exec", containerName, "sh", "-c", installScript)
+	c := dockerCommandContext(ctx, "exec", containerName, "sh", "-c", installScript)
 	c.Stdout = w
 	c.Stderr = w
 	if err := c.Run(); err != nil {
@@ -211,7 +654,7 @@ pip install aider-chat 2>/dev/null || pip3 install aider-chat`
 	}
 
 	// Verify the install actually made the binary available.
-	verify := exec.Command("docker", "exec", containerName,
+	verify := dockerCommand("exec", containerName,
 		"sh", "-c", "command -v "+agentCmd+" >/dev/null 2>&1")
 	if err := verify.Run(); err != nil {
 		return fmt.Errorf("auto-install of %q appeared to succeed but the command is still not in PATH\n"+
@@ -294,7 +737,7 @@ func seedClaudeConfig(containerName string) {
 		return
 	}
 
-	cmd := exec.Command("docker", "cp", src, containerName+":/root/.claude.json")
+	cmd := dockerCommand("cp", src, containerName+":/root/.claude.json")
 	if out, err := cmd.CombinedOutput(); err != nil {
 		log.Printf("seedClaudeConfig: docker cp failed: %v: %s", err, out)
 	}
@@ -313,4 +756,3 @@ func resolveMountPath(m, home string) (source, target string) {
 	}
 	return m, m
 }
-
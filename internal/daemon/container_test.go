@@ -0,0 +1,65 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+func TestParseInspectImage(t *testing.T) {
+	img := parseInspectImage([]byte("node:22\tsha256:0123456789abcdef0123\n"))
+	assert.Equal(t, &proto.ContainerImage{Ref: "node:22", Digest: "sha256:0123456789abcdef0123"}, img)
+	assert.Equal(t, "0123456789ab", img.ShortDigest())
+
+	assert.Nil(t, parseInspectImage(nil))
+	assert.Nil(t, parseInspectImage([]byte("node:22\n")))
+	assert.Nil(t, parseInspectImage([]byte("\tsha256:01\n")))
+}
+
+func TestImageDrift(t *testing.T) {
+	d := &Daemon{rootDir: t.TempDir()}
+	worktree := t.TempDir()
+	inst := &Instance{
+		ID:          "1",
+		Project:     "scratch:app",
+		Branch:      "main",
+		WorktreeDir: worktree,
+		Scratch:     &proto.ScratchInfo{DataDir: t.TempDir()},
+	}
+	writeConfig := func(yaml string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(worktree, "grove.yaml"), []byte(yaml), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeConfig("container:\n  image: node:22\n")
+	info := proto.InstanceInfo{Image: &proto.ContainerImage{Ref: "node:22", Digest: "sha256:aa"}}
+	assert.Empty(t, d.imageDrift(inst, info))
+
+	writeConfig("container:\n  image: node:22-slim\n")
+	assert.Equal(t, "node:22-slim", d.imageDrift(inst, info))
+
+	// Nothing recorded: nothing to compare.
+	assert.Empty(t, d.imageDrift(inst, proto.InstanceInfo{}))
+}
+
+func TestScratchMount(t *testing.T) {
+	assert.Equal(t, "type=volume,dst=/cache,volume-label=grove.project=app,volume-label=grove.scratch=7",
+		scratchMount("app", "7", "/cache"))
+}
+
+func TestSameArch(t *testing.T) {
+	assert.True(t, sameArch("linux/arm64/v8", "linux/arm64"))
+	assert.True(t, sameArch("linux/x86_64", "linux/amd64"), "docker info reports uname names")
+	assert.False(t, sameArch("linux/amd64", "linux/arm64"))
+	assert.Equal(t, "arm64", normalizeArch("aarch64"))
+
+	img := &proto.ContainerImage{Ref: "node:22", Platform: "linux/amd64", HostPlatform: "linux/arm64"}
+	assert.Contains(t, emulationWarning(img, ""), "hint: node:22 has no linux/arm64 variant")
+	assert.NotContains(t, emulationWarning(img, "linux/amd64"), "hint:")
+}
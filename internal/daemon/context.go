@@ -0,0 +1,138 @@
+package daemon
+
+// context.go – project conventions handed to every agent.
+//
+// grove.yaml's agent.context_files lists files (repo-relative, absolute or
+// ~/ on the host) that grove concatenates into .grove/CONTEXT.md in the
+// worktree before each launch of the agent, so the agent starts with the
+// same commit format, test commands and directory map whatever state the
+// branch's own docs are in.  The file is listed in info/exclude so it is
+// never committed.  agent.env can point the agent at it through
+// {{context_file}}.
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// contextFile is where the concatenated context files are written,
+// relative to the worktree.
+const contextFile = ".grove/CONTEXT.md"
+
+// contextFileHeader starts the generated file.
+const contextFileHeader = "<!-- Generated by grove from agent.context_files in grove.yaml; edits are lost. -->\n"
+
+// writeContextFile writes p's context files, concatenated, to contextFile
+// in worktreeDir.  It does nothing if p lists none.  Files that cannot be
+// read are skipped, each with a warning.
+func writeContextFile(p *Project, worktreeDir string) (warnings []string, err error) {
+	if len(p.Agent.ContextFiles) == 0 {
+		return nil, nil
+	}
+	home, _ := os.UserHomeDir()
+
+	var buf bytes.Buffer
+	buf.WriteString(contextFileHeader)
+	for _, name := range p.Agent.ContextFiles {
+		path, err := resolveContextFile(name, worktreeDir, home)
+		if err != nil {
+			warnings = append(warnings, err.Error())
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("skipping context file %q — %v", name, unwrapPathError(err)))
+			continue
+		}
+		fmt.Fprintf(&buf, "\n<!-- %s -->\n", name)
+		buf.Write(data)
+		if len(data) > 0 && data[len(data)-1] != '\n' {
+			buf.WriteByte('\n')
+		}
+	}
+
+	dest := filepath.Join(worktreeDir, contextFile)
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return warnings, err
+	}
+	return warnings, os.WriteFile(dest, buf.Bytes(), 0o644)
+}
+
+// resolveContextFile returns the host path of context file name: ~/ is the
+// user's home, an absolute path is taken as it is, and anything else is
+// relative to the worktree and must stay inside it.
+func resolveContextFile(name, worktreeDir, home string) (string, error) {
+	switch {
+	case name == "~" || strings.HasPrefix(name, "~/"):
+		return filepath.Join(home, strings.TrimPrefix(name, "~")), nil
+	case filepath.IsAbs(name):
+		return name, nil
+	}
+	clean := filepath.Clean(name)
+	if clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("skipping context file %q — a relative path must be inside the repository", name)
+	}
+	return filepath.Join(worktreeDir, clean), nil
+}
+
+// unwrapPathError drops the path from a *os.PathError, which the warning
+// already names.
+func unwrapPathError(err error) error {
+	if pe, ok := err.(*os.PathError); ok {
+		return pe.Err
+	}
+	return err
+}
+
+// agentEnv returns the environment p sets for its primary agent: agent.env
+// and the first agents entry's env, with {{context_file}} expanded to the
+// context file's path in the container.
+func (p *Project) agentEnv() map[string]string {
+	var own map[string]string
+	if len(p.Agents) > 0 {
+		own = p.Agents[0].Env
+	}
+	return p.expandAgentEnv(own)
+}
+
+// helperEnv returns the environment p sets for helper agent a, as agentEnv
+// does for the primary.
+func (p *Project) helperEnv(a AgentSpec) map[string]string {
+	return p.expandAgentEnv(a.Env)
+}
+
+// expandAgentEnv merges own over agent.env and expands {{context_file}}.
+func (p *Project) expandAgentEnv(own map[string]string) map[string]string {
+	if len(p.Agent.Env) == 0 && len(own) == 0 {
+		return nil
+	}
+	inContainer := p.containerWorkdir() + "/" + contextFile
+	env := make(map[string]string, len(p.Agent.Env)+len(own))
+	for _, vars := range []map[string]string{p.Agent.Env, own} {
+		for k, v := range vars {
+			env[k] = strings.ReplaceAll(v, "{{context_file}}", inContainer)
+		}
+	}
+	return env
+}
+
+// prepareContextFile writes the context file for a launch of instance id,
+// reporting problems to w and the daemon log.  They never stop the launch.
+func prepareContextFile(id string, p *Project, worktreeDir string, w io.Writer) {
+	warnings, err := writeContextFile(p, worktreeDir)
+	if err != nil {
+		warnings = append(warnings, fmt.Sprintf("could not write %s: %v", contextFile, err))
+	}
+	for _, msg := range warnings {
+		fmt.Fprintf(w, "Warning: %s\n", msg)
+		log.Printf("instance %s: %s", id, msg)
+	}
+	if err == nil && len(p.Agent.ContextFiles) > 0 {
+		fmt.Fprintf(w, "Agent context: %s\n", contextFile)
+	}
+}
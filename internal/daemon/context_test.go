@@ -0,0 +1,62 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteContextFile(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	worktree := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(worktree, "docs"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(worktree, "docs", "conventions.md"), []byte("Commit as: area: summary"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(home, "team.md"), []byte("Run make test.\n"), 0o644))
+	abs := filepath.Join(t.TempDir(), "map.md")
+	require.NoError(t, os.WriteFile(abs, []byte("cmd/ holds the CLI.\n"), 0o644))
+
+	p := &Project{}
+	p.Agent.ContextFiles = []string{"docs/conventions.md", "~/team.md", abs, "missing.md", "../outside.md"}
+	warnings, err := writeContextFile(p, worktree)
+	require.NoError(t, err)
+	require.Len(t, warnings, 2)
+	assert.Contains(t, warnings[0], `skipping context file "missing.md" — no such file or directory`)
+	assert.Contains(t, warnings[1], `"../outside.md" — a relative path must be inside the repository`)
+
+	data, err := os.ReadFile(filepath.Join(worktree, contextFile))
+	require.NoError(t, err)
+	assert.Equal(t, contextFileHeader+
+		"\n<!-- docs/conventions.md -->\nCommit as: area: summary\n"+
+		"\n<!-- ~/team.md -->\nRun make test.\n"+
+		"\n<!-- "+abs+" -->\ncmd/ holds the CLI.\n", string(data))
+}
+
+func TestWriteContextFileNone(t *testing.T) {
+	worktree := t.TempDir()
+	warnings, err := writeContextFile(&Project{}, worktree)
+	require.NoError(t, err)
+	assert.Empty(t, warnings)
+	assert.NoFileExists(t, filepath.Join(worktree, contextFile))
+}
+
+func TestPrepareContextFileWarns(t *testing.T) {
+	p := &Project{}
+	p.Agent.ContextFiles = []string{"nope.md"}
+	var out strings.Builder
+	prepareContextFile("1", p, t.TempDir(), &out)
+	assert.Equal(t, "Warning: skipping context file \"nope.md\" — no such file or directory\nAgent context: .grove/CONTEXT.md\n", out.String())
+}
+
+func TestProjectAgentEnv(t *testing.T) {
+	p := &Project{}
+	assert.Nil(t, p.agentEnv())
+
+	p.Container.Workdir = "/src"
+	p.Agent.Env = map[string]string{"CLAUDE_MD": "{{context_file}}", "MODE": "ci"}
+	assert.Equal(t, map[string]string{"CLAUDE_MD": "/src/.grove/CONTEXT.md", "MODE": "ci"}, p.agentEnv())
+}
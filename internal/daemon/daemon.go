@@ -13,30 +13,56 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 
-	"github.com/gandalfthegui/grove/internal/proto"
+	"github.com/gandalfthegui/grove/internal/registry"
+	"github.com/gandalfthegui/grove/pkg/proto"
 )
 
 // Daemon is the central supervisor.  It owns a map of live instances and
 // handles all IPC requests from grove.
 type Daemon struct {
-	rootDir string // ~/.grove  (data root: projects, instances, logs)
+	rootDir     string   // ~/.grove  (data root: projects, instances, logs)
+	projectDirs []string // registration search path, personal dir first
+	dockerRoot  string   // Docker's data root, if known (see disk.go)
 
 	mu        sync.Mutex
 	instances map[string]*Instance // keyed by instance ID
+	reserved  map[string]bool      // IDs handed to starts that are still in setup
+	lastSeq   int64                // Seq of the most recently created instance
+
+	projectLocks projectLocks // serialises git operations on each main checkout
+
+	starts startRegistry // starts under way, for grove cancel-start
+
+	events eventBus // instance events for `grove events` subscribers
+
+	diskUsage diskUsageCache // recent sizes of main checkouts and worktrees
+
+	webAddr string // where the web UI listens, if it does; set before Run accepts connections
 }
 
 // New creates a Daemon that uses rootDir (~/.grove) as its data directory.
-// Project registrations are read from rootDir/projects/<name>/project.yaml.
-// Returns an error if Docker is not available.
-func New(rootDir string) (*Daemon, error) {
-	if err := validateDocker(); err != nil {
-		return nil, err
+// Project registrations are looked up in rootDir/projects and then in each of
+// projectDirs, in order; the first match wins.
+// Returns an error if Docker is installed but not running.  If git or docker
+// cannot be found at all the daemon still starts, degraded (see tools.go), so
+// that clients can be told why.
+func New(rootDir string, projectDirs []string) (*Daemon, error) {
+	missing := resolveTools()
+	if len(missing) > 0 {
+		log.Printf("warning: daemon degraded, missing %v", missing)
+	}
+	if _, err := lookupTool(toolDocker); err == nil {
+		if err := validateDocker(); err != nil {
+			return nil, err
+		}
 	}
 
 	for _, sub := range []string{
@@ -50,8 +76,15 @@ func New(rootDir string) (*Daemon, error) {
 	}
 
 	d := &Daemon{
-		rootDir:   rootDir,
-		instances: make(map[string]*Instance),
+		rootDir:     rootDir,
+		projectDirs: registry.SearchDirs(rootDir, projectDirs),
+		instances:   make(map[string]*Instance),
+	}
+	log.Printf("project search path: %v", d.projectDirs)
+	if _, err := lookupTool(toolDocker); err == nil {
+		if d.dockerRoot, err = dockerDataRoot(); err != nil {
+			log.Printf("warning: cannot find Docker's data root, its free space will not be checked: %v", err)
+		}
 	}
 
 	if err := d.loadPersistedInstances(); err != nil {
@@ -61,18 +94,27 @@ func New(rootDir string) (*Daemon, error) {
 	return d, nil
 }
 
-// Run starts the Unix socket listener and blocks until it is closed.
-func (d *Daemon) Run(socketPath string) error {
-	// Remove stale socket.
-	os.Remove(socketPath)
+// staleSocketTimeout bounds the connection attempt that tells a socket left
+// behind by a dead daemon from one a live daemon is serving.
+const staleSocketTimeout = 200 * time.Millisecond
 
-	l, err := net.Listen("unix", socketPath)
+// Run starts the Unix socket listener and blocks until it is closed.  The
+// caller should hold the root lock (see LockRoot).
+func (d *Daemon) Run(socketPath string) error {
+	l, err := listen(socketPath)
 	if err != nil {
 		return fmt.Errorf("listen on %s: %w", socketPath, err)
 	}
 	defer l.Close()
 
 	log.Printf("groved listening on %s", socketPath)
+	d.startWeb(socketPath)
+
+	go d.autoCheckLoop()
+	go d.remoteStatusLoop()
+	go d.worktreeWatchLoop()
+	go d.ttlLoop()
+	d.resumePendingFinishes()
 
 	for {
 		conn, err := l.Accept()
@@ -84,6 +126,23 @@ func (d *Daemon) Run(socketPath string) error {
 	}
 }
 
+// listen listens on socketPath, first removing a socket left behind by a
+// daemon that died without cleaning up.  A socket that still accepts
+// connections belongs to a live daemon and is left alone.
+func listen(socketPath string) (net.Listener, error) {
+	if _, err := os.Lstat(socketPath); err == nil {
+		if conn, err := net.DialTimeout("unix", socketPath, staleSocketTimeout); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("another daemon is listening")
+		}
+		log.Printf("removing stale socket %s", socketPath)
+		if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("remove stale socket: %w", err)
+		}
+	}
+	return net.Listen("unix", socketPath)
+}
+
 // ─── Connection handling ──────────────────────────────────────────────────────
 
 func (d *Daemon) handleConn(conn net.Conn) {
@@ -98,20 +157,33 @@ func (d *Daemon) handleConn(conn net.Conn) {
 	if !scanner.Scan() {
 		return
 	}
+	if debugEnabled(DebugOn) {
+		debugf("request: %s", redactJSON(scanner.Bytes()))
+	}
 	if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
 		respond(conn, proto.Response{OK: false, Error: "bad request: " + err.Error()})
 		return
 	}
 
+	if respondMissingTools(conn, requestTools[req.Type]...) {
+		return
+	}
+
 	switch req.Type {
 	case proto.ReqPing:
-		respond(conn, proto.Response{OK: true})
+		h := d.healthWithDisk()
+		h.Web = d.webAddr
+		h.Locks = d.projectLocks.snapshot()
+		respond(conn, proto.Response{OK: true, Health: h})
 
 	case proto.ReqStart:
 		d.handleStart(conn, req)
 
+	case proto.ReqCancelStart:
+		d.handleCancelStart(conn, req)
+
 	case proto.ReqList:
-		d.handleList(conn)
+		d.handleList(conn, req)
 
 	case proto.ReqAttach:
 		d.handleAttach(conn, req)
@@ -122,6 +194,9 @@ func (d *Daemon) handleConn(conn net.Conn) {
 	case proto.ReqLogsFollow:
 		d.handleLogsFollow(conn, req)
 
+	case proto.ReqLogsAll:
+		d.handleLogsAll(conn, req)
+
 	case proto.ReqStop:
 		d.handleStop(conn, req)
 
@@ -137,6 +212,66 @@ func (d *Daemon) handleConn(conn net.Conn) {
 	case proto.ReqRestart:
 		d.handleRestart(conn, req)
 
+	case proto.ReqReopen:
+		d.handleReopen(conn, req)
+
+	case proto.ReqNote:
+		d.handleNote(conn, req)
+
+	case proto.ReqLabel:
+		d.handleLabel(conn, req)
+
+	case proto.ReqCopy:
+		d.handleCopy(conn, req)
+
+	case proto.ReqStats:
+		d.handleStats(conn)
+
+	case proto.ReqGC:
+		d.handleGC(conn)
+
+	case proto.ReqEvents:
+		d.handleEvents(conn)
+
+	case proto.ReqProjects:
+		d.handleProjects(conn, req)
+
+	case proto.ReqProjectResolve:
+		d.handleProjectResolve(conn, req)
+
+	case proto.ReqShowConfig:
+		d.handleShowConfig(conn, req)
+
+	case proto.ReqProjectDelete:
+		d.handleProjectDelete(conn, req)
+
+	case proto.ReqProjectFetch:
+		d.handleProjectFetch(conn, req)
+
+	case proto.ReqProjectLock:
+		d.handleProjectLock(conn, req)
+
+	case proto.ReqProjectMove:
+		d.handleProjectMove(conn, req)
+
+	case proto.ReqWorktrees:
+		d.handleWorktrees(conn, req)
+
+	case proto.ReqUsage:
+		d.handleUsage(conn, req)
+
+	case proto.ReqTTL:
+		d.handleTTL(conn, req)
+
+	case proto.ReqPin:
+		d.handlePin(conn, req)
+
+	case proto.ReqContainerRm:
+		d.handleContainerRm(conn, req)
+
+	case proto.ReqWorktreeRm:
+		d.handleWorktreeRm(conn, req)
+
 	default:
 		respond(conn, proto.Response{OK: false, Error: "unknown request type: " + req.Type})
 	}
@@ -144,12 +279,78 @@ func (d *Daemon) handleConn(conn net.Conn) {
 
 func respond(conn net.Conn, r proto.Response) {
 	data, _ := json.Marshal(r)
+	if debugEnabled(DebugOn) {
+		debugf("response: %s", redactJSON(data))
+	}
 	data = append(data, '\n')
 	conn.Write(data)
 }
 
+// clientGone returns a channel that is closed when the client of a streaming
+// request hangs up.  Such clients send nothing after the request, so a read
+// returning means the connection is gone.  Without this, a handler that has
+// nothing to write would not notice until it next wrote.
+func clientGone(conn net.Conn) <-chan struct{} {
+	gone := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, conn)
+		close(gone)
+	}()
+	return gone
+}
+
 // ─── Helpers ──────────────────────────────────────────────────────────────────
 
+// loadProject loads the registration for name from the daemon's search path.
+func (d *Daemon) loadProject(name string) (*Project, error) {
+	return loadProject(d.rootDir, d.projectDirs, name)
+}
+
+// instanceProject returns the config to operate inst with: the one it was
+// started with (see snapshot.go), or with current the project's current
+// config (see currentProject) with the agent and container settings inst
+// was started with laid over it.  Instances without a snapshot get the
+// latter too.
+func (d *Daemon) instanceProject(inst *Instance, current bool) (*Project, error) {
+	if !current {
+		p, err := d.snapshotProject(inst)
+		if err == nil {
+			return p, nil
+		}
+		if !os.IsNotExist(err) {
+			log.Printf("warning: %v; using the current config", err)
+		}
+	}
+	p, err := d.currentProject(inst)
+	if err != nil {
+		return nil, err
+	}
+	p.applyLaunch(inst.Launch)
+	return p, nil
+}
+
+// currentProject returns the project's config as it is now, including the
+// grove.yaml in the instance's worktree.  If the registration has been
+// deleted the project is rebuilt from the instance record, so the
+// worktree's grove.yaml still applies.
+func (d *Daemon) currentProject(inst *Instance) (*Project, error) {
+	if inst.Scratch != nil {
+		return d.scratchInstanceProject(inst), nil
+	}
+	p, err := d.loadProject(inst.Project)
+	if err != nil {
+		if inst.MainDir == "" {
+			return nil, err
+		}
+		log.Printf("instance %s: %v; using the instance record", inst.ID, err)
+		p = &Project{Name: inst.Project, DataDir: filepath.Dir(inst.MainDir)}
+	}
+	if _, err := loadInRepoConfig(p, inst.Branch, inst.WorktreeDir); err != nil {
+		log.Printf("warning: could not read grove.yaml for %s: %v", inst.Project, err)
+	}
+	return p, nil
+}
+
 func (d *Daemon) getInstance(id string) *Instance {
 	d.mu.Lock()
 	defer d.mu.Unlock()
@@ -165,18 +366,45 @@ var idAlphabet = []string{
 	"n", "o", "p", "q", "r", "s", "t", "u", "v", "w", "x", "y", "z",
 }
 
+// reserveInstanceID allocates an instance ID for a start that is still
+// setting up, so a concurrent start cannot be given the same one.  The caller
+// must releaseInstanceID once the instance is registered or setup failed.
+func (d *Daemon) reserveInstanceID() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	id := d.nextInstanceID()
+	if d.reserved == nil {
+		d.reserved = make(map[string]bool)
+	}
+	d.reserved[id] = true
+	return id
+}
+
+func (d *Daemon) releaseInstanceID(id string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.reserved, id)
+}
+
+// idTaken reports whether id is in use or reserved.
+// Must be called with d.mu held.
+func (d *Daemon) idTaken(id string) bool {
+	_, taken := d.instances[id]
+	return taken || d.reserved[id]
+}
+
 // nextInstanceID returns the lowest unused instance ID.
 // Must be called with d.mu held.
 func (d *Daemon) nextInstanceID() string {
 	for _, id := range idAlphabet {
-		if _, taken := d.instances[id]; !taken {
+		if !d.idTaken(id) {
 			return id
 		}
 	}
 	for _, a := range idAlphabet {
 		for _, b := range idAlphabet {
 			id := a + b
-			if _, taken := d.instances[id]; !taken {
+			if !d.idTaken(id) {
 				return id
 			}
 		}
@@ -1,9 +1,20 @@
 package daemon
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/gandalfthegui/grove/pkg/proto"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNextInstanceID(t *testing.T) {
@@ -33,25 +44,203 @@ func TestNextInstanceID(t *testing.T) {
 	d.mu.Unlock()
 }
 
-func TestRepoURLHintSuffix(t *testing.T) {
-	cases := []struct {
-		repo string
-		hint bool
-	}{
-		{"github.com/org/repo", true},
-		{"gitlab.com/org/repo", true},
-		{"bitbucket.org/org/repo", true},
-		{"git@github.com:org/repo.git", false},
-		{"https://github.com/org/repo.git", false},
-		{"", false},
+func TestContainerLabels(t *testing.T) {
+	got := containerLabels(map[string]string{"team": "payments", "experiment": "v2"})
+	assert.Equal(t, []string{"grove.label.experiment=v2", "grove.label.team=payments"}, got)
+	assert.Empty(t, containerLabels(nil))
+}
+
+func TestOwnerLabels(t *testing.T) {
+	assert.Equal(t, []string{"grove.project=my-app", "grove.instance=3"}, ownerLabels("my-app", "3"))
+}
+
+func TestUniqueFields(t *testing.T) {
+	assert.Equal(t, []string{"abc", "def"}, uniqueFields("abc\ndef\nabc\n"))
+	assert.Empty(t, uniqueFields("\n"))
+}
+
+func TestValidateLabels(t *testing.T) {
+	assert.NoError(t, validateLabels(map[string]string{"team": "payments"}))
+	assert.Error(t, validateLabels(map[string]string{"": "x"}))
+	assert.Error(t, validateLabels(map[string]string{"a=b": "x"}))
+	assert.Error(t, validateLabels(map[string]string{"a b": "x"}))
+}
+
+func TestParseDockerStats(t *testing.T) {
+	out := []byte(`{"Name":"grove-1","CPUPerc":"12.50%","MemUsage":"512MiB / 7.6GiB","MemPerc":"6.58%","NetIO":"1.2kB / 648B"}
+not json
+{"Name":"grove-2-app-1","CPUPerc":"--","MemUsage":"0B / 0B","MemPerc":"--","NetIO":"0B / 0B"}
+`)
+	stats := parseDockerStats(out)
+	assert.Len(t, stats, 2)
+	assert.InDelta(t, 12.5, stats["grove-1"].CPUPercent, 0.001)
+	assert.InDelta(t, 6.58, stats["grove-1"].MemPercent, 0.001)
+	assert.Equal(t, "512MiB / 7.6GiB", stats["grove-1"].MemUsage)
+	assert.Equal(t, "1.2kB / 648B", stats["grove-1"].NetIO)
+	assert.Zero(t, stats["grove-2-app-1"].CPUPercent)
+}
+
+func TestStreamResult(t *testing.T) {
+	res := streamResult(time.Now(), nil)
+	assert.True(t, res.OK)
+	assert.NotNil(t, res.Commands, "commands must encode as [] not null")
+
+	res = streamResult(time.Now(), []proto.CommandResult{{Command: "a"}, {Command: "b", ExitCode: 1}})
+	assert.False(t, res.OK)
+	assert.Len(t, res.Commands, 2)
+}
+
+func TestExitCode(t *testing.T) {
+	assert.Equal(t, 0, exitCode(nil))
+	assert.Equal(t, 4, exitCode(exec.Command("sh", "-c", "exit 4").Run()))
+	assert.Equal(t, -1, exitCode(exec.Command("/nonexistent/binary").Run()))
+}
+
+func TestListenReplacesStaleSocket(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "groved.sock")
+
+	// A daemon that died hard leaves its socket file behind.
+	dead, err := net.Listen("unix", sock)
+	require.NoError(t, err)
+	dead.(*net.UnixListener).SetUnlinkOnClose(false)
+	dead.Close()
+	require.FileExists(t, sock)
+
+	l, err := listen(sock)
+	require.NoError(t, err)
+	defer l.Close()
+
+	// A live daemon's socket is left alone.
+	_, err = listen(sock)
+	assert.ErrorContains(t, err, "another daemon is listening")
+	_, err = os.Stat(sock)
+	assert.NoError(t, err)
+}
+
+func TestLogsFollowStopsWhenClientGone(t *testing.T) {
+	inst := &Instance{ID: "1", state: proto.StateRunning}
+	d := &Daemon{instances: map[string]*Instance{"1": inst}}
+
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		d.handleLogsFollow(server, proto.Request{Type: proto.ReqLogsFollow, InstanceID: "1"})
+		close(done)
+	}()
+
+	var resp proto.Response
+	require.NoError(t, json.NewDecoder(client).Decode(&resp))
+	require.True(t, resp.OK)
+	client.Close()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("follow kept running after the client hung up with no output to send")
+	}
+}
+
+func TestLogsGzip(t *testing.T) {
+	// logs requests handleLogs for a buffer of n bytes and returns the
+	// response and the bytes that follow it.
+	logs := func(n int, acceptGzip bool) (proto.Response, []byte) {
+		t.Helper()
+		inst := &Instance{ID: "1", state: proto.StateRunning, logBuf: bytes.Repeat([]byte("x"), n)}
+		d := &Daemon{instances: map[string]*Instance{"1": inst}}
+		client, server := net.Pipe()
+		go func() {
+			d.handleLogs(server, proto.Request{Type: proto.ReqLogs, InstanceID: "1", AcceptGzip: acceptGzip})
+			server.Close()
+		}()
+		dec := json.NewDecoder(client)
+		var resp proto.Response
+		require.NoError(t, dec.Decode(&resp))
+		rest, err := io.ReadAll(io.MultiReader(dec.Buffered(), client))
+		require.NoError(t, err)
+		return resp, bytes.TrimPrefix(rest, []byte("\n"))
 	}
 
-	for _, tc := range cases {
-		suffix := repoURLHintSuffix(tc.repo)
-		if tc.hint {
-			assert.NotEmpty(t, suffix, "expected hint for %q", tc.repo)
-		} else {
-			assert.Empty(t, suffix, "expected no hint for %q", tc.repo)
-		}
+	resp, body := logs(gzipLogThreshold-1, true)
+	assert.Empty(t, resp.Encoding, "below the threshold")
+	assert.Len(t, body, gzipLogThreshold-1)
+
+	resp, body = logs(gzipLogThreshold, false)
+	assert.Empty(t, resp.Encoding, "the client did not ask")
+	assert.Len(t, body, gzipLogThreshold)
+
+	resp, body = logs(gzipLogThreshold, true)
+	assert.Equal(t, proto.EncodingGzip, resp.Encoding)
+	assert.Less(t, len(body), gzipLogThreshold)
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	require.NoError(t, err)
+	plain, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, bytes.Repeat([]byte("x"), gzipLogThreshold), plain)
+}
+
+func TestLogTail(t *testing.T) {
+	buf := []byte("first\nsecond\nthird\n")
+	assert.Equal(t, "third\n", string(logTail(buf, 8)), "from the first whole line")
+	assert.Equal(t, "second\nthird\n", string(logTail(buf, 13)))
+	assert.Equal(t, string(buf), string(logTail(buf, 100)))
+	assert.Equal(t, "cdef", string(logTail([]byte("abcdef"), 4)), "no line starts in the tail")
+}
+
+func TestProjectLockRequest(t *testing.T) {
+	projects := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(projects, "api"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(projects, "api", "project.yaml"), []byte("name: api\n"), 0o644))
+	d := &Daemon{projectDirs: []string{projects}}
+
+	// request sends a ReqProjectLock and returns the response, leaving the
+	// connection open.
+	request := func() (proto.Response, net.Conn) {
+		t.Helper()
+		client, server := net.Pipe()
+		go func() {
+			d.handleProjectLock(server, proto.Request{Type: proto.ReqProjectLock, Project: "api"})
+			server.Close()
+		}()
+		var resp proto.Response
+		require.NoError(t, json.NewDecoder(client).Decode(&resp))
+		return resp, client
 	}
+
+	resp, shell := request()
+	require.True(t, resp.OK, resp.Error)
+
+	resp, other := request()
+	other.Close()
+	assert.False(t, resp.OK)
+	assert.Contains(t, resp.Error, "project api is busy: project shell since")
+	assert.NotEmpty(t, resp.Hint)
+
+	// Closing the connection releases the lock.
+	shell.Close()
+	require.Eventually(t, func() bool {
+		_, held := d.projectLocks.holder("api")
+		return !held
+	}, time.Second, 5*time.Millisecond)
+	unlock, err := d.projectLocks.lock("api", "start 1", time.Second, io.Discard)
+	require.NoError(t, err)
+	unlock()
+}
+
+func TestHandlePin(t *testing.T) {
+	d, inst := teardownTest(t, proto.StateRunning)
+
+	resp := teardownRequest(t, d.handlePin, proto.Request{Type: proto.ReqPin, InstanceID: "1", Pin: true})
+	require.True(t, resp.OK, resp.Error)
+	require.Len(t, resp.Instances, 1)
+	assert.True(t, resp.Instances[0].Pinned)
+
+	d2 := &Daemon{rootDir: d.rootDir, instances: make(map[string]*Instance)}
+	require.NoError(t, d2.loadPersistedInstances())
+	assert.True(t, d2.instances["1"].Info().Pinned, "persisted")
+
+	resp = teardownRequest(t, d.handlePin, proto.Request{Type: proto.ReqPin, InstanceID: "1"})
+	require.True(t, resp.OK, resp.Error)
+	assert.False(t, inst.Info().Pinned)
+	resp = teardownRequest(t, d.handlePin, proto.Request{Type: proto.ReqPin, InstanceID: "2", Pin: true})
+	assert.Equal(t, "instance not found: 2", resp.Error)
 }
@@ -0,0 +1,293 @@
+package daemon
+
+// datadir.go – projects whose data lives outside ~/.grove.
+//
+// A registration's data_dir puts the project's main checkout and worktrees
+// in a directory of its own, typically on an external volume with room for
+// them.  Such a volume may not be mounted, and the path is then an empty
+// mount point, or nothing at all: grove must not clone or check out there,
+// which would fill the wrong disk and leave the real data unseen.  So
+// starts and the rest of what writes to the project's data refuse with a
+// dataDirUnavailableError instead, while grove's own directory under
+// ~/.grove/projects is created as needed.
+//
+// grove project move moves a project's data to a new data_dir while none of
+// its instances is live.  Instances keep the paths of their worktree and
+// main checkout, so their records are rewritten, and their containers,
+// whose bind mounts hold the old worktree, are removed for restart
+// --recreate-container to make anew.
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gandalfthegui/grove/internal/registry"
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+// opProjectMove is the operation grove project move holds each of the
+// project's instances with.
+const opProjectMove = "project move"
+
+// dataDirUnavailableError refuses to use a project whose data_dir is not
+// an existing directory.
+type dataDirUnavailableError struct {
+	project, dir string
+}
+
+func (e *dataDirUnavailableError) Error() string {
+	return fmt.Sprintf("project %s keeps its data in %s, which is not available; is its volume mounted?", e.project, e.dir)
+}
+
+// checkDataDir returns a *dataDirUnavailableError if p has a data_dir that
+// is not an existing directory.
+func (p *Project) checkDataDir() error {
+	if p.dataDirSet && !dirExists(p.DataDir) {
+		return &dataDirUnavailableError{project: p.Name, dir: p.DataDir}
+	}
+	return nil
+}
+
+// checkInstanceDataDir is checkDataDir for the project of inst, before a
+// restart or reopen, if inst's worktree is in the project's data_dir.
+func (d *Daemon) checkInstanceDataDir(inst *Instance) error {
+	if inst.Scratch != nil {
+		return nil
+	}
+	p, err := d.loadProject(inst.Project)
+	if err != nil || !pathWithin(inst.WorktreeDir, p.DataDir) {
+		return nil
+	}
+	return p.checkDataDir()
+}
+
+// pathWithin reports whether path is dir or below it.
+func pathWithin(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// handleProjectMove moves a project's main checkout and worktrees into
+// req.DataDir and makes it the project's data_dir; moving them back to
+// ~/.grove/projects/<name> removes the data_dir.  Every instance of the
+// project must be stopped.  Progress is streamed after the response,
+// ending with a result trailer.
+func (d *Daemon) handleProjectMove(conn net.Conn, req proto.Request) {
+	e, err := registry.Resolve(d.projectDirs, req.Project)
+	if err != nil {
+		respond(conn, proto.Response{OK: false, Error: err.Error()})
+		return
+	}
+	personal := registry.PersonalDir(d.rootDir)
+	if filepath.Clean(e.Source) != filepath.Clean(personal) {
+		respond(conn, proto.Response{OK: false, Error: fmt.Sprintf(
+			"project %q is registered in shared directory %s; set its data_dir there", e.Name, e.Source)})
+		return
+	}
+	p := entryProject(d.rootDir, e)
+	if err := p.checkDataDir(); err != nil {
+		respond(conn, errorResponse(err))
+		return
+	}
+	to := filepath.Clean(req.DataDir)
+	defaultDir := filepath.Join(personal, e.Name)
+	switch {
+	case !filepath.IsAbs(req.DataDir):
+		err = fmt.Errorf("%q is not an absolute path", req.DataDir)
+	case to == p.DataDir:
+		err = fmt.Errorf("project %s already keeps its data in %s", e.Name, to)
+	case pathWithin(to, p.DataDir):
+		err = fmt.Errorf("%s is inside the project's data directory %s", to, p.DataDir)
+	case !dirExists(to):
+		err = fmt.Errorf("%s is not an existing directory", to)
+	}
+	for _, sub := range []string{"main", "worktrees"} {
+		if _, serr := os.Lstat(filepath.Join(to, sub)); err == nil && serr == nil {
+			err = fmt.Errorf("%s already has a %s/", to, sub)
+		}
+	}
+	if err != nil {
+		respond(conn, proto.Response{OK: false, Error: err.Error()})
+		return
+	}
+
+	unlock, err := d.projectLocks.lock(e.Name, "project move", projectLockTimeout, io.Discard)
+	if err != nil {
+		respond(conn, proto.Response{OK: false, Error: err.Error()})
+		return
+	}
+	defer unlock()
+	insts, endOps, err := d.claimProjectInstances(e.Name)
+	if err != nil {
+		respond(conn, errorResponse(err))
+		return
+	}
+	defer endOps()
+
+	respond(conn, proto.Response{OK: true})
+	started := time.Now()
+	w := newResilientWriter(conn, nil)
+	res := streamResult(started, nil)
+	dst := &Project{Name: p.Name, DataDir: to}
+	if err := moveProjectData(p, dst, w); err != nil {
+		fmt.Fprintf(w, "error: %v\n", err)
+		res.OK = false
+		res.Error = err.Error()
+		proto.WriteResultTrailer(conn, res)
+		return
+	}
+
+	dataDir := to
+	if to == defaultDir {
+		dataDir = ""
+	}
+	if err := registry.SetDataDir(personal, e.Name, dataDir); err != nil {
+		fmt.Fprintf(w, "error: %v\n", err)
+		res.OK = false
+		res.Error = fmt.Sprintf("moved the data to %s, but could not set data_dir in the registration: %v", to, err)
+	}
+	for _, inst := range insts {
+		d.moveInstance(inst, p, dst, w)
+	}
+
+	log.Printf("project %s: moved %s to %s in %s", e.Name, p.DataDir, to, time.Since(started).Round(time.Millisecond))
+	if res.OK {
+		fmt.Fprintf(w, "project %s now keeps its data in %s\n", e.Name, to)
+	}
+	proto.WriteResultTrailer(conn, res)
+}
+
+// claimProjectInstances claims every instance of project for a project
+// move, refusing if one is live or busy.  endOps releases them.
+func (d *Daemon) claimProjectInstances(project string) (insts []*Instance, endOps func(), err error) {
+	d.mu.Lock()
+	for _, inst := range d.instances {
+		if inst.Project == project && inst.Scratch == nil {
+			insts = append(insts, inst)
+		}
+	}
+	d.mu.Unlock()
+
+	var ends []func()
+	endOps = func() {
+		for _, end := range ends {
+			end()
+		}
+	}
+	for _, inst := range insts {
+		end, err := inst.beginOperation(opProjectMove)
+		if err != nil {
+			endOps()
+			return nil, nil, err
+		}
+		ends = append(ends, end)
+		inst.mu.Lock()
+		state := inst.state
+		inst.mu.Unlock()
+		if !proto.IsTerminal(state) {
+			endOps()
+			return nil, nil, fmt.Errorf("instance %s is %s; stop it first: grove stop %s", inst.ID, state, inst.ID)
+		}
+	}
+	return insts, endOps, nil
+}
+
+// moveProjectData moves the main checkout and worktrees of from to those
+// of to, and repairs git's links between them.  If the worktrees cannot be
+// moved, the main checkout is moved back.
+func moveProjectData(from, to *Project, w io.Writer) error {
+	var worktrees []string
+	if dirExists(from.MainDir()) {
+		if out, err := gitCommand("-C", from.MainDir(), "worktree", "list", "--porcelain").Output(); err == nil {
+			for _, wt := range parseWorktreeList(string(out)) {
+				if moved, ok := movedPath(wt.path, from.WorktreesDir(), to.WorktreesDir()); ok {
+					worktrees = append(worktrees, moved)
+				}
+			}
+		}
+		fmt.Fprintf(w, "Moving %s to %s …\n", from.MainDir(), to.MainDir())
+		if err := moveDir(from.MainDir(), to.MainDir()); err != nil {
+			return err
+		}
+	}
+	if dirExists(from.WorktreesDir()) {
+		fmt.Fprintf(w, "Moving %s to %s …\n", from.WorktreesDir(), to.WorktreesDir())
+		if err := moveDir(from.WorktreesDir(), to.WorktreesDir()); err != nil {
+			if dirExists(to.MainDir()) {
+				if berr := moveDir(to.MainDir(), from.MainDir()); berr != nil {
+					return fmt.Errorf("%w; moving the main checkout back failed too: %v", err, berr)
+				}
+			}
+			return err
+		}
+	}
+	if len(worktrees) > 0 {
+		fmt.Fprintf(w, "Repairing the links of %d worktree(s) …\n", len(worktrees))
+		if err := runGit(to.MainDir(), append([]string{"worktree", "repair"}, worktrees...)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moveInstance rewrites inst's paths after its project's data moved from
+// from to to, and removes its container, whose bind mount holds the old
+// worktree.
+func (d *Daemon) moveInstance(inst *Instance, from, to *Project, w io.Writer) {
+	containerRemoved, _ := inst.teardownRemoved()
+	if !containerRemoved {
+		fmt.Fprintf(w, "Removing the container of instance %s …\n", inst.ID)
+		inst.destroyHelpers()
+		inst.destroyAux()
+		stopContainer(inst.ContainerID, inst.ComposeProject)
+	}
+
+	inst.mu.Lock()
+	if inst.MainDir == from.MainDir() {
+		inst.MainDir = to.MainDir()
+	}
+	if moved, ok := movedPath(inst.WorktreeDir, from.WorktreesDir(), to.WorktreesDir()); ok {
+		inst.WorktreeDir = moved
+	}
+	inst.containerRemoved = true
+	inst.stackStopped = false
+	inst.notes = append(inst.notes, proto.Note{Time: time.Now().Unix(),
+		Text: "project data moved to " + to.DataDir + "; grove restart --recreate-container makes a new container"})
+	inst.mu.Unlock()
+	inst.persistMeta(filepath.Join(d.rootDir, "instances"))
+	log.Printf("instance %s: worktree now %s", inst.ID, inst.WorktreeDir)
+}
+
+// movedPath returns where path, below the directory from, is once from has
+// moved to to.  It reports false if path is not below from.
+func movedPath(path, from, to string) (string, bool) {
+	rel, err := filepath.Rel(from, path)
+	if err != nil || rel == "." || !pathWithin(path, from) {
+		return "", false
+	}
+	return filepath.Join(to, rel), true
+}
+
+// moveDir moves the directory src to dst, which must not exist: a rename
+// within a filesystem, else a copy that keeps modes, times and symlinks
+// (cp -a) and the removal of src.
+func moveDir(src, dst string) error {
+	err := os.Rename(src, dst)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	if out, err := exec.Command("cp", "-a", src, dst).CombinedOutput(); err != nil {
+		os.RemoveAll(dst)
+		return fmt.Errorf("cp -a %s %s: %v: %s", src, dst, err, strings.TrimSpace(string(out)))
+	}
+	return os.RemoveAll(src)
+}
@@ -0,0 +1,142 @@
+package daemon
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gandalfthegui/grove/internal/registry"
+	"github.com/gandalfthegui/grove/pkg/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// dataDirTest is teardownTest with project app registered in the
+// daemon's personal directory.
+func dataDirTest(t *testing.T, state string) (*Daemon, *Instance) {
+	t.Helper()
+	d, inst := teardownTest(t, state)
+	writeRepoFiles(t, filepath.Join(d.rootDir, "projects", "app"), map[string]string{"project.yaml": "name: app\nrepo: git@example.com:app.git\n"})
+	d.projectDirs = registry.SearchDirs(d.rootDir, nil)
+	return d, inst
+}
+
+// streamedRequest sends req to handle and returns its response and, if it
+// succeeded, the output and result trailer streamed after it.
+func streamedRequest(t *testing.T, handle func(net.Conn, proto.Request), req proto.Request) (proto.Response, string, *proto.StreamResult) {
+	t.Helper()
+	client, _ := serveRequest(handle, req)
+	defer client.Close()
+	r := bufio.NewReader(client)
+	line, err := r.ReadBytes('\n')
+	require.NoError(t, err)
+	var resp proto.Response
+	require.NoError(t, json.Unmarshal(line, &resp))
+	if !resp.OK {
+		return resp, "", nil
+	}
+	var out strings.Builder
+	res, _, err := proto.SplitResult(r, &out)
+	require.NoError(t, err)
+	require.NotNil(t, res)
+	return resp, out.String(), res
+}
+
+func TestDataDirUnavailable(t *testing.T) {
+	d, _ := dataDirTest(t, proto.StateExited)
+	volume := filepath.Join(t.TempDir(), "ext")
+	require.NoError(t, registry.SetDataDir(registry.PersonalDir(d.rootDir), "app", volume))
+
+	p, err := d.loadProject("app")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(volume, "main"), p.MainDir())
+	assert.Equal(t, filepath.Join(volume, "worktrees"), p.WorktreesDir())
+
+	want := "project app keeps its data in " + volume + ", which is not available; is its volume mounted?"
+	resp := teardownRequest(t, d.handleStart, proto.Request{Type: proto.ReqStart, Project: "app", Branch: "new"})
+	assert.Equal(t, want, resp.Error)
+	assert.Equal(t, proto.ErrCodeDataDirUnavailable, resp.ErrorCode)
+	assert.NoDirExists(t, volume, "nothing created under the mount point")
+
+	e, err := registry.Find(d.projectDirs, "app")
+	require.NoError(t, err)
+	assert.EqualError(t, d.ensureProjectCheckout(e), want)
+	resp = teardownRequest(t, d.handleProjectDelete, proto.Request{Type: proto.ReqProjectDelete, Project: "app"})
+	assert.Equal(t, proto.ErrCodeDataDirUnavailable, resp.ErrorCode)
+	assert.FileExists(t, registry.YAMLPath(registry.PersonalDir(d.rootDir), "app"), "the registration is kept")
+
+	require.NoError(t, os.Mkdir(volume, 0o755))
+	assert.NoError(t, p.checkDataDir(), "mounted")
+	assert.NoError(t, (&Project{Name: "app", DataDir: volume + "-gone"}).checkDataDir(), "grove's own directory is made as needed")
+}
+
+func TestProjectMove(t *testing.T) {
+	calls := recordingDocker(t)
+	d, inst := dataDirTest(t, proto.StateRunning)
+	oldData := filepath.Join(d.rootDir, "projects", "app")
+	volume := t.TempDir()
+	move := func(dir string) (proto.Response, string, *proto.StreamResult) {
+		return streamedRequest(t, d.handleProjectMove, proto.Request{Type: proto.ReqProjectMove, Project: "app", DataDir: dir})
+	}
+
+	resp, _, _ := move(volume)
+	assert.Equal(t, "instance 1 is RUNNING; stop it first: grove stop 1", resp.Error)
+	inst.state = proto.StateExited
+	resp, _, _ = move("ext")
+	assert.Equal(t, `"ext" is not an absolute path`, resp.Error)
+	resp, _, _ = move(filepath.Join(volume, "gone"))
+	assert.Equal(t, filepath.Join(volume, "gone")+" is not an existing directory", resp.Error)
+	resp, _, _ = move(filepath.Join(oldData, "worktrees"))
+	assert.Contains(t, resp.Error, "is inside the project's data directory")
+
+	resp, out, res := move(volume)
+	require.True(t, resp.OK, resp.Error)
+	require.True(t, res.OK, res.Error)
+	assert.Contains(t, out, "project app now keeps its data in "+volume)
+	assert.NoDirExists(t, filepath.Join(oldData, "main"))
+	assert.NoDirExists(t, filepath.Join(oldData, "worktrees"))
+	e, err := registry.Find(d.projectDirs, "app")
+	require.NoError(t, err)
+	assert.Equal(t, volume, e.DataDir)
+
+	// The instance follows, and git still links checkout and worktree.
+	main, worktree := filepath.Join(volume, "main"), filepath.Join(volume, "worktrees", "feat-1")
+	assert.Equal(t, main, inst.MainDir)
+	assert.Equal(t, worktree, inst.WorktreeDir)
+	assert.Equal(t, "feat", git(t, "-C", worktree, "branch", "--show-current"))
+	assert.Equal(t, worktree, worktreeWithBranch(main, "feat"))
+	info := inst.Info()
+	assert.True(t, info.ContainerRemoved)
+	require.NotEmpty(t, info.Notes)
+	assert.Contains(t, info.Notes[len(info.Notes)-1].Text, "project data moved to "+volume)
+	data, err := os.ReadFile(calls)
+	require.NoError(t, err)
+	assert.Equal(t, "stop c1\nrm -v c1\n", string(data))
+
+	resp, _, _ = move(volume)
+	assert.Equal(t, "project app already keeps its data in "+volume, resp.Error)
+
+	// Back to ~/.grove/projects/app: data_dir goes.
+	resp, _, res = move(oldData)
+	require.True(t, resp.OK, resp.Error)
+	require.True(t, res.OK, res.Error)
+	e, err = registry.Find(d.projectDirs, "app")
+	require.NoError(t, err)
+	assert.Empty(t, e.DataDir)
+	assert.Equal(t, filepath.Join(oldData, "worktrees", "feat-1"), inst.WorktreeDir)
+	assert.Equal(t, "feat", git(t, "-C", inst.WorktreeDir, "branch", "--show-current"))
+}
+
+func TestMovedPath(t *testing.T) {
+	moved, ok := movedPath("/a/worktrees/feat-1", "/a/worktrees", "/b/worktrees")
+	assert.True(t, ok)
+	assert.Equal(t, "/b/worktrees/feat-1", moved)
+	_, ok = movedPath("/a/worktrees", "/a/worktrees", "/b/worktrees")
+	assert.False(t, ok)
+	_, ok = movedPath("/a/worktrees-old/x", "/a/worktrees", "/b/worktrees")
+	assert.False(t, ok)
+}
@@ -0,0 +1,177 @@
+package daemon
+
+// debug.go – verbose logging for debugging the daemon.
+//
+// groved --debug logs every request and response, with secrets redacted;
+// --debug=trace also logs each attach frame's type and size.  SIGUSR1
+// switches the verbose logging off and on again without a restart.
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+// DebugLevel is how much the daemon logs beyond its normal messages.
+type DebugLevel int32
+
+const (
+	DebugOff   DebugLevel = iota
+	DebugOn               // requests and responses
+	DebugTrace            // and attach frames
+)
+
+// ParseDebugLevel parses the value of groved's --debug flag.
+func ParseDebugLevel(s string) (DebugLevel, error) {
+	switch s {
+	case "", "false", "off":
+		return DebugOff, nil
+	case "true", "on", "debug":
+		return DebugOn, nil
+	case "trace":
+		return DebugTrace, nil
+	}
+	return DebugOff, fmt.Errorf("unknown debug level %q (want: debug or trace)", s)
+}
+
+func (l DebugLevel) String() string {
+	switch l {
+	case DebugOn:
+		return "debug"
+	case DebugTrace:
+		return "trace"
+	}
+	return "off"
+}
+
+var (
+	debugLevel atomic.Int32
+
+	// debugToggleMu guards debugToggled, the level ToggleDebug restores.
+	debugToggleMu sync.Mutex
+	debugToggled  = DebugOn
+)
+
+// SetDebugLevel sets how much the daemon logs.
+func SetDebugLevel(l DebugLevel) {
+	debugLevel.Store(int32(l))
+}
+
+// ToggleDebug switches verbose logging off if it is on, and back to the
+// level it had (debug if it never was on) if it is off.  It returns the new
+// level.
+func ToggleDebug() DebugLevel {
+	debugToggleMu.Lock()
+	defer debugToggleMu.Unlock()
+	l := DebugLevel(debugLevel.Load())
+	if l > DebugOff {
+		debugToggled = l
+		l = DebugOff
+	} else {
+		l = debugToggled
+	}
+	SetDebugLevel(l)
+	return l
+}
+
+func debugEnabled(l DebugLevel) bool {
+	return DebugLevel(debugLevel.Load()) >= l
+}
+
+// debugf logs at DebugOn.
+func debugf(format string, args ...any) {
+	if debugEnabled(DebugOn) {
+		log.Printf("debug: "+format, args...)
+	}
+}
+
+// traceFrame logs an attach frame at DebugTrace.  dir is "in" for frames
+// from the client and "out" for frames to it.
+func traceFrame(instanceID, dir string, frameType byte, size int) {
+	if debugEnabled(DebugTrace) {
+		log.Printf("trace: instance %s: attach %s: %s frame, %d bytes", instanceID, dir, frameName(frameType), size)
+	}
+}
+
+// frameName names an attach frame type for the trace log.
+func frameName(t byte) string {
+	switch t {
+	case proto.AttachFrameData:
+		return "data"
+	case proto.AttachFrameResize:
+		return "resize"
+	case proto.AttachFrameDetach:
+		return "detach"
+	case proto.AttachFrameState:
+		return "state"
+	case proto.AttachFrameExit:
+		return "exit"
+	case proto.AttachFramePing:
+		return "ping"
+	}
+	return fmt.Sprintf("0x%02x", t)
+}
+
+// redacted replaces secret values in logged requests and responses.
+const redacted = "[redacted]"
+
+// secretKeyWords mark JSON keys whose string values are secrets.
+var secretKeyWords = []string{"token", "secret", "password", "passwd", "credential", "api_key", "apikey", "auth"}
+
+// redactJSON returns the JSON document data with secrets replaced, for the
+// debug log: every value under agent_env (the client's credentials), and
+// any string under a key that names a secret.  Data that is not JSON is
+// summarised instead of logged.
+func redactJSON(data []byte) string {
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return fmt.Sprintf("(%d bytes, not JSON)", len(data))
+	}
+	out, _ := json.Marshal(redactValue(v, false))
+	return string(out)
+}
+
+func redactValue(v any, secret bool) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, e := range v {
+			v[k] = redactValue(e, secret || k == "agent_env" || isSecretKey(k))
+		}
+		return v
+	case []any:
+		for i, e := range v {
+			v[i] = redactValue(e, secret)
+		}
+		return v
+	case string:
+		if secret && v != "" {
+			return redacted
+		}
+	}
+	return v
+}
+
+// redactEnvVar returns the KEY=value pair kv with its value redacted if
+// KEY names a secret.
+func redactEnvVar(kv string) string {
+	k, v, ok := strings.Cut(kv, "=")
+	if !ok || v == "" || !isSecretKey(k) {
+		return kv
+	}
+	return k + "=" + redacted
+}
+
+func isSecretKey(k string) bool {
+	k = strings.ToLower(k)
+	for _, w := range secretKeyWords {
+		if strings.Contains(k, w) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package daemon
+
+import (
+	"bytes"
+	"log"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// captureLog collects the daemon's log output at debug level l for the
+// rest of the test.
+func captureLog(t *testing.T, l DebugLevel) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	SetDebugLevel(l)
+	t.Cleanup(func() {
+		log.SetOutput(os.Stderr)
+		SetDebugLevel(DebugOff)
+	})
+	return &buf
+}
+
+func TestParseDebugLevel(t *testing.T) {
+	for in, want := range map[string]DebugLevel{"true": DebugOn, "debug": DebugOn, "trace": DebugTrace, "false": DebugOff} {
+		got, err := ParseDebugLevel(in)
+		require.NoError(t, err, in)
+		assert.Equal(t, want, got, in)
+	}
+	_, err := ParseDebugLevel("loud")
+	assert.Error(t, err)
+}
+
+func TestToggleDebug(t *testing.T) {
+	t.Cleanup(func() { SetDebugLevel(DebugOff) })
+	SetDebugLevel(DebugTrace)
+	assert.Equal(t, DebugOff, ToggleDebug())
+	assert.Equal(t, DebugTrace, ToggleDebug(), "back to the level it had")
+	assert.True(t, debugEnabled(DebugTrace))
+}
+
+func TestRedactJSON(t *testing.T) {
+	in := `{"type":"start","agent_env":{"CLAUDE_CODE_OAUTH_TOKEN":"sk-1","HOME":"/root"},` +
+		`"labels":{"github_token":"ghp_x","team":"core"},"text":"hello","cols":80}`
+	out := redactJSON([]byte(in))
+	assert.NotContains(t, out, "sk-1")
+	assert.NotContains(t, out, "/root", "every agent_env value is a secret")
+	assert.NotContains(t, out, "ghp_x")
+	assert.Contains(t, out, `"team":"core"`)
+	assert.Contains(t, out, `"text":"hello"`)
+	assert.Contains(t, out, `"cols":80`)
+	assert.Equal(t, "(5 bytes, not JSON)", redactJSON([]byte("hello")))
+}
+
+func TestDebugLogsRequests(t *testing.T) {
+	logs := captureLog(t, DebugOn)
+	d := &Daemon{rootDir: t.TempDir(), instances: map[string]*Instance{}}
+	server, client := net.Pipe()
+	go d.handleConn(server)
+	data := `{"type":"list","agent_env":{"TOKEN":"secret-value"}}` + "\n"
+	_, err := client.Write([]byte(data))
+	require.NoError(t, err)
+	var resp bytes.Buffer
+	resp.ReadFrom(client)
+
+	assert.Contains(t, logs.String(), `debug: request: {"agent_env":{"TOKEN":"[redacted]"},"type":"list"}`)
+	assert.Contains(t, logs.String(), `debug: response: {"ok":true}`)
+	assert.NotContains(t, logs.String(), "secret-value")
+}
+
+func TestTraceFrame(t *testing.T) {
+	logs := captureLog(t, DebugOn)
+	traceFrame("1", "in", proto.AttachFrameResize, 4)
+	assert.Empty(t, logs.String(), "frames are logged at trace level only")
+
+	SetDebugLevel(DebugTrace)
+	traceFrame("1", "in", proto.AttachFrameResize, 4)
+	traceFrame("1", "out", 0x7f, 0)
+	assert.Contains(t, logs.String(), "trace: instance 1: attach in: resize frame, 4 bytes")
+	assert.Contains(t, logs.String(), "attach out: 0x7f frame, 0 bytes")
+}
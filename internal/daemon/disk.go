@@ -0,0 +1,134 @@
+package daemon
+
+// disk.go – free disk space.
+//
+// A clone or an npm install that fills the disk halfway through leaves a
+// half-built worktree behind.  So before the expensive stages handleStart
+// checks the filesystems that a start fills: the one holding the data root
+// (worktrees and logs) and the one holding Docker's data root (images and
+// containers).  Below the warning threshold it says so in the setup output;
+// below the minimum it refuses with ErrCodeInsufficientDisk.  Both
+// thresholds are groved flags; grove doctor shows the same numbers.
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+// Default disk thresholds: starts warn below 10 GiB free and are refused
+// below 2 GiB.
+const (
+	DefaultDiskWarn = 10 << 30
+	DefaultDiskMin  = 2 << 30
+)
+
+var diskWarn, diskMin atomic.Uint64
+
+func init() {
+	SetDiskThresholds(DefaultDiskWarn, DefaultDiskMin)
+}
+
+// SetDiskThresholds sets the free space below which starts warn and below
+// which they are refused, in bytes.  0 disables either check.
+func SetDiskThresholds(warn, min uint64) {
+	diskWarn.Store(warn)
+	diskMin.Store(min)
+}
+
+// statDisk reports the space available to the daemon, and the total size,
+// of the filesystem holding path.
+var statDisk = func(path string) (free, total uint64, err error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), uint64(st.Blocks) * uint64(st.Bsize), nil
+}
+
+// dockerDataRoot asks Docker where it keeps images and containers.
+func dockerDataRoot() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	out, err := dockerCommandContext(ctx, "info", "--format", "{{.DockerRootDir}}").Output()
+	if err != nil {
+		return "", fmt.Errorf("docker info: %w", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// diskSpace measures the filesystems a start fills.  Docker's data root is
+// left out if it is not known; if it cannot be measured from here (Docker
+// Desktop keeps it inside a VM) its entry says why.
+func (d *Daemon) diskSpace() []proto.DiskSpace {
+	spaces := []proto.DiskSpace{{Name: "grove", Path: d.rootDir}}
+	if d.dockerRoot != "" {
+		spaces = append(spaces, proto.DiskSpace{Name: "docker", Path: d.dockerRoot})
+	}
+	for i := range spaces {
+		s := &spaces[i]
+		free, total, err := statDisk(s.Path)
+		if err != nil {
+			s.Error = err.Error()
+			continue
+		}
+		s.Free, s.Total = free, total
+	}
+	return spaces
+}
+
+// healthWithDisk is health with the free disk space and thresholds.
+func (d *Daemon) healthWithDisk() *proto.DaemonHealth {
+	h := health()
+	h.Disk = d.diskSpace()
+	h.DiskWarn, h.DiskMin = diskWarn.Load(), diskMin.Load()
+	return h
+}
+
+// insufficientDiskError refuses a start for lack of disk space.
+type insufficientDiskError struct {
+	spaces []proto.DiskSpace // all measured, for the response
+	low    []proto.DiskSpace // those below min
+	min    uint64
+}
+
+func (e *insufficientDiskError) Error() string {
+	var where []string
+	for _, s := range e.low {
+		where = append(where, fmt.Sprintf("%s free on %s (%s)", proto.FormatBytes(s.Free), s.Path, s.Name))
+	}
+	return fmt.Sprintf("not enough disk space to start: %s, below the minimum of %s; "+
+		"free some with `grove prune` or `docker system prune`",
+		strings.Join(where, ", "), proto.FormatBytes(e.min))
+}
+
+// checkDisk refuses with an *insufficientDiskError if a filesystem a start
+// fills is below the minimum of free space, and warns on w if one is below
+// the warning threshold.
+func (d *Daemon) checkDisk(w io.Writer) error {
+	warn, min := diskWarn.Load(), diskMin.Load()
+	if warn == 0 && min == 0 {
+		return nil
+	}
+	spaces := d.diskSpace()
+	var low []proto.DiskSpace
+	for _, s := range spaces {
+		switch {
+		case s.Low(min):
+			low = append(low, s)
+		case s.Low(warn):
+			fmt.Fprintf(w, "warning: only %s free on %s (%s); free some with `grove prune` or `docker system prune`\n",
+				proto.FormatBytes(s.Free), s.Path, s.Name)
+		}
+	}
+	if len(low) > 0 {
+		return &insufficientDiskError{spaces: spaces, low: low, min: min}
+	}
+	return nil
+}
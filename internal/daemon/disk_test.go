@@ -0,0 +1,78 @@
+package daemon
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubDisk makes statDisk report free bytes for each path in free, and an
+// error for any other, and sets the thresholds to warn and min.
+func stubDisk(t *testing.T, free map[string]uint64, warn, min uint64) {
+	orig := statDisk
+	statDisk = func(path string) (uint64, uint64, error) {
+		if n, ok := free[path]; ok {
+			return n, 100 << 30, nil
+		}
+		return 0, 0, errors.New("no such file or directory")
+	}
+	SetDiskThresholds(warn, min)
+	t.Cleanup(func() {
+		statDisk = orig
+		SetDiskThresholds(DefaultDiskWarn, DefaultDiskMin)
+	})
+}
+
+func TestCheckDisk(t *testing.T) {
+	d := &Daemon{rootDir: "/grove", dockerRoot: "/var/lib/docker"}
+
+	stubDisk(t, map[string]uint64{"/grove": 50 << 30, "/var/lib/docker": 50 << 30}, 10<<30, 2<<30)
+	var out strings.Builder
+	require.NoError(t, d.checkDisk(&out))
+	assert.Empty(t, out.String())
+
+	stubDisk(t, map[string]uint64{"/grove": 50 << 30, "/var/lib/docker": 5 << 30}, 10<<30, 2<<30)
+	out.Reset()
+	require.NoError(t, d.checkDisk(&out))
+	assert.Equal(t, "warning: only 5.0 GiB free on /var/lib/docker (docker); free some with `grove prune` or `docker system prune`\n", out.String())
+
+	stubDisk(t, map[string]uint64{"/grove": 1 << 30, "/var/lib/docker": 5 << 30}, 10<<30, 2<<30)
+	out.Reset()
+	err := d.checkDisk(&out)
+	assert.EqualError(t, err, "not enough disk space to start: 1.0 GiB free on /grove (grove), below the minimum of 2.0 GiB; "+
+		"free some with `grove prune` or `docker system prune`")
+	assert.Contains(t, out.String(), "/var/lib/docker", "the other filesystem is still warned about")
+
+	resp := errorResponse(err)
+	assert.Equal(t, proto.ErrCodeInsufficientDisk, resp.ErrorCode)
+	require.NotNil(t, resp.Health)
+	assert.Len(t, resp.Health.Disk, 2)
+	assert.Equal(t, uint64(2<<30), resp.Health.DiskMin)
+
+	stubDisk(t, map[string]uint64{"/grove": 1 << 30}, 0, 0)
+	assert.NoError(t, d.checkDisk(&out), "thresholds of 0 disable the check")
+}
+
+func TestDiskSpaceUnknown(t *testing.T) {
+	stubDisk(t, map[string]uint64{"/grove": 1 << 30}, 10<<30, 2<<30)
+
+	d := &Daemon{rootDir: "/grove"}
+	assert.Equal(t, []proto.DiskSpace{{Name: "grove", Path: "/grove", Free: 1 << 30, Total: 100 << 30}}, d.diskSpace(),
+		"Docker's data root is left out when unknown")
+
+	// Docker Desktop keeps its data root inside a VM.
+	d.dockerRoot = "/var/lib/docker"
+	spaces := d.diskSpace()
+	require.Len(t, spaces, 2)
+	assert.Equal(t, "no such file or directory", spaces[1].Error)
+	var out strings.Builder
+	assert.Error(t, d.checkDisk(&out), "grove's own filesystem is still checked")
+
+	h := d.healthWithDisk()
+	assert.Equal(t, uint64(10<<30), h.DiskWarn)
+	assert.Len(t, h.Disk, 2)
+}
@@ -0,0 +1,331 @@
+package daemon
+
+// dryrun.go – grove start --dry-run: the checks a start would fail on,
+// made without creating anything, so that a long start is not found to be
+// doomed ten minutes in.  Each check looks at what one stage of handleStart
+// needs, with the code that stage (or grove validate) uses: the grove.yaml
+// in the main checkout, the branch, the repo, the image, the agent's
+// credentials and the free disk space.  No worktree, container or branch is
+// created and nothing is pulled; the main checkout is read, not pulled.
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gandalfthegui/grove/internal/envfile"
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+// dryRunTimeout bounds each network check of a dry run: git ls-remote and
+// docker manifest inspect.
+const dryRunTimeout = 30 * time.Second
+
+// dryRunCheck is the outcome of one check of a dry run.
+type dryRunCheck struct {
+	name    string
+	ok      bool
+	skipped bool     // nothing to check, or nothing that can be checked yet
+	detail  string   // what was found, or what is wrong
+	more    []string // further lines: errors, warnings, a hint
+}
+
+// String formats c as a line of the report, with its further lines
+// indented below.
+func (c dryRunCheck) String() string {
+	mark := "✓"
+	switch {
+	case c.skipped:
+		mark = "-"
+	case !c.ok:
+		mark = "✗"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %-11s  %s\n", mark, c.name, c.detail)
+	for _, m := range c.more {
+		fmt.Fprintf(&b, "    %s\n", m)
+	}
+	return b.String()
+}
+
+// dryRunStart runs the checks of grove start --dry-run for req on p, a
+// registered project, streaming a line for each as it is done.
+func (d *Daemon) dryRunStart(conn net.Conn, p *Project, req proto.Request) {
+	respond(conn, proto.Response{OK: true})
+	started := time.Now()
+	w := newResilientWriter(conn, nil)
+
+	// The config comes first: it says which image and agent the rest
+	// check.
+	checks := []func() dryRunCheck{
+		func() dryRunCheck { return dryRunConfig(p, req) },
+		func() dryRunCheck { return d.dryRunBranch(p, req) },
+		func() dryRunCheck { return dryRunRepo(p) },
+		func() dryRunCheck { return dryRunImage(p) },
+		func() dryRunCheck { return d.dryRunCredentials(p, req) },
+		d.dryRunDisk,
+	}
+	failed := 0
+	for _, check := range checks {
+		c := check()
+		if !c.ok && !c.skipped {
+			failed++
+		}
+		fmt.Fprint(w, c)
+	}
+
+	res := streamResult(started, nil)
+	if failed > 0 {
+		res.OK = false
+		res.Error = fmt.Sprintf("%d of %d checks failed", failed, len(checks))
+	} else {
+		fmt.Fprintln(w, "\nno problems found; nothing was created")
+	}
+	log.Printf("start --dry-run: project=%s branch=%s failed=%d", req.Project, req.Branch, failed)
+	proto.WriteResultTrailer(conn, res)
+}
+
+// dryRunConfig checks that the main checkout's grove.yaml validates and
+// loads, overlaying it onto p as a start does, and reports its warnings.
+func dryRunConfig(p *Project, req proto.Request) dryRunCheck {
+	c := dryRunCheck{name: "config"}
+	mainDir := p.MainDir()
+	if !dirExists(mainDir) {
+		c.skipped = true
+		c.detail = "the main checkout is not cloned yet; the start clones it first"
+		return c
+	}
+	path := findInRepoConfig(mainDir)
+	if path == "" {
+		c.detail = "no grove.yaml in the main checkout, " + mainDir
+		c.more = []string{"hint: grove start offers to create one"}
+		return c
+	}
+	rel, _ := filepath.Rel(mainDir, path)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		c.detail = err.Error()
+		return c
+	}
+	if r := ValidateConfig(data); len(r.Errors) > 0 {
+		c.detail = rel + " does not validate"
+		c.more = r.Errors
+		return c
+	}
+	if _, err := loadInRepoConfig(p, req.Branch, ""); err != nil {
+		c.detail = rel + " does not load: " + err.Error()
+		return c
+	}
+	if req.Agent != "" {
+		p.overrideAgent(req.Agent)
+	}
+	if req.Mode == proto.ModeAuto && !p.allowsAuto() {
+		c.detail = errAutoForbidden(req.Project).Error()
+		return c
+	}
+	c.ok = true
+	c.detail = rel + " loads"
+	for _, w := range configWarnings(p) {
+		c.more = append(c.more, "warning: "+w)
+	}
+	return c
+}
+
+// dryRunBranch checks that the branch name is valid and that no instance
+// or other worktree has the branch checked out, which would stop git
+// worktree add, and that a --base exists.
+func (d *Daemon) dryRunBranch(p *Project, req proto.Request) dryRunCheck {
+	c := dryRunCheck{name: "branch"}
+	if gitCommand("check-ref-format", "--branch", req.Branch).Run() != nil {
+		c.detail = fmt.Sprintf("%q is not a valid branch name", req.Branch)
+		return c
+	}
+	d.mu.Lock()
+	instances := make([]*Instance, 0, len(d.instances))
+	for _, inst := range d.instances {
+		instances = append(instances, inst)
+	}
+	d.mu.Unlock()
+	for _, inst := range instances {
+		if info := inst.Info(); info.Project == req.Project && info.Branch == req.Branch && !info.WorktreeRemoved {
+			c.detail = fmt.Sprintf("%s is checked out by instance %s (%s)", req.Branch, info.ID, info.State)
+			c.more = []string{fmt.Sprintf("hint: grove attach %s, or grove drop %s to start afresh", info.ID, info.ID)}
+			return c
+		}
+	}
+
+	mainDir := p.MainDir()
+	if !dirExists(mainDir) {
+		c.ok = true
+		c.detail = "a valid name; the main checkout is not cloned yet"
+		return c
+	}
+	if dir := worktreeWithBranch(mainDir, req.Branch); dir != "" {
+		c.detail = fmt.Sprintf("%s is checked out in %s", req.Branch, dir)
+		return c
+	}
+	exists := gitCommand("-C", mainDir, "rev-parse", "--verify", "--quiet", "refs/heads/"+req.Branch).Run() == nil
+	switch {
+	case exists:
+		c.detail = "existing branch " + req.Branch + ", checked out as it is"
+	case req.Base != "":
+		base := resolveBase(mainDir, req.Base)
+		if gitCommand("-C", mainDir, "rev-parse", "--verify", "--quiet", base+"^{commit}").Run() != nil {
+			c.detail = fmt.Sprintf("base %s not found in the main checkout", req.Base)
+			return c
+		}
+		c.detail = fmt.Sprintf("new branch %s from %s", req.Branch, base)
+	default:
+		c.detail = fmt.Sprintf("new branch %s from the main checkout's HEAD", req.Branch)
+	}
+	c.ok = true
+	return c
+}
+
+// worktreeWithBranch returns the path of the worktree of the checkout in
+// mainDir, the checkout itself included, that has branch checked out, or ""
+// if none has.
+func worktreeWithBranch(mainDir, branch string) string {
+	out, err := gitCommand("-C", mainDir, "worktree", "list", "--porcelain").Output()
+	if err != nil {
+		return ""
+	}
+	var dir string
+	for _, line := range strings.Split(string(out), "\n") {
+		if path, ok := strings.CutPrefix(line, "worktree "); ok {
+			dir = path
+		}
+		if line == "branch refs/heads/"+branch {
+			return dir
+		}
+	}
+	return ""
+}
+
+// dryRunRepo checks that the project's repo answers git ls-remote, with
+// the hint a failed clone or pull would get.
+func dryRunRepo(p *Project) dryRunCheck {
+	c := dryRunCheck{name: "repo"}
+	ctx, cancel := context.WithTimeout(context.Background(), dryRunTimeout)
+	defer cancel()
+	cmd := gitCommandContext(ctx, "ls-remote", "--heads", p.Repo)
+	// Never block on a credential prompt; the daemon has no terminal.
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	var out bytes.Buffer
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		c.detail = fmt.Sprintf("git ls-remote %s: %v", p.Repo, err)
+		if ctx.Err() != nil {
+			c.detail = fmt.Sprintf("git ls-remote %s: no answer within %s", p.Repo, dryRunTimeout)
+		}
+		if _, hint := classifyGitFailure(p.Name, p.Repo, out.String()); hint != "" {
+			c.more = []string{"hint: " + hint}
+		} else if last := lastLine(out.String()); last != "" {
+			c.more = []string{last}
+		}
+		return c
+	}
+	c.ok = true
+	c.detail = p.Repo + " answers"
+	return c
+}
+
+// dryRunImage checks that the container image is present locally, for the
+// configured platform, or else in its registry.
+func dryRunImage(p *Project) dryRunCheck {
+	c := dryRunCheck{name: "image"}
+	image := p.Container.Image
+	switch {
+	case p.Container.Compose != "":
+		c.skipped = true
+		c.detail = "a compose project; docker compose pulls its images"
+		return c
+	case image == "":
+		c.detail = "no container.image in grove.yaml"
+		return c
+	}
+	if out, err := dockerCommand("image", "inspect", "--format", platformFormat, image).Output(); err == nil {
+		platform := p.Container.Platform
+		if platform == "" || sameArch(strings.TrimSpace(string(out)), platform) {
+			c.ok = true
+			c.detail = image + " is present"
+			return c
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dryRunTimeout)
+	defer cancel()
+	out, err := dockerCommandContext(ctx, "manifest", "inspect", image).CombinedOutput()
+	if err != nil {
+		c.detail = fmt.Sprintf("%s is neither present nor in its registry", image)
+		if last := lastLine(string(out)); last != "" {
+			c.more = []string{last}
+		}
+		return c
+	}
+	c.ok = true
+	c.detail = image + " is in its registry; the start pulls it"
+	return c
+}
+
+// dryRunCredentials checks that a claude agent will have credentials: from
+// ~/.grove/env, the project's agent env, or the client's shell.
+func (d *Daemon) dryRunCredentials(p *Project, req proto.Request) dryRunCheck {
+	c := dryRunCheck{name: "credentials"}
+	agent := p.Agent.Command
+	if agent == "" {
+		agent = "sh"
+	}
+	if agent != "claude" {
+		c.skipped = true
+		c.detail = "agent " + agent + " needs none from grove"
+		return c
+	}
+	env := envfile.Load(filepath.Join(d.rootDir, "env"))
+	for _, vars := range []map[string]string{p.agentEnv(), req.AgentEnv} {
+		for k, v := range vars {
+			env[k] = v
+		}
+	}
+	found := claudeCredentials(env)
+	if len(found) == 0 {
+		c.detail = "no CLAUDE_CODE_OAUTH_TOKEN or ANTHROPIC_API_KEY; claude would show its login screen"
+		c.more = []string{"hint: grove token saves one to ~/.grove/env"}
+		return c
+	}
+	c.ok = true
+	c.detail = strings.Join(found, ", ")
+	return c
+}
+
+// dryRunDisk checks the free disk space as a start does first.
+func (d *Daemon) dryRunDisk() dryRunCheck {
+	c := dryRunCheck{name: "disk"}
+	if diskWarn.Load() == 0 && diskMin.Load() == 0 {
+		c.skipped = true
+		c.detail = "the daemon's free space checks are off"
+		return c
+	}
+	var warnings bytes.Buffer
+	if err := d.checkDisk(&warnings); err != nil {
+		c.detail = err.Error()
+		return c
+	}
+	c.ok = true
+	c.detail = "enough free space"
+	if s := strings.TrimSpace(warnings.String()); s != "" {
+		c.more = strings.Split(s, "\n")
+	}
+	return c
+}
+
+// lastLine returns the last non-empty line of s, trimmed.
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
@@ -0,0 +1,102 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDryRunConfig(t *testing.T) {
+	_, inst := teardownTest(t, proto.StateRunning)
+	p := &Project{Name: "app", mainDir: inst.MainDir}
+	req := proto.Request{Project: "app", Branch: "new"}
+
+	c := dryRunConfig(p, req)
+	assert.False(t, c.ok)
+	assert.Equal(t, "no grove.yaml in the main checkout, "+inst.MainDir, c.detail)
+
+	path := filepath.Join(inst.MainDir, "grove.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("container:\n  image: alpine\nagnet: {}\n"), 0o644))
+	c = dryRunConfig(p, req)
+	assert.False(t, c.ok)
+	assert.Equal(t, "grove.yaml does not validate", c.detail)
+	assert.NotEmpty(t, c.more)
+
+	require.NoError(t, os.WriteFile(path, []byte("container:\n  image: alpine\nagent:\n  command: claude\n"), 0o644))
+	c = dryRunConfig(p, proto.Request{Project: "app", Branch: "new", Agent: "aider", Mode: proto.ModeAuto})
+	assert.True(t, c.ok, c.detail)
+	assert.Equal(t, "grove.yaml loads", c.detail)
+	assert.Equal(t, "alpine", p.Container.Image, "overlaid for the checks after it")
+	assert.Equal(t, "aider", p.Agent.Command)
+
+	assert.True(t, dryRunConfig(&Project{Name: "app", mainDir: filepath.Join(t.TempDir(), "main")}, req).skipped,
+		"not cloned yet")
+}
+
+func TestDryRunBranch(t *testing.T) {
+	d, inst := teardownTest(t, proto.StateRunning)
+	p := &Project{Name: "app", mainDir: inst.MainDir}
+	branch := func(name, base string) dryRunCheck {
+		return d.dryRunBranch(p, proto.Request{Project: "app", Branch: name, Base: base})
+	}
+
+	assert.Equal(t, `"bad..name" is not a valid branch name`, branch("bad..name", "").detail)
+	c := branch("feat", "")
+	assert.False(t, c.ok)
+	assert.Equal(t, "feat is checked out by instance 1 (RUNNING)", c.detail)
+	c = branch("main", "")
+	assert.False(t, c.ok)
+	assert.Equal(t, "main is checked out in "+inst.MainDir, c.detail)
+
+	c = branch("new", "")
+	assert.True(t, c.ok)
+	assert.Equal(t, "new branch new from the main checkout's HEAD", c.detail)
+	c = branch("new", "nope")
+	assert.False(t, c.ok)
+	assert.Equal(t, "base nope not found in the main checkout", c.detail)
+	assert.True(t, branch("new", "main").ok)
+
+	git(t, "-C", inst.MainDir, "branch", "old")
+	assert.Equal(t, "existing branch old, checked out as it is", branch("old", "main").detail)
+}
+
+func TestDryRunRepo(t *testing.T) {
+	_, inst := teardownTest(t, proto.StateRunning)
+	c := dryRunRepo(&Project{Name: "app", Repo: inst.MainDir})
+	assert.True(t, c.ok, c.detail)
+
+	c = dryRunRepo(&Project{Name: "app", Repo: filepath.Join(t.TempDir(), "gone")})
+	assert.False(t, c.ok)
+	assert.Contains(t, c.detail, "git ls-remote")
+	assert.NotEmpty(t, c.more)
+}
+
+func TestDryRunCredentials(t *testing.T) {
+	d, _ := teardownTest(t, proto.StateRunning)
+	p := &Project{Name: "app"}
+	assert.True(t, d.dryRunCredentials(p, proto.Request{}).skipped, "sh needs none")
+
+	p.Agent.Command = "claude"
+	c := d.dryRunCredentials(p, proto.Request{})
+	assert.False(t, c.ok)
+	assert.Equal(t, []string{"hint: grove token saves one to ~/.grove/env"}, c.more)
+
+	c = d.dryRunCredentials(p, proto.Request{AgentEnv: map[string]string{"ANTHROPIC_API_KEY": "k"}})
+	assert.True(t, c.ok)
+	assert.Equal(t, "ANTHROPIC_API_KEY", c.detail)
+
+	require.NoError(t, os.WriteFile(filepath.Join(d.rootDir, "env"), []byte("CLAUDE_CODE_OAUTH_TOKEN=t\n"), 0o600))
+	assert.True(t, d.dryRunCredentials(p, proto.Request{}).ok, "from ~/.grove/env")
+}
+
+func TestDryRunCheckString(t *testing.T) {
+	assert.Equal(t, "✓ repo         git@example.com:a.git answers\n",
+		dryRunCheck{name: "repo", ok: true, detail: "git@example.com:a.git answers"}.String())
+	assert.Equal(t, "✗ credentials  none\n    hint: h\n",
+		dryRunCheck{name: "credentials", detail: "none", more: []string{"hint: h"}}.String())
+	assert.Equal(t, "- disk         off\n", dryRunCheck{name: "disk", skipped: true, detail: "off"}.String())
+}
@@ -0,0 +1,73 @@
+package daemon
+
+import (
+	"encoding/json"
+	"net"
+	"sync"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+// eventBufferSize is how many undelivered events a subscriber may fall
+// behind by before further events to it are dropped.
+const eventBufferSize = 64
+
+// eventBus fans instance events out to every connected `grove events`
+// client.  The zero value is ready to use.
+type eventBus struct {
+	mu   sync.Mutex
+	subs map[chan proto.Event]struct{}
+}
+
+func (b *eventBus) subscribe() chan proto.Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subs == nil {
+		b.subs = make(map[chan proto.Event]struct{})
+	}
+	ch := make(chan proto.Event, eventBufferSize)
+	b.subs[ch] = struct{}{}
+	return ch
+}
+
+func (b *eventBus) unsubscribe(ch chan proto.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.subs, ch)
+}
+
+// publish delivers ev to every subscriber without blocking; a subscriber
+// whose buffer is full misses the event rather than stalling the daemon.
+func (b *eventBus) publish(ev proto.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// handleEvents streams events to the client, one JSON object per line,
+// until it disconnects.
+func (d *Daemon) handleEvents(conn net.Conn) {
+	ch := d.events.subscribe()
+	defer d.events.unsubscribe(ch)
+
+	respond(conn, proto.Response{OK: true})
+
+	gone := clientGone(conn)
+	for {
+		select {
+		case <-gone:
+			return
+		case ev := <-ch:
+			data, _ := json.Marshal(ev)
+			data = append(data, '\n')
+			if _, err := conn.Write(data); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package daemon
+
+// exclude.go – keeping agents' scratch files (partial installs, .DS_Store,
+// build output) out of git.
+//
+// grove.yaml's git.exclude patterns are written to the repository's
+// info/exclude, in a block grove owns, so that `git status` in every
+// worktree — the agent's own included — ignores them.  info/exclude is
+// shared by all worktrees of a repository, so the block holds the list of
+// whichever instance of the project was last started or relaunched.
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Markers around the block of info/exclude that grove rewrites.
+const (
+	excludeBlockStart = "# >>> grove git.exclude (generated from grove.yaml; edits here are lost)"
+	excludeBlockEnd   = "# <<< grove git.exclude"
+)
+
+// infoExcludePath returns the info/exclude file of the repository checked
+// out at repoDir (shared by all its worktrees).
+func infoExcludePath(repoDir string) (string, error) {
+	out, err := gitCommand("-C", repoDir, "rev-parse", "--git-common-dir").Output()
+	if err != nil {
+		return "", fmt.Errorf("git rev-parse --git-common-dir: %w", err)
+	}
+	gitDir := strings.TrimSpace(string(out))
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(repoDir, gitDir)
+	}
+	return filepath.Join(gitDir, "info", "exclude"), nil
+}
+
+// applyGitExcludes replaces grove's block in the info/exclude of the
+// repository at repoDir with patterns, removing it if there are none.  The
+// rest of the file is left as it is.  Callers hold the project lock.
+func applyGitExcludes(repoDir string, patterns []string) error {
+	excludePath, err := infoExcludePath(repoDir)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(excludePath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	updated := replaceExcludeBlock(string(data), patterns)
+	if updated == string(data) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(excludePath), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(excludePath, []byte(updated), 0o644)
+}
+
+// replaceExcludeBlock returns content with grove's block replaced by one
+// holding patterns, appended at the end; with no patterns the block is
+// dropped.
+func replaceExcludeBlock(content string, patterns []string) string {
+	var kept []string
+	inBlock := false
+	for _, line := range strings.Split(strings.TrimSuffix(content, "\n"), "\n") {
+		switch {
+		case line == excludeBlockStart:
+			inBlock = true
+		case line == excludeBlockEnd:
+			inBlock = false
+		case !inBlock:
+			kept = append(kept, line)
+		}
+	}
+	if len(kept) == 1 && kept[0] == "" {
+		kept = nil
+	}
+	if len(patterns) > 0 {
+		kept = append(kept, excludeBlockStart)
+		kept = append(kept, patterns...)
+		kept = append(kept, excludeBlockEnd)
+	}
+	if len(kept) == 0 {
+		return ""
+	}
+	return strings.Join(kept, "\n") + "\n"
+}
+
+// excludeGeneratedFile adds rel, a file grove writes into worktrees, to the
+// info/exclude of the repository at mainDir (outside grove's git.exclude
+// block, so it stays when that block is rewritten).  It does nothing if the
+// file is already listed.
+func excludeGeneratedFile(mainDir, rel string) error {
+	excludePath, err := infoExcludePath(mainDir)
+	if err != nil {
+		return err
+	}
+
+	pattern := "/" + rel
+	data, err := os.ReadFile(excludePath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == pattern {
+			return nil
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(excludePath), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(excludePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	line := pattern
+	if len(data) > 0 && !strings.HasSuffix(string(data), "\n") {
+		line = "\n" + line
+	}
+	_, err = fmt.Fprintln(f, line)
+	return err
+}
@@ -0,0 +1,44 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplaceExcludeBlock(t *testing.T) {
+	user := "# user's own\n*.swp\n"
+
+	withBlock := replaceExcludeBlock(user, []string{"node_modules/", ".DS_Store"})
+	assert.Equal(t, user+excludeBlockStart+"\nnode_modules/\n.DS_Store\n"+excludeBlockEnd+"\n", withBlock)
+
+	// A changed list replaces the block rather than adding a second one.
+	changed := replaceExcludeBlock(withBlock+"/later-line\n", []string{"dist/"})
+	assert.Equal(t, user+"/later-line\n"+excludeBlockStart+"\ndist/\n"+excludeBlockEnd+"\n", changed)
+
+	assert.Equal(t, user, replaceExcludeBlock(withBlock, nil))
+	assert.Equal(t, "", replaceExcludeBlock("", nil))
+}
+
+func TestApplyGitExcludes(t *testing.T) {
+	dataDir := t.TempDir()
+	p := &Project{DataDir: dataDir}
+	git(t, "init", "-q", "-b", "main", p.MainDir())
+	git(t, "-C", p.MainDir(), "-c", "user.name=t", "-c", "user.email=t@t", "commit", "-q", "--allow-empty", "-m", "init")
+	worktree := filepath.Join(dataDir, "worktrees", "feat-1")
+	git(t, "-C", p.MainDir(), "worktree", "add", "-q", "-b", "feat", worktree)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(worktree, "node_modules"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(worktree, "node_modules", "x.js"), nil, 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(worktree, ".DS_Store"), nil, 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(worktree, "main.go"), nil, 0o644))
+
+	require.NoError(t, applyGitExcludes(worktree, []string{"node_modules/", ".DS_Store"}))
+	assert.Equal(t, "?? main.go", git(t, "-C", worktree, "status", "--porcelain"))
+
+	require.NoError(t, applyGitExcludes(p.MainDir(), nil))
+	assert.Contains(t, git(t, "-C", worktree, "status", "--porcelain"), "?? node_modules/")
+}
@@ -0,0 +1,189 @@
+package daemon
+
+// gitfail.go – making sense of a failed git clone or pull.  When a repo is
+// renamed or moved, an SSH key is rotated or the VPN is down, every start
+// of the project fails the same way; git's stderr says why, but not what to
+// do about it.  classifyGitFailure recognises the common cases so that the
+// CLI can show an error code and a hint instead of raw git output alone.
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+// gitError is a failed git clone or pull of a project's repo.  Code and hint
+// are empty if git's output was not recognised.
+type gitError struct {
+	msg  string
+	code string // proto.ErrCodeGit*
+	hint string
+}
+
+func (e *gitError) Error() string { return e.msg }
+
+// newGitError classifies the failure of git command op ("clone" or "pull")
+// of project's repo from git's output.
+func newGitError(op, project, repo, output string, err error) *gitError {
+	e := &gitError{}
+	switch detail := strings.TrimSpace(output); {
+	case op == "pull":
+		e.msg = fmt.Sprintf("git pull: %v", err)
+	case detail != "":
+		e.msg = fmt.Sprintf("git clone %q failed: %s", repo, detail)
+	default:
+		e.msg = fmt.Sprintf("git clone %q failed: %v", repo, err)
+	}
+	e.code, e.hint = classifyGitFailure(project, repo, output)
+	return e
+}
+
+// gitFailure returns the error code and hint of err if it is, or wraps, a
+// recognised gitError.
+func gitFailure(err error) (code, hint string) {
+	var gerr *gitError
+	if errors.As(err, &gerr) {
+		return gerr.code, gerr.hint
+	}
+	return "", ""
+}
+
+// Substrings of git's (lowercased) output, by failure.  Host key failures
+// are told apart before authentication ones: ssh reports both with
+// "could not read from remote repository".
+var (
+	gitHostKeyFailures = []string{
+		"host key verification failed",
+		"remote host identification has changed",
+	}
+	gitAuthFailures = []string{
+		"authentication failed",
+		"permission denied (publickey",
+		"could not read username",
+		"could not read password",
+		"terminal prompts disabled",
+		"invalid username or password",
+	}
+	gitNotFoundFailures = []string{
+		"repository not found",
+		"does not appear to be a git repository",
+		"' does not exist",
+	}
+	gitUnreachableFailures = []string{
+		"could not resolve host",
+		"could not resolve hostname",
+		"temporary failure in name resolution",
+		"name or service not known",
+		"nodename nor servname provided",
+		"network is unreachable",
+		"connection timed out",
+		"operation timed out",
+	}
+)
+
+// classifyGitFailure maps the output of a failed git clone or pull of
+// project's repo to a proto.ErrCodeGit* code and what the user can do about
+// it.  Both are empty if the output is not recognised.
+func classifyGitFailure(project, repo, output string) (code, hint string) {
+	host := repoHost(repo)
+	hostName := host
+	if hostName == "" {
+		hostName = "the git host"
+	}
+	update := fmt.Sprintf("grove project update %s --repo <url>", project)
+	out := strings.ToLower(output)
+	has := func(patterns []string) bool {
+		for _, p := range patterns {
+			if strings.Contains(out, p) {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch {
+	case missingScheme(repo):
+		// git takes such a URL for a local path and reports it missing.
+		path := strings.TrimSuffix(repo, ".git")
+		return proto.ErrCodeGitRepoURL, fmt.Sprintf(
+			"repo URL %q may be missing a scheme; use https://%s.git or git@%s.git; fix it with: %s",
+			repo, path, strings.Replace(path, "/", ":", 1), update)
+	case has(gitHostKeyFailures):
+		return proto.ErrCodeGitHostKey, fmt.Sprintf(
+			"ssh does not trust %s's host key, or the key has changed; check the fingerprint %s publishes, then add it to ~/.ssh/known_hosts (ssh-keyscan %s >> ~/.ssh/known_hosts)",
+			hostName, hostName, orPlaceholder(host, "<host>"))
+	case has(gitAuthFailures):
+		if sshURL(repo) {
+			return proto.ErrCodeGitAuth, fmt.Sprintf(
+				"%s refused your SSH key; load a key it accepts (ssh-add, then ssh -T git@%s to test), or switch to an https URL with: %s",
+				hostName, orPlaceholder(host, "<host>"), update)
+		}
+		return proto.ErrCodeGitAuth, fmt.Sprintf(
+			"%s refused your credentials; refresh the token your git credential helper stores for it, or switch to an SSH URL with: %s",
+			hostName, update)
+	case has(gitNotFoundFailures):
+		return proto.ErrCodeGitRepoNotFound, fmt.Sprintf(
+			"no repository at %s; if it was renamed or moved, point the project at its new URL with: %s (private repos also look missing to accounts without access)",
+			repo, update)
+	case has(gitUnreachableFailures):
+		return proto.ErrCodeGitUnreachable, fmt.Sprintf(
+			"could not reach %s; check your network connection, and your VPN if the host is internal", hostName)
+	}
+	return "", ""
+}
+
+// missingScheme reports whether repo looks like a hosted repo URL without
+// its scheme, e.g. github.com/org/repo.
+func missingScheme(repo string) bool {
+	for _, host := range []string{"github.com/", "gitlab.com/", "bitbucket.org/"} {
+		if strings.HasPrefix(repo, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// sshURL reports whether git reaches repo over SSH: ssh:// URLs and the
+// scp-like user@host:path form.
+func sshURL(repo string) bool {
+	if strings.HasPrefix(repo, "ssh://") {
+		return true
+	}
+	if strings.Contains(repo, "://") {
+		return false
+	}
+	before, _, ok := strings.Cut(repo, ":")
+	return ok && !strings.Contains(before, "/")
+}
+
+// repoHost returns the host of repo, or "" for local paths.
+func repoHost(repo string) string {
+	if strings.Contains(repo, "://") {
+		if u, err := url.Parse(repo); err == nil {
+			return u.Hostname()
+		}
+		return ""
+	}
+	if sshURL(repo) {
+		before, _, _ := strings.Cut(repo, ":")
+		if _, host, ok := strings.Cut(before, "@"); ok {
+			return host
+		}
+		return before
+	}
+	if missingScheme(repo) {
+		host, _, _ := strings.Cut(repo, "/")
+		return host
+	}
+	return ""
+}
+
+func orPlaceholder(s, placeholder string) string {
+	if s == "" {
+		return placeholder
+	}
+	return s
+}
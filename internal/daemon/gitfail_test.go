@@ -0,0 +1,92 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyGitFailure(t *testing.T) {
+	const (
+		ssh   = "git@github.com:org/app.git"
+		https = "https://github.com/org/app.git"
+	)
+	cases := []struct {
+		repo, output string
+		code         string
+		hint         string // a substring of the hint
+	}{
+		{"github.com/org/app", "fatal: repository 'github.com/org/app' does not exist", proto.ErrCodeGitRepoURL, "https://github.com/org/app.git or git@github.com:org/app.git"},
+		{"gitlab.com/org/app.git", "", proto.ErrCodeGitRepoURL, "git@gitlab.com:org/app.git"},
+		{"bitbucket.org/org/app", "", proto.ErrCodeGitRepoURL, "grove project update app --repo <url>"},
+
+		{ssh, "Host key verification failed.\nfatal: Could not read from remote repository.", proto.ErrCodeGitHostKey, "ssh-keyscan github.com >> ~/.ssh/known_hosts"},
+		{ssh, "@@@ WARNING: REMOTE HOST IDENTIFICATION HAS CHANGED! @@@", proto.ErrCodeGitHostKey, "known_hosts"},
+
+		{ssh, "git@github.com: Permission denied (publickey).\nfatal: Could not read from remote repository.", proto.ErrCodeGitAuth, "ssh -T git@github.com"},
+		{https, "remote: Invalid username or password.\nfatal: Authentication failed for 'https://github.com/org/app.git/'", proto.ErrCodeGitAuth, "credential helper"},
+		{https, "fatal: could not read Username for 'https://github.com': terminal prompts disabled", proto.ErrCodeGitAuth, "switch to an SSH URL"},
+		{https, "fatal: could not read Password for 'https://me@github.com': No such device or address", proto.ErrCodeGitAuth, "github.com refused"},
+
+		{ssh, "ERROR: Repository not found.\nfatal: Could not read from remote repository.", proto.ErrCodeGitRepoNotFound, "grove project update app --repo <url>"},
+		{https, "remote: Repository not found.\nfatal: repository 'https://github.com/org/app.git/' not found", proto.ErrCodeGitRepoNotFound, "renamed or moved"},
+		{"/srv/git/app", "fatal: repository '/srv/git/app' does not exist", proto.ErrCodeGitRepoNotFound, "no repository at /srv/git/app"},
+		{ssh, "fatal: 'org/app.git' does not appear to be a git repository", proto.ErrCodeGitRepoNotFound, "new URL"},
+
+		{https, "fatal: unable to access 'https://github.com/org/app.git/': Could not resolve host: github.com", proto.ErrCodeGitUnreachable, "VPN"},
+		{"git@git.corp:org/app.git", "ssh: Could not resolve hostname git.corp: Name or service not known", proto.ErrCodeGitUnreachable, "could not reach git.corp"},
+		{"ssh://git@git.corp:2222/org/app.git", "ssh: connect to host git.corp port 2222: Connection timed out", proto.ErrCodeGitUnreachable, "git.corp"},
+		{https, "fatal: unable to access '…': Failed to connect to github.com port 443: Network is unreachable", proto.ErrCodeGitUnreachable, "network"},
+		{"git@git.corp:org/app.git", "ssh: Could not resolve hostname git.corp: nodename nor servname provided, or not known", proto.ErrCodeGitUnreachable, "VPN"},
+
+		{ssh, "error: Your local changes to the following files would be overwritten by merge", "", ""},
+		{https, "", "", ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.output, func(t *testing.T) {
+			code, hint := classifyGitFailure("app", tc.repo, tc.output)
+			assert.Equal(t, tc.code, code)
+			assert.Contains(t, hint, tc.hint)
+			if tc.code == "" {
+				assert.Empty(t, hint)
+			}
+		})
+	}
+}
+
+func TestRepoHost(t *testing.T) {
+	for repo, host := range map[string]string{
+		"git@github.com:org/app.git":          "github.com",
+		"github.com:org/app.git":              "github.com",
+		"ssh://git@git.corp:2222/org/app.git": "git.corp",
+		"https://user@gitlab.com/org/app.git": "gitlab.com",
+		"github.com/org/app":                  "github.com",
+		"/srv/git/app":                        "",
+		"../app":                              "",
+	} {
+		assert.Equal(t, host, repoHost(repo), repo)
+	}
+}
+
+func TestCloneFailureResponse(t *testing.T) {
+	repo := filepath.Join(t.TempDir(), "gone")
+	p := &Project{Name: "app", Repo: repo, DataDir: t.TempDir()}
+	err := ensureMainCheckout(context.Background(), p, io.Discard)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), fmt.Sprintf("git clone %q failed: ", repo), "the message is git's as before")
+
+	resp := errorResponse(fmt.Errorf("start: %w", err))
+	assert.Equal(t, proto.ErrCodeGitRepoNotFound, resp.ErrorCode)
+	assert.Contains(t, resp.Hint, "grove project update app --repo <url>")
+
+	code, hint := gitFailure(errors.New("git clone: something else"))
+	assert.Empty(t, code)
+	assert.Empty(t, hint)
+}
@@ -2,49 +2,122 @@ package daemon
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"sort"
 	"strings"
-	"sync"
 	"time"
 
+	"github.com/gandalfthegui/grove/internal/archive"
 	"github.com/gandalfthegui/grove/internal/envfile"
-	"github.com/gandalfthegui/grove/internal/proto"
+	"github.com/gandalfthegui/grove/internal/history"
+	"github.com/gandalfthegui/grove/pkg/proto"
 )
 
 func (d *Daemon) handleStart(conn net.Conn, req proto.Request) {
-	if req.Project == "" {
+	if req.Project == "" && req.Scratch == "" {
 		respond(conn, proto.Response{OK: false, Error: "project name required"})
 		return
 	}
-	if req.Branch == "" {
+	if req.Branch == "" && req.Scratch == "" {
 		respond(conn, proto.Response{OK: false, Error: "branch name required"})
 		return
 	}
-
-	p, err := loadProject(d.rootDir, req.Project)
-	if err != nil {
+	if req.InPlace && req.Branch != "" {
+		respond(conn, proto.Response{OK: false, Error: "an in-place scratch instance works on the branch checked out; --branch cannot be used with --in-place"})
+		return
+	}
+	if err := validateLabels(req.Labels); err != nil {
+		respond(conn, proto.Response{OK: false, Error: err.Error()})
+		return
+	}
+	if err := checkTTLAction(req.TTLAction); err != nil {
+		respond(conn, proto.Response{OK: false, Error: err.Error()})
+		return
+	}
+	if req.TTL < 0 {
+		respond(conn, proto.Response{OK: false, Error: "the time to live must not be negative"})
+		return
+	}
+	if err := checkMode(req.Mode); err != nil {
 		respond(conn, proto.Response{OK: false, Error: err.Error()})
 		return
 	}
 
+	if req.DryRun && req.Scratch != "" {
+		respond(conn, proto.Response{OK: false, Error: "a dry run checks a registered project's start; scratch instances have none"})
+		return
+	}
+
+	var p *Project
+	var err error
+	if req.Scratch == "" {
+		if p, err = d.loadProject(req.Project); err != nil {
+			respond(conn, proto.Response{OK: false, Error: err.Error()})
+			return
+		}
+	}
+	if p != nil {
+		if err := p.checkDataDir(); err != nil {
+			respond(conn, errorResponse(err))
+			return
+		}
+	}
+	if req.DryRun {
+		d.dryRunStart(conn, p, req)
+		return
+	}
+
+	// The start is cancelled if its client goes away or grove cancel-start
+	// names it (see cancelstart.go); every stage runs its commands with ctx.
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	if req.StartID != "" {
+		unregister, err := d.starts.add(req.StartID, cancel)
+		if err != nil {
+			respond(conn, proto.Response{OK: false, Error: err.Error()})
+			return
+		}
+		defer unregister()
+	}
+	defer watchClient(conn, cancel)()
+
 	// Allocate instance ID early so the log file can be named after it.
-	d.mu.Lock()
-	instanceID := d.nextInstanceID()
-	d.mu.Unlock()
+	instanceID := d.reserveInstanceID()
+	defer d.releaseInstanceID(instanceID)
 	startedAt := time.Now()
 
+	// A scratch instance's project is made up from the path or URL; see
+	// scratch.go.
+	var scratch *proto.ScratchInfo
+	if req.Scratch != "" {
+		if p, scratch, err = d.scratchProject(instanceID, req.Scratch, req.InPlace); err != nil {
+			respond(conn, proto.Response{OK: false, Error: err.Error()})
+			return
+		}
+		req.Project = p.Name
+		switch {
+		case req.InPlace:
+			req.Branch = inPlaceBranch(req.Scratch)
+		case req.Branch == "":
+			req.Branch = "scratch-" + instanceID
+		}
+	}
+
 	logFile := filepath.Join(d.rootDir, "logs", instanceID+".log")
 	logFd, _ := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
 	if logFd != nil {
 		defer logFd.Close()
 	}
+	// Everything up to the agent's launch is setup output (see setuplog.go).
+	setup := beginSetupSection(logFd)
+	defer setup.end()
 
 	// setupW captures all clone/pull/bootstrap output in memory and also
 	// writes it to the log file so it's preserved after the connection closes.
@@ -66,65 +139,254 @@ func (d *Daemon) handleStart(conn net.Conn, req proto.Request) {
 			}
 		}
 	}()
+	if scratch != nil && !scratch.InPlace {
+		rollbacks = append(rollbacks, func() { os.RemoveAll(scratch.DataDir) })
+	}
 
-	// Ensure the canonical checkout exists (clone if needed).
-	if err := ensureMainCheckout(p, setupW); err != nil {
+	// cancelled ends the start, as a failure, if it was cancelled before
+	// or during stage.
+	cancelled := func(stage string) bool {
+		if ctx.Err() == nil {
+			return false
+		}
+		setupErr = &startCancelledError{stage: stage, cause: context.Cause(ctx)}
+		fmt.Fprintln(setupW, setupErr)
+		log.Printf("start cancelled: stage=%s project=%s branch=%s instance=%s elapsed=%s cause=%q",
+			stage, req.Project, req.Branch, instanceID, time.Since(startedAt).Round(time.Millisecond), context.Cause(ctx))
+		respond(conn, errorResponse(setupErr))
+		return true
+	}
+
+	// Hold the project lock while touching the main checkout (clone, pull,
+	// worktree add); it is released once the worktree exists.
+	unlockProject, err := d.projectLocks.lock(req.Project, "start "+instanceID, projectLockTimeout, setupW)
+	if err != nil {
 		setupErr = err
-		log.Printf("start failed: stage=clone project=%s branch=%s instance=%s repo=%q elapsed=%s err=%v%s",
-			req.Project, req.Branch, instanceID, p.Repo, time.Since(startedAt).Round(time.Millisecond), err, repoURLHintSuffix(p.Repo))
 		respond(conn, proto.Response{OK: false, Error: err.Error()})
 		return
 	}
+	defer unlockProject()
+
+	// Refuse before cloning or building anything if the disk is nearly full.
+	if err := d.checkDisk(setupW); err != nil {
+		setupErr = err
+		log.Printf("start failed: stage=disk project=%s branch=%s instance=%s err=%v", req.Project, req.Branch, instanceID, err)
+		respond(conn, errorResponse(err))
+		return
+	}
+
+	// Ensure the canonical checkout exists (clone if needed).  An in-place
+	// scratch directory is used as it is.
+	timer := newStageTimer()
+	timer.begin(proto.StageClone)
+	if scratch == nil || !scratch.InPlace {
+		if err := ensureMainCheckout(ctx, p, setupW); err != nil {
+			if cancelled(proto.StageClone) {
+				return
+			}
+			setupErr = err
+			code, _ := gitFailure(err)
+			log.Printf("start failed: stage=clone project=%s branch=%s instance=%s repo=%q elapsed=%s code=%s err=%v",
+				req.Project, req.Branch, instanceID, p.Repo, time.Since(startedAt).Round(time.Millisecond), code, err)
+			respond(conn, errorResponse(err))
+			return
+		}
+	}
 
 	// Pull latest changes so the new worktree branches from current remote HEAD.
 	// Non-fatal: log the warning and continue so offline use still works.
-	if err := pullMain(p, setupW); err != nil {
-		log.Printf("warning: git pull failed for %s: %v", req.Project, err)
+	// A scratch clone is fresh already.
+	if scratch == nil {
+		if err := pullMain(ctx, p, setupW); err != nil {
+			if cancelled(proto.StageClone) {
+				return
+			}
+			code, hint := gitFailure(err)
+			log.Printf("warning: git pull failed for %s: code=%s err=%v", req.Project, code, err)
+			if hint != "" {
+				fmt.Fprintf(setupW, "git pull failed; starting from the checkout as it is.\nhint: %s\n", hint)
+			}
+		}
 	}
+	timer.end()
 
 	// Overlay grove.yaml from the repo root if it exists.
-	inRepoFound, err := loadInRepoConfig(p)
+	inRepoFound, err := loadInRepoConfig(p, req.Branch, "")
 	if err != nil {
-		log.Printf("warning: could not read grove.yaml for %s: %v", req.Project, err)
+		// A config that exists but is invalid (bad YAML, missing or cyclic
+		// include) must not be mistaken for a missing one below.
+		setupErr = err
+		log.Printf("start failed: stage=config project=%s branch=%s instance=%s err=%v", req.Project, req.Branch, instanceID, err)
+		resp := proto.Response{OK: false, Error: "invalid grove.yaml: " + err.Error(), ErrorCode: proto.ErrCodeConfigInvalid}
+		if path := findInRepoConfig(p.MainDir()); path != "" {
+			resp.Hint = "grove validate " + path + " shows what is wrong with it"
+		}
+		respond(conn, resp)
+		return
 	}
 
 	// If there is no grove.yaml the project is not configured enough to start.
-	// Tell the client so it can prompt the user to create one.
-	if !inRepoFound {
+	// Tell the client so it can prompt the user to create one.  A scratch
+	// instance makes do with defaults (applied below).
+	if !inRepoFound && scratch == nil {
 		setupErr = fmt.Errorf("no grove.yaml")
 		respond(conn, proto.Response{
-			OK:       false,
-			Error:    "no grove.yaml found in " + req.Project,
-			InitPath: p.MainDir(),
+			OK:             false,
+			Error:          "no grove.yaml found in " + req.Project,
+			ErrorCode:      proto.ErrCodeConfigMissing,
+			InitPath:       p.MainDir(),
+			ConfigBranches: configBranches(ctx, p.MainDir()),
 		})
 		return
 	}
+	if scratch == nil {
+		warnUntrackedConfig(ctx, p, setupW)
+	}
+	if req.Mode == proto.ModeAuto && !p.allowsAuto() {
+		setupErr = errAutoForbidden(req.Project)
+		respond(conn, proto.Response{OK: false, Error: setupErr.Error()})
+		return
+	}
 
 	// Create the git worktree on the user-specified branch.
-	worktreeDir, err := createWorktree(p, instanceID, req.Branch, setupW)
+	timer.begin(proto.StageWorktree)
+	worktreeDir := req.Scratch
+	if scratch == nil || !scratch.InPlace {
+		worktreeDir, err = createWorktree(ctx, p, instanceID, req.Branch, req.Base, setupW)
+	}
+	timer.end()
 	if err != nil {
+		if cancelled(proto.StageWorktree) {
+			return
+		}
 		setupErr = err
 		log.Printf("start failed: stage=worktree project=%s branch=%s instance=%s main_dir=%s elapsed=%s err=%v",
 			req.Project, req.Branch, instanceID, p.MainDir(), time.Since(startedAt).Round(time.Millisecond), err)
 		respond(conn, proto.Response{OK: false, Error: err.Error()})
 		return
 	}
-	rollbacks = append(rollbacks, func() { removeWorktree(p, instanceID, req.Branch) })
+
+	// What the branch starts from, for grove status and grove list -o wide;
+	// an in-place scratch worktree is the user's own and has none.
+	var baseSHA string
+	if scratch == nil || !scratch.InPlace {
+		sha, err := mergeBase(ctx, p.MainDir(), worktreeDir)
+		if err != nil {
+			log.Printf("warning: instance %s: no base commit for branch %s: %v", instanceID, req.Branch, err)
+		}
+		baseSHA = sha
+	}
+	if scratch == nil {
+		rollbacks = append(rollbacks, func() {
+			if unlock, err := d.projectLocks.lock(req.Project, "rollback "+instanceID, projectLockTimeout, io.Discard); err == nil {
+				defer unlock()
+			}
+			removeWorktree(p.MainDir(), worktreeDir, req.Branch, "instance "+instanceID)
+		})
+	}
+
+	// The branch may carry its own grove.yaml; reload the config from the
+	// worktree so it applies to this instance.  Fall back to the main
+	// checkout's version if the branch's copy does not parse.
+	bp, err := d.loadProject(req.Project)
+	if scratch != nil {
+		bp, err = &Project{Name: p.Name, Repo: p.Repo, DataDir: p.DataDir, mainDir: p.mainDir}, nil
+	}
+	if err == nil {
+		found, err := loadInRepoConfig(bp, req.Branch, worktreeDir)
+		if err != nil {
+			log.Printf("warning: could not read grove.yaml on branch %s of %s: %v", req.Branch, req.Project, err)
+		} else {
+			if !found && scratch != nil {
+				d.applyScratchDefaults(bp)
+			}
+			p = bp
+		}
+		// Say which copy applies when the branch changed it, so that a
+		// config change that "didn't take" is not a mystery.
+		if scratch == nil {
+			fmt.Fprint(setupW, branchConfigNotice(p.MainDir(), worktreeDir, req.Branch, err))
+		}
+	}
+	if req.Agent != "" {
+		p.overrideAgent(req.Agent)
+	}
+	// The branch's grove.yaml may forbid auto mode too, but not allow it
+	// where the main checkout's forbids it.
+	if req.Mode == proto.ModeAuto && !p.allowsAuto() {
+		setupErr = errAutoForbidden(req.Project)
+		respond(conn, proto.Response{OK: false, Error: setupErr.Error()})
+		return
+	}
+
+	// Keep the agent status file (see status.go) out of the agent's commits.
+	if err := excludeAgentStatusFile(p.MainDir()); err != nil {
+		log.Printf("warning: could not exclude %s in %s: %v", agentStatusFile, p.MainDir(), err)
+	}
+	if err := applyGitExcludes(p.MainDir(), p.Git.Exclude); err != nil {
+		log.Printf("warning: could not apply git.exclude in %s: %v", p.MainDir(), err)
+	}
+	if len(p.Agent.ContextFiles) > 0 {
+		if err := excludeGeneratedFile(p.MainDir(), contextFile); err != nil {
+			log.Printf("warning: could not exclude %s in %s: %v", contextFile, p.MainDir(), err)
+		}
+	}
+	unlockProject()
+
+	// Settings that load but are probably not what was meant (see
+	// validate.go) are worth a line in the setup output.
+	for _, w := range configWarnings(p) {
+		fmt.Fprintf(setupW, "warning: grove.yaml: %s\n", w)
+		log.Printf("warning: instance %s: grove.yaml: %s", instanceID, w)
+	}
+	prepareContextFile(instanceID, p, worktreeDir, setupW)
+
+	// Pull the image first, so that its time is not counted as the
+	// container's.
+	if p.Container.Compose == "" {
+		timer.begin(proto.StageImage)
+		err := pullImage(ctx, p, setupW)
+		timer.end()
+		if err != nil {
+			if cancelled(proto.StageImage) {
+				return
+			}
+			setupErr = err
+			log.Printf("start failed: stage=image project=%s branch=%s instance=%s image=%s elapsed=%s err=%v",
+				req.Project, req.Branch, instanceID, p.Container.Image, time.Since(startedAt).Round(time.Millisecond), err)
+			respond(conn, proto.Response{OK: false, Error: err.Error()})
+			return
+		}
+	}
 
 	// Start the container with the worktree bind-mounted inside it.
-	containerName, err := startContainer(p, instanceID, worktreeDir, setupW)
+	composeProject := ""
+	if p.Container.Compose != "" {
+		composeProject = "grove-" + instanceID
+	}
+	timer.begin(proto.StageContainer)
+	containerName, err := startContainer(ctx, p, instanceID, worktreeDir, req.Labels, setupW)
+	timer.end()
 	if err != nil {
+		// docker may have made the container before it was killed.
+		if ctx.Err() != nil {
+			rollbacks = append(rollbacks, func() { stopContainer("grove-"+instanceID, composeProject) })
+		}
+		if cancelled(proto.StageContainer) {
+			return
+		}
 		setupErr = err
 		log.Printf("start failed: stage=container project=%s branch=%s instance=%s worktree=%s elapsed=%s err=%v",
 			req.Project, req.Branch, instanceID, worktreeDir, time.Since(startedAt).Round(time.Millisecond), err)
 		respond(conn, proto.Response{OK: false, Error: err.Error()})
 		return
 	}
-	composeProject := ""
-	if p.Container.Compose != "" {
-		composeProject = "grove-" + instanceID
-	}
 	rollbacks = append(rollbacks, func() { stopContainer(containerName, composeProject) })
+	image := inspectImage(containerName)
+	if image != nil && image.HostPlatform != "" {
+		fmt.Fprint(setupW, emulationWarning(image, p.Container.Platform))
+		log.Printf("warning: instance %s: %s runs under emulation on %s", instanceID, image.Platform, image.HostPlatform)
+	}
 
 	// Copy host's ~/.claude.json into the container so Claude starts with
 	// existing preferences/auth. This is a copy, not a bind mount, to avoid
@@ -134,7 +396,13 @@ func (d *Daemon) handleStart(conn net.Conn, req proto.Request) {
 	}
 
 	// Run start commands inside the container.
-	if err := runStart(p, containerName, setupW); err != nil {
+	timer.begin(proto.StageStart)
+	err = runStart(ctx, p, containerName, setupW)
+	timer.end()
+	if err != nil {
+		if cancelled(proto.StageStart) {
+			return
+		}
 		setupErr = err
 		log.Printf("start failed: stage=start project=%s branch=%s instance=%s worktree=%s elapsed=%s err=%v",
 			req.Project, req.Branch, instanceID, worktreeDir, time.Since(startedAt).Round(time.Millisecond), err)
@@ -147,7 +415,13 @@ func (d *Daemon) handleStart(conn net.Conn, req proto.Request) {
 	if agentCmd == "" {
 		agentCmd = "sh"
 	}
-	if err := ensureAgentInstalled(agentCmd, containerName, setupW); err != nil {
+	timer.begin(proto.StageAgentInstall)
+	err = ensureAgentsInstalled(ctx, p, agentCmd, containerName, setupW)
+	timer.end()
+	if err != nil {
+		if cancelled(proto.StageAgentInstall) {
+			return
+		}
 		setupErr = err
 		log.Printf("start failed: stage=agent-install project=%s branch=%s instance=%s worktree=%s elapsed=%s err=%v",
 			req.Project, req.Branch, instanceID, worktreeDir, time.Since(startedAt).Round(time.Millisecond), err)
@@ -155,28 +429,58 @@ func (d *Daemon) handleStart(conn net.Conn, req proto.Request) {
 		return
 	}
 
+	mode := req.Mode
+	if mode == "" {
+		mode = proto.ModeNormal
+	}
+	agentArgs, hasModes := modeArgs(agentCmd, mode)
+	agentArgs = append(append([]string(nil), p.Agent.Args...), agentArgs...)
+	if !hasModes {
+		if mode != proto.ModeNormal {
+			fmt.Fprintf(setupW, "warning: grove has no %s mode for agent %s; --mode ignored\n", mode, agentCmd)
+			log.Printf("warning: instance %s: no %s mode for agent %s", instanceID, mode, agentCmd)
+		}
+		mode = ""
+	}
+
 	inst := &Instance{
 		ID:             instanceID,
 		Project:        req.Project,
 		Branch:         req.Branch,
 		WorktreeDir:    worktreeDir,
+		MainDir:        p.MainDir(),
 		CreatedAt:      time.Now(),
 		LogFile:        logFile,
 		state:          proto.StateRunning,
 		InstancesDir:   filepath.Join(d.rootDir, "instances"),
 		ContainerID:    containerName,
 		ComposeProject: composeProject,
+		Launch:         p.launchConfig(),
+		labels:         req.Labels,
+		Task:           req.Task,
+		Mode:           mode,
+		pinned:         req.Pin,
+		Scratch:        scratch,
+		image:          image,
+		baseSHA:        baseSHA,
+		emit:           d.events.publish,
+		record:         d.recordHistory,
 	}
+	inst.addSetupLog(setup.end())
+	inst.setTTL(time.Duration(req.TTL)*time.Second, req.TTLAction)
 
-	// Build the agent environment: env file is the base, request-level
-	// values (from the CLI prompt or host env) override.
-	agentEnv := envfile.Load(filepath.Join(d.rootDir, "env"))
-	for k, v := range req.AgentEnv {
-		agentEnv[k] = v
-	}
+	agentEnv := d.launchEnv(inst, p.agentEnv(), req)
 	logAgentCredentials(instanceID, agentEnv)
 
-	if err := inst.startAgent(agentCmd, p.Agent.Args, agentEnv); err != nil {
+	inst.setInitialSize(req.Cols, req.Rows, p)
+	// The last chance to cancel: once the agent runs the start is done.
+	if cancelled(proto.StageAgentLaunch) {
+		return
+	}
+	timer.begin(proto.StageAgentLaunch)
+	err = inst.startAgent(agentCmd, agentArgs, agentEnv)
+	timer.end()
+	if err != nil {
 		setupErr = err
 		log.Printf("start failed: stage=agent-launch project=%s branch=%s instance=%s worktree=%s elapsed=%s err=%v",
 			req.Project, req.Branch, instanceID, worktreeDir, time.Since(startedAt).Round(time.Millisecond), err)
@@ -184,43 +488,83 @@ func (d *Daemon) handleStart(conn net.Conn, req proto.Request) {
 		return
 	}
 
+	inst.recordRun(agentCmd, agentArgs, false)
+	d.startHelpers(inst, p, req, setupW)
+	inst.StartTimings = timer.result()
+	d.recordHistory(history.Record{
+		Kind:       history.KindStart,
+		Project:    req.Project,
+		DurationMs: proto.StartDuration(inst.StartTimings).Milliseconds(),
+		Stages:     inst.StartTimings,
+	})
+
 	// All steps succeeded — register the instance and respond.
 	d.mu.Lock()
+	d.lastSeq++
+	inst.Seq = d.lastSeq
 	d.instances[instanceID] = inst
 	d.mu.Unlock()
 
 	inst.persistMeta(filepath.Join(d.rootDir, "instances"))
+	d.writeConfigSnapshot(inst, p)
+	go d.measureUsageWhenDone(inst)
 
 	// Send the JSON ACK first, then stream any captured setup output.
 	respond(conn, proto.Response{OK: true, InstanceID: instanceID})
 	if outputBuf.Len() > 0 {
 		conn.Write(outputBuf.Bytes())
 	}
-	log.Printf("start succeeded: project=%s branch=%s instance=%s worktree=%s elapsed=%s", req.Project, req.Branch, instanceID, worktreeDir, time.Since(startedAt).Round(time.Millisecond))
+	log.Printf("start succeeded: project=%s branch=%s instance=%s worktree=%s base=%s elapsed=%s", req.Project, req.Branch, instanceID, worktreeDir, baseSHA, time.Since(startedAt).Round(time.Millisecond))
 }
 
-func repoURLHintSuffix(repo string) string {
-	if strings.HasPrefix(repo, "github.com/") || strings.HasPrefix(repo, "gitlab.com/") || strings.HasPrefix(repo, "bitbucket.org/") {
-		return " hint=\"repo URL may be missing scheme; try https://host/org/repo.git or git@host:org/repo.git\""
-	}
-	return ""
-}
-
-func (d *Daemon) handleList(conn net.Conn) {
+func (d *Daemon) handleList(conn net.Conn, req proto.Request) {
 	d.mu.Lock()
-	infos := make([]proto.InstanceInfo, 0, len(d.instances))
+	insts := make([]*Instance, 0, len(d.instances))
 	for _, inst := range d.instances {
-		infos = append(infos, inst.Info())
+		if req.InstanceID == "" || inst.ID == req.InstanceID {
+			insts = append(insts, inst)
+		}
 	}
 	d.mu.Unlock()
 
+	now := time.Now()
+	infos := make([]proto.InstanceInfo, 0, len(insts))
+	for _, inst := range insts {
+		info := inst.Info()
+		if req.Changes {
+			info.Changes = inst.changeSummary(now)
+		}
+		if req.MainAhead {
+			info.MainAhead = inst.mainAhead()
+		}
+		info.ImageDrift = d.imageDrift(inst, info)
+		infos = append(infos, info)
+	}
+
 	sort.Slice(infos, func(i, j int) bool {
-		return infos[i].CreatedAt < infos[j].CreatedAt
+		return proto.CreatedBefore(infos[i], infos[j])
 	})
 
 	respond(conn, proto.Response{OK: true, Instances: infos})
 }
 
+// imageDrift returns the image inst's project names now if it is not the
+// one inst's container was created from, else "".  Only single-image
+// projects are compared; a compose file names its images itself.
+func (d *Daemon) imageDrift(inst *Instance, info proto.InstanceInfo) string {
+	if info.Image == nil {
+		return ""
+	}
+	p, err := d.currentProject(inst)
+	if err != nil || p.Container.Compose != "" || p.Container.Image == "" {
+		return ""
+	}
+	if p.Container.Image == info.Image.Ref {
+		return ""
+	}
+	return p.Container.Image
+}
+
 func (d *Daemon) handleAttach(conn net.Conn, req proto.Request) {
 	inst := d.getInstance(req.InstanceID)
 	if inst == nil {
@@ -228,20 +572,82 @@ func (d *Daemon) handleAttach(conn net.Conn, req proto.Request) {
 		return
 	}
 
+	if req.Command != "" {
+		d.attachCommand(conn, inst, req)
+		return
+	}
+
+	h, err := inst.agentByName(req.AgentName)
+	if err != nil {
+		respond(conn, proto.Response{OK: false, Error: err.Error()})
+		return
+	}
+
 	inst.mu.Lock()
 	state := inst.state
+	if h != nil {
+		state = h.state
+	}
 	inst.mu.Unlock()
 
+	if state == proto.StateBroken {
+		respond(conn, proto.Response{OK: false, Error: "instance is " + state, Hint: brokenHint(inst.ID)})
+		return
+	}
 	if proto.IsTerminal(state) {
-		respond(conn, proto.Response{OK: false, Error: "instance has " + strings.ToLower(state)})
+		who := "instance"
+		if h != nil {
+			who = "agent " + h.name
+		}
+		respond(conn, proto.Response{OK: false, Error: who + " has " + strings.ToLower(state)})
 		return
 	}
 
 	// Send the handshake ACK before entering streaming mode.
-	respond(conn, proto.Response{OK: true})
+	respond(conn, proto.Response{OK: true, AttachV2: req.AttachV2})
 
 	// Attach blocks until the client detaches or the agent exits.
-	inst.Attach(conn)
+	if h != nil {
+		inst.attachHelper(h, conn, req.AttachV2)
+		return
+	}
+	inst.Attach(conn, req.AttachV2)
+}
+
+// attachCommand serves grove attach --command: it runs req.Command in a
+// PTY of its own in inst's container and attaches the client to it.
+func (d *Daemon) attachCommand(conn net.Conn, inst *Instance, req proto.Request) {
+	if !req.AttachV2 || req.AgentName != "" {
+		respond(conn, proto.Response{OK: false, Error: "a command cannot be attached to with this client (or with --agent)"})
+		return
+	}
+	inst.mu.Lock()
+	state := inst.state
+	inst.mu.Unlock()
+	switch state {
+	case proto.StateBroken:
+		respond(conn, proto.Response{OK: false, Error: "instance is " + state, Hint: brokenHint(inst.ID)})
+		return
+	case proto.StateFailedSetup:
+		respond(conn, proto.Response{OK: false, Error: "instance is " + state, Hint: failedSetupHint(inst.ID)})
+		return
+	}
+	if resp := inst.removedError("run a command", true, false); resp != nil {
+		respond(conn, *resp)
+		return
+	}
+	if err := requireTools(toolDocker); err != nil {
+		respond(conn, proto.Response{OK: false, Error: err.Error()})
+		return
+	}
+
+	s, cmd, err := inst.startAux(req.Command)
+	if err != nil {
+		respond(conn, proto.Response{OK: false, Error: err.Error()})
+		return
+	}
+	respond(conn, proto.Response{OK: true, AttachV2: true, AttachCommand: true})
+	inst.runAux(s, cmd, conn)
 }
 
 func (d *Daemon) handleLogs(conn net.Conn, req proto.Request) {
@@ -251,13 +657,85 @@ func (d *Daemon) handleLogs(conn net.Conn, req proto.Request) {
 		return
 	}
 
+	if req.LogSection != "" {
+		d.sendLogSection(conn, inst, req.LogSection, req.AcceptGzip)
+		return
+	}
+	h, err := inst.agentByName(req.AgentName)
+	if err != nil {
+		respond(conn, proto.Response{OK: false, Error: err.Error()})
+		return
+	}
+
 	inst.mu.Lock()
-	logs := make([]byte, len(inst.logBuf))
-	copy(logs, inst.logBuf)
+	buf := inst.logBuf
+	if h != nil {
+		buf = h.logBuf
+	}
+	if req.LogTail > 0 {
+		buf = logTail(buf, req.LogTail)
+	}
+	logs := make([]byte, len(buf))
+	copy(logs, buf)
 	inst.mu.Unlock()
 
-	respond(conn, proto.Response{OK: true, InstanceID: req.InstanceID})
-	conn.Write(logs)
+	writeLogs(conn, inst.ID, logs, req.AcceptGzip)
+}
+
+// logTail returns the last n bytes of buf, from the first line that starts
+// in them: a cut in the middle of a line could split a rune or an escape
+// sequence.
+func logTail(buf []byte, n int) []byte {
+	if len(buf) <= n {
+		return buf
+	}
+	if buf[len(buf)-n-1] == '\n' {
+		return buf[len(buf)-n:]
+	}
+	tail := buf[len(buf)-n:]
+	if i := bytes.IndexByte(tail, '\n'); i >= 0 {
+		return tail[i+1:]
+	}
+	return tail
+}
+
+// gzipLogThreshold is the size from which a log is gzipped for a client
+// that accepts it; below it, compression saves too little to matter.
+const gzipLogThreshold = 64 << 10
+
+// writeLogs answers a logs request with data, gzipped if the client accepts
+// it and data is at least gzipLogThreshold bytes.
+func writeLogs(conn net.Conn, instanceID string, data []byte, acceptGzip bool) {
+	if !acceptGzip || len(data) < gzipLogThreshold {
+		respond(conn, proto.Response{OK: true, InstanceID: instanceID})
+		conn.Write(data)
+		return
+	}
+	respond(conn, proto.Response{OK: true, InstanceID: instanceID, Encoding: proto.EncodingGzip})
+	zw := gzip.NewWriter(conn)
+	zw.Write(data)
+	zw.Close()
+}
+
+// sendLogSection answers grove logs --setup or --agent from the instance's
+// log file, which unlike the in-memory buffer survives daemon restarts.
+func (d *Daemon) sendLogSection(conn net.Conn, inst *Instance, section string, acceptGzip bool) {
+	if section != proto.LogSectionSetup && section != proto.LogSectionAgent {
+		respond(conn, proto.Response{OK: false, Error: "unknown log section: " + section})
+		return
+	}
+	data, err := os.ReadFile(inst.LogFile)
+	if err != nil {
+		respond(conn, proto.Response{OK: false, Error: "cannot read log: " + err.Error()})
+		return
+	}
+	ranges := inst.Info().SetupLog
+	if section == proto.LogSectionSetup && len(ranges) == 0 {
+		respond(conn, proto.Response{OK: false, Error: "no setup output recorded for instance " + inst.ID +
+			" (it was started by an older grove)"})
+		return
+	}
+	writeLogs(conn, inst.ID, logSection(data, ranges, section), acceptGzip)
 }
 
 func (d *Daemon) handleLogsFollow(conn net.Conn, req proto.Request) {
@@ -266,13 +744,27 @@ func (d *Daemon) handleLogsFollow(conn net.Conn, req proto.Request) {
 		respond(conn, proto.Response{OK: false, Error: "instance not found: " + req.InstanceID})
 		return
 	}
+	h, err := inst.agentByName(req.AgentName)
+	if err != nil {
+		respond(conn, proto.Response{OK: false, Error: err.Error()})
+		return
+	}
 	respond(conn, proto.Response{OK: true})
 
+	// logBuf and state return the followed agent's; the caller holds
+	// inst.mu.
+	logBuf := func() []byte { return inst.logBuf }
+	state := func() string { return inst.state }
+	if h != nil {
+		logBuf = func() []byte { return h.logBuf }
+		state = func() string { return h.state }
+	}
+
 	// Snapshot current logBuf; track how many bytes we've sent.
 	inst.mu.Lock()
-	initial := make([]byte, len(inst.logBuf))
-	copy(initial, inst.logBuf)
-	offset := len(inst.logBuf)
+	initial := make([]byte, len(logBuf()))
+	copy(initial, logBuf())
+	offset := len(initial)
 	inst.mu.Unlock()
 
 	if len(initial) > 0 {
@@ -281,18 +773,25 @@ func (d *Daemon) handleLogsFollow(conn net.Conn, req proto.Request) {
 		}
 	}
 
+	gone := clientGone(conn)
 	ticker := time.NewTicker(100 * time.Millisecond)
 	defer ticker.Stop()
 
-	for range ticker.C {
+	for {
+		select {
+		case <-gone:
+			return
+		case <-ticker.C:
+		}
 		inst.mu.Lock()
-		state := inst.state
+		state := state()
+		buf := logBuf()
 		// Clamp offset if logBuf was trimmed (rolled over 1 MiB cap).
-		if offset > len(inst.logBuf) {
+		if offset > len(buf) {
 			offset = 0
 		}
-		newData := make([]byte, len(inst.logBuf)-offset)
-		copy(newData, inst.logBuf[offset:])
+		newData := make([]byte, len(buf)-offset)
+		copy(newData, buf[offset:])
 		offset += len(newData)
 		inst.mu.Unlock()
 
@@ -316,14 +815,70 @@ func (d *Daemon) handleStop(conn net.Conn, req proto.Request) {
 		return
 	}
 
+	stack := req.StopStack
+	if inst.ComposeProject == "" {
+		if stack {
+			respond(conn, proto.Response{OK: false, Error: "instance " + inst.ID + " has no compose stack; --stack applies to compose projects only"})
+			return
+		}
+	} else if p, err := d.instanceProject(inst, false); err == nil && p.Stop.Stack {
+		stack = true
+	}
+
 	// Kill the agent process if it is running; ptyReader will transition
-	// the state to CRASHED and persist it.  For already-dead instances
-	// (EXITED/CRASHED/FINISHED) this is a no-op.
+	// the state to KILLED and persist it.  For already-dead instances
+	// (EXITED/CRASHED/KILLED/FINISHED) this is a no-op and the state stays.
 	inst.destroy()
 
+	if stack {
+		if err := d.stopStack(inst); err != nil {
+			respond(conn, proto.Response{OK: false, Error: "the agent is stopped, but its services are not: " + err.Error()})
+			return
+		}
+	}
+
 	respond(conn, proto.Response{OK: true})
 }
 
+// stopStack stops inst's compose services, leaving them to be resumed by
+// resumeStack on restart, reopen or finish.
+func (d *Daemon) stopStack(inst *Instance) error {
+	inst.mu.Lock()
+	stopped := inst.stackStopped
+	inst.mu.Unlock()
+	if stopped {
+		return nil
+	}
+	if err := stopStack(inst.ComposeProject); err != nil {
+		return err
+	}
+	inst.mu.Lock()
+	inst.stackStopped = true
+	inst.mu.Unlock()
+	inst.persistMeta(filepath.Join(d.rootDir, "instances"))
+	log.Printf("instance %s: stopped compose services of %s", inst.ID, inst.ComposeProject)
+	return nil
+}
+
+// resumeStack starts inst's compose services again if grove stop stopped
+// them, writing docker's output to w.
+func (d *Daemon) resumeStack(inst *Instance, w io.Writer) error {
+	inst.mu.Lock()
+	stopped := inst.stackStopped
+	inst.mu.Unlock()
+	if !stopped {
+		return nil
+	}
+	if err := startStack(inst.ComposeProject, w); err != nil {
+		return err
+	}
+	inst.mu.Lock()
+	inst.stackStopped = false
+	inst.mu.Unlock()
+	inst.persistMeta(filepath.Join(d.rootDir, "instances"))
+	return nil
+}
+
 func (d *Daemon) handleDrop(conn net.Conn, req proto.Request) {
 	inst := d.getInstance(req.InstanceID)
 	if inst == nil {
@@ -331,35 +886,73 @@ func (d *Daemon) handleDrop(conn net.Conn, req proto.Request) {
 		return
 	}
 
-	worktreeDir := inst.WorktreeDir
-	branch := inst.Branch
-	containerID := inst.ContainerID
-	composeProject := inst.ComposeProject
-	projectName := inst.Project
+	unlockProject, err := d.projectLocks.lock(inst.Project, "drop "+req.InstanceID, projectLockTimeout, io.Discard)
+	if err != nil {
+		respond(conn, proto.Response{OK: false, Error: err.Error()})
+		return
+	}
+	defer unlockProject()
 
-	// Kill the docker exec session (container keeps running until stopContainer).
-	inst.destroy()
+	residue := d.dropInstance(inst, dropOptions{KeepWorktree: req.KeepWorktree, KeepContainer: req.KeepContainer})
+
+	respond(conn, proto.Response{OK: true, Residue: residue})
+}
 
-	// Stop and remove the container (or compose stack).
-	stopContainer(containerID, composeProject)
+// dropOptions leaves parts of an instance in place when it is dropped.
+type dropOptions struct {
+	KeepWorktree  bool // the worktree and branch, or a scratch instance's clone
+	KeepContainer bool // the container or compose stack, still running
+}
 
-	// Derive mainDir from the project and daemon root — explicit and resilient.
-	mainDir := filepath.Join(d.rootDir, "projects", projectName, "main")
+// dropInstance kills inst's agent, tears down its container, removes its
+// worktree and branch, and forgets it, except for what opts keeps and what
+// grove container rm or grove worktree rm removed already.  It returns what
+// it could not remove, which the instance is forgotten without.  The caller
+// must hold the project lock.
+func (d *Daemon) dropInstance(inst *Instance, opts dropOptions) []string {
+	// Kill the docker exec session (container keeps running until stopContainer).
+	inst.destroy()
+	inst.destroyAux()
+
+	containerRemoved, worktreeRemoved := inst.teardownRemoved()
+	switch {
+	case opts.KeepContainer && !containerRemoved:
+		inst.destroyHelpers()
+		log.Printf("instance %s: dropped, keeping container %s", inst.ID, inst.ContainerID)
+	case !containerRemoved:
+		// Stop and remove the container (or compose stack).
+		stopContainer(inst.ContainerID, inst.ComposeProject)
+	}
 
-	if out, err := exec.Command("git", "-C", mainDir, "worktree", "remove", "--force", worktreeDir).CombinedOutput(); err != nil {
-		log.Printf("instance %s: git worktree remove failed: %v: %s", req.InstanceID, err, out)
+	var residue []string
+	leftover := func(err error) {
+		if err != nil {
+			log.Printf("instance %s: %v", inst.ID, err)
+			residue = append(residue, err.Error())
+		}
 	}
-	if out, err := exec.Command("git", "-C", mainDir, "branch", "-D", branch).CombinedOutput(); err != nil {
-		log.Printf("instance %s: git branch -D failed: %v: %s", req.InstanceID, err, out)
+	switch {
+	case opts.KeepWorktree:
+		log.Printf("instance %s: dropped, keeping worktree %s and branch %s", inst.ID, inst.WorktreeDir, inst.Branch)
+	case inst.Scratch == nil:
+		if !worktreeRemoved {
+			leftover(removeWorktreeDir(inst.MainDir, inst.WorktreeDir, "instance "+inst.ID))
+		}
+		leftover(deleteBranch(inst.MainDir, inst.Branch))
+	case !inst.Scratch.InPlace:
+		// The scratch clone goes, and the worktree and branch with it.  An
+		// in-place directory is the user's and stays.
+		leftover(os.RemoveAll(inst.Scratch.DataDir))
 	}
 
 	d.mu.Lock()
-	delete(d.instances, req.InstanceID)
+	delete(d.instances, inst.ID)
 	d.mu.Unlock()
 
-	os.Remove(filepath.Join(d.rootDir, "instances", req.InstanceID+".json"))
-
-	respond(conn, proto.Response{OK: true})
+	os.Remove(filepath.Join(d.rootDir, "instances", inst.ID+".json"))
+	os.Remove(configSnapshotPath(filepath.Join(d.rootDir, "instances"), inst.ID))
+	os.Remove(pendingFinishPath(filepath.Join(d.rootDir, "instances"), inst.ID))
+	return residue
 }
 
 func (d *Daemon) handleFinish(conn net.Conn, req proto.Request) {
@@ -371,19 +964,51 @@ func (d *Daemon) handleFinish(conn net.Conn, req proto.Request) {
 
 	worktreeDir := inst.WorktreeDir
 	branch := inst.Branch
-	projectName := inst.Project
-
+	// A FINISHED instance is finished again without running anything.
 	inst.mu.Lock()
-	state := inst.state
-	switch state {
-	case proto.StateExited, proto.StateCrashed, proto.StateKilled:
-		// Process already dead; transition to FINISHED directly.
-		inst.state = proto.StateFinished
-		inst.mu.Unlock()
-	case proto.StateFinished:
-		// Already finished; respond and skip finish commands.
-		inst.mu.Unlock()
-		respond(conn, proto.Response{OK: true, WorktreeDir: worktreeDir, Branch: branch})
+	finished := inst.state == proto.StateFinished
+	inst.mu.Unlock()
+	if resp := inst.removedError("finish", true, true); resp != nil && !finished {
+		respond(conn, *resp)
+		return
+	}
+
+	// Refuse before stopping the agent if a finish command needs a terminal
+	// this client cannot give it.
+	if !req.Interactive {
+		if p, err := d.instanceProject(inst, req.CurrentConfig); err == nil && !finished {
+			if err := requireTerminal("finish", p.Finish); err != nil {
+				respond(conn, proto.Response{OK: false, Error: err.Error()})
+				return
+			}
+		}
+	}
+
+	endOp, err := inst.beginOperation(opFinish)
+	if err != nil {
+		respond(conn, errorResponse(err))
+		return
+	}
+	defer endOp()
+
+	inst.mu.Lock()
+	state := inst.state
+	switch state {
+	case proto.StateExited, proto.StateCrashed, proto.StateKilled, proto.StateStalled, proto.StateFinishFailed:
+		// Process already dead (or an earlier finish was cut short);
+		// transition to FINISHED directly and run the commands.
+		inst.setState(proto.StateFinished)
+		inst.mu.Unlock()
+		inst.destroyHelpers()
+	case proto.StateBroken:
+		inst.mu.Unlock()
+		respond(conn, proto.Response{OK: false, Error: "cannot finish: instance is " + state, Hint: brokenHint(inst.ID)})
+		return
+	case proto.StateFinished:
+		// Already finished; respond and skip finish commands.
+		inst.mu.Unlock()
+		respond(conn, proto.Response{OK: true, WorktreeDir: worktreeDir, Branch: branch})
+		proto.WriteResultTrailer(conn, streamResult(time.Now(), nil))
 		return
 	default:
 		// Agent is alive; request finish and wait for ptyReader to exit.
@@ -395,6 +1020,7 @@ func (d *Daemon) handleFinish(conn net.Conn, req proto.Request) {
 			<-processDone
 		}
 	}
+	inst.waitHelpers()
 
 	// Persist FINISHED state. (ptyReader may have already done this if it ran,
 	// but an extra write is harmless.)
@@ -402,15 +1028,31 @@ func (d *Daemon) handleFinish(conn net.Conn, req proto.Request) {
 
 	// Send ACK — instance is now FINISHED regardless of what complete commands do.
 	respond(conn, proto.Response{OK: true, WorktreeDir: worktreeDir, Branch: branch})
+	var stdin *clientStdin
+	if req.Interactive {
+		stdin = newClientStdin(conn)
+	}
+
+	started := time.Now()
+	var results []proto.CommandResult
+	var failure string
+	// The trailer goes to conn only; it is protocol, not log output.
+	defer func() {
+		res := streamResult(started, results)
+		if failure != "" {
+			res.OK = false
+			res.Error = failure
+		}
+		d.recordFinish(inst, res, finishSummary(res))
+		proto.WriteResultTrailer(conn, res)
+	}()
 
-	p, err := loadProject(d.rootDir, projectName)
+	p, err := d.instanceProject(inst, req.CurrentConfig)
 	if err != nil {
 		fmt.Fprintf(conn, "warning: could not load project to run finish commands: %v\n", err)
+		failure = "could not load project: " + err.Error()
 		return
 	}
-	if _, err := loadInRepoConfig(p); err != nil {
-		log.Printf("warning: could not read grove.yaml for %s: %v", projectName, err)
-	}
 	if len(p.Finish) == 0 {
 		return
 	}
@@ -427,17 +1069,14 @@ func (d *Daemon) handleFinish(conn net.Conn, req proto.Request) {
 	// receiving output and commands run to completion.
 	w := newResilientWriter(conn, logFd)
 
-	containerID := inst.ContainerID
-
-	for _, cmdStr := range p.Finish {
-		expanded := strings.ReplaceAll(cmdStr, "{{branch}}", branch)
-		fmt.Fprintf(w, "$ %s\n", expanded)
-		if err := execInContainer(containerID, expanded, w); err != nil {
-			fmt.Fprintf(w, "error: command failed: %v\n", err)
-			log.Printf("instance %s: finish command failed: %v", inst.ID, err)
-			return
-		}
+	if err := d.resumeStack(inst, w); err != nil {
+		fmt.Fprintf(w, "error: %v\n", err)
+		failure = err.Error()
+		return
 	}
+
+	pf := &pendingFinish{Started: started.Unix(), Remaining: resolveFinishCommands(p, branch)}
+	results = d.runFinishCommands(inst, pf, false, stdin, w)
 }
 
 func (d *Daemon) handleCheck(conn net.Conn, req proto.Request) {
@@ -446,64 +1085,77 @@ func (d *Daemon) handleCheck(conn net.Conn, req proto.Request) {
 		respond(conn, proto.Response{OK: false, Error: "instance not found: " + req.InstanceID})
 		return
 	}
-
-	projectName := inst.Project
-
-	inst.mu.Lock()
-	state := inst.state
-	if proto.IsTerminal(state) || state == proto.StateChecking {
-		inst.mu.Unlock()
-		respond(conn, proto.Response{OK: false, Error: "cannot check: instance is " + state})
+	if resp := inst.removedError("check", true, true); resp != nil {
+		respond(conn, *resp)
 		return
 	}
-	inst.state = proto.StateChecking
-	inst.mu.Unlock()
 
-	defer func() {
-		inst.mu.Lock()
-		if inst.state == proto.StateChecking {
-			inst.state = proto.StateWaiting
-		}
-		inst.mu.Unlock()
-	}()
-
-	p, err := loadProject(d.rootDir, projectName)
+	p, err := d.instanceProject(inst, req.CurrentConfig)
 	if err != nil {
 		respond(conn, proto.Response{OK: false, Error: err.Error()})
 		return
 	}
-	if _, err := loadInRepoConfig(p); err != nil {
-		log.Printf("warning: could not read grove.yaml for %s: %v", projectName, err)
-	}
-	if len(p.Check) == 0 {
+	if len(p.Check.Commands) == 0 {
 		respond(conn, proto.Response{OK: false, Error: "no check commands defined in grove.yaml"})
 		return
 	}
+	if req.Watch {
+		d.watchChecks(conn, inst, p)
+		return
+	}
+	if !req.Interactive {
+		if err := requireTerminal("check", p.Check.Commands); err != nil {
+			respond(conn, proto.Response{OK: false, Error: err.Error()})
+			return
+		}
+	}
+
+	endOp, err := inst.beginOperation(opCheck)
+	if err != nil {
+		respond(conn, errorResponse(err))
+		return
+	}
+	defer endOp()
+
+	after, err := inst.beginCheck(p.Check.maxDuration(), false, req.Force)
+	if err != nil {
+		respond(conn, proto.Response{OK: false, Error: err.Error()})
+		return
+	}
 
 	respond(conn, proto.Response{OK: true})
+	var stdin *clientStdin
+	if req.Interactive {
+		stdin = newClientStdin(conn)
+	}
 
 	logFd, _ := os.OpenFile(inst.LogFile, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0o644)
 	if logFd != nil {
 		defer logFd.Close()
 	}
 
-	w := newResilientWriter(conn, logFd)
+	res := d.runChecks(inst, p, after, stdin, newResilientWriter(conn, logFd), false)
+	d.publishCheck(inst, res)
+	proto.WriteResultTrailer(conn, res)
+}
 
-	containerID := inst.ContainerID
-
-	var wg sync.WaitGroup
-	for _, cmdStr := range p.Check {
-		wg.Add(1)
-		go func(cmd string) {
-			defer wg.Done()
-			fmt.Fprintf(w, "$ %s\n", cmd)
-			if err := execInContainer(containerID, cmd, w); err != nil {
-				fmt.Fprintf(w, "error: check command failed: %v\n", err)
-				log.Printf("instance %s: check command %q failed: %v", inst.ID, cmd, err)
-			}
-		}(cmdStr)
+// streamResult builds the trailer for the commands run since started; it is
+// OK only if every command exited zero.
+func streamResult(started time.Time, results []proto.CommandResult) proto.StreamResult {
+	res := proto.StreamResult{
+		OK:         true,
+		Commands:   results,
+		DurationMs: time.Since(started).Milliseconds(),
 	}
-	wg.Wait()
+	if res.Commands == nil {
+		res.Commands = []proto.CommandResult{}
+	}
+	for _, r := range results {
+		if r.ExitCode != 0 {
+			res.OK = false
+		}
+	}
+	return res
 }
 
 func (d *Daemon) handleRestart(conn net.Conn, req proto.Request) {
@@ -513,6 +1165,13 @@ func (d *Daemon) handleRestart(conn net.Conn, req proto.Request) {
 		return
 	}
 
+	endOp, err := inst.beginOperation(opRestart)
+	if err != nil {
+		respond(conn, errorResponse(err))
+		return
+	}
+	defer endOp()
+
 	inst.mu.Lock()
 	state := inst.state
 	inst.mu.Unlock()
@@ -521,41 +1180,549 @@ func (d *Daemon) handleRestart(conn net.Conn, req proto.Request) {
 		respond(conn, proto.Response{OK: false, Error: "cannot restart: instance is " + state})
 		return
 	}
+	if state == proto.StateBroken {
+		respond(conn, proto.Response{OK: false, Error: "cannot restart: instance is " + state, Hint: brokenHint(inst.ID)})
+		return
+	}
+	if state == proto.StateFailedSetup && !req.RecreateContainer {
+		respond(conn, proto.Response{OK: false, Error: "cannot restart: instance is " + state, Hint: failedSetupHint(inst.ID)})
+		return
+	}
+	if resp := inst.removedError("restart", true, true); resp != nil && !req.RecreateContainer {
+		respond(conn, *resp)
+		return
+	}
+	if err := d.checkInstanceDataDir(inst); err != nil {
+		respond(conn, errorResponse(err))
+		return
+	}
 
-	p, err := loadProject(d.rootDir, inst.Project)
+	p, err := d.relaunchProject(inst, req.CurrentConfig || req.RecreateContainer)
 	if err != nil {
 		respond(conn, proto.Response{OK: false, Error: err.Error()})
 		return
 	}
 
-	if _, err := loadInRepoConfig(p); err != nil {
-		log.Printf("warning: could not read grove.yaml for %s: %v", inst.Project, err)
-	}
-
 	agentCmd := p.Agent.Command
 	if agentCmd == "" {
 		agentCmd = "sh"
 	}
 
+	// With --recreate-container, setup output is logged and streamed after
+	// the response, as for reopen.
+	var outputBuf bytes.Buffer
+	setupW := io.Discard
+	if req.RecreateContainer {
+		setupW = &outputBuf
+		logFd, _ := os.OpenFile(inst.LogFile, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0o644)
+		if logFd != nil {
+			defer logFd.Close()
+			setupW = io.MultiWriter(&outputBuf, logFd)
+		}
+		setup := beginSetupSection(logFd)
+		defer setup.end()
+		if restored, err := d.restoreWorktree(inst, setupW); err != nil {
+			inst.addSetupLog(setup.end())
+			respond(conn, proto.Response{OK: false, Error: err.Error()})
+			return
+		} else if restored {
+			// The branch's own grove.yaml is back.
+			if p, err = d.relaunchProject(inst, true); err != nil {
+				inst.addSetupLog(setup.end())
+				respond(conn, proto.Response{OK: false, Error: err.Error()})
+				return
+			}
+			if agentCmd = p.Agent.Command; agentCmd == "" {
+				agentCmd = "sh"
+			}
+		}
+		if err := d.recreateContainer(inst, p, agentCmd, setupW); err != nil {
+			inst.addSetupLog(setup.end())
+			respond(conn, proto.Response{OK: false, Error: err.Error(), Hint: failedSetupHint(inst.ID)})
+			return
+		}
+		prepareContextFile(inst.ID, p, inst.WorktreeDir, setupW)
+		inst.addSetupLog(setup.end())
+		log.Printf("instance %s: recreated container %s", inst.ID, inst.ContainerID)
+	} else {
+		if err := d.resumeStack(inst, io.Discard); err != nil {
+			respond(conn, proto.Response{OK: false, Error: err.Error()})
+			return
+		}
+		prepareContextFile(inst.ID, p, inst.WorktreeDir, io.Discard)
+	}
+	if err := d.relaunchAgent(inst, p, agentCmd, req); err != nil {
+		respond(conn, proto.Response{OK: false, Error: err.Error()})
+		return
+	}
+
+	respond(conn, proto.Response{OK: true})
+	if outputBuf.Len() > 0 {
+		conn.Write(outputBuf.Bytes())
+	}
+}
+
+// relaunchAgent starts a new agent session for an existing instance whose
+// container is running, in the mode it was started in, resuming the
+// previous session unless req.Fresh.  p becomes the instance's recorded
+// config, so a relaunch with the current config sticks.  Shared by restart
+// and reopen.
+func (d *Daemon) relaunchAgent(inst *Instance, p *Project, agentCmd string, req proto.Request) error {
+	if inst.Mode == proto.ModeAuto && !p.allowsAuto() {
+		return errAutoForbidden(inst.Project)
+	}
+
 	// Reset mutable state before restarting.
 	inst.mu.Lock()
 	inst.endedAt = time.Time{}
 	inst.finishRequest = false
 	inst.killed = false
+	inst.endReason = ""
+	inst.exitCode = 0
+	inst.stalled = ""
+	inst.broken = ""
+	inst.loop = loopDetector{}
+	inst.Launch = p.launchConfig()
 	inst.mu.Unlock()
 
-	agentEnv := envfile.Load(filepath.Join(d.rootDir, "env"))
-	for k, v := range req.AgentEnv {
-		agentEnv[k] = v
+	// Re-apply git.exclude, which may have changed since the last launch.
+	if unlock, err := d.projectLocks.lock(inst.Project, "relaunch "+inst.ID, projectLockTimeout, io.Discard); err == nil {
+		if err := applyGitExcludes(inst.WorktreeDir, p.Git.Exclude); err != nil {
+			log.Printf("instance %s: could not apply git.exclude: %v", inst.ID, err)
+		}
+		if len(p.Agent.ContextFiles) > 0 {
+			if err := excludeGeneratedFile(inst.WorktreeDir, contextFile); err != nil {
+				log.Printf("instance %s: could not exclude %s: %v", inst.ID, contextFile, err)
+			}
+		}
+		unlock()
 	}
+
+	agentEnv := d.launchEnv(inst, p.agentEnv(), req)
 	logAgentCredentials(inst.ID, agentEnv)
 
-	if err := inst.startAgent(agentCmd, p.Agent.Args, agentEnv); err != nil {
+	// The mode it was started in, then the previous agent session unless
+	// asked for a fresh one.
+	mArgs, _ := modeArgs(agentCmd, inst.Mode)
+	args := append(append([]string(nil), p.Agent.Args...), mArgs...)
+	resumeArgs := p.resumeArgs(agentCmd)
+	resumed := !req.Fresh && len(resumeArgs) > 0
+	if resumed {
+		args = append(args, resumeArgs...)
+	}
+
+	inst.setInitialSize(req.Cols, req.Rows, p)
+	if err := inst.startAgent(agentCmd, args, agentEnv); err != nil {
+		return err
+	}
+	inst.recordRun(agentCmd, args, resumed)
+	go d.measureUsageWhenDone(inst)
+	inst.forgetHelpers(p.helperAgents())
+	d.startHelpers(inst, p, req, io.Discard)
+
+	inst.persistMeta(filepath.Join(d.rootDir, "instances"))
+	d.writeConfigSnapshot(inst, p)
+	return nil
+}
+
+// launchEnv builds the environment an agent of inst is launched with: the
+// env file is the base, then vars from grove.yaml (see agentEnv); request-
+// level values (from the CLI prompt or host env) override both.
+func (d *Daemon) launchEnv(inst *Instance, vars map[string]string, req proto.Request) map[string]string {
+	env := envfile.Load(filepath.Join(d.rootDir, "env"))
+	for k, v := range vars {
+		env[k] = v
+	}
+	for k, v := range req.AgentEnv {
+		env[k] = v
+	}
+	if inst.Task != "" {
+		env["GROVE_TASK"] = inst.Task
+	}
+	return env
+}
+
+// startHelpers launches p's helper agents (see helpers.go) that are not
+// running.  A helper that fails to start is reported to w and the daemon
+// log; the instance carries on without it.
+func (d *Daemon) startHelpers(inst *Instance, p *Project, req proto.Request, w io.Writer) {
+	for _, a := range p.helperAgents() {
+		env := d.launchEnv(inst, p.helperEnv(a), req)
+		env["GROVE_AGENT"] = a.Name
+		if err := inst.startHelper(a, env); err != nil {
+			fmt.Fprintf(w, "warning: agent %s did not start: %v\n", a.Name, err)
+			log.Printf("instance %s: agent %s did not start: %v", inst.ID, a.Name, err)
+		}
+	}
+}
+
+// handleReopen picks a FINISHED instance back up, e.g. when review asks for
+// changes: it makes sure the container is running (recreating it against the
+// existing worktree if needed) and relaunches the agent.  Setup output is
+// streamed after the response, as for start.
+func (d *Daemon) handleReopen(conn net.Conn, req proto.Request) {
+	inst := d.getInstance(req.InstanceID)
+	if inst == nil {
+		respond(conn, proto.Response{OK: false, Error: "instance not found: " + req.InstanceID})
+		return
+	}
+
+	endOp, err := inst.beginOperation(opReopen)
+	if err != nil {
+		respond(conn, errorResponse(err))
+		return
+	}
+	defer endOp()
+
+	inst.mu.Lock()
+	state := inst.state
+	inst.mu.Unlock()
+	if state != proto.StateFinished && state != proto.StateFinishFailed {
+		respond(conn, proto.Response{OK: false, Error: "cannot reopen: instance is " + state + " (only FINISHED or FINISH_FAILED instances can be reopened; use restart)"})
+		return
+	}
+	if err := d.checkInstanceDataDir(inst); err != nil {
+		respond(conn, errorResponse(err))
+		return
+	}
+	// A worktree removed by grove worktree rm is checked out again below.
+	if _, removed := inst.teardownRemoved(); !removed {
+		if _, err := os.Stat(inst.WorktreeDir); err != nil {
+			respond(conn, proto.Response{OK: false, Error: fmt.Sprintf(
+				"worktree %s no longer exists; start a new instance instead: grove start %s %s",
+				inst.WorktreeDir, inst.Project, inst.Branch)})
+			return
+		}
+	}
+
+	p, err := d.relaunchProject(inst, req.CurrentConfig)
+	if err != nil {
+		respond(conn, proto.Response{OK: false, Error: err.Error()})
+		return
+	}
+
+	var outputBuf bytes.Buffer
+	var setupW io.Writer = &outputBuf
+	logFd, _ := os.OpenFile(inst.LogFile, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0o644)
+	if logFd != nil {
+		defer logFd.Close()
+		setupW = io.MultiWriter(&outputBuf, logFd)
+	}
+	setup := beginSetupSection(logFd)
+	defer setup.end()
+
+	agentCmd := p.Agent.Command
+	if agentCmd == "" {
+		agentCmd = "sh"
+	}
+
+	restored, err := d.restoreWorktree(inst, setupW)
+	if err != nil {
+		respond(conn, proto.Response{OK: false, Error: err.Error()})
+		return
+	}
+	if restored && req.CurrentConfig {
+		// The branch's own grove.yaml is back.
+		if p, err = d.relaunchProject(inst, true); err != nil {
+			respond(conn, proto.Response{OK: false, Error: err.Error()})
+			return
+		}
+		if agentCmd = p.Agent.Command; agentCmd == "" {
+			agentCmd = "sh"
+		}
+	}
+	if err := d.resumeStack(inst, setupW); err != nil {
+		respond(conn, proto.Response{OK: false, Error: err.Error()})
+		return
+	}
+	if restored || !containerRunning(inst.ContainerID) {
+		if err := d.recreateContainer(inst, p, agentCmd, setupW); err != nil {
+			respond(conn, proto.Response{OK: false, Error: err.Error(), Hint: failedSetupHint(inst.ID)})
+			return
+		}
+	} else if err := ensureAgentsInstalled(context.Background(), p, agentCmd, inst.ContainerID, setupW); err != nil {
+		respond(conn, proto.Response{OK: false, Error: err.Error()})
+		return
+	}
+
+	prepareContextFile(inst.ID, p, inst.WorktreeDir, setupW)
+	inst.addSetupLog(setup.end())
+	if err := d.relaunchAgent(inst, p, agentCmd, req); err != nil {
+		respond(conn, proto.Response{OK: false, Error: err.Error()})
+		return
+	}
+
+	inst.mu.Lock()
+	inst.notes = append(inst.notes, proto.Note{Time: time.Now().Unix(), Text: "reopened"})
+	inst.mu.Unlock()
+	inst.persistMeta(filepath.Join(d.rootDir, "instances"))
+	log.Printf("instance %s: reopened", inst.ID)
+
+	respond(conn, proto.Response{OK: true, InstanceID: inst.ID})
+	if outputBuf.Len() > 0 {
+		conn.Write(outputBuf.Bytes())
+	}
+}
+
+// recreateContainer replaces inst's container with a new one from p against
+// the existing worktree: it re-runs setup and installs agentCmd, writing the
+// output to w.  The instance's container name, compose project and image are
+// updated to the new container's.  Shared by reopen and restart
+// --recreate-container.
+//
+// If any step fails, whatever was created is removed and the instance is
+// left FAILED_SETUP, with the error in a note, rather than half-recreated.
+func (d *Daemon) recreateContainer(inst *Instance, p *Project, agentCmd string, w io.Writer) error {
+	// Clear the old container (or a stopped leftover) so the name is free.
+	stopContainer(inst.ContainerID, inst.ComposeProject)
+	inst.mu.Lock()
+	inst.stackStopped = false
+	inst.mu.Unlock()
+
+	composeProject := ""
+	if p.Container.Compose != "" {
+		composeProject = "grove-" + inst.ID
+	}
+	ctx := context.Background()
+	err := func() error {
+		if p.Container.Compose == "" {
+			if err := pullImage(ctx, p, w); err != nil {
+				return err
+			}
+		}
+		name, err := startContainer(ctx, p, inst.ID, inst.WorktreeDir, inst.Info().Labels, w)
+		if err != nil {
+			return err
+		}
+		// The name differs from the old one if the project moved between
+		// a single image and compose.
+		image := inspectImage(name)
+		if image != nil && image.HostPlatform != "" {
+			fmt.Fprint(w, emulationWarning(image, p.Container.Platform))
+		}
+		inst.mu.Lock()
+		inst.ContainerID = name
+		inst.ComposeProject = composeProject
+		inst.image = image
+		inst.containerRemoved = false
+		inst.mu.Unlock()
+		if p.Agent.Command == "claude" || p.Agent.Command == "" {
+			seedClaudeConfig(name)
+		}
+		if err := runStart(ctx, p, name, w); err != nil {
+			return err
+		}
+		return ensureAgentsInstalled(ctx, p, agentCmd, name, w)
+	}()
+	if err == nil {
+		return nil
+	}
+
+	// A single container is named grove-<id>; compose's go with the project.
+	stopContainer("grove-"+inst.ID, composeProject)
+	inst.mu.Lock()
+	inst.setState(proto.StateFailedSetup)
+	inst.image = nil
+	inst.notes = append(inst.notes, proto.Note{Time: time.Now().Unix(), Text: "recreating the container failed: " + err.Error()})
+	inst.mu.Unlock()
+	inst.persistMeta(filepath.Join(d.rootDir, "instances"))
+	log.Printf("instance %s: recreating the container failed: %v", inst.ID, err)
+	return err
+}
+
+// failedSetupHint is the hint sent with an error from recreateContainer.
+func failedSetupHint(id string) string {
+	return "The instance is FAILED_SETUP and has no container; fix the project's config, then run grove restart " + id + " --recreate-container."
+}
+
+func (d *Daemon) handleNote(conn net.Conn, req proto.Request) {
+	inst := d.getInstance(req.InstanceID)
+	if inst == nil {
+		respond(conn, proto.Response{OK: false, Error: "instance not found: " + req.InstanceID})
+		return
+	}
+
+	text := strings.TrimSpace(req.Text)
+	if !req.Clear && text == "" {
+		respond(conn, proto.Response{OK: false, Error: "note text required"})
+		return
+	}
+
+	inst.mu.Lock()
+	if req.Clear {
+		inst.notes = nil
+	} else {
+		inst.notes = append(inst.notes, proto.Note{Time: time.Now().Unix(), Text: text})
+	}
+	inst.mu.Unlock()
+
+	inst.persistMeta(filepath.Join(d.rootDir, "instances"))
+
+	respond(conn, proto.Response{OK: true, InstanceID: req.InstanceID})
+}
+
+func (d *Daemon) handleLabel(conn net.Conn, req proto.Request) {
+	inst := d.getInstance(req.InstanceID)
+	if inst == nil {
+		respond(conn, proto.Response{OK: false, Error: "instance not found: " + req.InstanceID})
+		return
+	}
+	if len(req.Labels) == 0 && len(req.RemoveLabels) == 0 {
+		respond(conn, proto.Response{OK: false, Error: "no labels to set or remove"})
+		return
+	}
+	if err := validateLabels(req.Labels); err != nil {
 		respond(conn, proto.Response{OK: false, Error: err.Error()})
 		return
 	}
 
+	// Docker labels are fixed at container creation, so only the instance
+	// record is updated here.
+	inst.mu.Lock()
+	for _, k := range req.RemoveLabels {
+		delete(inst.labels, k)
+	}
+	if len(req.Labels) > 0 && inst.labels == nil {
+		inst.labels = make(map[string]string, len(req.Labels))
+	}
+	for k, v := range req.Labels {
+		inst.labels[k] = v
+	}
+	inst.mu.Unlock()
+
 	inst.persistMeta(filepath.Join(d.rootDir, "instances"))
 
+	respond(conn, proto.Response{OK: true, InstanceID: req.InstanceID})
+}
+
+// handlePin pins or unpins an instance.  The bulk commands that would
+// remove or stop it leave it alone, in the CLI (prune, stop --all) and in
+// the daemon (its time to live, see expiry); grove drop asks again.
+func (d *Daemon) handlePin(conn net.Conn, req proto.Request) {
+	inst := d.getInstance(req.InstanceID)
+	if inst == nil {
+		respond(conn, proto.Response{OK: false, Error: "instance not found: " + req.InstanceID})
+		return
+	}
+	inst.mu.Lock()
+	inst.pinned = req.Pin
+	inst.mu.Unlock()
+	inst.persistMeta(filepath.Join(d.rootDir, "instances"))
+	if req.Pin {
+		log.Printf("instance %s: pinned", inst.ID)
+	} else {
+		log.Printf("instance %s: unpinned", inst.ID)
+	}
+	respond(conn, proto.Response{OK: true, InstanceID: inst.ID, Instances: []proto.InstanceInfo{inst.Info()}})
+}
+
+// validateLabels rejects label keys that cannot round-trip through the
+// key=value CLI syntax or be used as a Docker label suffix.
+func validateLabels(labels map[string]string) error {
+	for k := range labels {
+		if k == "" {
+			return fmt.Errorf("label key must not be empty")
+		}
+		if strings.ContainsAny(k, "= \t\n") {
+			return fmt.Errorf("invalid label key %q: must not contain '=' or whitespace", k)
+		}
+	}
+	return nil
+}
+
+// handleCopy streams a file or directory out of, or into, an instance
+// worktree as a tar archive.  Paths that resolve outside the worktree are
+// rejected before any data is transferred.
+func (d *Daemon) handleCopy(conn net.Conn, req proto.Request) {
+	inst := d.getInstance(req.InstanceID)
+	if inst == nil {
+		respond(conn, proto.Response{OK: false, Error: "instance not found: " + req.InstanceID})
+		return
+	}
+	if resp := inst.removedError("copy", false, true); resp != nil {
+		respond(conn, *resp)
+		return
+	}
+
+	target, err := archive.SafeJoin(inst.WorktreeDir, req.Path)
+	if err != nil {
+		respond(conn, proto.Response{OK: false, Error: err.Error()})
+		return
+	}
+
+	switch req.Direction {
+	case proto.CopyOut:
+		if _, err := os.Lstat(target); err != nil {
+			respond(conn, proto.Response{OK: false, Error: "no such file in worktree: " + req.Path})
+			return
+		}
+		respond(conn, proto.Response{OK: true})
+		if err := archive.Write(conn, target); err != nil {
+			log.Printf("instance %s: copy out %q failed: %v", inst.ID, req.Path, err)
+		}
+
+	case proto.CopyIn:
+		respond(conn, proto.Response{OK: true})
+		if err := archive.Extract(conn, target); err != nil {
+			log.Printf("instance %s: copy in %q failed: %v", inst.ID, req.Path, err)
+			respond(conn, proto.Response{OK: false, Error: err.Error()})
+			return
+		}
+		respond(conn, proto.Response{OK: true})
+
+	default:
+		respond(conn, proto.Response{OK: false, Error: "unknown copy direction: " + req.Direction})
+	}
+}
+
+// handleStats samples CPU, memory, and network usage for every instance whose
+// container is running, so the client does not need direct Docker access.
+func (d *Daemon) handleStats(conn net.Conn) {
+	samples, err := containerStats()
+	if err != nil {
+		respond(conn, proto.Response{OK: false, Error: err.Error()})
+		return
+	}
+
+	d.mu.Lock()
+	infos := make([]proto.InstanceInfo, 0, len(d.instances))
+	for _, inst := range d.instances {
+		infos = append(infos, inst.Info())
+	}
+	d.mu.Unlock()
+
+	stats := make([]proto.InstanceStats, 0, len(infos))
+	for _, info := range infos {
+		s, ok := samples[info.ContainerID]
+		if !ok {
+			continue
+		}
+		s.ID = info.ID
+		s.Project = info.Project
+		s.Branch = info.Branch
+		s.State = info.State
+		stats = append(stats, s)
+	}
+
+	respond(conn, proto.Response{OK: true, Stats: stats})
+}
+
+// handleGC removes leftovers no instance can use any more: dangling
+// container.scratch volumes, and the git records of worktrees whose
+// directories are gone.  Progress is streamed after the response, ending
+// with a result trailer.
+func (d *Daemon) handleGC(conn net.Conn) {
 	respond(conn, proto.Response{OK: true})
+	started := time.Now()
+	w := newResilientWriter(conn, nil)
+	res := streamResult(started, nil)
+	for _, sweep := range []func(io.Writer) error{removeDanglingScratch, d.pruneProjectWorktrees} {
+		if err := sweep(w); err != nil {
+			fmt.Fprintf(w, "error: %v\n", err)
+			res.OK = false
+			res.Error = err.Error()
+		}
+	}
+	log.Printf("gc: ok=%v", res.OK)
+	proto.WriteResultTrailer(conn, res)
 }
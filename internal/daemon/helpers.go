@@ -0,0 +1,453 @@
+package daemon
+
+// helpers.go – helper agents.
+//
+// grove.yaml's agents list names the agents an instance runs.  The first is
+// the primary agent, which the rest of the daemon knows as the instance's
+// agent: attach connects to it, its exit decides the instance's state, and
+// restart resumes it.  The others are helpers, e.g. a read-only reviewer.
+// Each helper runs in its own PTY in the same container, keeps its own
+// rolling buffer and log file (<root>/logs/<id>-<name>.log), and is attached
+// to with grove attach --agent <name>.  While a helper runs, an instance
+// whose primary agent has exited still reports RUNNING.
+//
+// Helpers start after the primary agent on start, restart and reopen (those
+// still running are left alone) and are killed whenever the primary is.
+// They are always started afresh: resume args apply to the primary only.
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/creack/pty"
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+// helperAgent is one helper agent of an instance.  All fields other than
+// name and logFile are protected by the instance's mu.
+type helperAgent struct {
+	name    string
+	logFile string
+
+	argv           []string // command and arguments of the last launch
+	state          string   // RUNNING, EXITED, CRASHED or KILLED
+	stateChangedAt time.Time
+	pid            int
+	ptm            *os.File // nil after the process exits
+	logBuf         []byte
+	lastOutputTime time.Time
+	endedAt        time.Time
+	attachedConn   net.Conn
+	attachedOut    io.Writer
+	attachedFrames *proto.FrameWriter
+	killed         bool
+	processDone    chan struct{} // closed when the process has fully exited
+}
+
+// helperLogFile returns where instance id's helper agent name logs, next to
+// the instance's own log file logFile.
+func helperLogFile(logFile, id, name string) string {
+	return filepath.Join(filepath.Dir(logFile), id+"-"+name+".log")
+}
+
+// setState moves h to state, noting when it changed.  The caller holds
+// inst.mu.
+func (h *helperAgent) setState(state string) {
+	if state != h.state || h.stateChangedAt.IsZero() {
+		h.stateChangedAt = time.Now()
+	}
+	h.state = state
+}
+
+// currentState returns h's state at now as Instance.currentState does for
+// the primary agent: RUNNING becomes WAITING after a silence.  The caller
+// holds inst.mu.
+func (h *helperAgent) currentState(now time.Time) (string, time.Time) {
+	if h.state == proto.StateRunning && !h.lastOutputTime.IsZero() &&
+		now.Sub(h.lastOutputTime) > waitingIdleThreshold {
+		return proto.StateWaiting, h.lastOutputTime
+	}
+	return h.state, h.stateChangedAt
+}
+
+// attachState returns h's state for an attached client.  The caller holds
+// inst.mu.
+func (h *helperAgent) attachState(now time.Time) proto.AttachState {
+	state, since := h.currentState(now)
+	st := proto.AttachState{State: state}
+	if !since.IsZero() {
+		st.StateSince = since.Unix()
+	}
+	return st
+}
+
+// helper returns the helper agent called name, or nil.  The caller holds
+// inst.mu.
+func (inst *Instance) helper(name string) *helperAgent {
+	for _, h := range inst.helpers {
+		if h.name == name {
+			return h
+		}
+	}
+	return nil
+}
+
+// helperRunning reports whether any helper agent is running.  The caller
+// holds inst.mu.
+func (inst *Instance) helperRunning() bool {
+	for _, h := range inst.helpers {
+		if h.ptm != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// helperInfo describes the helper agents for InstanceInfo.  The caller holds
+// inst.mu.
+func (inst *Instance) helperInfo() []proto.AgentInfo {
+	if len(inst.helpers) == 0 {
+		return nil
+	}
+	now := time.Now()
+	infos := make([]proto.AgentInfo, 0, len(inst.helpers))
+	for _, h := range inst.helpers {
+		state, since := h.currentState(now)
+		info := proto.AgentInfo{Name: h.name, Argv: h.argv, State: state, PID: h.pid}
+		if !since.IsZero() {
+			info.StateSince = since.Unix()
+		}
+		if !h.endedAt.IsZero() {
+			info.EndedAt = h.endedAt.Unix()
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// restoreHelpers recreates the helper agents recorded in infos by an earlier
+// daemon.  Their processes died with it, so those that were running are
+// CRASHED.
+func (inst *Instance) restoreHelpers(infos []proto.AgentInfo, now time.Time) {
+	for _, info := range infos {
+		h := &helperAgent{
+			name:    info.Name,
+			logFile: helperLogFile(inst.LogFile, inst.ID, info.Name),
+			argv:    info.Argv,
+			state:   info.State,
+		}
+		if info.StateSince > 0 {
+			h.stateChangedAt = time.Unix(info.StateSince, 0)
+		}
+		if info.EndedAt > 0 {
+			h.endedAt = time.Unix(info.EndedAt, 0)
+		}
+		if !proto.IsTerminal(h.state) {
+			h.state = proto.StateCrashed
+			h.stateChangedAt, h.endedAt = now, now
+		}
+		inst.helpers = append(inst.helpers, h)
+	}
+}
+
+// startHelper launches helper agent a in the instance's container, unless
+// it is already running.
+func (inst *Instance) startHelper(a AgentSpec, env map[string]string) error {
+	inst.mu.Lock()
+	h := inst.helper(a.Name)
+	if h != nil && h.ptm != nil {
+		inst.mu.Unlock()
+		return nil
+	}
+	if h == nil {
+		h = &helperAgent{name: a.Name, logFile: helperLogFile(inst.LogFile, inst.ID, a.Name)}
+		inst.helpers = append(inst.helpers, h)
+	}
+	inst.mu.Unlock()
+
+	cmd := inst.agentCommand(a.Command, a.Args, env)
+	ptm, err := pty.StartWithSize(cmd, inst.ptySize())
+	if err != nil {
+		return fmt.Errorf("pty.Start: %w", err)
+	}
+
+	inst.mu.Lock()
+	h.argv = append([]string{a.Command}, a.Args...)
+	h.ptm = ptm
+	h.pid = cmd.Process.Pid
+	h.setState(proto.StateRunning)
+	h.endedAt = time.Time{}
+	h.killed = false
+	h.logBuf = h.logBuf[:0]
+	h.lastOutputTime = time.Time{}
+	h.processDone = make(chan struct{})
+	inst.mu.Unlock()
+
+	go inst.helperReader(h, cmd)
+	return nil
+}
+
+// forgetHelpers drops the records of helper agents that are not running
+// and not in keep, e.g. after a relaunch with a config that no longer has
+// them.
+func (inst *Instance) forgetHelpers(keep []AgentSpec) {
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+	kept := inst.helpers[:0]
+	for _, h := range inst.helpers {
+		wanted := h.ptm != nil
+		for _, a := range keep {
+			wanted = wanted || a.Name == h.name
+		}
+		if wanted {
+			kept = append(kept, h)
+		}
+	}
+	inst.helpers = kept
+}
+
+// helperReader drains h's PTY into its log file, its rolling buffer and the
+// attached client, if any, as ptyReader does for the primary agent, and
+// records how the process ended.
+func (inst *Instance) helperReader(h *helperAgent, cmd *exec.Cmd) {
+	logFd, err := os.OpenFile(h.logFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		log.Printf("instance %s: cannot open log file of agent %s: %v", inst.ID, h.name, err)
+	}
+	defer func() {
+		if logFd != nil {
+			logFd.Close()
+		}
+	}()
+
+	inst.mu.Lock()
+	ptm := h.ptm
+	inst.mu.Unlock()
+
+	buf := make([]byte, 4096)
+	for {
+		n, err := ptm.Read(buf)
+		if n > 0 {
+			chunk := buf[:n]
+			if logFd != nil {
+				logFd.Write(chunk)
+			}
+
+			inst.mu.Lock()
+			now := time.Now()
+			if state, _ := h.currentState(now); state == proto.StateWaiting {
+				h.stateChangedAt = now
+			}
+			h.logBuf = append(h.logBuf, chunk...)
+			if len(h.logBuf) > maxLogBytes {
+				h.logBuf = h.logBuf[len(h.logBuf)-maxLogBytes:]
+			}
+			h.lastOutputTime = now
+			out := h.attachedOut
+			inst.mu.Unlock()
+
+			if out != nil {
+				out.Write(chunk)
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	waitErr := cmd.Wait()
+
+	inst.mu.Lock()
+	ptm.Close()
+	h.ptm = nil
+	h.endedAt = time.Now()
+	switch {
+	case h.killed:
+		h.setState(proto.StateKilled)
+	case waitErr == nil:
+		h.setState(proto.StateExited)
+	default:
+		h.setState(proto.StateCrashed)
+	}
+	conn := h.attachedConn
+	fw := h.attachedFrames
+	exit := proto.AttachExit{State: h.state, ExitCode: exitCode(waitErr)}
+	h.attachedConn = nil
+	h.attachedOut = nil
+	h.attachedFrames = nil
+	instancesDir := inst.InstancesDir
+	processDone := h.processDone
+	inst.mu.Unlock()
+
+	if conn != nil {
+		if fw != nil {
+			inst.writeJSONFrame(fw, proto.AttachFrameExit, exit)
+		}
+		conn.Close()
+	}
+
+	log.Printf("instance %s: agent %s exited (%v)", inst.ID, h.name, waitErr)
+
+	if instancesDir != "" {
+		inst.persistMeta(instancesDir)
+	}
+	close(processDone)
+}
+
+// attachHelper connects a client to h's PTY, as Attach does for the primary
+// agent: it replays h's recent output, then passes input and output along
+// until the client detaches or the helper exits.
+func (inst *Instance) attachHelper(h *helperAgent, conn net.Conn, v2 bool) {
+	inst.mu.Lock()
+	if h.attachedConn != nil {
+		inst.mu.Unlock()
+		fmt.Fprintf(conn, `{"ok":false,"error":"already attached"}`+"\n")
+		return
+	}
+
+	replay := make([]byte, len(h.logBuf))
+	copy(replay, h.logBuf)
+
+	var out io.Writer = conn
+	var fw *proto.FrameWriter
+	if v2 {
+		fw = proto.NewFrameWriter(conn)
+		out = dataFrameWriter{inst, fw}
+	}
+
+	ptm := h.ptm
+	if ptm != nil {
+		h.attachedConn = conn
+		h.attachedOut = out
+		h.attachedFrames = fw
+	}
+	cols, rows := inst.cols, inst.rows
+	inst.mu.Unlock()
+
+	if ptm != nil && cols > 0 && rows > 0 {
+		pty.Setsize(ptm, &pty.Winsize{Cols: cols, Rows: rows})
+	}
+	if len(replay) > 0 {
+		if _, err := out.Write(replay); err != nil {
+			conn.Close()
+		}
+	}
+	if ptm == nil {
+		conn.Close()
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer func() {
+			inst.mu.Lock()
+			if h.attachedConn == conn {
+				h.attachedConn = nil
+				h.attachedOut = nil
+				h.attachedFrames = nil
+			}
+			inst.mu.Unlock()
+			conn.Close()
+			close(done)
+		}()
+		inst.readAttachInput(conn, func() *os.File { return h.ptm })
+	}()
+
+	if v2 {
+		go inst.sendAttachState(fw, done, h.attachState)
+	}
+	<-done
+}
+
+// destroyHelpers kills every running helper agent.
+func (inst *Instance) destroyHelpers() {
+	inst.mu.Lock()
+	var running []*helperAgent
+	for _, h := range inst.helpers {
+		if h.ptm != nil {
+			h.killed = true
+			running = append(running, h)
+		}
+	}
+	inst.mu.Unlock()
+
+	for _, h := range running {
+		inst.mu.Lock()
+		pid, ptm, conn := h.pid, h.ptm, h.attachedConn
+		inst.mu.Unlock()
+		killProcessGroup(pid)
+		if ptm != nil {
+			ptm.Close()
+		}
+		if conn != nil {
+			conn.Close()
+		}
+	}
+}
+
+// waitHelpers blocks until every helper agent that was running has exited.
+func (inst *Instance) waitHelpers() {
+	inst.mu.Lock()
+	var pending []chan struct{}
+	for _, h := range inst.helpers {
+		if h.ptm != nil && h.processDone != nil {
+			pending = append(pending, h.processDone)
+		}
+	}
+	inst.mu.Unlock()
+	for _, done := range pending {
+		<-done
+	}
+}
+
+// agentByName returns the helper agent called name, or nil for the primary
+// agent: an empty name or the primary's own name in the agents list.
+func (inst *Instance) agentByName(name string) (*helperAgent, error) {
+	if name == "" {
+		return nil, nil
+	}
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+	if h := inst.helper(name); h != nil {
+		return h, nil
+	}
+	if inst.Launch != nil && inst.Launch.AgentName == name {
+		return nil, nil
+	}
+	names := make([]string, 0, len(inst.helpers))
+	for _, h := range inst.helpers {
+		names = append(names, h.name)
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("instance %s has no agent %q: it runs only its primary agent", inst.ID, name)
+	}
+	return nil, fmt.Errorf("instance %s has no agent %q (helper agents: %s)", inst.ID, name, strings.Join(names, ", "))
+}
+
+// ensureAgentsInstalled makes sure the primary agent command agentCmd and
+// the commands of p's helper agents are available in the container; see
+// ensureAgentInstalled.
+func ensureAgentsInstalled(ctx context.Context, p *Project, agentCmd, containerName string, w io.Writer) error {
+	seen := map[string]bool{agentCmd: true}
+	if err := ensureAgentInstalled(ctx, agentCmd, containerName, w); err != nil {
+		return err
+	}
+	for _, a := range p.helperAgents() {
+		if seen[a.Command] {
+			continue
+		}
+		seen[a.Command] = true
+		if err := ensureAgentInstalled(ctx, a.Command, containerName, w); err != nil {
+			return fmt.Errorf("agent %s: %w", a.Name, err)
+		}
+	}
+	return nil
+}
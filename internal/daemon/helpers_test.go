@@ -0,0 +1,146 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeExecDocker installs a docker that runs "docker exec ... c1 cmd args"
+// as plain "cmd args" on the host.
+func fakeExecDocker(t *testing.T) {
+	t.Helper()
+	installFakeDocker(t, "while [ \"$1\" != c1 ]; do shift; done\nshift\nexec \"$@\"\n")
+}
+
+func helperTestInstance(t *testing.T) *Instance {
+	return &Instance{
+		ID:          "1",
+		ContainerID: "c1",
+		LogFile:     filepath.Join(t.TempDir(), "1.log"),
+		state:       proto.StateRunning,
+		Launch:      &proto.LaunchConfig{AgentName: "coder", AgentCommand: "claude"},
+	}
+}
+
+// helperState returns the state of inst's helper name.
+func helperState(inst *Instance, name string) string {
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+	return inst.helper(name).state
+}
+
+func TestHelperAgentRunsAndRollsUp(t *testing.T) {
+	fakeExecDocker(t)
+	inst := helperTestInstance(t)
+
+	reviewer := AgentSpec{Name: "reviewer", Command: "sh", Args: []string{"-c", "echo reviewing; sleep 30"}}
+	require.NoError(t, inst.startHelper(reviewer, nil))
+	require.Eventually(t, func() bool {
+		inst.mu.Lock()
+		defer inst.mu.Unlock()
+		return strings.Contains(string(inst.helper("reviewer").logBuf), "reviewing")
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Starting it again while it runs does nothing.
+	require.NoError(t, inst.startHelper(reviewer, nil))
+	info := inst.Info()
+	require.Len(t, info.Agents, 1)
+	assert.Equal(t, "reviewer", info.Agents[0].Name)
+	assert.Equal(t, []string{"sh", "-c", "echo reviewing; sleep 30"}, info.Agents[0].Argv)
+	assert.Contains(t, []string{proto.StateRunning, proto.StateWaiting}, info.Agents[0].State)
+
+	// The primary agent exiting leaves the instance RUNNING while the
+	// helper works.
+	inst.mu.Lock()
+	inst.setState(proto.StateExited)
+	inst.mu.Unlock()
+	assert.Equal(t, proto.StateRunning, inst.Info().State)
+
+	inst.destroyHelpers()
+	inst.waitHelpers()
+	assert.Equal(t, proto.StateKilled, helperState(inst, "reviewer"))
+	assert.Equal(t, proto.StateExited, inst.Info().State)
+
+	data, err := os.ReadFile(filepath.Join(filepath.Dir(inst.LogFile), "1-reviewer.log"))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "reviewing")
+}
+
+func TestHelperAgentExitStates(t *testing.T) {
+	fakeExecDocker(t)
+	inst := helperTestInstance(t)
+
+	require.NoError(t, inst.startHelper(AgentSpec{Name: "ok", Command: "true"}, nil))
+	require.NoError(t, inst.startHelper(AgentSpec{Name: "bad", Command: "sh", Args: []string{"-c", "exit 3"}}, nil))
+	require.Eventually(t, func() bool {
+		inst.mu.Lock()
+		defer inst.mu.Unlock()
+		return !inst.helperRunning()
+	}, 5*time.Second, 10*time.Millisecond)
+	inst.waitHelpers()
+
+	assert.Equal(t, proto.StateExited, helperState(inst, "ok"))
+	assert.Equal(t, proto.StateCrashed, helperState(inst, "bad"))
+	for _, a := range inst.Info().Agents {
+		assert.NotZero(t, a.EndedAt, a.Name)
+	}
+}
+
+func TestAgentByName(t *testing.T) {
+	inst := helperTestInstance(t)
+
+	_, err := inst.agentByName("reviewer")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "runs only its primary agent")
+
+	inst.helpers = []*helperAgent{{name: "reviewer"}, {name: "docs"}}
+	h, err := inst.agentByName("")
+	require.NoError(t, err)
+	assert.Nil(t, h)
+	h, err = inst.agentByName("coder")
+	require.NoError(t, err)
+	assert.Nil(t, h, "the primary's own name selects the primary")
+	h, err = inst.agentByName("reviewer")
+	require.NoError(t, err)
+	assert.Equal(t, "reviewer", h.name)
+
+	_, err = inst.agentByName("tester")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `no agent "tester" (helper agents: reviewer, docs)`)
+}
+
+func TestRestoreHelpers(t *testing.T) {
+	inst := helperTestInstance(t)
+	now := time.Unix(1_700_000_100, 0)
+	inst.restoreHelpers([]proto.AgentInfo{
+		{Name: "reviewer", Argv: []string{"claude"}, State: proto.StateRunning, StateSince: 1_700_000_000, PID: 42},
+		{Name: "docs", Argv: []string{"sh"}, State: proto.StateExited, StateSince: 1_700_000_050, EndedAt: 1_700_000_050},
+	}, now)
+
+	infos := inst.helperInfo()
+	require.Len(t, infos, 2)
+	assert.Equal(t, proto.AgentInfo{Name: "reviewer", Argv: []string{"claude"}, State: proto.StateCrashed,
+		StateSince: now.Unix(), EndedAt: now.Unix()}, infos[0], "its process died with the old daemon")
+	assert.Equal(t, proto.StateExited, infos[1].State)
+	assert.Equal(t, int64(1_700_000_050), infos[1].StateSince)
+	assert.Equal(t, filepath.Join(filepath.Dir(inst.LogFile), "1-docs.log"), inst.helpers[1].logFile)
+}
+
+func TestForgetHelpers(t *testing.T) {
+	inst := helperTestInstance(t)
+	inst.helpers = []*helperAgent{{name: "reviewer"}, {name: "gone"}, {name: "busy", ptm: os.Stdin}}
+
+	inst.forgetHelpers([]AgentSpec{{Name: "reviewer", Command: "claude"}})
+	var names []string
+	for _, h := range inst.helpers {
+		names = append(names, h.name)
+	}
+	assert.Equal(t, []string{"reviewer", "busy"}, names, "running helpers are kept")
+}
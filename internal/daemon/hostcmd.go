@@ -0,0 +1,185 @@
+package daemon
+
+// hostcmd.go – check and finish commands that run on the host.
+//
+// Some verifications only work outside the container: opening the dev server
+// in a browser, a screenshot tool, a macOS-only linter.  A command marked
+// host: true runs on the daemon's host instead, in the instance's worktree.
+// grove.yaml comes with the repository, so this would let any cloned project
+// run what it likes on the host; the daemon refuses such commands unless its
+// user has opted in with allow_host_commands: true in ~/.grove/config.yaml.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/gandalfthegui/grove/internal/envfile"
+	"github.com/gandalfthegui/grove/pkg/proto"
+	"gopkg.in/yaml.v3"
+)
+
+// daemonConfig is the part of ~/.grove/config.yaml the daemon reads; the
+// rest is the CLI's.  It is read afresh each time, so a change needs no
+// daemon restart, except to web_listen.
+type daemonConfig struct {
+	AllowHostCommands  bool   `yaml:"allow_host_commands"`
+	PrefetchOnRegister bool   `yaml:"prefetch_on_register"` // see handleProjectFetch
+	ScratchImage       string `yaml:"scratch_image"`        // see applyScratchDefaults
+	WebListen          string `yaml:"web_listen"`           // see startWeb; read only at startup
+}
+
+// loadDaemonConfig reads the daemon's settings from <root>/config.yaml.  A
+// missing or unparseable file yields the zero config, which allows and
+// prefetches nothing.
+func (d *Daemon) loadDaemonConfig() daemonConfig {
+	var cfg daemonConfig
+	data, err := os.ReadFile(filepath.Join(d.rootDir, "config.yaml"))
+	if err != nil {
+		return cfg
+	}
+	_ = yaml.Unmarshal(data, &cfg)
+	return cfg
+}
+
+// errHostCommandsDisabled refuses a host: command the user has not allowed.
+var errHostCommandsDisabled = errors.New("host commands are disabled; set allow_host_commands: true in ~/.grove/config.yaml to run commands marked host: true")
+
+// runInstanceCommand runs check or finish command c for inst, writing its
+// output to w: in the container like runCommandResult, or on the host if c
+// is marked host:.  While it runs it is listed in the instance's Running,
+// and its start and end are published as events; kind is a CommandKind*.
+func (d *Daemon) runInstanceCommand(inst *Instance, kind string, c CommandSpec, opts execOptions, w io.Writer) (proto.CommandResult, error) {
+	prog := inst.commandStarted(kind, c)
+	var res proto.CommandResult
+	var err error
+	if !c.Host {
+		res, err = runCommandResult(inst.ContainerID, c, opts, w)
+	} else {
+		start := time.Now()
+		err = d.execOnHost(inst, c, w)
+		res = commandResult(c, start, err)
+	}
+	inst.commandFinished(prog, res)
+	return res, err
+}
+
+// commandStarted adds a running command to inst and publishes an
+// EventCommandStarted.
+func (inst *Instance) commandStarted(kind string, c CommandSpec) *proto.CommandProgress {
+	prog := &proto.CommandProgress{Kind: kind, Label: c.label(), Started: time.Now().Unix()}
+	inst.mu.Lock()
+	inst.running = append(inst.running, prog)
+	inst.mu.Unlock()
+	inst.emitCommand(proto.EventCommandStarted, *prog)
+	return prog
+}
+
+// commandFinished removes prog, which commandStarted returned, from inst's
+// running commands and publishes an EventCommandFinished with res.
+func (inst *Instance) commandFinished(prog *proto.CommandProgress, res proto.CommandResult) {
+	inst.mu.Lock()
+	for i, c := range inst.running {
+		if c == prog {
+			inst.running = append(inst.running[:i:i], inst.running[i+1:]...)
+			break
+		}
+	}
+	inst.mu.Unlock()
+	done := *prog
+	done.Result = &res
+	inst.emitCommand(proto.EventCommandFinished, done)
+}
+
+// commandSummary renders a command event in one line, e.g. "check started:
+// lint" or "check ended: lint (exit 2 after 3.1s)".
+func commandSummary(prog proto.CommandProgress) string {
+	if prog.Result == nil {
+		return fmt.Sprintf("%s started: %s", prog.Kind, prog.Label)
+	}
+	took := (time.Duration(prog.Result.DurationMs) * time.Millisecond).Round(100 * time.Millisecond)
+	return fmt.Sprintf("%s ended: %s (exit %d after %s)", prog.Kind, prog.Label, prog.Result.ExitCode, took)
+}
+
+// emitCommand publishes a command event of type typ for inst.
+func (inst *Instance) emitCommand(typ string, prog proto.CommandProgress) {
+	inst.mu.Lock()
+	emit := inst.emit
+	inst.mu.Unlock()
+	if emit == nil {
+		return
+	}
+	info := inst.Info()
+	emit(proto.Event{
+		Time:       time.Now().Unix(),
+		Type:       typ,
+		InstanceID: info.ID,
+		Project:    info.Project,
+		Branch:     info.Branch,
+		BaseSHA:    info.BaseSHA,
+		State:      info.State,
+		Summary:    commandSummary(prog),
+		Command:    &prog,
+	})
+}
+
+// execOnHost runs c.Run with sh on the host, in c.Dir resolved against
+// inst's worktree, with hostCommandEnv.  It gets no input.
+func (d *Daemon) execOnHost(inst *Instance, c CommandSpec, w io.Writer) error {
+	if !d.loadDaemonConfig().AllowHostCommands {
+		return errHostCommandsDisabled
+	}
+	ctx := context.Background()
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, "sh", "-c", c.Run)
+	cmd.Dir = inst.WorktreeDir
+	if c.Dir != "" {
+		cmd.Dir = c.Dir
+		if !filepath.IsAbs(c.Dir) {
+			cmd.Dir = filepath.Join(inst.WorktreeDir, c.Dir)
+		}
+	}
+	cmd.Env = d.hostCommandEnv(inst)
+	// On a timeout kill everything the command started, not just sh, so
+	// that a child holding the output open cannot keep it running.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error { return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL) }
+	cmd.Stdout = w
+	cmd.Stderr = w
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("on host: timed out after %s", c.Timeout)
+		}
+		return fmt.Errorf("on host: %w", err)
+	}
+	return nil
+}
+
+// hostCommandEnv is the environment of a host command for inst: the
+// daemon's own, then ~/.grove/env, then the instance's GROVE_ variables.
+func (d *Daemon) hostCommandEnv(inst *Instance) []string {
+	env := os.Environ()
+	for k, v := range envfile.Load(filepath.Join(d.rootDir, "env")) {
+		env = append(env, k+"="+v)
+	}
+	env = append(env,
+		"GROVE_INSTANCE_ID="+inst.ID,
+		"GROVE_PROJECT="+inst.Project,
+		"GROVE_BRANCH="+inst.Branch,
+		"GROVE_WORKTREE="+inst.WorktreeDir,
+	)
+	if inst.Task != "" {
+		env = append(env, "GROVE_TASK="+inst.Task)
+	}
+	return env
+}
@@ -0,0 +1,90 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// hostCommandDaemon returns a daemon whose config.yaml is config, and an
+// instance with a worktree holding a directory "sub".
+func hostCommandDaemon(t *testing.T, config string) (*Daemon, *Instance) {
+	t.Helper()
+	root := t.TempDir()
+	if config != "" {
+		require.NoError(t, os.WriteFile(filepath.Join(root, "config.yaml"), []byte(config), 0o644))
+	}
+	worktree := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(worktree, "sub"), 0o755))
+	d := &Daemon{rootDir: root, instances: map[string]*Instance{}}
+	inst := &Instance{ID: "4", Project: "web", Branch: "feat/x", WorktreeDir: worktree, ContainerID: "grove-web-4"}
+	return d, inst
+}
+
+func TestHostCommandNeedsOptIn(t *testing.T) {
+	for _, config := range []string{"", "editor: vim\n", "allow_host_commands: false\n", "{not yaml"} {
+		d, inst := hostCommandDaemon(t, config)
+		var out strings.Builder
+		res, err := d.runInstanceCommand(inst, proto.CommandKindCheck, CommandSpec{Run: "touch ran", Host: true}, execOptions{}, &out)
+		assert.ErrorIs(t, err, errHostCommandsDisabled, "config %q", config)
+		assert.Equal(t, -1, res.ExitCode)
+		assert.NoFileExists(t, filepath.Join(inst.WorktreeDir, "ran"))
+	}
+}
+
+func TestHostCommand(t *testing.T) {
+	d, inst := hostCommandDaemon(t, "allow_host_commands: true\n")
+	require.NoError(t, os.WriteFile(filepath.Join(d.rootDir, "env"), []byte("FROM_ENV_FILE=yes\n"), 0o600))
+	inst.Task = "fix the build"
+
+	var out strings.Builder
+	c := CommandSpec{Run: `pwd; echo "$GROVE_INSTANCE_ID $GROVE_BRANCH $GROVE_TASK $FROM_ENV_FILE"; exit 3`, Dir: "sub", Name: "env", Host: true}
+	res, err := d.runInstanceCommand(inst, proto.CommandKindCheck, c, execOptions{Workdir: "/app/sub"}, &out)
+	assert.Error(t, err)
+	assert.Equal(t, 3, res.ExitCode)
+	assert.Equal(t, "env", res.Name)
+	wantDir, _ := filepath.EvalSymlinks(filepath.Join(inst.WorktreeDir, "sub"))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 2)
+	gotDir, _ := filepath.EvalSymlinks(lines[0])
+	assert.Equal(t, wantDir, gotDir, "dir: is relative to the worktree")
+	assert.Equal(t, "4 feat/x fix the build yes", lines[1])
+
+	res, err = d.runInstanceCommand(inst, proto.CommandKindFinish, CommandSpec{Run: "sleep 5", Timeout: 50 * time.Millisecond, Host: true}, execOptions{}, &out)
+	assert.ErrorContains(t, err, "on host: timed out after 50ms")
+	assert.Equal(t, -1, res.ExitCode)
+}
+
+func TestCommandProgress(t *testing.T) {
+	d, inst := hostCommandDaemon(t, "allow_host_commands: true\n")
+	var events []proto.Event
+	var running [][]proto.CommandProgress
+	inst.emit = func(ev proto.Event) {
+		events = append(events, ev)
+		running = append(running, inst.Info().Running)
+	}
+
+	var out strings.Builder
+	_, err := d.runInstanceCommand(inst, proto.CommandKindCheck, CommandSpec{Run: "exit 2", Name: "lint", Host: true}, execOptions{}, &out)
+	assert.Error(t, err)
+
+	require.Len(t, events, 2)
+	assert.Equal(t, proto.EventCommandStarted, events[0].Type)
+	assert.Equal(t, "check started: lint", events[0].Summary)
+	assert.Equal(t, proto.CommandKindCheck, events[0].Command.Kind)
+	assert.Nil(t, events[0].Command.Result)
+	require.Len(t, running[0], 1, "listed while it runs")
+	assert.Equal(t, "lint", running[0][0].Label)
+
+	assert.Equal(t, proto.EventCommandFinished, events[1].Type)
+	require.NotNil(t, events[1].Command.Result)
+	assert.Equal(t, 2, events[1].Command.Result.ExitCode)
+	assert.Regexp(t, `^check ended: lint \(exit 2 after [0-9.]+m?s\)$`, events[1].Summary)
+	assert.Empty(t, running[1], "and not after")
+}
@@ -0,0 +1,75 @@
+package daemon
+
+// initconfig.go – a project whose main checkout has no committed grove.yaml.
+//
+// A start refuses a project without a grove.yaml, and grove start then
+// offers to write a boilerplate one into the main checkout.  Two things go
+// wrong with that.  The repo may have a grove.yaml already, on a branch
+// that is not merged yet, and a second one written beside it conflicts
+// when that branch is merged; so the refusal names the branches that have
+// one.  And a boilerplate that is never committed works for this machine
+// only, and pulls of the main checkout trip over it once someone commits
+// another; so a start says so while the config it loads is untracked.
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// maxConfigBranches bounds the branches configBranches returns.
+const maxConfigBranches = 5
+
+// configBranches returns the branches of the repo in mainDir, local and
+// remote-tracking, whose tips have a grove.yaml, local ones first.
+func configBranches(ctx context.Context, mainDir string) []string {
+	paths := []string{configPathPreferred, configPathLegacy}
+	// The common case, no grove.yaml in any commit, takes one git call.
+	found, err := gitCommandContext(ctx, append([]string{"-C", mainDir, "log", "--all", "-n", "1", "--format=%H", "--"}, paths...)...).Output()
+	if err != nil || len(strings.TrimSpace(string(found))) == 0 {
+		return nil
+	}
+	refs, err := gitCommandContext(ctx, "-C", mainDir, "for-each-ref", "--format=%(refname:short)", "refs/heads", "refs/remotes").Output()
+	if err != nil {
+		return nil
+	}
+	var branches []string
+	for _, ref := range strings.Fields(string(refs)) {
+		if strings.HasSuffix(ref, "/HEAD") || ref == "origin" {
+			continue
+		}
+		out, err := gitCommandContext(ctx, append([]string{"-C", mainDir, "ls-tree", "--name-only", ref, "--"}, paths...)...).Output()
+		if err == nil && len(strings.TrimSpace(string(out))) > 0 {
+			branches = append(branches, ref)
+			if len(branches) == maxConfigBranches {
+				break
+			}
+		}
+	}
+	return branches
+}
+
+// configUntracked reports whether configPath, a file in the repo in
+// mainDir, is not known to git.
+func configUntracked(ctx context.Context, mainDir, configPath string) bool {
+	rel, err := filepath.Rel(mainDir, configPath)
+	if err != nil {
+		return false
+	}
+	return gitCommandContext(ctx, "-C", mainDir, "ls-files", "--error-unmatch", "--", rel).Run() != nil
+}
+
+// warnUntrackedConfig writes a warning to w if the main checkout's
+// grove.yaml is not committed.
+func warnUntrackedConfig(ctx context.Context, p *Project, w io.Writer) {
+	mainDir := p.MainDir()
+	path := findInRepoConfig(mainDir)
+	if path == "" || !configUntracked(ctx, mainDir, path) {
+		return
+	}
+	rel, _ := filepath.Rel(mainDir, path)
+	fmt.Fprintf(w, "warning: %s is not committed in %s; only this machine has it, and pulls conflict once another is committed.\n", rel, mainDir)
+	fmt.Fprintf(w, "hint: git -C %s add %s && git -C %s commit -m 'Add grove config' -- %s\n", mainDir, rel, mainDir, rel)
+}
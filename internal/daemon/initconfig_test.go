@@ -0,0 +1,79 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigBranches(t *testing.T) {
+	resetTools(t)
+	d, _ := dataDirTest(t, proto.StateExited)
+	main := filepath.Join(d.rootDir, "projects", "app", "main")
+	ctx := context.Background()
+	commit := func(args ...string) {
+		git(t, append([]string{"-C", main, "-c", "user.name=t", "-c", "user.email=t@t", "commit", "-q"}, args...)...)
+	}
+	assert.Empty(t, configBranches(ctx, main))
+
+	resp := teardownRequest(t, d.handleStart, proto.Request{Type: proto.ReqStart, Project: "app", Branch: "new"})
+	assert.Equal(t, "no grove.yaml found in app", resp.Error)
+	assert.Equal(t, proto.ErrCodeConfigMissing, resp.ErrorCode)
+	assert.Equal(t, main, resp.InitPath)
+	assert.Empty(t, resp.ConfigBranches)
+
+	git(t, "-C", main, "checkout", "-q", "-b", "setup")
+	writeRepoFiles(t, main, map[string]string{".grove/grove.yaml": "container:\n  image: img\n"})
+	git(t, "-C", main, "add", ".grove")
+	commit("-m", "config")
+	git(t, "-C", main, "checkout", "-q", "-b", "gone")
+	git(t, "-C", main, "rm", "-q", "-r", ".grove")
+	commit("-m", "no config")
+	git(t, "-C", main, "checkout", "-q", "main")
+	assert.Equal(t, []string{"setup"}, configBranches(ctx, main), "only branches whose tips have one")
+
+	resp = teardownRequest(t, d.handleStart, proto.Request{Type: proto.ReqStart, Project: "app", Branch: "new"})
+	assert.Equal(t, proto.ErrCodeConfigMissing, resp.ErrorCode)
+	assert.Equal(t, []string{"setup"}, resp.ConfigBranches)
+}
+
+func TestConfigInvalid(t *testing.T) {
+	resetTools(t)
+	d, _ := dataDirTest(t, proto.StateExited)
+	main := filepath.Join(d.rootDir, "projects", "app", "main")
+	writeRepoFiles(t, main, map[string]string{"grove.yaml": "container: [\n"})
+
+	resp := teardownRequest(t, d.handleStart, proto.Request{Type: proto.ReqStart, Project: "app", Branch: "new"})
+	assert.Contains(t, resp.Error, "invalid grove.yaml: ")
+	assert.Equal(t, proto.ErrCodeConfigInvalid, resp.ErrorCode)
+	assert.Equal(t, "grove validate "+filepath.Join(main, "grove.yaml")+" shows what is wrong with it", resp.Hint)
+	assert.Empty(t, resp.InitPath, "not mistaken for a missing one")
+}
+
+func TestWarnUntrackedConfig(t *testing.T) {
+	resetTools(t)
+	d, _ := dataDirTest(t, proto.StateExited)
+	main := filepath.Join(d.rootDir, "projects", "app", "main")
+	p := &Project{Name: "app", DataDir: filepath.Join(d.rootDir, "projects", "app")}
+	require.Equal(t, main, p.MainDir())
+	ctx := context.Background()
+
+	var w bytes.Buffer
+	warnUntrackedConfig(ctx, p, &w)
+	assert.Empty(t, w.String(), "no config at all")
+
+	writeRepoFiles(t, main, map[string]string{".grove/grove.yaml": "container:\n  image: img\n"})
+	warnUntrackedConfig(ctx, p, &w)
+	assert.Contains(t, w.String(), "warning: .grove/grove.yaml is not committed in "+main)
+	assert.Contains(t, w.String(), "hint: git -C "+main+" add .grove/grove.yaml")
+
+	w.Reset()
+	git(t, "-C", main, "add", ".grove")
+	warnUntrackedConfig(ctx, p, &w)
+	assert.Empty(t, w.String(), "added is enough for git to know it")
+}
@@ -24,7 +24,7 @@ package daemon
 //  └──────────────────────────────┘
 
 import (
-	"encoding/binary"
+	"bufio"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -33,17 +33,23 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	"github.com/creack/pty"
-	"github.com/gandalfthegui/grove/internal/proto"
+	"github.com/gandalfthegui/grove/internal/history"
+	"github.com/gandalfthegui/grove/pkg/proto"
 )
 
 const (
 	maxLogBytes = 1 << 20 // 1 MiB rolling log per instance
 
+	// maxRunHistory caps how many agent launches are kept per instance.
+	maxRunHistory = 50
+
 	// waitingIdleThreshold is how long an agent must produce no PTY output
 	// before its state is promoted from RUNNING to WAITING.
 	waitingIdleThreshold = 2 * time.Second
@@ -51,73 +57,228 @@ const (
 
 // Instance represents one running (or stopped) agent session.
 type Instance struct {
-	// Immutable after creation.
+	// Immutable after creation, but for ContainerID and ComposeProject,
+	// which recreateContainer changes (under mu) while the agent is down,
+	// and WorktreeDir and MainDir, which grove project move changes (under
+	// mu) likewise; see datadir.go.
 	ID             string
 	Project        string
 	Branch         string
 	WorktreeDir    string
+	MainDir        string // the project's main checkout the worktree belongs to
 	CreatedAt      time.Time
-	LogFile        string // path to the on-disk log file
-	ContainerID    string // exec target ("grove-1" or "grove-1-app-1")
-	ComposeProject string // "grove-<id>" if compose mode; empty if single container
+	Seq            int64               // creation order; see proto.InstanceInfo
+	LogFile        string              // path to the on-disk log file
+	ContainerID    string              // exec target ("grove-1" or "grove-1-app-1"); see recreateContainer
+	ComposeProject string              // "grove-<id>" if compose mode; empty if single container
+	Launch         *proto.LaunchConfig // agent and container settings at start; nil if unknown
+	Task           string              // from grove start --task; set as GROVE_TASK for the agent
+	Mode           string              // from grove start --mode; see agentModeArgs
+	StartTimings   []proto.StageTiming // how long each stage of grove start took; see timings.go
+	Scratch        *proto.ScratchInfo  // set for scratch instances; see scratch.go
 
 	// Mutable; protected by mu.
 	mu             sync.Mutex
-	state          string
+	state          string    // set through setState
+	stateChangedAt time.Time // when state last changed; zero if unknown
 	pid            int
-	ptm            *os.File     // PTY master; nil after process exits
-	logBuf         []byte       // rolling in-memory copy of recent output
-	lastOutputTime time.Time    // last time the PTY produced output
-	endedAt        time.Time    // when the process exited; zero if still running
-	attachedConn   net.Conn     // non-nil while a client is attached
-	attachDone     chan struct{} // closed when the current attach session ends
+	ptm            *os.File           // PTY master; nil after process exits
+	logBuf         []byte             // rolling in-memory copy of recent output
+	logIndex       logIndex           // when logBuf's output came; see logsall.go
+	lastOutputTime time.Time          // last time the PTY produced output
+	endedAt        time.Time          // when the process exited; zero if still running
+	launchedAt     time.Time          // when the process was started
+	attachedConn   net.Conn           // non-nil while a client is attached
+	attachedOut    io.Writer          // where PTY output for attachedConn goes: the conn, or data frames
+	attachedFrames *proto.FrameWriter // frames to attachedConn for a v2 client; nil for v1
+	attachDone     chan struct{}      // closed when the current attach session ends
+	notes          []proto.Note       // user annotations, oldest first
+	labels         map[string]string
+	cols, rows     uint16 // most recent known PTY size; zero if unknown
+	summary        string // agent's summary from its last "done" status report
+	readyAt        time.Time
+	lastCheck      *proto.CheckResult
+	lastFinish     *proto.CheckResult
+	pendingFinish  *proto.PendingFinish     // while finish commands run
+	checking       *proto.CheckProgress     // while CHECKING; see beginCheck
+	running        []*proto.CommandProgress // check and finish commands; see runInstanceCommand
+	operation      *operation               // check, finish, etc. in progress; see operation.go
+	setupLog       []proto.LogRange         // setup output in LogFile; see setuplog.go
+	lastAutoCheck  time.Time                // when an automatic check was last triggered
+	checkWatch     time.Time                // since when grove check --watch has run; see checkwatch.go
+	runs           []proto.AgentRun
+	remote         *proto.RemoteStatus
+	remoteQueried  time.Time            // last ls-remote attempt, successful or not
+	helpers        []*helperAgent       // other agents in the container; see helpers.go
+	aux            []*auxSession        // grove attach --command sessions; see aux.go
+	changes        *proto.ChangeSummary // cached by changeSummary; see changes.go
+	changesAt      time.Time
+	image          *proto.ContainerImage   // what ContainerID was created from; see inspectImage
+	stackStopped   bool                    // compose services stopped by grove stop; see stopStack
+	loop           loopDetector            // see stall.go
+	stalled        string                  // set when the agent is caught in a loop: the line it repeats
+	broken         string                  // set when the worktree disappears: why; see broken.go
+	environment    *proto.AgentEnvironment // see launchEnvironment
+	usage          *proto.AgentUsage       // see usage.go
+	baseSHA        string                  // the commit the branch started from; see base.go
+	// containerRemoved and worktreeRemoved are set by grove container rm
+	// and grove worktree rm; see teardown.go.
+	containerRemoved bool
+	worktreeRemoved  bool
+	// expiresAt is when the instance's time to live runs out, zero if it
+	// has none, and ttlAction what is done then; expiring is set while it
+	// is done.  See ttl.go.
+	expiresAt time.Time
+	ttlAction string
+	expiring  bool
+	// pinned is set by grove pin; see handlePin.
+	pinned bool
 
 	// InstancesDir is set so ptyReader can persist state changes on exit.
 	InstancesDir string
+	persistMu    sync.Mutex // see persistMeta
+	// emit publishes instance events (e.g. READY) to `grove events` clients.
+	// May be nil.
+	emit func(proto.Event)
+	// record appends to the timing history (see package history).  May be
+	// nil.
+	record func(history.Record)
 	// finishRequest, when true, causes ptyReader to transition to FINISHED
 	// instead of EXITED/CRASHED when the process stops.
 	finishRequest bool
-	// killed, when true, means destroy() was called deliberately; ptyReader
-	// transitions to KILLED instead of CRASHED on non-zero exit.
+	// killed, when true, means the agent was stopped deliberately.  destroy
+	// sets it, under mu, before the kill, and only while the agent runs, so
+	// ptyReader records KILLED whatever the exit status, and an agent that
+	// had already exited keeps its EXITED or CRASHED.
 	killed bool
+	// endReason and exitCode say why and how the agent ended; see
+	// proto.EndStopped.
+	endReason string
+	exitCode  int
 	// processDone is closed by ptyReader when the agent process fully exits.
 	processDone chan struct{}
 }
 
+// setState moves the instance to state, noting when it changed.  The caller
+// holds inst.mu.
+func (inst *Instance) setState(state string) {
+	if state != inst.state || inst.stateChangedAt.IsZero() {
+		inst.stateChangedAt = time.Now()
+	}
+	inst.state = state
+}
+
+// currentState returns the state to report at now and since when the
+// instance has been in it.  The caller holds inst.mu.
+//
+// RUNNING is promoted to WAITING when no PTY output has been seen for 2
+// seconds: Claude streams output continuously while working; silence means
+// it is waiting for human input.  It has been waiting since its last output.
+//
+// An instance whose primary agent has exited is still RUNNING while one of
+// its helper agents runs.
+func (inst *Instance) currentState(now time.Time) (string, time.Time) {
+	if inst.state == proto.StateRunning && !inst.lastOutputTime.IsZero() &&
+		now.Sub(inst.lastOutputTime) > waitingIdleThreshold {
+		return proto.StateWaiting, inst.lastOutputTime
+	}
+	if (inst.state == proto.StateExited || inst.state == proto.StateCrashed) && inst.helperRunning() {
+		return proto.StateRunning, inst.stateChangedAt
+	}
+	return inst.state, inst.stateChangedAt
+}
+
 // Info returns a serialisable snapshot of this instance's metadata.
 func (inst *Instance) Info() proto.InstanceInfo {
 	inst.mu.Lock()
 	defer inst.mu.Unlock()
 
-	state := inst.state
-	// Promote RUNNING → WAITING when no PTY output has been seen for 2 seconds.
-	// Claude streams output continuously while working; silence means it is
-	// waiting for human input.
-	if state == proto.StateRunning && !inst.lastOutputTime.IsZero() &&
-		time.Since(inst.lastOutputTime) > waitingIdleThreshold {
-		state = proto.StateWaiting
+	state, since := inst.currentState(time.Now())
+	var stateSince int64
+	if !since.IsZero() {
+		stateSince = since.Unix()
 	}
 
 	var endedAt int64
 	if !inst.endedAt.IsZero() {
 		endedAt = inst.endedAt.Unix()
 	}
+	var notes []proto.Note
+	if len(inst.notes) > 0 {
+		notes = make([]proto.Note, len(inst.notes))
+		copy(notes, inst.notes)
+	}
+	var runs []proto.AgentRun
+	if len(inst.runs) > 0 {
+		runs = make([]proto.AgentRun, len(inst.runs))
+		copy(runs, inst.runs)
+	}
+	var running []proto.CommandProgress
+	for _, c := range inst.running {
+		running = append(running, *c)
+	}
+	var expiresAt int64
+	if !inst.expiresAt.IsZero() {
+		expiresAt = inst.expiresAt.Unix()
+	}
+	var labels map[string]string
+	if len(inst.labels) > 0 {
+		labels = make(map[string]string, len(inst.labels))
+		for k, v := range inst.labels {
+			labels[k] = v
+		}
+	}
 	return proto.InstanceInfo{
-		ID:             inst.ID,
-		Project:        inst.Project,
-		State:          state,
-		Branch:         inst.Branch,
-		WorktreeDir:    inst.WorktreeDir,
-		CreatedAt:      inst.CreatedAt.Unix(),
-		EndedAt:        endedAt,
-		PID:            inst.pid,
-		ContainerID:    inst.ContainerID,
-		ComposeProject: inst.ComposeProject,
+		ID:               inst.ID,
+		Project:          inst.Project,
+		State:            state,
+		StateSince:       stateSince,
+		Branch:           inst.Branch,
+		WorktreeDir:      inst.WorktreeDir,
+		MainDir:          inst.MainDir,
+		CreatedAt:        inst.CreatedAt.Unix(),
+		Seq:              inst.Seq,
+		EndedAt:          endedAt,
+		EndReason:        inst.endReason,
+		ExitCode:         inst.exitCode,
+		PID:              inst.pid,
+		ContainerID:      inst.ContainerID,
+		ComposeProject:   inst.ComposeProject,
+		Notes:            notes,
+		Labels:           labels,
+		Summary:          inst.summary,
+		Task:             inst.Task,
+		Mode:             inst.Mode,
+		StartTimings:     inst.StartTimings,
+		Scratch:          inst.Scratch,
+		Image:            inst.image,
+		StackStopped:     inst.stackStopped,
+		ContainerRemoved: inst.containerRemoved,
+		WorktreeRemoved:  inst.worktreeRemoved,
+		ExpiresAt:        expiresAt,
+		TTLAction:        inst.ttlAction,
+		Pinned:           inst.pinned,
+		Environment:      inst.environment,
+		LastCheck:        inst.lastCheck,
+		LastFinish:       inst.lastFinish,
+		SetupLog:         append([]proto.LogRange(nil), inst.setupLog...),
+		Remote:           inst.remote,
+		Runs:             runs,
+		Launch:           inst.Launch,
+		PendingFinish:    inst.pendingFinish,
+		Checking:         inst.checking,
+		Running:          running,
+		Agents:           inst.helperInfo(),
+		Usage:            inst.usage,
+		BaseSHA:          inst.baseSHA,
 	}
 }
 
 // persistMeta writes the instance metadata to ~/.grove/instances/<id>.json.
+// Writes are serialised so that the last one holds the newest snapshot.
 func (inst *Instance) persistMeta(instancesDir string) {
+	inst.persistMu.Lock()
+	defer inst.persistMu.Unlock()
 	info := inst.Info()
 	data, _ := json.MarshalIndent(info, "", "  ")
 	path := filepath.Join(instancesDir, inst.ID+".json")
@@ -131,6 +292,37 @@ func (inst *Instance) persistMeta(instancesDir string) {
 // destroy() kills the docker exec process; the container keeps running so that
 // restart works by starting a new docker exec in the same container.
 func (inst *Instance) startAgent(agentCmd string, agentArgs []string, extraEnv map[string]string) error {
+	cmd := inst.agentCommand(agentCmd, agentArgs, extraEnv)
+	environment := launchEnvironment(cmd.Args, containerEnv(inst.ContainerID))
+	ptm, err := pty.StartWithSize(cmd, inst.ptySize())
+	if err != nil {
+		return fmt.Errorf("pty.Start: %w", err)
+	}
+
+	inst.mu.Lock()
+	inst.ptm = ptm
+	inst.environment = environment
+	inst.pid = cmd.Process.Pid
+	inst.launchedAt = time.Now()
+	inst.setState(proto.StateRunning)
+	inst.processDone = make(chan struct{})
+	inst.logBuf = inst.logBuf[:0]     // clear stale output from prior runs
+	inst.lastOutputTime = time.Time{} // reset idle timer
+	inst.logIndex = logIndex{}
+	processDone := inst.processDone
+	inst.mu.Unlock()
+
+	// Background goroutine: drain PTY master and buffer/forward output.
+	go inst.ptyReader(cmd)
+	// Watch for the agent reporting its task done (see status.go).
+	go inst.watchAgentStatus(processDone)
+
+	return nil
+}
+
+// agentCommand returns the "docker exec -it" command that runs agentCmd in
+// the instance's container.
+func (inst *Instance) agentCommand(agentCmd string, agentArgs []string, extraEnv map[string]string) *exec.Cmd {
 	// bash in sh mode resets PS1 during initialisation; PROMPT_COMMAND fires
 	// before every prompt and is not reset, so it reliably overrides PS1 for
 	// shell sessions.  Agents like claude/aider ignore both variables.
@@ -157,32 +349,56 @@ func (inst *Instance) startAgent(agentCmd string, agentArgs []string, extraEnv m
 	if agentCmd == "claude" {
 		dockerArgs = append(dockerArgs, "-e", "IS_DEMO=true")
 	}
-	for k, v := range extraEnv {
-		dockerArgs = append(dockerArgs, "-e", k+"="+v)
+	// Sorted, so that launches with the same env have the same command line.
+	keys := make([]string, 0, len(extraEnv))
+	for k := range extraEnv {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		dockerArgs = append(dockerArgs, "-e", k+"="+extraEnv[k])
 	}
 	dockerArgs = append(dockerArgs, inst.ContainerID, agentCmd)
 	dockerArgs = append(dockerArgs, agentArgs...)
-	cmd := exec.Command("docker", dockerArgs...)
 	// No cmd.Dir or cmd.Env — handled by the container.
+	return dockerCommand(dockerArgs...)
+}
 
-	// Start the command attached to a new PTY.
-	ptm, err := pty.Start(cmd)
-	if err != nil {
-		return fmt.Errorf("pty.Start: %w", err)
+// launchEnvironment describes an agent launched by the docker exec command
+// line argv in a container configured with env base: the variables argv
+// passes with -e override base.  Secret values are redacted.
+func launchEnvironment(argv, base []string) *proto.AgentEnvironment {
+	vars := make(map[string]string)
+	for _, kv := range base {
+		k, v, _ := strings.Cut(kv, "=")
+		vars[k] = v
+	}
+	redactedArgv := make([]string, len(argv))
+	for i, a := range argv {
+		if i > 0 && argv[i-1] == "-e" {
+			k, v, _ := strings.Cut(a, "=")
+			vars[k] = v
+			a = redactEnvVar(a)
+		}
+		redactedArgv[i] = a
+	}
+	env := make([]string, 0, len(vars))
+	for k, v := range vars {
+		env = append(env, redactEnvVar(k+"="+v))
 	}
+	sort.Strings(env)
+	return &proto.AgentEnvironment{Argv: redactedArgv, Env: env}
+}
 
+// ptySize returns the size to start a new PTY at: the most recent known
+// size, so detached agents don't render into an 80x24 default, or nil if
+// there is none.
+func (inst *Instance) ptySize() *pty.Winsize {
 	inst.mu.Lock()
-	inst.ptm = ptm
-	inst.pid = cmd.Process.Pid
-	inst.state = proto.StateRunning
-	inst.processDone = make(chan struct{})
-	inst.logBuf = inst.logBuf[:0]     // clear stale output from prior runs
-	inst.lastOutputTime = time.Time{} // reset idle timer
-	inst.mu.Unlock()
-
-	// Background goroutine: drain PTY master and buffer/forward output.
-	go inst.ptyReader(cmd)
-
+	defer inst.mu.Unlock()
+	if inst.cols > 0 && inst.rows > 0 {
+		return &pty.Winsize{Cols: inst.cols, Rows: inst.rows}
+	}
 	return nil
 }
 
@@ -216,18 +432,30 @@ func (inst *Instance) ptyReader(cmd *exec.Cmd) {
 			}
 
 			inst.mu.Lock()
+			// Output after a silence ends a WAITING spell: RUNNING again
+			// from now.
+			now := time.Now()
+			if state, _ := inst.currentState(now); state == proto.StateWaiting && inst.state == proto.StateRunning {
+				inst.stateChangedAt = now
+			}
 			// Append to rolling in-memory buffer, trimming if too large.
 			inst.logBuf = append(inst.logBuf, chunk...)
 			if len(inst.logBuf) > maxLogBytes {
 				inst.logBuf = inst.logBuf[len(inst.logBuf)-maxLogBytes:]
 			}
-			inst.lastOutputTime = time.Now()
-			conn := inst.attachedConn
+			inst.logIndex.add(n, now)
+			inst.lastOutputTime = now
+			out := inst.attachedOut
+			excerpt, looping := inst.loop.feed(chunk, now)
 			inst.mu.Unlock()
 
+			if looping {
+				inst.stall(excerpt)
+			}
+
 			// Forward to attached client (ignore errors; client may have gone away).
-			if conn != nil {
-				conn.Write(chunk)
+			if out != nil {
+				out.Write(chunk)
 			}
 		}
 		if err != nil {
@@ -243,21 +471,49 @@ func (inst *Instance) ptyReader(cmd *exec.Cmd) {
 	inst.ptm.Close()
 	inst.ptm = nil
 	inst.endedAt = time.Now()
-	if waitErr == nil {
-		inst.state = proto.StateExited
-	} else if inst.killed {
-		inst.state = proto.StateKilled
-	} else {
-		inst.state = proto.StateCrashed
+	inst.exitCode = exitCode(waitErr)
+	switch {
+	case inst.broken != "":
+		inst.setState(proto.StateBroken)
+		inst.endReason = proto.EndBroken
+	case inst.stalled != "":
+		inst.setState(proto.StateStalled)
+		inst.endReason = proto.EndStalled
+	case inst.killed:
+		inst.setState(proto.StateKilled)
+		inst.endReason = proto.EndStopped
+	case waitErr == nil:
+		inst.setState(proto.StateExited)
+		inst.endReason = proto.EndExited
+	case !inst.finishRequest && crashLooping(inst.runs, inst.endedAt):
+		// Crashing again soon after each launch: restarting will not help.
+		inst.stalled = inst.loop.last
+		if inst.stalled == "" {
+			inst.stalled = fmt.Sprintf("crashed within %s of each of its last %d launches", stallCrashWindow, stallCrashRuns)
+		}
+		inst.setState(proto.StateStalled)
+		inst.endReason = proto.EndStalled
+	default:
+		inst.setState(proto.StateCrashed)
+		inst.endReason = proto.EndExited
 	}
+	stalled := inst.state == proto.StateStalled
+	excerpt := inst.stalled
 	conn := inst.attachedConn
+	fw := inst.attachedFrames
+	exit := proto.AttachExit{State: inst.state, ExitCode: exitCode(waitErr)}
 	inst.attachedConn = nil
+	inst.attachedOut = nil
+	inst.attachedFrames = nil
 	inst.mu.Unlock()
 
 	// Close the client connection to unblock the Attach goroutine's frame
 	// reader.  The Attach goroutine's defer is the sole owner of close(done);
 	// closing it here too would double-close the channel and panic the daemon.
 	if conn != nil {
+		if fw != nil {
+			inst.writeJSONFrame(fw, proto.AttachFrameExit, exit)
+		}
 		conn.Close()
 	}
 
@@ -266,12 +522,23 @@ func (inst *Instance) ptyReader(cmd *exec.Cmd) {
 	// If finish was requested, override state to FINISHED.
 	inst.mu.Lock()
 	if inst.finishRequest {
-		inst.state = proto.StateFinished
+		inst.setState(proto.StateFinished)
+		stalled = false
 	}
 	instancesDir := inst.InstancesDir
 	processDone := inst.processDone
+	record := inst.record
+	session := inst.endedAt.Sub(inst.launchedAt)
 	inst.mu.Unlock()
 
+	if record != nil {
+		record(history.Record{Kind: history.KindSession, Project: inst.Project, DurationMs: session.Milliseconds()})
+	}
+	if stalled {
+		log.Printf("instance %s: stalled: %s", inst.ID, excerpt)
+		inst.reportStall(excerpt)
+	}
+
 	// Persist the final state to disk.
 	if instancesDir != "" {
 		inst.persistMeta(instancesDir)
@@ -292,28 +559,52 @@ func (inst *Instance) ptyReader(cmd *exec.Cmd) {
 //     resize events, detach signal).
 //  4. Blocks until the session ends (client detaches, client disconnects,
 //     or the agent exits).
-func (inst *Instance) Attach(conn net.Conn) {
+//
+// With v2 set, output is sent as data frames and control frames are
+// interleaved (see attachframes.go).
+func (inst *Instance) Attach(conn net.Conn, v2 bool) {
 	inst.mu.Lock()
 	if inst.state == proto.StateAttached {
 		inst.mu.Unlock()
 		fmt.Fprintf(conn, `{"ok":false,"error":"already attached"}`+"\n")
 		return
 	}
+	stateBefore, outputBefore := inst.state, inst.lastOutputTime
 
 	// Grab a copy of the log buffer to replay.
 	replay := make([]byte, len(inst.logBuf))
 	copy(replay, inst.logBuf)
 
+	var out io.Writer = conn
+	var fw *proto.FrameWriter
+	if v2 {
+		fw = proto.NewFrameWriter(conn)
+		out = dataFrameWriter{inst, fw}
+	}
+
 	done := make(chan struct{})
 	inst.attachedConn = conn
+	inst.attachedOut = out
+	inst.attachedFrames = fw
 	inst.attachDone = done
-	inst.state = proto.StateAttached
+	inst.setState(proto.StateAttached)
 	ptm := inst.ptm
+	cols, rows := inst.cols, inst.rows
 	inst.mu.Unlock()
 
+	// Apply the last known size right away; the client's own resize frame
+	// follows shortly and overrides it if the terminal differs.
+	if ptm != nil && cols > 0 && rows > 0 {
+		pty.Setsize(ptm, &pty.Winsize{Cols: cols, Rows: rows})
+	}
+
 	// Replay buffered output so the human sees what the agent has done.
+	// If the client is already gone, close conn so that the reader below
+	// ends the session and nothing more is sent.
 	if len(replay) > 0 {
-		conn.Write(replay)
+		if _, err := out.Write(replay); err != nil {
+			conn.Close()
+		}
 	}
 
 	// If the agent is already gone there's nothing to do.
@@ -330,8 +621,15 @@ func (inst *Instance) Attach(conn net.Conn) {
 			wasAttached := inst.attachedConn == conn
 			if wasAttached {
 				inst.attachedConn = nil
-				if inst.state == proto.StateAttached {
-					inst.state = proto.StateRunning
+				inst.attachedOut = nil
+				inst.attachedFrames = nil
+				switch {
+				case inst.state != proto.StateAttached:
+				case stateBefore == proto.StateReady && !inst.lastOutputTime.After(outputBefore):
+					// Only looked at: the agent is still done.
+					inst.setState(proto.StateReady)
+				default:
+					inst.setState(proto.StateRunning)
 				}
 			}
 			inst.mu.Unlock()
@@ -339,81 +637,150 @@ func (inst *Instance) Attach(conn net.Conn) {
 			close(done)
 		}()
 
-		for {
-			frameType, payload, err := proto.ReadFrame(conn)
-			if err != nil {
-				if err != io.EOF {
-					log.Printf("instance %s: attach read: %v", inst.ID, err)
-				}
-				return
+		inst.readAttachInput(conn, func() *os.File { return inst.ptm })
+	}()
+
+	if v2 {
+		go inst.sendAttachState(fw, done, inst.attachState)
+	}
+
+	// Block the caller (the daemon's request handler) until the attach ends.
+	<-done
+}
+
+// readAttachInput passes stdin and resize frames from an attach client to
+// the PTY that ptm returns (called with inst.mu held; nil once the agent has
+// exited) until the client detaches or disconnects.
+func (inst *Instance) readAttachInput(conn net.Conn, ptm func() *os.File) {
+	br := bufio.NewReader(conn)
+	for {
+		frameType, payload, err := proto.ReadFrame(br)
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("instance %s: attach read: %v", inst.ID, err)
 			}
+			return
+		}
+		traceFrame(inst.ID, "in", frameType, len(payload))
 
-			switch frameType {
-			case proto.AttachFrameData:
-				// Write client stdin into the PTY.
+		switch frameType {
+		case proto.AttachFrameData:
+			// Write client stdin into the PTY.
+			inst.mu.Lock()
+			p := ptm()
+			inst.mu.Unlock()
+			if p != nil {
+				p.Write(payload)
+			}
+
+		case proto.AttachFrameResize:
+			if cols, rows, ok := proto.ParseResizePayload(payload); ok {
 				inst.mu.Lock()
-				p := inst.ptm
+				p := ptm()
+				inst.cols, inst.rows = cols, rows
 				inst.mu.Unlock()
-				if p != nil {
-					p.Write(payload)
+				// During a resize storm (a terminal corner being dragged)
+				// only the latest size matters: skip the ioctl, and the
+				// agent's redraw, if another resize is already waiting.
+				if p != nil && !nextFrameIs(br, proto.AttachFrameResize) {
+					setPTYSize(p, &pty.Winsize{
+						Cols: cols,
+						Rows: rows,
+					})
 				}
-
-			case proto.AttachFrameResize:
-				// payload: 2-byte cols + 2-byte rows (big-endian uint16)
-				if len(payload) == 4 {
-					cols := binary.BigEndian.Uint16(payload[0:2])
-					rows := binary.BigEndian.Uint16(payload[2:4])
-					inst.mu.Lock()
-					p := inst.ptm
-					inst.mu.Unlock()
-					if p != nil {
-						pty.Setsize(p, &pty.Winsize{
-							Cols: cols,
-							Rows: rows,
-						})
-					}
-				}
-
-			case proto.AttachFrameDetach:
-				// Client requested a clean detach; just return.
-				return
 			}
+
+		case proto.AttachFrameDetach:
+			// Client requested a clean detach; just return.
+			return
 		}
-	}()
+	}
+}
 
-	// Block the caller (the daemon's request handler) until the attach ends.
-	<-done
+// setPTYSize resizes a PTY; tests replace it to count resizes.
+var setPTYSize = pty.Setsize
+
+// nextFrameIs reports whether the next frame in br, if already received, is
+// of frameType.  It does not wait for more input.
+func nextFrameIs(br *bufio.Reader, frameType byte) bool {
+	if br.Buffered() == 0 {
+		return false
+	}
+	b, err := br.Peek(1)
+	return err == nil && b[0] == frameType
+}
+
+// recordRun appends an agent launch to the instance history, dropping the
+// oldest entries beyond maxRunHistory.
+func (inst *Instance) recordRun(agentCmd string, args []string, resumed bool) {
+	argv := append([]string{agentCmd}, args...)
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+	inst.runs = append(inst.runs, proto.AgentRun{Time: time.Now().Unix(), Argv: argv, Resumed: resumed})
+	if len(inst.runs) > maxRunHistory {
+		inst.runs = inst.runs[len(inst.runs)-maxRunHistory:]
+	}
+}
+
+// setInitialSize records the PTY size for the next startAgent call.  A
+// client-supplied size wins, then the size from a previous session (on
+// restart), then the grove.yaml terminal default.
+func (inst *Instance) setInitialSize(cols, rows uint16, p *Project) {
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+	switch {
+	case cols > 0 && rows > 0:
+		inst.cols, inst.rows = cols, rows
+	case inst.cols > 0 && inst.rows > 0:
+		// Keep the size from the previous session (restart).
+	case p.Terminal.Cols > 0 && p.Terminal.Rows > 0:
+		inst.cols, inst.rows = p.Terminal.Cols, p.Terminal.Rows
+	}
 }
 
-// destroy kills the agent process and its process group, then closes the PTY.
+// destroy kills the agent process and its process group, then closes the
+// PTY.  Helper agents are killed too.  A running agent is marked stopped,
+// on disk as well, before it is killed, so that ptyReader and a daemon
+// restart that beats it both record it as KILLED.
 func (inst *Instance) destroy() {
 	inst.mu.Lock()
 	ptm := inst.ptm
 	pid := inst.pid
 	conn := inst.attachedConn
-	inst.killed = true
+	if ptm != nil {
+		inst.killed = true
+		inst.endReason = proto.EndStopped
+	}
+	instancesDir := inst.InstancesDir
 	inst.mu.Unlock()
 
-	if pid > 0 {
-		// Look up the actual PGID rather than assuming it equals the PID.
-		// After pty.Start (which calls setsid), the child is its own session
-		// leader and PGID = PID — but using Getpgid makes this explicit and
-		// safe against any edge cases.
-		pgid, err := syscall.Getpgid(pid)
-		if err == nil && pgid > 0 {
-			syscall.Kill(-pgid, syscall.SIGKILL)
-		} else {
-			// Fallback: kill just the process.
-			syscall.Kill(pid, syscall.SIGKILL)
-		}
+	if ptm != nil && instancesDir != "" {
+		inst.persistMeta(instancesDir)
 	}
-
+	killProcessGroup(pid)
 	if ptm != nil {
 		ptm.Close()
 	}
-
 	if conn != nil {
 		conn.Close()
 	}
+	inst.destroyHelpers()
 }
 
+// killProcessGroup kills the process group of pid, if pid is set.
+func killProcessGroup(pid int) {
+	if pid <= 0 {
+		return
+	}
+	// Look up the actual PGID rather than assuming it equals the PID.
+	// After pty.Start (which calls setsid), the child is its own session
+	// leader and PGID = PID — but using Getpgid makes this explicit and
+	// safe against any edge cases.
+	pgid, err := syscall.Getpgid(pid)
+	if err == nil && pgid > 0 {
+		syscall.Kill(-pgid, syscall.SIGKILL)
+	} else {
+		// Fallback: kill just the process.
+		syscall.Kill(pid, syscall.SIGKILL)
+	}
+}
@@ -1,11 +1,16 @@
 package daemon
 
 import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
-	"github.com/gandalfthegui/grove/internal/proto"
+	"github.com/gandalfthegui/grove/pkg/proto"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 
@@ -49,3 +54,185 @@ func TestInfoNonRunningStateUnchanged(t *testing.T) {
 		assert.Equal(t, state, inst.Info().State, "state %s should not be promoted", state)
 	}
 }
+
+func TestSetInitialSizePrecedence(t *testing.T) {
+	p := &Project{}
+	p.Terminal.Cols, p.Terminal.Rows = 200, 50
+
+	// No client size, no prior session: grove.yaml default applies.
+	inst := &Instance{}
+	inst.setInitialSize(0, 0, p)
+	assert.Equal(t, [2]uint16{200, 50}, [2]uint16{inst.cols, inst.rows})
+
+	// Client size wins.
+	inst.setInitialSize(120, 40, p)
+	assert.Equal(t, [2]uint16{120, 40}, [2]uint16{inst.cols, inst.rows})
+
+	// Restart without a client size keeps the last known size.
+	inst.setInitialSize(0, 0, p)
+	assert.Equal(t, [2]uint16{120, 40}, [2]uint16{inst.cols, inst.rows})
+
+	// Nothing known anywhere: stays zero (library default).
+	bare := &Instance{}
+	bare.setInitialSize(0, 0, &Project{})
+	assert.Zero(t, bare.cols)
+	assert.Zero(t, bare.rows)
+}
+
+func TestRecordRunCapsHistory(t *testing.T) {
+	inst := &Instance{}
+	inst.recordRun("claude", nil, false)
+	for i := 0; i < maxRunHistory+5; i++ {
+		inst.recordRun("claude", []string{"--continue"}, true)
+	}
+
+	runs := inst.Info().Runs
+	require.Len(t, runs, maxRunHistory)
+	assert.Equal(t, []string{"claude", "--continue"}, runs[0].Argv)
+	assert.True(t, runs[len(runs)-1].Resumed)
+}
+
+func TestLaunchEnvironment(t *testing.T) {
+	inst := &Instance{ID: "3", Project: "app", Branch: "main", ContainerID: "grove-3"}
+	cmd := inst.agentCommand("claude", []string{"--continue"}, map[string]string{
+		"ANTHROPIC_API_KEY": "sk-secret",
+		"GROVE_TASK":        "fix it",
+		"NODE_ENV":          "test",
+	})
+	env := launchEnvironment(cmd.Args, []string{"PATH=/usr/bin:/bin", "NODE_ENV=production", "LANG=C.UTF-8"})
+
+	// The -e flags follow the defaults in key order, and secrets are masked
+	// in the command line as well as in the environment.
+	argv := strings.Join(env.Argv, " ")
+	assert.Contains(t, argv, "-e ANTHROPIC_API_KEY=[redacted] -e GROVE_TASK=fix it -e NODE_ENV=test grove-3 claude --continue")
+	assert.NotContains(t, argv, "sk-secret")
+
+	vars := map[string]string{}
+	for _, kv := range env.Env {
+		k, v, _ := strings.Cut(kv, "=")
+		vars[k] = v
+	}
+	assert.True(t, sort.StringsAreSorted(env.Env))
+	assert.Equal(t, "[redacted]", vars["ANTHROPIC_API_KEY"])
+	assert.Equal(t, "test", vars["NODE_ENV"], "grove's variables override the container's")
+	assert.Equal(t, "C.UTF-8", vars["LANG"])
+	assert.Equal(t, "/root/.local/bin:/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin", vars["PATH"])
+	assert.Equal(t, "xterm-256color", vars["TERM"])
+}
+
+func TestInfoStateSince(t *testing.T) {
+	inst := &Instance{ID: "1"}
+	assert.Zero(t, inst.Info().StateSince, "no state yet")
+
+	inst.setState(proto.StateRunning)
+	started := inst.stateChangedAt
+	require.False(t, started.IsZero())
+	assert.Equal(t, started.Unix(), inst.Info().StateSince)
+
+	inst.setState(proto.StateRunning)
+	assert.Equal(t, started, inst.stateChangedAt, "setting the same state keeps the time")
+
+	// Derived WAITING dates from the last output.
+	lastOutput := time.Now().Add(-time.Minute)
+	inst.lastOutputTime = lastOutput
+	state, since := inst.currentState(time.Now())
+	assert.Equal(t, proto.StateWaiting, state)
+	assert.Equal(t, lastOutput, since)
+	assert.Equal(t, lastOutput.Unix(), inst.Info().StateSince)
+
+	inst.setState(proto.StateExited)
+	assert.False(t, inst.stateChangedAt.Before(started))
+	state, since = inst.currentState(time.Now())
+	assert.Equal(t, proto.StateExited, state)
+	assert.Equal(t, inst.stateChangedAt, since)
+}
+
+func TestAgentEndStates(t *testing.T) {
+	cases := []struct {
+		name      string
+		agent     []string
+		stop      bool // grove stop while the agent runs
+		stopAfter bool // grove stop once it has exited
+		state     string
+		reason    string
+		code      int
+	}{
+		{name: "stop while running", agent: []string{"sleep", "30"}, stop: true,
+			state: proto.StateKilled, reason: proto.EndStopped, code: -1},
+		{name: "stop after output", agent: []string{"sh", "-c", "echo hi; sleep 30"}, stop: true,
+			state: proto.StateKilled, reason: proto.EndStopped, code: -1},
+		{name: "exit 0", agent: []string{"true"},
+			state: proto.StateExited, reason: proto.EndExited},
+		{name: "exit 3", agent: []string{"sh", "-c", "exit 3"},
+			state: proto.StateCrashed, reason: proto.EndExited, code: 3},
+		{name: "stop after exit", agent: []string{"true"}, stopAfter: true,
+			state: proto.StateExited, reason: proto.EndExited},
+		{name: "stop after crash", agent: []string{"sh", "-c", "exit 3"}, stopAfter: true,
+			state: proto.StateCrashed, reason: proto.EndExited, code: 3},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			fakeExecDocker(t)
+			instancesDir := t.TempDir()
+			inst := &Instance{ID: "1", ContainerID: "c1", LogFile: filepath.Join(t.TempDir(), "1.log"), InstancesDir: instancesDir}
+			require.NoError(t, inst.startAgent(tc.agent[0], tc.agent[1:], nil))
+			inst.mu.Lock()
+			done := inst.processDone
+			inst.mu.Unlock()
+
+			if tc.stop {
+				inst.destroy()
+			}
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("agent did not end")
+			}
+			if tc.stopAfter {
+				inst.destroy()
+			}
+
+			info := inst.Info()
+			assert.Equal(t, tc.state, info.State)
+			assert.Equal(t, tc.reason, info.EndReason)
+			assert.Equal(t, tc.code, info.ExitCode)
+
+			d := &Daemon{rootDir: filepath.Dir(instancesDir), instances: make(map[string]*Instance)}
+			require.NoError(t, os.Rename(instancesDir, filepath.Join(d.rootDir, "instances")))
+			require.NoError(t, d.loadPersistedInstances())
+			reloaded := d.instances["1"].Info()
+			assert.Equal(t, tc.state, reloaded.State, "persisted")
+			assert.Equal(t, tc.reason, reloaded.EndReason, "persisted")
+		})
+	}
+}
+
+func TestReloadAgentEndStates(t *testing.T) {
+	cases := []struct {
+		name   string
+		state  string
+		reason string
+		want   string
+		wantBy string
+	}{
+		{"running", proto.StateWaiting, "", proto.StateCrashed, proto.EndDaemonExit},
+		{"being stopped", proto.StateRunning, proto.EndStopped, proto.StateKilled, proto.EndStopped},
+		{"killed", proto.StateKilled, proto.EndStopped, proto.StateKilled, proto.EndStopped},
+		{"exited", proto.StateExited, proto.EndExited, proto.StateExited, proto.EndExited},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			instancesDir := filepath.Join(root, "instances")
+			require.NoError(t, os.MkdirAll(instancesDir, 0o755))
+			inst := &Instance{ID: "1", CreatedAt: time.Now(), state: tc.state, endReason: tc.reason}
+			inst.persistMeta(instancesDir)
+
+			d := &Daemon{rootDir: root, instances: make(map[string]*Instance)}
+			require.NoError(t, d.loadPersistedInstances())
+			info := d.instances["1"].Info()
+			assert.Equal(t, tc.want, info.State)
+			assert.Equal(t, tc.wantBy, info.EndReason)
+		})
+	}
+}
@@ -0,0 +1,263 @@
+package daemon
+
+// lock.go – per-project serialisation of git operations on the main
+// checkout.  Concurrent `git worktree add` (or a pull racing a clone) against
+// one repository can corrupt its worktree metadata, so every handler that
+// touches <project>/main takes that project's lock first.
+//
+// An operation that waits long for a lock says so, to its client if it
+// has a stream to write to and to the log, and grove daemon status
+// --verbose lists the locks held and what is waiting for each, so a start
+// that hangs on another's clone is not taken for a hang of its own.
+//
+// It also holds the root lock, which keeps a second daemon off a data root
+// that already has one.
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+// projectLockTimeout bounds how long an operation waits for another one on
+// the same project, so a stuck clone or pull does not block every later
+// start forever.
+const projectLockTimeout = 2 * time.Minute
+
+// DefaultLockWaitNotice is how long an operation waits for a project lock
+// before it says so, and then how often it says so again.
+const DefaultLockWaitNotice = 3 * time.Second
+
+var lockWaitNotice atomic.Int64
+
+func init() {
+	SetLockWaitNotice(DefaultLockWaitNotice)
+}
+
+// SetLockWaitNotice sets how long an operation waits for a project lock
+// before it says so.  Zero never says so.
+func SetLockWaitNotice(d time.Duration) {
+	lockWaitNotice.Store(int64(d))
+}
+
+// projectLocks holds one lock per project name.  The zero value is ready to
+// use.  Each lock is a 1-buffered channel so acquiring it can time out.
+type projectLocks struct {
+	mu      sync.Mutex
+	locks   map[string]chan struct{}
+	holders map[string]lockHolder    // of the locks currently held
+	waiters map[string][]*lockHolder // of each project's lock, oldest first
+}
+
+// lockHolder is the operation holding a project lock, for telling whoever
+// else wants it.
+type lockHolder struct {
+	what  string
+	since time.Time
+}
+
+func (h lockHolder) String() string {
+	return fmt.Sprintf("%s since %s", h.what, h.since.Format("15:04:05"))
+}
+
+func (l *projectLocks) get(project string) chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.locks == nil {
+		l.locks = make(map[string]chan struct{})
+	}
+	ch, ok := l.locks[project]
+	if !ok {
+		ch = make(chan struct{}, 1)
+		l.locks[project] = ch
+	}
+	return ch
+}
+
+// lock acquires the lock for project, waiting at most timeout.  what names
+// the operation for the log and for other operations waiting.  Once it has
+// waited lockWaitNotice, and again every lockWaitNotice after, it says what
+// it is waiting for to w and the log.  The returned unlock func is safe to
+// call more than once, so callers can both defer it and release early.
+func (l *projectLocks) lock(project, what string, timeout time.Duration, w io.Writer) (unlock func(), err error) {
+	ch := l.get(project)
+	select {
+	case ch <- struct{}{}:
+		return l.hold(project, what, ch), nil
+	default:
+	}
+	started := time.Now()
+	defer l.wait(project, what, started)()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	var notice <-chan time.Time
+	if every := time.Duration(lockWaitNotice.Load()); every > 0 {
+		ticker := time.NewTicker(every)
+		defer ticker.Stop()
+		notice = ticker.C
+	}
+	noticed := false
+	for {
+		select {
+		case ch <- struct{}{}:
+			if noticed {
+				log.Printf("%s: acquired project %s lock after %s", what, project, time.Since(started).Round(time.Millisecond))
+			}
+			return l.hold(project, what, ch), nil
+		case <-notice:
+			noticed = true
+			msg := l.waitMessage(project, time.Since(started))
+			log.Printf("%s: %s", what, msg)
+			fmt.Fprintln(w, msg)
+		case <-timer.C:
+			if h, ok := l.holder(project); ok {
+				return nil, fmt.Errorf("project %s is busy: %s (waited %s)", project, h, timeout)
+			}
+			return nil, fmt.Errorf("project %s is busy with another operation (waited %s)", project, timeout)
+		}
+	}
+}
+
+// waitMessage says that an operation has waited for project's lock.
+func (l *projectLocks) waitMessage(project string, waited time.Duration) string {
+	if h, ok := l.holder(project); ok {
+		return fmt.Sprintf("waiting for project %s's lock, held by %s, %s so far", project, h, waited.Round(time.Second))
+	}
+	return fmt.Sprintf("waiting for project %s's lock, %s so far", project, waited.Round(time.Second))
+}
+
+// wait records what as waiting for project's lock since since, and returns
+// the func that records it has stopped.
+func (l *projectLocks) wait(project, what string, since time.Time) func() {
+	waiter := &lockHolder{what: what, since: since}
+	l.mu.Lock()
+	if l.waiters == nil {
+		l.waiters = make(map[string][]*lockHolder)
+	}
+	l.waiters[project] = append(l.waiters[project], waiter)
+	l.mu.Unlock()
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		waiters := l.waiters[project]
+		for i, w := range waiters {
+			if w == waiter {
+				waiters = append(waiters[:i:i], waiters[i+1:]...)
+				break
+			}
+		}
+		if len(waiters) == 0 {
+			delete(l.waiters, project)
+		} else {
+			l.waiters[project] = waiters
+		}
+	}
+}
+
+// snapshot returns the locks held and waited for now, by project name.
+func (l *projectLocks) snapshot() []proto.LockInfo {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var locks []proto.LockInfo
+	add := func(project string) {
+		info := proto.LockInfo{Project: project}
+		if h, ok := l.holders[project]; ok {
+			info.Holder, info.Since = h.what, h.since.Unix()
+		}
+		for _, w := range l.waiters[project] {
+			info.Waiting = append(info.Waiting, proto.LockWaiter{What: w.what, Since: w.since.Unix()})
+		}
+		locks = append(locks, info)
+	}
+	for project := range l.holders {
+		add(project)
+	}
+	for project := range l.waiters {
+		if _, ok := l.holders[project]; !ok {
+			add(project)
+		}
+	}
+	sort.Slice(locks, func(i, j int) bool { return locks[i].Project < locks[j].Project })
+	return locks
+}
+
+// tryLock acquires the lock for project if it is free.  Otherwise it
+// returns ok false and the operation holding it.
+func (l *projectLocks) tryLock(project, what string) (unlock func(), holder lockHolder, ok bool) {
+	ch := l.get(project)
+	select {
+	case ch <- struct{}{}:
+		return l.hold(project, what, ch), lockHolder{}, true
+	default:
+		holder, _ = l.holder(project)
+		return nil, holder, false
+	}
+}
+
+// hold records what as the holder of project's lock ch, just acquired, and
+// returns the func that releases it.
+func (l *projectLocks) hold(project, what string, ch chan struct{}) func() {
+	l.mu.Lock()
+	if l.holders == nil {
+		l.holders = make(map[string]lockHolder)
+	}
+	l.holders[project] = lockHolder{what: what, since: time.Now()}
+	l.mu.Unlock()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			delete(l.holders, project)
+			l.mu.Unlock()
+			<-ch
+		})
+	}
+}
+
+// holder returns the operation holding project's lock, if it is held.
+func (l *projectLocks) holder(project string) (lockHolder, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	h, ok := l.holders[project]
+	return h, ok
+}
+
+// RootLockFile is the file under the data root that the running daemon holds
+// locked.  It contains the daemon's PID.
+const RootLockFile = "groved.lock"
+
+// LockRoot takes an exclusive lock on rootDir so that only one daemon serves
+// it, and records this process's PID in the lock file.  It fails at once if
+// another daemon holds the lock.  The lock lasts until release is called or
+// the process exits, however it exits.
+func LockRoot(rootDir string) (release func(), err error) {
+	if err := os.MkdirAll(rootDir, 0o755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(rootDir, RootLockFile)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, fmt.Errorf("another groved is already running for %s", rootDir)
+		}
+		return nil, fmt.Errorf("lock %s: %w", path, err)
+	}
+	if err := f.Truncate(0); err == nil {
+		fmt.Fprintf(f, "%d\n", os.Getpid())
+	}
+	return func() { f.Close() }, nil
+}
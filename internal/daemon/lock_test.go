@@ -0,0 +1,197 @@
+package daemon
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProjectLocks(t *testing.T) {
+	var l projectLocks
+
+	unlock, err := l.lock("api", "first", time.Second, io.Discard)
+	require.NoError(t, err)
+
+	// Another project is independent.
+	unlockWeb, err := l.lock("web", "other", 10*time.Millisecond, io.Discard)
+	require.NoError(t, err)
+	unlockWeb()
+
+	_, err = l.lock("api", "second", 10*time.Millisecond, io.Discard)
+	assert.Error(t, err, "a held lock must time out")
+
+	acquired := make(chan struct{})
+	go func() {
+		unlock2, err := l.lock("api", "third", time.Second, io.Discard)
+		if err == nil {
+			unlock2()
+		}
+		close(acquired)
+	}()
+	unlock()
+	unlock() // releasing twice is a no-op
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("waiter did not acquire the lock after release")
+	}
+
+	unlock3, err := l.lock("api", "fourth", 10*time.Millisecond, io.Discard)
+	require.NoError(t, err, "double unlock must not leave the lock held or over-released")
+	unlock3()
+}
+
+func TestProjectLockHolder(t *testing.T) {
+	var l projectLocks
+
+	unlock, holder, ok := l.tryLock("api", "project shell")
+	require.True(t, ok)
+	assert.Equal(t, lockHolder{}, holder)
+
+	_, holder, ok = l.tryLock("api", "start 3")
+	assert.False(t, ok)
+	assert.Equal(t, "project shell", holder.what)
+	assert.WithinDuration(t, time.Now(), holder.since, time.Second)
+
+	_, err := l.lock("api", "start 3", 10*time.Millisecond, io.Discard)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "project api is busy: project shell since ")
+
+	unlock()
+	_, ok = l.holder("api")
+	assert.False(t, ok)
+	unlock, _, ok = l.tryLock("api", "start 3")
+	require.True(t, ok)
+	unlock()
+}
+
+// noticeWriter sends each write to a channel, for a writer a lock waiting
+// in another goroutine writes to.
+type noticeWriter chan string
+
+func (w noticeWriter) Write(p []byte) (int, error) {
+	w <- string(p)
+	return len(p), nil
+}
+
+func TestProjectLockWaitNotice(t *testing.T) {
+	SetLockWaitNotice(20 * time.Millisecond)
+	t.Cleanup(func() { SetLockWaitNotice(DefaultLockWaitNotice) })
+	var l projectLocks
+
+	unlock, err := l.lock("api", "start 4", time.Second, io.Discard)
+	require.NoError(t, err)
+	notices := make(noticeWriter, 100)
+	acquired := make(chan error)
+	go func() {
+		unlock2, err := l.lock("api", "drop 5", time.Second, notices)
+		if err == nil {
+			unlock2()
+		}
+		acquired <- err
+	}()
+
+	select {
+	case notice := <-notices:
+		assert.Regexp(t, `^waiting for project api's lock, held by start 4 since \d\d:\d\d:\d\d, \d+s so far\n$`, notice)
+	case <-time.After(time.Second):
+		t.Fatal("no notice while waiting")
+	}
+	select {
+	case <-notices:
+	case <-time.After(time.Second):
+		t.Fatal("the notice is not repeated")
+	}
+	unlock()
+	require.NoError(t, <-acquired)
+
+	for len(notices) > 0 {
+		<-notices // from before the lock was released
+	}
+
+	SetLockWaitNotice(0)
+	unlock, err = l.lock("api", "start 4", time.Second, io.Discard)
+	require.NoError(t, err)
+	_, err = l.lock("api", "drop 5", 100*time.Millisecond, notices)
+	assert.Error(t, err)
+	assert.Empty(t, notices, "no notice when it is off")
+	unlock()
+}
+
+func TestProjectLockSnapshot(t *testing.T) {
+	var l projectLocks
+	assert.Empty(t, l.snapshot())
+
+	unlock, err := l.lock("web", "fetch", time.Second, io.Discard)
+	require.NoError(t, err)
+	var wg sync.WaitGroup
+	for _, what := range []string{"start 4", "drop 2"} {
+		wg.Add(1)
+		go func(what string) {
+			defer wg.Done()
+			if unlock, err := l.lock("web", what, time.Second, io.Discard); err == nil {
+				unlock()
+			}
+		}(what)
+		// Wait for it to be waiting, so the order is known.
+		require.Eventually(t, func() bool {
+			locks := l.snapshot()
+			return len(locks) == 1 && len(locks[0].Waiting) > 0 && locks[0].Waiting[len(locks[0].Waiting)-1].What == what
+		}, time.Second, time.Millisecond)
+	}
+	unlockAPI, err := l.lock("api", "gc", time.Second, io.Discard)
+	require.NoError(t, err)
+
+	locks := l.snapshot()
+	require.Len(t, locks, 2)
+	assert.Equal(t, proto.LockInfo{Project: "api", Holder: "gc", Since: locks[0].Since}, locks[0])
+	assert.InDelta(t, time.Now().Unix(), locks[0].Since, 2)
+	assert.Equal(t, "web", locks[1].Project)
+	assert.Equal(t, "fetch", locks[1].Holder)
+	require.Len(t, locks[1].Waiting, 2)
+	assert.Equal(t, "start 4", locks[1].Waiting[0].What, "oldest first")
+	assert.Equal(t, "drop 2", locks[1].Waiting[1].What)
+
+	unlock()
+	unlockAPI()
+	wg.Wait()
+	assert.Empty(t, l.snapshot(), "nothing held or waited for")
+}
+
+func TestReserveInstanceID(t *testing.T) {
+	d := &Daemon{instances: make(map[string]*Instance)}
+	a := d.reserveInstanceID()
+	b := d.reserveInstanceID()
+	assert.Equal(t, "1", a)
+	assert.Equal(t, "2", b)
+
+	d.releaseInstanceID(a)
+	assert.Equal(t, "1", d.reserveInstanceID())
+}
+
+func TestLockRoot(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "grove")
+
+	release, err := LockRoot(root)
+	require.NoError(t, err)
+	data, err := os.ReadFile(filepath.Join(root, RootLockFile))
+	require.NoError(t, err)
+	assert.Equal(t, strconv.Itoa(os.Getpid()), strings.TrimSpace(string(data)))
+
+	_, err = LockRoot(root)
+	assert.ErrorContains(t, err, "another groved is already running")
+
+	release()
+	release, err = LockRoot(root)
+	require.NoError(t, err)
+	release()
+}
@@ -0,0 +1,249 @@
+package daemon
+
+// logsall.go – grove logs --all: every agent's recent output, merged.
+//
+// When something goes wrong machine-wide (a full disk, a Docker restart)
+// what each agent was printing around then matters more than any one log.
+// ReqLogsAll answers with the lines of every instance's agent output (of
+// one project, if asked), each with the instance it came from, without
+// escape sequences, and ordered by when it was output.
+//
+// logBuf holds only bytes, so each instance also keeps a logIndex: the
+// time each chunk of output arrived from the PTY.  A line is dated by the
+// chunk it began in, which is as near as the daemon can tell; lines of
+// different instances output within logMarkGrain of each other may come
+// out of order.
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+const (
+	// logMarkGrain is how close together chunks of output are dated as one.
+	logMarkGrain = 100 * time.Millisecond
+	// maxLogMarks bounds an instance's logIndex; the oldest marks go first.
+	maxLogMarks = 4096
+	// logsAllTick is how often grove logs --all --follow looks for output.
+	logsAllTick = 100 * time.Millisecond
+	// logPartialWait is how long grove logs --all --follow waits for the
+	// newline of a line before it sends the line without.
+	logPartialWait = time.Second
+)
+
+// ansiPattern matches terminal escape sequences, which logs --all strips.
+var ansiPattern = regexp.MustCompile(`\x1b\[[0-9;?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(\x07|\x1b\\)`)
+
+// logIndex dates the output in an agent's logBuf.  The zero value is
+// ready to use.
+type logIndex struct {
+	total int64     // bytes output since the agent was launched
+	marks []logMark // oldest first
+}
+
+// logMark says that output from offset on arrived at at.
+type logMark struct {
+	offset int64 // counted as logIndex.total is
+	at     time.Time
+}
+
+// add records n bytes of output arriving at at.
+func (x *logIndex) add(n int, at time.Time) {
+	if len(x.marks) == 0 || at.Sub(x.marks[len(x.marks)-1].at) >= logMarkGrain {
+		if len(x.marks) == maxLogMarks {
+			x.marks = append(x.marks[:0], x.marks[1:]...)
+		}
+		x.marks = append(x.marks, logMark{offset: x.total, at: at})
+	}
+	x.total += int64(n)
+}
+
+// timeAt returns when the byte at offset arrived: the time of the last
+// mark at or before it, or of the first mark if it is older than them all.
+func (x *logIndex) timeAt(offset int64) time.Time {
+	i := sort.Search(len(x.marks), func(i int) bool { return x.marks[i].offset > offset })
+	if i == 0 {
+		if len(x.marks) == 0 {
+			return time.Time{}
+		}
+		return x.marks[0].at
+	}
+	return x.marks[i-1].at
+}
+
+// logSource is how far grove logs --all has read one instance's output.
+type logSource struct {
+	inst      *Instance
+	offset    int64     // counted as logIndex.total is
+	partial   []byte    // a line whose newline has not come yet
+	partialAt time.Time // when partial began
+	grown     time.Time // when partial last grew
+}
+
+// read returns the lines inst has output since the last read.  A line
+// without its newline yet is kept for the next read, unless flush is set
+// or nothing has been output for logPartialWait.
+func (s *logSource) read(flush bool) []proto.LogLine {
+	inst := s.inst
+	inst.mu.Lock()
+	index := logIndex{total: inst.logIndex.total, marks: append([]logMark(nil), inst.logIndex.marks...)}
+	start := index.total - int64(len(inst.logBuf))
+	if s.offset > index.total {
+		// The agent was launched again since the last read.
+		s.offset, s.partial = 0, nil
+	}
+	if s.offset < start {
+		s.offset = start
+	}
+	data := append([]byte(nil), inst.logBuf[s.offset-start:]...)
+	inst.mu.Unlock()
+
+	var lines []proto.LogLine
+	emit := func(text []byte, at time.Time) {
+		if plain := plainLine(text); plain != "" {
+			lines = append(lines, proto.LogLine{InstanceID: inst.ID, Project: inst.Project, Branch: inst.Branch,
+				TimeMs: at.UnixMilli(), Text: plain})
+		}
+	}
+	now := time.Now()
+	if len(data) > 0 {
+		s.grown = now
+	} else if now.Sub(s.grown) >= logPartialWait {
+		flush = true
+	}
+	for len(data) > 0 {
+		if len(s.partial) == 0 {
+			s.partialAt = index.timeAt(s.offset)
+		}
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			s.partial = append(s.partial, data...)
+			s.offset += int64(len(data))
+			break
+		}
+		emit(append(s.partial, data[:i]...), s.partialAt)
+		s.partial = s.partial[:0]
+		s.offset += int64(i + 1)
+		data = data[i+1:]
+	}
+	if flush && len(s.partial) > 0 {
+		emit(s.partial, s.partialAt)
+		s.partial = s.partial[:0]
+	}
+	return lines
+}
+
+// plainLine returns line as it reads on screen: without escape sequences
+// or other control characters, and only the last of the versions a
+// carriage return redrew it with.
+func plainLine(line []byte) string {
+	text := ansiPattern.ReplaceAllString(strings.TrimRight(string(line), "\r"), "")
+	if i := strings.LastIndexByte(text, '\r'); i >= 0 {
+		text = text[i+1:]
+	}
+	text = strings.Map(func(r rune) rune {
+		if (r < ' ' && r != '\t') || r == 0x7f {
+			return -1
+		}
+		return r
+	}, text)
+	return strings.TrimRight(text, " \t")
+}
+
+// logSources returns a logSource for each instance of project (of every
+// project if empty) not in known, oldest first.
+func (d *Daemon) logSources(project string, known map[string]bool) []*logSource {
+	d.mu.Lock()
+	var insts []*Instance
+	for _, inst := range d.instances {
+		if (project == "" || inst.Project == project) && !known[inst.ID] {
+			insts = append(insts, inst)
+		}
+	}
+	d.mu.Unlock()
+	sort.Slice(insts, func(i, j int) bool {
+		if !insts[i].CreatedAt.Equal(insts[j].CreatedAt) {
+			return insts[i].CreatedAt.Before(insts[j].CreatedAt)
+		}
+		return insts[i].Seq < insts[j].Seq
+	})
+	sources := make([]*logSource, len(insts))
+	for i, inst := range insts {
+		sources[i] = &logSource{inst: inst}
+	}
+	return sources
+}
+
+// writeLogLines writes lines to conn, in the order they were output,
+// reporting false if the client has gone.
+func writeLogLines(conn net.Conn, lines []proto.LogLine) bool {
+	if len(lines) == 0 {
+		return true
+	}
+	sort.SliceStable(lines, func(i, j int) bool { return lines[i].TimeMs < lines[j].TimeMs })
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, line := range lines {
+		enc.Encode(line)
+	}
+	_, err := conn.Write(buf.Bytes())
+	return err == nil
+}
+
+// handleLogsAll streams the agent output of every instance, or of those of
+// req.Project, as LogLines after the response: what logBuf holds from
+// req.Since on, and then, with req.Follow, new output (of instances
+// started meanwhile too) until the client goes.
+func (d *Daemon) handleLogsAll(conn net.Conn, req proto.Request) {
+	sources := d.logSources(req.Project, nil)
+	if req.Project != "" && len(sources) == 0 {
+		respond(conn, proto.Response{OK: false, Error: "project " + req.Project + " has no instances"})
+		return
+	}
+	respond(conn, proto.Response{OK: true})
+
+	var lines []proto.LogLine
+	for _, s := range sources {
+		for _, line := range s.read(!req.Follow) {
+			if line.TimeMs >= req.Since*1000 {
+				lines = append(lines, line)
+			}
+		}
+	}
+	if !writeLogLines(conn, lines) || !req.Follow {
+		return
+	}
+
+	known := make(map[string]bool)
+	for _, s := range sources {
+		known[s.inst.ID] = true
+	}
+	gone := clientGone(conn)
+	ticker := time.NewTicker(logsAllTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-gone:
+			return
+		case <-ticker.C:
+		}
+		for _, s := range d.logSources(req.Project, known) {
+			known[s.inst.ID] = true
+			sources = append(sources, s)
+		}
+		lines = lines[:0]
+		for _, s := range sources {
+			lines = append(lines, s.read(false)...)
+		}
+		if !writeLogLines(conn, lines) {
+			return
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package daemon
+
+import (
+	"bufio"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// output appends data to inst's agent output as ptyReader does, as if it
+// arrived at at.
+func output(inst *Instance, data string, at time.Time) {
+	inst.mu.Lock()
+	inst.logBuf = append(inst.logBuf, data...)
+	inst.logIndex.add(len(data), at)
+	inst.mu.Unlock()
+}
+
+// logLines reads LogLines from r until want have come.
+func logLines(t *testing.T, r *bufio.Reader, want int) []proto.LogLine {
+	t.Helper()
+	var lines []proto.LogLine
+	for len(lines) < want {
+		data, err := r.ReadBytes('\n')
+		require.NoError(t, err)
+		var line proto.LogLine
+		require.NoError(t, json.Unmarshal(data, &line))
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// texts returns the "id: text" of each line.
+func texts(lines []proto.LogLine) []string {
+	var out []string
+	for _, line := range lines {
+		out = append(out, line.InstanceID+": "+line.Text)
+	}
+	return out
+}
+
+func TestLogIndex(t *testing.T) {
+	var x logIndex
+	t0 := time.Unix(1000, 0)
+	assert.True(t, x.timeAt(0).IsZero())
+	x.add(10, t0)
+	x.add(5, t0.Add(50*time.Millisecond)) // within the grain: one mark
+	x.add(5, t0.Add(time.Second))
+	assert.Equal(t, int64(20), x.total)
+	require.Len(t, x.marks, 2)
+	assert.Equal(t, t0, x.timeAt(0))
+	assert.Equal(t, t0, x.timeAt(14))
+	assert.Equal(t, t0.Add(time.Second), x.timeAt(15))
+	assert.Equal(t, t0.Add(time.Second), x.timeAt(100))
+
+	for i := 0; i < maxLogMarks+10; i++ {
+		x.add(1, t0.Add(time.Duration(i+2)*time.Second))
+	}
+	assert.Len(t, x.marks, maxLogMarks)
+	assert.Equal(t, t0.Add(12*time.Second), x.timeAt(0), "older output is dated by the oldest mark kept")
+}
+
+func TestPlainLine(t *testing.T) {
+	assert.Equal(t, "done", plainLine([]byte("\x1b[1;32mdone\x1b[0m\r")))
+	assert.Equal(t, "100%", plainLine([]byte("10%\r50%\r100%")), "the last redraw")
+	assert.Equal(t, "a\tb", plainLine([]byte("a\tb\x07\x1b]0;title\x07  ")))
+	assert.Equal(t, "", plainLine([]byte("\x1b[2K\r")))
+}
+
+func TestLogsAll(t *testing.T) {
+	t0 := time.Now().Add(-time.Hour)
+	api := &Instance{ID: "1", Project: "api", Branch: "feat-a", CreatedAt: t0}
+	web := &Instance{ID: "2", Project: "web", Branch: "feat-b", CreatedAt: t0.Add(time.Second)}
+	output(api, "old\n", t0)
+	output(api, "a1\n", t0.Add(10*time.Minute))
+	output(web, "b1\n\x1b[1mb2\x1b[0m\n", t0.Add(20*time.Minute))
+	output(api, "a2\npart", t0.Add(30*time.Minute))
+	d := &Daemon{instances: map[string]*Instance{"1": api, "2": web}}
+
+	resp, out, _ := streamedLines(t, d, proto.Request{Type: proto.ReqLogsAll, Since: t0.Add(time.Minute).Unix()})
+	require.True(t, resp.OK)
+	assert.Equal(t, []string{"1: a1", "2: b1", "2: b2", "1: a2", "1: part"}, texts(out), "merged by time, the old line left out")
+	assert.Equal(t, proto.LogLine{InstanceID: "2", Project: "web", Branch: "feat-b", TimeMs: t0.Add(20 * time.Minute).UnixMilli(), Text: "b1"}, out[1])
+
+	_, out, _ = streamedLines(t, d, proto.Request{Type: proto.ReqLogsAll, Project: "web"})
+	assert.Equal(t, []string{"2: b1", "2: b2"}, texts(out))
+	resp, _, _ = streamedLines(t, d, proto.Request{Type: proto.ReqLogsAll, Project: "docs"})
+	assert.Equal(t, "project docs has no instances", resp.Error)
+}
+
+// streamedLines sends req to handleLogsAll and returns its response and
+// the lines that follow it.
+func streamedLines(t *testing.T, d *Daemon, req proto.Request) (proto.Response, []proto.LogLine, error) {
+	t.Helper()
+	client, _ := serveRequest(d.handleLogsAll, req)
+	defer client.Close()
+	dec := json.NewDecoder(client)
+	var resp proto.Response
+	require.NoError(t, dec.Decode(&resp))
+	var lines []proto.LogLine
+	for {
+		var line proto.LogLine
+		if err := dec.Decode(&line); err != nil {
+			return resp, lines, err
+		}
+		lines = append(lines, line)
+	}
+}
+
+func TestLogsAllFollow(t *testing.T) {
+	api := &Instance{ID: "1", Project: "api", Branch: "feat-a"}
+	output(api, "a1\npart", time.Now())
+	d := &Daemon{instances: map[string]*Instance{"1": api}}
+
+	client, _ := serveRequest(d.handleLogsAll, proto.Request{Type: proto.ReqLogsAll, Follow: true})
+	defer client.Close()
+	r := bufio.NewReader(client)
+	_, err := r.ReadBytes('\n') // the response
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"1: a1"}, texts(logLines(t, r, 1)))
+	output(api, "ial\n", time.Now())
+	assert.Equal(t, []string{"1: partial"}, texts(logLines(t, r, 1)), "a line is held for its newline")
+
+	web := &Instance{ID: "2", Project: "web", Branch: "feat-b"}
+	output(web, "b1\n", time.Now())
+	d.mu.Lock()
+	d.instances["2"] = web
+	d.mu.Unlock()
+	assert.Equal(t, []string{"2: b1"}, texts(logLines(t, r, 1)), "instances started meanwhile are followed too")
+
+	output(api, "no newline", time.Now())
+	assert.Equal(t, []string{"1: no newline"}, texts(logLines(t, r, 1)), "once output stops")
+
+	// The agent is launched again: its output starts over.
+	api.mu.Lock()
+	api.logBuf, api.logIndex = nil, logIndex{}
+	api.mu.Unlock()
+	output(api, "again\n", time.Now())
+	assert.Equal(t, []string{"1: again"}, texts(logLines(t, r, 1)))
+}
@@ -0,0 +1,96 @@
+package daemon
+
+// operation.go – one long-running operation per instance at a time.
+//
+// check, finish, restart and reopen each change the instance's state and
+// exec into its container for a while.  Run together they race: a finish
+// that stops the agent under a running check left the instance in CHECKING
+// for good, and their output interleaved in the log.  So each takes the
+// instance's operation slot first and a second caller is refused with an
+// operationInProgressError naming the one that holds it.
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+// Operation names, as reported to a refused caller.
+const (
+	opCheck   = "check"
+	opFinish  = "finish"
+	opRestart = "restart"
+	opReopen  = "reopen"
+)
+
+// operation is the long-running operation an instance is busy with.
+type operation struct {
+	name    string
+	started time.Time
+}
+
+// operationInProgressError is returned by beginOperation when the instance
+// is busy.
+type operationInProgressError struct {
+	instanceID string
+	active     operation
+}
+
+func (e *operationInProgressError) Error() string {
+	return fmt.Sprintf("instance %s is busy: %s in progress since %s (%s ago)",
+		e.instanceID, e.active.name, e.active.started.Format("15:04:05"),
+		time.Since(e.active.started).Round(time.Second))
+}
+
+// beginOperation claims inst for the operation name, or returns an
+// *operationInProgressError if another operation holds it.  The returned
+// end func releases it and is safe to call more than once.
+func (inst *Instance) beginOperation(name string) (end func(), err error) {
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+	if inst.operation != nil {
+		return nil, &operationInProgressError{instanceID: inst.ID, active: *inst.operation}
+	}
+	inst.operation = &operation{name: name, started: time.Now()}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			inst.mu.Lock()
+			inst.operation = nil
+			inst.mu.Unlock()
+		})
+	}, nil
+}
+
+// errorResponse is the response refusing a request with err; an
+// operationInProgressError, insufficientDiskError, dataDirUnavailableError
+// or recognised gitError also gets its machine-readable details.
+func errorResponse(err error) proto.Response {
+	resp := proto.Response{OK: false, Error: err.Error()}
+	var busy *operationInProgressError
+	if errors.As(err, &busy) {
+		resp.ErrorCode = proto.ErrCodeOperationInProgress
+		resp.Operation = &proto.Operation{Name: busy.active.name, Started: busy.active.started.Unix()}
+	}
+	var disk *insufficientDiskError
+	if errors.As(err, &disk) {
+		resp.ErrorCode = proto.ErrCodeInsufficientDisk
+		resp.Health = health()
+		resp.Health.Disk, resp.Health.DiskWarn, resp.Health.DiskMin = disk.spaces, diskWarn.Load(), disk.min
+	}
+	var dataDir *dataDirUnavailableError
+	if errors.As(err, &dataDir) {
+		resp.ErrorCode = proto.ErrCodeDataDirUnavailable
+	}
+	var cancelled *startCancelledError
+	if errors.As(err, &cancelled) {
+		resp.ErrorCode = proto.ErrCodeStartCancelled
+	}
+	if code, hint := gitFailure(err); code != "" {
+		resp.ErrorCode, resp.Hint = code, hint
+	}
+	return resp
+}
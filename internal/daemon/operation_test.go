@@ -0,0 +1,79 @@
+package daemon
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBeginOperationRefusesConcurrentPairs(t *testing.T) {
+	ops := []string{opCheck, opFinish, opRestart, opReopen}
+	for _, first := range ops {
+		for _, second := range ops {
+			t.Run(first+"/"+second, func(t *testing.T) {
+				inst := &Instance{ID: "3"}
+				end, err := inst.beginOperation(first)
+				require.NoError(t, err)
+
+				_, err = inst.beginOperation(second)
+				var busy *operationInProgressError
+				require.ErrorAs(t, err, &busy)
+				assert.Equal(t, first, busy.active.name)
+				assert.Contains(t, err.Error(), "instance 3 is busy: "+first+" in progress since")
+
+				end()
+				end() // ending twice is a no-op
+				endSecond, err := inst.beginOperation(second)
+				require.NoError(t, err, "the slot is free once the first operation ends")
+				endSecond()
+			})
+		}
+	}
+}
+
+func TestErrorResponse(t *testing.T) {
+	inst := &Instance{ID: "3"}
+	end, err := inst.beginOperation(opFinish)
+	require.NoError(t, err)
+	defer end()
+	_, err = inst.beginOperation(opCheck)
+
+	resp := errorResponse(err)
+	assert.False(t, resp.OK)
+	assert.Equal(t, proto.ErrCodeOperationInProgress, resp.ErrorCode)
+	require.NotNil(t, resp.Operation)
+	assert.Equal(t, opFinish, resp.Operation.Name)
+	assert.InDelta(t, time.Now().Unix(), resp.Operation.Started, 2)
+
+	plain := errorResponse(assert.AnError)
+	assert.Empty(t, plain.ErrorCode)
+	assert.Nil(t, plain.Operation)
+}
+
+func TestRestartRefusedDuringFinish(t *testing.T) {
+	inst := &Instance{ID: "3", state: proto.StateFinished}
+	d := &Daemon{instances: map[string]*Instance{"3": inst}}
+	end, err := inst.beginOperation(opFinish)
+	require.NoError(t, err)
+	defer end()
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		d.handleRestart(server, proto.Request{Type: proto.ReqRestart, InstanceID: "3"})
+		server.Close()
+	}()
+
+	var resp proto.Response
+	require.NoError(t, json.NewDecoder(client).Decode(&resp))
+	assert.False(t, resp.OK)
+	assert.Equal(t, proto.ErrCodeOperationInProgress, resp.ErrorCode)
+	require.NotNil(t, resp.Operation)
+	assert.Equal(t, opFinish, resp.Operation.Name)
+	assert.Equal(t, proto.StateFinished, inst.Info().State, "the refused restart changes nothing")
+}
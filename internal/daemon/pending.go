@@ -0,0 +1,267 @@
+package daemon
+
+// pending.go – finish work that survives a daemon restart.
+//
+// grove finish marks an instance FINISHED before running its finish
+// commands, which usually push the branch and open a pull request.  If the
+// daemon died part-way, nothing would show that those steps never ran.  So
+// before the first command the daemon records the commands still to run in
+// <root>/instances/<id>.finish-pending, updates the record as each one
+// completes and removes it at the end.  On startup a leftover record is
+// resumed in the background if the instance's container is still running;
+// otherwise the instance is marked FINISH_FAILED with a note saying what did
+// not run.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+// pendingFinishExt names pending finish records; it is not ".json" so the
+// instance loader passes over them.
+const pendingFinishExt = ".finish-pending"
+
+// pendingFinish is the on-disk record of a finish in progress.  Commands are
+// stored resolved — {{branch}} expanded, dir absolute — so resuming needs
+// neither the project nor its config.
+type pendingFinish struct {
+	Started   int64                 `json:"started"` // unix timestamp of the grove finish
+	Remaining []CommandSpec         `json:"remaining"`
+	Done      []proto.CommandResult `json:"done,omitempty"`
+}
+
+// pendingFinishPath returns where the pending finish of instance id is
+// recorded.
+func pendingFinishPath(instancesDir, id string) string {
+	return filepath.Join(instancesDir, id+pendingFinishExt)
+}
+
+// resolveFinishCommands returns p's finish commands as they run for branch.
+func resolveFinishCommands(p *Project, branch string) []CommandSpec {
+	cmds := make([]CommandSpec, len(p.Finish))
+	for i, c := range p.Finish {
+		c.Run = strings.ReplaceAll(c.Run, "{{branch}}", branch)
+		if !c.Host {
+			c.Dir = p.commandDir(c)
+		}
+		cmds[i] = c
+	}
+	return cmds
+}
+
+// writePendingFinish records pf for instance id, via a temporary file so a
+// crash never leaves half a record.
+func writePendingFinish(instancesDir, id string, pf *pendingFinish) error {
+	data, err := json.MarshalIndent(pf, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := pendingFinishPath(instancesDir, id)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// readPendingFinish reads the pending finish record at path.
+func readPendingFinish(path string) (*pendingFinish, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var pf pendingFinish
+	if err := json.Unmarshal(data, &pf); err != nil {
+		return nil, fmt.Errorf("pending finish %s: %w", filepath.Base(path), err)
+	}
+	return &pf, nil
+}
+
+// commandLabels returns the label of each of cmds.
+func commandLabels(cmds []CommandSpec) []string {
+	labels := make([]string, len(cmds))
+	for i, c := range cmds {
+		labels[i] = c.label()
+	}
+	return labels
+}
+
+// setPendingFinish publishes pf's progress on inst for grove status; nil
+// clears it.
+func (inst *Instance) setPendingFinish(pf *pendingFinish, resumed bool) {
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+	if pf == nil {
+		inst.pendingFinish = nil
+		return
+	}
+	inst.pendingFinish = &proto.PendingFinish{
+		Started:   pf.Started,
+		Remaining: commandLabels(pf.Remaining),
+		Resumed:   resumed,
+	}
+}
+
+// runFinishCommands runs the commands left in pf in inst's container,
+// writing their output to w, until one fails.  The record on disk follows
+// along and is removed at the end.  It returns the results of every command
+// of this finish, including any run before a restart.
+func (d *Daemon) runFinishCommands(inst *Instance, pf *pendingFinish, resumed bool, stdin *clientStdin, w io.Writer) []proto.CommandResult {
+	instancesDir := filepath.Join(d.rootDir, "instances")
+	defer func() {
+		os.Remove(pendingFinishPath(instancesDir, inst.ID))
+		inst.setPendingFinish(nil, false)
+	}()
+
+	for len(pf.Remaining) > 0 {
+		if err := writePendingFinish(instancesDir, inst.ID, pf); err != nil {
+			log.Printf("instance %s: could not record pending finish: %v", inst.ID, err)
+		}
+		inst.setPendingFinish(pf, resumed)
+
+		c := pf.Remaining[0]
+		fmt.Fprintf(w, "$ %s\n", c.Run)
+		opts := execOptions{Workdir: c.Dir, Timeout: c.Timeout, TTY: c.TTY, Stdin: stdin}
+		res, err := d.runInstanceCommand(inst, proto.CommandKindFinish, c, opts, w)
+		pf.Done = append(pf.Done, res)
+		pf.Remaining = pf.Remaining[1:]
+		if err != nil {
+			fmt.Fprintf(w, "error: command failed: %v\n", err)
+			log.Printf("instance %s: finish command failed: %v", inst.ID, err)
+			break
+		}
+	}
+	return pf.Done
+}
+
+// recordFinish keeps the outcome of a finish on inst, where it survives a
+// later reopen, and announces it.
+func (d *Daemon) recordFinish(inst *Instance, res proto.StreamResult, summary string) {
+	inst.mu.Lock()
+	inst.lastFinish = &proto.CheckResult{Time: time.Now().Unix(), Result: res}
+	inst.mu.Unlock()
+	inst.persistMeta(filepath.Join(d.rootDir, "instances"))
+
+	info := inst.Info()
+	d.events.publish(proto.Event{
+		Time:       time.Now().Unix(),
+		Type:       proto.EventFinish,
+		InstanceID: info.ID,
+		Project:    info.Project,
+		Branch:     info.Branch,
+		BaseSHA:    info.BaseSHA,
+		State:      info.State,
+		Summary:    summary,
+		Result:     &res,
+	})
+	// Finish commands usually push; see whether the branch made it.
+	go d.refreshRemote(inst, true)
+}
+
+// finishSummary renders a one-line outcome such as "finish failed: git push
+// (exit 1)" for events.
+func finishSummary(res proto.StreamResult) string {
+	if res.OK {
+		return fmt.Sprintf("finish commands passed (%d)", len(res.Commands))
+	}
+	if res.Error != "" {
+		return "finish failed: " + res.Error
+	}
+	var failed []string
+	for _, c := range res.Commands {
+		if c.ExitCode != 0 {
+			failed = append(failed, fmt.Sprintf("%s (exit %d)", c.Label(), c.ExitCode))
+		}
+	}
+	return "finish failed: " + strings.Join(failed, ", ")
+}
+
+// resumePendingFinishes takes up the finish records left by a daemon that
+// stopped part-way through a finish.  It is called once at startup.
+func (d *Daemon) resumePendingFinishes() {
+	instancesDir := filepath.Join(d.rootDir, "instances")
+	paths, _ := filepath.Glob(filepath.Join(instancesDir, "*"+pendingFinishExt))
+	for _, path := range paths {
+		id := strings.TrimSuffix(filepath.Base(path), pendingFinishExt)
+		inst := d.getInstance(id)
+		if inst == nil {
+			os.Remove(path)
+			continue
+		}
+		pf, err := readPendingFinish(path)
+		if err != nil {
+			log.Printf("instance %s: %v", id, err)
+			pf = &pendingFinish{Started: time.Now().Unix()}
+			d.failPendingFinish(inst, pf, "its record could not be read")
+			continue
+		}
+
+		switch {
+		case inst.ContainerID == "" || !containerRunning(inst.ContainerID):
+			d.failPendingFinish(inst, pf, "its container is no longer running")
+		case requireTerminal("finish", pf.Remaining) != nil:
+			d.failPendingFinish(inst, pf, "a remaining command needs a terminal")
+		default:
+			// Claimed now, not in the goroutine, so that no check or
+			// restart gets in first.
+			endOp, err := inst.beginOperation(opFinish)
+			if err != nil {
+				d.failPendingFinish(inst, pf, err.Error())
+				continue
+			}
+			go func() {
+				defer endOp()
+				d.resumeFinish(inst, pf)
+			}()
+		}
+	}
+}
+
+// resumeFinish runs the rest of an interrupted finish, writing its output to
+// the instance log.
+func (d *Daemon) resumeFinish(inst *Instance, pf *pendingFinish) {
+	log.Printf("instance %s: resuming finish (%d commands left)", inst.ID, len(pf.Remaining))
+	var w io.Writer = io.Discard
+	if logFd, err := os.OpenFile(inst.LogFile, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0o644); err == nil {
+		defer logFd.Close()
+		w = logFd
+	}
+	fmt.Fprintf(w, "\n[grove] resuming finish after a daemon restart\n")
+
+	results := d.runFinishCommands(inst, pf, true, nil, w)
+	res := streamResult(time.Unix(pf.Started, 0), results)
+	d.recordFinish(inst, res, "resumed "+finishSummary(res))
+}
+
+// failPendingFinish gives up on an interrupted finish: the instance becomes
+// FINISH_FAILED and a note records which commands did not run, so the user
+// can run grove finish again.
+func (d *Daemon) failPendingFinish(inst *Instance, pf *pendingFinish, why string) {
+	log.Printf("instance %s: not resuming finish: %s", inst.ID, why)
+	os.Remove(pendingFinishPath(filepath.Join(d.rootDir, "instances"), inst.ID))
+
+	msg := "finish was interrupted by a daemon restart and not resumed: " + why
+	note := msg
+	if len(pf.Remaining) > 0 {
+		note += "; not run: " + strings.Join(commandLabels(pf.Remaining), ", ")
+	}
+	note += ". Run grove finish " + inst.ID + " to retry."
+
+	inst.mu.Lock()
+	inst.setState(proto.StateFinishFailed)
+	inst.notes = append(inst.notes, proto.Note{Time: time.Now().Unix(), Text: note})
+	inst.mu.Unlock()
+
+	res := streamResult(time.Unix(pf.Started, 0), pf.Done)
+	res.OK = false
+	res.Error = msg
+	d.recordFinish(inst, res, finishSummary(res))
+}
@@ -0,0 +1,151 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeFinishDocker installs a docker that reports containers as running or
+// not, logs each exec'd command to the returned file, and fails commands
+// containing "exit 3".
+func fakeFinishDocker(t *testing.T, running bool) string {
+	t.Helper()
+	bin := installFakeDocker(t, `case "$1" in
+  inspect) echo `+map[bool]string{true: "true", false: "false"}[running]+` ;;
+  exec) eval last=\${$#}; echo "$last" >> "$(dirname "$0")/exec.log"
+        case "$last" in *"exit 3"*) exit 3 ;; esac ;;
+esac
+`)
+	return filepath.Join(bin, "exec.log")
+}
+
+// pendingFinishDaemon returns a daemon with one FINISHED instance "1" and a
+// pending finish record for it.
+func pendingFinishDaemon(t *testing.T, pf *pendingFinish) (*Daemon, *Instance) {
+	t.Helper()
+	root := t.TempDir()
+	instancesDir := filepath.Join(root, "instances")
+	require.NoError(t, os.MkdirAll(instancesDir, 0o755))
+	inst := &Instance{
+		ID:          "1",
+		Project:     "app",
+		Branch:      "feat/a",
+		WorktreeDir: filepath.Join(root, "gone"),
+		ContainerID: "grove-1",
+		LogFile:     filepath.Join(root, "1.log"),
+		state:       proto.StateFinished,
+	}
+	d := &Daemon{rootDir: root, instances: map[string]*Instance{"1": inst}}
+	require.NoError(t, writePendingFinish(instancesDir, "1", pf))
+	return d, inst
+}
+
+func TestResolveFinishCommands(t *testing.T) {
+	p := &Project{Finish: []CommandSpec{
+		{Run: "git push -u origin {{branch}}"},
+		{Run: "make release", Dir: "tools", Name: "release"},
+		{Run: "./notify {{branch}}", Dir: "scripts", Host: true},
+	}}
+	assert.Equal(t, []CommandSpec{
+		{Run: "git push -u origin feat/a"},
+		{Run: "make release", Dir: "/app/tools", Name: "release"},
+		{Run: "./notify feat/a", Dir: "scripts", Host: true}, // relative to the worktree
+	}, resolveFinishCommands(p, "feat/a"))
+}
+
+func TestResumePendingFinish(t *testing.T) {
+	execLog := fakeFinishDocker(t, true)
+	d, inst := pendingFinishDaemon(t, &pendingFinish{
+		Started:   time.Now().Add(-time.Minute).Unix(),
+		Remaining: []CommandSpec{{Run: "git push"}, {Run: "gh pr create", Name: "pr"}},
+		Done:      []proto.CommandResult{{Command: "make dist"}},
+	})
+	events := d.events.subscribe()
+	defer d.events.unsubscribe(events)
+
+	d.resumePendingFinishes()
+	var ev proto.Event
+	select {
+	case ev = <-events:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event for the resumed finish")
+	}
+	assert.Equal(t, proto.EventFinish, ev.Type)
+	assert.Equal(t, "resumed finish commands passed (3)", ev.Summary)
+
+	ran, err := os.ReadFile(execLog)
+	require.NoError(t, err)
+	assert.Equal(t, "git push\ngh pr create\n", string(ran), "only the commands left are run")
+
+	info := inst.Info()
+	assert.Equal(t, proto.StateFinished, info.State)
+	assert.Nil(t, info.PendingFinish)
+	require.NotNil(t, info.LastFinish)
+	assert.True(t, info.LastFinish.Result.OK)
+	assert.Len(t, info.LastFinish.Result.Commands, 3)
+	assert.NoFileExists(t, pendingFinishPath(filepath.Join(d.rootDir, "instances"), "1"))
+}
+
+func TestPendingFinishWithoutContainer(t *testing.T) {
+	execLog := fakeFinishDocker(t, false)
+	d, inst := pendingFinishDaemon(t, &pendingFinish{
+		Started:   time.Now().Unix(),
+		Remaining: []CommandSpec{{Run: "git push"}},
+	})
+
+	d.resumePendingFinishes()
+	assert.NoFileExists(t, execLog, "nothing is run")
+	assert.NoFileExists(t, pendingFinishPath(filepath.Join(d.rootDir, "instances"), "1"))
+
+	info := inst.Info()
+	assert.Equal(t, proto.StateFinishFailed, info.State)
+	require.Len(t, info.Notes, 1)
+	assert.Contains(t, info.Notes[0].Text, "container is no longer running; not run: git push")
+	assert.Contains(t, info.Notes[0].Text, "Run grove finish 1 to retry.")
+	require.NotNil(t, info.LastFinish)
+	assert.False(t, info.LastFinish.Result.OK)
+
+	// The corrected state is what a later daemon loads.
+	data, err := os.ReadFile(filepath.Join(d.rootDir, "instances", "1.json"))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), proto.StateFinishFailed)
+}
+
+func TestRunFinishCommandsStopsAtFailure(t *testing.T) {
+	execLog := fakeFinishDocker(t, true)
+	d, inst := pendingFinishDaemon(t, &pendingFinish{})
+	pf := &pendingFinish{
+		Started:   time.Now().Unix(),
+		Remaining: []CommandSpec{{Run: "echo one"}, {Run: "exit 3"}, {Run: "echo never"}},
+	}
+
+	var out strings.Builder
+	results := d.runFinishCommands(inst, pf, false, nil, &out)
+	require.Len(t, results, 2)
+	assert.Equal(t, 3, results[1].ExitCode)
+	assert.Contains(t, out.String(), "error: command failed")
+
+	ran, err := os.ReadFile(execLog)
+	require.NoError(t, err)
+	assert.Equal(t, "echo one\nexit 3\n", string(ran))
+	assert.NoFileExists(t, pendingFinishPath(filepath.Join(d.rootDir, "instances"), "1"),
+		"a finish that ran to its end, even a failing one, leaves no record")
+}
+
+func TestOrphanPendingFinishRemoved(t *testing.T) {
+	fakeFinishDocker(t, true)
+	d, _ := pendingFinishDaemon(t, &pendingFinish{})
+	orphan := pendingFinishPath(filepath.Join(d.rootDir, "instances"), "9")
+	require.NoError(t, os.WriteFile(orphan, []byte("{}"), 0o644))
+	delete(d.instances, "1")
+
+	d.resumePendingFinishes()
+	assert.NoFileExists(t, orphan)
+}
@@ -10,13 +10,18 @@ import (
 	"sync"
 	"time"
 
-	"github.com/gandalfthegui/grove/internal/proto"
+	"github.com/gandalfthegui/grove/internal/registry"
+	"github.com/gandalfthegui/grove/pkg/proto"
 )
 
 // loadPersistedInstances reads instance JSON files written by previous daemon
 // runs and re-registers them with the correct state.  Instances that were
-// RUNNING/WAITING/ATTACHED when the daemon was killed are marked as CRASHED.
-// EXITED, CRASHED, and FINISHED states are preserved as-is.
+// RUNNING/WAITING/ATTACHED/READY when the daemon was killed are marked as CRASHED,
+// and so are their helper agents (see helpers.go), unless grove stop had
+// already been asked to kill them: those are KILLED.
+// A CHECKING instance is treated as being in the state its check would have
+// returned it to, with a note.
+// EXITED, CRASHED, KILLED, FINISHED and FINISH_FAILED states are preserved as-is.
 func (d *Daemon) loadPersistedInstances() error {
 	instancesDir := filepath.Join(d.rootDir, "instances")
 	entries, err := os.ReadDir(instancesDir)
@@ -44,29 +49,109 @@ func (d *Daemon) loadPersistedInstances() error {
 			endedAt = time.Unix(info.EndedAt, 0)
 		}
 
-		// If the daemon was killed mid-run, the process is gone → CRASHED.
-		if state == proto.StateRunning || state == proto.StateWaiting || state == proto.StateAttached {
+		var stateSince time.Time
+		if info.StateSince > 0 {
+			stateSince = time.Unix(info.StateSince, 0)
+		}
+
+		// A check cannot have survived the restart: the instance goes back
+		// to the state the check would have left it in, and from there
+		// follows the agent.
+		if state == proto.StateChecking {
+			state = proto.StateWaiting
+			if info.Checking != nil && info.Checking.Return != "" {
+				state = info.Checking.Return
+			}
+			info.Notes = append(info.Notes, proto.Note{Time: time.Now().Unix(), Text: "check interrupted by a daemon restart"})
+		}
+
+		// If the daemon was killed mid-run, the process is gone → CRASHED,
+		// or KILLED if it was being stopped anyway.
+		endReason := info.EndReason
+		switch state {
+		case proto.StateRunning, proto.StateWaiting, proto.StateAttached, proto.StateReady:
 			state = proto.StateCrashed
+			if endReason == proto.EndStopped {
+				state = proto.StateKilled
+			} else {
+				endReason = proto.EndDaemonExit
+			}
 			endedAt = time.Now()
+			stateSince = endedAt
+		}
+
+		// Instances recorded before MainDir was stored belong to the
+		// project's checkout under the daemon root.  Their WorktreeDir
+		// (worktrees/<id>) is kept as recorded.
+		mainDir := info.MainDir
+		if mainDir == "" {
+			mainDir = filepath.Join(registry.PersonalDir(d.rootDir), info.Project, "main")
 		}
 
 		inst := &Instance{
-			ID:             info.ID,
-			Project:        info.Project,
-			Branch:         info.Branch,
-			WorktreeDir:    info.WorktreeDir,
-			CreatedAt:      time.Unix(info.CreatedAt, 0),
-			LogFile:        filepath.Join(d.rootDir, "logs", info.ID+".log"),
-			state:          state,
-			endedAt:        endedAt,
-			InstancesDir:   instancesDir,
-			ContainerID:    info.ContainerID,
-			ComposeProject: info.ComposeProject,
+			ID:               info.ID,
+			Project:          info.Project,
+			Branch:           info.Branch,
+			WorktreeDir:      info.WorktreeDir,
+			MainDir:          mainDir,
+			CreatedAt:        time.Unix(info.CreatedAt, 0),
+			Seq:              info.Seq,
+			LogFile:          filepath.Join(d.rootDir, "logs", info.ID+".log"),
+			state:            state,
+			stateChangedAt:   stateSince,
+			endedAt:          endedAt,
+			endReason:        endReason,
+			exitCode:         info.ExitCode,
+			InstancesDir:     instancesDir,
+			ContainerID:      info.ContainerID,
+			ComposeProject:   info.ComposeProject,
+			notes:            info.Notes,
+			labels:           info.Labels,
+			summary:          info.Summary,
+			lastCheck:        info.LastCheck,
+			lastFinish:       info.LastFinish,
+			setupLog:         info.SetupLog,
+			runs:             info.Runs,
+			remote:           info.Remote,
+			Launch:           info.Launch,
+			Task:             info.Task,
+			Mode:             info.Mode,
+			StartTimings:     info.StartTimings,
+			Scratch:          info.Scratch,
+			image:            info.Image,
+			stackStopped:     info.StackStopped,
+			environment:      info.Environment,
+			usage:            info.Usage,
+			baseSHA:          info.BaseSHA,
+			containerRemoved: info.ContainerRemoved,
+			worktreeRemoved:  info.WorktreeRemoved,
+			ttlAction:        info.TTLAction,
+			pinned:           info.Pinned,
+			emit:             d.events.publish,
+			record:           d.recordHistory,
+		}
+		if info.ExpiresAt != 0 {
+			inst.expiresAt = time.Unix(info.ExpiresAt, 0)
+		}
+		// Older records have no launch config; recover the agent command
+		// from the first recorded run.  Container settings stay unknown and
+		// are taken from the project's current config.
+		migrated := false
+		if inst.Launch == nil {
+			inst.Launch = launchFromRuns(info.Runs)
+			migrated = inst.Launch != nil
+		}
+		inst.restoreHelpers(info.Agents, time.Now())
+		helpersCrashed := false
+		for _, a := range info.Agents {
+			helpersCrashed = helpersCrashed || !proto.IsTerminal(a.State)
 		}
 		d.instances[info.ID] = inst
+		d.lastSeq = max(d.lastSeq, info.Seq)
 
-		// Persist the corrected state if it changed (e.g., RUNNING → CRASHED).
-		if state != info.State {
+		// Persist the corrected state if it changed (e.g., RUNNING → CRASHED)
+		// or fields missing from an older record were filled in.
+		if state != info.State || mainDir != info.MainDir || migrated || helpersCrashed {
 			inst.persistMeta(instancesDir)
 		}
 	}
@@ -74,20 +159,37 @@ func (d *Daemon) loadPersistedInstances() error {
 	return nil
 }
 
+// launchFromRuns reconstructs the agent part of a launch config from an
+// instance's recorded runs: the first run that was not a resume used the
+// configured command and arguments.  It returns nil if there is none.
+func launchFromRuns(runs []proto.AgentRun) *proto.LaunchConfig {
+	for _, r := range runs {
+		if !r.Resumed && len(r.Argv) > 0 {
+			return &proto.LaunchConfig{AgentCommand: r.Argv[0], AgentArgs: r.Argv[1:]}
+		}
+	}
+	return nil
+}
+
 // logAgentCredentials logs which credential keys are present in agentEnv so
 // auth problems can be diagnosed from the daemon log without exposing values.
 func logAgentCredentials(instanceID string, agentEnv map[string]string) {
+	if found := claudeCredentials(agentEnv); len(found) > 0 {
+		log.Printf("instance %s: claude credentials present: %s", instanceID, strings.Join(found, ", "))
+	} else {
+		log.Printf("instance %s: WARNING no claude credentials found — agent will show login screen", instanceID)
+	}
+}
+
+// claudeCredentials returns the names of the claude credentials set in env.
+func claudeCredentials(env map[string]string) []string {
 	var found []string
 	for _, k := range []string{"CLAUDE_CODE_OAUTH_TOKEN", "ANTHROPIC_API_KEY"} {
-		if agentEnv[k] != "" {
+		if env[k] != "" {
 			found = append(found, k)
 		}
 	}
-	if len(found) > 0 {
-		log.Printf("instance %s: claude credentials present: %s", instanceID, strings.Join(found, ", "))
-	} else {
-		log.Printf("instance %s: WARNING no claude credentials found — agent will show login screen", instanceID)
-	}
+	return found
 }
 
 // ─── resilientWriter ──────────────────────────────────────────────────────────
@@ -0,0 +1,147 @@
+package daemon
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPersistedNotesSurviveReload(t *testing.T) {
+	root := t.TempDir()
+	instancesDir := filepath.Join(root, "instances")
+	require.NoError(t, os.MkdirAll(instancesDir, 0o755))
+
+	inst := &Instance{
+		ID:        "1",
+		Project:   "my-app",
+		Branch:    "feat/x",
+		CreatedAt: time.Now(),
+		state:     proto.StateExited,
+		notes: []proto.Note{
+			{Time: 100, Text: "first"},
+			{Time: 200, Text: "second"},
+		},
+	}
+	inst.persistMeta(instancesDir)
+
+	d := &Daemon{rootDir: root, instances: make(map[string]*Instance)}
+	require.NoError(t, d.loadPersistedInstances())
+
+	reloaded := d.instances["1"]
+	require.NotNil(t, reloaded)
+	assert.Equal(t, inst.notes, reloaded.Info().Notes)
+}
+
+func TestPersistedInstanceFromOlderDaemonIsMigrated(t *testing.T) {
+	root := t.TempDir()
+	instancesDir := filepath.Join(root, "instances")
+	require.NoError(t, os.MkdirAll(instancesDir, 0o755))
+
+	// Written by an older daemon: no main_dir or launch config, worktree
+	// named after the ID.
+	oldWorktree := filepath.Join(root, "projects", "my-app", "worktrees", "1")
+	inst := &Instance{
+		ID:          "1",
+		Project:     "my-app",
+		Branch:      "feat/x",
+		WorktreeDir: oldWorktree,
+		CreatedAt:   time.Now(),
+		state:       proto.StateExited,
+		runs: []proto.AgentRun{
+			{Time: 100, Argv: []string{"claude", "--resume"}, Resumed: true},
+			{Time: 200, Argv: []string{"claude", "--verbose"}},
+		},
+	}
+	inst.persistMeta(instancesDir)
+
+	d := &Daemon{rootDir: root, instances: make(map[string]*Instance)}
+	require.NoError(t, d.loadPersistedInstances())
+
+	reloaded := d.instances["1"]
+	require.NotNil(t, reloaded)
+	assert.Equal(t, oldWorktree, reloaded.WorktreeDir)
+	assert.Equal(t, filepath.Join(root, "projects", "my-app", "main"), reloaded.MainDir)
+	assert.Equal(t, &proto.LaunchConfig{AgentCommand: "claude", AgentArgs: []string{"--verbose"}}, reloaded.Launch)
+
+	data, err := os.ReadFile(filepath.Join(instancesDir, "1.json"))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"main_dir"`)
+	assert.Contains(t, string(data), `"launch"`)
+}
+
+func TestPersistedStateSince(t *testing.T) {
+	root := t.TempDir()
+	instancesDir := filepath.Join(root, "instances")
+	require.NoError(t, os.MkdirAll(instancesDir, 0o755))
+
+	changed := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for id, state := range map[string]string{"1": proto.StateExited, "2": proto.StateRunning} {
+		inst := &Instance{ID: id, Project: "my-app", CreatedAt: changed, state: state, stateChangedAt: changed}
+		inst.persistMeta(instancesDir)
+	}
+
+	d := &Daemon{rootDir: root, instances: make(map[string]*Instance)}
+	require.NoError(t, d.loadPersistedInstances())
+
+	assert.Equal(t, changed.Unix(), d.instances["1"].Info().StateSince, "kept across a reload")
+	crashed := d.instances["2"].Info()
+	assert.Equal(t, proto.StateCrashed, crashed.State)
+	assert.Equal(t, crashed.EndedAt, crashed.StateSince, "crashed when the daemon found it gone")
+}
+
+func TestPersistedSeq(t *testing.T) {
+	root := t.TempDir()
+	instancesDir := filepath.Join(root, "instances")
+	require.NoError(t, os.MkdirAll(instancesDir, 0o755))
+
+	created := time.Now().Truncate(time.Second)
+	for id, seq := range map[string]int64{"1": 0, "2": 7, "3": 4} {
+		inst := &Instance{ID: id, Project: "my-app", CreatedAt: created, Seq: seq, state: proto.StateExited}
+		inst.persistMeta(instancesDir)
+	}
+
+	d := &Daemon{rootDir: root, instances: make(map[string]*Instance)}
+	require.NoError(t, d.loadPersistedInstances())
+	assert.Equal(t, int64(7), d.instances["2"].Info().Seq)
+	assert.Equal(t, int64(7), d.lastSeq, "new instances are numbered after every recorded one")
+
+	server, client := net.Pipe()
+	go func() {
+		d.handleList(server, proto.Request{Type: proto.ReqList})
+		server.Close()
+	}()
+	var resp proto.Response
+	require.NoError(t, json.NewDecoder(client).Decode(&resp))
+	var ids []string
+	for _, inst := range resp.Instances {
+		ids = append(ids, inst.ID)
+	}
+	assert.Equal(t, []string{"1", "3", "2"}, ids, "created in the same second: by Seq")
+}
+
+func TestPersistedCheckingIsCleared(t *testing.T) {
+	root := t.TempDir()
+	instancesDir := filepath.Join(root, "instances")
+	require.NoError(t, os.MkdirAll(instancesDir, 0o755))
+
+	inst := &Instance{ID: "1", Project: "my-app", CreatedAt: time.Now(), state: proto.StateReady, InstancesDir: instancesDir}
+	_, err := inst.beginCheck(time.Hour, false, false)
+	require.NoError(t, err)
+
+	d := &Daemon{rootDir: root, instances: make(map[string]*Instance)}
+	require.NoError(t, d.loadPersistedInstances())
+	info := d.instances["1"].Info()
+	// Out of CHECKING, and like any instance whose agent was live when the
+	// daemon went away, CRASHED.
+	assert.Equal(t, proto.StateCrashed, info.State)
+	assert.Nil(t, info.Checking)
+	require.Len(t, info.Notes, 1)
+	assert.Equal(t, "check interrupted by a daemon restart", info.Notes[0].Text)
+}
@@ -1,13 +1,23 @@
 package daemon
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"os"
-	"os/exec"
+	"path"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/gandalfthegui/grove/internal/linediff"
+	"github.com/gandalfthegui/grove/internal/registry"
+	"github.com/gandalfthegui/grove/pkg/proto"
 	"gopkg.in/yaml.v3"
 )
 
@@ -18,6 +28,225 @@ type ContainerConfig struct {
 	Service string   `yaml:"service"` // compose service to exec into; default "app"
 	Workdir string   `yaml:"workdir"` // working directory inside container; default "/app"
 	Mounts  []string `yaml:"mounts"`  // extra host paths to bind-mount; ~/foo maps to /root/foo
+	// Tmpfs lists in-memory mounts as docker's --tmpfs takes them,
+	// <path>[:<options>], e.g. /tmp:size=1g.  Unset means defaultTmpfs;
+	// an empty list means none.
+	Tmpfs optionalArgs `yaml:"tmpfs,omitempty"`
+	// Scratch lists container paths that get an anonymous volume each:
+	// disk-backed space that is not part of the worktree and goes away
+	// with the container.
+	Scratch []string `yaml:"scratch"`
+	// Platform is passed to docker run and pull as --platform (and set on
+	// the compose service), e.g. linux/amd64 for an image without an arm64
+	// variant.  Empty means docker's choice: the host's.
+	Platform string `yaml:"platform"`
+}
+
+// defaultTmpfs is mounted when container.tmpfs is not set.
+const defaultTmpfs = "/tmp:size=1g"
+
+// platformPattern matches a docker platform: os/arch, optionally /variant.
+var platformPattern = regexp.MustCompile(`^[a-z0-9]+/[a-z0-9_]+(/[a-z0-9]+)?$`)
+
+// tmpfsSizePattern matches a tmpfs size= option: bytes, with an optional
+// k, m or g suffix, or a percentage of memory.
+var tmpfsSizePattern = regexp.MustCompile(`^[0-9]+([kKmMgG]|%)?$`)
+
+// UnmarshalYAML implements yaml.Unmarshaler to check the platform and the
+// tmpfs and scratch mounts, so that a bad size fails at config load rather
+// than at docker run.
+func (c *ContainerConfig) UnmarshalYAML(node *yaml.Node) error {
+	type plain ContainerConfig
+	if err := node.Decode((*plain)(c)); err != nil {
+		return err
+	}
+	for _, t := range c.Tmpfs {
+		if err := checkTmpfs(t); err != nil {
+			return fmt.Errorf("line %d: container.tmpfs %q: %w", node.Line, t, err)
+		}
+	}
+	if c.Platform != "" && !platformPattern.MatchString(c.Platform) {
+		return fmt.Errorf("line %d: container.platform %q must be os/arch, e.g. linux/amd64", node.Line, c.Platform)
+	}
+	for _, s := range c.Scratch {
+		if err := checkMountPath(s); err != nil {
+			return fmt.Errorf("line %d: container.scratch %q: %w", node.Line, s, err)
+		}
+	}
+	return nil
+}
+
+// checkTmpfs checks a container.tmpfs entry: an absolute path, then
+// comma-separated mount options of which size= must be a valid size.
+func checkTmpfs(t string) error {
+	dir, opts, _ := strings.Cut(t, ":")
+	if err := checkMountPath(dir); err != nil {
+		return err
+	}
+	if opts == "" {
+		return nil
+	}
+	for _, o := range strings.Split(opts, ",") {
+		k, v, _ := strings.Cut(o, "=")
+		if k == "size" && !tmpfsSizePattern.MatchString(v) {
+			return fmt.Errorf("size %q must be a number of bytes with an optional k, m or g suffix, or a percentage", v)
+		}
+	}
+	return nil
+}
+
+// checkMountPath checks a container path for a tmpfs or scratch mount.
+func checkMountPath(dir string) error {
+	if !path.IsAbs(dir) {
+		return errors.New("the path must be absolute")
+	}
+	if strings.ContainsAny(dir, ",:") {
+		return errors.New("the path must not contain ',' or ':'")
+	}
+	return nil
+}
+
+// StopConfig holds the stop section of grove.yaml.
+type StopConfig struct {
+	// Stack makes grove stop also stop a compose instance's services, as
+	// grove stop --stack does.
+	Stack bool `yaml:"stack,omitempty"`
+}
+
+// GitConfig holds the git section of grove.yaml.
+type GitConfig struct {
+	// Exclude lists .gitignore-style patterns that every worktree's git
+	// should ignore; see exclude.go.
+	Exclude []string `yaml:"exclude"`
+}
+
+// CommandSpec is one start, check or finish command.  In grove.yaml it is
+// either a plain string, run with sh -c in the container's workdir, or a
+// mapping:
+//
+//	check:
+//	  - run: go test ./...
+//	    dir: services/api   # relative to the container workdir
+//	    timeout: 5m
+//	    name: api-tests     # labels its output and its result
+//	    tty: true           # needs a terminal, e.g. to prompt
+//	  - run: open http://localhost:3000
+//	    host: true          # runs on the host, in the worktree; see hostcmd.go
+type CommandSpec struct {
+	Run     string        `yaml:"run" json:"run"`
+	Dir     string        `yaml:"dir,omitempty" json:"dir,omitempty"`
+	Timeout time.Duration `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+	Name    string        `yaml:"name,omitempty" json:"name,omitempty"`
+	TTY     bool          `yaml:"tty,omitempty" json:"tty,omitempty"`   // run with a pseudo-TTY; see clientStdin
+	Host    bool          `yaml:"host,omitempty" json:"host,omitempty"` // check and finish only
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler to accept the string shorthand.
+func (c *CommandSpec) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*c = CommandSpec{}
+		return node.Decode(&c.Run)
+	}
+	type plain CommandSpec
+	if err := node.Decode((*plain)(c)); err != nil {
+		return err
+	}
+	if strings.TrimSpace(c.Run) == "" {
+		return fmt.Errorf("line %d: command needs run:", node.Line)
+	}
+	if c.Timeout < 0 {
+		return fmt.Errorf("line %d: command timeout must not be negative", node.Line)
+	}
+	return nil
+}
+
+// MarshalYAML implements yaml.Marshaler, writing the string shorthand when
+// nothing but run is set.
+func (c CommandSpec) MarshalYAML() (interface{}, error) {
+	if c.Dir == "" && c.Timeout == 0 && c.Name == "" && !c.TTY && !c.Host {
+		return c.Run, nil
+	}
+	type plain CommandSpec
+	return plain(c), nil
+}
+
+// needsTerminal reports whether c runs under a pseudo-TTY: tty: is ignored
+// for host commands.
+func (c CommandSpec) needsTerminal() bool {
+	return c.TTY && !c.Host
+}
+
+// label names c in output and summaries: its name, or else its command.
+func (c CommandSpec) label() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return c.Run
+}
+
+// check.auto values.
+const (
+	checkAutoNever     = "never"
+	checkAutoOnWaiting = "on-waiting" // when the agent goes idle (WAITING) or READY
+	checkAutoOnReady   = "on-ready"   // only when the agent reports its task done
+)
+
+// CheckConfig holds the check section of grove.yaml.  It accepts either a
+// plain list of commands or a mapping:
+//
+//	check:
+//	  auto: on-ready
+//	  report_to_agent: true
+//	  max_duration: 2h
+//	  commands:
+//	    - go test ./...
+type CheckConfig struct {
+	Commands      []CommandSpec `yaml:"commands"`
+	Auto          string        `yaml:"auto"`            // checkAuto*; empty means never
+	ReportToAgent bool          `yaml:"report_to_agent"` // type a failure summary into the agent PTY
+	// MaxDuration is how long a check may keep the instance CHECKING
+	// before the daemon gives up on it; zero means defaultCheckMaxDuration.
+	MaxDuration time.Duration `yaml:"max_duration,omitempty"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler to accept the list shorthand.
+func (c *CheckConfig) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.SequenceNode {
+		return node.Decode(&c.Commands)
+	}
+	type plain CheckConfig
+	if err := node.Decode((*plain)(c)); err != nil {
+		return err
+	}
+	if c.MaxDuration < 0 {
+		return fmt.Errorf("line %d: check.max_duration must not be negative", node.Line)
+	}
+	switch c.Auto {
+	case "", checkAutoNever, checkAutoOnWaiting, checkAutoOnReady:
+		return nil
+	}
+	return fmt.Errorf("check.auto: unknown value %q (want %s, %s, or %s)",
+		c.Auto, checkAutoOnWaiting, checkAutoOnReady, checkAutoNever)
+}
+
+// maxDuration is check.max_duration, or its default.
+func (c CheckConfig) maxDuration() time.Duration {
+	if c.MaxDuration > 0 {
+		return c.MaxDuration
+	}
+	return defaultCheckMaxDuration
+}
+
+// runsOn reports whether an automatic check should run for trigger, which is
+// the state the instance just entered (WAITING or READY).
+func (c CheckConfig) runsOn(trigger string) bool {
+	switch c.Auto {
+	case checkAutoOnWaiting:
+		return trigger == proto.StateWaiting || trigger == proto.StateReady
+	case checkAutoOnReady:
+		return trigger == proto.StateReady
+	}
+	return false
 }
 
 // Project holds the parsed contents of a project.yaml file.
@@ -27,19 +256,353 @@ type Project struct {
 
 	Container ContainerConfig `yaml:"container"`
 
-	Start  []string `yaml:"start"`
-	Finish []string `yaml:"finish"`
-	Check  []string `yaml:"check"`
+	Start  []CommandSpec `yaml:"start"`
+	Finish []CommandSpec `yaml:"finish"`
+	Check  CheckConfig   `yaml:"check"`
+
+	Stop StopConfig `yaml:"stop,omitempty"`
+
+	Git GitConfig `yaml:"git"`
 
 	Agent struct {
 		Command string   `yaml:"command"`
 		Args    []string `yaml:"args"`
+		// ResumeArgs are appended to Args on restart so the agent continues
+		// its previous session.  Nil means the per-agent default (see
+		// defaultResumeArgs); an explicit empty list disables resuming.
+		ResumeArgs optionalArgs `yaml:"resume_args,omitempty"`
+		// ContextFiles are concatenated into contextFile in the worktree
+		// before the agent starts; see context.go.
+		ContextFiles []string `yaml:"context_files,omitempty"`
+		// Env is set in the agent's environment; {{context_file}} in a
+		// value becomes the context file's path in the container.
+		Env map[string]string `yaml:"env,omitempty"`
+		// UsageCommand prints what the agent has cost, in US dollars; see
+		// usage.go.  Empty means the per-agent default, if any.
+		UsageCommand string `yaml:"usage_command,omitempty"`
+		// AllowAuto false forbids grove start --mode auto; nil allows it.
+		AllowAuto *bool `yaml:"allow_auto,omitempty"`
 	} `yaml:"agent"`
 
+	// Agents, if set, lists the agents to run in each instance: the first
+	// is the primary agent and takes the place of agent.command and
+	// agent.args; the others are helpers started beside it (see helpers.go).
+	Agents agentList `yaml:"agents,omitempty"`
+
+	// Overrides are per-branch changes layered over the rest of grove.yaml;
+	// see loadInRepoConfig.
+	Overrides branchOverrides `yaml:"overrides,omitempty"`
+
+	// Defaults are grove start options the CLI applies unless overridden
+	// by its flags.
+	Defaults StartDefaults `yaml:"defaults,omitempty"`
+
+	// Terminal is the PTY size used when no client terminal size is known,
+	// e.g. for instances started from scripts without a TTY.
+	Terminal struct {
+		Cols uint16 `yaml:"cols"`
+		Rows uint16 `yaml:"rows"`
+	} `yaml:"terminal"`
+
 	// DataDir is where all project data lives: registration (project.yaml),
 	// canonical clone (main/), and worktrees (worktrees/).
-	// Always set to <daemonRoot>/projects/<name>.
+	// Set to <daemonRoot>/projects/<name>, the registration's data_dir
+	// (which holds no registration), or <daemonRoot>/scratch/<id> for a
+	// scratch instance's project (see scratch.go).
 	DataDir string `yaml:"-"`
+
+	// dataDirSet is set when DataDir is the registration's data_dir,
+	// which grove does not create; see datadir.go.
+	dataDirSet bool
+
+	// mainDir, if set, is the main checkout instead of DataDir/main: the
+	// directory an in-place scratch instance works in.
+	mainDir string
+}
+
+// StartDefaults is grove.yaml's defaults section: options for grove start
+// that the CLI fetches with the project (see proto.StartDefaults) and
+// applies before its own flags.
+//
+//	defaults:
+//	  detach: true
+//	  base: develop
+//	  labels: {team: core}
+//	  agent: aider --model sonnet
+//	  task_template: "Implement {{branch}}"
+//	  ttl: 2h
+//	  ttl_action: drop
+type StartDefaults struct {
+	Detach       bool              `yaml:"detach,omitempty"`
+	Base         string            `yaml:"base,omitempty"`
+	Labels       map[string]string `yaml:"labels,omitempty"`
+	Agent        string            `yaml:"agent,omitempty"`
+	TaskTemplate string            `yaml:"task_template,omitempty"`
+	// TTL is how long an instance may live before the daemon does
+	// TTLAction with it (proto.TTLFinish or proto.TTLDrop; empty means
+	// finish); see ttl.go.
+	TTL       time.Duration `yaml:"ttl,omitempty"`
+	TTLAction string        `yaml:"ttl_action,omitempty"`
+	// Mode is the agent's mode, as grove start --mode; see agentModeArgs.
+	Mode string `yaml:"mode,omitempty"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler to check ttl, ttl_action and
+// mode.
+func (d *StartDefaults) UnmarshalYAML(node *yaml.Node) error {
+	type plain StartDefaults
+	if err := node.Decode((*plain)(d)); err != nil {
+		return err
+	}
+	if d.TTL < 0 {
+		return fmt.Errorf("line %d: defaults.ttl must not be negative", node.Line)
+	}
+	if err := checkTTLAction(d.TTLAction); err != nil {
+		return fmt.Errorf("line %d: defaults.%w", node.Line, err)
+	}
+	if err := checkMode(d.Mode); err != nil {
+		return fmt.Errorf("line %d: defaults.%w", node.Line, err)
+	}
+	return nil
+}
+
+// info returns d for the client, or nil if it sets nothing.
+func (d StartDefaults) info() *proto.StartDefaults {
+	if !d.Detach && d.Base == "" && len(d.Labels) == 0 && d.Agent == "" && d.TaskTemplate == "" && d.TTL == 0 && d.TTLAction == "" && d.Mode == "" {
+		return nil
+	}
+	return &proto.StartDefaults{
+		Detach:       d.Detach,
+		Base:         d.Base,
+		Labels:       d.Labels,
+		Agent:        d.Agent,
+		TaskTemplate: d.TaskTemplate,
+		TTL:          int64(d.TTL / time.Second),
+		TTLAction:    d.TTLAction,
+		Mode:         d.Mode,
+	}
+}
+
+// overrideAgent replaces the agent command and arguments with those of
+// cmdline, an agent command line from grove start --agent, split on spaces.
+// Helper agents are left alone.
+func (p *Project) overrideAgent(cmdline string) {
+	fields := strings.Fields(cmdline)
+	if len(fields) == 0 {
+		return
+	}
+	p.Agent.Command, p.Agent.Args = fields[0], fields[1:]
+	if len(p.Agents) > 0 {
+		// p may share the list with a cached config; change a copy.
+		p.Agents = append(agentList(nil), p.Agents...)
+		p.Agents[0].Command, p.Agents[0].Args = p.Agent.Command, p.Agent.Args
+	}
+}
+
+// AgentSpec is one entry of grove.yaml's agents list:
+//
+//	agents:
+//	  - name: coder          # optional for the first, primary agent
+//	    command: claude
+//	  - name: reviewer
+//	    command: claude
+//	    args: [--permission-mode, plan]
+//	    env: {GROVE_ROLE: reviewer}
+type AgentSpec struct {
+	Name    string            `yaml:"name,omitempty"`
+	Command string            `yaml:"command"`
+	Args    []string          `yaml:"args,omitempty"`
+	Env     map[string]string `yaml:"env,omitempty"` // on top of agent.env
+}
+
+// agentNamePattern is what an agent name may look like; it becomes part of
+// the agent's log file name.
+var agentNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+// agentList is grove.yaml's agents list.
+type agentList []AgentSpec
+
+// UnmarshalYAML implements yaml.Unmarshaler, checking that every agent has
+// a command and that helpers have distinct names.
+func (l *agentList) UnmarshalYAML(node *yaml.Node) error {
+	var specs []AgentSpec
+	if err := node.Decode(&specs); err != nil {
+		return err
+	}
+	seen := make(map[string]bool)
+	for i, a := range specs {
+		switch {
+		case strings.TrimSpace(a.Command) == "":
+			return fmt.Errorf("agents[%d]: command is required", i)
+		case a.Name == "" && i > 0:
+			return fmt.Errorf("agents[%d]: every agent after the first needs a name", i)
+		case a.Name != "" && !agentNamePattern.MatchString(a.Name):
+			return fmt.Errorf("agents[%d]: name %q may only contain letters, digits, '-' and '_'", i, a.Name)
+		case a.Name != "" && seen[a.Name]:
+			return fmt.Errorf("agents[%d]: name %q is used twice", i, a.Name)
+		}
+		seen[a.Name] = true
+	}
+	*l = specs
+	return nil
+}
+
+// primaryAgentName returns the name of the primary agent, or "" if the
+// config has no agents list or does not name it.
+func (p *Project) primaryAgentName() string {
+	if len(p.Agents) == 0 {
+		return ""
+	}
+	return p.Agents[0].Name
+}
+
+// helperAgents returns the agents started beside the primary one.
+func (p *Project) helperAgents() []AgentSpec {
+	if len(p.Agents) < 2 {
+		return nil
+	}
+	return p.Agents[1:]
+}
+
+// optionalArgs is a list, of arguments or mounts, for which nil (unset) and
+// empty (set to nothing) mean different things.  It survives a YAML round trip: nil is
+// left out, an empty list is written as [].
+type optionalArgs []string
+
+// IsZero implements yaml.IsZeroer for omitempty.
+func (a optionalArgs) IsZero() bool { return a == nil }
+
+// branchOverride is one entry of grove.yaml's overrides section: config
+// applied to instances whose branch matches Pattern (a path.Match glob such
+// as "release/*").
+type branchOverride struct {
+	Pattern string
+	Config  Project
+}
+
+// branchOverrides keeps the overrides section in file order, so that when
+// several patterns match a branch the later ones win predictably.
+type branchOverrides []branchOverride
+
+// UnmarshalYAML implements yaml.Unmarshaler for the pattern → config mapping.
+func (o *branchOverrides) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("overrides: want a mapping of branch pattern to config")
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		pattern := node.Content[i].Value
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("overrides: bad branch pattern %q: %w", pattern, err)
+		}
+		var cfg Project
+		if err := node.Content[i+1].Decode(&cfg); err != nil {
+			return fmt.Errorf("overrides %q: %w", pattern, err)
+		}
+		*o = append(*o, branchOverride{Pattern: pattern, Config: cfg})
+	}
+	return nil
+}
+
+// defaultResumeArgs are the resume args for agents known to keep session
+// state somewhere that survives a restart (e.g. the mounted ~/.claude).
+var defaultResumeArgs = map[string][]string{
+	"claude": {"--continue"},
+	"aider":  {"--restore-chat-history"},
+}
+
+// agentModeArgs are the arguments that put known agents in each mode but
+// normal, which is how they run without any.  Agents not listed have no
+// modes.
+var agentModeArgs = map[string]map[string][]string{
+	"claude": {
+		proto.ModePlan: {"--permission-mode", "plan"},
+		proto.ModeAuto: {"--dangerously-skip-permissions"},
+	},
+	"aider": {
+		proto.ModePlan: {"--chat-mode", "ask"},
+		proto.ModeAuto: {"--yes-always"},
+	},
+}
+
+// checkMode checks an agent mode.
+func checkMode(mode string) error {
+	switch mode {
+	case "", proto.ModePlan, proto.ModeNormal, proto.ModeAuto:
+		return nil
+	}
+	return fmt.Errorf("mode: unknown value %q (want %s, %s or %s)", mode, proto.ModePlan, proto.ModeNormal, proto.ModeAuto)
+}
+
+// modeArgs returns the arguments that run agentCmd in mode, and whether
+// agentCmd has modes at all.
+func modeArgs(agentCmd, mode string) ([]string, bool) {
+	modes, ok := agentModeArgs[agentCmd]
+	return modes[mode], ok
+}
+
+// allowsAuto reports whether p lets agents run in auto mode.
+func (p *Project) allowsAuto() bool {
+	return p.Agent.AllowAuto == nil || *p.Agent.AllowAuto
+}
+
+// errAutoForbidden refuses auto mode in project, whose grove.yaml sets
+// agent.allow_auto: false.
+func errAutoForbidden(project string) error {
+	return fmt.Errorf("project %s does not allow agents in auto mode (agent.allow_auto: false in grove.yaml)", project)
+}
+
+// resumeArgs returns the arguments that make agentCmd continue its previous
+// session: agent.resume_args if set, otherwise the per-agent default.
+func (p *Project) resumeArgs(agentCmd string) []string {
+	if p.Agent.ResumeArgs != nil {
+		return p.Agent.ResumeArgs
+	}
+	return defaultResumeArgs[agentCmd]
+}
+
+// launchConfig records the agent and container settings an instance is
+// being started with.
+func (p *Project) launchConfig() *proto.LaunchConfig {
+	return &proto.LaunchConfig{
+		AgentName:    p.primaryAgentName(),
+		AgentCommand: p.Agent.Command,
+		AgentArgs:    p.Agent.Args,
+		Image:        p.Container.Image,
+		Compose:      p.Container.Compose,
+		Service:      p.Container.Service,
+		Workdir:      p.Container.Workdir,
+		Platform:     p.Container.Platform,
+		Tmpfs:        p.containerTmpfs(),
+		Scratch:      p.Container.Scratch,
+	}
+}
+
+// applyLaunch lays the settings an instance was started with over p.  The
+// container settings are left alone if l has none, as for records migrated
+// from older daemons, which only know the agent command.
+func (p *Project) applyLaunch(l *proto.LaunchConfig) {
+	if l == nil {
+		return
+	}
+	p.Agent.Command = l.AgentCommand
+	p.Agent.Args = l.AgentArgs
+	if l.Image != "" || l.Compose != "" {
+		p.Container.Image = l.Image
+		p.Container.Compose = l.Compose
+		p.Container.Service = l.Service
+		p.Container.Workdir = l.Workdir
+		p.Container.Platform = l.Platform
+		p.Container.Tmpfs = optionalArgs(l.Tmpfs)
+		p.Container.Scratch = l.Scratch
+	}
+}
+
+// containerTmpfs returns the tmpfs mounts to give the container.
+func (p *Project) containerTmpfs() []string {
+	if p.Container.Tmpfs == nil {
+		return []string{defaultTmpfs}
+	}
+	return []string(p.Container.Tmpfs)
 }
 
 // containerWorkdir returns the working directory to use inside the container.
@@ -50,6 +613,18 @@ func (p *Project) containerWorkdir() string {
 	return "/app"
 }
 
+// commandDir returns the directory to run c in inside the container: its
+// dir resolved against the container workdir, or "" for the default.
+func (p *Project) commandDir(c CommandSpec) string {
+	if c.Dir == "" {
+		return ""
+	}
+	if path.IsAbs(c.Dir) {
+		return c.Dir
+	}
+	return path.Join(p.containerWorkdir(), c.Dir)
+}
+
 // containerService returns the compose service name to exec into.
 func (p *Project) containerService() string {
 	if p.Container.Service != "" {
@@ -60,6 +635,9 @@ func (p *Project) containerService() string {
 
 // MainDir returns the path of the canonical checkout for this project.
 func (p *Project) MainDir() string {
+	if p.mainDir != "" {
+		return p.mainDir
+	}
 	return filepath.Join(p.DataDir, "main")
 }
 
@@ -68,48 +646,82 @@ func (p *Project) WorktreesDir() string {
 	return filepath.Join(p.DataDir, "worktrees")
 }
 
-// WorktreeDir returns the path for a specific instance's worktree.
-func (p *Project) WorktreeDir(instanceID string) string {
-	return filepath.Join(p.WorktreesDir(), instanceID)
+// maxBranchSlug caps the branch part of a worktree directory name so deeply
+// nested or very long branch names still give a usable path.
+const maxBranchSlug = 40
+
+// WorktreeDir returns the path for an instance's worktree:
+// worktrees/<branch-slug>-<instanceID>.  The instance ID keeps the name
+// unique among live instances; the slug makes it recognisable.  The path is
+// stored on the instance when it is created and never re-derived.
+func (p *Project) WorktreeDir(instanceID, branch string) string {
+	name := instanceID
+	if slug := branchSlug(branch); slug != "" {
+		name = slug + "-" + instanceID
+	}
+	return filepath.Join(p.WorktreesDir(), name)
 }
 
-// loadProject reads the project registration from <dataRoot>/projects/<name>/project.yaml.
-// The registration only carries name and repo — all other config (container, agent,
-// start, finish, check) comes exclusively from grove.yaml in the project repo.
-func loadProject(dataRoot, name string) (*Project, error) {
-	projectDir := filepath.Join(dataRoot, "projects", name)
-	yamlPath := filepath.Join(projectDir, "project.yaml")
-	data, err := os.ReadFile(yamlPath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, fmt.Errorf("project %q not found (expected %s)", name, yamlPath)
+// branchSlug turns a branch name into a single path component: runs of
+// characters other than letters, digits, '.', '_' and '-' become one '-',
+// and the result is trimmed and capped at maxBranchSlug bytes.
+func branchSlug(branch string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range branch {
+		switch {
+		case r < 0x80 && (r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '_'):
+			b.WriteRune(r)
+			dash = false
+		default:
+			if !dash {
+				b.WriteByte('-')
+				dash = true
+			}
 		}
-		return nil, fmt.Errorf("read project.yaml: %w", err)
 	}
+	slug := b.String()
+	if len(slug) > maxBranchSlug {
+		slug = slug[:maxBranchSlug]
+	}
+	return strings.Trim(slug, "-.")
+}
 
-	var reg struct {
-		Name string `yaml:"name"`
-		Repo string `yaml:"repo"`
+// loadProject reads the registration for name from the first directory in
+// dirs that has one (see package registry); nil dirs means just
+// <dataRoot>/projects.  The registration only carries name, repo and
+// data_dir — all other config (container, agent, start, finish, check)
+// comes exclusively from grove.yaml in the project repo.  Wherever the
+// registration lives, the project's data is kept under
+// <dataRoot>/projects/<name>, or in its data_dir.
+func loadProject(dataRoot string, dirs []string, name string) (*Project, error) {
+	if dirs == nil {
+		dirs = registry.SearchDirs(dataRoot, nil)
 	}
-	if err := yaml.Unmarshal(data, &reg); err != nil {
-		return nil, fmt.Errorf("parse project.yaml: %w", err)
+	reg, err := registry.Find(dirs, name)
+	if err != nil {
+		return nil, err
 	}
+	return entryProject(dataRoot, reg), nil
+}
 
-	p := &Project{
-		Name:    reg.Name,
-		Repo:    reg.Repo,
-		DataDir: projectDir,
-	}
-	if p.Name == "" {
-		p.Name = name
+// entryProject returns the project of registration e, before its
+// grove.yaml is read.
+func entryProject(dataRoot string, e registry.Entry) *Project {
+	return &Project{
+		Name:       e.Name,
+		Repo:       e.Repo,
+		DataDir:    registry.DataDir(dataRoot, e),
+		dataDirSet: e.DataDir != "",
 	}
-	return p, nil
 }
 
 // ensureMainCheckout clones the project repo into the main directory if it
 // does not already exist.  It is a no-op if the directory already has a git repo.
-// All output (git clone progress, etc.) is written to w.
-func ensureMainCheckout(p *Project, w io.Writer) error {
+// All output (git clone progress, etc.) is written to w.  If ctx is
+// cancelled git is killed and what it had cloned is removed, so that the
+// next start does not take a partial clone for a checkout.
+func ensureMainCheckout(ctx context.Context, p *Project, w io.Writer) error {
 	mainDir := p.MainDir()
 	gitDir := filepath.Join(mainDir, ".git")
 
@@ -126,54 +738,101 @@ func ensureMainCheckout(p *Project, w io.Writer) error {
 		return err
 	}
 
+	_, statErr := os.Lstat(mainDir)
 	fmt.Fprintf(w, "Cloning %s into %s …\n", p.Repo, mainDir)
-	cmd := exec.Command("git", "clone", p.Repo, mainDir)
+	cmd := gitCommandContext(ctx, "clone", p.Repo, mainDir)
 	out, err := cmd.CombinedOutput()
 	if len(out) > 0 {
 		_, _ = w.Write(out)
 	}
-	if err != nil {
-		detail := strings.TrimSpace(string(out))
-		if detail != "" {
-			return fmt.Errorf("git clone %q failed: %s", p.Repo, detail)
+	if ctx.Err() != nil {
+		if os.IsNotExist(statErr) {
+			os.RemoveAll(mainDir)
 		}
-		return fmt.Errorf("git clone %q failed: %w", p.Repo, err)
+		return ctx.Err()
+	}
+	if err != nil {
+		return newGitError("clone", p.Name, p.Repo, string(out), err)
 	}
 	return nil
 }
 
 // pullMain runs "git pull" in the main checkout to bring it up-to-date with
 // the remote before branching.  Errors are non-fatal — the caller logs and
-// continues so that offline use still works.  Output is written to w.
-func pullMain(p *Project, w io.Writer) error {
-	cmd := exec.Command("git", "-C", p.MainDir(), "pull")
-	cmd.Stdout = w
-	cmd.Stderr = w
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("git pull: %w", err)
+// continues so that offline use still works.  Output is written to w, and
+// kept to classify a failure (see classifyGitFailure).
+func pullMain(ctx context.Context, p *Project, w io.Writer) error {
+	var out bytes.Buffer
+	cmd := gitCommandContext(ctx, "-C", p.MainDir(), "pull")
+	cmd.Stdout = io.MultiWriter(w, &out)
+	cmd.Stderr = cmd.Stdout
+	err := cmd.Run()
+	// The pull may have rewritten grove.yaml without changing its size or
+	// modification time as the cache sees them.
+	configs.forgetDir(p.MainDir())
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		return newGitError("pull", p.Name, p.Repo, out.String(), err)
 	}
 	return nil
 }
 
-// createWorktree creates a new git worktree at worktreeDir on branch branchName,
-// branching off from the current HEAD of the main checkout.
-func createWorktree(p *Project, instanceID, branchName string, w io.Writer) (string, error) {
+// createWorktree creates a new git worktree on branch branchName, branching
+// off from base, or the current HEAD of the main checkout if base is empty,
+// and returns its path.  An existing branch is checked out as it is.  If
+// the usual path (see WorktreeDir) is occupied — e.g. left behind by an
+// instance whose drop failed — a numeric suffix is added.
+func createWorktree(ctx context.Context, p *Project, instanceID, branchName, base string, w io.Writer) (string, error) {
 	mainDir := p.MainDir()
-	worktreeDir := p.WorktreeDir(instanceID)
+	worktreeDir := p.WorktreeDir(instanceID, branchName)
+	for n := 2; ; n++ {
+		if _, err := os.Lstat(worktreeDir); os.IsNotExist(err) {
+			break
+		}
+		worktreeDir = fmt.Sprintf("%s-%d", p.WorktreeDir(instanceID, branchName), n)
+	}
 
 	if err := os.MkdirAll(p.WorktreesDir(), 0o755); err != nil {
 		return "", err
 	}
 
+	// A start cancelled while git runs leaves a partial worktree, and
+	// perhaps a new branch, to remove.
+	newBranch := gitCommand("-C", mainDir, "rev-parse", "--verify", "--quiet", "refs/heads/"+branchName).Run() != nil
+	abandon := func() (string, error) {
+		os.RemoveAll(worktreeDir)
+		gitCommand("-C", mainDir, "worktree", "prune").Run()
+		if newBranch {
+			deleteBranch(mainDir, branchName)
+		}
+		return "", ctx.Err()
+	}
+
 	// Try creating a new branch; if it already exists, check it out directly.
-	cmd := exec.Command("git", "-C", mainDir, "worktree", "add", "-b", branchName, worktreeDir)
+	args := []string{"-C", mainDir, "worktree", "add", "-b", branchName, worktreeDir}
+	if base != "" {
+		// Not tracking origin's base keeps a later push off that branch.
+		args = []string{"-C", mainDir, "worktree", "add", "--no-track", "-b", branchName, worktreeDir, resolveBase(mainDir, base)}
+	}
+	cmd := gitCommandContext(ctx, args...)
 	cmd.Stdout = w
 	cmd.Stderr = w
 	if err := cmd.Run(); err != nil {
-		cmd = exec.Command("git", "-C", mainDir, "worktree", "add", worktreeDir, branchName)
+		if ctx.Err() != nil {
+			return abandon()
+		}
+		if base != "" && !newBranch {
+			fmt.Fprintf(w, "Branch %s already exists; checking it out instead of branching from %s\n", branchName, base)
+		}
+		cmd = gitCommandContext(ctx, "-C", mainDir, "worktree", "add", worktreeDir, branchName)
 		cmd.Stdout = w
 		cmd.Stderr = w
 		if err := cmd.Run(); err != nil {
+			if ctx.Err() != nil {
+				return abandon()
+			}
 			return "", fmt.Errorf("git worktree add: %w", err)
 		}
 	}
@@ -181,42 +840,239 @@ func createWorktree(p *Project, instanceID, branchName string, w io.Writer) (str
 	return worktreeDir, nil
 }
 
-// removeWorktree removes the git worktree for the given instance and deletes
-// the associated branch.  Errors are best-effort (logged but not fatal).
-func removeWorktree(p *Project, instanceID, branchName string) {
-	mainDir := p.MainDir()
-	worktreeDir := p.WorktreeDir(instanceID)
+// resolveBase returns what to branch from for base: origin's copy of base
+// if there is one, as a clone only has its default branch locally and pulls
+// only that, otherwise base itself (a local branch, tag or commit).
+func resolveBase(mainDir, base string) string {
+	if gitCommand("-C", mainDir, "rev-parse", "--verify", "--quiet", "refs/remotes/origin/"+base).Run() == nil {
+		return "origin/" + base
+	}
+	return base
+}
+
+// removeWorktree removes the git worktree at worktreeDir from the checkout
+// in mainDir and deletes the associated branch.  Both paths are the ones
+// stored on the instance.  Errors are best-effort: they are logged, prefixed
+// with who, but not returned.
+func removeWorktree(mainDir, worktreeDir, branchName, who string) {
+	if err := removeWorktreeDir(mainDir, worktreeDir, who); err != nil {
+		log.Printf("%s: %v", who, err)
+	}
+	if err := deleteBranch(mainDir, branchName); err != nil {
+		log.Printf("%s: %v", who, err)
+	}
+}
+
+// deleteBranch deletes branchName from the checkout in mainDir, if it is
+// there.
+func deleteBranch(mainDir, branchName string) error {
+	if gitCommand("-C", mainDir, "rev-parse", "--verify", "--quiet", "refs/heads/"+branchName).Run() != nil {
+		return nil
+	}
+	if out, err := gitCommand("-C", mainDir, "branch", "-D", branchName).CombinedOutput(); err != nil {
+		return fmt.Errorf("branch %s: git branch -D: %v: %s", branchName, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// In-repo config locations, relative to the repo root, in order of
+// preference.
+const (
+	configPathPreferred = ".grove/grove.yaml"
+	configPathLegacy    = "grove.yaml"
+)
 
-	// git worktree remove --force <path>
-	exec.Command("git", "-C", mainDir, "worktree", "remove", "--force", worktreeDir).Run()
+// bothConfigsWarned holds the repo directories findInRepoConfig has logged
+// having both config files, so that each is logged once.
+var bothConfigsWarned sync.Map
 
-	// git branch -D <branch>
-	exec.Command("git", "-C", mainDir, "branch", "-D", branchName).Run()
+// findInRepoConfig returns the path of the config file in repoDir, or "" if
+// there is none.  .grove/grove.yaml wins over a top-level grove.yaml; having
+// both is logged, the first time, since the top-level one is then silently
+// ignored.
+func findInRepoConfig(repoDir string) string {
+	preferred := filepath.Join(repoDir, configPathPreferred)
+	legacy := filepath.Join(repoDir, configPathLegacy)
+	switch {
+	case fileExists(preferred):
+		if fileExists(legacy) {
+			if _, warned := bothConfigsWarned.LoadOrStore(repoDir, true); !warned {
+				log.Printf("warning: both %s and %s exist in %s; using %s", configPathPreferred, configPathLegacy, repoDir, configPathPreferred)
+			}
+		}
+		return preferred
+	case fileExists(legacy):
+		return legacy
+	}
+	return ""
 }
 
-// loadInRepoConfig reads grove.yaml from the root of the project's main clone
+// loadInRepoConfig reads the in-repo config (.grove/grove.yaml or grove.yaml)
 // and overlays its fields onto p.  In-repo config takes precedence over the
-// registration so teams can commit authoritative settings alongside their code.
+// registration so teams can commit authoritative settings alongside their
+// code.
+//
+// The config is read from worktreeDir if it has one — the branch's own
+// version, so config changes on a branch take effect for its instance — and
+// otherwise from the project's main clone.  Its include files are merged
+// first (see readInRepoConfig).  Then every entry of its overrides section
+// whose pattern matches branch is overlaid in turn, in file order.  Pass an
+// empty branch or worktreeDir to skip either step.
 //
 // Returns (true, nil) if the file was found and applied, (false, nil) if it
-// does not exist, or (false, err) on a parse error.
-func loadInRepoConfig(p *Project) (bool, error) {
-	inRepoPath := filepath.Join(p.MainDir(), "grove.yaml")
-	data, err := os.ReadFile(inRepoPath)
+// does not exist, or (false, err) on a parse or include error.
+func loadInRepoConfig(p *Project, branch, worktreeDir string) (bool, error) {
+	repoDir := p.MainDir()
+	configPath := findInRepoConfig(repoDir)
+	if worktreeDir != "" {
+		if wt := findInRepoConfig(worktreeDir); wt != "" {
+			repoDir, configPath = worktreeDir, wt
+		}
+	}
+	if configPath == "" {
+		return false, nil
+	}
+
+	rel, _ := filepath.Rel(repoDir, configPath)
+	merged, err := configs.load(configPath, func(files map[string]fileStamp) (*Project, error) {
+		var merged Project
+		err := readInRepoConfig(repoDir, rel, &merged, nil, files)
+		return &merged, err
+	})
 	if err != nil {
-		if os.IsNotExist(err) {
-			return false, nil
+		return false, err
+	}
+
+	overlayConfig(p, merged)
+	if branch != "" {
+		for _, o := range merged.Overrides {
+			if matched, _ := path.Match(o.Pattern, branch); matched {
+				overlayConfig(p, &o.Config)
+			}
 		}
-		return false, fmt.Errorf("read grove.yaml: %w", err)
+	}
+	return true, nil
+}
+
+// maxConfigDiffLines bounds the diff branchConfigNotice includes.
+const maxConfigDiffLines = 40
+
+// branchConfigNotice explains, for an instance's setup output, which
+// grove.yaml it runs with when the copy on its branch (in worktreeDir)
+// differs from the main checkout's, with the diff when it is short.
+// branchErr is the error loading the branch's copy, if it did not load and
+// the main checkout's was used instead.  Included files are not compared.
+// It returns "" when the two copies are the same.
+func branchConfigNotice(mainDir, worktreeDir, branch string, branchErr error) string {
+	mainPath, branchPath := findInRepoConfig(mainDir), findInRepoConfig(worktreeDir)
+	mainRel, _ := filepath.Rel(mainDir, mainPath)
+	branchRel, _ := filepath.Rel(worktreeDir, branchPath)
+	var mainData, branchData []byte
+	if mainPath != "" {
+		mainData, _ = os.ReadFile(mainPath)
+	}
+	if branchPath != "" {
+		branchData, _ = os.ReadFile(branchPath)
+	}
+	if mainRel == branchRel && bytes.Equal(mainData, branchData) {
+		return ""
+	}
+
+	var b strings.Builder
+	switch {
+	case branchPath == "":
+		fmt.Fprintf(&b, "notice: branch %s has no grove.yaml; this instance uses the main checkout's %s.\n", branch, mainRel)
+		return b.String()
+	case branchErr != nil:
+		fmt.Fprintf(&b, "warning: %s on branch %s does not load (%v); this instance uses the main checkout's, which differs.\n", branchRel, branch, branchErr)
+		b.WriteString("  Fix the branch's copy and run grove restart --current-config to switch to it.\n")
+	default:
+		fmt.Fprintf(&b, "notice: %s on branch %s differs from the main checkout's; this instance uses the branch's.\n", branchRel, branch)
+	}
+	if mainPath == "" {
+		return b.String()
+	}
+	diff := linediff.Lines(linediff.Split(string(mainData)), linediff.Split(string(branchData)))
+	changed := 0
+	for _, line := range diff {
+		if line[0] != ' ' {
+			changed++
+		}
+	}
+	if changed > maxConfigDiffLines {
+		fmt.Fprintf(&b, "  (%d lines differ; too many to show)\n", changed)
+		return b.String()
+	}
+	// Show the changes with up to two unchanged lines around each, which is
+	// usually enough to see which section a change is in.
+	show := make([]bool, len(diff))
+	for i, line := range diff {
+		if line[0] != ' ' {
+			for j := max(i-2, 0); j <= min(i+2, len(diff)-1); j++ {
+				show[j] = true
+			}
+		}
+	}
+	fmt.Fprintf(&b, "  --- %s (main checkout)\n  +++ %s (branch %s)\n", mainRel, branchRel, branch)
+	for i, line := range diff {
+		switch {
+		case show[i]:
+			fmt.Fprintf(&b, "  %s\n", line)
+		case i > 0 && show[i-1]:
+			b.WriteString("  ...\n")
+		}
+	}
+	return b.String()
+}
+
+// readInRepoConfig parses the config file at rel (relative to repoDir) and
+// overlays it onto into: first each file in its include list, in order and
+// recursively, then the document itself, so the including file wins.
+// Overrides sections are concatenated in the same order.  stack holds the
+// files currently being read, to report include cycles.  Each file read is
+// recorded in files (see configcache.go).
+func readInRepoConfig(repoDir, rel string, into *Project, stack []string, files map[string]fileStamp) error {
+	for _, s := range stack {
+		if s == rel {
+			return fmt.Errorf("include cycle: %s", strings.Join(append(stack, rel), " → "))
+		}
+	}
+	stack = append(stack, rel)
+
+	data, err := readFile(filepath.Join(repoDir, rel), files)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", rel, err)
+	}
+	var doc struct {
+		Project `yaml:",inline"`
+		Include []string `yaml:"include"`
+	}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("parse %s: %w", rel, err)
 	}
 
-	var overlay Project
-	if err := yaml.Unmarshal(data, &overlay); err != nil {
-		return false, fmt.Errorf("parse grove.yaml: %w", err)
+	for _, inc := range doc.Include {
+		clean := filepath.Clean(inc)
+		if filepath.IsAbs(inc) || clean == ".." || strings.HasPrefix(clean, "../") {
+			return fmt.Errorf("%s: include %q must be a path inside the repository", rel, inc)
+		}
+		if !fileExists(filepath.Join(repoDir, clean)) {
+			return fmt.Errorf("%s: included file %q not found (paths are relative to the repository root)", rel, inc)
+		}
+		if err := readInRepoConfig(repoDir, clean, into, stack, files); err != nil {
+			return err
+		}
 	}
 
-	// Overlay container config field by field so a partial in-repo config
-	// (e.g. only mounts:) merges with rather than replaces the registration.
+	overlayConfig(into, &doc.Project)
+	into.Overrides = append(into.Overrides, doc.Overrides...)
+	return nil
+}
+
+// overlayConfig copies the fields set in overlay onto p.  Container and check
+// settings merge field by field so a partial config (e.g. only mounts:)
+// merges with rather than replaces what is already there.
+func overlayConfig(p, overlay *Project) {
 	if overlay.Container.Image != "" {
 		p.Container.Image = overlay.Container.Image
 	}
@@ -232,29 +1088,106 @@ func loadInRepoConfig(p *Project) (bool, error) {
 	if len(overlay.Container.Mounts) > 0 {
 		p.Container.Mounts = overlay.Container.Mounts
 	}
+	if overlay.Container.Platform != "" {
+		p.Container.Platform = overlay.Container.Platform
+	}
+	if overlay.Container.Tmpfs != nil {
+		p.Container.Tmpfs = overlay.Container.Tmpfs
+	}
+	if len(overlay.Container.Scratch) > 0 {
+		p.Container.Scratch = overlay.Container.Scratch
+	}
 	if len(overlay.Start) > 0 {
 		p.Start = overlay.Start
 	}
 	if overlay.Agent.Command != "" {
-		p.Agent = overlay.Agent
+		p.Agent.Command = overlay.Agent.Command
+		p.Agent.Args = overlay.Agent.Args
+		p.Agent.ResumeArgs = overlay.Agent.ResumeArgs
+		if len(p.Agents) > 0 {
+			p.Agents = append(agentList(nil), p.Agents...)
+			p.Agents[0].Command, p.Agents[0].Args = p.Agent.Command, p.Agent.Args
+		}
+	}
+	if len(overlay.Agents) > 0 {
+		p.Agents = overlay.Agents
+		p.Agent.Command = overlay.Agents[0].Command
+		p.Agent.Args = overlay.Agents[0].Args
+	}
+	if len(overlay.Agent.ContextFiles) > 0 {
+		p.Agent.ContextFiles = overlay.Agent.ContextFiles
+	}
+	if len(overlay.Agent.Env) > 0 {
+		p.Agent.Env = overlay.Agent.Env
+	}
+	if overlay.Agent.UsageCommand != "" {
+		p.Agent.UsageCommand = overlay.Agent.UsageCommand
+	}
+	if overlay.Agent.AllowAuto != nil {
+		p.Agent.AllowAuto = overlay.Agent.AllowAuto
 	}
 	if len(overlay.Finish) > 0 {
 		p.Finish = overlay.Finish
 	}
-	if len(overlay.Check) > 0 {
-		p.Check = overlay.Check
+	if len(overlay.Check.Commands) > 0 {
+		p.Check.Commands = overlay.Check.Commands
 	}
+	if overlay.Check.Auto != "" {
+		p.Check.Auto = overlay.Check.Auto
+	}
+	if overlay.Check.ReportToAgent {
+		p.Check.ReportToAgent = true
+	}
+	if overlay.Check.MaxDuration > 0 {
+		p.Check.MaxDuration = overlay.Check.MaxDuration
+	}
+	if overlay.Terminal.Cols > 0 && overlay.Terminal.Rows > 0 {
+		p.Terminal = overlay.Terminal
+	}
+	if overlay.Stop.Stack {
+		p.Stop.Stack = true
+	}
+	if len(overlay.Git.Exclude) > 0 {
+		p.Git.Exclude = overlay.Git.Exclude
+	}
+	if overlay.Defaults.Detach {
+		p.Defaults.Detach = true
+	}
+	if overlay.Defaults.Base != "" {
+		p.Defaults.Base = overlay.Defaults.Base
+	}
+	if len(overlay.Defaults.Labels) > 0 {
+		p.Defaults.Labels = overlay.Defaults.Labels
+	}
+	if overlay.Defaults.Agent != "" {
+		p.Defaults.Agent = overlay.Defaults.Agent
+	}
+	if overlay.Defaults.TaskTemplate != "" {
+		p.Defaults.TaskTemplate = overlay.Defaults.TaskTemplate
+	}
+	if overlay.Defaults.TTL > 0 {
+		p.Defaults.TTL = overlay.Defaults.TTL
+	}
+	if overlay.Defaults.TTLAction != "" {
+		p.Defaults.TTLAction = overlay.Defaults.TTLAction
+	}
+	if overlay.Defaults.Mode != "" {
+		p.Defaults.Mode = overlay.Defaults.Mode
+	}
+}
 
-	return true, nil
+func fileExists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
 }
 
 // runStart executes the project start commands sequentially inside the container.
 // All output is written to w.
-func runStart(p *Project, containerName string, w io.Writer) error {
-	for _, cmdStr := range p.Start {
-		fmt.Fprintf(w, "Start: %s\n", cmdStr)
-		if err := execInContainer(containerName, cmdStr, w); err != nil {
-			return fmt.Errorf("start %q: %w", cmdStr, err)
+func runStart(ctx context.Context, p *Project, containerName string, w io.Writer) error {
+	for _, c := range p.Start {
+		fmt.Fprintf(w, "Start: %s\n", c.Run)
+		if err := execInContainer(ctx, containerName, commandOptions(p, c, nil), c.Run, w); err != nil {
+			return fmt.Errorf("start %q: %w", c.label(), err)
 		}
 	}
 	return nil
@@ -1,12 +1,20 @@
 package daemon
 
 import (
+	"context"
+	"errors"
+	"io"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/gandalfthegui/grove/pkg/proto"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
 )
 
 func TestProjectDirHelpers(t *testing.T) {
@@ -14,7 +22,204 @@ func TestProjectDirHelpers(t *testing.T) {
 
 	assert.Equal(t, "/data/my-app/main", p.MainDir())
 	assert.Equal(t, "/data/my-app/worktrees", p.WorktreesDir())
-	assert.Equal(t, "/data/my-app/worktrees/abc", p.WorktreeDir("abc"))
+	assert.Equal(t, "/data/my-app/worktrees/feat-login-a", p.WorktreeDir("a", "feat/login"))
+	assert.Equal(t, "/data/my-app/worktrees/a", p.WorktreeDir("a", "///"))
+}
+
+func TestBranchSlug(t *testing.T) {
+	cases := map[string]string{
+		"main":                   "main",
+		"feat/login":             "feat-login",
+		"fix//weird  name":       "fix-weird-name",
+		"/leading/and/trailing/": "leading-and-trailing",
+		"release/v1.2":           "release-v1.2",
+		"unicodé":                "unicod",
+		"..":                     "",
+	}
+	for in, want := range cases {
+		assert.Equal(t, want, branchSlug(in), in)
+	}
+	long := branchSlug("feature/" + strings.Repeat("x", 100))
+	assert.Len(t, long, maxBranchSlug)
+}
+
+func TestCreateWorktreeAvoidsOccupiedPath(t *testing.T) {
+	dataDir := t.TempDir()
+	p := &Project{DataDir: dataDir}
+	git(t, "init", "-q", "-b", "main", p.MainDir())
+	git(t, "-C", p.MainDir(), "-c", "user.name=t", "-c", "user.email=t@t", "commit", "-q", "--allow-empty", "-m", "init")
+
+	// A leftover directory from an instance whose drop failed.
+	require.NoError(t, os.MkdirAll(p.WorktreeDir("1", "feat/x"), 0o755))
+
+	dir, err := createWorktree(context.Background(), p, "1", "feat/x", "", io.Discard)
+	require.NoError(t, err)
+	assert.Equal(t, p.WorktreeDir("1", "feat/x")+"-2", dir)
+	assert.FileExists(t, filepath.Join(dir, ".git"))
+
+	removeWorktree(p.MainDir(), dir, "feat/x", "test")
+	assert.NoDirExists(t, dir)
+}
+
+func TestCreateWorktreeFromBase(t *testing.T) {
+	origin := filepath.Join(t.TempDir(), "origin")
+	commit := func(dir, msg string) {
+		git(t, "-C", dir, "-c", "user.name=t", "-c", "user.email=t@t", "commit", "-q", "--allow-empty", "-m", msg)
+	}
+	git(t, "init", "-q", "-b", "main", origin)
+	commit(origin, "init")
+	git(t, "-C", origin, "checkout", "-q", "-b", "develop")
+	commit(origin, "on develop")
+	develop := git(t, "-C", origin, "rev-parse", "HEAD")
+	git(t, "-C", origin, "checkout", "-q", "main")
+
+	p := &Project{DataDir: t.TempDir()}
+	git(t, "clone", "-q", origin, p.MainDir())
+
+	// develop exists only as origin/develop in the clone.
+	dir, err := createWorktree(context.Background(), p, "1", "feat/x", "develop", io.Discard)
+	require.NoError(t, err)
+	assert.Equal(t, develop, git(t, "-C", dir, "rev-parse", "HEAD"))
+	_, err = exec.Command("git", "-C", dir, "config", "branch.feat/x.merge").Output()
+	assert.Error(t, err, "the new branch does not track origin/develop")
+
+	// A branch that exists is checked out as it is.
+	git(t, "-C", p.MainDir(), "branch", "feat/y", "main")
+	var out strings.Builder
+	dir, err = createWorktree(context.Background(), p, "2", "feat/y", "develop", &out)
+	require.NoError(t, err)
+	assert.Equal(t, git(t, "-C", p.MainDir(), "rev-parse", "main"), git(t, "-C", dir, "rev-parse", "HEAD"))
+	assert.Contains(t, out.String(), "Branch feat/y already exists; checking it out instead of branching from develop")
+}
+
+func TestStartDefaults(t *testing.T) {
+	dataDir := t.TempDir()
+	writeRepoFiles(t, filepath.Join(dataDir, "main"), map[string]string{
+		".grove/grove.yaml": "include: [shared.yaml]\ndefaults:\n  base: develop\n  labels: {team: core}\n",
+		"shared.yaml":       "defaults:\n  detach: true\n  base: main\n  task_template: Work on {{branch}}\n  ttl: 2h\n  ttl_action: drop\n  mode: plan\n",
+	})
+	p := &Project{DataDir: dataDir}
+	_, err := loadInRepoConfig(p, "", "")
+	require.NoError(t, err)
+	assert.Equal(t, &proto.StartDefaults{
+		Detach:       true,
+		Base:         "develop",
+		Labels:       map[string]string{"team": "core"},
+		TaskTemplate: "Work on {{branch}}",
+		TTL:          7200,
+		TTLAction:    proto.TTLDrop,
+		Mode:         proto.ModePlan,
+	}, p.Defaults.info())
+
+	assert.Nil(t, StartDefaults{}.info(), "no defaults section")
+
+	var bad Project
+	assert.ErrorContains(t, yaml.Unmarshal([]byte("defaults:\n  ttl_action: stop\n"), &bad), `ttl_action: unknown value "stop"`)
+	assert.ErrorContains(t, yaml.Unmarshal([]byte("defaults:\n  ttl: -1h\n"), &bad), "defaults.ttl must not be negative")
+	assert.ErrorContains(t, yaml.Unmarshal([]byte("defaults:\n  mode: yolo\n"), &Project{}), `defaults.mode: unknown value "yolo"`)
+}
+
+func TestModeArgs(t *testing.T) {
+	args, ok := modeArgs("claude", proto.ModeAuto)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"--dangerously-skip-permissions"}, args)
+	args, ok = modeArgs("aider", proto.ModeNormal)
+	assert.True(t, ok)
+	assert.Empty(t, args)
+	_, ok = modeArgs("sh", proto.ModePlan)
+	assert.False(t, ok, "no modes")
+
+	var p Project
+	require.NoError(t, yaml.Unmarshal([]byte("agent:\n  command: claude\n"), &p))
+	assert.True(t, p.allowsAuto(), "allowed unless forbidden")
+	var forbidden Project
+	require.NoError(t, yaml.Unmarshal([]byte("agent:\n  allow_auto: false\n"), &forbidden))
+	overlayConfig(&p, &forbidden)
+	assert.False(t, p.allowsAuto())
+	overlayConfig(&p, &Project{})
+	assert.False(t, p.allowsAuto(), "an overlay without allow_auto keeps it")
+}
+
+func TestOverrideAgent(t *testing.T) {
+	p := &Project{}
+	p.Agent.Command, p.Agent.Args = "claude", []string{"--verbose"}
+	p.overrideAgent("  ")
+	assert.Equal(t, "claude", p.Agent.Command, "a blank command line changes nothing")
+	p.overrideAgent("aider --model  sonnet")
+	assert.Equal(t, "aider", p.Agent.Command)
+	assert.Equal(t, []string{"--model", "sonnet"}, p.Agent.Args)
+}
+
+func TestOverrideAgentWithAgentsList(t *testing.T) {
+	cached := &Project{Agents: agentList{{Name: "coder", Command: "claude"}, {Name: "reviewer", Command: "claude"}}}
+	p := *cached
+	p.Agent.Command = "claude"
+	p.overrideAgent("aider --yes")
+	assert.Equal(t, AgentSpec{Name: "coder", Command: "aider", Args: []string{"--yes"}}, p.Agents[0])
+	assert.Equal(t, "reviewer", p.helperAgents()[0].Name, "helpers are left alone")
+	assert.Equal(t, "claude", cached.Agents[0].Command, "a shared list is not changed")
+}
+
+func TestLoadInRepoConfigAgents(t *testing.T) {
+	dataDir := t.TempDir()
+	mainDir := filepath.Join(dataDir, "main")
+	writeRepoFiles(t, mainDir, map[string]string{
+		"grove.yaml": `include: [shared.yaml]
+agent:
+  env: {SHARED: "1"}
+agents:
+  - name: coder
+    command: claude
+    args: [--verbose]
+    env: {ROLE: coder}
+  - name: reviewer
+    command: claude
+    args: [--permission-mode, plan]
+    env: {ROLE: reviewer}
+overrides:
+  solo/*:
+    agent:
+      command: aider
+`,
+		"shared.yaml": "agent:\n  command: sh\n",
+	})
+
+	p := &Project{DataDir: dataDir}
+	_, err := loadInRepoConfig(p, "main", "")
+	require.NoError(t, err)
+	assert.Equal(t, "claude", p.Agent.Command, "the first agent is the primary")
+	assert.Equal(t, []string{"--verbose"}, p.Agent.Args)
+	assert.Equal(t, "coder", p.primaryAgentName())
+	assert.Equal(t, map[string]string{"SHARED": "1", "ROLE": "coder"}, p.agentEnv())
+	require.Len(t, p.helperAgents(), 1)
+	reviewer := p.helperAgents()[0]
+	assert.Equal(t, "reviewer", reviewer.Name)
+	assert.Equal(t, map[string]string{"SHARED": "1", "ROLE": "reviewer"}, p.helperEnv(reviewer))
+	assert.Equal(t, "coder", p.launchConfig().AgentName)
+
+	// A later agent.command replaces the primary and keeps the helpers.
+	p = &Project{DataDir: dataDir}
+	_, err = loadInRepoConfig(p, "solo/1", "")
+	require.NoError(t, err)
+	assert.Equal(t, "aider", p.Agent.Command)
+	assert.Equal(t, "aider", p.Agents[0].Command)
+	assert.Len(t, p.helperAgents(), 1)
+}
+
+func TestAgentListErrors(t *testing.T) {
+	tests := []struct{ yaml, want string }{
+		{"agents:\n  - name: coder\n", "agents[0]: command is required"},
+		{"agents:\n  - command: claude\n  - command: aider\n", "agents[1]: every agent after the first needs a name"},
+		{"agents:\n  - command: claude\n  - name: a/b\n    command: aider\n", `agents[1]: name "a/b" may only contain`},
+		{"agents:\n  - name: x\n    command: claude\n  - name: x\n    command: aider\n", `agents[1]: name "x" is used twice`},
+	}
+	for _, tt := range tests {
+		var p Project
+		err := yaml.Unmarshal([]byte(tt.yaml), &p)
+		if assert.Error(t, err, tt.yaml) {
+			assert.Contains(t, err.Error(), tt.want)
+		}
+	}
 }
 
 func TestLoadProject(t *testing.T) {
@@ -26,7 +231,7 @@ func TestLoadProject(t *testing.T) {
 	yaml := "name: my-app\nrepo: git@github.com:org/my-app.git\nagent:\n  command: claude\n  args: []\n"
 	require.NoError(t, os.WriteFile(filepath.Join(projectDir, "project.yaml"), []byte(yaml), 0o644))
 
-	p, err := loadProject(dataRoot, "my-app")
+	p, err := loadProject(dataRoot, nil, "my-app")
 	require.NoError(t, err)
 	assert.Equal(t, "my-app", p.Name)
 	assert.Equal(t, "git@github.com:org/my-app.git", p.Repo)
@@ -42,13 +247,24 @@ func TestLoadProjectFallsBackToDirectoryName(t *testing.T) {
 	// YAML has no name field — should fall back to directory name.
 	require.NoError(t, os.WriteFile(filepath.Join(projectDir, "project.yaml"), []byte("repo: git@github.com:org/repo.git\n"), 0o644))
 
-	p, err := loadProject(dataRoot, "my-app")
+	p, err := loadProject(dataRoot, nil, "my-app")
 	require.NoError(t, err)
 	assert.Equal(t, "my-app", p.Name)
 }
 
+func TestLoadProjectFromSharedDir(t *testing.T) {
+	dataRoot, shared := t.TempDir(), t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(shared, "my-app"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(shared, "my-app", "project.yaml"), []byte("repo: git@team:my-app.git\n"), 0o644))
+
+	p, err := loadProject(dataRoot, []string{filepath.Join(dataRoot, "projects"), shared}, "my-app")
+	require.NoError(t, err)
+	assert.Equal(t, "git@team:my-app.git", p.Repo)
+	assert.Equal(t, filepath.Join(dataRoot, "projects", "my-app"), p.DataDir, "data stays in the personal root")
+}
+
 func TestLoadProjectNotFound(t *testing.T) {
-	_, err := loadProject(t.TempDir(), "nonexistent")
+	_, err := loadProject(t.TempDir(), nil, "nonexistent")
 	assert.Error(t, err)
 }
 
@@ -63,17 +279,17 @@ func TestLoadInRepoConfig(t *testing.T) {
 	p := &Project{DataDir: dataDir}
 	p.Agent.Command = "claude" // original value — should be overridden
 
-	found, err := loadInRepoConfig(p)
+	found, err := loadInRepoConfig(p, "", "")
 	require.NoError(t, err)
 	assert.True(t, found)
 	assert.Equal(t, "aider", p.Agent.Command)
-	assert.Equal(t, []string{"npm install"}, p.Start)
-	assert.Equal(t, []string{"git push"}, p.Finish)
+	assert.Equal(t, commands("npm install"), p.Start)
+	assert.Equal(t, commands("git push"), p.Finish)
 }
 
 func TestLoadInRepoConfigMissing(t *testing.T) {
 	p := &Project{DataDir: t.TempDir()}
-	found, err := loadInRepoConfig(p)
+	found, err := loadInRepoConfig(p, "", "")
 	assert.NoError(t, err)
 	assert.False(t, found)
 }
@@ -91,9 +307,434 @@ func TestLoadInRepoConfigPartialDoesNotWipeOtherFields(t *testing.T) {
 	// in-repo config fills those in.
 	p := &Project{DataDir: dataDir}
 
-	_, err := loadInRepoConfig(p)
+	_, err := loadInRepoConfig(p, "", "")
 	require.NoError(t, err)
-	assert.Equal(t, []string{"make setup"}, p.Start)
+	assert.Equal(t, commands("make setup"), p.Start)
 	assert.Empty(t, p.Agent.Command, "agent should remain empty when absent from in-repo config")
 	assert.Empty(t, p.Finish, "finish should remain empty when absent from in-repo config")
 }
+
+func TestLoadInRepoConfigBranchOverrides(t *testing.T) {
+	dataDir := t.TempDir()
+	mainDir := filepath.Join(dataDir, "main")
+	require.NoError(t, os.MkdirAll(mainDir, 0o755))
+
+	cfg := `container:
+  image: golang:1.23
+  mounts: [~/.ssh]
+check:
+  - go test ./...
+finish:
+  - git push
+overrides:
+  release/*:
+    container:
+      image: golang:1.21
+    check:
+      - go test ./...
+      - make release-check
+  release/legacy-*:
+    container:
+      image: golang:1.19
+`
+	require.NoError(t, os.WriteFile(filepath.Join(mainDir, "grove.yaml"), []byte(cfg), 0o644))
+
+	cases := []struct {
+		branch string
+		image  string
+		check  []CommandSpec
+	}{
+		{"main", "golang:1.23", commands("go test ./...")},
+		{"", "golang:1.23", commands("go test ./...")},
+		{"release/2.0", "golang:1.21", commands("go test ./...", "make release-check")},
+		// Both patterns match; the later one wins for the fields it sets.
+		{"release/legacy-1", "golang:1.19", commands("go test ./...", "make release-check")},
+		// * does not cross a slash.
+		{"release/2.0/hotfix", "golang:1.23", commands("go test ./...")},
+	}
+	for _, tc := range cases {
+		t.Run(tc.branch, func(t *testing.T) {
+			p := &Project{DataDir: dataDir}
+			_, err := loadInRepoConfig(p, tc.branch, "")
+			require.NoError(t, err)
+			assert.Equal(t, tc.image, p.Container.Image)
+			assert.Equal(t, tc.check, p.Check.Commands)
+			// Fields an override does not set are kept from the base config.
+			assert.Equal(t, []string{"~/.ssh"}, p.Container.Mounts)
+			assert.Equal(t, commands("git push"), p.Finish)
+		})
+	}
+}
+
+func TestOverlayConfigAgentContext(t *testing.T) {
+	p := &Project{}
+	p.Agent.Command = "claude"
+	p.Agent.ContextFiles = []string{"docs/agents.md"}
+	p.Agent.Env = map[string]string{"CLAUDE_MD": "{{context_file}}"}
+
+	// An override that changes the agent command keeps the context.
+	var overlay Project
+	overlay.Agent.Command = "aider"
+	overlayConfig(p, &overlay)
+	assert.Equal(t, "aider", p.Agent.Command)
+	assert.Equal(t, []string{"docs/agents.md"}, p.Agent.ContextFiles)
+	assert.Equal(t, "{{context_file}}", p.Agent.Env["CLAUDE_MD"])
+
+	// One that only sets context files keeps the command.
+	overlay = Project{}
+	overlay.Agent.ContextFiles = []string{"docs/release.md"}
+	overlayConfig(p, &overlay)
+	assert.Equal(t, "aider", p.Agent.Command)
+	assert.Equal(t, []string{"docs/release.md"}, p.Agent.ContextFiles)
+}
+
+func TestLoadInRepoConfigBadOverridePattern(t *testing.T) {
+	dataDir := t.TempDir()
+	mainDir := filepath.Join(dataDir, "main")
+	require.NoError(t, os.MkdirAll(mainDir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(mainDir, "grove.yaml"), []byte("overrides:\n  \"release/[\":\n    start: [x]\n"), 0o644))
+
+	_, err := loadInRepoConfig(&Project{DataDir: dataDir}, "main", "")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "bad branch pattern")
+}
+
+func TestLoadInRepoConfigPrefersWorktree(t *testing.T) {
+	dataDir := t.TempDir()
+	mainDir := filepath.Join(dataDir, "main")
+	worktreeDir := filepath.Join(dataDir, "worktrees", "1")
+	require.NoError(t, os.MkdirAll(mainDir, 0o755))
+	require.NoError(t, os.MkdirAll(worktreeDir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(mainDir, "grove.yaml"), []byte("start: [main-setup]\nfinish: [git push]\n"), 0o644))
+
+	// No grove.yaml in the worktree: the main checkout's copy is used.
+	p := &Project{DataDir: dataDir}
+	_, err := loadInRepoConfig(p, "feat/x", worktreeDir)
+	require.NoError(t, err)
+	assert.Equal(t, commands("main-setup"), p.Start)
+
+	// The branch's own copy wins outright; main's is not merged in.
+	require.NoError(t, os.WriteFile(filepath.Join(worktreeDir, "grove.yaml"), []byte("start: [branch-setup]\n"), 0o644))
+	p = &Project{DataDir: dataDir}
+	found, err := loadInRepoConfig(p, "feat/x", worktreeDir)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, commands("branch-setup"), p.Start)
+	assert.Empty(t, p.Finish)
+}
+
+func TestBranchConfigNotice(t *testing.T) {
+	mainDir, worktreeDir := t.TempDir(), t.TempDir()
+	base := "name: app\ncontainer:\n  image: node:22\nstart:\n  - npm ci\nfinish:\n  - git push\n"
+	writeRepoFiles(t, mainDir, map[string]string{"grove.yaml": base})
+
+	// Branch without its own copy.
+	assert.Contains(t, branchConfigNotice(mainDir, worktreeDir, "feat/x", nil), "branch feat/x has no grove.yaml; this instance uses the main checkout's grove.yaml")
+
+	writeRepoFiles(t, worktreeDir, map[string]string{"grove.yaml": base})
+	assert.Empty(t, branchConfigNotice(mainDir, worktreeDir, "feat/x", nil))
+
+	writeRepoFiles(t, worktreeDir, map[string]string{"grove.yaml": strings.Replace(base, "node:22", "node:24", 1)})
+	notice := branchConfigNotice(mainDir, worktreeDir, "feat/x", nil)
+	assert.Equal(t, "notice: grove.yaml on branch feat/x differs from the main checkout's; this instance uses the branch's.\n"+
+		"  --- grove.yaml (main checkout)\n"+
+		"  +++ grove.yaml (branch feat/x)\n"+
+		"   name: app\n"+
+		"   container:\n"+
+		"  -  image: node:22\n"+
+		"  +  image: node:24\n"+
+		"   start:\n"+
+		"     - npm ci\n"+
+		"  ...\n", notice)
+
+	notice = branchConfigNotice(mainDir, worktreeDir, "feat/x", errors.New("parse grove.yaml: bad"))
+	assert.Contains(t, notice, "warning: grove.yaml on branch feat/x does not load (parse grove.yaml: bad); this instance uses the main checkout's")
+	assert.Contains(t, notice, "+  image: node:24")
+
+	// A long diff is summarised.
+	writeRepoFiles(t, worktreeDir, map[string]string{"grove.yaml": strings.Repeat("# comment\n", maxConfigDiffLines+1)})
+	assert.Contains(t, branchConfigNotice(mainDir, worktreeDir, "feat/x", nil), "lines differ; too many to show")
+}
+
+// writeRepoFiles creates files (path → content) under dir.
+func writeRepoFiles(t testing.TB, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		full := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(full), 0o755))
+		require.NoError(t, os.WriteFile(full, []byte(content), 0o644))
+	}
+}
+
+func TestLoadInRepoConfigPreferredLocation(t *testing.T) {
+	dataDir := t.TempDir()
+	mainDir := filepath.Join(dataDir, "main")
+	writeRepoFiles(t, mainDir, map[string]string{".grove/grove.yaml": "start: [dotgrove]\n"})
+
+	p := &Project{DataDir: dataDir}
+	found, err := loadInRepoConfig(p, "", "")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, commands("dotgrove"), p.Start)
+
+	// With both present, .grove/grove.yaml wins and the other is ignored.
+	writeRepoFiles(t, mainDir, map[string]string{"grove.yaml": "start: [toplevel]\nfinish: [x]\n"})
+	p = &Project{DataDir: dataDir}
+	_, err = loadInRepoConfig(p, "", "")
+	require.NoError(t, err)
+	assert.Equal(t, commands("dotgrove"), p.Start)
+	assert.Empty(t, p.Finish)
+}
+
+func TestFindInRepoConfigWarnsOnce(t *testing.T) {
+	repoDir := t.TempDir()
+	writeRepoFiles(t, repoDir, map[string]string{".grove/grove.yaml": "start: [a]\n", "grove.yaml": "start: [b]\n"})
+	logs := captureLog(t, DebugOff)
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, filepath.Join(repoDir, ".grove", "grove.yaml"), findInRepoConfig(repoDir))
+	}
+	assert.Equal(t, 1, strings.Count(logs.String(), "both .grove/grove.yaml and grove.yaml exist"), logs.String())
+}
+
+func TestLoadInRepoConfigIncludes(t *testing.T) {
+	dataDir := t.TempDir()
+	mainDir := filepath.Join(dataDir, "main")
+	writeRepoFiles(t, mainDir, map[string]string{
+		".grove/grove.yaml": "include:\n  - shared/base.yaml\n  - shared/go.yaml\nfinish: [git push]\n",
+		// base sets the image and start; go.yaml (later) overrides start.
+		"shared/base.yaml": "include: [shared/mounts.yaml]\ncontainer:\n  image: ubuntu:24.04\nstart: [base-setup]\ncheck: [make lint]\n",
+		"shared/go.yaml":   "start: [go mod download]\noverrides:\n  release/*:\n    check: [make release]\n",
+		// Nested include, merged before base.yaml itself.
+		"shared/mounts.yaml": "container:\n  image: alpine\n  mounts: [~/.ssh]\n",
+	})
+
+	p := &Project{DataDir: dataDir}
+	found, err := loadInRepoConfig(p, "release/1", "")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "ubuntu:24.04", p.Container.Image, "including file wins over its includes")
+	assert.Equal(t, []string{"~/.ssh"}, p.Container.Mounts)
+	assert.Equal(t, commands("go mod download"), p.Start, "later includes win")
+	assert.Equal(t, commands("git push"), p.Finish)
+	assert.Equal(t, commands("make release"), p.Check.Commands, "overrides from includes apply")
+}
+
+func TestLoadInRepoConfigIncludeErrors(t *testing.T) {
+	cases := []struct {
+		name  string
+		files map[string]string
+		want  string
+	}{
+		{"missing", map[string]string{"grove.yaml": "include: [nope.yaml]\n"}, `included file "nope.yaml" not found`},
+		{"self cycle", map[string]string{"grove.yaml": "include: [grove.yaml]\n"}, "include cycle: grove.yaml → grove.yaml"},
+		{"cycle", map[string]string{
+			"grove.yaml": "include: [a.yaml]\n",
+			"a.yaml":     "include: [b.yaml]\n",
+			"b.yaml":     "include: [a.yaml]\n",
+		}, "include cycle: grove.yaml → a.yaml → b.yaml → a.yaml"},
+		{"outside repo", map[string]string{"grove.yaml": "include: [../secret.yaml]\n"}, "must be a path inside the repository"},
+		{"bad include yaml", map[string]string{"grove.yaml": "include: [a.yaml]\n", "a.yaml": "start: {\n"}, "parse a.yaml"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dataDir := t.TempDir()
+			writeRepoFiles(t, filepath.Join(dataDir, "main"), tc.files)
+			found, err := loadInRepoConfig(&Project{DataDir: dataDir}, "", "")
+			require.Error(t, err)
+			assert.False(t, found)
+			assert.Contains(t, err.Error(), tc.want)
+		})
+	}
+}
+
+func TestCheckConfigForms(t *testing.T) {
+	cases := []struct {
+		name string
+		yaml string
+		want CheckConfig
+	}{
+		{"list shorthand", "check:\n  - go test ./...\n", CheckConfig{Commands: commands("go test ./...")}},
+		{"mapping", "check:\n  auto: on-ready\n  report_to_agent: true\n  commands:\n    - make lint\n",
+			CheckConfig{Commands: commands("make lint"), Auto: "on-ready", ReportToAgent: true}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var p Project
+			require.NoError(t, yaml.Unmarshal([]byte(tc.yaml), &p))
+			assert.Equal(t, tc.want, p.Check)
+		})
+	}
+
+	var p Project
+	assert.Error(t, yaml.Unmarshal([]byte("check:\n  auto: sometimes\n"), &p))
+}
+
+func TestContainerConfigChecks(t *testing.T) {
+	var p Project
+	require.NoError(t, yaml.Unmarshal([]byte("container:\n  image: alpine\n"), &p))
+	assert.Equal(t, []string{"/tmp:size=1g"}, p.containerTmpfs(), "unset means the default")
+
+	p = Project{}
+	require.NoError(t, yaml.Unmarshal([]byte("container:\n  tmpfs: []\n"), &p))
+	assert.Empty(t, p.containerTmpfs(), "explicit [] means none")
+
+	p = Project{}
+	require.NoError(t, yaml.Unmarshal([]byte(
+		"container:\n  tmpfs: [/tmp:size=512m, '/run:size=10%,mode=1777', /scratch]\n  scratch: [/cache]\n"), &p))
+	assert.Equal(t, []string{"/tmp:size=512m", "/run:size=10%,mode=1777", "/scratch"}, p.containerTmpfs())
+	assert.Equal(t, []string{"/cache"}, p.Container.Scratch)
+
+	p = Project{}
+	require.NoError(t, yaml.Unmarshal([]byte("container:\n  platform: linux/arm/v7\n"), &p))
+	assert.Equal(t, "linux/arm/v7", p.Container.Platform)
+
+	for _, bad := range []struct{ yaml, err string }{
+		{"container:\n  tmpfs: [/tmp:size=lots]\n", `size "lots"`},
+		{"container:\n  tmpfs: [/tmp:size=1gb]\n", `size "1gb"`},
+		{"container:\n  tmpfs: [tmp]\n", "must be absolute"},
+		{"container:\n  scratch: [cache]\n", "must be absolute"},
+		{"container:\n  scratch: ['/a,b']\n", "must not contain"},
+		{"container:\n  platform: amd64\n", "must be os/arch"},
+	} {
+		err := yaml.Unmarshal([]byte(bad.yaml), &Project{})
+		if assert.Error(t, err, bad.yaml) {
+			assert.Contains(t, err.Error(), bad.err, bad.yaml)
+		}
+	}
+}
+
+// commands returns plain command specs running each of runs.
+func commands(runs ...string) []CommandSpec {
+	specs := make([]CommandSpec, len(runs))
+	for i, r := range runs {
+		specs[i] = CommandSpec{Run: r}
+	}
+	return specs
+}
+
+func TestCommandSpecForms(t *testing.T) {
+	cfg := `start:
+  - npm install
+  - run: go test ./...
+    dir: services/api
+    timeout: 5m
+    name: api-tests
+  - run: gh auth login
+    tty: true
+  - run: open http://localhost:3000
+    host: true
+`
+	var p Project
+	require.NoError(t, yaml.Unmarshal([]byte(cfg), &p))
+	assert.Equal(t, []CommandSpec{
+		{Run: "npm install"},
+		{Run: "go test ./...", Dir: "services/api", Timeout: 5 * time.Minute, Name: "api-tests"},
+		{Run: "gh auth login", TTY: true},
+		{Run: "open http://localhost:3000", Host: true},
+	}, p.Start)
+
+	// Plain commands are written back in the string form.
+	out, err := yaml.Marshal(p.Start)
+	require.NoError(t, err)
+	assert.Equal(t, "- npm install\n- run: go test ./...\n  dir: services/api\n  timeout: 5m0s\n  name: api-tests\n- run: gh auth login\n  tty: true\n- run: open http://localhost:3000\n  host: true\n", string(out))
+	var back []CommandSpec
+	require.NoError(t, yaml.Unmarshal(out, &back))
+	assert.Equal(t, p.Start, back)
+
+	assert.Error(t, yaml.Unmarshal([]byte("finish:\n  - dir: sub\n"), &p), "run is required")
+	assert.Error(t, yaml.Unmarshal([]byte("finish:\n  - run: x\n    timeout: soon\n"), &p))
+}
+
+func TestCommandDir(t *testing.T) {
+	p := &Project{}
+	assert.Equal(t, "", p.commandDir(CommandSpec{Run: "x"}))
+	assert.Equal(t, "/app/services/api", p.commandDir(CommandSpec{Run: "x", Dir: "services/api"}))
+	assert.Equal(t, "/srv", p.commandDir(CommandSpec{Run: "x", Dir: "/srv"}))
+	p.Container.Workdir = "/work"
+	assert.Equal(t, "/work", p.commandDir(CommandSpec{Run: "x", Dir: "."}))
+}
+
+func TestCheckConfigRunsOn(t *testing.T) {
+	onWaiting := CheckConfig{Auto: checkAutoOnWaiting}
+	assert.True(t, onWaiting.runsOn(proto.StateWaiting))
+	assert.True(t, onWaiting.runsOn(proto.StateReady))
+
+	onReady := CheckConfig{Auto: checkAutoOnReady}
+	assert.False(t, onReady.runsOn(proto.StateWaiting))
+	assert.True(t, onReady.runsOn(proto.StateReady))
+
+	assert.False(t, CheckConfig{}.runsOn(proto.StateReady))
+	assert.False(t, CheckConfig{Auto: checkAutoNever}.runsOn(proto.StateReady))
+}
+
+func TestResumeArgs(t *testing.T) {
+	p := &Project{}
+	assert.Equal(t, []string{"--continue"}, p.resumeArgs("claude"))
+	assert.Equal(t, []string{"--restore-chat-history"}, p.resumeArgs("aider"))
+	assert.Empty(t, p.resumeArgs("sh"))
+
+	require.NoError(t, yaml.Unmarshal([]byte("agent:\n  command: claude\n  resume_args: []\n"), p))
+	assert.Empty(t, p.resumeArgs("claude"), "explicit [] disables resuming")
+
+	require.NoError(t, yaml.Unmarshal([]byte("agent:\n  command: claude\n  resume_args: [--resume, last]\n"), p))
+	assert.Equal(t, []string{"--resume", "last"}, p.resumeArgs("claude"))
+}
+
+func TestApplyLaunch(t *testing.T) {
+	started := &Project{}
+	started.Agent.Command = "aider"
+	started.Agent.Args = []string{"--yes"}
+	started.Container.Compose = "docker-compose.yml"
+	started.Container.Service = "web"
+	launch := started.launchConfig()
+
+	p := &Project{}
+	p.Agent.Command = "claude"
+	p.Container.Image = "ruby:3.3"
+	p.Container.Workdir = "/src"
+	p.Start = commands("bundle install")
+	p.applyLaunch(launch)
+
+	assert.Equal(t, "aider", p.Agent.Command)
+	assert.Equal(t, []string{"--yes"}, p.Agent.Args)
+	assert.Equal(t, ContainerConfig{Compose: "docker-compose.yml", Service: "web", Tmpfs: optionalArgs{defaultTmpfs}}, p.Container)
+	assert.Equal(t, commands("bundle install"), p.Start, "settings not recorded are kept")
+
+	// A migrated record knows only the agent; the container config stays.
+	p = &Project{}
+	p.Container.Image = "ruby:3.3"
+	p.applyLaunch(&proto.LaunchConfig{AgentCommand: "sh"})
+	assert.Equal(t, "sh", p.Agent.Command)
+	assert.Equal(t, "ruby:3.3", p.Container.Image)
+
+	p.applyLaunch(nil)
+	assert.Equal(t, "sh", p.Agent.Command)
+}
+
+func TestInstanceProjectWithoutRegistration(t *testing.T) {
+	root := t.TempDir()
+	mainDir := filepath.Join(root, "projects", "gone", "main")
+	writeRepoFiles(t, mainDir, map[string]string{
+		"grove.yaml": "finish:\n  - git push\ncontainer:\n  image: alpine\n",
+	})
+
+	d := &Daemon{rootDir: root, instances: make(map[string]*Instance)}
+	inst := &Instance{
+		ID:      "1",
+		Project: "gone",
+		Branch:  "feat/x",
+		MainDir: mainDir,
+		Launch:  &proto.LaunchConfig{AgentCommand: "aider", Image: "ruby:3.3"},
+	}
+
+	p, err := d.instanceProject(inst, false)
+	require.NoError(t, err)
+	assert.Equal(t, mainDir, p.MainDir())
+	assert.Equal(t, commands("git push"), p.Finish)
+	assert.Equal(t, "aider", p.Agent.Command)
+	assert.Equal(t, "ruby:3.3", p.Container.Image, "the launch record wins over grove.yaml")
+
+	inst.MainDir = ""
+	_, err = d.instanceProject(inst, false)
+	assert.Error(t, err)
+}
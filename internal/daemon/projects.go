@@ -0,0 +1,328 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gandalfthegui/grove/internal/registry"
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+// projectInfo describes a registered project for ReqProjects and
+// ReqProjectResolve.
+func (d *Daemon) projectInfo(e registry.Entry) proto.ProjectInfo {
+	info := proto.ProjectInfo{Name: e.Name, Repo: e.Repo, Source: e.Source}
+
+	p := entryProject(d.rootDir, e)
+	var fetched time.Time
+	info.Cloned, fetched = checkoutFetched(p.MainDir())
+	if !fetched.IsZero() {
+		info.FetchedAt = fetched.Unix()
+	}
+	if found, err := loadInRepoConfig(p, "", ""); err == nil && found {
+		info.HasConfig = true
+		info.AgentCommand = p.Agent.Command
+		info.Defaults = p.Defaults.info()
+	}
+
+	var insts []*Instance
+	d.mu.Lock()
+	for _, inst := range d.instances {
+		if inst.Project == e.Name {
+			insts = append(insts, inst)
+		}
+	}
+	d.mu.Unlock()
+	info.Instances = len(insts)
+	now := time.Now()
+	for _, inst := range insts {
+		inst.mu.Lock()
+		state, _ := inst.currentState(now)
+		inst.mu.Unlock()
+		if info.InstanceStates == nil {
+			info.InstanceStates = make(map[string]int)
+		}
+		info.InstanceStates[state]++
+		info.LastStartAt = max(info.LastStartAt, inst.CreatedAt.Unix())
+	}
+	return info
+}
+
+// handleProjects describes every registered project, measuring the disk
+// each takes if req.DiskUsage is set.
+func (d *Daemon) handleProjects(conn net.Conn, req proto.Request) {
+	projects := []proto.ProjectInfo{}
+	for _, e := range registry.List(d.projectDirs) {
+		info := d.projectInfo(e)
+		if req.DiskUsage {
+			d.measureProject(&info, entryProject(d.rootDir, e))
+		}
+		projects = append(projects, info)
+	}
+	respond(conn, proto.Response{OK: true, Projects: projects})
+}
+
+func (d *Daemon) handleProjectResolve(conn net.Conn, req proto.Request) {
+	e, err := registry.Resolve(d.projectDirs, req.Project)
+	if err != nil {
+		respond(conn, proto.Response{OK: false, Error: err.Error()})
+		return
+	}
+	if req.Checkout {
+		if err := d.ensureProjectCheckout(e); err != nil {
+			respond(conn, errorResponse(err))
+			return
+		}
+	}
+	info := d.projectInfo(e)
+	respond(conn, proto.Response{OK: true, Project: &info})
+}
+
+// ensureProjectCheckout clones e's main checkout if it does not exist yet.
+func (d *Daemon) ensureProjectCheckout(e registry.Entry) error {
+	p := entryProject(d.rootDir, e)
+	if err := p.checkDataDir(); err != nil {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(p.MainDir(), ".git")); err == nil {
+		return nil
+	}
+	unlock, err := d.projectLocks.lock(e.Name, "clone", projectLockTimeout, io.Discard)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := d.checkDisk(io.Discard); err != nil {
+		return err
+	}
+	if err := ensureMainCheckout(context.Background(), p, io.Discard); err != nil {
+		return err
+	}
+	log.Printf("project %s: cloned %s into %s", e.Name, e.Repo, p.MainDir())
+	return nil
+}
+
+// checkoutFetched reports whether mainDir holds a clone and, if known, when
+// it was last cloned or pulled: git rewrites FETCH_HEAD on every fetch or
+// pull, and until the first one .git/HEAD dates from the clone.
+func checkoutFetched(mainDir string) (cloned bool, at time.Time) {
+	gitDir := filepath.Join(mainDir, ".git")
+	if _, err := os.Stat(gitDir); err != nil {
+		return false, time.Time{}
+	}
+	for _, name := range []string{"FETCH_HEAD", "HEAD"} {
+		if fi, err := os.Stat(filepath.Join(gitDir, name)); err == nil {
+			return true, fi.ModTime()
+		}
+	}
+	return true, time.Time{}
+}
+
+// handleProjectFetch clones a project's main checkout, or pulls it if it
+// exists, so that the first grove start does not pay for the clone.  The
+// output is streamed after the response and ends with a result trailer.
+//
+// grove project create sends the request with Prefetch after registering a
+// project with a repo.  Then the fetch runs in the background, and only if
+// ~/.grove/config.yaml sets prefetch_on_register: true.
+func (d *Daemon) handleProjectFetch(conn net.Conn, req proto.Request) {
+	e, err := registry.Resolve(d.projectDirs, req.Project)
+	if err != nil {
+		respond(conn, proto.Response{OK: false, Error: err.Error()})
+		return
+	}
+	if req.Prefetch {
+		if e.Repo == "" || !d.loadDaemonConfig().PrefetchOnRegister {
+			respond(conn, proto.Response{OK: true})
+			return
+		}
+		respond(conn, proto.Response{OK: true, Prefetching: true})
+		go func() {
+			if err := d.fetchProject(e, io.Discard); err != nil {
+				log.Printf("project %s: prefetch failed: %v", e.Name, err)
+			}
+		}()
+		return
+	}
+
+	respond(conn, proto.Response{OK: true})
+	started := time.Now()
+	w := newResilientWriter(conn, nil)
+	res := streamResult(started, nil)
+	if err := d.fetchProject(e, w); err != nil {
+		fmt.Fprintf(w, "error: %v\n", err)
+		res.OK = false
+		res.Error = err.Error()
+		resp := errorResponse(err)
+		res.ErrorCode, res.Hint = resp.ErrorCode, resp.Hint
+	}
+	proto.WriteResultTrailer(conn, res)
+}
+
+// fetchProject clones e's main checkout, or pulls it if it is cloned
+// already, under the project lock.  Output goes to w.
+func (d *Daemon) fetchProject(e registry.Entry, w io.Writer) error {
+	p := entryProject(d.rootDir, e)
+	if err := p.checkDataDir(); err != nil {
+		return err
+	}
+	unlock, err := d.projectLocks.lock(e.Name, "fetch", projectLockTimeout, w)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	started := time.Now()
+	if cloned, _ := checkoutFetched(p.MainDir()); cloned {
+		if err := pullMain(context.Background(), p, w); err != nil {
+			return err
+		}
+		log.Printf("project %s: pulled %s in %s", e.Name, p.MainDir(), time.Since(started).Round(time.Millisecond))
+		return nil
+	}
+	if err := d.checkDisk(w); err != nil {
+		return err
+	}
+	if err := ensureMainCheckout(context.Background(), p, w); err != nil {
+		return err
+	}
+	log.Printf("project %s: cloned %s into %s in %s", e.Name, e.Repo, p.MainDir(), time.Since(started).Round(time.Millisecond))
+	return nil
+}
+
+// handleProjectDelete removes a project and everything grove made for it: its
+// instances (containers, worktrees, branches), containers labelled with the
+// project that no instance refers to any more, its Docker volumes and built
+// images unless asked to keep them, and its data directory, registration
+// included (of a data_dir, its main checkout and worktrees).  Progress is
+// streamed after the response, ending with a result trailer.  Registrations
+// in shared directories are not grove's to delete.
+func (d *Daemon) handleProjectDelete(conn net.Conn, req proto.Request) {
+	e, err := registry.Find(d.projectDirs, req.Project)
+	if err != nil {
+		respond(conn, proto.Response{OK: false, Error: err.Error()})
+		return
+	}
+	personal := registry.PersonalDir(d.rootDir)
+	if filepath.Clean(e.Source) != filepath.Clean(personal) {
+		respond(conn, proto.Response{OK: false, Error: fmt.Sprintf(
+			"project %q is registered in shared directory %s; remove it there", e.Name, e.Source)})
+		return
+	}
+
+	// Deleting the registration alone would leave the data on the
+	// volume with nothing pointing at it.
+	p := entryProject(d.rootDir, e)
+	if err := p.checkDataDir(); err != nil {
+		respond(conn, errorResponse(err))
+		return
+	}
+
+	unlockProject, err := d.projectLocks.lock(e.Name, "delete project", projectLockTimeout, io.Discard)
+	if err != nil {
+		respond(conn, proto.Response{OK: false, Error: err.Error()})
+		return
+	}
+	defer unlockProject()
+
+	respond(conn, proto.Response{OK: true})
+
+	started := time.Now()
+	w := newResilientWriter(conn, nil)
+	var failures []string
+	fail := func(err error) {
+		fmt.Fprintf(w, "error: %v\n", err)
+		failures = append(failures, err.Error())
+	}
+
+	d.mu.Lock()
+	var insts []*Instance
+	for _, inst := range d.instances {
+		if inst.Project == e.Name {
+			insts = append(insts, inst)
+		}
+	}
+	d.mu.Unlock()
+	sort.Slice(insts, func(i, j int) bool { return insts[i].CreatedAt.Before(insts[j].CreatedAt) })
+	for _, inst := range insts {
+		fmt.Fprintf(w, "Dropping instance %s (%s) …\n", inst.ID, inst.Branch)
+		for _, left := range d.dropInstance(inst, dropOptions{}) {
+			fmt.Fprintf(w, "warning: %s\n", left)
+		}
+	}
+
+	if err := removeProjectContainers(e.Name, w); err != nil {
+		fail(err)
+	}
+	if req.KeepVolumes {
+		fmt.Fprintln(w, "Keeping volumes")
+	} else if err := removeProjectVolumes(e.Name, w); err != nil {
+		fail(err)
+	}
+	if req.KeepImages {
+		fmt.Fprintln(w, "Keeping images")
+	} else if err := removeProjectImages(e.Name, w); err != nil {
+		fail(err)
+	}
+
+	// A data_dir is the user's directory; only what grove put in it goes.
+	if p.dataDirSet {
+		for _, dir := range []string{p.MainDir(), p.WorktreesDir()} {
+			fmt.Fprintf(w, "Removing %s …\n", dir)
+			if err := os.RemoveAll(dir); err != nil {
+				fail(err)
+			}
+		}
+	}
+	dataDir := filepath.Join(personal, e.Name)
+	fmt.Fprintf(w, "Removing %s …\n", dataDir)
+	if err := os.RemoveAll(dataDir); err != nil {
+		fail(err)
+	}
+
+	res := streamResult(started, nil)
+	if len(failures) > 0 {
+		res.OK = false
+		res.Error = strings.Join(failures, "; ")
+	}
+	log.Printf("project %s deleted: instances=%d ok=%v", e.Name, len(insts), res.OK)
+	proto.WriteResultTrailer(conn, res)
+}
+
+// handleProjectLock takes a project's lock for grove project shell and
+// holds it until the client closes the connection, so that starts, pulls
+// and worktree changes wait while the user works in the main checkout.
+func (d *Daemon) handleProjectLock(conn net.Conn, req proto.Request) {
+	e, err := registry.Resolve(d.projectDirs, req.Project)
+	if err != nil {
+		respond(conn, proto.Response{OK: false, Error: err.Error()})
+		return
+	}
+	unlock, holder, ok := d.projectLocks.tryLock(e.Name, "project shell")
+	if !ok {
+		busy := "another operation"
+		if holder.what != "" {
+			busy = holder.String()
+		}
+		respond(conn, proto.Response{
+			OK:    false,
+			Error: fmt.Sprintf("project %s is busy: %s", e.Name, busy),
+			Hint:  "try again when it is done, or use --no-lock to look around without changing anything",
+		})
+		return
+	}
+	defer unlock()
+	log.Printf("project %s: locked for a shell", e.Name)
+	respond(conn, proto.Response{OK: true})
+	// Nothing more is sent; the read ends when the client goes away.
+	io.Copy(io.Discard, conn)
+	log.Printf("project %s: shell lock released", e.Name)
+}
@@ -0,0 +1,137 @@
+package daemon
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gandalfthegui/grove/internal/registry"
+	"github.com/gandalfthegui/grove/pkg/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProjectInfo(t *testing.T) {
+	root := t.TempDir()
+	started := time.Unix(1700000000, 0)
+	d := &Daemon{rootDir: root, instances: map[string]*Instance{
+		"1": {ID: "1", Project: "api", CreatedAt: started, state: proto.StateExited},
+		"2": {ID: "2", Project: "api", CreatedAt: started.Add(time.Hour), state: proto.StateFinished},
+		"3": {ID: "3", Project: "web", CreatedAt: started.Add(2 * time.Hour), state: proto.StateExited},
+	}}
+
+	mainDir := filepath.Join(root, "projects", "api", "main")
+	require.NoError(t, os.MkdirAll(mainDir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(mainDir, "grove.yaml"), []byte("agent:\n  command: aider\n"), 0o644))
+
+	info := d.projectInfo(registry.Entry{Name: "api", Repo: "git@x:api.git", Source: "/shared"})
+	assert.Equal(t, proto.ProjectInfo{
+		Name: "api", Repo: "git@x:api.git", Source: "/shared",
+		HasConfig: true, AgentCommand: "aider", Instances: 2,
+		InstanceStates: map[string]int{proto.StateExited: 1, proto.StateFinished: 1},
+		LastStartAt:    started.Add(time.Hour).Unix(),
+	}, info)
+
+	info = d.projectInfo(registry.Entry{Name: "new", Source: "/shared"})
+	assert.False(t, info.HasConfig, "not cloned yet")
+	assert.Zero(t, info.Instances)
+	assert.Nil(t, info.InstanceStates)
+	assert.Zero(t, info.LastStartAt)
+}
+
+func TestEnsureProjectCheckout(t *testing.T) {
+	origin := filepath.Join(t.TempDir(), "origin")
+	git(t, "init", "-q", origin)
+	writeRepoFiles(t, origin, map[string]string{"grove.yaml": "defaults:\n  detach: true\n"})
+	git(t, "-C", origin, "add", ".")
+	git(t, "-C", origin, "-c", "user.name=t", "-c", "user.email=t@t", "commit", "-q", "-m", "init")
+
+	d := &Daemon{rootDir: t.TempDir(), instances: map[string]*Instance{}}
+	e := registry.Entry{Name: "api", Repo: origin}
+	assert.False(t, d.projectInfo(e).HasConfig, "not cloned yet")
+
+	require.NoError(t, d.ensureProjectCheckout(e))
+	info := d.projectInfo(e)
+	assert.True(t, info.HasConfig)
+	assert.Equal(t, &proto.StartDefaults{Detach: true}, info.Defaults)
+
+	require.NoError(t, d.ensureProjectCheckout(e), "already cloned")
+}
+
+func TestFetchProject(t *testing.T) {
+	origin := filepath.Join(t.TempDir(), "origin")
+	git(t, "init", "-q", origin)
+	writeRepoFiles(t, origin, map[string]string{"README": "one\n"})
+	git(t, "-C", origin, "add", ".")
+	git(t, "-C", origin, "-c", "user.name=t", "-c", "user.email=t@t", "commit", "-q", "-m", "one")
+
+	d := &Daemon{rootDir: t.TempDir(), instances: map[string]*Instance{}}
+	e := registry.Entry{Name: "api", Repo: origin}
+	mainDir := filepath.Join(d.rootDir, "projects", "api", "main")
+	cloned, _ := checkoutFetched(mainDir)
+	assert.False(t, cloned)
+
+	var out strings.Builder
+	require.NoError(t, d.fetchProject(e, &out))
+	cloned, at := checkoutFetched(mainDir)
+	assert.True(t, cloned)
+	assert.WithinDuration(t, time.Now(), at, time.Minute)
+	info := d.projectInfo(e)
+	assert.True(t, info.Cloned)
+	assert.Equal(t, at.Unix(), info.FetchedAt)
+
+	// Fetching again pulls.
+	writeRepoFiles(t, origin, map[string]string{"README": "two\n"})
+	git(t, "-C", origin, "-c", "user.name=t", "-c", "user.email=t@t", "commit", "-q", "-am", "two")
+	require.NoError(t, d.fetchProject(e, &out))
+	data, err := os.ReadFile(filepath.Join(mainDir, "README"))
+	require.NoError(t, err)
+	assert.Equal(t, "two\n", string(data))
+	_, err = os.Stat(filepath.Join(mainDir, ".git", "FETCH_HEAD"))
+	assert.NoError(t, err)
+
+	assert.Error(t, d.fetchProject(registry.Entry{Name: "norepo"}, &out))
+}
+
+func TestHandleProjectFetchPrefetch(t *testing.T) {
+	origin := filepath.Join(t.TempDir(), "origin")
+	git(t, "init", "-q", origin)
+	git(t, "-C", origin, "-c", "user.name=t", "-c", "user.email=t@t", "commit", "-q", "--allow-empty", "-m", "init")
+	root := t.TempDir()
+	writeRepoFiles(t, filepath.Join(root, "projects", "api"), map[string]string{
+		"project.yaml": "name: api\nrepo: " + origin + "\n",
+	})
+	d := &Daemon{rootDir: root, projectDirs: registry.SearchDirs(root, nil), instances: map[string]*Instance{}}
+
+	prefetch := func() proto.Response {
+		t.Helper()
+		client, server := net.Pipe()
+		defer client.Close()
+		go d.handleProjectFetch(server, proto.Request{Type: proto.ReqProjectFetch, Project: "api", Prefetch: true})
+		var resp proto.Response
+		require.NoError(t, json.NewDecoder(client).Decode(&resp))
+		return resp
+	}
+
+	resp := prefetch()
+	assert.True(t, resp.OK)
+	assert.False(t, resp.Prefetching, "prefetch_on_register is off by default")
+
+	require.NoError(t, os.WriteFile(filepath.Join(root, "config.yaml"), []byte("prefetch_on_register: true\n"), 0o644))
+	resp = prefetch()
+	assert.True(t, resp.OK)
+	assert.True(t, resp.Prefetching)
+	assert.Eventually(t, func() bool {
+		cloned, _ := checkoutFetched(filepath.Join(root, "projects", "api", "main"))
+		return cloned
+	}, 5*time.Second, 10*time.Millisecond)
+	// Let the clone finish before the directories are removed.
+	unlock, err := d.projectLocks.lock("api", "test", time.Minute, io.Discard)
+	require.NoError(t, err)
+	unlock()
+}
@@ -0,0 +1,105 @@
+package daemon
+
+// projectusage.go – the disk a project's main checkout and worktrees take,
+// for grove project list --verbose.
+//
+// Walking a checkout with its node_modules or target directory takes
+// seconds, so sizes are measured only when a request asks for them, and a
+// measurement is reused for diskUsageTTL.  A size is the space the files
+// take on disk, as du counts it, except that a file with several hard links
+// is counted at each.
+
+import (
+	"io/fs"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+// diskUsageTTL is how long a directory's measured size is reused.
+const diskUsageTTL = 5 * time.Minute
+
+// diskUsageCache holds recent sizes of directories.  The zero value is
+// ready to use.
+type diskUsageCache struct {
+	mu      sync.Mutex
+	entries map[string]*diskUsage // by directory
+}
+
+// diskUsage is a directory's size.  bytes and at are set before ready is
+// closed, and not changed after.
+type diskUsage struct {
+	bytes int64
+	at    time.Time
+	ready chan struct{}
+}
+
+// measured reports whether u's size is known.
+func (u *diskUsage) measured() bool {
+	select {
+	case <-u.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// size returns the bytes dir takes on disk and when that was measured.  It
+// measures dir unless it was less than diskUsageTTL ago; calls for a dir
+// being measured wait for that measurement.
+func (c *diskUsageCache) size(dir string) (int64, time.Time) {
+	c.mu.Lock()
+	if c.entries == nil {
+		c.entries = make(map[string]*diskUsage)
+	}
+	u, ok := c.entries[dir]
+	if ok && (!u.measured() || time.Since(u.at) < diskUsageTTL) {
+		c.mu.Unlock()
+		<-u.ready
+		return u.bytes, u.at
+	}
+	u = &diskUsage{ready: make(chan struct{})}
+	c.entries[dir] = u
+	c.mu.Unlock()
+
+	u.bytes, u.at = dirDiskUsage(dir), time.Now()
+	close(u.ready)
+	return u.bytes, u.at
+}
+
+// dirDiskUsage returns the bytes the files under dir take on disk; 0 if
+// there is no dir.  What cannot be read, such as files a container created
+// as another user, is left out.
+func dirDiskUsage(dir string) int64 {
+	var total int64
+	filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		fi, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+			total += int64(st.Blocks) * 512
+		} else {
+			total += fi.Size()
+		}
+		return nil
+	})
+	return total
+}
+
+// measureProject sets the sizes of p's main checkout and worktrees in info.
+func (d *Daemon) measureProject(info *proto.ProjectInfo, p *Project) {
+	var mainAt, worktreesAt time.Time
+	info.MainBytes, mainAt = d.diskUsage.size(p.MainDir())
+	info.WorktreesBytes, worktreesAt = d.diskUsage.size(p.WorktreesDir())
+	if worktreesAt.Before(mainAt) {
+		mainAt = worktreesAt
+	}
+	info.MeasuredAt = mainAt.Unix()
+}
@@ -0,0 +1,70 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiskUsageCache(t *testing.T) {
+	dir := t.TempDir()
+	writeRepoFiles(t, dir, map[string]string{"a": strings.Repeat("x", 64<<10), "sub/b": strings.Repeat("y", 64<<10)})
+	var c diskUsageCache
+
+	size, at := c.size(dir)
+	assert.GreaterOrEqual(t, size, int64(128<<10))
+	assert.WithinDuration(t, time.Now(), at, time.Second)
+	empty, _ := c.size(filepath.Join(dir, "none"))
+	assert.Zero(t, empty, "no such directory")
+
+	writeRepoFiles(t, dir, map[string]string{"c": strings.Repeat("z", 64<<10)})
+	again, againAt := c.size(dir)
+	assert.Equal(t, size, again, "measured less than diskUsageTTL ago")
+	assert.Equal(t, at, againAt)
+
+	c.entries[dir].at = at.Add(-diskUsageTTL)
+	again, _ = c.size(dir)
+	assert.GreaterOrEqual(t, again, size+64<<10, "measured again")
+
+	// Concurrent calls share one measurement.
+	c.entries[dir].at = at.Add(-diskUsageTTL)
+	var wg sync.WaitGroup
+	sizes := make([]int64, 8)
+	for i := range sizes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sizes[i], _ = c.size(dir)
+		}(i)
+	}
+	wg.Wait()
+	for _, s := range sizes {
+		assert.Equal(t, again, s)
+	}
+}
+
+func TestProjectsDiskUsage(t *testing.T) {
+	d, _ := dataDirTest(t, proto.StateRunning)
+	worktree := filepath.Join(d.rootDir, "projects", "app", "worktrees", "feat-1")
+	require.NoError(t, os.WriteFile(filepath.Join(worktree, "big"), []byte(strings.Repeat("x", 256<<10)), 0o644))
+
+	resp := teardownRequest(t, d.handleProjects, proto.Request{Type: proto.ReqProjects})
+	require.Len(t, resp.Projects, 1)
+	info := resp.Projects[0]
+	assert.Equal(t, map[string]int{proto.StateRunning: 1}, info.InstanceStates)
+	assert.Zero(t, info.MainBytes, "measured only when asked")
+	assert.Zero(t, info.MeasuredAt)
+
+	resp = teardownRequest(t, d.handleProjects, proto.Request{Type: proto.ReqProjects, DiskUsage: true})
+	info = resp.Projects[0]
+	assert.Positive(t, info.MainBytes)
+	assert.GreaterOrEqual(t, info.WorktreesBytes, int64(256<<10))
+	assert.InDelta(t, time.Now().Unix(), info.MeasuredAt, 2)
+}
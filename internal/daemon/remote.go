@@ -0,0 +1,111 @@
+package daemon
+
+// remote.go – tracking whether each instance's branch has reached origin, so
+// `grove list -o wide` and `grove watch` can tell pushed work from local-only
+// work.  The remote is queried with `git ls-remote` after finish and, for
+// every other instance, at most once per remoteQueryInterval.
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+const (
+	// remoteLoopInterval is how often the daemon looks for instances whose
+	// remote status is due a refresh.
+	remoteLoopInterval = 30 * time.Second
+
+	// remoteQueryInterval is the minimum time between ls-remote calls for
+	// one instance, whether or not the previous call succeeded.
+	remoteQueryInterval = 5 * time.Minute
+
+	// remoteQueryTimeout bounds a single ls-remote so an unreachable remote
+	// does not hold up finish.
+	remoteQueryTimeout = 15 * time.Second
+)
+
+// lsRemoteHead returns the SHA of refs/heads/<branch> on origin, or "" if the
+// branch does not exist there.  An error means the remote could not be asked.
+func lsRemoteHead(repoDir, branch string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), remoteQueryTimeout)
+	defer cancel()
+	cmd := gitCommandContext(ctx, "-C", repoDir, "ls-remote", "--heads", "origin", "refs/heads/"+branch)
+	// Never block on a credential prompt; the daemon has no terminal.
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git ls-remote: %w", err)
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[1] == "refs/heads/"+branch {
+			return fields[0], nil
+		}
+	}
+	return "", nil
+}
+
+// remoteDue reports whether inst's remote status should be refreshed now,
+// and if so records the attempt.  force skips the rate limit (used after
+// finish, which is when branches are usually pushed).
+func (inst *Instance) remoteDue(now time.Time, force bool) bool {
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+	if !force && now.Sub(inst.remoteQueried) < remoteQueryInterval {
+		return false
+	}
+	inst.remoteQueried = now
+	return true
+}
+
+// refreshRemote queries origin for inst's branch and records the result.
+// On failure (e.g. offline) the last known status is kept.
+func (d *Daemon) refreshRemote(inst *Instance, force bool) {
+	now := time.Now()
+	if !inst.remoteDue(now, force) {
+		return
+	}
+	if _, err := os.Stat(inst.WorktreeDir); err != nil {
+		return
+	}
+	sha, err := lsRemoteHead(inst.WorktreeDir, inst.Branch)
+	if err != nil {
+		log.Printf("instance %s: remote status: %v", inst.ID, err)
+		return
+	}
+
+	if d.getInstance(inst.ID) != inst {
+		return // dropped while we were asking
+	}
+	inst.mu.Lock()
+	inst.remote = &proto.RemoteStatus{Pushed: sha != "", SHA: sha, CheckedAt: now.Unix()}
+	inst.mu.Unlock()
+	inst.persistMeta(filepath.Join(d.rootDir, "instances"))
+}
+
+// remoteStatusLoop keeps every instance's remote status reasonably fresh.
+// Instances are refreshed one at a time to keep network use low.  It runs
+// for the life of the daemon.
+func (d *Daemon) remoteStatusLoop() {
+	ticker := time.NewTicker(remoteLoopInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		d.mu.Lock()
+		insts := make([]*Instance, 0, len(d.instances))
+		for _, inst := range d.instances {
+			insts = append(insts, inst)
+		}
+		d.mu.Unlock()
+
+		for _, inst := range insts {
+			d.refreshRemote(inst, false)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package daemon
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func git(t *testing.T, args ...string) string {
+	t.Helper()
+	out, err := exec.Command("git", args...).CombinedOutput()
+	require.NoError(t, err, "git %v: %s", args, out)
+	return strings.TrimSpace(string(out))
+}
+
+func TestLsRemoteHead(t *testing.T) {
+	origin := filepath.Join(t.TempDir(), "origin.git")
+	clone := filepath.Join(t.TempDir(), "clone")
+	git(t, "init", "--bare", origin)
+	git(t, "clone", origin, clone)
+	git(t, "-C", clone, "-c", "user.name=t", "-c", "user.email=t@t", "commit", "--allow-empty", "-m", "init")
+	git(t, "-C", clone, "push", "origin", "HEAD:refs/heads/feat/pushed")
+	head := git(t, "-C", clone, "rev-parse", "HEAD")
+
+	sha, err := lsRemoteHead(clone, "feat/pushed")
+	require.NoError(t, err)
+	assert.Equal(t, head, sha)
+
+	sha, err = lsRemoteHead(clone, "feat/local")
+	require.NoError(t, err)
+	assert.Empty(t, sha)
+
+	// A prefix of an existing branch is not a match.
+	sha, err = lsRemoteHead(clone, "pushed")
+	require.NoError(t, err)
+	assert.Empty(t, sha)
+
+	git(t, "-C", clone, "remote", "set-url", "origin", filepath.Join(t.TempDir(), "gone"))
+	_, err = lsRemoteHead(clone, "feat/pushed")
+	assert.Error(t, err, "unreachable remote must be an error, not local-only")
+}
+
+func TestRemoteDueRateLimited(t *testing.T) {
+	inst := &Instance{}
+	now := time.Now()
+	assert.True(t, inst.remoteDue(now, false))
+	assert.False(t, inst.remoteDue(now.Add(time.Minute), false))
+	assert.True(t, inst.remoteDue(now.Add(time.Minute), true), "force skips the rate limit")
+	assert.True(t, inst.remoteDue(now.Add(time.Minute+remoteQueryInterval), false))
+}
@@ -0,0 +1,195 @@
+package daemon
+
+// schema.go – a JSON Schema for grove.yaml, for editor completion and CI.
+//
+// The schema is generated by reflection from the structs grove.yaml is
+// decoded into, so it cannot describe a field the daemon does not read.
+// Types that decode from more than one YAML shape (CommandSpec, CheckConfig,
+// branch overrides) describe themselves through schemaDescriber.  The
+// generated file is checked in as docs/grove.schema.json; go generate
+// rewrites it and TestConfigSchemaUpToDate fails when it has drifted.
+
+//go:generate go run ./schemagen ../../docs/grove.schema.json
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+// durationPattern matches the Go durations time.ParseDuration accepts, e.g.
+// "90s" or "1h30m".  A bare number is refused: YAML would read it as
+// nanoseconds.
+const durationPattern = `^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`
+
+// schemaDescriber is implemented by config types whose YAML form is not
+// simply that of their Go type.
+type schemaDescriber interface {
+	jsonSchema(g *schemaGen) map[string]any
+}
+
+var (
+	durationType        = reflect.TypeOf(time.Duration(0))
+	schemaDescriberType = reflect.TypeOf((*schemaDescriber)(nil)).Elem()
+)
+
+// configFile is a grove.yaml document: a project config plus the files it
+// includes (see readInRepoConfig).
+type configFile struct {
+	Project `yaml:",inline"`
+	Include []string `yaml:"include"`
+}
+
+// schemaGen builds the schema of a Go type.
+type schemaGen struct {
+	defs map[string]any
+}
+
+// ConfigSchema returns the JSON Schema of grove.yaml, indented and with a
+// trailing newline.
+func ConfigSchema() []byte {
+	g := &schemaGen{defs: map[string]any{}}
+	g.defs["project"] = g.structSchema(reflect.TypeOf(Project{}))
+	root := g.structSchema(reflect.TypeOf(configFile{}))
+	root["type"] = "object"
+	root["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	root["title"] = "grove.yaml"
+	root["$defs"] = g.defs
+
+	data, err := json.MarshalIndent(root, "", "  ")
+	if err != nil {
+		panic(err) // only maps, strings and bools: cannot fail
+	}
+	return append(data, '\n')
+}
+
+// typeSchema returns the schema of values of type t.
+func (g *schemaGen) typeSchema(t reflect.Type) map[string]any {
+	if t == durationType {
+		return map[string]any{"type": "string", "pattern": durationPattern}
+	}
+	if t.Implements(schemaDescriberType) {
+		return reflect.Zero(t).Interface().(schemaDescriber).jsonSchema(g)
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer", "minimum": 0}
+	case reflect.Slice:
+		return map[string]any{"type": []any{"array", "null"}, "items": g.typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": []any{"object", "null"}, "additionalProperties": g.typeSchema(t.Elem())}
+	case reflect.Struct:
+		return g.structSchema(t)
+	case reflect.Pointer:
+		// Optional: null leaves it unset.
+		return map[string]any{"anyOf": []any{g.typeSchema(t.Elem()), map[string]any{"type": "null"}}}
+	}
+	panic("grove.yaml schema: unsupported type " + t.String())
+}
+
+// structSchema returns the schema of a mapping decoded into struct t.  An
+// empty section (e.g. "start:" with nothing after it) is null, which
+// decodes to the zero value, so null is allowed too.
+func (g *schemaGen) structSchema(t reflect.Type) map[string]any {
+	props := map[string]any{}
+	g.addFields(t, props)
+	return map[string]any{
+		"type":                 []any{"object", "null"},
+		"properties":           props,
+		"additionalProperties": false,
+	}
+}
+
+// addFields adds the schema of each field of struct t that YAML decodes,
+// under its YAML key, to props.
+func (g *schemaGen) addFields(t reflect.Type, props map[string]any) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, opts, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		}
+		if strings.Contains(opts, "inline") {
+			g.addFields(f.Type, props)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		props[name] = g.typeSchema(f.Type)
+	}
+}
+
+// jsonSchema implements schemaDescriber: a command is a string or a mapping
+// with at least run:.
+func (CommandSpec) jsonSchema(g *schemaGen) map[string]any {
+	type plain CommandSpec
+	mapping := g.structSchema(reflect.TypeOf(plain{}))
+	mapping["type"] = "object"
+	mapping["required"] = []any{"run"}
+	return map[string]any{"anyOf": []any{map[string]any{"type": "string"}, mapping}}
+}
+
+// jsonSchema implements schemaDescriber: check is a list of commands or a
+// mapping with them under commands:.
+func (CheckConfig) jsonSchema(g *schemaGen) map[string]any {
+	type plain CheckConfig
+	mapping := g.structSchema(reflect.TypeOf(plain{}))
+	mapping["properties"].(map[string]any)["auto"] = map[string]any{
+		"type": "string",
+		"enum": []any{checkAutoNever, checkAutoOnWaiting, checkAutoOnReady},
+	}
+	list := g.typeSchema(reflect.TypeOf([]CommandSpec{}))
+	return map[string]any{"anyOf": []any{list, mapping}}
+}
+
+// jsonSchema implements schemaDescriber: ttl_action and mode take one of a
+// few values.
+func (StartDefaults) jsonSchema(g *schemaGen) map[string]any {
+	type plain StartDefaults
+	mapping := g.structSchema(reflect.TypeOf(plain{}))
+	mapping["properties"].(map[string]any)["ttl_action"] = map[string]any{
+		"type": "string",
+		"enum": []any{proto.TTLFinish, proto.TTLDrop},
+	}
+	mapping["properties"].(map[string]any)["mode"] = map[string]any{
+		"type": "string",
+		"enum": []any{proto.ModePlan, proto.ModeNormal, proto.ModeAuto},
+	}
+	return mapping
+}
+
+// jsonSchema implements schemaDescriber: overrides map branch patterns to
+// project configs.
+func (branchOverrides) jsonSchema(g *schemaGen) map[string]any {
+	return map[string]any{
+		"type":                 []any{"object", "null"},
+		"additionalProperties": map[string]any{"$ref": "#/$defs/project"},
+	}
+}
+
+// jsonSchema implements schemaDescriber: an agent needs a command, and its
+// name becomes part of a file name.
+func (AgentSpec) jsonSchema(g *schemaGen) map[string]any {
+	type plain AgentSpec
+	mapping := g.structSchema(reflect.TypeOf(plain{}))
+	mapping["type"] = "object"
+	mapping["required"] = []any{"command"}
+	mapping["properties"].(map[string]any)["name"] = map[string]any{
+		"type":    "string",
+		"pattern": agentNamePattern.String(),
+	}
+	return mapping
+}
@@ -0,0 +1,28 @@
+package daemon
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigSchemaUpToDate(t *testing.T) {
+	checkedIn, err := os.ReadFile("../../docs/grove.schema.json")
+	require.NoError(t, err)
+	assert.Equal(t, string(ConfigSchema()), string(checkedIn),
+		"docs/grove.schema.json is stale: run go generate ./internal/daemon")
+}
+
+func TestConfigSchemaShape(t *testing.T) {
+	var schema map[string]any
+	require.NoError(t, json.Unmarshal(ConfigSchema(), &schema))
+	props := schema["properties"].(map[string]any)
+	for _, key := range []string{"container", "agent", "start", "check", "finish", "overrides", "include"} {
+		assert.Contains(t, props, key)
+	}
+	assert.Equal(t, false, schema["additionalProperties"])
+	assert.Contains(t, schema["$defs"], "project")
+}
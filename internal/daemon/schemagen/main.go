@@ -0,0 +1,22 @@
+// Command schemagen writes the JSON Schema of grove.yaml (see
+// daemon.ConfigSchema) to the file named by its argument.  It is run by
+// go generate in internal/daemon.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/gandalfthegui/grove/internal/daemon"
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: schemagen <output-file>")
+		os.Exit(2)
+	}
+	if err := os.WriteFile(os.Args[1], daemon.ConfigSchema(), 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "schemagen: %v\n", err)
+		os.Exit(1)
+	}
+}
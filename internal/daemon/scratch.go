@@ -0,0 +1,117 @@
+package daemon
+
+// scratch.go – grove scratch: an instance on a repo URL or local directory
+// that is not a registered project.  The daemon makes up a project for it,
+// named scratch:<name>, whose data (clone and worktree) lives under
+// <root>/scratch/<instance id> and goes when the instance is dropped.  With
+// in-place the local directory is bind-mounted as it is, with no clone.
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+// defaultScratchImage is the container image of a scratch instance whose
+// repo has no grove.yaml, unless ~/.grove/config.yaml sets scratch_image.
+const defaultScratchImage = "ubuntu:24.04"
+
+// scratchDataDir returns the directory holding a scratch instance's data.
+func scratchDataDir(rootDir, instanceID string) string {
+	return filepath.Join(rootDir, "scratch", instanceID)
+}
+
+// scratchName returns the name of the project made up for source: its last
+// path component without .git.
+func scratchName(source string) string {
+	s := strings.TrimSuffix(strings.TrimRight(source, "/"), ".git")
+	if i := strings.LastIndexAny(s, "/:"); i >= 0 {
+		s = s[i+1:]
+	}
+	if s == "" {
+		s = "repo"
+	}
+	return proto.ScratchPrefix + s
+}
+
+// isRepoURL reports whether source names a remote repo rather than a local
+// directory.
+func isRepoURL(source string) bool {
+	return strings.Contains(source, "://") || sshURL(source)
+}
+
+// scratchProject makes up the project for a scratch instance on source, a
+// repo URL or an absolute path.  A local directory is cloned like a URL
+// unless inPlace is set; then it must be a directory, and becomes the main
+// checkout and the worktree both.
+func (d *Daemon) scratchProject(instanceID, source string, inPlace bool) (*Project, *proto.ScratchInfo, error) {
+	info := &proto.ScratchInfo{Source: source, DataDir: scratchDataDir(d.rootDir, instanceID), InPlace: inPlace}
+	p := &Project{Name: scratchName(source), DataDir: info.DataDir}
+	switch {
+	case isRepoURL(source):
+		if inPlace {
+			return nil, nil, fmt.Errorf("--in-place needs a local directory, not %s", source)
+		}
+		p.Repo = source
+	case !filepath.IsAbs(source):
+		return nil, nil, fmt.Errorf("scratch path %q is not absolute", source)
+	case !dirExists(source):
+		return nil, nil, fmt.Errorf("%s is not a directory", source)
+	case inPlace:
+		p.mainDir = source
+	case gitCommand("-C", source, "rev-parse", "--git-dir").Run() != nil:
+		return nil, nil, fmt.Errorf("%s is not a git repository; use --in-place to work in it directly", source)
+	default:
+		p.Repo = source
+	}
+	// Left over from a start the daemon did not live to clean up.
+	if err := os.RemoveAll(info.DataDir); err != nil {
+		return nil, nil, err
+	}
+	return p, info, nil
+}
+
+// applyScratchDefaults fills in what a scratch project without a grove.yaml
+// needs to start: an image and an agent.
+func (d *Daemon) applyScratchDefaults(p *Project) {
+	if p.Container.Image == "" && p.Container.Compose == "" {
+		p.Container.Image = d.loadDaemonConfig().ScratchImage
+		if p.Container.Image == "" {
+			p.Container.Image = defaultScratchImage
+		}
+	}
+	if p.Agent.Command == "" {
+		p.Agent.Command = "claude"
+	}
+}
+
+// scratchInstanceProject rebuilds the project of scratch instance inst, for
+// currentProject.
+func (d *Daemon) scratchInstanceProject(inst *Instance) *Project {
+	p := &Project{Name: inst.Project, DataDir: inst.Scratch.DataDir}
+	if inst.Scratch.InPlace {
+		p.mainDir = inst.MainDir
+	}
+	found, err := loadInRepoConfig(p, inst.Branch, inst.WorktreeDir)
+	if err != nil {
+		log.Printf("warning: could not read grove.yaml for %s: %v", inst.Project, err)
+	}
+	if !found {
+		d.applyScratchDefaults(p)
+	}
+	return p
+}
+
+// inPlaceBranch returns the branch checked out in dir, or "" if there is
+// none (not a git repo, or a detached HEAD).
+func inPlaceBranch(dir string) string {
+	out, err := gitCommand("-C", dir, "symbolic-ref", "--short", "-q", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
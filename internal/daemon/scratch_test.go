@@ -0,0 +1,86 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScratchName(t *testing.T) {
+	for source, name := range map[string]string{
+		"git@github.com:org/app.git":     "scratch:app",
+		"https://github.com/org/app.git": "scratch:app",
+		"https://github.com/org/app/":    "scratch:app",
+		"/home/me/src/tool":              "scratch:tool",
+		"host:app":                       "scratch:app",
+		"/":                              "scratch:repo",
+	} {
+		assert.Equal(t, name, scratchName(source), source)
+	}
+}
+
+func TestScratchProject(t *testing.T) {
+	root := t.TempDir()
+	d := &Daemon{rootDir: root}
+	repo := filepath.Join(t.TempDir(), "app")
+	git(t, "init", "-q", repo)
+	plain := t.TempDir()
+
+	p, info, err := d.scratchProject("3", "git@github.com:org/app.git", false)
+	require.NoError(t, err)
+	assert.Equal(t, "scratch:app", p.Name)
+	assert.Equal(t, "git@github.com:org/app.git", p.Repo)
+	assert.Equal(t, filepath.Join(root, "scratch", "3", "main"), p.MainDir())
+	assert.Equal(t, filepath.Join(root, "scratch", "3"), info.DataDir)
+
+	// A local repo is cloned like a URL, unless in place.
+	p, _, err = d.scratchProject("3", repo, false)
+	require.NoError(t, err)
+	assert.Equal(t, repo, p.Repo)
+	p, info, err = d.scratchProject("3", plain, true)
+	require.NoError(t, err)
+	assert.Empty(t, p.Repo)
+	assert.Equal(t, plain, p.MainDir())
+	assert.True(t, info.InPlace)
+
+	// A leftover data directory is cleared.
+	stale := filepath.Join(root, "scratch", "4", "main")
+	require.NoError(t, os.MkdirAll(stale, 0o755))
+	_, _, err = d.scratchProject("4", repo, false)
+	require.NoError(t, err)
+	assert.NoDirExists(t, stale)
+
+	for _, tc := range []struct {
+		source  string
+		inPlace bool
+		err     string
+	}{
+		{"https://github.com/org/app.git", true, "--in-place needs a local directory"},
+		{"src/app", false, "is not absolute"},
+		{filepath.Join(plain, "missing"), false, "is not a directory"},
+		{plain, false, "is not a git repository; use --in-place"},
+	} {
+		_, _, err := d.scratchProject("5", tc.source, tc.inPlace)
+		require.Error(t, err, tc.source)
+		assert.Contains(t, err.Error(), tc.err)
+	}
+}
+
+func TestApplyScratchDefaults(t *testing.T) {
+	root := t.TempDir()
+	d := &Daemon{rootDir: root}
+	p := &Project{}
+	d.applyScratchDefaults(p)
+	assert.Equal(t, defaultScratchImage, p.Container.Image)
+	assert.Equal(t, "claude", p.Agent.Command)
+
+	require.NoError(t, os.WriteFile(filepath.Join(root, "config.yaml"), []byte("scratch_image: golang:1.23\n"), 0o644))
+	p = &Project{}
+	p.Agent.Command = "aider"
+	d.applyScratchDefaults(p)
+	assert.Equal(t, "golang:1.23", p.Container.Image)
+	assert.Equal(t, "aider", p.Agent.Command)
+}
@@ -0,0 +1,116 @@
+package daemon
+
+// setuplog.go – telling setup output apart from agent output in an
+// instance's log file.
+//
+// The log file holds everything: clone and pull output, container start,
+// start commands and agent install, then the agent's PTY output and any
+// check and finish output.  Start and reopen write their setup output
+// between two marker lines and record its byte range on the instance, so
+// grove logs --setup can show it alone and --agent can leave it out.  The
+// markers appear only in the file: grove logs without a section and the
+// replay on attach come from the in-memory PTY buffer.
+
+import (
+	"bytes"
+	"os"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+const (
+	setupStartMarker = "===grove:setup:start===\n"
+	setupEndMarker   = "===grove:setup:end===\n"
+)
+
+// setupSection is the setup output being written to a log file.
+type setupSection struct {
+	f     *os.File
+	start int64
+	done  bool
+}
+
+// beginSetupSection writes the start marker to f, an instance log opened for
+// appending.  f may be nil, in which case nothing is recorded.
+func beginSetupSection(f *os.File) *setupSection {
+	s := &setupSection{f: f}
+	if f == nil {
+		return s
+	}
+	if _, err := f.WriteString(setupStartMarker); err != nil {
+		s.f = nil
+		return s
+	}
+	s.start = logSize(f)
+	return s
+}
+
+// end writes the end marker and returns the range of the output in between.
+// It is safe to call more than once; later calls and a nil file return a
+// zero range.
+func (s *setupSection) end() proto.LogRange {
+	if s.f == nil || s.done {
+		return proto.LogRange{}
+	}
+	s.done = true
+	r := proto.LogRange{Start: s.start, End: logSize(s.f)}
+	s.f.WriteString(setupEndMarker)
+	return r
+}
+
+// logSize returns the size of f, which for a file opened for appending is
+// where the next write goes.
+func logSize(f *os.File) int64 {
+	fi, err := f.Stat()
+	if err != nil {
+		return 0
+	}
+	return fi.Size()
+}
+
+// addSetupLog records r as setup output on inst.  A zero range (no log
+// file) is not recorded.
+func (inst *Instance) addSetupLog(r proto.LogRange) {
+	if r == (proto.LogRange{}) {
+		return
+	}
+	inst.mu.Lock()
+	inst.setupLog = append(inst.setupLog, r)
+	inst.mu.Unlock()
+}
+
+// logSection returns section (proto.LogSectionSetup or LogSectionAgent) of
+// the log data, whose setup output is at ranges.  Non-empty setup sections
+// are separated by a blank line; the markers around them are left out of
+// both.
+func logSection(data []byte, ranges []proto.LogRange, section string) []byte {
+	clamp := func(i int64) int64 {
+		return max(0, min(i, int64(len(data))))
+	}
+	var out bytes.Buffer
+	if section == proto.LogSectionSetup {
+		for _, r := range ranges {
+			part := data[clamp(r.Start):clamp(max(r.Start, r.End))]
+			if len(part) == 0 {
+				continue // e.g. a reopen whose container was still running
+			}
+			if out.Len() > 0 {
+				out.WriteString("\n")
+			}
+			out.Write(part)
+		}
+		return out.Bytes()
+	}
+
+	var pos int64
+	for _, r := range ranges {
+		from := clamp(r.Start - int64(len(setupStartMarker)))
+		if from < pos {
+			continue // overlapping or out of order: a damaged record
+		}
+		out.Write(data[pos:from])
+		pos = clamp(r.End + int64(len(setupEndMarker)))
+	}
+	out.Write(data[clamp(pos):])
+	return out.Bytes()
+}
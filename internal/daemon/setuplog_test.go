@@ -0,0 +1,54 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetupSection(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "1.log")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	require.NoError(t, err)
+	defer f.Close()
+
+	s := beginSetupSection(f)
+	f.WriteString("cloning\n")
+	r := s.end()
+	assert.Equal(t, proto.LogRange{}, s.end(), "a second end records nothing")
+	f.WriteString("agent says hi\n")
+
+	s = beginSetupSection(f)
+	f.WriteString("recreating container\n")
+	r2 := s.end()
+	f.WriteString("agent again\n")
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, setupStartMarker+"cloning\n"+setupEndMarker+"agent says hi\n"+
+		setupStartMarker+"recreating container\n"+setupEndMarker+"agent again\n", string(data))
+
+	ranges := []proto.LogRange{r, r2}
+	assert.Equal(t, "cloning\n\nrecreating container\n", string(logSection(data, ranges, proto.LogSectionSetup)))
+	assert.Equal(t, "agent says hi\nagent again\n", string(logSection(data, ranges, proto.LogSectionAgent)))
+}
+
+func TestSetupSectionWithoutLog(t *testing.T) {
+	s := beginSetupSection(nil)
+	assert.Equal(t, proto.LogRange{}, s.end())
+
+	inst := &Instance{}
+	inst.addSetupLog(s.end())
+	assert.Empty(t, inst.Info().SetupLog)
+}
+
+func TestLogSectionDamagedRanges(t *testing.T) {
+	data := []byte("short log\n")
+	ranges := []proto.LogRange{{Start: 100, End: 200}}
+	assert.Empty(t, logSection(data, ranges, proto.LogSectionSetup), "ranges past the end are clamped")
+	assert.Equal(t, "short log\n", string(logSection(data, ranges, proto.LogSectionAgent)))
+}
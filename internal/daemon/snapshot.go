@@ -0,0 +1,120 @@
+package daemon
+
+// snapshot.go – the effective config each instance was started with.
+//
+// grove.yaml keeps changing after an instance starts, so the settings it was
+// started with cannot be worked out from the project later.  At start the
+// daemon writes the fully merged config (registration, in-repo grove.yaml
+// with its includes, branch overrides) to <root>/instances/<id>.config.yaml.
+// check, finish, restart and reopen use it unless asked for the current
+// config, and `grove show-config` prints it.
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+	"gopkg.in/yaml.v3"
+)
+
+// configSnapshotPath returns where the config of instance id is recorded.
+func configSnapshotPath(instancesDir, id string) string {
+	return filepath.Join(instancesDir, id+".config.yaml")
+}
+
+// marshalConfig renders p as grove.yaml.  Branch overrides are left out:
+// they have already been merged into p.
+func marshalConfig(p *Project) ([]byte, error) {
+	c := *p
+	c.Overrides = nil
+	return yaml.Marshal(&c)
+}
+
+// writeConfigSnapshot records p as the config inst runs with.  A failure is
+// logged, not returned: the instance works without a snapshot, it just
+// cannot be shown or relaunched from one.
+func (d *Daemon) writeConfigSnapshot(inst *Instance, p *Project) {
+	data, err := marshalConfig(p)
+	if err == nil {
+		err = os.WriteFile(configSnapshotPath(filepath.Join(d.rootDir, "instances"), inst.ID), data, 0o644)
+	}
+	if err != nil {
+		log.Printf("instance %s: could not record config: %v", inst.ID, err)
+	}
+}
+
+// snapshotProject returns the config recorded for inst.  The error satisfies
+// os.IsNotExist for instances started before snapshots were recorded.
+func (d *Daemon) snapshotProject(inst *Instance) (*Project, error) {
+	path := configSnapshotPath(filepath.Join(d.rootDir, "instances"), inst.ID)
+	cached, err := configs.load(path, func(files map[string]fileStamp) (*Project, error) {
+		data, err := readFile(path, files)
+		if err != nil {
+			return nil, err
+		}
+		var p Project
+		if err := yaml.Unmarshal(data, &p); err != nil {
+			return nil, fmt.Errorf("recorded config of instance %s: %w", inst.ID, err)
+		}
+		return &p, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	p := *cached
+	p.DataDir = filepath.Dir(inst.MainDir)
+	if inst.Scratch != nil {
+		p.DataDir = inst.Scratch.DataDir
+		if inst.Scratch.InPlace {
+			p.mainDir = inst.MainDir
+		}
+	}
+	return &p, nil
+}
+
+// relaunchProject returns the config restart and reopen use for inst: the
+// one it was started with, or with current the project's config as it is
+// now, for a new container.
+func (d *Daemon) relaunchProject(inst *Instance, current bool) (*Project, error) {
+	if current {
+		return d.currentProject(inst)
+	}
+	return d.instanceProject(inst, false)
+}
+
+// handleShowConfig returns the config an instance was started with and, for
+// comparison, the project's config as the instance would get it now.
+func (d *Daemon) handleShowConfig(conn net.Conn, req proto.Request) {
+	inst := d.getInstance(req.InstanceID)
+	if inst == nil {
+		respond(conn, proto.Response{OK: false, Error: "instance not found: " + req.InstanceID})
+		return
+	}
+
+	snap, err := d.snapshotProject(inst)
+	if os.IsNotExist(err) {
+		respond(conn, proto.Response{OK: false, Error: fmt.Sprintf(
+			"no config recorded for instance %s: it was started by an older grove", inst.ID)})
+		return
+	}
+	if err != nil {
+		respond(conn, proto.Response{OK: false, Error: err.Error()})
+		return
+	}
+	original, err := marshalConfig(snap)
+	if err != nil {
+		respond(conn, proto.Response{OK: false, Error: err.Error()})
+		return
+	}
+
+	resp := proto.Response{OK: true, InstanceID: inst.ID, Config: string(original)}
+	if cur, err := d.currentProject(inst); err != nil {
+		log.Printf("show-config %s: %v", inst.ID, err)
+	} else if data, err := marshalConfig(cur); err == nil {
+		resp.ProjectConfig = string(data)
+	}
+	respond(conn, resp)
+}
@@ -0,0 +1,114 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestMarshalConfigRoundTrip(t *testing.T) {
+	var p Project
+	require.NoError(t, yaml.Unmarshal([]byte(`
+name: app
+container:
+  image: ruby:3.3
+check:
+  auto: on-ready
+  commands: [go test ./...]
+agent:
+  command: claude
+overrides:
+  release/*:
+    container:
+      image: ruby:3.2
+`), &p))
+
+	data, err := marshalConfig(&p)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "overrides", "overrides are already merged")
+	assert.NotContains(t, string(data), "resume_args", "unset resume_args must stay unset")
+
+	var back Project
+	require.NoError(t, yaml.Unmarshal(data, &back))
+	assert.Equal(t, "ruby:3.3", back.Container.Image)
+	assert.Equal(t, CheckConfig{Commands: commands("go test ./..."), Auto: checkAutoOnReady}, back.Check)
+	assert.Nil(t, back.Agent.ResumeArgs)
+
+	// An explicit empty list (resuming disabled) survives too.
+	p.Agent.ResumeArgs = []string{}
+	data, err = marshalConfig(&p)
+	require.NoError(t, err)
+	back = Project{}
+	require.NoError(t, yaml.Unmarshal(data, &back))
+	assert.NotNil(t, back.Agent.ResumeArgs)
+	assert.Empty(t, back.Agent.ResumeArgs)
+}
+
+func TestRelaunchProject(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "instances"), 0o755))
+	mainDir := filepath.Join(root, "projects", "app", "main")
+	writeRepoFiles(t, mainDir, map[string]string{
+		"grove.yaml": "container:\n  image: alpine:3.19\nagent:\n  command: claude\n",
+	})
+	d := &Daemon{rootDir: root, instances: make(map[string]*Instance)}
+	inst := &Instance{ID: "1", Project: "app", Branch: "main", MainDir: mainDir}
+
+	// Without a snapshot the current config is used.
+	p, err := d.relaunchProject(inst, false)
+	require.NoError(t, err)
+	assert.Equal(t, "alpine:3.19", p.Container.Image)
+
+	d.writeConfigSnapshot(inst, p)
+	writeRepoFiles(t, mainDir, map[string]string{
+		"grove.yaml": "container:\n  image: alpine:3.20\nagent:\n  command: claude\n",
+	})
+
+	p, err = d.relaunchProject(inst, false)
+	require.NoError(t, err)
+	assert.Equal(t, "alpine:3.19", p.Container.Image, "the recorded config is the default")
+	assert.Equal(t, mainDir, p.MainDir())
+
+	p, err = d.relaunchProject(inst, true)
+	require.NoError(t, err)
+	assert.Equal(t, "alpine:3.20", p.Container.Image)
+}
+
+func TestInstanceProjectPrefersSnapshot(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "instances"), 0o755))
+	mainDir := filepath.Join(root, "projects", "app", "main")
+	writeRepoFiles(t, mainDir, map[string]string{
+		"grove.yaml": "check:\n  commands: [make test]\nfinish: [git push]\n",
+	})
+	d := &Daemon{rootDir: root, instances: make(map[string]*Instance)}
+	inst := &Instance{
+		ID: "1", Project: "app", Branch: "main", MainDir: mainDir,
+		Launch: &proto.LaunchConfig{AgentCommand: "aider"},
+	}
+
+	p, err := d.instanceProject(inst, false)
+	require.NoError(t, err)
+	d.writeConfigSnapshot(inst, p)
+	writeRepoFiles(t, mainDir, map[string]string{
+		"grove.yaml": "check:\n  commands: [make lint]\nfinish: [gh pr create]\n",
+	})
+
+	p, err = d.instanceProject(inst, false)
+	require.NoError(t, err)
+	assert.Equal(t, commands("make test"), p.Check.Commands, "the recorded config is the default")
+	assert.Equal(t, commands("git push"), p.Finish)
+	assert.Equal(t, "aider", p.Agent.Command)
+	assert.Equal(t, mainDir, p.MainDir())
+
+	p, err = d.instanceProject(inst, true)
+	require.NoError(t, err)
+	assert.Equal(t, commands("make lint"), p.Check.Commands)
+	assert.Equal(t, commands("gh pr create"), p.Finish)
+	assert.Equal(t, "aider", p.Agent.Command, "the launch settings still apply")
+}
@@ -0,0 +1,191 @@
+package daemon
+
+// stall.go – catching an agent stuck in a loop.
+//
+// An agent can spin while its instance shows RUNNING: a tool it runs fails
+// the same way over and over, or it crashes right after every restart (by
+// hand, or by a script restarting it on each CRASHED event).  The daemon
+// watches for both:
+//
+//   - the same error line stallRepeats times within stallRepeatWindow of
+//     output, with nothing else happening in between, after which the
+//     agent is killed;
+//   - a crash when the agent has been launched stallCrashRuns times within
+//     stallCrashWindow.
+//
+// Either way the instance ends STALLED rather than KILLED or CRASHED, with
+// the repeated line in a note and in an EventStalled.  Scripts that restart
+// CRASHED instances leave it alone; grove restart relaunches it.
+//
+// Agents such as claude redraw their interface in place, so one error line
+// left on the screen arrives from the PTY again with every frame.  The
+// detector therefore plays the output on a termtext.Screen and counts a
+// line when the cursor has moved below it showing something new.
+//
+// An error line that repeats is only a loop while nothing else happens:
+// the lines between two repeats must be those between the first two (the
+// rest of the loop's turn, such as the command retried), and a relaunch
+// starts afresh.  A test run failing many assertions prints the same error
+// line many times, but with other lines between them that differ.
+
+import (
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gandalfthegui/grove/internal/termtext"
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+const (
+	stallRepeats      = 20
+	stallRepeatWindow = 2 * time.Minute
+	stallCrashRuns    = 3
+	stallCrashWindow  = 5 * time.Minute
+
+	// maxLoopTurn bounds the lines between two repeats of an error line
+	// that loopDetector still takes for one turn of a loop.
+	maxLoopTurn = 50
+	// loopScreenLines is how many lines above the cursor loopDetector's
+	// screen keeps for an agent to redraw.
+	loopScreenLines = 200
+)
+
+var (
+	// errorLinePattern picks out the lines loopDetector counts.
+	errorLinePattern = regexp.MustCompile(`(?i)\b(error|fatal|panic|exception|traceback|segmentation fault)\b`)
+	// digitsPattern matches numbers (times, PIDs, ports), which change from
+	// one repetition of a line to the next.
+	digitsPattern = regexp.MustCompile(`[0-9]+`)
+)
+
+// loopDetector counts repeated error lines in an agent's output.
+type loopDetector struct {
+	screen termtext.Screen
+	shown  []string // what the settled lines showed when last counted
+	top    int      // the number of the line shown[0] is
+
+	sig   string    // the signature of the error line followed; empty if none
+	first time.Time // when it was first seen in this run of repeats
+	n     int       // how often it has been seen since
+	turn  []string  // the signatures of the lines between its first two repeats
+	since []string  // and of those since its last one
+	last  string    // the most recent error line, as shown
+}
+
+// feed plays output on the screen and counts the lines it settles.  It
+// returns the line that has now repeated stallRepeats times within
+// stallRepeatWindow, if any.
+func (l *loopDetector) feed(output []byte, now time.Time) (string, bool) {
+	l.screen.Write(output)
+	if !l.screen.Changed() {
+		// Nothing has settled, as while a spinner turns.
+		return "", false
+	}
+	first, lines := l.screen.Settled()
+	l.shown = l.shown[min(first-l.top, len(l.shown)):]
+	l.top = first
+	excerpt, looping := "", false
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || i < len(l.shown) && l.shown[i] == line {
+			// Erased, or redrawn as it was.
+			continue
+		}
+		for len(l.shown) <= i {
+			l.shown = append(l.shown, "")
+		}
+		l.shown[i] = line
+		if excerpt, looping = l.line(line, now); looping {
+			break
+		}
+	}
+	l.screen.Trim(loopScreenLines)
+	return excerpt, looping
+}
+
+// line counts one line of output, as the screen shows it.
+func (l *loopDetector) line(line string, now time.Time) (string, bool) {
+	sig := digitsPattern.ReplaceAllString(line, "#")
+	isError := errorLinePattern.MatchString(line)
+	if isError {
+		l.last = line
+	}
+	switch {
+	case l.sig != "" && sig == l.sig && now.Sub(l.first) <= stallRepeatWindow:
+		if l.n == 1 {
+			l.turn = l.since
+		} else if !slices.Equal(l.since, l.turn) {
+			// Something else happened since the last repeat.
+			l.follow(sig, now)
+			return "", false
+		}
+		l.n++
+		l.since = nil
+		return line, l.n >= stallRepeats
+	case l.sig != "" && sig != l.sig && len(l.since) < maxLoopTurn &&
+		(l.n == 1 || (len(l.since) < len(l.turn) && l.turn[len(l.since)] == sig)):
+		// The rest of a turn of the loop.
+		l.since = append(l.since, sig)
+	case isError:
+		l.follow(sig, now)
+	default:
+		l.sig = ""
+	}
+	return "", false
+}
+
+// follow starts counting the repeats of the error line with signature sig.
+func (l *loopDetector) follow(sig string, now time.Time) {
+	l.sig, l.first, l.n, l.turn, l.since = sig, now, 1, nil, nil
+}
+
+// crashLooping reports whether runs, the instance's launches, include
+// stallCrashRuns within stallCrashWindow of now.
+func crashLooping(runs []proto.AgentRun, now time.Time) bool {
+	n := 0
+	for _, r := range runs {
+		if now.Sub(time.Unix(r.Time, 0)) <= stallCrashWindow {
+			n++
+		}
+	}
+	return n >= stallCrashRuns
+}
+
+// stall kills an agent whose output loops on excerpt; ptyReader then ends
+// the instance STALLED.
+func (inst *Instance) stall(excerpt string) {
+	inst.mu.Lock()
+	if inst.ptm == nil || inst.stalled != "" {
+		inst.mu.Unlock()
+		return
+	}
+	inst.stalled = excerpt
+	inst.mu.Unlock()
+	log.Printf("instance %s: agent is looping on %q; stopping it", inst.ID, excerpt)
+	inst.destroy()
+}
+
+// reportStall records and publishes that inst ended STALLED on excerpt.
+func (inst *Instance) reportStall(excerpt string) {
+	inst.mu.Lock()
+	inst.notes = append(inst.notes, proto.Note{Time: time.Now().Unix(), Text: "stalled: " + excerpt})
+	emit := inst.emit
+	inst.mu.Unlock()
+	if emit == nil {
+		return
+	}
+	info := inst.Info()
+	emit(proto.Event{
+		Time:       time.Now().Unix(),
+		Type:       proto.EventStalled,
+		InstanceID: info.ID,
+		Project:    info.Project,
+		Branch:     info.Branch,
+		BaseSHA:    info.BaseSHA,
+		State:      info.State,
+		Summary:    excerpt,
+	})
+}
@@ -0,0 +1,137 @@
+package daemon
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+func TestLoopDetector(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	var l loopDetector
+
+	// Ordinary output never counts, however often it repeats.
+	for i := 0; i < 2*stallRepeats; i++ {
+		_, stalled := l.feed([]byte("compiling...\n"), now)
+		assert.False(t, stalled)
+	}
+
+	// The same error with a changing number and colour, split across reads.
+	for i := 1; i < stallRepeats; i++ {
+		_, stalled := l.feed([]byte("\x1b[31mError: connect ECONNREFUSED 127.0.0.1:5432 (attempt "), now)
+		assert.False(t, stalled)
+		_, stalled = l.feed([]byte(fmt.Sprintf("%d)\x1b[0m\r\n", i)), now)
+		assert.False(t, stalled, "repeat %d", i)
+	}
+	excerpt, stalled := l.feed([]byte("Error: connect ECONNREFUSED 127.0.0.1:5432 (attempt 20)\n"), now)
+	assert.True(t, stalled)
+	assert.Equal(t, "Error: connect ECONNREFUSED 127.0.0.1:5432 (attempt 20)", excerpt)
+}
+
+func TestLoopDetectorWindow(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	var l loopDetector
+	// Errors spread out further than stallRepeatWindow are not a loop.
+	for i := 0; i < 3*stallRepeats; i++ {
+		_, stalled := l.feed([]byte("panic: boom\n"), now.Add(time.Duration(i)*stallRepeatWindow/(stallRepeats-2)))
+		assert.False(t, stalled, "line %d", i)
+	}
+	assert.Equal(t, "panic: boom", l.last)
+}
+
+func TestLoopDetectorTurn(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	var l loopDetector
+	// A command retried between the errors is part of the loop.
+	for i := 1; i < stallRepeats; i++ {
+		_, stalled := l.feed([]byte(fmt.Sprintf("$ npm test\nError: Cannot find module 'jest' (try %d)\n", i)), now)
+		assert.False(t, stalled, "repeat %d", i)
+	}
+	_, stalled := l.feed([]byte("$ npm test\nError: Cannot find module 'jest' (try 20)\n"), now)
+	assert.True(t, stalled)
+
+	// Anything else between the errors starts the count again.
+	l = loopDetector{}
+	for i := 0; i < 3*stallRepeats; i++ {
+		out := "Error: connect ECONNREFUSED 127.0.0.1:5432\n"
+		if i%10 == 9 {
+			out = "retrying with a longer timeout\n" + out
+		}
+		_, stalled := l.feed([]byte(out), now)
+		assert.False(t, stalled, "line %d", i)
+	}
+}
+
+// TestLoopDetectorRedraws replays claude redrawing a failed tool call, error
+// line and all, under a spinner: the error is on the screen once.
+func TestLoopDetectorRedraws(t *testing.T) {
+	raw, err := os.ReadFile(filepath.Join("..", "termtext", "testdata", "claude-error.raw"))
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, bytes.Count(raw, []byte("Error: exit status 1")), stallRepeats)
+
+	now := time.Unix(1_700_000_000, 0)
+	var l loopDetector
+	for len(raw) > 0 {
+		n := min(len(raw), 100)
+		_, stalled := l.feed(raw[:n], now)
+		require.False(t, stalled)
+		raw = raw[n:]
+	}
+	assert.Equal(t, "⎿  Error: exit status 1", l.last)
+}
+
+// TestLoopDetectorTestify feeds a test run failing the same assertion in
+// many tests, which repeats error lines with different lines between them.
+func TestLoopDetectorTestify(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	var l loopDetector
+	for i := 0; i < 2*stallRepeats; i++ {
+		out := fmt.Sprintf(`--- FAIL: TestParse%c (0.00s)
+    parse_test.go:%d:
+        	Error Trace:	parse_test.go:%d
+        	Error:      	Not equal:
+        	            	expected: "UTC"
+        	            	actual  : "Local"
+        	Test:       	TestParse%c
+`, 'A'+i, 10*i, 10*i, 'A'+i)
+		_, stalled := l.feed([]byte(out), now)
+		assert.False(t, stalled, "test %d", i)
+	}
+	assert.Regexp(t, regexp.MustCompile(`^Error:`), l.last)
+}
+
+// BenchmarkLoopDetectorFeed feeds claude redrawing its interface, in the
+// pieces a PTY read might return.
+func BenchmarkLoopDetectorFeed(b *testing.B) {
+	raw, err := os.ReadFile(filepath.Join("..", "termtext", "testdata", "claude-error.raw"))
+	require.NoError(b, err)
+	now := time.Unix(1_700_000_000, 0)
+	b.SetBytes(int64(len(raw)))
+	for i := 0; i < b.N; i++ {
+		var l loopDetector
+		for data := raw; len(data) > 0; {
+			n := min(len(data), 100)
+			l.feed(data[:n], now)
+			data = data[n:]
+		}
+	}
+}
+
+func TestCrashLooping(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	run := func(ago time.Duration) proto.AgentRun {
+		return proto.AgentRun{Time: now.Add(-ago).Unix()}
+	}
+	assert.False(t, crashLooping(nil, now))
+	assert.False(t, crashLooping([]proto.AgentRun{run(time.Hour), run(time.Minute), run(0)}, now))
+	assert.True(t, crashLooping([]proto.AgentRun{run(time.Hour), run(2 * time.Minute), run(time.Minute), run(0)}, now))
+}
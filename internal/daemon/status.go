@@ -0,0 +1,150 @@
+package daemon
+
+// status.go – the agent status file: a file-based contract that lets the
+// agent tell grove it believes its task is complete.
+//
+// The agent writes <worktree>/.grove/status.json:
+//
+//	{"status": "done", "summary": "Added retry logic; tests pass"}
+//
+// The daemon polls the file while the agent runs.  "done" moves a RUNNING (or
+// WAITING) instance to READY, records the summary, and publishes an EventReady.
+// "working" moves a READY instance back to RUNNING.
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+const (
+	// agentStatusFile is the status file path relative to the worktree root.
+	agentStatusFile = ".grove/status.json"
+
+	// statusPollInterval is how often the daemon checks the status file.
+	statusPollInterval = time.Second
+
+	agentStatusDone    = "done"
+	agentStatusWorking = "working"
+)
+
+// agentStatus is the JSON document an agent writes to agentStatusFile.
+type agentStatus struct {
+	Status  string `json:"status"`
+	Summary string `json:"summary"`
+}
+
+// watchAgentStatus polls the status file until done is closed, applying each
+// new version.  A file left over from an earlier session is ignored until it
+// is rewritten.
+func (inst *Instance) watchAgentStatus(done <-chan struct{}) {
+	path := filepath.Join(inst.WorktreeDir, agentStatusFile)
+	last := statusModTime(path)
+
+	ticker := time.NewTicker(statusPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		mt := statusModTime(path)
+		if mt.IsZero() || mt.Equal(last) {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var st agentStatus
+		if err := json.Unmarshal(data, &st); err != nil {
+			// Possibly caught mid-write; try again on the next tick.
+			log.Printf("instance %s: ignoring malformed %s: %v", inst.ID, agentStatusFile, err)
+			continue
+		}
+		if inst.applyAgentStatus(st) {
+			last = mt
+		}
+	}
+}
+
+// applyAgentStatus applies one status report.  It returns false if the report
+// should be retried later: a "done" that arrives while a client is attached or
+// checks are running takes effect once the instance is back to RUNNING.
+func (inst *Instance) applyAgentStatus(st agentStatus) bool {
+	inst.mu.Lock()
+	switch st.Status {
+	case agentStatusDone:
+		switch inst.state {
+		case proto.StateAttached, proto.StateChecking:
+			inst.mu.Unlock()
+			return false
+		case proto.StateRunning, proto.StateWaiting:
+		default:
+			inst.mu.Unlock()
+			return true
+		}
+		inst.setState(proto.StateReady)
+		inst.readyAt = time.Now()
+		inst.summary = strings.TrimSpace(st.Summary)
+		if inst.summary != "" {
+			inst.notes = append(inst.notes, proto.Note{Time: time.Now().Unix(), Text: "ready: " + inst.summary})
+		}
+		emit := inst.emit
+		instancesDir := inst.InstancesDir
+		inst.mu.Unlock()
+
+		log.Printf("instance %s: agent reported done", inst.ID)
+		if instancesDir != "" {
+			inst.persistMeta(instancesDir)
+		}
+		if emit != nil {
+			info := inst.Info()
+			emit(proto.Event{
+				Time:       time.Now().Unix(),
+				Type:       proto.EventReady,
+				InstanceID: info.ID,
+				Project:    info.Project,
+				Branch:     info.Branch,
+				BaseSHA:    info.BaseSHA,
+				State:      info.State,
+				Summary:    info.Summary,
+				Changes:    inst.changeSummary(time.Now()),
+			})
+		}
+
+	case agentStatusWorking:
+		if inst.state == proto.StateReady {
+			inst.setState(proto.StateRunning)
+		}
+		inst.mu.Unlock()
+
+	default:
+		inst.mu.Unlock()
+		log.Printf("instance %s: unknown status %q in %s", inst.ID, st.Status, agentStatusFile)
+	}
+	return true
+}
+
+// statusModTime returns the status file's modification time, or the zero
+// time if it does not exist.
+func statusModTime(path string) time.Time {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
+
+// excludeAgentStatusFile adds the status file to the repository's
+// info/exclude (shared by all its worktrees) so agents don't commit it.
+func excludeAgentStatusFile(mainDir string) error {
+	return excludeGeneratedFile(mainDir, agentStatusFile)
+}
@@ -0,0 +1,85 @@
+package daemon
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyAgentStatusDone(t *testing.T) {
+	var events []proto.Event
+	inst := &Instance{
+		ID:      "1",
+		Project: "my-app",
+		Branch:  "feat/x",
+		state:   proto.StateRunning,
+		emit:    func(ev proto.Event) { events = append(events, ev) },
+	}
+
+	assert.True(t, inst.applyAgentStatus(agentStatus{Status: "done", Summary: " added retries "}))
+
+	info := inst.Info()
+	assert.Equal(t, proto.StateReady, info.State)
+	assert.Equal(t, "added retries", info.Summary)
+	require.Len(t, info.Notes, 1)
+	assert.Equal(t, "ready: added retries", info.Notes[0].Text)
+	require.Len(t, events, 1)
+	assert.Equal(t, proto.EventReady, events[0].Type)
+	assert.Equal(t, "1", events[0].InstanceID)
+	assert.Equal(t, proto.StateReady, events[0].State)
+	assert.Equal(t, "added retries", events[0].Summary)
+
+	// "working" returns the instance to RUNNING; the summary is kept.
+	assert.True(t, inst.applyAgentStatus(agentStatus{Status: "working"}))
+	assert.Equal(t, proto.StateRunning, inst.state)
+	assert.Equal(t, "added retries", inst.Info().Summary)
+}
+
+func TestApplyAgentStatusDeferredWhileAttached(t *testing.T) {
+	inst := &Instance{ID: "1", state: proto.StateAttached}
+	assert.False(t, inst.applyAgentStatus(agentStatus{Status: "done"}), "retry once detached")
+	assert.Equal(t, proto.StateAttached, inst.state)
+
+	inst.state = proto.StateRunning
+	assert.True(t, inst.applyAgentStatus(agentStatus{Status: "done"}))
+	assert.Equal(t, proto.StateReady, inst.state)
+	assert.Empty(t, inst.notes, "no note without a summary")
+}
+
+func TestApplyAgentStatusIgnoredWhenStopped(t *testing.T) {
+	inst := &Instance{ID: "1", state: proto.StateExited}
+	assert.True(t, inst.applyAgentStatus(agentStatus{Status: "done"}))
+	assert.Equal(t, proto.StateExited, inst.state)
+}
+
+func TestEventBusDropsForSlowSubscriber(t *testing.T) {
+	var b eventBus
+	ch := b.subscribe()
+	for i := 0; i < eventBufferSize+10; i++ {
+		b.publish(proto.Event{Type: proto.EventReady}) // must not block
+	}
+	assert.Len(t, ch, eventBufferSize)
+
+	b.unsubscribe(ch)
+	b.publish(proto.Event{})
+	assert.Len(t, ch, eventBufferSize)
+}
+
+func TestExcludeAgentStatusFile(t *testing.T) {
+	dir := t.TempDir()
+	out, err := exec.Command("git", "init", dir).CombinedOutput()
+	require.NoError(t, err, "%s", out)
+
+	require.NoError(t, excludeAgentStatusFile(dir))
+	require.NoError(t, excludeAgentStatusFile(dir), "second call must be a no-op")
+
+	data, err := os.ReadFile(filepath.Join(dir, ".git", "info", "exclude"))
+	require.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(data), "/"+agentStatusFile+"\n"))
+}
@@ -0,0 +1,101 @@
+package daemon
+
+// stdin.go – feeding a client's keyboard to tty: commands.
+//
+// A check or finish command marked tty: true runs under docker exec -t.  When
+// the client that asked for the run is interactive, it sends its stdin after
+// the response as attach data frames, and the daemon passes them to whichever
+// tty: command is running, so a finish step can ask a one-off question.
+// Input that arrives while no such command runs is dropped.
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+// clientStdin forwards an interactive client's input to the command
+// attached to it, one command at a time.
+type clientStdin struct {
+	mu     sync.Mutex
+	turn   sync.Mutex // held by the attached command
+	target io.WriteCloser
+	closed bool // the client sent detach or went away
+}
+
+// newClientStdin starts reading attach frames from r (the client
+// connection) until it is closed.
+func newClientStdin(r io.Reader) *clientStdin {
+	s := &clientStdin{}
+	go s.readFrames(r)
+	return s
+}
+
+func (s *clientStdin) readFrames(r io.Reader) {
+	defer s.close()
+	for {
+		frameType, payload, err := proto.ReadFrame(r)
+		if err != nil {
+			return
+		}
+		switch frameType {
+		case proto.AttachFrameData:
+			s.mu.Lock()
+			if s.target != nil {
+				if _, err := s.target.Write(payload); err != nil {
+					s.target = nil
+				}
+			}
+			s.mu.Unlock()
+		case proto.AttachFrameDetach:
+			return
+		}
+	}
+}
+
+// close ends input: the attached command sees EOF, as do later ones.
+func (s *clientStdin) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.target != nil {
+		s.target.Close()
+		s.target = nil
+	}
+}
+
+// attach makes w receive the client's input until the returned function is
+// called, waiting for any other command to finish with it first.  If the
+// client's input has already ended w is closed straight away.
+func (s *clientStdin) attach(w io.WriteCloser) (detach func()) {
+	s.turn.Lock()
+	s.mu.Lock()
+	if s.closed {
+		w.Close()
+	} else {
+		s.target = w
+	}
+	s.mu.Unlock()
+	return func() {
+		s.mu.Lock()
+		if s.target == w {
+			s.target = nil
+		}
+		s.mu.Unlock()
+		s.turn.Unlock()
+	}
+}
+
+// requireTerminal returns an error naming the first of cmds that needs a
+// terminal, for requests from clients that cannot provide one.
+func requireTerminal(verb string, cmds []CommandSpec) error {
+	for _, c := range cmds {
+		if c.needsTerminal() {
+			return fmt.Errorf("%s command %q needs a terminal (tty: true); run grove %s from an interactive terminal",
+				verb, c.label(), verb)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingCloser collects what is written to it and whether it was closed.
+type recordingCloser struct {
+	bytes.Buffer
+	closed chan struct{}
+}
+
+func newRecordingCloser() *recordingCloser {
+	return &recordingCloser{closed: make(chan struct{})}
+}
+
+func (r *recordingCloser) Close() error {
+	close(r.closed)
+	return nil
+}
+
+func TestClientStdin(t *testing.T) {
+	pr, pw := io.Pipe()
+	s := newClientStdin(pr)
+
+	// Nothing is attached: the input is dropped.
+	require.NoError(t, proto.WriteFrame(pw, proto.AttachFrameData, []byte("lost")))
+	// Once the next frame has been read, the first has been handled.
+	require.NoError(t, proto.WriteFrame(pw, proto.AttachFrameData, nil))
+
+	w := newRecordingCloser()
+	detach := s.attach(w)
+	require.NoError(t, proto.WriteFrame(pw, proto.AttachFrameData, []byte("y\n")))
+	require.NoError(t, proto.WriteFrame(pw, proto.AttachFrameDetach, nil))
+	select {
+	case <-w.closed:
+	case <-time.After(time.Second):
+		t.Fatal("detach frame did not close the command's stdin")
+	}
+	detach()
+	assert.Equal(t, "y\n", w.String())
+
+	// Input has ended, so a later command gets EOF straight away.
+	later := newRecordingCloser()
+	s.attach(later)()
+	select {
+	case <-later.closed:
+	default:
+		t.Fatal("stdin attached after the client's input ended was not closed")
+	}
+}
+
+func TestRequireTerminal(t *testing.T) {
+	assert.NoError(t, requireTerminal("finish", commands("git push")))
+	err := requireTerminal("finish", []CommandSpec{{Run: "git push"}, {Run: "gh pr create", Name: "pr", TTY: true}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `finish command "pr" needs a terminal`)
+	assert.Contains(t, err.Error(), "run grove finish from an interactive terminal")
+	assert.NoError(t, requireTerminal("finish", []CommandSpec{{Run: "say done", TTY: true, Host: true}}), "host commands get no terminal")
+}
+
+func TestExecInContainerTTY(t *testing.T) {
+	argsFile := filepath.Join(installFakeDocker(t, "echo \"$@\" > \"$(dirname \"$0\")/args\"\ncat\n"), "args")
+
+	// Without a client's input the command gets a TTY but no stdin.
+	var out bytes.Buffer
+	require.NoError(t, execInContainer(context.Background(), "c1", execOptions{TTY: true}, "true", &out))
+	args, err := os.ReadFile(argsFile)
+	require.NoError(t, err)
+	assert.Equal(t, "exec -t c1 sh -c true\n", string(args))
+
+	pr, pw := io.Pipe()
+	stdin := newClientStdin(pr)
+	go func() {
+		// Sent once the command has started and attached.
+		time.Sleep(50 * time.Millisecond)
+		proto.WriteFrame(pw, proto.AttachFrameData, []byte("yes\n"))
+		proto.WriteFrame(pw, proto.AttachFrameDetach, nil)
+	}()
+	out.Reset()
+	opts := execOptions{Workdir: "/app/sub", TTY: true, Stdin: stdin}
+	require.NoError(t, execInContainer(context.Background(), "c1", opts, "read answer", &out))
+	assert.Equal(t, "yes\n", out.String())
+	args, err = os.ReadFile(argsFile)
+	require.NoError(t, err)
+	assert.Equal(t, "exec -i -t -w /app/sub c1 sh -c read answer\n", string(args))
+}
@@ -0,0 +1,186 @@
+package daemon
+
+// teardown.go – removing an instance's container or worktree on its own.
+//
+// grove drop removes an instance's container, worktree, branch and record
+// together.  grove container rm and grove worktree rm take away one part of
+// a stopped instance and keep the rest, e.g. a worktree with work in it
+// whose container is no longer needed.  The instance remembers what was
+// removed, which list and status show, and restart and reopen create it
+// again: a container from the instance's config, a worktree from its
+// branch.
+//
+// A container's bind mount holds on to the worktree directory it was
+// created with, and does not see a new directory at the same path, so a
+// worktree that is created again needs a new container too.
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"path/filepath"
+	"time"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+// Operation names of grove container rm and grove worktree rm.
+const (
+	opContainerRm = "container rm"
+	opWorktreeRm  = "worktree rm"
+)
+
+// handleContainerRm removes a stopped instance's container, or its compose
+// stack, keeping its worktree and record.
+func (d *Daemon) handleContainerRm(conn net.Conn, req proto.Request) {
+	inst, endOp, ok := d.beginTeardown(conn, req, opContainerRm)
+	if !ok {
+		return
+	}
+	defer endOp()
+
+	inst.mu.Lock()
+	removed := inst.containerRemoved
+	inst.mu.Unlock()
+	if removed {
+		respond(conn, proto.Response{OK: false, Error: "instance " + inst.ID + " has no container: it was removed"})
+		return
+	}
+
+	inst.destroyHelpers()
+	inst.destroyAux()
+	stopContainer(inst.ContainerID, inst.ComposeProject)
+
+	inst.mu.Lock()
+	inst.containerRemoved = true
+	inst.stackStopped = false
+	inst.notes = append(inst.notes, proto.Note{Time: time.Now().Unix(), Text: "container removed"})
+	inst.mu.Unlock()
+	inst.persistMeta(filepath.Join(d.rootDir, "instances"))
+	log.Printf("instance %s: removed container %s", inst.ID, inst.ContainerID)
+	respond(conn, proto.Response{OK: true, InstanceID: inst.ID})
+}
+
+// handleWorktreeRm removes a stopped instance's worktree, keeping its
+// branch, container and record.
+func (d *Daemon) handleWorktreeRm(conn net.Conn, req proto.Request) {
+	inst, endOp, ok := d.beginTeardown(conn, req, opWorktreeRm)
+	if !ok {
+		return
+	}
+	defer endOp()
+
+	inst.mu.Lock()
+	removed := inst.worktreeRemoved
+	inst.mu.Unlock()
+	switch {
+	case removed:
+		respond(conn, proto.Response{OK: false, Error: "instance " + inst.ID + " has no worktree: it was removed"})
+		return
+	case inst.Scratch != nil && inst.Scratch.InPlace:
+		respond(conn, proto.Response{OK: false, Error: "instance " + inst.ID + " works in " + inst.WorktreeDir + " in place; grove does not remove it"})
+		return
+	}
+
+	unlock, err := d.projectLocks.lock(inst.Project, "worktree rm "+inst.ID, projectLockTimeout, io.Discard)
+	if err != nil {
+		respond(conn, proto.Response{OK: false, Error: err.Error()})
+		return
+	}
+	err = removeWorktreeDir(inst.MainDir, inst.WorktreeDir, "instance "+inst.ID)
+	unlock()
+	if err != nil {
+		respond(conn, proto.Response{OK: false, Error: err.Error()})
+		return
+	}
+
+	inst.mu.Lock()
+	inst.worktreeRemoved = true
+	inst.notes = append(inst.notes, proto.Note{Time: time.Now().Unix(), Text: "worktree removed; branch " + inst.Branch + " kept"})
+	inst.mu.Unlock()
+	inst.persistMeta(filepath.Join(d.rootDir, "instances"))
+	log.Printf("instance %s: removed worktree %s", inst.ID, inst.WorktreeDir)
+	respond(conn, proto.Response{OK: true, InstanceID: inst.ID, WorktreeDir: inst.WorktreeDir, Branch: inst.Branch})
+}
+
+// beginTeardown looks up the instance of a container rm or worktree rm
+// request and claims it for op.  It refuses, replying on conn, an instance
+// whose agent is live: the agent works in both.
+func (d *Daemon) beginTeardown(conn net.Conn, req proto.Request, op string) (*Instance, func(), bool) {
+	inst := d.getInstance(req.InstanceID)
+	if inst == nil {
+		respond(conn, proto.Response{OK: false, Error: "instance not found: " + req.InstanceID})
+		return nil, nil, false
+	}
+	endOp, err := inst.beginOperation(op)
+	if err != nil {
+		respond(conn, errorResponse(err))
+		return nil, nil, false
+	}
+	inst.mu.Lock()
+	state := inst.state
+	inst.mu.Unlock()
+	if !proto.IsTerminal(state) {
+		endOp()
+		respond(conn, proto.Response{OK: false, Error: "cannot " + op + ": instance is " + state,
+			Hint: "Stop the agent first: grove stop " + inst.ID})
+		return nil, nil, false
+	}
+	return inst, endOp, true
+}
+
+// teardownRemoved reports what of inst grove container rm and grove
+// worktree rm have removed.
+func (inst *Instance) teardownRemoved() (container, worktree bool) {
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+	return inst.containerRemoved, inst.worktreeRemoved
+}
+
+// removedError refuses op on inst if it needs the container or the worktree
+// and that was removed, or returns nil.
+func (inst *Instance) removedError(op string, needContainer, needWorktree bool) *proto.Response {
+	container, worktree := inst.teardownRemoved()
+	switch {
+	case needContainer && container:
+		return &proto.Response{OK: false, Error: "cannot " + op + ": the container of instance " + inst.ID + " was removed",
+			Hint: "grove restart " + inst.ID + " --recreate-container creates it again."}
+	case needWorktree && worktree:
+		return &proto.Response{OK: false, Error: "cannot " + op + ": the worktree of instance " + inst.ID + " was removed",
+			Hint: "grove restart " + inst.ID + " --recreate-container checks out branch " + inst.Branch + " again, in a new container."}
+	}
+	return nil
+}
+
+// restoreWorktree checks inst's branch out again at its worktree path if
+// grove worktree rm removed it, writing git's output to w.  It reports
+// whether it did, in which case the container needs recreating too.
+func (d *Daemon) restoreWorktree(inst *Instance, w io.Writer) (bool, error) {
+	if _, worktree := inst.teardownRemoved(); !worktree {
+		return false, nil
+	}
+	unlock, err := d.projectLocks.lock(inst.Project, "restore worktree "+inst.ID, projectLockTimeout, w)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+	if gitCommand("-C", inst.MainDir, "rev-parse", "--verify", "--quiet", "refs/heads/"+inst.Branch).Run() != nil {
+		return false, fmt.Errorf("branch %s no longer exists in %s; start a new instance instead: grove start %s %s",
+			inst.Branch, inst.MainDir, inst.Project, inst.Branch)
+	}
+	fmt.Fprintf(w, "Checking out %s again in %s …\n", inst.Branch, inst.WorktreeDir)
+	cmd := gitCommand("-C", inst.MainDir, "worktree", "add", inst.WorktreeDir, inst.Branch)
+	cmd.Stdout = w
+	cmd.Stderr = w
+	if err := cmd.Run(); err != nil {
+		return false, fmt.Errorf("git worktree add: %w", err)
+	}
+
+	inst.mu.Lock()
+	inst.worktreeRemoved = false
+	inst.mu.Unlock()
+	inst.persistMeta(filepath.Join(d.rootDir, "instances"))
+	log.Printf("instance %s: restored worktree %s", inst.ID, inst.WorktreeDir)
+	return true, nil
+}
@@ -0,0 +1,179 @@
+package daemon
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingDocker installs a docker that only appends its arguments, one
+// call per line, to the file it returns.
+func recordingDocker(t *testing.T) string {
+	t.Helper()
+	return filepath.Join(installFakeDocker(t, "echo \"$@\" >> \"$(dirname \"$0\")/calls\"\n"), "calls")
+}
+
+// teardownTest returns a daemon with an instance 1 of project app on
+// branch feat, with a worktree of its own, in state.  The main checkout and
+// worktree are where the daemon keeps them, under projects/app; the project
+// is not registered.
+func teardownTest(t *testing.T, state string) (*Daemon, *Instance) {
+	t.Helper()
+	root := t.TempDir()
+	data := filepath.Join(root, "projects", "app")
+	main := filepath.Join(data, "main")
+	git(t, "init", "-q", "-b", "main", main)
+	git(t, "-C", main, "-c", "user.name=t", "-c", "user.email=t@t", "commit", "-q", "--allow-empty", "-m", "init")
+	worktree := filepath.Join(data, "worktrees", "feat-1")
+	git(t, "-C", main, "worktree", "add", "-q", "-b", "feat", worktree)
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "instances"), 0o755))
+
+	inst := &Instance{ID: "1", Project: "app", Branch: "feat", MainDir: main, WorktreeDir: worktree, ContainerID: "c1", state: state}
+	return &Daemon{rootDir: root, instances: map[string]*Instance{"1": inst}}, inst
+}
+
+// serveRequest runs handle with req on one end of a pipe, closing it once
+// handle returns, and returns the other end and a channel closed then.
+func serveRequest(handle func(net.Conn, proto.Request), req proto.Request) (net.Conn, <-chan struct{}) {
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		handle(server, req)
+		server.Close()
+		close(done)
+	}()
+	return client, done
+}
+
+// teardownRequest sends req to handle and returns the response once the
+// handler has returned.
+func teardownRequest(t *testing.T, handle func(net.Conn, proto.Request), req proto.Request) proto.Response {
+	t.Helper()
+	client, done := serveRequest(handle, req)
+	var resp proto.Response
+	require.NoError(t, json.NewDecoder(client).Decode(&resp))
+	client.Close()
+	<-done
+	return resp
+}
+
+func TestWorktreeRm(t *testing.T) {
+	d, inst := teardownTest(t, proto.StateRunning)
+	rm := proto.Request{Type: proto.ReqWorktreeRm, InstanceID: "1"}
+
+	resp := teardownRequest(t, d.handleWorktreeRm, rm)
+	assert.False(t, resp.OK)
+	assert.Equal(t, "cannot worktree rm: instance is RUNNING", resp.Error)
+	assert.DirExists(t, inst.WorktreeDir)
+
+	inst.state = proto.StateFinished
+	resp = teardownRequest(t, d.handleWorktreeRm, rm)
+	require.True(t, resp.OK, resp.Error)
+	assert.NoDirExists(t, inst.WorktreeDir)
+	assert.Equal(t, "feat", git(t, "-C", inst.MainDir, "branch", "--list", "feat", "--format=%(refname:short)"), "the branch is kept")
+	assert.True(t, inst.Info().WorktreeRemoved)
+	assert.Nil(t, inst.changeSummary(inst.CreatedAt), "no worktree to summarise")
+
+	resp = teardownRequest(t, d.handleWorktreeRm, rm)
+	assert.Equal(t, "instance 1 has no worktree: it was removed", resp.Error)
+	resp = *inst.removedError("check", true, true)
+	assert.Equal(t, "cannot check: the worktree of instance 1 was removed", resp.Error)
+	assert.Contains(t, resp.Hint, "grove restart 1 --recreate-container")
+	assert.Nil(t, inst.removedError("run a command", true, false))
+
+	wts, err := d.projectWorktrees("app", inst.MainDir)
+	require.NoError(t, err)
+	for _, wt := range wts {
+		assert.NotEqual(t, proto.WorktreeMissing, wt.Status, "removed on purpose, so not missing: %s", wt.Path)
+	}
+
+	d2 := &Daemon{rootDir: d.rootDir, instances: make(map[string]*Instance)}
+	require.NoError(t, d2.loadPersistedInstances())
+	assert.True(t, d2.instances["1"].Info().WorktreeRemoved, "persisted")
+
+	var out strings.Builder
+	restored, err := d.restoreWorktree(inst, &out)
+	require.NoError(t, err)
+	assert.True(t, restored)
+	assert.DirExists(t, inst.WorktreeDir)
+	assert.Contains(t, out.String(), "Checking out feat again")
+	assert.False(t, inst.Info().WorktreeRemoved)
+	restored, err = d.restoreWorktree(inst, &out)
+	require.NoError(t, err)
+	assert.False(t, restored, "nothing to restore")
+}
+
+func TestRestoreWorktreeBranchGone(t *testing.T) {
+	d, inst := teardownTest(t, proto.StateExited)
+	require.True(t, teardownRequest(t, d.handleWorktreeRm, proto.Request{InstanceID: "1"}).OK)
+	git(t, "-C", inst.MainDir, "branch", "-D", "feat")
+
+	_, err := d.restoreWorktree(inst, &strings.Builder{})
+	assert.ErrorContains(t, err, "branch feat no longer exists")
+	assert.True(t, inst.Info().WorktreeRemoved)
+}
+
+func TestContainerRm(t *testing.T) {
+	calls := recordingDocker(t)
+	d, inst := teardownTest(t, proto.StateKilled)
+	inst.stackStopped = true
+
+	resp := teardownRequest(t, d.handleContainerRm, proto.Request{Type: proto.ReqContainerRm, InstanceID: "1"})
+	require.True(t, resp.OK, resp.Error)
+	got, err := os.ReadFile(calls)
+	require.NoError(t, err)
+	assert.Equal(t, "stop c1\nrm -v c1\n", string(got))
+	info := inst.Info()
+	assert.True(t, info.ContainerRemoved)
+	assert.False(t, info.StackStopped)
+	assert.DirExists(t, inst.WorktreeDir)
+
+	resp = teardownRequest(t, d.handleContainerRm, proto.Request{Type: proto.ReqContainerRm, InstanceID: "1"})
+	assert.Equal(t, "instance 1 has no container: it was removed", resp.Error)
+	assert.Equal(t, "cannot check: the container of instance 1 was removed", inst.removedError("check", true, true).Error)
+	assert.Nil(t, inst.removedError("copy", false, true))
+}
+
+func TestDropKeep(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		opts         dropOptions
+		worktreeRm   bool
+		wantDocker   string
+		wantWorktree bool
+		wantBranch   bool
+	}{
+		{name: "all", wantDocker: "stop c1\nrm -v c1\n"},
+		{name: "keep worktree", opts: dropOptions{KeepWorktree: true}, wantDocker: "stop c1\nrm -v c1\n", wantWorktree: true, wantBranch: true},
+		{name: "keep container", opts: dropOptions{KeepContainer: true}},
+		{name: "worktree removed", worktreeRm: true, wantDocker: "stop c1\nrm -v c1\n"},
+		{name: "worktree removed, keep worktree", opts: dropOptions{KeepWorktree: true}, worktreeRm: true, wantDocker: "stop c1\nrm -v c1\n", wantBranch: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			calls := recordingDocker(t)
+			d, inst := teardownTest(t, proto.StateExited)
+			if tc.worktreeRm {
+				require.True(t, teardownRequest(t, d.handleWorktreeRm, proto.Request{InstanceID: "1"}).OK)
+			}
+
+			assert.Empty(t, d.dropInstance(inst, tc.opts))
+			got, _ := os.ReadFile(calls)
+			assert.Equal(t, tc.wantDocker, string(got))
+			if tc.wantWorktree {
+				assert.DirExists(t, inst.WorktreeDir)
+			} else {
+				assert.NoDirExists(t, inst.WorktreeDir)
+			}
+			branch := git(t, "-C", inst.MainDir, "branch", "--list", "feat", "--format=%(refname:short)")
+			assert.Equal(t, tc.wantBranch, branch == "feat", "branch %q", branch)
+			assert.Nil(t, d.getInstance("1"))
+		})
+	}
+}
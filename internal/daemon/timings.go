@@ -0,0 +1,64 @@
+package daemon
+
+// timings.go – how long each stage of a start takes.
+//
+// handleStart marks where each stage begins and ends on a stageTimer; the
+// durations are stored with the instance, shown by grove status and
+// summarised per project by grove stats.  Anything that reports start
+// progress should take its stage boundaries from the same timer, so that
+// what it shows and what is recorded agree.
+//
+// If the user has turned the timing history on (grove stats --record on),
+// starts, check runs and agent sessions are also appended to it; see
+// package history.
+
+import (
+	"log"
+	"time"
+
+	"github.com/gandalfthegui/grove/internal/history"
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+// stageTimer records the durations of a sequence of stages.  Time between
+// end and the next begin belongs to no stage.
+type stageTimer struct {
+	now     func() time.Time
+	stage   string // running stage, or ""
+	started time.Time
+	timings []proto.StageTiming
+}
+
+func newStageTimer() *stageTimer {
+	return &stageTimer{now: time.Now}
+}
+
+// begin ends the running stage, if any, and starts stage.
+func (t *stageTimer) begin(stage string) {
+	t.end()
+	t.stage, t.started = stage, t.now()
+}
+
+// end records the running stage, if any.
+func (t *stageTimer) end() {
+	if t.stage == "" {
+		return
+	}
+	t.timings = append(t.timings, proto.StageTiming{
+		Stage:    t.stage,
+		Duration: t.now().Sub(t.started).Milliseconds(),
+	})
+	t.stage = ""
+}
+
+// result returns the recorded timings.
+func (t *stageTimer) result() []proto.StageTiming {
+	return append([]proto.StageTiming(nil), t.timings...)
+}
+
+// recordHistory appends rec to the timing history, if it is on.
+func (d *Daemon) recordHistory(rec history.Record) {
+	if err := history.Append(d.rootDir, rec); err != nil {
+		log.Printf("warning: timing history: %v", err)
+	}
+}
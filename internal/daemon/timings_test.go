@@ -0,0 +1,64 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gandalfthegui/grove/internal/history"
+	"github.com/gandalfthegui/grove/pkg/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStageTimer(t *testing.T) {
+	now := time.Unix(1000, 0)
+	timer := newStageTimer()
+	timer.now = func() time.Time { return now }
+
+	timer.begin(proto.StageClone)
+	now = now.Add(2 * time.Second)
+	timer.end()
+	now = now.Add(time.Hour) // between stages: not counted
+	timer.begin(proto.StageWorktree)
+	now = now.Add(300 * time.Millisecond)
+	timer.begin(proto.StageContainer) // ends the worktree stage
+	now = now.Add(time.Second)
+	timer.end()
+	timer.end() // no stage running
+
+	assert.Equal(t, []proto.StageTiming{
+		{Stage: proto.StageClone, Duration: 2000},
+		{Stage: proto.StageWorktree, Duration: 300},
+		{Stage: proto.StageContainer, Duration: 1000},
+	}, timer.result())
+	assert.Equal(t, 3300*time.Millisecond, proto.StartDuration(timer.result()))
+}
+
+func TestSessionHistory(t *testing.T) {
+	fakeExecDocker(t)
+	root := t.TempDir()
+	require.NoError(t, history.Enable(root))
+	d := &Daemon{rootDir: root}
+	inst := &Instance{ID: "1", Project: "api", ContainerID: "c1", LogFile: filepath.Join(t.TempDir(), "1.log"), record: d.recordHistory}
+	require.NoError(t, inst.startAgent("sleep", []string{"0.2"}, nil))
+	inst.mu.Lock()
+	done := inst.processDone
+	inst.mu.Unlock()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("agent did not end")
+	}
+
+	f, err := os.Open(history.Path(root))
+	require.NoError(t, err)
+	defer f.Close()
+	recs, err := history.Read(f)
+	require.NoError(t, err)
+	require.Len(t, recs, 1)
+	assert.Equal(t, history.KindSession, recs[0].Kind)
+	assert.Equal(t, "api", recs[0].Project)
+	assert.GreaterOrEqual(t, recs[0].DurationMs, int64(200))
+}
@@ -0,0 +1,170 @@
+package daemon
+
+// tools.go – locating the external programs the daemon runs.
+//
+// Under launchd groved gets a minimal PATH, so a git or docker installed by
+// Homebrew may not be found even though both work in the user's shell.  The
+// daemon resolves them once at startup, logs where they are, and from then on
+// runs them by absolute path.  A tool that cannot be found leaves the daemon
+// degraded: requests that need it fail with an error naming the daemon's
+// PATH, and `grove daemon status --verbose` shows the problem.
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+const (
+	toolGit    = "git"
+	toolDocker = "docker"
+)
+
+// requiredTools are resolved at startup.
+var requiredTools = []string{toolGit, toolDocker}
+
+// requestTools lists the tools each request type needs.  handleConn refuses
+// a request up front if one of them cannot be found.
+var requestTools = map[string][]string{
+	proto.ReqStart:         {toolGit, toolDocker},
+	proto.ReqDrop:          {toolGit, toolDocker},
+	proto.ReqRestart:       {toolDocker},
+	proto.ReqReopen:        {toolDocker},
+	proto.ReqCheck:         {toolDocker},
+	proto.ReqFinish:        {toolDocker},
+	proto.ReqStats:         {toolDocker},
+	proto.ReqUsage:         {toolDocker},
+	proto.ReqContainerRm:   {toolDocker},
+	proto.ReqWorktreeRm:    {toolGit},
+	proto.ReqProjectDelete: {toolGit, toolDocker},
+}
+
+// tools holds the absolute path of each resolved tool.  Until resolveTools
+// runs (as in unit tests) commands use the bare name.
+var tools = struct {
+	mu    sync.RWMutex
+	paths map[string]string
+}{paths: make(map[string]string)}
+
+// resolveTools looks up every required tool on PATH and records the ones it
+// finds, logging the outcome.  It returns the names it could not find.
+func resolveTools() []string {
+	var missing []string
+	for _, name := range requiredTools {
+		if _, err := lookupTool(name); err != nil {
+			log.Printf("warning: %s not found on PATH=%q; requests that need it will fail", name, os.Getenv("PATH"))
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// lookupTool returns the absolute path of name, resolving and recording it
+// if that has not been done yet.  A tool missing at startup is looked up
+// again each time, so installing it does not require a daemon restart.
+func lookupTool(name string) (string, error) {
+	tools.mu.RLock()
+	path, ok := tools.paths[name]
+	tools.mu.RUnlock()
+	if ok {
+		return path, nil
+	}
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return "", err
+	}
+	tools.mu.Lock()
+	tools.paths[name] = path
+	tools.mu.Unlock()
+	log.Printf("using %s at %s", name, path)
+	return path, nil
+}
+
+// toolPath returns the path to run name by: absolute if known, else the bare
+// name so the error from exec is the usual one.
+func toolPath(name string) string {
+	tools.mu.RLock()
+	defer tools.mu.RUnlock()
+	if path, ok := tools.paths[name]; ok {
+		return path
+	}
+	return name
+}
+
+// gitCommand is exec.Command for git.
+func gitCommand(args ...string) *exec.Cmd {
+	return exec.Command(toolPath(toolGit), args...)
+}
+
+// gitCommandContext is exec.CommandContext for git.
+func gitCommandContext(ctx context.Context, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, toolPath(toolGit), args...)
+	cmd.WaitDelay = killWaitDelay
+	return cmd
+}
+
+// dockerCommand is exec.Command for docker.
+func dockerCommand(args ...string) *exec.Cmd {
+	return exec.Command(toolPath(toolDocker), args...)
+}
+
+// dockerCommandContext is exec.CommandContext for docker.
+func dockerCommandContext(ctx context.Context, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, toolPath(toolDocker), args...)
+	cmd.WaitDelay = killWaitDelay
+	return cmd
+}
+
+// killWaitDelay is how long a command killed because its context ended is
+// waited for to close its output.  Processes it started, such as the ssh
+// of a git clone, can hold that open long after it is gone.
+const killWaitDelay = 5 * time.Second
+
+// requireTools returns an error naming the daemon's PATH if any of names
+// cannot be found.
+func requireTools(names ...string) error {
+	var missing []string
+	for _, name := range names {
+		if _, err := lookupTool(name); err != nil {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%v not found on the daemon's PATH (%s); if groved runs as a LaunchAgent, re-run `grove daemon install` from a shell where they work",
+		missing, os.Getenv("PATH"))
+}
+
+// health reports the daemon's PATH and which required tools it has found.
+func health() *proto.DaemonHealth {
+	h := &proto.DaemonHealth{Path: os.Getenv("PATH"), Tools: make(map[string]string)}
+	for _, name := range requiredTools {
+		if path, err := lookupTool(name); err == nil {
+			h.Tools[name] = path
+		} else {
+			h.Missing = append(h.Missing, name)
+		}
+	}
+	sort.Strings(h.Missing)
+	return h
+}
+
+// respondMissingTools answers a request that cannot proceed without names.
+// It reports whether it did, i.e. whether the caller should return.
+func respondMissingTools(conn net.Conn, names ...string) bool {
+	err := requireTools(names...)
+	if err == nil {
+		return false
+	}
+	respond(conn, proto.Response{OK: false, Error: err.Error(), Health: health()})
+	return true
+}
@@ -0,0 +1,80 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// resetTools clears the resolved tool paths for the duration of a test.
+func resetTools(t *testing.T) {
+	t.Helper()
+	tools.mu.Lock()
+	saved := tools.paths
+	tools.paths = make(map[string]string)
+	tools.mu.Unlock()
+	t.Cleanup(func() {
+		tools.mu.Lock()
+		tools.paths = saved
+		tools.mu.Unlock()
+	})
+}
+
+// installFakeDocker resets the tools and installs the shell script script
+// as docker, in a directory of its own, which it returns.  The script finds
+// that directory as $(dirname "$0"), e.g. for files it records calls in.
+func installFakeDocker(t *testing.T, script string) (binDir string) {
+	t.Helper()
+	resetTools(t)
+	binDir = t.TempDir()
+	path := filepath.Join(binDir, toolDocker)
+	require.NoError(t, os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0o755))
+	tools.paths[toolDocker] = path
+	return binDir
+}
+
+func TestMissingToolsDegradeRequests(t *testing.T) {
+	resetTools(t)
+	bin := t.TempDir()
+	t.Setenv("PATH", bin)
+
+	assert.Equal(t, []string{toolGit, toolDocker}, resolveTools())
+	err := requireTools(toolDocker)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "[docker] not found on the daemon's PATH ("+bin+")")
+	assert.Equal(t, toolDocker, toolPath(toolDocker), "unresolved tools run by bare name")
+
+	h := health()
+	assert.Equal(t, bin, h.Path)
+	assert.Empty(t, h.Tools)
+	assert.Equal(t, []string{toolDocker, toolGit}, h.Missing)
+
+	// Installing the tool later is picked up without a restart.
+	fake := filepath.Join(bin, toolDocker)
+	require.NoError(t, os.WriteFile(fake, []byte("#!/bin/sh\n"), 0o755))
+	require.NoError(t, requireTools(toolDocker))
+	assert.Equal(t, fake, toolPath(toolDocker))
+
+	h = health()
+	assert.Equal(t, map[string]string{toolDocker: fake}, h.Tools)
+	assert.Equal(t, []string{toolGit}, h.Missing)
+}
+
+func TestResolvedToolsRunByAbsolutePath(t *testing.T) {
+	resetTools(t)
+	bin := t.TempDir()
+	for _, name := range requiredTools {
+		require.NoError(t, os.WriteFile(filepath.Join(bin, name), []byte("#!/bin/sh\n"), 0o755))
+	}
+	t.Setenv("PATH", bin)
+
+	assert.Empty(t, resolveTools())
+	// Later PATH changes do not affect tools already resolved.
+	t.Setenv("PATH", t.TempDir())
+	assert.Equal(t, filepath.Join(bin, toolGit), gitCommand("status").Path)
+	assert.Equal(t, filepath.Join(bin, toolDocker), dockerCommand("ps").Path)
+	assert.NoError(t, requireTools(requiredTools...))
+}
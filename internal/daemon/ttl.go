@@ -0,0 +1,234 @@
+package daemon
+
+// ttl.go – a time to live for instances.
+//
+// An instance started with grove start --ttl, or with defaults.ttl in its
+// grove.yaml, expires that long after it was started.  The daemon looks for
+// expired instances every ttlCheckInterval and, whatever they are doing,
+// finishes them (ttl_action: finish, the default) or stops and drops them
+// (ttl_action: drop).  The instance gets a note saying so, and grove events
+// clients an EventExpired.  grove ttl sets a new time to live, counted from
+// now, or removes it.
+//
+// An instance that cannot be finished, e.g. because it is BROKEN or a finish
+// command needs a terminal, has its agent stopped instead.  One that is busy
+// with a check or the like is tried again on the next tick.  A pinned
+// instance is left alone for as long as it is pinned.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"path/filepath"
+	"time"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+const ttlCheckInterval = 15 * time.Second
+
+// checkTTLAction checks a ttl_action value.
+func checkTTLAction(action string) error {
+	switch action {
+	case "", proto.TTLFinish, proto.TTLDrop:
+		return nil
+	}
+	return fmt.Errorf("ttl_action: unknown value %q (want %s or %s)", action, proto.TTLFinish, proto.TTLDrop)
+}
+
+// setTTL makes inst expire ttl from now, or never if ttl is zero.  An empty
+// action keeps the one it has.
+func (inst *Instance) setTTL(ttl time.Duration, action string) {
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+	inst.expiresAt = time.Time{}
+	if ttl > 0 {
+		inst.expiresAt = time.Now().Add(ttl)
+	}
+	if action != "" {
+		inst.ttlAction = action
+	}
+}
+
+// handleTTL sets or removes an instance's time to live.
+func (d *Daemon) handleTTL(conn net.Conn, req proto.Request) {
+	inst := d.getInstance(req.InstanceID)
+	if inst == nil {
+		respond(conn, proto.Response{OK: false, Error: "instance not found: " + req.InstanceID})
+		return
+	}
+	if req.TTL < 0 {
+		respond(conn, proto.Response{OK: false, Error: "the time to live must not be negative"})
+		return
+	}
+	if err := checkTTLAction(req.TTLAction); err != nil {
+		respond(conn, proto.Response{OK: false, Error: err.Error()})
+		return
+	}
+
+	inst.setTTL(time.Duration(req.TTL)*time.Second, req.TTLAction)
+	inst.persistMeta(filepath.Join(d.rootDir, "instances"))
+	info := inst.Info()
+	if info.ExpiresAt == 0 {
+		log.Printf("instance %s: time to live removed", inst.ID)
+	} else {
+		log.Printf("instance %s: expires at %s", inst.ID, time.Unix(info.ExpiresAt, 0).Format(time.RFC3339))
+	}
+	respond(conn, proto.Response{OK: true, InstanceID: inst.ID, Instances: []proto.InstanceInfo{info}})
+}
+
+// ttlLoop expires instances whose time to live has run out.  It runs for
+// the life of the daemon.
+func (d *Daemon) ttlLoop() {
+	ticker := time.NewTicker(ttlCheckInterval)
+	defer ticker.Stop()
+	lastPinned := 0
+	for now := range ticker.C {
+		d.mu.Lock()
+		insts := make([]*Instance, 0, len(d.instances))
+		for _, inst := range d.instances {
+			insts = append(insts, inst)
+		}
+		d.mu.Unlock()
+
+		pinned := 0
+		for _, inst := range insts {
+			if action := inst.expiry(now); action != "" {
+				go d.expire(inst, action)
+			} else if inst.pinnedPastTTL(now) {
+				pinned++
+			}
+		}
+		if pinned != lastPinned && pinned > 0 {
+			log.Printf("time to live: skipped %d pinned instance(s) past theirs", pinned)
+		}
+		lastPinned = pinned
+	}
+}
+
+// pinnedPastTTL reports whether inst's time to live has run out at now but
+// it is pinned.
+func (inst *Instance) pinnedPastTTL(now time.Time) bool {
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+	return inst.pinned && !inst.expiresAt.IsZero() && !now.Before(inst.expiresAt)
+}
+
+// expiry returns what to do with inst if its time to live has run out at
+// now, claiming it so that the next tick leaves it alone, or "".  A
+// FINISHED instance has nothing left to finish; its time to live is simply
+// removed.  A pinned one expires once it is unpinned.
+func (inst *Instance) expiry(now time.Time) string {
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+	if inst.expiresAt.IsZero() || now.Before(inst.expiresAt) || inst.expiring || inst.pinned {
+		return ""
+	}
+	action := inst.ttlAction
+	if action == "" {
+		action = proto.TTLFinish
+	}
+	if action == proto.TTLFinish && inst.state == proto.StateFinished {
+		inst.expiresAt = time.Time{}
+		return ""
+	}
+	inst.expiring = true
+	return action
+}
+
+// expire does action with inst, whose time to live has run out.
+func (d *Daemon) expire(inst *Instance, action string) {
+	const why = "time to live ran out"
+
+	var outcome string
+	switch action {
+	case proto.TTLDrop:
+		unlock, err := d.projectLocks.lock(inst.Project, "drop "+inst.ID+" (expired)", projectLockTimeout, io.Discard)
+		if err != nil {
+			log.Printf("instance %s: %s, but cannot drop it yet: %v", inst.ID, why, err)
+			inst.mu.Lock()
+			inst.expiring = false
+			inst.mu.Unlock()
+			return
+		}
+		log.Printf("instance %s: %s; dropping it", inst.ID, why)
+		// Published first: once dropped, the instance is gone.
+		d.publishExpired(inst, "dropped")
+		d.dropInstance(inst, dropOptions{})
+		unlock()
+		return
+
+	default:
+		log.Printf("instance %s: %s; finishing it", inst.ID, why)
+		err := d.finishExpired(inst)
+		var busy *operationInProgressError
+		if errors.As(err, &busy) {
+			log.Printf("instance %s: cannot finish it yet: %v", inst.ID, err)
+			inst.mu.Lock()
+			inst.expiring = false
+			inst.mu.Unlock()
+			return
+		}
+		outcome = "finished"
+		if err != nil {
+			log.Printf("instance %s: cannot finish it: %v; stopping the agent", inst.ID, err)
+			inst.destroy()
+			outcome = "stopped: could not finish: " + err.Error()
+		}
+	}
+
+	inst.mu.Lock()
+	inst.expiresAt = time.Time{}
+	inst.expiring = false
+	inst.notes = append(inst.notes, proto.Note{Time: time.Now().Unix(), Text: why + "; " + outcome})
+	inst.mu.Unlock()
+	inst.persistMeta(filepath.Join(d.rootDir, "instances"))
+	d.publishExpired(inst, outcome)
+}
+
+// finishExpired runs grove finish on inst as a client would, with no
+// terminal; its output goes to the instance log as ever.  A refusal comes
+// back as an error, an *operationInProgressError if inst is busy.
+func (d *Daemon) finishExpired(inst *Instance) error {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		d.handleFinish(server, proto.Request{Type: proto.ReqFinish, InstanceID: inst.ID})
+		server.Close()
+	}()
+
+	dec := json.NewDecoder(client)
+	var resp proto.Response
+	if err := dec.Decode(&resp); err != nil {
+		return err
+	}
+	if !resp.OK {
+		if resp.ErrorCode == proto.ErrCodeOperationInProgress {
+			return &operationInProgressError{instanceID: inst.ID, active: operation{name: resp.Operation.Name, started: time.Unix(resp.Operation.Started, 0)}}
+		}
+		return errors.New(resp.Error)
+	}
+	// The finish commands' output and result trailer; recordFinish has
+	// recorded the result once the stream ends.
+	io.Copy(io.Discard, io.MultiReader(dec.Buffered(), client))
+	return nil
+}
+
+// publishExpired tells grove events clients that inst expired and what
+// was done about it.
+func (d *Daemon) publishExpired(inst *Instance, outcome string) {
+	info := inst.Info()
+	d.events.publish(proto.Event{
+		Time:       time.Now().Unix(),
+		Type:       proto.EventExpired,
+		InstanceID: info.ID,
+		Project:    info.Project,
+		Branch:     info.Branch,
+		BaseSHA:    info.BaseSHA,
+		State:      info.State,
+		Summary:    outcome,
+	})
+}
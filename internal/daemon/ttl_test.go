@@ -0,0 +1,116 @@
+package daemon
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpiry(t *testing.T) {
+	inst := &Instance{ID: "1", state: proto.StateRunning}
+	now := time.Now()
+	assert.Empty(t, inst.expiry(now), "no time to live")
+
+	inst.setTTL(time.Hour, "")
+	assert.Empty(t, inst.expiry(now))
+	assert.Equal(t, proto.TTLFinish, inst.expiry(now.Add(2*time.Hour)))
+	assert.Empty(t, inst.expiry(now.Add(2*time.Hour)), "claimed by the last tick")
+
+	inst.expiring = false
+	inst.setTTL(time.Hour, proto.TTLDrop)
+	assert.Equal(t, proto.TTLDrop, inst.expiry(now.Add(2*time.Hour)))
+
+	inst.expiring = false
+	inst.state = proto.StateFinished
+	inst.setTTL(time.Hour, proto.TTLFinish)
+	assert.Empty(t, inst.expiry(now.Add(2*time.Hour)), "nothing to finish")
+	assert.Zero(t, inst.Info().ExpiresAt)
+
+	inst.setTTL(0, "")
+	assert.Equal(t, proto.TTLFinish, inst.Info().TTLAction, "kept")
+
+	inst.state = proto.StateRunning
+	inst.setTTL(time.Hour, "")
+	inst.pinned = true
+	assert.Empty(t, inst.expiry(now.Add(2*time.Hour)), "pinned")
+	assert.True(t, inst.pinnedPastTTL(now.Add(2*time.Hour)))
+	assert.False(t, inst.pinnedPastTTL(now))
+	inst.pinned = false
+	assert.Equal(t, proto.TTLFinish, inst.expiry(now.Add(2*time.Hour)), "unpinned")
+}
+
+func TestExpireFinish(t *testing.T) {
+	recordingDocker(t)
+	d, inst := teardownTest(t, proto.StateExited)
+	events := d.events.subscribe()
+	inst.setTTL(time.Hour, "")
+
+	d.expire(inst, inst.expiry(time.Now().Add(2*time.Hour)))
+	info := inst.Info()
+	assert.Equal(t, proto.StateFinished, info.State)
+	assert.Zero(t, info.ExpiresAt)
+	require.NotEmpty(t, info.Notes)
+	assert.Equal(t, "time to live ran out; finished", info.Notes[len(info.Notes)-1].Text)
+
+	for ev := range events {
+		if ev.Type == proto.EventExpired {
+			assert.Equal(t, "finished", ev.Summary)
+			break
+		}
+	}
+}
+
+func TestExpireBusy(t *testing.T) {
+	d, inst := teardownTest(t, proto.StateExited)
+	endOp, err := inst.beginOperation(opCheck)
+	require.NoError(t, err)
+	defer endOp()
+	inst.setTTL(time.Hour, "")
+
+	d.expire(inst, inst.expiry(time.Now().Add(2*time.Hour)))
+	info := inst.Info()
+	assert.Equal(t, proto.StateExited, info.State)
+	assert.NotZero(t, info.ExpiresAt, "tried again on the next tick")
+	assert.False(t, inst.expiring)
+}
+
+func TestExpireDrop(t *testing.T) {
+	recordingDocker(t)
+	d, inst := teardownTest(t, proto.StateExited)
+	events := d.events.subscribe()
+	inst.setTTL(time.Hour, proto.TTLDrop)
+
+	d.expire(inst, inst.expiry(time.Now().Add(2*time.Hour)))
+	assert.Nil(t, d.getInstance("1"))
+	assert.NoDirExists(t, inst.WorktreeDir)
+	ev := <-events
+	assert.Equal(t, proto.EventExpired, ev.Type)
+	assert.Equal(t, "dropped", ev.Summary)
+}
+
+func TestHandleTTL(t *testing.T) {
+	d, inst := teardownTest(t, proto.StateRunning)
+
+	resp := teardownRequest(t, d.handleTTL, proto.Request{Type: proto.ReqTTL, InstanceID: "1", TTL: 3600, TTLAction: proto.TTLDrop})
+	require.True(t, resp.OK, resp.Error)
+	require.Len(t, resp.Instances, 1)
+	assert.InDelta(t, time.Now().Add(time.Hour).Unix(), resp.Instances[0].ExpiresAt, 5)
+	assert.Equal(t, proto.TTLDrop, resp.Instances[0].TTLAction)
+
+	d2 := &Daemon{rootDir: d.rootDir, instances: make(map[string]*Instance)}
+	require.NoError(t, d2.loadPersistedInstances())
+	assert.Equal(t, resp.Instances[0].ExpiresAt, d2.instances["1"].Info().ExpiresAt, "persisted")
+
+	resp = teardownRequest(t, d.handleTTL, proto.Request{Type: proto.ReqTTL, InstanceID: "1"})
+	require.True(t, resp.OK, resp.Error)
+	assert.Zero(t, inst.Info().ExpiresAt)
+	assert.Equal(t, proto.TTLDrop, inst.Info().TTLAction, "kept")
+
+	resp = teardownRequest(t, d.handleTTL, proto.Request{Type: proto.ReqTTL, InstanceID: "1", TTL: 60, TTLAction: "pause"})
+	assert.Equal(t, `ttl_action: unknown value "pause" (want finish or drop)`, resp.Error)
+	resp = teardownRequest(t, d.handleTTL, proto.Request{Type: proto.ReqTTL, InstanceID: "2", TTL: 60})
+	assert.Equal(t, "instance not found: 2", resp.Error)
+}
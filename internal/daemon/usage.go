@@ -0,0 +1,156 @@
+package daemon
+
+// usage.go – what an instance's agent has cost.
+//
+// agent.usage_command in grove.yaml is a shell command, run in the
+// container's workdir with GROVE_INSTANCE and GROVE_BRANCH set, whose last
+// line of output is the agent's total cost in US dollars: "1.23" or
+// "$1.23".  The daemon runs it each time the agent exits and when grove
+// status --usage asks, and keeps the result on the instance.  A command
+// that fails or prints anything else leaves the usage unknown rather than
+// being an error, and does not replace a cost measured before.
+//
+// claude has a default.  Claude Code's session files under ~/.claude,
+// mounted from the host, record a costUSD with each reply;
+// claudeUsageCommand adds up those of the sessions on the instance's
+// branch.  Versions of Claude Code that record tokens but no cost leave the
+// usage unknown.
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+// usageTimeout is how long a usage command may run.
+const usageTimeout = 30 * time.Second
+
+// claudeUsageCommand sums the costUSD of the messages on $GROVE_BRANCH in
+// the Claude Code sessions started in the current directory.  Claude Code
+// keeps those in ~/.claude/projects/<dir>, <dir> being the directory with
+// everything but letters and digits replaced by '-'.  It fails if no
+// message records a cost.
+const claudeUsageCommand = `dir="$HOME/.claude/projects/$(pwd | sed 's/[^A-Za-z0-9]/-/g')"; ` +
+	`cat "$dir"/*.jsonl 2>/dev/null | grep -F "\"gitBranch\":\"$GROVE_BRANCH\"" | ` +
+	`grep -o '"costUSD":[0-9.eE+-]*' | awk -F: '{ s += $2; n++ } END { if (n) printf "%.4f\n", s; else exit 1 }'`
+
+// defaultUsageCommands are the usage commands of agents grove knows how to
+// measure.
+var defaultUsageCommands = map[string]string{
+	"claude": claudeUsageCommand,
+}
+
+// usageCommand returns the command that measures agentCmd's usage:
+// agent.usage_command if set, otherwise the per-agent default, or "" if
+// there is none.
+func (p *Project) usageCommand(agentCmd string) string {
+	if p.Agent.UsageCommand != "" {
+		return p.Agent.UsageCommand
+	}
+	return defaultUsageCommands[agentCmd]
+}
+
+// measureUsage runs inst's usage command and records the result on inst.
+// It returns nil, recording nothing, if the project has no usage command.
+func (d *Daemon) measureUsage(inst *Instance) *proto.AgentUsage {
+	p, err := d.instanceProject(inst, false)
+	command := ""
+	if err == nil {
+		command = p.usageCommand(p.Agent.Command)
+		if command == "" {
+			return nil
+		}
+	}
+
+	containerRemoved, _ := inst.teardownRemoved()
+	var usage *proto.AgentUsage
+	switch {
+	case err != nil:
+		usage = &proto.AgentUsage{Reason: "cannot read the project's config: " + err.Error()}
+	case inst.ContainerID == "":
+		usage = &proto.AgentUsage{Reason: "the instance has no container"}
+	case containerRemoved:
+		usage = &proto.AgentUsage{Reason: "the instance's container was removed"}
+	default:
+		var out bytes.Buffer
+		opts := execOptions{Timeout: usageTimeout, Env: []string{"GROVE_INSTANCE=" + inst.ID, "GROVE_BRANCH=" + inst.Branch}}
+		err := execInContainer(context.Background(), inst.ContainerID, opts, command, &out)
+		usage = parseUsage(out.Bytes(), err)
+	}
+	usage.Time = time.Now().Unix()
+
+	inst.mu.Lock()
+	if usage.Known || inst.usage == nil || !inst.usage.Known {
+		inst.usage = usage
+	}
+	inst.mu.Unlock()
+	if !usage.Known {
+		log.Printf("instance %s: usage unknown: %s", inst.ID, usage.Reason)
+	}
+	return usage
+}
+
+// parseUsage reads the output of a usage command that returned err.
+func parseUsage(out []byte, err error) *proto.AgentUsage {
+	if err != nil {
+		return &proto.AgentUsage{Reason: "usage command failed: " + err.Error()}
+	}
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	last := strings.TrimSpace(lines[len(lines)-1])
+	if last == "" {
+		return &proto.AgentUsage{Reason: "usage command printed nothing"}
+	}
+	cost, perr := strconv.ParseFloat(strings.TrimPrefix(last, "$"), 64)
+	if perr != nil || cost < 0 || math.IsInf(cost, 0) || math.IsNaN(cost) {
+		return &proto.AgentUsage{Reason: fmt.Sprintf("usage command printed %q, not a cost", last)}
+	}
+	return &proto.AgentUsage{Known: true, CostUSD: cost}
+}
+
+// measureUsageWhenDone measures inst's usage once the agent it last
+// launched exits, unless the instance has been dropped by then.
+func (d *Daemon) measureUsageWhenDone(inst *Instance) {
+	inst.mu.Lock()
+	done := inst.processDone
+	inst.mu.Unlock()
+	if done == nil {
+		return
+	}
+	<-done
+	if d.getInstance(inst.ID) != inst {
+		return
+	}
+	if d.measureUsage(inst) != nil && d.getInstance(inst.ID) == inst {
+		inst.persistMeta(filepath.Join(d.rootDir, "instances"))
+	}
+}
+
+// handleUsage measures an instance's usage for grove status --usage and
+// replies with the instance.
+func (d *Daemon) handleUsage(conn net.Conn, req proto.Request) {
+	inst := d.getInstance(req.InstanceID)
+	if inst == nil {
+		respond(conn, proto.Response{OK: false, Error: "instance not found: " + req.InstanceID})
+		return
+	}
+	if d.measureUsage(inst) == nil {
+		agent := ""
+		if inst.Launch != nil {
+			agent = inst.Launch.AgentCommand
+		}
+		respond(conn, proto.Response{OK: false, Error: "no usage command for agent " + strconv.Quote(agent),
+			Hint: "Set agent.usage_command in grove.yaml to a command that prints the agent's cost in US dollars."})
+		return
+	}
+	inst.persistMeta(filepath.Join(d.rootDir, "instances"))
+	respond(conn, proto.Response{OK: true, Instances: []proto.InstanceInfo{inst.Info()}})
+}
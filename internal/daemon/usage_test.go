@@ -0,0 +1,71 @@
+package daemon
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseUsage(t *testing.T) {
+	assert.Equal(t, &proto.AgentUsage{Known: true, CostUSD: 1.25}, parseUsage([]byte("1.25\n"), nil))
+	assert.Equal(t, &proto.AgentUsage{Known: true, CostUSD: 0.5}, parseUsage([]byte("summing sessions\n  $0.50  \n"), nil))
+
+	for out, reason := range map[string]string{
+		"":          "usage command printed nothing",
+		"12 tokens": `usage command printed "12 tokens", not a cost`,
+		"-3":        `usage command printed "-3", not a cost`,
+		"NaN":       `usage command printed "NaN", not a cost`,
+	} {
+		assert.Equal(t, &proto.AgentUsage{Reason: reason}, parseUsage([]byte(out), nil), out)
+	}
+	assert.Equal(t, &proto.AgentUsage{Reason: "usage command failed: exit status 1"}, parseUsage([]byte("1.25"), errors.New("exit status 1")))
+}
+
+func TestUsageCommand(t *testing.T) {
+	var p Project
+	assert.Equal(t, claudeUsageCommand, p.usageCommand("claude"))
+	assert.Empty(t, p.usageCommand("aider"))
+	p.Agent.UsageCommand = "cat cost"
+	assert.Equal(t, "cat cost", p.usageCommand("aider"))
+}
+
+func TestMeasureUsage(t *testing.T) {
+	fakeExecDocker(t)
+	root := t.TempDir()
+	cost := filepath.Join(t.TempDir(), "cost")
+	mainDir := filepath.Join(root, "projects", "app", "main")
+	writeRepoFiles(t, mainDir, map[string]string{
+		"grove.yaml": "agent:\n  command: aider\n  usage_command: cat " + cost + "\n",
+	})
+	d := &Daemon{rootDir: root, instances: make(map[string]*Instance)}
+	inst := helperTestInstance(t)
+	inst.Project, inst.MainDir, inst.WorktreeDir = "app", mainDir, mainDir
+	inst.Launch = &proto.LaunchConfig{AgentCommand: "aider"}
+
+	require.NoError(t, os.WriteFile(cost, []byte("$2.40\n"), 0o644))
+	u := d.measureUsage(inst)
+	require.NotNil(t, u)
+	assert.True(t, u.Known)
+	assert.Equal(t, 2.40, u.CostUSD)
+	assert.Equal(t, u, inst.Info().Usage)
+
+	// A failed measurement is reported but keeps the known cost.
+	require.NoError(t, os.WriteFile(cost, []byte("no sessions\n"), 0o644))
+	u = d.measureUsage(inst)
+	require.NotNil(t, u)
+	assert.False(t, u.Known)
+	assert.Equal(t, 2.40, inst.Info().Usage.CostUSD)
+
+	// No usage command, nothing measured.
+	writeRepoFiles(t, mainDir, map[string]string{"grove.yaml": "agent:\n  command: aider\n"})
+	other := helperTestInstance(t)
+	other.Project, other.MainDir, other.WorktreeDir, other.ID = "app", mainDir, mainDir, "2"
+	other.Launch = &proto.LaunchConfig{AgentCommand: "aider"}
+	assert.Nil(t, d.measureUsage(other))
+	assert.Nil(t, other.Info().Usage)
+}
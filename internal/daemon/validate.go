@@ -0,0 +1,334 @@
+package daemon
+
+// validate.go – checking a grove.yaml without a registered project, for
+// `grove validate`: first against the schema (schema.go), then for settings
+// that decode fine but do not do what their author probably meant.  The
+// daemon reports the latter as warnings when it starts an instance.
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigReport is the outcome of ValidateConfig.  Errors make the file
+// unusable; warnings point at settings that are ignored or likely wrong.
+type ConfigReport struct {
+	Errors   []string
+	Warnings []string
+}
+
+// knownAgents are agent commands grove has specific support for: credential
+// mounts, resume arguments, or (for shells) nothing to set up.
+var knownAgents = []string{"aider", "bash", "claude", "sh"}
+
+// ValidateConfig checks the grove.yaml document data.  Included files are
+// not followed; validate them separately.
+func ValidateConfig(data []byte) ConfigReport {
+	var r ConfigReport
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		r.Errors = append(r.Errors, err.Error())
+		return r
+	}
+	if len(doc.Content) == 0 {
+		return r // an empty file sets nothing
+	}
+
+	var schema map[string]any
+	if err := json.Unmarshal(ConfigSchema(), &schema); err != nil {
+		panic(err)
+	}
+	v := &schemaValidator{root: schema}
+	v.validate(schema, doc.Content[0], "")
+	r.Errors = v.errs
+	if len(r.Errors) > 0 {
+		return r
+	}
+
+	// The schema cannot express everything the decoder checks (e.g.
+	// negative timeouts), so decode as the daemon would.
+	var cfg configFile
+	if err := doc.Decode(&cfg); err != nil {
+		r.Errors = append(r.Errors, err.Error())
+		return r
+	}
+	r.Warnings = configWarnings(&cfg.Project)
+	seen := make(map[string]bool)
+	for _, w := range r.Warnings {
+		seen[w] = true
+	}
+	for _, o := range cfg.Overrides {
+		merged := cfg.Project
+		overlayConfig(&merged, &o.Config)
+		for _, w := range configWarnings(&merged) {
+			if !seen[w] {
+				seen[w] = true
+				r.Warnings = append(r.Warnings, fmt.Sprintf("on branches matching %q: %s", o.Pattern, w))
+			}
+		}
+	}
+	return r
+}
+
+// configWarnings returns problems with a decoded config that do not stop
+// it from loading.
+func configWarnings(p *Project) []string {
+	var warnings []string
+	warn := func(format string, args ...any) {
+		warnings = append(warnings, fmt.Sprintf(format, args...))
+	}
+
+	switch {
+	case p.Container.Image != "" && p.Container.Compose != "":
+		warn("container.image and container.compose are both set; compose is used and image is ignored")
+	case p.Container.Image == "" && p.Container.Compose == "":
+		warn("no container configured: set container.image or container.compose")
+	}
+
+	if p.Agent.Command != "" {
+		i := sort.SearchStrings(knownAgents, p.Agent.Command)
+		if i == len(knownAgents) || knownAgents[i] != p.Agent.Command {
+			warn("agent.command %q is not one grove knows (%s): no credentials are mounted for it, and restarts do not resume it unless agent.resume_args is set",
+				p.Agent.Command, strings.Join(knownAgents, ", "))
+		}
+	}
+
+	if len(p.Agents) > 0 && p.Agent.Command != "" && p.Agent.Command != p.Agents[0].Command {
+		warn("agent.command and agents are both set; the first entry of agents is the primary agent and agent.command is ignored")
+	}
+	for i, a := range p.Agents {
+		if i == 0 && a.Command == p.Agent.Command {
+			continue // warned about as agent.command above
+		}
+		if !slices.Contains(knownAgents, a.Command) {
+			warn("agents[%d].command %q is not one grove knows (%s): no credentials are mounted for it",
+				i, a.Command, strings.Join(knownAgents, ", "))
+		}
+	}
+
+	if len(p.Agent.ContextFiles) == 0 {
+		keys := make([]string, 0, len(p.Agent.Env))
+		for k := range p.Agent.Env {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if strings.Contains(p.Agent.Env[k], "{{context_file}}") {
+				warn("agent.env.%s uses {{context_file}} but agent.context_files is empty, so no context file is written", k)
+			}
+		}
+	}
+
+	for _, section := range []struct {
+		name string
+		cmds []CommandSpec
+	}{{"start", p.Start}, {"check", p.Check.Commands}, {"finish", p.Finish}} {
+		for _, c := range section.cmds {
+			if c.Timeout > 0 && c.Timeout < time.Second {
+				warn("%s command %q: timeout %s is under a second", section.name, c.label(), c.Timeout)
+			}
+			if section.name == "check" && c.Timeout > p.Check.maxDuration() {
+				warn("check command %q: timeout %s is longer than check.max_duration %s, after which the instance leaves CHECKING anyway", c.label(), c.Timeout, p.Check.maxDuration())
+			}
+			if c.needsTerminal() && section.name == "check" && p.Check.Auto != "" && p.Check.Auto != checkAutoNever {
+				warn("check command %q needs a terminal (tty: true); automatic checks run it without input", c.label())
+			}
+			switch {
+			case c.Host && section.name == "start":
+				warn("start command %q: host: applies only to check and finish commands; it runs in the container", c.label())
+			case c.Host && c.TTY:
+				warn("%s command %q: tty: is ignored for host commands, which run without input", section.name, c.label())
+			}
+		}
+	}
+
+	if p.Stop.Stack && p.Container.Compose == "" {
+		warn("stop.stack has no effect without container.compose")
+	}
+	if p.Check.ReportToAgent && (p.Check.Auto == "" || p.Check.Auto == checkAutoNever) {
+		warn("check.report_to_agent has no effect unless check.auto is on-ready or on-waiting")
+	}
+	if err := validateLabels(p.Defaults.Labels); err != nil {
+		warn("defaults.labels: %v; grove start will refuse them", err)
+	}
+	if (p.Terminal.Cols > 0) != (p.Terminal.Rows > 0) {
+		warn("terminal.cols and terminal.rows must be set together; one alone is ignored")
+	}
+	return warnings
+}
+
+// schemaValidator checks YAML nodes against the subset of JSON Schema that
+// ConfigSchema produces.
+type schemaValidator struct {
+	root map[string]any
+	errs []string
+}
+
+func (v *schemaValidator) errorf(n *yaml.Node, path, format string, args ...any) {
+	if path == "" {
+		path = "(top level)"
+	}
+	v.errs = append(v.errs, fmt.Sprintf("line %d: %s: %s", n.Line, path, fmt.Sprintf(format, args...)))
+}
+
+// validate checks n, found at path, against schema s.
+func (v *schemaValidator) validate(s map[string]any, n *yaml.Node, path string) {
+	if n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+	if ref, ok := s["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/$defs/")
+		s = v.root["$defs"].(map[string]any)[name].(map[string]any)
+	}
+
+	if alts, ok := s["anyOf"].([]any); ok {
+		// Report the errors of the alternative whose type fits n; if none
+		// does, just say what was expected.
+		var fitting []string
+		var wanted []string
+		for _, alt := range alts {
+			alt := alt.(map[string]any)
+			wanted = append(wanted, schemaTypes(alt)...)
+			if !typeMatches(alt, n) {
+				continue
+			}
+			sub := &schemaValidator{root: v.root}
+			sub.validate(alt, n, path)
+			if len(sub.errs) == 0 {
+				return
+			}
+			fitting = sub.errs
+		}
+		if fitting != nil {
+			v.errs = append(v.errs, fitting...)
+		} else {
+			v.errorf(n, path, "want %s, got %s", strings.Join(wanted, " or "), nodeType(n))
+		}
+		return
+	}
+
+	if !typeMatches(s, n) {
+		v.errorf(n, path, "want %s, got %s", strings.Join(schemaTypes(s), " or "), nodeType(n))
+		return
+	}
+	if enum, ok := s["enum"].([]any); ok {
+		found := false
+		var names []string
+		for _, e := range enum {
+			names = append(names, fmt.Sprint(e))
+			found = found || fmt.Sprint(e) == n.Value
+		}
+		if !found {
+			v.errorf(n, path, "unknown value %q (want %s)", n.Value, strings.Join(names, ", "))
+		}
+	}
+	if pattern, ok := s["pattern"].(string); ok && !regexp.MustCompile(pattern).MatchString(n.Value) {
+		v.errorf(n, path, "%q is not a duration such as 90s or 5m", n.Value)
+	}
+	if min, ok := s["minimum"].(float64); ok && n.Tag == "!!int" {
+		var i int64
+		if n.Decode(&i) == nil && float64(i) < min {
+			v.errorf(n, path, "must be at least %v", min)
+		}
+	}
+
+	switch n.Kind {
+	case yaml.SequenceNode:
+		if items, ok := s["items"].(map[string]any); ok {
+			for i, item := range n.Content {
+				v.validate(items, item, fmt.Sprintf("%s[%d]", path, i))
+			}
+		}
+	case yaml.MappingNode:
+		v.validateMapping(s, n, path)
+	}
+}
+
+func (v *schemaValidator) validateMapping(s map[string]any, n *yaml.Node, path string) {
+	props, _ := s["properties"].(map[string]any)
+	present := make(map[string]bool)
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, value := n.Content[i], n.Content[i+1]
+		present[key.Value] = true
+		keyPath := key.Value
+		if path != "" {
+			keyPath = path + "." + key.Value
+		}
+		if prop, ok := props[key.Value].(map[string]any); ok {
+			v.validate(prop, value, keyPath)
+			continue
+		}
+		switch extra := s["additionalProperties"].(type) {
+		case bool:
+			if !extra {
+				v.errorf(key, keyPath, "unknown field")
+			}
+		case map[string]any:
+			v.validate(extra, value, keyPath)
+		}
+	}
+	required, _ := s["required"].([]any)
+	for _, r := range required {
+		if !present[r.(string)] {
+			v.errorf(n, path, "missing %s:", r)
+		}
+	}
+}
+
+// schemaTypes returns the JSON types schema s allows.
+func schemaTypes(s map[string]any) []string {
+	switch t := s["type"].(type) {
+	case string:
+		return []string{t}
+	case []any:
+		types := make([]string, len(t))
+		for i, x := range t {
+			types[i] = x.(string)
+		}
+		return types
+	}
+	return nil
+}
+
+// typeMatches reports whether n is of a type schema s allows.
+func typeMatches(s map[string]any, n *yaml.Node) bool {
+	types := schemaTypes(s)
+	if types == nil {
+		return true
+	}
+	got := nodeType(n)
+	for _, t := range types {
+		if t == got || (t == "number" && got == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// nodeType names the JSON type of YAML node n.
+func nodeType(n *yaml.Node) string {
+	switch n.Kind {
+	case yaml.MappingNode:
+		return "object"
+	case yaml.SequenceNode:
+		return "array"
+	}
+	switch n.Tag {
+	case "!!null":
+		return "null"
+	case "!!bool":
+		return "boolean"
+	case "!!int":
+		return "integer"
+	case "!!float":
+		return "number"
+	}
+	return "string"
+}
@@ -0,0 +1,190 @@
+package daemon
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateConfigValid(t *testing.T) {
+	r := ValidateConfig([]byte(`
+container:
+  image: golang:1.22
+agent:
+  command: claude
+  args: [--verbose]
+start:
+  - go mod download
+check:
+  auto: on-ready
+  report_to_agent: true
+  commands:
+    - go test ./...
+    - run: npm test
+      dir: web
+      timeout: 5m
+      name: web
+finish:
+  - git push -u origin {{branch}}
+overrides:
+  "release/*":
+    agent:
+      command: sh
+include: [grove.local.yaml]
+`))
+	assert.Empty(t, r.Errors)
+	assert.Empty(t, r.Warnings)
+}
+
+func TestValidateConfigEmptySections(t *testing.T) {
+	r := ValidateConfig([]byte("container:\n  image: alpine\nstart:\ncheck:\nagent:\n"))
+	assert.Empty(t, r.Errors, "an empty section is allowed")
+	assert.Empty(t, ValidateConfig(nil).Errors)
+}
+
+func TestValidateConfigErrors(t *testing.T) {
+	tests := []struct {
+		name, yaml, want string
+	}{
+		{"unknown field", "container:\n  imag: alpine\n", "line 2: container.imag: unknown field"},
+		{"unknown top-level field", "contaner: {}\n", "line 1: contaner: unknown field"},
+		{"wrong type", "start: make\n", "line 1: start: want array or null, got string"},
+		{"enum", "check:\n  auto: always\n", `line 2: check.auto: unknown value "always"`},
+		{"bare number duration", "check:\n  - run: make\n    timeout: 90\n", "line 3: check[0].timeout: want string, got integer"},
+		{"bad duration", "finish:\n  - run: make\n    timeout: 5 minutes\n", `line 3: finish[0].timeout: "5 minutes" is not a duration`},
+		{"command without run", "start:\n  - dir: web\n", "line 2: start[0]: missing run:"},
+		{"nested field in override", "overrides:\n  main:\n    agent:\n      cmd: sh\n", "line 4: overrides.main.agent.cmd: unknown field"},
+		{"negative timeout", "check:\n  - run: make\n    timeout: -1s\n", "not a duration"},
+		{"not YAML", "start: [\n", "yaml:"},
+		{"agent without command", "agents:\n  - name: coder\n", "line 2: agents[0]: missing command:"},
+		{"bad agent name", "agents:\n  - command: claude\n  - name: code review\n    command: claude\n", "agents[1].name"},
+		{"unnamed helper", "agents:\n  - command: claude\n  - command: aider\n", "every agent after the first needs a name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := ValidateConfig([]byte(tt.yaml))
+			if assert.NotEmpty(t, r.Errors) {
+				assert.Contains(t, r.Errors[0], tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateConfigWarnings(t *testing.T) {
+	r := ValidateConfig([]byte(`
+container:
+  image: alpine
+  compose: docker-compose.yml
+agent:
+  command: codex
+check:
+  report_to_agent: true
+  max_duration: 10m
+  commands:
+    - run: make lint
+      timeout: 100ms
+    - run: make e2e
+      timeout: 20m
+terminal:
+  cols: 120
+`))
+	assert.Empty(t, r.Errors)
+	assert.Equal(t, []string{
+		"container.image and container.compose are both set; compose is used and image is ignored",
+		`agent.command "codex" is not one grove knows (aider, bash, claude, sh): no credentials are mounted for it, and restarts do not resume it unless agent.resume_args is set`,
+		`check command "make lint": timeout 100ms is under a second`,
+		`check command "make e2e": timeout 20m0s is longer than check.max_duration 10m0s, after which the instance leaves CHECKING anyway`,
+		"check.report_to_agent has no effect unless check.auto is on-ready or on-waiting",
+		"terminal.cols and terminal.rows must be set together; one alone is ignored",
+	}, r.Warnings)
+}
+
+func TestValidateConfigStopStack(t *testing.T) {
+	r := ValidateConfig([]byte("container:\n  image: alpine\nstop:\n  stack: true\n"))
+	assert.Empty(t, r.Errors)
+	assert.Equal(t, []string{"stop.stack has no effect without container.compose"}, r.Warnings)
+
+	r = ValidateConfig([]byte("container:\n  compose: docker-compose.yml\nstop:\n  stack: true\n"))
+	assert.Empty(t, r.Errors)
+	assert.Empty(t, r.Warnings)
+}
+
+func TestValidateConfigOverrideWarnings(t *testing.T) {
+	r := ValidateConfig([]byte(`
+container:
+  image: alpine
+check:
+  auto: on-ready
+  commands: [make test]
+overrides:
+  "e2e/*":
+    check:
+      commands:
+        - run: npm run e2e
+          tty: true
+`))
+	assert.Empty(t, r.Errors)
+	assert.Equal(t, []string{
+		`on branches matching "e2e/*": check command "npm run e2e" needs a terminal (tty: true); automatic checks run it without input`,
+	}, r.Warnings)
+}
+
+func TestValidateConfigAgentsWarnings(t *testing.T) {
+	r := ValidateConfig([]byte(`
+container:
+  image: alpine
+agent:
+  command: claude
+agents:
+  - command: aider
+  - name: reviewer
+    command: codex
+`))
+	assert.Empty(t, r.Errors)
+	assert.Equal(t, []string{
+		"agent.command and agents are both set; the first entry of agents is the primary agent and agent.command is ignored",
+		`agents[1].command "codex" is not one grove knows (aider, bash, claude, sh): no credentials are mounted for it`,
+	}, r.Warnings)
+
+	// Known commands and no agent.command: nothing to warn about.
+	r = ValidateConfig([]byte("container:\n  image: alpine\nagents:\n  - command: claude\n  - name: reviewer\n    command: claude\n"))
+	assert.Empty(t, r.Errors)
+	assert.Empty(t, r.Warnings)
+}
+
+func TestConfigWarningsNoContainer(t *testing.T) {
+	assert.Equal(t, []string{"no container configured: set container.image or container.compose"},
+		configWarnings(&Project{}))
+}
+
+func TestConfigWarningsContextFileEnv(t *testing.T) {
+	p := &Project{}
+	p.Container.Image = "alpine"
+	p.Agent.Env = map[string]string{"CLAUDE_MD": "{{context_file}}"}
+	assert.Equal(t, []string{"agent.env.CLAUDE_MD uses {{context_file}} but agent.context_files is empty, so no context file is written"},
+		configWarnings(p))
+	p.Agent.ContextFiles = []string{"AGENTS.md"}
+	assert.Empty(t, configWarnings(p))
+}
+
+func TestConfigWarningsDefaultLabels(t *testing.T) {
+	p := &Project{}
+	p.Container.Image = "alpine"
+	p.Defaults.Labels = map[string]string{"bad key": "x"}
+	assert.Equal(t, []string{
+		`defaults.labels: invalid label key "bad key": must not contain '=' or whitespace; grove start will refuse them`,
+	}, configWarnings(p))
+}
+
+func TestConfigWarningsHostCommands(t *testing.T) {
+	p := &Project{}
+	p.Container.Image = "alpine"
+	p.Start = []CommandSpec{{Run: "open .", Host: true}}
+	p.Check.Commands = []CommandSpec{{Run: "screenshot", Host: true}, {Run: "osascript", Host: true, TTY: true}}
+	p.Check.Auto = checkAutoOnReady
+	p.Finish = []CommandSpec{{Run: "say done", Host: true}}
+	assert.Equal(t, []string{
+		`start command "open .": host: applies only to check and finish commands; it runs in the container`,
+		`check command "osascript": tty: is ignored for host commands, which run without input`,
+	}, configWarnings(p))
+}
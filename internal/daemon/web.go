@@ -0,0 +1,102 @@
+package daemon
+
+// web.go – the optional browser UI (see package web).
+//
+// With web_listen: 127.0.0.1:7421 in ~/.grove/config.yaml the daemon serves
+// the UI on that address.  It is read once, at startup.  The UI reaches the
+// daemon through its own Unix socket like any client, so it only needs the
+// socket path.  Requests must carry the token in <root>/web.token, which
+// only the daemon's user can read; grove web prints a URL that includes it.
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gandalfthegui/grove/internal/web"
+	"github.com/gandalfthegui/grove/pkg/client"
+)
+
+// WebTokenFile is the name of the web UI's token file under the root.
+const WebTokenFile = "web.token"
+
+// webToken returns the UI's token from <root>/web.token, creating the file
+// with a new random token if there is none.
+func webToken(rootDir string) (string, error) {
+	path := filepath.Join(rootDir, WebTokenFile)
+	if data, err := os.ReadFile(path); err == nil {
+		if tok := strings.TrimSpace(string(data)); tok != "" {
+			return tok, nil
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	tok := hex.EncodeToString(b)
+	if err := os.WriteFile(path, []byte(tok+"\n"), 0o600); err != nil {
+		return "", err
+	}
+	return tok, nil
+}
+
+// checkLoopback refuses an address the UI would serve beyond this machine:
+// it speaks plain HTTP, so its token would cross the network in the clear.
+func checkLoopback(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+	return fmt.Errorf("%s is not a loopback address", addr)
+}
+
+// startWeb serves the web UI if config.yaml sets web_listen, recording the
+// address it listens on for the health report.  A bad setting is logged
+// and the daemon runs without the UI.
+func (d *Daemon) startWeb(socketPath string) {
+	addr := d.loadDaemonConfig().WebListen
+	if addr == "" {
+		return
+	}
+	if err := checkLoopback(addr); err != nil {
+		log.Printf("web_listen: %v; not serving the web UI", err)
+		return
+	}
+	token, err := webToken(d.rootDir)
+	if err != nil {
+		log.Printf("web UI: token: %v; not serving it", err)
+		return
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Printf("web UI: %v; not serving it", err)
+		return
+	}
+	d.webAddr = ln.Addr().String()
+	srv := &http.Server{
+		Handler:           web.New(client.New(socketPath), token),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	log.Printf("web UI listening on http://%s/", ln.Addr())
+	go func() {
+		if err := srv.Serve(ln); err != nil {
+			log.Printf("web UI: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,38 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebToken(t *testing.T) {
+	root := t.TempDir()
+	tok, err := webToken(root)
+	require.NoError(t, err)
+	assert.Len(t, tok, 64)
+	fi, err := os.Stat(filepath.Join(root, WebTokenFile))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), fi.Mode().Perm())
+
+	again, err := webToken(root)
+	require.NoError(t, err)
+	assert.Equal(t, tok, again, "the token survives daemon restarts")
+
+	require.NoError(t, os.WriteFile(filepath.Join(root, WebTokenFile), []byte("mine\n"), 0o600))
+	tok, err = webToken(root)
+	require.NoError(t, err)
+	assert.Equal(t, "mine", tok, "a token the user wrote is kept")
+}
+
+func TestCheckLoopback(t *testing.T) {
+	for _, addr := range []string{"127.0.0.1:7421", "localhost:7421", "[::1]:7421"} {
+		assert.NoError(t, checkLoopback(addr), addr)
+	}
+	for _, addr := range []string{"0.0.0.0:7421", ":7421", "192.168.1.2:7421", "7421"} {
+		assert.Error(t, checkLoopback(addr), addr)
+	}
+}
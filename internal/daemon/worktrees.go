@@ -0,0 +1,403 @@
+package daemon
+
+// worktrees.go – grove worktrees: a project's git worktrees as git sees
+// them, set against the instances that should own them, so that a worktree
+// left behind by a failed drop, or an instance whose worktree has gone,
+// shows up without running git worktree list by hand.
+//
+// Removing a worktree has to get past what git refuses: a locked worktree,
+// or administrative files under .git/worktrees left damaged by a crash.  A
+// record there whose directory is gone keeps its branch checked out as far
+// as git is concerned, and a later start on that branch fails; grove gc
+// prunes such records.
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gandalfthegui/grove/internal/registry"
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+// gitWorktree is one entry of git worktree list --porcelain.
+type gitWorktree struct {
+	path   string
+	head   string
+	branch string // without refs/heads/; empty if detached
+	locked bool
+}
+
+// parseWorktreeList parses git worktree list --porcelain output.  The main
+// worktree comes first.
+func parseWorktreeList(out string) []gitWorktree {
+	var wts []gitWorktree
+	for _, line := range strings.Split(out, "\n") {
+		key, value, _ := strings.Cut(line, " ")
+		if key == "worktree" {
+			wts = append(wts, gitWorktree{path: value})
+			continue
+		}
+		if len(wts) == 0 {
+			continue
+		}
+		wt := &wts[len(wts)-1]
+		switch key {
+		case "HEAD":
+			wt.head = value
+		case "branch":
+			wt.branch = strings.TrimPrefix(value, "refs/heads/")
+		case "locked":
+			wt.locked = true
+		}
+	}
+	return wts
+}
+
+// canonicalPath resolves symlinks in path, or in its parent if path itself
+// is gone, so that paths from git and from instance records compare equal.
+func canonicalPath(path string) string {
+	if p, err := filepath.EvalSymlinks(path); err == nil {
+		return p
+	}
+	if dir, err := filepath.EvalSymlinks(filepath.Dir(path)); err == nil {
+		return filepath.Join(dir, filepath.Base(path))
+	}
+	return filepath.Clean(path)
+}
+
+// projectWorktrees lists the worktrees of the main checkout in mainDir and
+// matches them with project's instances.
+func (d *Daemon) projectWorktrees(project, mainDir string) ([]proto.WorktreeInfo, error) {
+	out, err := gitCommand("-C", mainDir, "worktree", "list", "--porcelain").Output()
+	if err != nil {
+		return nil, fmt.Errorf("git worktree list in %s: %w", mainDir, err)
+	}
+
+	d.mu.Lock()
+	var insts []*Instance
+	for _, inst := range d.instances {
+		if inst.Project == project {
+			insts = append(insts, inst)
+		}
+	}
+	d.mu.Unlock()
+	sort.Slice(insts, func(i, j int) bool { return insts[i].CreatedAt.Before(insts[j].CreatedAt) })
+	byPath := make(map[string]*Instance, len(insts))
+	for _, inst := range insts {
+		byPath[canonicalPath(inst.WorktreeDir)] = inst
+	}
+
+	var wts []proto.WorktreeInfo
+	for i, wt := range parseWorktreeList(string(out)) {
+		info := proto.WorktreeInfo{Path: wt.path, Branch: wt.branch, Head: wt.head, Locked: wt.locked}
+		key := canonicalPath(wt.path)
+		inst := byPath[key]
+		switch {
+		case i == 0:
+			info.Status = proto.WorktreeMain
+		case inst != nil:
+			info.InstanceID = inst.ID
+			info.Status = proto.WorktreeOK
+			if !dirExists(wt.path) {
+				info.Status = proto.WorktreeMissing
+			}
+			delete(byPath, key)
+		default:
+			info.Status = proto.WorktreeOrphan
+		}
+		wts = append(wts, info)
+	}
+	for _, inst := range insts {
+		if byPath[canonicalPath(inst.WorktreeDir)] != inst {
+			continue
+		}
+		if _, removed := inst.teardownRemoved(); removed {
+			// grove worktree rm took it away on purpose.
+			continue
+		}
+		info := proto.WorktreeInfo{Path: inst.WorktreeDir, Branch: inst.Branch, InstanceID: inst.ID, Status: proto.WorktreeMissing}
+		if dirExists(inst.WorktreeDir) {
+			info.Status = proto.WorktreeUnlisted
+		}
+		wts = append(wts, info)
+	}
+	return wts, nil
+}
+
+// pruneOrphanWorktrees removes those of paths that are orphans in wts, then
+// has git forget worktrees whose directories are gone.  Branches are kept.
+// The caller holds the project lock.
+func pruneOrphanWorktrees(mainDir string, wts []proto.WorktreeInfo, paths []string) error {
+	orphans := make(map[string]proto.WorktreeInfo)
+	for _, wt := range wts {
+		if wt.Status == proto.WorktreeOrphan {
+			orphans[canonicalPath(wt.Path)] = wt
+		}
+	}
+	var failures []string
+	for _, path := range paths {
+		wt, ok := orphans[canonicalPath(path)]
+		switch {
+		case !ok:
+			log.Printf("worktrees: %s is not an orphan any more; leaving it", path)
+		case wt.Locked:
+			failures = append(failures, path+": locked (git worktree unlock it first)")
+		case dirExists(wt.Path):
+			if out, err := gitCommand("-C", mainDir, "worktree", "remove", "--force", wt.Path).CombinedOutput(); err != nil {
+				failures = append(failures, fmt.Sprintf("%s: %v: %s", path, err, strings.TrimSpace(string(out))))
+				continue
+			}
+			log.Printf("worktrees: removed orphan %s", wt.Path)
+		}
+	}
+	if out, err := gitCommand("-C", mainDir, "worktree", "prune").CombinedOutput(); err != nil {
+		failures = append(failures, fmt.Sprintf("git worktree prune: %v: %s", err, strings.TrimSpace(string(out))))
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("could not remove %s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+func (d *Daemon) handleWorktrees(conn net.Conn, req proto.Request) {
+	e, err := registry.Resolve(d.projectDirs, req.Project)
+	if err != nil {
+		respond(conn, proto.Response{OK: false, Error: err.Error()})
+		return
+	}
+	p := entryProject(d.rootDir, e)
+	if err := p.checkDataDir(); err != nil {
+		respond(conn, errorResponse(err))
+		return
+	}
+	mainDir := p.MainDir()
+	if !dirExists(mainDir) {
+		respond(conn, proto.Response{OK: false, Error: fmt.Sprintf("project %q has not been cloned yet", e.Name)})
+		return
+	}
+
+	var pruneErr error
+	if len(req.PruneWorktrees) > 0 {
+		// Under the project lock, so that a worktree a start is still
+		// setting up is not taken for an orphan.
+		unlock, err := d.projectLocks.lock(e.Name, "prune worktrees", projectLockTimeout, io.Discard)
+		if err != nil {
+			respond(conn, proto.Response{OK: false, Error: err.Error()})
+			return
+		}
+		wts, err := d.projectWorktrees(e.Name, mainDir)
+		if err == nil {
+			pruneErr = pruneOrphanWorktrees(mainDir, wts, req.PruneWorktrees)
+		}
+		unlock()
+		if err != nil {
+			respond(conn, proto.Response{OK: false, Error: err.Error()})
+			return
+		}
+	}
+
+	wts, err := d.projectWorktrees(e.Name, mainDir)
+	if err != nil {
+		respond(conn, proto.Response{OK: false, Error: err.Error()})
+		return
+	}
+	resp := proto.Response{OK: pruneErr == nil, Worktrees: wts}
+	if pruneErr != nil {
+		resp.Error = pruneErr.Error()
+	}
+	respond(conn, resp)
+}
+
+// removeWorktreeDir removes the git worktree at worktreeDir from the
+// checkout in mainDir, keeping its branch.  Each step git refuses is logged,
+// prefixed with who, and the next one tried: git worktree remove --force;
+// git worktree prune, then remove --force --force, which also removes a
+// locked worktree; deleting the directory and its administrative files by
+// hand.  The error says what is left.
+func removeWorktreeDir(mainDir, worktreeDir, who string) error {
+	admin := worktreeAdminDirs(mainDir, worktreeDir)
+	if dirExists(worktreeDir) {
+		err := runGit(mainDir, "worktree", "remove", "--force", worktreeDir)
+		if err != nil {
+			log.Printf("%s: %v; pruning and forcing", who, err)
+			if err := runGit(mainDir, "worktree", "prune"); err != nil {
+				log.Printf("%s: %v", who, err)
+			}
+			err = runGit(mainDir, "worktree", "remove", "--force", "--force", worktreeDir)
+		}
+		if err != nil {
+			log.Printf("%s: %v; removing %s by hand", who, err, worktreeDir)
+			if err := os.RemoveAll(worktreeDir); err != nil {
+				log.Printf("%s: %v", who, err)
+			}
+		}
+	}
+	for _, dir := range admin {
+		if err := os.RemoveAll(dir); err != nil {
+			log.Printf("%s: %v", who, err)
+		}
+	}
+	if err := runGit(mainDir, "worktree", "prune"); err != nil {
+		log.Printf("%s: %v", who, err)
+	}
+
+	var left []string
+	if _, err := os.Lstat(worktreeDir); err == nil {
+		left = append(left, "directory "+worktreeDir)
+	}
+	for _, dir := range admin {
+		if _, err := os.Lstat(dir); err == nil {
+			left = append(left, "git's record of it in "+dir)
+		}
+	}
+	if len(left) > 0 {
+		return fmt.Errorf("could not remove worktree %s: left %s", worktreeDir, strings.Join(left, " and "))
+	}
+	return nil
+}
+
+// runGit runs git with args in dir, returning an error with its output if
+// it fails.
+func runGit(dir string, args ...string) error {
+	out, err := gitCommand(append([]string{"-C", dir}, args...)...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("git %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// worktreeAdminDirs returns the administrative directories under
+// mainDir/.git/worktrees that belong to worktreeDir: those whose gitdir
+// file points into it, and the one its own .git file names, which may
+// differ when the files are damaged.
+func worktreeAdminDirs(mainDir, worktreeDir string) []string {
+	base := filepath.Join(mainDir, ".git", "worktrees")
+	want := canonicalPath(worktreeDir)
+	seen := make(map[string]bool)
+	var dirs []string
+	add := func(dir string) {
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	entries, _ := os.ReadDir(base)
+	for _, e := range entries {
+		dir := filepath.Join(base, e.Name())
+		if gitdir := readAdminGitdir(dir); gitdir != "" && canonicalPath(filepath.Dir(gitdir)) == want {
+			add(dir)
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(worktreeDir, ".git")); err == nil {
+		if dir, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "gitdir: "); ok &&
+			filepath.Dir(canonicalPath(dir)) == canonicalPath(base) {
+			add(filepath.Clean(dir))
+		}
+	}
+	return dirs
+}
+
+// readAdminGitdir returns the path in the gitdir file of the worktree
+// administrative directory dir: the worktree's .git file.  It is "" if the
+// file is missing or empty.
+func readAdminGitdir(dir string) string {
+	data, err := os.ReadFile(filepath.Join(dir, "gitdir"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// staleWorktree is a record under .git/worktrees whose worktree directory
+// is gone.
+type staleWorktree struct {
+	admin  string // the administrative directory
+	path   string // the worktree it records; "" if its gitdir file is unreadable
+	locked bool
+}
+
+// staleWorktrees lists the records under mainDir/.git/worktrees whose
+// worktree directory is gone.
+func staleWorktrees(mainDir string) []staleWorktree {
+	base := filepath.Join(mainDir, ".git", "worktrees")
+	entries, _ := os.ReadDir(base)
+	var stale []staleWorktree
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		dir := filepath.Join(base, e.Name())
+		wt := staleWorktree{admin: dir, locked: fileExists(filepath.Join(dir, "locked"))}
+		if gitdir := readAdminGitdir(dir); gitdir != "" {
+			wt.path = filepath.Dir(gitdir)
+			if dirExists(wt.path) {
+				continue
+			}
+		}
+		stale = append(stale, wt)
+	}
+	return stale
+}
+
+// pruneStaleWorktrees prunes the records of worktrees whose directories are
+// gone from the main checkout of project in mainDir, reporting to w.  A
+// locked record is only reported: someone asked git to keep it.  The caller
+// holds the project lock.
+func pruneStaleWorktrees(project, mainDir string, w io.Writer) error {
+	stale := staleWorktrees(mainDir)
+	var pruned int
+	for _, wt := range stale {
+		what := wt.path
+		if what == "" {
+			what = wt.admin + " (no gitdir)"
+		}
+		if wt.locked {
+			fmt.Fprintf(w, "%s: leaving locked record of missing worktree %s; git worktree unlock it to let gc prune it\n", project, what)
+			continue
+		}
+		fmt.Fprintf(w, "%s: pruning record of missing worktree %s\n", project, what)
+		pruned++
+	}
+	if pruned == 0 {
+		return nil
+	}
+	if err := runGit(mainDir, "worktree", "prune"); err != nil {
+		return fmt.Errorf("%s: %w", project, err)
+	}
+	return nil
+}
+
+// pruneProjectWorktrees runs pruneStaleWorktrees over the main checkout of
+// every registered project that has been cloned, for grove gc.
+func (d *Daemon) pruneProjectWorktrees(w io.Writer) error {
+	var errs []error
+	for _, e := range registry.List(d.projectDirs) {
+		mainDir := entryProject(d.rootDir, e).MainDir()
+		if !dirExists(mainDir) {
+			continue
+		}
+		unlock, err := d.projectLocks.lock(e.Name, "gc", projectLockTimeout, w)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := pruneStaleWorktrees(e.Name, mainDir, w); err != nil {
+			errs = append(errs, err)
+		}
+		unlock()
+	}
+	return errors.Join(errs...)
+}
+
+// dirExists reports whether path is a directory.
+func dirExists(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.IsDir()
+}
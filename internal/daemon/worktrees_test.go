@@ -0,0 +1,151 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseWorktreeList(t *testing.T) {
+	out := "worktree /p/main\nHEAD abc\nbranch refs/heads/main\n\n" +
+		"worktree /p/worktrees/feat-x\nHEAD def\nbranch refs/heads/feat/x\nlocked\n\n" +
+		"worktree /p/worktrees/gone\nHEAD 123\ndetached\nprunable gitdir file points to non-existent location\n\n"
+	assert.Equal(t, []gitWorktree{
+		{path: "/p/main", head: "abc", branch: "main"},
+		{path: "/p/worktrees/feat-x", head: "def", branch: "feat/x", locked: true},
+		{path: "/p/worktrees/gone", head: "123"},
+	}, parseWorktreeList(out))
+	assert.Empty(t, parseWorktreeList(""))
+}
+
+func TestProjectWorktrees(t *testing.T) {
+	root := t.TempDir()
+	main := filepath.Join(root, "main")
+	git(t, "init", "-q", "-b", "main", main)
+	git(t, "-C", main, "-c", "user.name=t", "-c", "user.email=t@t", "commit", "-q", "--allow-empty", "-m", "init")
+	worktree := func(name string) string {
+		dir := filepath.Join(root, "worktrees", name)
+		git(t, "-C", main, "worktree", "add", "-q", "-b", name, dir)
+		return dir
+	}
+	owned, orphan, gone := worktree("owned"), worktree("orphan"), worktree("gone")
+	require.NoError(t, os.RemoveAll(gone))
+	unlisted := filepath.Join(root, "worktrees", "unlisted")
+	require.NoError(t, os.MkdirAll(unlisted, 0o755))
+
+	now := time.Now()
+	d := &Daemon{rootDir: root, instances: map[string]*Instance{
+		"1": {ID: "1", Project: "app", Branch: "owned", WorktreeDir: owned, CreatedAt: now},
+		"2": {ID: "2", Project: "app", Branch: "gone", WorktreeDir: gone, CreatedAt: now.Add(time.Second)},
+		"3": {ID: "3", Project: "app", Branch: "unlisted", WorktreeDir: unlisted, CreatedAt: now.Add(2 * time.Second)},
+		"4": {ID: "4", Project: "other", Branch: "orphan", WorktreeDir: orphan, CreatedAt: now},
+	}}
+
+	status := func() map[string]string {
+		wts, err := d.projectWorktrees("app", main)
+		require.NoError(t, err)
+		got := map[string]string{}
+		for _, wt := range wts {
+			got[filepath.Base(wt.Path)] = wt.Status + " " + wt.InstanceID
+		}
+		return got
+	}
+	assert.Equal(t, map[string]string{
+		"main":     "main ",
+		"owned":    "ok 1",
+		"orphan":   "orphan ", // instance 4 is another project's
+		"gone":     "missing 2",
+		"unlisted": "unlisted 3",
+	}, status())
+
+	wts, err := d.projectWorktrees("app", main)
+	require.NoError(t, err)
+	require.NoError(t, pruneOrphanWorktrees(main, wts, []string{orphan, owned}))
+	assert.NoDirExists(t, orphan)
+	assert.DirExists(t, owned, "not an orphan, so left alone")
+	assert.Equal(t, "orphan", git(t, "-C", main, "branch", "--list", "orphan", "--format=%(refname:short)"), "branches are kept")
+	assert.Equal(t, map[string]string{
+		"main":     "main ",
+		"owned":    "ok 1",
+		"gone":     "missing 2", // pruned from git, still the instance's
+		"unlisted": "unlisted 3",
+	}, status())
+}
+
+func TestPruneOrphanWorktreesLocked(t *testing.T) {
+	wts := []proto.WorktreeInfo{{Path: "/p/w/locked", Status: proto.WorktreeOrphan, Locked: true}}
+	main := t.TempDir()
+	git(t, "init", "-q", main)
+	err := pruneOrphanWorktrees(main, wts, []string{"/p/w/locked"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "/p/w/locked: locked")
+}
+
+func TestRemoveWorktreeDir(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		damage func(t *testing.T, main, worktree string)
+	}{
+		{name: "plain", damage: func(*testing.T, string, string) {}},
+		{name: "locked", damage: func(t *testing.T, main, worktree string) {
+			git(t, "-C", main, "worktree", "lock", worktree)
+		}},
+		{name: "gitdir file damaged", damage: func(t *testing.T, main, worktree string) {
+			require.NoError(t, os.WriteFile(filepath.Join(main, ".git", "worktrees", "feat", "gitdir"), []byte("garbage\n"), 0o644))
+		}},
+		{name: "worktree .git file damaged", damage: func(t *testing.T, main, worktree string) {
+			require.NoError(t, os.WriteFile(filepath.Join(worktree, ".git"), []byte("garbage\n"), 0o644))
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			main := filepath.Join(root, "main")
+			git(t, "init", "-q", "-b", "main", main)
+			git(t, "-C", main, "-c", "user.name=t", "-c", "user.email=t@t", "commit", "-q", "--allow-empty", "-m", "init")
+			worktree := filepath.Join(root, "worktrees", "feat")
+			git(t, "-C", main, "worktree", "add", "-q", "-b", "feat", worktree)
+			tc.damage(t, main, worktree)
+
+			require.NoError(t, removeWorktreeDir(main, worktree, "test"))
+			assert.NoDirExists(t, worktree)
+			assert.NoDirExists(t, filepath.Join(main, ".git", "worktrees", "feat"))
+			assert.Equal(t, "feat", git(t, "-C", main, "branch", "--list", "feat", "--format=%(refname:short)"), "the branch is kept")
+			git(t, "-C", main, "worktree", "add", "-q", worktree, "feat")
+		})
+	}
+}
+
+func TestPruneStaleWorktrees(t *testing.T) {
+	root := t.TempDir()
+	main := filepath.Join(root, "main")
+	git(t, "init", "-q", "-b", "main", main)
+	git(t, "-C", main, "-c", "user.name=t", "-c", "user.email=t@t", "commit", "-q", "--allow-empty", "-m", "init")
+	for _, name := range []string{"kept", "gone", "locked"} {
+		git(t, "-C", main, "worktree", "add", "-q", "-b", name, filepath.Join(root, "worktrees", name))
+	}
+	git(t, "-C", main, "worktree", "lock", filepath.Join(root, "worktrees", "locked"))
+	require.NoError(t, os.RemoveAll(filepath.Join(root, "worktrees", "gone")))
+	require.NoError(t, os.RemoveAll(filepath.Join(root, "worktrees", "locked")))
+
+	stale := staleWorktrees(main)
+	require.Len(t, stale, 2)
+
+	var out strings.Builder
+	require.NoError(t, pruneStaleWorktrees("app", main, &out))
+	assert.Contains(t, out.String(), "app: pruning record of missing worktree "+filepath.Join(root, "worktrees", "gone"))
+	assert.Contains(t, out.String(), "app: leaving locked record of missing worktree "+filepath.Join(root, "worktrees", "locked"))
+	stale = staleWorktrees(main)
+	require.Len(t, stale, 1)
+	assert.True(t, stale[0].locked)
+	git(t, "-C", main, "worktree", "add", "-q", filepath.Join(root, "worktrees", "gone2"), "gone")
+
+	out.Reset()
+	require.NoError(t, pruneStaleWorktrees("app", main, &out))
+	assert.NotContains(t, out.String(), "pruning")
+}
@@ -5,11 +5,13 @@ package envfile
 import (
 	"bufio"
 	"os"
+	"path/filepath"
 	"strings"
 )
 
 // Load reads a dotenv-style file at path and returns its key-value pairs.
-// Lines starting with # and blank lines are silently skipped.
+// Lines starting with # and blank lines are silently skipped.  If a key
+// appears more than once, the last occurrence wins.
 // Returns an empty map (not an error) if the file does not exist.
 func Load(path string) map[string]string {
 	env := map[string]string{}
@@ -21,15 +23,84 @@ func Load(path string) map[string]string {
 
 	scanner := bufio.NewScanner(f)
 	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-		k, v, ok := strings.Cut(line, "=")
+		k, v, ok := parseLine(scanner.Text())
 		if !ok {
 			continue
 		}
-		env[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		env[k] = v
 	}
 	return env
 }
+
+// Set writes key=value into the dotenv file at path, creating it (and its
+// parent directory) if needed.  The first existing entry for key is replaced
+// in place and any later duplicates are removed; comments, blank lines, and
+// unrelated entries are preserved.  The file is written atomically via a
+// temp file and rename, with mode 0600.
+func Set(path, key, value string) error {
+	existing, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	entry := key + "=" + value
+	var out []string
+	replaced := false
+	if len(existing) > 0 {
+		for _, line := range strings.Split(strings.TrimSuffix(string(existing), "\n"), "\n") {
+			if k, _, ok := parseLine(line); ok && k == key {
+				if !replaced {
+					out = append(out, entry)
+					replaced = true
+				}
+				continue
+			}
+			out = append(out, line)
+		}
+	}
+	if !replaced {
+		// Drop trailing blank lines before appending the new entry.
+		for len(out) > 0 && strings.TrimSpace(out[len(out)-1]) == "" {
+			out = out[:len(out)-1]
+		}
+		out = append(out, entry)
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".env-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath) // no-op after a successful rename
+
+	if _, err := tmp.WriteString(strings.Join(out, "\n") + "\n"); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// parseLine splits a KEY=value line.  ok is false for blank lines, comments,
+// and lines without '='.
+func parseLine(line string) (key, value string, ok bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", "", false
+	}
+	k, v, ok := strings.Cut(line, "=")
+	if !ok {
+		return "", "", false
+	}
+	return strings.TrimSpace(k), strings.TrimSpace(v), true
+}
@@ -46,3 +46,37 @@ func TestLoadSkipsLinesWithoutEquals(t *testing.T) {
 	env := envfile.Load(path)
 	assert.Equal(t, map[string]string{"A": "1"}, env)
 }
+
+func TestLoadDuplicateKeyLastWins(t *testing.T) {
+	path := write(t, "TOKEN=old\nTOKEN=new\n")
+	env := envfile.Load(path)
+	assert.Equal(t, "new", env["TOKEN"])
+}
+
+func TestSetReplacesInPlace(t *testing.T) {
+	path := write(t, "# credentials\nA=1\nTOKEN=old\n\n# trailing comment\nB=2\nTOKEN=stale\n")
+	require.NoError(t, envfile.Set(path, "TOKEN", "new"))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "# credentials\nA=1\nTOKEN=new\n\n# trailing comment\nB=2\n", string(data))
+}
+
+func TestSetAppendsMissingKey(t *testing.T) {
+	path := write(t, "A=1\n\n")
+	require.NoError(t, envfile.Set(path, "TOKEN", "t"))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "A=1\nTOKEN=t\n", string(data))
+}
+
+func TestSetCreatesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sub", "env")
+	require.NoError(t, envfile.Set(path, "TOKEN", "t"))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	assert.Equal(t, map[string]string{"TOKEN": "t"}, envfile.Load(path))
+}
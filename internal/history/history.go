@@ -0,0 +1,200 @@
+// Package history keeps the timing history shared by the daemon
+// (internal/daemon), which appends to it, and the CLI (cmd/grove), which
+// summarises it for grove stats --timings.
+//
+// The history is opt-in: it is the file <root>/history.jsonl, and records
+// are only appended while that file exists.  Each line is one Record.  A
+// record holds a project name and durations, nothing else: no instance IDs,
+// branches, tasks, commands or paths.  Nothing leaves the machine.
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+// FileName is the history file in the daemon's root directory.
+const FileName = "history.jsonl"
+
+// Record kinds.
+const (
+	KindStart   = "start"   // a grove start; Stages holds its stage timings
+	KindCheck   = "check"   // a check run, all of its commands
+	KindSession = "session" // an agent session, from launch to exit
+)
+
+// Record is one timing in the history.
+type Record struct {
+	Time       int64               `json:"time"` // unix time it was recorded
+	Kind       string              `json:"kind"`
+	Project    string              `json:"project"`
+	DurationMs int64               `json:"duration_ms"`
+	Stages     []proto.StageTiming `json:"stages,omitempty"`
+}
+
+// Path returns the history file in root.
+func Path(root string) string {
+	return filepath.Join(root, FileName)
+}
+
+// Enabled reports whether the history is being recorded in root.
+func Enabled(root string) bool {
+	_, err := os.Stat(Path(root))
+	return err == nil
+}
+
+// Enable starts recording the history in root.  What is already recorded
+// is kept.
+func Enable(root string) error {
+	f, err := os.OpenFile(Path(root), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// Disable stops recording the history in root and deletes it.
+func Disable(root string) error {
+	if err := os.Remove(Path(root)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// appendMu serialises Append, so that lines never interleave.
+var appendMu sync.Mutex
+
+// Append adds rec to the history in root, setting its time if unset.  It
+// does nothing if the history is not enabled.
+func Append(root string, rec Record) error {
+	if rec.Time == 0 {
+		rec.Time = time.Now().Unix()
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	appendMu.Lock()
+	defer appendMu.Unlock()
+	f, err := os.OpenFile(Path(root), os.O_WRONLY|os.O_APPEND, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// Read parses a history.  Lines that are not records (one cut short by a
+// crash, say) are skipped.
+func Read(r io.Reader) ([]Record, error) {
+	var recs []Record
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var rec Record
+		if json.Unmarshal(scanner.Bytes(), &rec) != nil || rec.Kind == "" {
+			continue
+		}
+		recs = append(recs, rec)
+	}
+	return recs, scanner.Err()
+}
+
+// Distribution summarises a set of durations.
+type Distribution struct {
+	N   int
+	P50 time.Duration
+	P90 time.Duration
+}
+
+// Distribute returns the distribution of ds, sorting ds.  It is zero if ds
+// is empty.
+func Distribute(ds []time.Duration) Distribution {
+	if len(ds) == 0 {
+		return Distribution{}
+	}
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+	return Distribution{N: len(ds), P50: percentile(ds, 50), P90: percentile(ds, 90)}
+}
+
+// percentile returns the p'th percentile of sorted, by the nearest-rank
+// method: the smallest duration at least p percent of sorted are no longer
+// than.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	return sorted[max(rank, 1)-1]
+}
+
+// Project is the timing summary of one project.
+type Project struct {
+	Project string
+	Stages  map[string]Distribution // per start stage, for stages that ran
+	Start   Distribution            // whole starts
+	Check   Distribution
+	Session Distribution
+	// Slowest is the stage with the highest median, or "" if no start
+	// was recorded.
+	Slowest string
+}
+
+// Summarize returns the timing summary of each project in recs, ordered by
+// project name.
+func Summarize(recs []Record) []Project {
+	type samples struct {
+		stages                map[string][]time.Duration
+		start, check, session []time.Duration
+	}
+	byProject := map[string]*samples{}
+	for _, rec := range recs {
+		s := byProject[rec.Project]
+		if s == nil {
+			s = &samples{stages: map[string][]time.Duration{}}
+			byProject[rec.Project] = s
+		}
+		d := time.Duration(rec.DurationMs) * time.Millisecond
+		switch rec.Kind {
+		case KindStart:
+			for _, t := range rec.Stages {
+				s.stages[t.Stage] = append(s.stages[t.Stage], time.Duration(t.Duration)*time.Millisecond)
+			}
+			s.start = append(s.start, d)
+		case KindCheck:
+			s.check = append(s.check, d)
+		case KindSession:
+			s.session = append(s.session, d)
+		}
+	}
+
+	projects := make([]Project, 0, len(byProject))
+	for name, s := range byProject {
+		p := Project{
+			Project: name,
+			Stages:  map[string]Distribution{},
+			Start:   Distribute(s.start),
+			Check:   Distribute(s.check),
+			Session: Distribute(s.session),
+		}
+		for stage, ds := range s.stages {
+			p.Stages[stage] = Distribute(ds)
+			slowest, ok := p.Stages[p.Slowest]
+			if !ok || p.Stages[stage].P50 > slowest.P50 || (p.Stages[stage].P50 == slowest.P50 && stage < p.Slowest) {
+				p.Slowest = stage
+			}
+		}
+		projects = append(projects, p)
+	}
+	sort.Slice(projects, func(i, j int) bool { return projects[i].Project < projects[j].Project })
+	return projects
+}
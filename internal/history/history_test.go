@@ -0,0 +1,90 @@
+package history_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gandalfthegui/grove/internal/history"
+	"github.com/gandalfthegui/grove/pkg/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppendOptIn(t *testing.T) {
+	root := t.TempDir()
+	rec := history.Record{Kind: history.KindCheck, Project: "api", DurationMs: 1500}
+
+	require.NoError(t, history.Append(root, rec))
+	assert.False(t, history.Enabled(root), "nothing is recorded until enabled")
+
+	require.NoError(t, history.Enable(root))
+	require.NoError(t, history.Append(root, rec))
+	require.NoError(t, history.Append(root, history.Record{Time: 7, Kind: history.KindSession, Project: "api", DurationMs: 60000}))
+	require.NoError(t, history.Enable(root), "enabling again keeps the history")
+
+	f, err := os.Open(history.Path(root))
+	require.NoError(t, err)
+	recs, err := history.Read(f)
+	f.Close()
+	require.NoError(t, err)
+	require.Len(t, recs, 2)
+	assert.NotZero(t, recs[0].Time, "the time is set")
+	assert.Equal(t, history.KindCheck, recs[0].Kind)
+	assert.Equal(t, int64(7), recs[1].Time)
+
+	require.NoError(t, history.Disable(root))
+	assert.False(t, history.Enabled(root))
+	require.NoError(t, history.Disable(root), "disabling twice is fine")
+}
+
+func TestReadSkipsMalformedLines(t *testing.T) {
+	recs, err := history.Read(strings.NewReader(`{"kind":"check","project":"api","duration_ms":10}
+not json
+{"project":"no kind"}
+{"kind":"session","project":"api","dur`))
+	require.NoError(t, err)
+	assert.Equal(t, []history.Record{{Kind: history.KindCheck, Project: "api", DurationMs: 10}}, recs)
+}
+
+func TestDistribute(t *testing.T) {
+	assert.Equal(t, history.Distribution{}, history.Distribute(nil))
+	assert.Equal(t, history.Distribution{N: 1, P50: time.Second, P90: time.Second}, history.Distribute([]time.Duration{time.Second}))
+
+	var ds []time.Duration
+	for i := 10; i >= 1; i-- {
+		ds = append(ds, time.Duration(i)*time.Second)
+	}
+	assert.Equal(t, history.Distribution{N: 10, P50: 5 * time.Second, P90: 9 * time.Second}, history.Distribute(ds))
+}
+
+func TestSummarize(t *testing.T) {
+	start := func(project string, clone, image int64) history.Record {
+		return history.Record{Kind: history.KindStart, Project: project, DurationMs: clone + image, Stages: []proto.StageTiming{
+			{Stage: proto.StageClone, Duration: clone},
+			{Stage: proto.StageImage, Duration: image},
+		}}
+	}
+	projects := history.Summarize([]history.Record{
+		start("web", 3000, 1000),
+		start("api", 1000, 40000),
+		start("api", 2000, 20000),
+		{Kind: history.KindCheck, Project: "api", DurationMs: 5000},
+		{Kind: history.KindSession, Project: "api", DurationMs: 600000},
+	})
+	require.Len(t, projects, 2)
+
+	api := projects[0]
+	assert.Equal(t, "api", api.Project)
+	assert.Equal(t, history.Distribution{N: 2, P50: time.Second, P90: 2 * time.Second}, api.Stages[proto.StageClone])
+	assert.Equal(t, history.Distribution{N: 2, P50: 20 * time.Second, P90: 40 * time.Second}, api.Stages[proto.StageImage])
+	assert.Equal(t, proto.StageImage, api.Slowest)
+	assert.Equal(t, 2, api.Start.N)
+	assert.Equal(t, history.Distribution{N: 1, P50: 5 * time.Second, P90: 5 * time.Second}, api.Check)
+	assert.Equal(t, 10*time.Minute, api.Session.P50)
+
+	web := projects[1]
+	assert.Equal(t, proto.StageClone, web.Slowest)
+	assert.Zero(t, web.Check.N)
+}
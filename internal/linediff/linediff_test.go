@@ -0,0 +1,28 @@
+package linediff_test
+
+import (
+	"testing"
+
+	"github.com/gandalfthegui/grove/internal/linediff"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLines(t *testing.T) {
+	a := []string{"name: app", "container:", "  image: alpine:3.19", "start: []"}
+	b := []string{"name: app", "container:", "  image: alpine:3.20", "start: []", "finish: []"}
+	assert.Equal(t, []string{
+		" name: app",
+		" container:",
+		"-  image: alpine:3.19",
+		"+  image: alpine:3.20",
+		" start: []",
+		"+finish: []",
+	}, linediff.Lines(a, b))
+	assert.Equal(t, []string{"-x"}, linediff.Lines([]string{"x"}, nil))
+	assert.Equal(t, []string{" x"}, linediff.Lines([]string{"x"}, []string{"x"}))
+}
+
+func TestSplit(t *testing.T) {
+	assert.Equal(t, []string{"a", "b"}, linediff.Split("a\nb\n"))
+	assert.Equal(t, []string{"a", "b"}, linediff.Split("a\nb"))
+}
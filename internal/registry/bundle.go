@@ -0,0 +1,201 @@
+package registry
+
+// bundle.go – moving registrations between machines.
+//
+// grove project export writes a bundle: the project.yaml of each exported
+// project, with its name set, in one YAML document.  grove project
+// import-bundle writes them back into a personal projects directory.
+// Mapping keys that name a secret (agent.env's GITHUB_TOKEN, say) are left
+// out on export, so a bundle can be passed around, and so is data_dir: a
+// path on this machine.
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// BundleVersion is the bundle format Export writes and ParseBundle accepts.
+const BundleVersion = 1
+
+// Bundle is a set of registrations: each entry is a project.yaml mapping
+// that includes its name.
+type Bundle struct {
+	Version  int         `yaml:"grove_bundle"`
+	Projects []yaml.Node `yaml:"projects"`
+}
+
+// secretKeyWords mark mapping keys whose values Export leaves out.
+var secretKeyWords = []string{"token", "secret", "password", "passwd", "credential", "api_key", "apikey", "auth"}
+
+func isSecretKey(k string) bool {
+	k = strings.ToLower(k)
+	for _, w := range secretKeyWords {
+		if strings.Contains(k, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidName reports whether name can name a project: it is a directory
+// name, and ':' is reserved for scratch instances.
+func ValidName(name string) error {
+	if name == "" || strings.ContainsAny(name, `/\:`) || name == "." || name == ".." {
+		return fmt.Errorf("invalid project name %q (it names a directory; ':' is reserved for scratch instances)", name)
+	}
+	return nil
+}
+
+// Export returns the registration of e for a bundle, with its name set and
+// secrets and data_dir removed, and the dotted paths of the secrets it
+// removed.
+func Export(e Entry) (*yaml.Node, []string, error) {
+	path := YAMLPath(e.Source, e.Name)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	m := &yaml.Node{Kind: yaml.MappingNode}
+	if len(doc.Content) > 0 {
+		m = doc.Content[0]
+	}
+	if m.Kind != yaml.MappingNode {
+		return nil, nil, fmt.Errorf("parse %s: not a mapping", path)
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == "data_dir" {
+			m.Content = append(m.Content[:i], m.Content[i+2:]...)
+			break
+		}
+	}
+	if mappingValue(m, "name") == nil {
+		m.Content = append([]*yaml.Node{
+			{Kind: yaml.ScalarNode, Value: "name"},
+			{Kind: yaml.ScalarNode, Value: e.Name},
+		}, m.Content...)
+	}
+	return m, stripSecrets(m, ""), nil
+}
+
+// stripSecrets removes the entries of every mapping under n whose key names
+// a secret, returning their dotted paths below prefix.
+func stripSecrets(n *yaml.Node, prefix string) []string {
+	var removed []string
+	switch n.Kind {
+	case yaml.MappingNode:
+		kept := n.Content[:0]
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k, v := n.Content[i], n.Content[i+1]
+			if isSecretKey(k.Value) {
+				removed = append(removed, prefix+k.Value)
+				continue
+			}
+			removed = append(removed, stripSecrets(v, prefix+k.Value+".")...)
+			kept = append(kept, k, v)
+		}
+		n.Content = kept
+	case yaml.SequenceNode:
+		for i, c := range n.Content {
+			removed = append(removed, stripSecrets(c, fmt.Sprintf("%s%d.", prefix, i))...)
+		}
+	}
+	return removed
+}
+
+// mappingValue returns the value of key in mapping m, or nil.
+func mappingValue(m *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// BundleName returns the name of a registration from a parsed bundle.
+func BundleName(reg *yaml.Node) string {
+	if v := mappingValue(reg, "name"); v != nil {
+		return v.Value
+	}
+	return ""
+}
+
+// ParseBundle parses and checks a bundle, which may be YAML or JSON: every
+// entry must be a mapping with a valid, distinct name and a string repo if
+// it has one.
+func ParseBundle(data []byte) (*Bundle, error) {
+	var b Bundle
+	if err := yaml.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("parse bundle: %w", err)
+	}
+	switch {
+	case b.Version == 0:
+		return nil, errors.New("not a grove bundle (no grove_bundle version)")
+	case b.Version != BundleVersion:
+		return nil, fmt.Errorf("bundle format %d is not supported (this grove reads format %d)", b.Version, BundleVersion)
+	}
+	seen := make(map[string]bool)
+	for i := range b.Projects {
+		reg := &b.Projects[i]
+		if reg.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("bundle: project %d is not a mapping", i+1)
+		}
+		name := mappingValue(reg, "name")
+		if name == nil || name.Kind != yaml.ScalarNode {
+			return nil, fmt.Errorf("bundle: project %d has no name", i+1)
+		}
+		if err := ValidName(name.Value); err != nil {
+			return nil, fmt.Errorf("bundle: project %d: %w", i+1, err)
+		}
+		if seen[name.Value] {
+			return nil, fmt.Errorf("bundle: project %q appears twice", name.Value)
+		}
+		seen[name.Value] = true
+		if repo := mappingValue(reg, "repo"); repo != nil && repo.Kind != yaml.ScalarNode {
+			return nil, fmt.Errorf("bundle: project %q: repo is not a string", name.Value)
+		}
+	}
+	return &b, nil
+}
+
+// Import outcomes.
+const (
+	ImportCreated  = "created"
+	ImportSkipped  = "skipped"  // a registration of that name exists
+	ImportReplaced = "replaced" // it existed, and overwrite was set
+)
+
+// Import writes reg, a registration from a parsed bundle, to dir as
+// <dir>/<name>/project.yaml.  An existing registration is left alone unless
+// overwrite is set.  It returns what it did: ImportCreated etc.
+func Import(dir string, reg *yaml.Node, overwrite bool) (string, error) {
+	name := BundleName(reg)
+	path := YAMLPath(dir, name)
+	outcome := ImportCreated
+	if _, err := os.Stat(path); err == nil {
+		if !overwrite {
+			return ImportSkipped, nil
+		}
+		outcome = ImportReplaced
+	}
+	data, err := yaml.Marshal(reg)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return "", err
+	}
+	return outcome, nil
+}
@@ -0,0 +1,99 @@
+package registry
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+// exportBundle exports the registrations in dir as a marshalled bundle.
+func exportBundle(t *testing.T, dir string) []byte {
+	t.Helper()
+	b := Bundle{Version: BundleVersion}
+	for _, e := range List([]string{dir}) {
+		reg, _, err := Export(e)
+		require.NoError(t, err)
+		b.Projects = append(b.Projects, *reg)
+	}
+	data, err := yaml.Marshal(&b)
+	require.NoError(t, err)
+	return data
+}
+
+func TestBundleRoundTrip(t *testing.T) {
+	from, to := t.TempDir(), t.TempDir()
+	writeRegistration(t, from, "api", "name: api\nrepo: git@me:api.git # moved in 2025\ncontainer:\n  image: node:22\n")
+	writeRegistration(t, from, "web", "repo: git@me:web.git\ndata_dir: /Volumes/ext/web\nagent:\n  env:\n    GITHUB_TOKEN: ghp_x\n    NODE_ENV: dev\n")
+
+	reg, removed, err := Export(Entry{Name: "web", Source: from})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"agent.env.GITHUB_TOKEN"}, removed)
+	assert.Equal(t, "web", BundleName(reg), "the name is added from the directory")
+
+	data := exportBundle(t, from)
+	assert.NotContains(t, string(data), "ghp_x")
+	assert.NotContains(t, string(data), "data_dir", "a path on this machine")
+	b, err := ParseBundle(data)
+	require.NoError(t, err)
+	require.Len(t, b.Projects, 2)
+
+	// Into an empty directory: everything is created.
+	for i := range b.Projects {
+		outcome, err := Import(to, &b.Projects[i], false)
+		require.NoError(t, err)
+		assert.Equal(t, ImportCreated, outcome)
+	}
+	assert.Equal(t, []Entry{
+		{Name: "api", Repo: "git@me:api.git", Source: to},
+		{Name: "web", Repo: "git@me:web.git", Source: to},
+	}, List([]string{to}))
+	data, err = os.ReadFile(YAMLPath(to, "api"))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "# moved in 2025", "comments survive")
+	assert.Contains(t, string(data), "image: node:22")
+
+	// Again, after a local change: skipped unless overwriting.
+	require.NoError(t, SetRepo(to, "api", "git@me:api-local.git"))
+	outcome, err := Import(to, &b.Projects[0], false)
+	require.NoError(t, err)
+	assert.Equal(t, ImportSkipped, outcome)
+	e, err := Find([]string{to}, "api")
+	require.NoError(t, err)
+	assert.Equal(t, "git@me:api-local.git", e.Repo)
+
+	outcome, err = Import(to, &b.Projects[0], true)
+	require.NoError(t, err)
+	assert.Equal(t, ImportReplaced, outcome)
+	e, err = Find([]string{to}, "api")
+	require.NoError(t, err)
+	assert.Equal(t, "git@me:api.git", e.Repo)
+}
+
+func TestParseBundleJSON(t *testing.T) {
+	b, err := ParseBundle([]byte(`{"grove_bundle": 1, "projects": [{"name": "api", "repo": "git@me:api.git"}]}`))
+	require.NoError(t, err)
+	require.Len(t, b.Projects, 1)
+	assert.Equal(t, "api", BundleName(&b.Projects[0]))
+}
+
+func TestParseBundleMalformed(t *testing.T) {
+	for _, tc := range []struct{ bundle, err string }{
+		{"{", "parse bundle"},
+		{"name: api\nrepo: x\n", "not a grove bundle"},
+		{"grove_bundle: 2\nprojects: []\n", "bundle format 2 is not supported"},
+		{"grove_bundle: 1\nprojects: [api]\n", "project 1 is not a mapping"},
+		{"grove_bundle: 1\nprojects: [{repo: x}]\n", "project 1 has no name"},
+		{"grove_bundle: 1\nprojects: [{name: ../etc}]\n", "invalid project name"},
+		{"grove_bundle: 1\nprojects: [{name: 'a:b'}]\n", "invalid project name"},
+		{"grove_bundle: 1\nprojects: [{name: api}, {name: api}]\n", `project "api" appears twice`},
+		{"grove_bundle: 1\nprojects: [{name: api, repo: [x]}]\n", "repo is not a string"},
+	} {
+		_, err := ParseBundle([]byte(tc.bundle))
+		if assert.Error(t, err, tc.bundle) {
+			assert.Contains(t, err.Error(), tc.err, tc.bundle)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+// Package registry locates project registrations (<dir>/<name>/project.yaml)
+// across a search path of projects directories.
+//
+// The personal directory, <root>/projects, always comes first and is the only
+// one grove writes to.  Further directories — typically a team-shared git
+// repo of registrations, possibly mounted read-only — come from
+// GROVE_PROJECTS_PATH (or groved's --projects-dir flags).  When the same
+// project name appears in several directories the first one wins.
+//
+// Only the registration is looked up this way.  A project's data (main
+// checkout, worktrees) lives under <root>/projects/<name>, or in the
+// directory the registration's data_dir names, e.g. on an external volume.
+package registry
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPath names the environment variable holding extra projects
+// directories, separated by os.PathListSeparator (":" on Unix).
+const EnvPath = "GROVE_PROJECTS_PATH"
+
+// Entry is one registered project.
+type Entry struct {
+	Name   string // the registration's name, or its directory name if unset
+	Repo   string // may be empty
+	Source string // projects directory the registration was found in
+
+	// DataDir is the registration's data_dir: an absolute path to keep the
+	// project's data in instead of <root>/projects/<name>.  May be empty.
+	DataDir string
+}
+
+// PersonalDir returns the projects directory grove writes registrations to.
+func PersonalDir(rootDir string) string {
+	return filepath.Join(rootDir, "projects")
+}
+
+// DataDir returns the directory holding e's data, main checkout and
+// worktrees: its data_dir if it has one, else <rootDir>/projects/<name>,
+// wherever the registration itself was found.
+func DataDir(rootDir string, e Entry) string {
+	if e.DataDir != "" {
+		return e.DataDir
+	}
+	return filepath.Join(PersonalDir(rootDir), e.Name)
+}
+
+// PathFromEnv returns the directories listed in GROVE_PROJECTS_PATH.
+func PathFromEnv() []string {
+	return filepath.SplitList(os.Getenv(EnvPath))
+}
+
+// SearchDirs returns the search path: the personal directory followed by
+// extra, in order, without empty entries or duplicates.
+func SearchDirs(rootDir string, extra []string) []string {
+	dirs := []string{PersonalDir(rootDir)}
+	seen := map[string]bool{filepath.Clean(dirs[0]): true}
+	for _, d := range extra {
+		if d == "" {
+			continue
+		}
+		if clean := filepath.Clean(d); !seen[clean] {
+			seen[clean] = true
+			dirs = append(dirs, d)
+		}
+	}
+	return dirs
+}
+
+// Find returns the registration for name from the first directory in dirs
+// that has one.
+func Find(dirs []string, name string) (Entry, error) {
+	for _, dir := range dirs {
+		e, err := read(dir, name)
+		if os.IsNotExist(err) {
+			continue
+		}
+		return e, err
+	}
+	return Entry{}, fmt.Errorf("project %q not found (searched %v)", name, dirs)
+}
+
+// List returns every project registered in dirs, sorted by name.  A project
+// shadowed by an earlier directory is listed once, from that directory.
+// Unreadable or malformed registrations are skipped.
+func List(dirs []string) []Entry {
+	seen := make(map[string]bool)
+	var entries []Entry
+	for _, dir := range dirs {
+		dirEntries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, de := range dirEntries {
+			if !de.IsDir() || seen[de.Name()] {
+				continue
+			}
+			e, err := read(dir, de.Name())
+			if err != nil {
+				continue
+			}
+			seen[de.Name()] = true
+			entries = append(entries, e)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries
+}
+
+// Resolve resolves a project argument that is either a 1-based index into
+// List(dirs) (e.g. "2", as shown by `grove project list`) or a project name.
+func Resolve(dirs []string, arg string) (Entry, error) {
+	n, err := strconv.Atoi(arg)
+	if err != nil {
+		return Find(dirs, arg)
+	}
+	entries := List(dirs)
+	if n < 1 || n > len(entries) {
+		return Entry{}, fmt.Errorf("project index %d out of range (have %d project(s))", n, len(entries))
+	}
+	return entries[n-1], nil
+}
+
+// YAMLPath returns the path of a registration in a projects directory.
+func YAMLPath(dir, name string) string {
+	return filepath.Join(dir, name, "project.yaml")
+}
+
+// read parses <dir>/<name>/project.yaml.  It returns an error satisfying
+// os.IsNotExist if there is no such file.  A data_dir must be absolute;
+// whether it exists is for its users to check, as a volume may come and go.
+func read(dir, name string) (Entry, error) {
+	data, err := os.ReadFile(YAMLPath(dir, name))
+	if err != nil {
+		return Entry{}, err
+	}
+	var reg struct {
+		Name    string `yaml:"name"`
+		Repo    string `yaml:"repo"`
+		DataDir string `yaml:"data_dir"`
+	}
+	if err := yaml.Unmarshal(data, &reg); err != nil {
+		return Entry{}, fmt.Errorf("parse %s: %w", YAMLPath(dir, name), err)
+	}
+	if reg.DataDir != "" {
+		if !filepath.IsAbs(reg.DataDir) {
+			return Entry{}, fmt.Errorf("parse %s: data_dir %q must be an absolute path", YAMLPath(dir, name), reg.DataDir)
+		}
+		reg.DataDir = filepath.Clean(reg.DataDir)
+	}
+	if reg.Name == "" {
+		reg.Name = name
+	}
+	return Entry{Name: reg.Name, Repo: reg.Repo, Source: dir, DataDir: reg.DataDir}, nil
+}
+
+// SetRepo changes the repo URL of the registration <dir>/<name>/project.yaml,
+// keeping the rest of the file as it is.
+func SetRepo(dir, name, repo string) error {
+	return set(dir, name, "repo", repo)
+}
+
+// SetDataDir changes the data_dir of the registration
+// <dir>/<name>/project.yaml, or removes it if dataDir is empty, keeping the
+// rest of the file as it is.  It moves nothing; see grove project move.
+func SetDataDir(dir, name, dataDir string) error {
+	return set(dir, name, "data_dir", dataDir)
+}
+
+// set sets key in the registration <dir>/<name>/project.yaml to value, or
+// removes it if value is empty.
+func set(dir, name, key, value string) error {
+	path := YAMLPath(dir, name)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+	if len(doc.Content) == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	m := doc.Content[0]
+	if m.Kind != yaml.MappingNode {
+		return fmt.Errorf("parse %s: not a mapping", path)
+	}
+	found := false
+	kept := m.Content[:0]
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			found = true
+			if value == "" {
+				continue
+			}
+			m.Content[i+1].SetString(value)
+		}
+		kept = append(kept, m.Content[i], m.Content[i+1])
+	}
+	m.Content = kept
+	if !found && value != "" {
+		m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, &yaml.Node{Kind: yaml.ScalarNode, Value: value})
+	}
+	out, err := yaml.Marshal(&doc)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, out, 0o644)
+}
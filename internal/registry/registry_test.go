@@ -0,0 +1,130 @@
+package registry
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeRegistration(t *testing.T, dir, name, content string) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, name), 0o755))
+	require.NoError(t, os.WriteFile(YAMLPath(dir, name), []byte(content), 0o644))
+}
+
+func TestSearchDirs(t *testing.T) {
+	dirs := SearchDirs("/root/.grove", []string{"/team", "", "/root/.grove/projects/", "/team", "/other"})
+	assert.Equal(t, []string{"/root/.grove/projects", "/team", "/other"}, dirs)
+}
+
+func TestPathFromEnv(t *testing.T) {
+	t.Setenv(EnvPath, "/a"+string(os.PathListSeparator)+"/b")
+	assert.Equal(t, []string{"/a", "/b"}, PathFromEnv())
+
+	t.Setenv(EnvPath, "")
+	assert.Empty(t, PathFromEnv())
+}
+
+func TestFindFirstMatchWins(t *testing.T) {
+	personal, team := t.TempDir(), t.TempDir()
+	writeRegistration(t, team, "api", "repo: git@team:api.git\n")
+	writeRegistration(t, team, "web", "repo: git@team:web.git\n")
+	writeRegistration(t, personal, "web", "repo: git@me:web.git\n")
+	dirs := []string{personal, team}
+
+	e, err := Find(dirs, "web")
+	require.NoError(t, err)
+	assert.Equal(t, Entry{Name: "web", Repo: "git@me:web.git", Source: personal}, e)
+
+	e, err = Find(dirs, "api")
+	require.NoError(t, err)
+	assert.Equal(t, team, e.Source)
+
+	_, err = Find(dirs, "missing")
+	assert.Error(t, err)
+}
+
+func TestFindMalformed(t *testing.T) {
+	dir := t.TempDir()
+	writeRegistration(t, dir, "bad", "repo: [unclosed\n")
+	_, err := Find([]string{dir}, "bad")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "parse")
+}
+
+func TestList(t *testing.T) {
+	personal, team := t.TempDir(), t.TempDir()
+	writeRegistration(t, personal, "zeta", "name: zeta\n")
+	writeRegistration(t, personal, "web", "repo: mine\n")
+	writeRegistration(t, team, "web", "repo: theirs\n")
+	writeRegistration(t, team, "api", "repo: theirs\n")
+	require.NoError(t, os.MkdirAll(filepath.Join(team, "not-a-project"), 0o755))
+
+	entries := List([]string{personal, team, filepath.Join(team, "missing")})
+	require.Len(t, entries, 3)
+	assert.Equal(t, Entry{Name: "api", Repo: "theirs", Source: team}, entries[0])
+	assert.Equal(t, Entry{Name: "web", Repo: "mine", Source: personal}, entries[1], "personal shadows team")
+	assert.Equal(t, "zeta", entries[2].Name)
+}
+
+func TestResolve(t *testing.T) {
+	dir := t.TempDir()
+	writeRegistration(t, dir, "beta", "repo: b\n")
+	writeRegistration(t, dir, "alpha", "repo: a\n")
+	dirs := []string{dir}
+
+	e, err := Resolve(dirs, "2")
+	require.NoError(t, err)
+	assert.Equal(t, "beta", e.Name)
+
+	e, err = Resolve(dirs, "alpha")
+	require.NoError(t, err)
+	assert.Equal(t, "a", e.Repo)
+
+	for _, bad := range []string{"0", "3", "gamma"} {
+		_, err := Resolve(dirs, bad)
+		assert.Error(t, err, "arg %q", bad)
+	}
+}
+
+func TestSetRepo(t *testing.T) {
+	dir := t.TempDir()
+	writeRegistration(t, dir, "web", "# moved in March\nname: web\nrepo: git@old:web.git\n")
+	require.NoError(t, SetRepo(dir, "web", "git@new:web.git"))
+	data, err := os.ReadFile(YAMLPath(dir, "web"))
+	require.NoError(t, err)
+	assert.Equal(t, "# moved in March\nname: web\nrepo: git@new:web.git\n", string(data))
+
+	writeRegistration(t, dir, "api", "name: api\n")
+	require.NoError(t, SetRepo(dir, "api", "https://example.com/api.git"))
+	e, err := Find([]string{dir}, "api")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/api.git", e.Repo)
+
+	assert.True(t, os.IsNotExist(SetRepo(dir, "none", "x")))
+}
+
+func TestDataDir(t *testing.T) {
+	dir := t.TempDir()
+	writeRegistration(t, dir, "web", "name: web\nrepo: git@me:web.git\n")
+	e, err := Find([]string{dir}, "web")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join("/root", "projects", "web"), DataDir("/root", e))
+
+	require.NoError(t, SetDataDir(dir, "web", "/Volumes/ext/web/"))
+	e, err = Find([]string{dir}, "web")
+	require.NoError(t, err)
+	assert.Equal(t, "/Volumes/ext/web", DataDir("/root", e))
+
+	require.NoError(t, SetDataDir(dir, "web", ""))
+	data, err := os.ReadFile(YAMLPath(dir, "web"))
+	require.NoError(t, err)
+	assert.Equal(t, "name: web\nrepo: git@me:web.git\n", string(data))
+
+	writeRegistration(t, dir, "api", "data_dir: ext/api\n")
+	_, err = Find([]string{dir}, "api")
+	assert.ErrorContains(t, err, `data_dir "ext/api" must be an absolute path`)
+}
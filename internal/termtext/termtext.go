@@ -0,0 +1,431 @@
+// Package termtext renders the raw output of a terminal program, escape
+// sequences and all, into the plain lines a terminal would have been left
+// showing, for grove peek and grove logs --plain (cmd/grove), and, as it
+// arrives, for the daemon's loop detection (internal/daemon).
+//
+// Agents such as claude redraw their interface in place: a spinner rewrites
+// its line after a carriage return, and each frame erases the lines of the
+// last one (erase line, cursor up) before drawing the next.  Stripping the
+// escape sequences from such output leaves every frame ever drawn, run
+// together; Render plays them on a small screen model instead, so that only
+// the last frame remains.
+//
+// The model is a terminal of unbounded width and height: lines do not wrap,
+// and the screen grows downwards as lines are written.  Clearing the screen
+// starts a new one below the old, which is kept as scrollback.  Colours and
+// other attributes, modes and window titles are dropped, and a wide rune
+// takes one column.  A line feed also returns the carriage, since output
+// the daemon writes itself (check and finish output, for one) has bare
+// newlines.
+package termtext
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Render returns the lines data leaves on the screen, with trailing spaces
+// and trailing empty lines removed.
+func Render(data []byte) []string {
+	s := newScreen()
+	s.write(data)
+	return s.text()
+}
+
+// A Screen renders output that arrives in pieces, such as an agent's from
+// its PTY, as Render renders it whole.  The zero value is ready to use.
+type Screen struct {
+	s       *screen
+	pending []byte // an escape sequence or rune cut short by the end of a Write
+	dropped int    // lines Trim has dropped
+	settled []string
+}
+
+// maxPending bounds the unfinished escape sequence a Screen keeps; an OSC
+// string with no end would otherwise be kept forever.
+const maxPending = 4096
+
+// Write draws p on the screen.  It never fails.
+func (sc *Screen) Write(p []byte) (int, error) {
+	if sc.s == nil {
+		sc.s = newScreen()
+	}
+	data := p
+	if len(sc.pending) > 0 {
+		data = append(sc.pending, p...)
+	}
+	done := sc.s.write(data)
+	sc.pending = append(sc.pending[:0], data[done:]...)
+	if len(sc.pending) > maxPending {
+		sc.pending = sc.pending[:0]
+	}
+	return len(p), nil
+}
+
+// Changed reports whether Settled would return anything new: whether a
+// line above the cursor's has been written since it last returned them, or
+// the cursor has moved to another line.  Output that stays on the cursor's
+// line, such as a spinner's, changes nothing.
+func (sc *Screen) Changed() bool {
+	s := sc.s
+	return s != nil && (s.dirty < s.row || s.row != len(sc.settled))
+}
+
+// Settled returns the lines above the cursor's line, with trailing spaces
+// removed, and the number of the first, counting from the first line ever
+// written.  A program changes them only by moving the cursor back up, as
+// one redrawing its interface in place does.  The slice is the Screen's
+// own, valid until the next Write or Trim; only lines written since the
+// last call are rendered again.
+func (sc *Screen) Settled() (first int, lines []string) {
+	s := sc.s
+	if s == nil {
+		return 0, nil
+	}
+	from := min(s.dirty, s.row, len(sc.settled))
+	sc.settled = sc.settled[:from]
+	for _, line := range s.lines[from:s.row] {
+		sc.settled = append(sc.settled, strings.TrimRight(string(line), " "))
+	}
+	s.dirty = s.row
+	return sc.dropped, sc.settled
+}
+
+// Trim drops all but the last keep lines above the cursor's line, so that
+// a Screen fed for long holds only what a program could still redraw.
+func (sc *Screen) Trim(keep int) {
+	s := sc.s
+	if s == nil || s.row <= keep {
+		return
+	}
+	n := s.row - keep
+	s.lines = append(s.lines[:0], s.lines[n:]...)
+	s.row -= n
+	s.top = max(s.top-n, 0)
+	s.savedRow = max(s.savedRow-n, 0)
+	s.dirty = max(s.dirty-n, 0)
+	sc.settled = append(sc.settled[:0], sc.settled[min(n, len(sc.settled)):]...)
+	sc.dropped += n
+}
+
+// Tail returns the last n of lines, or all of them if there are fewer.
+func Tail(lines []string, n int) []string {
+	if n < len(lines) {
+		return lines[len(lines)-n:]
+	}
+	return lines
+}
+
+// screen is the terminal Render draws on.
+type screen struct {
+	lines    [][]rune
+	row, col int
+	top      int // the first line of the screen; those above are scrollback
+
+	savedRow, savedCol int
+
+	// dirty is the first line written since Screen.Settled last rendered
+	// them.
+	dirty int
+
+	// main is the main screen while the alternate screen is shown.
+	main *screen
+}
+
+func newScreen() *screen {
+	return &screen{lines: [][]rune{nil}}
+}
+
+// write draws data and returns how much of it it drew: all of it but an
+// escape sequence or rune cut short at its end.
+func (s *screen) write(data []byte) int {
+	for i := 0; i < len(data); {
+		switch b := data[i]; {
+		case b == 0x1b:
+			next := s.escape(data, i)
+			if next < 0 {
+				return i
+			}
+			i = next
+		case b < 0x20 || b == 0x7f:
+			s.control(b)
+			i++
+		default:
+			if !utf8.FullRune(data[i:]) {
+				return i
+			}
+			r, n := utf8.DecodeRune(data[i:])
+			s.put(r)
+			i += n
+		}
+	}
+	return len(data)
+}
+
+// control handles a control character.
+func (s *screen) control(b byte) {
+	switch b {
+	case '\r':
+		s.col = 0
+	case '\n', '\v', '\f':
+		s.col = 0
+		s.moveTo(s.row+1, 0)
+	case '\b':
+		if s.col > 0 {
+			s.col--
+		}
+	case '\t':
+		s.col = (s.col/8 + 1) * 8
+	}
+}
+
+// put writes r at the cursor and moves it right.
+func (s *screen) put(r rune) {
+	s.touch(s.row)
+	line := s.lines[s.row]
+	for len(line) <= s.col {
+		line = append(line, ' ')
+	}
+	line[s.col] = r
+	s.lines[s.row] = line
+	s.col++
+}
+
+// moveTo moves the cursor to row and col, neither of them above or left of
+// the screen, adding lines to reach row.
+func (s *screen) moveTo(row, col int) {
+	s.row = max(row, s.top)
+	s.col = max(col, 0)
+	for len(s.lines) <= s.row {
+		s.lines = append(s.lines, nil)
+	}
+}
+
+// escape handles the escape sequence at data[i] and returns the index after
+// it, or -1 if data ends before the sequence does.
+func (s *screen) escape(data []byte, i int) int {
+	if i+1 >= len(data) {
+		return -1
+	}
+	switch data[i+1] {
+	case '[':
+		return s.csi(data, i+2)
+	case ']', 'P', 'X', '^', '_':
+		// OSC (window titles, hyperlinks), DCS and the like: a string
+		// ended by BEL or ST (ESC \).
+		for j := i + 2; j < len(data); j++ {
+			if data[j] == 0x07 {
+				return j + 1
+			}
+			if data[j] == 0x1b && j+1 < len(data) && data[j+1] == '\\' {
+				return j + 2
+			}
+		}
+		return -1
+	case '(', ')', '*', '+', '#', '%':
+		// Character set selection and the like: one more byte.
+		if i+2 >= len(data) {
+			return -1
+		}
+		return i + 3
+	case '7':
+		s.savedRow, s.savedCol = s.row, s.col
+	case '8':
+		s.moveTo(s.savedRow, s.savedCol)
+	case 'M':
+		// Reverse index: up a line.
+		s.moveTo(s.row-1, s.col)
+	case 'D', 'E':
+		col := s.col
+		if data[i+1] == 'E' {
+			col = 0
+		}
+		s.moveTo(s.row+1, col)
+	case 'c':
+		s.clear()
+	}
+	return i + 2
+}
+
+// csi handles the control sequence whose parameters start at data[i] and
+// returns the index after it, or -1 if data ends before the sequence does.
+func (s *screen) csi(data []byte, i int) int {
+	start := i
+	for i < len(data) && data[i] >= 0x30 && data[i] <= 0x3f {
+		i++
+	}
+	params := string(data[start:i])
+	// Intermediate bytes, as in CSI ! p (soft reset), change nothing here.
+	for i < len(data) && data[i] >= 0x20 && data[i] <= 0x2f {
+		i++
+	}
+	if i >= len(data) {
+		return -1
+	}
+	final := data[i]
+	if strings.HasPrefix(params, "?") {
+		if final == 'h' || final == 'l' {
+			s.privateMode(params[1:], final == 'h')
+		}
+		return i + 1
+	}
+	if params != "" && (params[0] < '0' || params[0] > '9') && params[0] != ';' {
+		// Other private sequences (CSI > c, CSI = c ...) are queries.
+		return i + 1
+	}
+
+	args := strings.Split(params, ";")
+	// arg returns the nth parameter, def if it is missing or zero.
+	arg := func(n, def int) int {
+		if n < len(args) {
+			if v, err := strconv.Atoi(args[n]); err == nil && v > 0 {
+				return v
+			}
+		}
+		return def
+	}
+	switch final {
+	case 'A':
+		s.moveTo(s.row-arg(0, 1), s.col)
+	case 'B', 'e':
+		s.moveTo(s.row+arg(0, 1), s.col)
+	case 'C', 'a':
+		s.col += arg(0, 1)
+	case 'D':
+		s.col = max(s.col-arg(0, 1), 0)
+	case 'E':
+		s.moveTo(s.row+arg(0, 1), 0)
+	case 'F':
+		s.moveTo(s.row-arg(0, 1), 0)
+	case 'G', '`':
+		s.col = arg(0, 1) - 1
+	case 'd':
+		s.moveTo(s.top+arg(0, 1)-1, s.col)
+	case 'H', 'f':
+		s.moveTo(s.top+arg(0, 1)-1, arg(1, 1)-1)
+	case 'K':
+		s.eraseLine(arg(0, 0))
+	case 'J':
+		s.eraseDisplay(arg(0, 0))
+	case 'X':
+		s.blank(s.row, s.col, s.col+arg(0, 1))
+	case 'P':
+		s.touch(s.row)
+		line := s.lines[s.row]
+		if s.col < len(line) {
+			n := min(arg(0, 1), len(line)-s.col)
+			s.lines[s.row] = append(line[:s.col], line[s.col+n:]...)
+		}
+	case '@':
+		s.touch(s.row)
+		line := s.lines[s.row]
+		if s.col < len(line) {
+			gap := []rune(strings.Repeat(" ", arg(0, 1)))
+			s.lines[s.row] = append(line[:s.col], append(gap, line[s.col:]...)...)
+		}
+	case 's':
+		s.savedRow, s.savedCol = s.row, s.col
+	case 'u':
+		s.moveTo(s.savedRow, s.savedCol)
+	}
+	// m (colours), r (scrolling region), n (status reports) and the rest
+	// change nothing on this screen.
+	return i + 1
+}
+
+// privateMode handles DEC private mode changes: of those, only the
+// alternate screen matters.  Programs that use it, such as editors and
+// pagers, leave the main screen as it was when they exit.
+func (s *screen) privateMode(params string, set bool) {
+	for _, p := range strings.Split(params, ";") {
+		if p != "1049" && p != "1047" && p != "47" {
+			continue
+		}
+		switch {
+		case set && s.main == nil:
+			main := *s
+			*s = screen{lines: [][]rune{nil}, main: &main}
+		case !set && s.main != nil:
+			*s = *s.main
+			s.dirty = 0
+		}
+	}
+}
+
+// touch records that row has been written.
+func (s *screen) touch(row int) {
+	s.dirty = min(s.dirty, row)
+}
+
+// eraseLine handles CSI K: mode 0 erases from the cursor to the end of the
+// line, 1 from its start to the cursor, 2 all of it.
+func (s *screen) eraseLine(mode int) {
+	s.touch(s.row)
+	switch mode {
+	case 0:
+		if s.col < len(s.lines[s.row]) {
+			s.lines[s.row] = s.lines[s.row][:s.col]
+		}
+	case 1:
+		s.blank(s.row, 0, s.col+1)
+	case 2:
+		s.lines[s.row] = nil
+	}
+}
+
+// eraseDisplay handles CSI J: mode 0 erases from the cursor to the end of
+// the screen, 1 from its start to the cursor, 2 and 3 all of it.
+func (s *screen) eraseDisplay(mode int) {
+	switch mode {
+	case 0:
+		s.eraseLine(0)
+		s.lines = s.lines[:s.row+1]
+	case 1:
+		for r := s.top; r < s.row; r++ {
+			s.lines[r] = nil
+		}
+		s.touch(s.top)
+		s.blank(s.row, 0, s.col+1)
+	default:
+		s.clear()
+	}
+}
+
+// clear starts a new, empty screen below the current one, keeping the
+// cursor's column.
+func (s *screen) clear() {
+	last := len(s.lines)
+	for last > 0 && len(s.lines[last-1]) == 0 {
+		last--
+	}
+	s.lines = append(s.lines[:last], nil)
+	s.top = last
+	s.row = last
+	s.touch(last)
+}
+
+// blank replaces columns from to to of row, as far as the line goes, with
+// spaces.
+func (s *screen) blank(row, from, to int) {
+	s.touch(row)
+	line := s.lines[row]
+	for c := from; c < to && c < len(line); c++ {
+		line[c] = ' '
+	}
+}
+
+// text returns the screen's lines, scrollback included, with trailing
+// spaces and trailing empty lines removed.  On the alternate screen, that
+// is what is showing.
+func (s *screen) text() []string {
+	out := make([]string, len(s.lines))
+	last := 0
+	for i, line := range s.lines {
+		out[i] = strings.TrimRight(string(line), " ")
+		if out[i] != "" {
+			last = i + 1
+		}
+	}
+	return out[:last]
+}
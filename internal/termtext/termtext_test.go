@@ -0,0 +1,144 @@
+package termtext_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gandalfthegui/grove/internal/termtext"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRenderFixtures renders each testdata/*.raw, output as a PTY delivers
+// it, and compares the result with the .txt beside it.
+func TestRenderFixtures(t *testing.T) {
+	raws, err := filepath.Glob(filepath.Join("testdata", "*.raw"))
+	require.NoError(t, err)
+	require.NotEmpty(t, raws)
+	for _, raw := range raws {
+		t.Run(filepath.Base(raw), func(t *testing.T) {
+			data, err := os.ReadFile(raw)
+			require.NoError(t, err)
+			want, err := os.ReadFile(strings.TrimSuffix(raw, ".raw") + ".txt")
+			require.NoError(t, err)
+			assert.Equal(t, string(want), strings.Join(termtext.Render(data), "\n")+"\n")
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		in   string
+		want []string
+	}{
+		{"plain", "a\nb\n", []string{"a", "b"}},
+		{"carriage return", "50%\r100%\r\n", []string{"100%"}},
+		{"shorter after carriage return", "abcdef\rxy\n", []string{"xycdef"}},
+		{"erase to end of line", "abcdef\rxy\x1b[K\n", []string{"xy"}},
+		{"erase line, cursor up", "one\r\ntwo\r\n\x1b[2K\x1b[1A\x1b[2K\x1b[1A\x1b[2K\x1b[Gthree\r\n", []string{"three"}},
+		{"up past the top", "a\x1b[5Ab", []string{"ab"}},
+		{"colours dropped", "\x1b[1;31mred\x1b[0m \x1b[38;2;1;2;3mrgb\x1b[39m", []string{"red rgb"}},
+		{"titles dropped", "\x1b]0;title\x07a\x1b]8;;http://x\x1b\\b", []string{"ab"}},
+		{"modes dropped", "\x1b[?25l\x1b[?2004ha\x1b[?2026h\x1b[?25h", []string{"a"}},
+		{"column", "abcdef\x1b[3GX", []string{"abXdef"}},
+		{"forward and back", "ab\x1b[2Cc\x1b[4Dd", []string{"ad  c"}},
+		{"position", "x\n\x1b[2J\x1b[Hfirst\x1b[3;2Hthird", []string{"x", "first", "", " third"}},
+		{"erase below", "a\nb\nc\x1b[2;1H\x1b[J", []string{"a"}},
+		{"delete and insert", "abcdef\x1b[3G\x1b[2P\x1b[1G\x1b[1@", []string{" abef"}},
+		{"save and restore", "a\x1b7\nb\x1b8c", []string{"ac", "b"}},
+		{"alternate screen", "before\n\x1b[?1049hvim\x1b[?1049lafter", []string{"before", "after"}},
+		{"still on the alternate screen", "before\n\x1b[?1049hless", []string{"less"}},
+		{"unfinished sequence", "a\x1b[3", []string{"a"}},
+		{"trailing blank lines", "a  \n\n\n", []string{"a"}},
+		{"nothing", "\x1b[2J", nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := termtext.Render([]byte(tc.in))
+			if len(tc.want) == 0 {
+				assert.Empty(t, got)
+				return
+			}
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+// TestScreenPieces checks that a Screen fed each fixture a few bytes at a
+// time, cutting escape sequences and runes, settles on what Render renders.
+func TestScreenPieces(t *testing.T) {
+	raws, err := filepath.Glob(filepath.Join("testdata", "*.raw"))
+	require.NoError(t, err)
+	for _, raw := range raws {
+		t.Run(filepath.Base(raw), func(t *testing.T) {
+			data, err := os.ReadFile(raw)
+			require.NoError(t, err)
+			var sc termtext.Screen
+			for i := 0; i < len(data); i += 7 {
+				sc.Write(data[i:min(i+7, len(data))])
+			}
+			first, settled := sc.Settled()
+			assert.Zero(t, first)
+			for len(settled) > 0 && settled[len(settled)-1] == "" {
+				settled = settled[:len(settled)-1]
+			}
+			want := termtext.Render(data)
+			require.LessOrEqual(t, len(settled), len(want))
+			assert.Equal(t, want[:len(settled)], settled)
+			assert.GreaterOrEqual(t, len(settled), len(want)-1, "all but the cursor's line")
+		})
+	}
+}
+
+func TestScreenSettled(t *testing.T) {
+	var sc termtext.Screen
+	first, lines := sc.Settled()
+	assert.Zero(t, first)
+	assert.Empty(t, lines)
+
+	sc.Write([]byte("a\nb\nc\nd\ne"))
+	first, lines = sc.Settled()
+	assert.Equal(t, 0, first)
+	assert.Equal(t, []string{"a", "b", "c", "d"}, lines, "not the cursor's line")
+
+	sc.Trim(2)
+	first, lines = sc.Settled()
+	assert.Equal(t, 2, first)
+	assert.Equal(t, []string{"c", "d"}, lines)
+
+	// Redrawing moves the cursor up, as far as the lines kept go.
+	sc.Write([]byte("\x1b[2K\x1b[1A\x1b[2K\x1b[1A\x1b[2K\x1b[1A\x1b[2K\x1b[Gx\n"))
+	first, lines = sc.Settled()
+	assert.Equal(t, 2, first)
+	assert.Equal(t, []string{"x"}, lines)
+}
+
+func TestScreenChanged(t *testing.T) {
+	var sc termtext.Screen
+	assert.False(t, sc.Changed())
+	sc.Write([]byte("a\nb"))
+	assert.True(t, sc.Changed())
+	sc.Settled()
+	assert.False(t, sc.Changed())
+
+	// A spinner stays on the cursor's line.
+	sc.Write([]byte("\r⠋ working\r⠙ working\x1b[K"))
+	assert.False(t, sc.Changed())
+
+	// Going up and back down may have redrawn a settled line.
+	sc.Write([]byte("\x1b[1A\x1b[2K\x1b[Gc\n"))
+	assert.True(t, sc.Changed())
+	_, lines := sc.Settled()
+	assert.Equal(t, []string{"c"}, lines)
+
+	sc.Write([]byte("\x1b[2J"))
+	assert.True(t, sc.Changed())
+}
+
+func TestTail(t *testing.T) {
+	lines := []string{"a", "b", "c"}
+	assert.Equal(t, []string{"b", "c"}, termtext.Tail(lines, 2))
+	assert.Equal(t, lines, termtext.Tail(lines, 10))
+}
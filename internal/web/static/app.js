@@ -0,0 +1,310 @@
+// app.js – the grove web UI: the instance table, its actions, logs and
+// attach.  It talks to the JSON API and websockets of internal/web.
+"use strict";
+
+const $ = (sel) => document.querySelector(sel);
+
+// Attach frame types, as in pkg/proto.
+const FRAME_DATA = 0x00, FRAME_RESIZE = 0x01, FRAME_DETACH = 0x02,
+  FRAME_STATE = 0x03, FRAME_EXIT = 0x04;
+
+const TERMINAL = new Set(["EXITED", "CRASHED", "KILLED", "FINISHED", "FINISH_FAILED", "FAILED_SETUP", "STALLED", "BROKEN"]);
+
+let instances = [];
+let attached = null; // the open attach: { ws, term, fit }
+
+async function api(method, path) {
+  const resp = await fetch(path, { method, credentials: "same-origin" });
+  const ct = resp.headers.get("Content-Type") || "";
+  const body = ct.startsWith("application/json") ? await resp.json() : await resp.text();
+  if (!resp.ok) {
+    const msg = typeof body === "string" ? body : body.error + (body.hint ? "\n" + body.hint : "");
+    throw new Error(msg);
+  }
+  return body;
+}
+
+function showError(err) {
+  const el = $("#error");
+  el.textContent = err ? String(err.message || err) : "";
+  el.hidden = !err;
+}
+
+function since(unix) {
+  if (!unix) return "";
+  const s = Math.max(0, Math.floor(Date.now() / 1000 - unix));
+  if (s < 60) return s + "s";
+  if (s < 3600) return Math.floor(s / 60) + "m";
+  if (s < 86400) return Math.floor(s / 3600) + "h";
+  return Math.floor(s / 86400) + "d";
+}
+
+function button(label, onClick, opts = {}) {
+  const b = document.createElement("button");
+  b.textContent = label;
+  if (opts.danger) b.className = "danger";
+  b.disabled = !!opts.disabled;
+  b.addEventListener("click", onClick);
+  return b;
+}
+
+function cell(tr, text, cls) {
+  const td = tr.insertCell();
+  td.textContent = text || "";
+  if (cls) td.className = cls;
+  return td;
+}
+
+async function refresh() {
+  try {
+    instances = await api("GET", "api/instances");
+    showError(null);
+  } catch (err) {
+    showError(err);
+    return;
+  }
+  render();
+}
+
+function render(flashID) {
+  const tbody = $("#instances tbody");
+  tbody.replaceChildren();
+  for (const inst of instances) {
+    const tr = tbody.insertRow();
+    if (inst.id === flashID) tr.className = "flash";
+    cell(tr, inst.id, "id");
+    cell(tr, inst.project);
+    const st = cell(tr, inst.state, "state state-" + inst.state);
+    if (inst.checking && inst.checking.command) st.title = "running " + inst.checking.command;
+    cell(tr, inst.branch);
+    const task = cell(tr, inst.summary || inst.task, "task");
+    task.title = inst.summary || inst.task || "";
+    cell(tr, since(inst.state_since || inst.created_at), "dim");
+
+    const ended = TERMINAL.has(inst.state);
+    const actions = tr.insertCell();
+    actions.className = "actions";
+    actions.append(
+      button("Attach", () => attach(inst), { disabled: ended }),
+      " ",
+      button("Logs", () => showLogs(inst)),
+      " ",
+      button("Check", (e) => runAction(inst, "check", e.target), { disabled: ended }),
+      " ",
+      button("Finish", (e) => runAction(inst, "finish", e.target), { disabled: ended }),
+      " ",
+      button("Stop", (e) => runAction(inst, "stop", e.target), { disabled: ended }),
+      " ",
+      button("Drop", (e) => {
+        if (confirm(`Drop instance ${inst.id} (${inst.branch})? This removes its worktree.`)) runAction(inst, "drop", e.target);
+      }, { danger: true }),
+    );
+  }
+  $("#empty").hidden = instances.length > 0;
+}
+
+async function runAction(inst, action, btn) {
+  btn.disabled = true;
+  const label = btn.textContent;
+  btn.textContent = action === "check" ? "Checking…" : action === "finish" ? "Finishing…" : label;
+  try {
+    const res = await api("POST", `api/instances/${encodeURIComponent(inst.id)}/${action}`);
+    if (action === "check" || action === "finish") {
+      openPanel(`${action} ${inst.id}: ${res.ok ? "passed" : "failed"}`);
+      const text = $("#panel-text");
+      text.innerHTML = ansiToHtml(res.output || "(no output)");
+      text.hidden = false;
+    }
+    showError(null);
+  } catch (err) {
+    showError(err);
+  } finally {
+    btn.textContent = label;
+    btn.disabled = false;
+    refresh();
+  }
+}
+
+// ─── Panel: logs and attach ────────────────────────────────────────────────
+
+function openPanel(title) {
+  closePanel();
+  $("#panel-title").textContent = title;
+  $("#panel-status").textContent = "";
+  $("#panel").hidden = false;
+}
+
+function closePanel() {
+  if (attached) {
+    sendFrame(attached.ws, FRAME_DETACH);
+    attached.ws.close();
+    attached.term.dispose();
+    attached = null;
+  }
+  $("#panel").hidden = true;
+  $("#panel-text").hidden = true;
+  $("#panel-text").replaceChildren();
+  $("#panel-term").hidden = true;
+  $("#panel-term").replaceChildren();
+  $("#panel-detach").hidden = true;
+}
+
+async function showLogs(inst) {
+  openPanel(`logs ${inst.id}`);
+  const text = $("#panel-text");
+  text.hidden = false;
+  text.textContent = "loading…";
+  try {
+    const log = await api("GET", `api/instances/${encodeURIComponent(inst.id)}/logs`);
+    text.innerHTML = ansiToHtml(log || "(empty)");
+    text.scrollTop = text.scrollHeight;
+  } catch (err) {
+    text.textContent = String(err.message || err);
+  }
+}
+
+function sendFrame(ws, type, payload) {
+  if (ws.readyState !== WebSocket.OPEN) return;
+  payload = payload || new Uint8Array(0);
+  const frame = new Uint8Array(5 + payload.length);
+  frame[0] = type;
+  new DataView(frame.buffer).setUint32(1, payload.length);
+  frame.set(payload, 5);
+  ws.send(frame);
+}
+
+function sendResize(ws, cols, rows) {
+  const p = new Uint8Array(4);
+  new DataView(p.buffer).setUint16(0, cols);
+  new DataView(p.buffer).setUint16(2, rows);
+  sendFrame(ws, FRAME_RESIZE, p);
+}
+
+// newTerminal returns xterm.js if it is vendored, else MiniTerm, with a fit
+// function resizing it to its element.
+function newTerminal(el) {
+  if (window.Terminal) {
+    const term = new window.Terminal({ cursorBlink: true, fontSize: 13 });
+    let fit = () => false;
+    if (window.FitAddon) {
+      const addon = new window.FitAddon.FitAddon();
+      term.loadAddon(addon);
+      fit = () => {
+        const { cols, rows } = term;
+        addon.fit();
+        return term.cols !== cols || term.rows !== rows;
+      };
+    }
+    term.open(el);
+    return { term, fit };
+  }
+  const term = new MiniTerm();
+  term.open(el);
+  return { term, fit: () => term.fit() };
+}
+
+function attach(inst) {
+  openPanel(`attach ${inst.id}`);
+  const el = $("#panel-term");
+  el.hidden = false;
+  const { term, fit } = newTerminal(el);
+  const scheme = location.protocol === "https:" ? "wss:" : "ws:";
+  const ws = new WebSocket(`${scheme}//${location.host}/api/instances/${encodeURIComponent(inst.id)}/attach`);
+  ws.binaryType = "arraybuffer";
+  attached = { ws, term, fit };
+  const status = $("#panel-status");
+  status.textContent = "connecting…";
+  $("#panel-detach").hidden = false;
+
+  const encoder = new TextEncoder();
+  term.onData((data) => sendFrame(ws, FRAME_DATA, encoder.encode(data)));
+
+  let pending = new Uint8Array(0);
+  ws.onopen = () => {
+    status.textContent = "attached";
+    fit();
+    sendResize(ws, term.cols, term.rows);
+    term.focus();
+  };
+  ws.onmessage = (e) => {
+    const chunk = new Uint8Array(e.data);
+    const buf = new Uint8Array(pending.length + chunk.length);
+    buf.set(pending);
+    buf.set(chunk, pending.length);
+    let off = 0;
+    while (buf.length - off >= 5) {
+      const len = new DataView(buf.buffer, off + 1, 4).getUint32(0);
+      if (buf.length - off - 5 < len) break;
+      const type = buf[off];
+      const payload = buf.subarray(off + 5, off + 5 + len);
+      off += 5 + len;
+      if (type === FRAME_DATA) {
+        term.write(payload.slice());
+      } else if (type === FRAME_STATE) {
+        try {
+          status.textContent = "attached · " + JSON.parse(new TextDecoder().decode(payload)).state;
+        } catch (_) {}
+      } else if (type === FRAME_EXIT) {
+        try {
+          const exit = JSON.parse(new TextDecoder().decode(payload));
+          status.textContent = `agent ${exit.state.toLowerCase()} (exit code ${exit.exit_code})`;
+        } catch (_) {}
+      }
+    }
+    pending = buf.slice(off);
+  };
+  ws.onclose = (e) => {
+    if (attached && attached.ws === ws) {
+      $("#panel-detach").hidden = true;
+      if (e.reason) status.textContent = e.reason;
+      else if (status.textContent.startsWith("attached")) status.textContent = "detached";
+    }
+    refresh();
+  };
+}
+
+window.addEventListener("resize", () => {
+  if (!attached) return;
+  if (attached.fit()) sendResize(attached.ws, attached.term.cols, attached.term.rows);
+});
+
+$("#panel-close").addEventListener("click", closePanel);
+$("#panel-detach").addEventListener("click", () => {
+  if (!attached) return;
+  sendFrame(attached.ws, FRAME_DETACH);
+});
+
+// ─── Live updates ──────────────────────────────────────────────────────────
+
+// watchEvents refreshes the table on each of the daemon's events, and
+// reconnects if the socket drops (e.g. across a daemon restart).
+function watchEvents() {
+  const scheme = location.protocol === "https:" ? "wss:" : "ws:";
+  const ws = new WebSocket(`${scheme}//${location.host}/api/events`);
+  const live = $("#live");
+  ws.onopen = () => {
+    live.textContent = "live";
+    live.className = "live on";
+    refresh();
+  };
+  ws.onmessage = (e) => {
+    let ev;
+    try {
+      ev = JSON.parse(e.data);
+    } catch (_) {
+      return;
+    }
+    refresh().then(() => render(ev.instance_id));
+  };
+  ws.onclose = () => {
+    live.textContent = "reconnecting…";
+    live.className = "live";
+    setTimeout(watchEvents, 3000);
+  };
+}
+
+// Not every state change is an event (an agent going idle is not), so the
+// table is also refreshed now and then.
+setInterval(refresh, 5000);
+refresh();
+watchEvents();
@@ -0,0 +1,507 @@
+// term.js – ANSI rendering for the grove web UI: ansiToHtml turns log
+// output into HTML, and MiniTerm is the terminal attach falls back on when
+// xterm.js is not vendored.  MiniTerm handles what agents' TUIs commonly
+// use (cursor movement, erasing, scroll regions, the alternate screen and
+// SGR colours); anything else is ignored.
+"use strict";
+
+const ANSI_16 = [
+  "#000000", "#cd3131", "#0dbc79", "#e5e510", "#2472c8", "#bc3fbc", "#11a8cd", "#e5e5e5",
+  "#666666", "#f14c4c", "#23d18b", "#f5f543", "#3b8eea", "#d670d6", "#29b8db", "#ffffff",
+];
+
+// color256 returns the CSS colour of entry n of the xterm 256-colour palette.
+function color256(n) {
+  if (n < 16) return ANSI_16[n];
+  if (n < 232) {
+    n -= 16;
+    const level = (v) => (v === 0 ? 0 : 55 + v * 40);
+    return `rgb(${level(Math.floor(n / 36))},${level(Math.floor(n / 6) % 6)},${level(n % 6)})`;
+  }
+  const g = 8 + (n - 232) * 10;
+  return `rgb(${g},${g},${g})`;
+}
+
+function defaultStyle() {
+  return { fg: null, bg: null, bold: false, dim: false, italic: false, underline: false, inverse: false };
+}
+
+// applySGR updates style with the parameters of an SGR (CSI … m) sequence.
+function applySGR(style, params) {
+  if (params.length === 0) params = [0];
+  for (let i = 0; i < params.length; i++) {
+    const p = params[i] || 0;
+    if (p === 0) Object.assign(style, defaultStyle());
+    else if (p === 1) style.bold = true;
+    else if (p === 2) style.dim = true;
+    else if (p === 3) style.italic = true;
+    else if (p === 4) style.underline = true;
+    else if (p === 7) style.inverse = true;
+    else if (p === 22) style.bold = style.dim = false;
+    else if (p === 23) style.italic = false;
+    else if (p === 24) style.underline = false;
+    else if (p === 27) style.inverse = false;
+    else if (p >= 30 && p <= 37) style.fg = ANSI_16[p - 30];
+    else if (p === 39) style.fg = null;
+    else if (p >= 40 && p <= 47) style.bg = ANSI_16[p - 40];
+    else if (p === 49) style.bg = null;
+    else if (p >= 90 && p <= 97) style.fg = ANSI_16[p - 90 + 8];
+    else if (p >= 100 && p <= 107) style.bg = ANSI_16[p - 100 + 8];
+    else if (p === 38 || p === 48) {
+      let c = null;
+      if (params[i + 1] === 5) {
+        c = color256(params[i + 2] || 0);
+        i += 2;
+      } else if (params[i + 1] === 2) {
+        c = `rgb(${params[i + 2] || 0},${params[i + 3] || 0},${params[i + 4] || 0})`;
+        i += 4;
+      }
+      if (p === 38) style.fg = c;
+      else style.bg = c;
+    }
+  }
+}
+
+// styleCSS returns the inline CSS of style, or "" for the default.
+function styleCSS(style) {
+  let fg = style.fg, bg = style.bg;
+  if (style.inverse) {
+    [fg, bg] = [bg || "var(--bg, #000)", fg || "var(--fg, #ddd)"];
+  }
+  let css = "";
+  if (fg) css += `color:${fg};`;
+  if (bg) css += `background:${bg};`;
+  if (style.bold) css += "font-weight:bold;";
+  if (style.dim) css += "opacity:.6;";
+  if (style.italic) css += "font-style:italic;";
+  if (style.underline) css += "text-decoration:underline;";
+  return css;
+}
+
+function escapeHtml(s) {
+  return s.replace(/[&<>"]/g, (c) => ({ "&": "&amp;", "<": "&lt;", ">": "&gt;", '"': "&quot;" })[c]);
+}
+
+// ansiToHtml renders text with SGR colours as HTML.  Other escape sequences
+// are dropped, and a carriage return overwrites the line as a terminal
+// would, so progress bars show their last state.
+function ansiToHtml(text) {
+  const style = defaultStyle();
+  let out = "";
+  let open = false;
+  const span = () => {
+    if (open) out += "</span>";
+    const css = styleCSS(style);
+    open = css !== "";
+    if (open) out += `<span style="${css}">`;
+  };
+  const lines = text.replace(/\r\n/g, "\n").split("\n");
+  lines.forEach((line, n) => {
+    if (n > 0) out += "\n";
+    const cr = line.lastIndexOf("\r");
+    if (cr >= 0 && cr < line.length - 1) line = line.slice(cr + 1);
+    const re = /\x1b(?:\[([0-9;?]*)([@-~])|\][^\x07\x1b]*(?:\x07|\x1b\\)?|[()][0-9A-Za-z]|[@-_])/g;
+    let last = 0, m;
+    while ((m = re.exec(line)) !== null) {
+      out += escapeHtml(line.slice(last, m.index).replace(/[\x00-\x08\x0b-\x1f\x7f]/g, ""));
+      last = re.lastIndex;
+      if (m[2] === "m" && !(m[1] || "").startsWith("?")) {
+        applySGR(style, (m[1] || "").split(";").map((p) => parseInt(p, 10) || 0));
+        span();
+      }
+    }
+    out += escapeHtml(line.slice(last).replace(/[\x00-\x08\x0b-\x1f\x7f]/g, ""));
+  });
+  if (open) out += "</span>";
+  return out;
+}
+
+// MiniTerm is a small terminal emulator with the part of xterm.js's API
+// app.js uses: open, write, onData, resize, focus and dispose.
+class MiniTerm {
+  constructor() {
+    this.cols = 80;
+    this.rows = 24;
+    this.listeners = [];
+    this.decoder = new TextDecoder();
+    this.state = "ground";
+    this.seq = "";
+    this.reset();
+  }
+
+  reset() {
+    this.style = defaultStyle();
+    this.main = this.blankScreen();
+    this.alt = null;
+    this.screen = this.main;
+    this.x = this.y = 0;
+    this.saved = { x: 0, y: 0 };
+    this.top = 0;
+    this.bottom = this.rows - 1;
+    this.cursorVisible = true;
+    this.wrapPending = false;
+  }
+
+  blankCell() {
+    return { ch: " ", style: this.style ? { ...this.style, inverse: false } : defaultStyle() };
+  }
+
+  blankRow() {
+    const row = [];
+    for (let i = 0; i < this.cols; i++) row.push(this.blankCell());
+    return row;
+  }
+
+  blankScreen() {
+    const screen = [];
+    for (let i = 0; i < this.rows; i++) screen.push(this.blankRow());
+    return screen;
+  }
+
+  open(el) {
+    this.el = el;
+    this.pre = document.createElement("pre");
+    this.pre.className = "miniterm";
+    el.appendChild(this.pre);
+    this.keyHandler = (e) => this.onKey(e);
+    this.pasteHandler = (e) => {
+      e.preventDefault();
+      this.emit(e.clipboardData.getData("text"));
+    };
+    el.addEventListener("keydown", this.keyHandler);
+    el.addEventListener("paste", this.pasteHandler);
+    this.render();
+  }
+
+  onData(fn) {
+    this.listeners.push(fn);
+  }
+
+  emit(data) {
+    this.listeners.forEach((fn) => fn(data));
+  }
+
+  focus() {
+    if (this.el) this.el.focus();
+  }
+
+  dispose() {
+    if (!this.el) return;
+    this.el.removeEventListener("keydown", this.keyHandler);
+    this.el.removeEventListener("paste", this.pasteHandler);
+    this.pre.remove();
+    this.el = null;
+  }
+
+  // fit resizes the terminal to fill its element and returns whether the
+  // size changed.
+  fit() {
+    if (!this.el) return false;
+    const probe = document.createElement("span");
+    probe.textContent = "M".repeat(10);
+    this.pre.appendChild(probe);
+    const cw = probe.getBoundingClientRect().width / 10;
+    const ch = probe.getBoundingClientRect().height;
+    probe.remove();
+    if (!cw || !ch) return false;
+    const cols = Math.max(20, Math.floor(this.el.clientWidth / cw) - 1);
+    const rows = Math.max(5, Math.floor(this.el.clientHeight / ch) - 1);
+    if (cols === this.cols && rows === this.rows) return false;
+    this.resize(cols, rows);
+    return true;
+  }
+
+  resize(cols, rows) {
+    const fix = (screen) => {
+      if (!screen) return screen;
+      while (screen.length > rows) screen.shift();
+      while (screen.length < rows) screen.push([]);
+      return screen.map((row) => {
+        row = row.slice(0, cols);
+        while (row.length < cols) row.push(this.blankCell());
+        return row;
+      });
+    };
+    const onAlt = this.screen === this.alt;
+    this.cols = cols;
+    this.rows = rows;
+    this.main = fix(this.main);
+    this.alt = fix(this.alt);
+    this.screen = onAlt ? this.alt : this.main;
+    this.top = 0;
+    this.bottom = rows - 1;
+    this.x = Math.min(this.x, cols - 1);
+    this.y = Math.min(this.y, rows - 1);
+    this.render();
+  }
+
+  write(data) {
+    const text = typeof data === "string" ? data : this.decoder.decode(data, { stream: true });
+    for (const c of text) this.feed(c);
+    this.scheduleRender();
+  }
+
+  feed(c) {
+    const code = c.codePointAt(0);
+    switch (this.state) {
+      case "esc":
+        this.state = "ground";
+        if (c === "[") {
+          this.state = "csi";
+          this.seq = "";
+        } else if (c === "]") {
+          this.state = "osc";
+        } else if (c === "(" || c === ")") {
+          this.state = "charset";
+        } else this.escape(c);
+        return;
+      case "csi":
+        if (code >= 0x40 && code <= 0x7e) {
+          this.state = "ground";
+          this.csi(this.seq, c);
+        } else this.seq += c;
+        return;
+      case "osc":
+        if (c === "\x07") this.state = "ground";
+        else if (c === "\x1b") this.state = "osc-esc";
+        return;
+      case "osc-esc":
+        this.state = c === "\\" ? "ground" : "osc";
+        return;
+      case "charset":
+        this.state = "ground";
+        return;
+    }
+    if (code < 0x20 || code === 0x7f) {
+      this.control(c);
+      return;
+    }
+    if (this.wrapPending) {
+      this.x = 0;
+      this.lineFeed();
+      this.wrapPending = false;
+    }
+    this.screen[this.y][this.x] = { ch: c, style: { ...this.style } };
+    if (this.x === this.cols - 1) this.wrapPending = true;
+    else this.x++;
+  }
+
+  control(c) {
+    switch (c) {
+      case "\x1b": this.state = "esc"; break;
+      case "\r": this.x = 0; this.wrapPending = false; break;
+      case "\n": case "\x0b": case "\x0c": this.lineFeed(); break;
+      case "\b": if (this.x > 0) this.x--; this.wrapPending = false; break;
+      case "\t": this.x = Math.min(this.cols - 1, (Math.floor(this.x / 8) + 1) * 8); break;
+    }
+  }
+
+  lineFeed() {
+    this.wrapPending = false;
+    if (this.y === this.bottom) this.scrollUp(1);
+    else if (this.y < this.rows - 1) this.y++;
+  }
+
+  scrollUp(n) {
+    for (let i = 0; i < n; i++) {
+      this.screen.splice(this.top, 1);
+      this.screen.splice(this.bottom, 0, this.blankRow());
+    }
+  }
+
+  scrollDown(n) {
+    for (let i = 0; i < n; i++) {
+      this.screen.splice(this.bottom, 1);
+      this.screen.splice(this.top, 0, this.blankRow());
+    }
+  }
+
+  escape(c) {
+    switch (c) {
+      case "7": this.saved = { x: this.x, y: this.y, style: { ...this.style } }; break;
+      case "8":
+        this.x = this.saved.x;
+        this.y = this.saved.y;
+        if (this.saved.style) this.style = { ...this.saved.style };
+        break;
+      case "D": this.lineFeed(); break;
+      case "E": this.x = 0; this.lineFeed(); break;
+      case "M":
+        if (this.y === this.top) this.scrollDown(1);
+        else if (this.y > 0) this.y--;
+        break;
+      case "c": this.reset(); break;
+    }
+  }
+
+  setAltScreen(on) {
+    if (on && this.screen !== this.alt) {
+      this.saved = { x: this.x, y: this.y, style: { ...this.style } };
+      this.alt = this.blankScreen();
+      this.screen = this.alt;
+    } else if (!on && this.screen === this.alt) {
+      this.screen = this.main;
+      this.alt = null;
+      this.x = this.saved.x;
+      this.y = this.saved.y;
+    }
+  }
+
+  erase(row, from, to) {
+    for (let x = from; x < to; x++) this.screen[row][x] = this.blankCell();
+  }
+
+  csi(seq, final) {
+    const priv = seq.startsWith("?");
+    const params = (priv ? seq.slice(1) : seq).split(";").map((p) => parseInt(p, 10));
+    const n = params[0] || 1;
+    const clampX = (x) => Math.max(0, Math.min(this.cols - 1, x));
+    const clampY = (y) => Math.max(0, Math.min(this.rows - 1, y));
+    this.wrapPending = false;
+    if (priv) {
+      if (final === "h" || final === "l") {
+        const on = final === "h";
+        params.forEach((p) => {
+          if (p === 25) this.cursorVisible = on;
+          else if (p === 47 || p === 1047 || p === 1049) this.setAltScreen(on);
+        });
+      }
+      return;
+    }
+    switch (final) {
+      case "A": this.y = Math.max(this.y - n, 0); break;
+      case "B": case "e": this.y = clampY(this.y + n); break;
+      case "C": case "a": this.x = clampX(this.x + n); break;
+      case "D": this.x = clampX(this.x - n); break;
+      case "E": this.x = 0; this.y = clampY(this.y + n); break;
+      case "F": this.x = 0; this.y = clampY(this.y - n); break;
+      case "G": case "`": this.x = clampX(n - 1); break;
+      case "d": this.y = clampY(n - 1); break;
+      case "H": case "f":
+        this.y = clampY((params[0] || 1) - 1);
+        this.x = clampX((params[1] || 1) - 1);
+        break;
+      case "J": {
+        const mode = params[0] || 0;
+        if (mode === 0) {
+          this.erase(this.y, this.x, this.cols);
+          for (let y = this.y + 1; y < this.rows; y++) this.erase(y, 0, this.cols);
+        } else if (mode === 1) {
+          for (let y = 0; y < this.y; y++) this.erase(y, 0, this.cols);
+          this.erase(this.y, 0, this.x + 1);
+        } else {
+          for (let y = 0; y < this.rows; y++) this.erase(y, 0, this.cols);
+        }
+        break;
+      }
+      case "K": {
+        const mode = params[0] || 0;
+        if (mode === 0) this.erase(this.y, this.x, this.cols);
+        else if (mode === 1) this.erase(this.y, 0, this.x + 1);
+        else this.erase(this.y, 0, this.cols);
+        break;
+      }
+      case "X": this.erase(this.y, this.x, Math.min(this.cols, this.x + n)); break;
+      case "P": {
+        const row = this.screen[this.y];
+        row.splice(this.x, Math.min(n, this.cols - this.x));
+        while (row.length < this.cols) row.push(this.blankCell());
+        break;
+      }
+      case "@": {
+        const row = this.screen[this.y];
+        for (let i = 0; i < Math.min(n, this.cols - this.x); i++) row.splice(this.x, 0, this.blankCell());
+        row.length = this.cols;
+        break;
+      }
+      case "L": case "M":
+        if (this.y >= this.top && this.y <= this.bottom) {
+          const top = this.top;
+          this.top = this.y;
+          if (final === "L") this.scrollDown(n);
+          else this.scrollUp(n);
+          this.top = top;
+        }
+        break;
+      case "S": this.scrollUp(n); break;
+      case "T": this.scrollDown(n); break;
+      case "m": applySGR(this.style, seq === "" ? [] : params.map((p) => p || 0)); break;
+      case "r":
+        this.top = clampY((params[0] || 1) - 1);
+        this.bottom = clampY((params[1] || this.rows) - 1);
+        if (this.top >= this.bottom) {
+          this.top = 0;
+          this.bottom = this.rows - 1;
+        }
+        this.x = this.y = 0;
+        break;
+      case "s": this.saved = { x: this.x, y: this.y }; break;
+      case "u": this.x = this.saved.x; this.y = this.saved.y; break;
+    }
+  }
+
+  scheduleRender() {
+    if (this.renderQueued) return;
+    this.renderQueued = true;
+    requestAnimationFrame(() => {
+      this.renderQueued = false;
+      this.render();
+    });
+  }
+
+  render() {
+    if (!this.pre) return;
+    let html = "";
+    for (let y = 0; y < this.rows; y++) {
+      let css = null, run = "";
+      const flush = () => {
+        if (run === "") return;
+        html += css ? `<span style="${css}">${escapeHtml(run)}</span>` : escapeHtml(run);
+        run = "";
+      };
+      for (let x = 0; x < this.cols; x++) {
+        const cell = this.screen[y][x];
+        if (this.cursorVisible && x === this.x && y === this.y) {
+          flush();
+          html += `<span class="cursor">${escapeHtml(cell.ch)}</span>`;
+          css = null;
+          continue;
+        }
+        const cellCSS = styleCSS(cell.style);
+        if (cellCSS !== css) {
+          flush();
+          css = cellCSS;
+        }
+        run += cell.ch;
+      }
+      flush();
+      html += "\n";
+    }
+    this.pre.innerHTML = html;
+  }
+
+  onKey(e) {
+    if (e.metaKey || (e.ctrlKey && e.shiftKey)) return; // leave the browser's shortcuts, copy and paste alone
+    const keys = {
+      Enter: "\r", Backspace: "\x7f", Tab: "\t", Escape: "\x1b",
+      ArrowUp: "\x1b[A", ArrowDown: "\x1b[B", ArrowRight: "\x1b[C", ArrowLeft: "\x1b[D",
+      Home: "\x1b[H", End: "\x1b[F", Delete: "\x1b[3~", Insert: "\x1b[2~",
+      PageUp: "\x1b[5~", PageDown: "\x1b[6~",
+    };
+    let data = keys[e.key];
+    if (e.key === "Tab" && e.shiftKey) data = "\x1b[Z";
+    if (data === undefined && e.key.length === 1) {
+      data = e.key;
+      if (e.ctrlKey) {
+        const c = e.key.toUpperCase().charCodeAt(0);
+        if (c >= 0x40 && c <= 0x5f) data = String.fromCharCode(c & 0x1f);
+        else if (e.key === " ") data = "\x00";
+        else return;
+      }
+      if (e.altKey) data = "\x1b" + data;
+    }
+    if (data === undefined) return;
+    e.preventDefault();
+    this.emit(data);
+  }
+}
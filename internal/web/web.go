@@ -0,0 +1,339 @@
+// Package web serves grove's optional browser UI: an instance table kept
+// live by the daemon's events, stop/check/finish/drop buttons, log viewing
+// and a terminal attached to an agent.  It is a client of the daemon like
+// the CLI, talking to it over its Unix socket through pkg/client, so it
+// can do nothing the CLI cannot.
+//
+// Every request must carry the UI's token: as ?token= on the page's URL,
+// which trades it for a cookie, or as an Authorization: Bearer header.
+// The assets under static/ are embedded, so the UI needs nothing at
+// runtime beyond the daemon itself.
+package web
+
+import (
+	"context"
+	"crypto/subtle"
+	"embed"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gandalfthegui/grove/pkg/client"
+)
+
+//go:embed static
+var static embed.FS
+
+// cookieName is the cookie holding the token once the page has been opened
+// with ?token=.
+const cookieName = "grove_token"
+
+// maxOutput bounds the check or finish output a response carries; the
+// head of longer output is dropped.
+const maxOutput = 256 << 10
+
+// Server is the UI's http.Handler.
+type Server struct {
+	client *client.Client
+	token  string
+	mux    *http.ServeMux
+}
+
+// New returns a Server that talks to the daemon through c and admits
+// requests carrying token.
+func New(c *client.Client, token string) *Server {
+	s := &Server{client: c, token: token, mux: http.NewServeMux()}
+	assets, _ := fs.Sub(static, "static")
+	s.mux.HandleFunc("GET /{$}", s.handleIndex)
+	s.mux.Handle("GET /static/", s.auth(http.StripPrefix("/static/", http.FileServerFS(assets))))
+	s.mux.Handle("GET /api/instances", s.auth(http.HandlerFunc(s.handleList)))
+	s.mux.Handle("GET /api/instances/{id}/logs", s.auth(http.HandlerFunc(s.handleLogs)))
+	s.mux.Handle("POST /api/instances/{id}/{action}", s.auth(http.HandlerFunc(s.handleAction)))
+	s.mux.Handle("GET /api/instances/{id}/attach", s.auth(http.HandlerFunc(s.handleAttach)))
+	s.mux.Handle("GET /api/events", s.auth(http.HandlerFunc(s.handleEvents)))
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	s.mux.ServeHTTP(w, r)
+}
+
+// validToken reports whether tok is the UI's token.
+func (s *Server) validToken(tok string) bool {
+	return tok != "" && subtle.ConstantTimeCompare([]byte(tok), []byte(s.token)) == 1
+}
+
+// authorized reports whether r carries the token, in the cookie or an
+// Authorization header.
+func (s *Server) authorized(r *http.Request) bool {
+	if c, err := r.Cookie(cookieName); err == nil && s.validToken(c.Value) {
+		return true
+	}
+	tok, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && s.validToken(tok)
+}
+
+// sameOrigin reports whether r, if it comes from a browser page, comes from
+// one of ours.  Cookies go along with requests other sites' pages make, so
+// anything with an effect, and every websocket, must pass this too.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true // not a browser, or a same-origin GET
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// auth refuses requests without the token, and cross-origin requests that
+// are not plain reads.
+func (s *Server) auth(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.authorized(r) {
+			writeError(w, http.StatusUnauthorized, errors.New("missing or wrong token; open the URL grove web prints"))
+			return
+		}
+		if (r.Method != http.MethodGet || r.Header.Get("Upgrade") != "") && !sameOrigin(r) {
+			writeError(w, http.StatusForbidden, errors.New("cross-origin request refused"))
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// handleIndex serves the page.  Opened with ?token=, it sets the cookie
+// and redirects to itself without the token, keeping it out of the
+// browser's history.
+func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if tok := r.URL.Query().Get("token"); tok != "" {
+		if !s.validToken(tok) {
+			http.Error(w, "wrong token; open the URL grove web prints", http.StatusUnauthorized)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: cookieName, Value: tok, Path: "/", HttpOnly: true, SameSite: http.SameSiteStrictMode})
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	if !s.authorized(r) {
+		http.Error(w, "grove: open the URL grove web prints, which carries the token", http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	http.ServeFileFS(w, r, static, "static/index.html")
+}
+
+// writeJSON writes v as the response.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// apiError is an error response's body.
+type apiError struct {
+	Error string `json:"error"`
+	Hint  string `json:"hint,omitempty"`
+}
+
+// writeError replies with err, and the daemon's hint if it refused.
+func writeError(w http.ResponseWriter, status int, err error) {
+	body := apiError{Error: err.Error()}
+	var refused *client.Error
+	if errors.As(err, &refused) {
+		body.Hint = refused.Hint
+	}
+	writeJSON(w, status, body)
+}
+
+// daemonStatus is the status to reply with when a daemon request fails:
+// the daemon refusing is the client's problem, not reaching it is ours.
+func daemonStatus(err error) int {
+	var refused *client.Error
+	if errors.As(err, &refused) {
+		return http.StatusConflict
+	}
+	return http.StatusBadGateway
+}
+
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	instances, err := s.client.List(r.Context(), client.ListOptions{})
+	if err != nil {
+		writeError(w, daemonStatus(err), err)
+		return
+	}
+	if instances == nil {
+		instances = []client.InstanceInfo{}
+	}
+	writeJSON(w, http.StatusOK, instances)
+}
+
+// handleLogs serves an instance's log as text, ANSI sequences and all:
+// ?section=setup or agent picks a part of it, ?agent= a helper's log.
+func (s *Server) handleLogs(w http.ResponseWriter, r *http.Request) {
+	logs, err := s.client.Logs(r.Context(), r.PathValue("id"), client.LogsOptions{
+		Section: r.URL.Query().Get("section"),
+		Helper:  r.URL.Query().Get("agent"),
+	})
+	if err != nil {
+		writeError(w, daemonStatus(err), err)
+		return
+	}
+	defer logs.Close()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	io.Copy(w, logs)
+}
+
+// actionResult is the body of a successful action: check and finish report
+// their result and output.
+type actionResult struct {
+	OK     bool                 `json:"ok"`
+	Result *client.StreamResult `json:"result,omitempty"`
+	Output string               `json:"output,omitempty"`
+}
+
+// handleAction runs stop, check, finish or drop on an instance.  Check and
+// finish run to the end even if the browser goes away, as they would had
+// grove check been interrupted after the daemon started them.
+func (s *Server) handleAction(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	ctx := r.Context()
+	var res actionResult
+	var err error
+	switch r.PathValue("action") {
+	case "stop":
+		err = s.client.Stop(ctx, id)
+	case "drop":
+		err = s.client.Drop(ctx, id)
+	case "check", "finish":
+		out := &tailBuffer{max: maxOutput}
+		ctx = context.WithoutCancel(ctx)
+		if r.PathValue("action") == "check" {
+			res.Result, err = s.client.Check(ctx, id, client.RunOptions{Output: out})
+		} else {
+			res.Result, err = s.client.Finish(ctx, id, client.RunOptions{Output: out})
+		}
+		res.Output = string(out.buf)
+	default:
+		writeError(w, http.StatusNotFound, errors.New("unknown action "+r.PathValue("action")))
+		return
+	}
+	if err != nil {
+		writeError(w, daemonStatus(err), err)
+		return
+	}
+	res.OK = res.Result == nil || res.Result.OK
+	writeJSON(w, http.StatusOK, res)
+}
+
+// tailBuffer keeps the last max bytes written to it.
+type tailBuffer struct {
+	buf []byte
+	max int
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.buf = append(b.buf, p...)
+	if over := len(b.buf) - b.max; over > 0 {
+		b.buf = append(b.buf[:0], b.buf[over:]...)
+	}
+	return len(p), nil
+}
+
+// handleEvents forwards the daemon's events, one text message of JSON
+// each, until either side goes away.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithCancel(context.WithoutCancel(r.Context()))
+	defer cancel()
+	events, err := s.client.Events(ctx)
+	if err != nil {
+		writeError(w, daemonStatus(err), err)
+		return
+	}
+	defer events.Close()
+	ws := upgrade(w, r)
+	if ws == nil {
+		return
+	}
+	defer ws.close(closeGoingAway, "")
+	go discardMessages(ws, cancel)
+	for {
+		if _, err := events.Next(); err != nil {
+			return
+		}
+		if err := ws.writeMessage(opText, events.Raw()); err != nil {
+			return
+		}
+	}
+}
+
+// discardMessages reads ws until the browser closes it, then calls done.
+func discardMessages(ws *wsConn, done func()) {
+	defer done()
+	for {
+		if _, _, err := ws.readMessage(); err != nil {
+			return
+		}
+	}
+}
+
+// handleAttach bridges a websocket to the agent's terminal: binary
+// messages carry the attach protocol's frames unchanged in both
+// directions, so the page speaks the same protocol grove attach does.
+// ?agent= attaches to a helper.  If the daemon refuses, the socket is
+// closed with its error as the reason.
+func (s *Server) handleAttach(w http.ResponseWriter, r *http.Request) {
+	ws := upgrade(w, r)
+	if ws == nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.WithoutCancel(r.Context()))
+	defer cancel()
+	_, stream, err := s.client.Open(ctx, client.Request{
+		Type:       client.ReqAttach,
+		InstanceID: r.PathValue("id"),
+		AgentName:  r.URL.Query().Get("agent"),
+		AttachV2:   true,
+	})
+	if err != nil {
+		ws.close(closeError, err.Error())
+		return
+	}
+	defer stream.Close()
+
+	go func() {
+		defer cancel()
+		for {
+			op, data, err := ws.readMessage()
+			if err != nil {
+				return
+			}
+			if op != opBinary {
+				continue
+			}
+			if _, err := stream.Write(data); err != nil {
+				return
+			}
+		}
+	}()
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := stream.Read(buf)
+		if n > 0 {
+			if werr := ws.writeMessage(opBinary, buf[:n]); werr != nil {
+				break
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	ws.close(closeNormal, "")
+}
@@ -0,0 +1,335 @@
+package web
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gandalfthegui/grove/pkg/client"
+	"github.com/gandalfthegui/grove/pkg/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testToken = "s3cret"
+
+// fakeDaemon serves each connection with handle, given the request read
+// from it, and returns a UI server talking to it.
+func fakeDaemon(t *testing.T, handle func(conn net.Conn, req proto.Request)) *httptest.Server {
+	t.Helper()
+	sock := filepath.Join(t.TempDir(), "groved.sock")
+	ln, err := net.Listen("unix", sock)
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				// Byte at a time, leaving any stream that follows unread.
+				var line []byte
+				b := make([]byte, 1)
+				for {
+					if _, err := conn.Read(b); err != nil {
+						return
+					}
+					if b[0] == '\n' {
+						break
+					}
+					line = append(line, b[0])
+				}
+				var req proto.Request
+				if json.Unmarshal(line, &req) == nil {
+					handle(conn, req)
+				}
+			}()
+		}
+	}()
+	srv := httptest.NewServer(New(client.New(sock), testToken))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func respond(conn net.Conn, resp proto.Response) {
+	data, _ := json.Marshal(resp)
+	conn.Write(append(data, '\n'))
+}
+
+func do(t *testing.T, method, url string, header http.Header) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, nil)
+	require.NoError(t, err)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp, string(body)
+}
+
+var bearer = http.Header{"Authorization": {"Bearer " + testToken}}
+
+func TestAuth(t *testing.T) {
+	srv := fakeDaemon(t, func(conn net.Conn, req proto.Request) {
+		respond(conn, proto.Response{OK: true})
+	})
+
+	resp, _ := do(t, "GET", srv.URL+"/", nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp, _ = do(t, "GET", srv.URL+"/?token=wrong", nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp, _ = do(t, "GET", srv.URL+"/api/instances", nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp, _ = do(t, "GET", srv.URL+"/static/app.js", nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// The token in the URL is traded for a cookie.
+	resp, _ = do(t, "GET", srv.URL+"/?token="+testToken, nil)
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+	assert.Equal(t, "/", resp.Header.Get("Location"))
+	cookies := resp.Cookies()
+	require.Len(t, cookies, 1)
+	assert.True(t, cookies[0].HttpOnly)
+	assert.Equal(t, http.SameSiteStrictMode, cookies[0].SameSite)
+
+	withCookie := http.Header{"Cookie": {cookieName + "=" + testToken}}
+	resp, body := do(t, "GET", srv.URL+"/", withCookie)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, "<title>grove</title>")
+	resp, body = do(t, "GET", srv.URL+"/static/term.js", withCookie)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, "class MiniTerm")
+	resp, _ = do(t, "GET", srv.URL+"/api/instances", bearer)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Another site's page may not act with the user's cookie.
+	crossSite := http.Header{"Cookie": withCookie["Cookie"], "Origin": {"http://evil.example"}}
+	resp, _ = do(t, "POST", srv.URL+"/api/instances/1/stop", crossSite)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	sameSite := http.Header{"Cookie": withCookie["Cookie"], "Origin": {srv.URL}}
+	resp, _ = do(t, "POST", srv.URL+"/api/instances/1/stop", sameSite)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestAPI(t *testing.T) {
+	srv := fakeDaemon(t, func(conn net.Conn, req proto.Request) {
+		switch req.Type {
+		case proto.ReqList:
+			respond(conn, proto.Response{OK: true, Instances: []proto.InstanceInfo{{ID: "1", Project: "app", State: proto.StateWaiting}}})
+		case proto.ReqLogs:
+			respond(conn, proto.Response{OK: true})
+			io.WriteString(conn, "\x1b[32mok\x1b[0m "+req.LogSection)
+		case proto.ReqCheck:
+			respond(conn, proto.Response{OK: true})
+			io.WriteString(conn, "go test ./...\nFAIL\n")
+			proto.WriteResultTrailer(conn, proto.StreamResult{Commands: []proto.CommandResult{{Command: "go test ./...", ExitCode: 1}}})
+		case proto.ReqDrop:
+			respond(conn, proto.Response{Error: "instance not found: " + req.InstanceID, Hint: "see grove list"})
+		default:
+			respond(conn, proto.Response{OK: true})
+		}
+	})
+
+	resp, body := do(t, "GET", srv.URL+"/api/instances", bearer)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var instances []proto.InstanceInfo
+	require.NoError(t, json.Unmarshal([]byte(body), &instances))
+	assert.Equal(t, "WAITING", instances[0].State)
+
+	_, body = do(t, "GET", srv.URL+"/api/instances/1/logs?section=setup", bearer)
+	assert.Equal(t, "\x1b[32mok\x1b[0m setup", body, "logs are passed on as they are")
+
+	resp, body = do(t, "POST", srv.URL+"/api/instances/1/check", bearer)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var res actionResult
+	require.NoError(t, json.Unmarshal([]byte(body), &res))
+	assert.False(t, res.OK)
+	assert.Equal(t, "go test ./...\nFAIL\n", res.Output)
+	assert.Equal(t, 1, res.Result.Commands[0].ExitCode)
+
+	resp, body = do(t, "POST", srv.URL+"/api/instances/9/drop", bearer)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	assert.JSONEq(t, `{"error":"instance not found: 9","hint":"see grove list"}`, body)
+
+	resp, _ = do(t, "POST", srv.URL+"/api/instances/1/explode", bearer)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestTailBuffer(t *testing.T) {
+	b := &tailBuffer{max: 5}
+	b.Write([]byte("abc"))
+	b.Write([]byte("defg"))
+	assert.Equal(t, "cdefg", string(b.buf))
+}
+
+// wsClient is the browser's end of a websocket.
+type wsClient struct {
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+// dialWS opens a websocket to path on srv, or returns the refusal's status.
+func dialWS(t *testing.T, srv *httptest.Server, path string, header http.Header) (*wsClient, int) {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	key := make([]byte, 16)
+	rand.Read(key)
+	req, _ := http.NewRequest("GET", srv.URL+path, nil)
+	req.Header = http.Header{
+		"Connection":            {"Upgrade"},
+		"Upgrade":               {"websocket"},
+		"Sec-Websocket-Version": {"13"},
+		"Sec-Websocket-Key":     {base64.StdEncoding.EncodeToString(key)},
+		"Authorization":         {"Bearer " + testToken},
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	require.NoError(t, req.Write(conn))
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	require.NoError(t, err)
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, resp.StatusCode
+	}
+	assert.Equal(t, wsAccept(base64.StdEncoding.EncodeToString(key)), resp.Header.Get("Sec-Websocket-Accept"))
+	return &wsClient{conn: conn, br: br}, resp.StatusCode
+}
+
+// send writes a masked frame, as browsers must.
+func (c *wsClient) send(t *testing.T, fin bool, op byte, payload []byte) {
+	t.Helper()
+	hdr := []byte{op, 0x80 | byte(len(payload))}
+	if fin {
+		hdr[0] |= 0x80
+	}
+	mask := []byte{1, 2, 3, 4}
+	masked := make([]byte, len(payload))
+	for i := range payload {
+		masked[i] = payload[i] ^ mask[i%4]
+	}
+	_, err := c.conn.Write(append(append(hdr, mask...), masked...))
+	require.NoError(t, err)
+}
+
+// recv reads an unmasked frame.
+func (c *wsClient) recv(t *testing.T) (byte, []byte) {
+	t.Helper()
+	var hdr [2]byte
+	_, err := io.ReadFull(c.br, hdr[:])
+	require.NoError(t, err)
+	n := int(hdr[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		io.ReadFull(c.br, ext[:])
+		n = int(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		io.ReadFull(c.br, ext[:])
+		n = int(binary.BigEndian.Uint64(ext[:]))
+	}
+	payload := make([]byte, n)
+	_, err = io.ReadFull(c.br, payload)
+	require.NoError(t, err)
+	return hdr[0] & 0x0f, payload
+}
+
+func TestEvents(t *testing.T) {
+	srv := fakeDaemon(t, func(conn net.Conn, req proto.Request) {
+		respond(conn, proto.Response{OK: true})
+		io.WriteString(conn, `{"type":"ready","instance_id":"1","state":"READY"}`+"\n")
+		io.Copy(io.Discard, conn) // until the UI hangs up
+	})
+
+	_, status := dialWS(t, srv, "/api/events", http.Header{"Origin": {"http://evil.example"}})
+	assert.Equal(t, http.StatusForbidden, status)
+
+	ws, _ := dialWS(t, srv, "/api/events", nil)
+	require.NotNil(t, ws)
+	op, msg := ws.recv(t)
+	assert.Equal(t, byte(opText), op)
+	assert.JSONEq(t, `{"type":"ready","instance_id":"1","state":"READY"}`, string(msg))
+
+	ws.send(t, true, opPing, []byte("hi"))
+	op, msg = ws.recv(t)
+	assert.Equal(t, byte(opPong), op)
+	assert.Equal(t, "hi", string(msg))
+
+	ws.send(t, true, opClose, []byte{0x03, 0xe8})
+	op, _ = ws.recv(t)
+	assert.Equal(t, byte(opClose), op)
+}
+
+func TestAttach(t *testing.T) {
+	srv := fakeDaemon(t, func(conn net.Conn, req proto.Request) {
+		if req.InstanceID != "1" || !req.AttachV2 || req.AgentName != "docs" {
+			respond(conn, proto.Response{Error: "instance 2 is not running"})
+			return
+		}
+		respond(conn, proto.Response{OK: true, AttachV2: true})
+		proto.WriteFrame(conn, proto.AttachFrameData, []byte("$ "))
+		// Echo typed input until the detach.
+		for {
+			frameType, payload, err := proto.ReadFrame(conn)
+			if err != nil || frameType == proto.AttachFrameDetach {
+				return
+			}
+			proto.WriteFrame(conn, frameType, payload)
+		}
+	})
+
+	ws, _ := dialWS(t, srv, "/api/instances/1/attach?agent=docs", nil)
+	require.NotNil(t, ws)
+	op, msg := ws.recv(t)
+	assert.Equal(t, byte(opBinary), op)
+	assert.Equal(t, []byte{proto.AttachFrameData, 0, 0, 0, 2, '$', ' '}, msg, "frames pass through unchanged")
+
+	// A frame split across two websocket fragments is reassembled.
+	frame := []byte{proto.AttachFrameData, 0, 0, 0, 3, 'l', 's', '\r'}
+	ws.send(t, false, opBinary, frame[:4])
+	ws.send(t, true, opContinuation, frame[4:])
+	_, msg = ws.recv(t)
+	assert.Equal(t, frame, msg)
+
+	ws.send(t, true, opBinary, []byte{proto.AttachFrameDetach, 0, 0, 0, 0})
+	op, msg = ws.recv(t)
+	assert.Equal(t, byte(opClose), op)
+	assert.Equal(t, []byte{0x03, 0xe8}, msg, "normal closure once the daemon ends the session")
+
+	// A refusal closes the socket with the daemon's error as the reason.
+	ws, _ = dialWS(t, srv, "/api/instances/2/attach", nil)
+	require.NotNil(t, ws)
+	op, msg = ws.recv(t)
+	assert.Equal(t, byte(opClose), op)
+	assert.Equal(t, "instance 2 is not running", string(msg[2:]))
+}
+
+func TestUpgradeRejectsPlainRequests(t *testing.T) {
+	srv := fakeDaemon(t, func(conn net.Conn, req proto.Request) {
+		respond(conn, proto.Response{OK: true})
+	})
+	resp, _ := do(t, "GET", srv.URL+"/api/events", bearer)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestWSAccept(t *testing.T) {
+	// The example from RFC 6455, section 1.3.
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", wsAccept("dGhlIHNhbXBsZSBub25jZQ=="))
+}
@@ -0,0 +1,221 @@
+package web
+
+// websocket.go – just enough of RFC 6455 for the UI's two sockets: the
+// server side of the handshake, and messages in both directions.  No
+// extensions (compression) are negotiated.
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Opcodes of the frames wsConn handles.
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// maxMessage bounds a message from the browser: keystrokes and resizes.
+const maxMessage = 1 << 20
+
+// wsGUID is the key suffix of RFC 6455's handshake.
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// errClosed is returned by readMessage once the browser has closed the socket.
+var errClosed = errors.New("websocket closed")
+
+// wsConn is a server-side websocket.  Messages must be read from one
+// goroutine; writes may come from any.
+type wsConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+
+	wmu sync.Mutex
+}
+
+// wsAccept returns the Sec-WebSocket-Accept value for key.
+func wsAccept(key string) string {
+	h := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// headerHas reports whether the comma-separated header name of r lists
+// token, case-insensitively.
+func headerHas(r *http.Request, name, token string) bool {
+	for _, v := range r.Header.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// upgrade completes a websocket handshake on r, or replies with an error
+// and returns nil.
+func upgrade(w http.ResponseWriter, r *http.Request) *wsConn {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet || !headerHas(r, "Connection", "upgrade") || !headerHas(r, "Upgrade", "websocket") || key == "" {
+		http.Error(w, "expected a websocket handshake", http.StatusBadRequest)
+		return nil
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return nil
+	}
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil
+	}
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", wsAccept(key))
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil
+	}
+	return &wsConn{conn: conn, br: rw.Reader}
+}
+
+// readFrame reads one frame, unmasking its payload.
+func (c *wsConn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var hdr [2]byte
+	if _, err = io.ReadFull(c.br, hdr[:]); err != nil {
+		return
+	}
+	fin, op = hdr[0]&0x80 != 0, hdr[0]&0x0f
+	if hdr[0]&0x70 != 0 {
+		return fin, op, nil, errors.New("websocket: reserved bits set")
+	}
+	if hdr[1]&0x80 == 0 {
+		return fin, op, nil, errors.New("websocket: unmasked client frame")
+	}
+	n := uint64(hdr[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > maxMessage {
+		return fin, op, nil, fmt.Errorf("websocket: frame of %d bytes is too large", n)
+	}
+	var mask [4]byte
+	if _, err = io.ReadFull(c.br, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(c.br, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, op, payload, nil
+}
+
+// readMessage returns the next text or binary message, answering pings
+// and reassembling fragments on the way.  It returns errClosed when the
+// browser closes the socket.
+func (c *wsConn) readMessage() (op byte, data []byte, err error) {
+	for {
+		fin, frameOp, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch frameOp {
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			c.writeFrame(opClose, payload)
+			return 0, nil, errClosed
+		case opContinuation:
+			if op == 0 {
+				return 0, nil, errors.New("websocket: continuation without a message")
+			}
+		case opText, opBinary:
+			if op != 0 {
+				return 0, nil, errors.New("websocket: message interrupted by another")
+			}
+			op = frameOp
+		default:
+			return 0, nil, fmt.Errorf("websocket: unknown opcode %#x", frameOp)
+		}
+		if len(data)+len(payload) > maxMessage {
+			return 0, nil, errors.New("websocket: message too large")
+		}
+		data = append(data, payload...)
+		if fin {
+			return op, data, nil
+		}
+	}
+}
+
+// writeFrame writes one unfragmented, unmasked frame.
+func (c *wsConn) writeFrame(op byte, payload []byte) error {
+	hdr := make([]byte, 2, 10)
+	hdr[0] = 0x80 | op
+	switch n := len(payload); {
+	case n < 126:
+		hdr[1] = byte(n)
+	case n <= 0xffff:
+		hdr[1] = 126
+		hdr = binary.BigEndian.AppendUint16(hdr, uint16(n))
+	default:
+		hdr[1] = 127
+		hdr = binary.BigEndian.AppendUint64(hdr, uint64(n))
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_, err := (&net.Buffers{hdr, payload}).WriteTo(c.conn)
+	return err
+}
+
+// writeMessage sends data as one text or binary message.
+func (c *wsConn) writeMessage(op byte, data []byte) error {
+	return c.writeFrame(op, data)
+}
+
+// close sends a close frame with status code and reason, then closes the
+// connection.
+func (c *wsConn) close(code uint16, reason string) error {
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	c.writeFrame(opClose, append(binary.BigEndian.AppendUint16(nil, code), reason...))
+	return c.conn.Close()
+}
+
+// Close status codes wsConn.close sends.
+const (
+	closeNormal    = 1000
+	closeGoingAway = 1001
+	closeError     = 1011
+)
@@ -0,0 +1,140 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+// AttachOptions are the options of Attach.
+type AttachOptions struct {
+	Agent string // a helper agent from grove.yaml's agents list; empty means the primary agent
+	// Command, if set, is a shell command line to run in a terminal of its
+	// own in the instance's container instead; Exit then returns its exit
+	// status.  Detaching or closing the session kills it.
+	Command string
+}
+
+// AttachSession is a connection to an agent's terminal.  Reading it yields
+// the agent's output and writing it types into the agent; Resize, Detach,
+// State and Exit cover the rest of what a terminal client needs.  Reads
+// must come from one goroutine; the other methods may be called from any.
+type AttachSession struct {
+	s  *Stream
+	fw *proto.FrameWriter
+	v2 bool
+
+	pending []byte // data read from a frame but not yet returned by Read
+
+	mu    sync.Mutex
+	state AttachState
+	exit  *AttachExit
+}
+
+// Attach connects to an instance's agent.  The session ends when the agent
+// exits, when Detach or Close is called, or when ctx is done.
+func (c *Client) Attach(ctx context.Context, instanceID string, opts AttachOptions) (*AttachSession, error) {
+	resp, s, err := c.Open(ctx, Request{Type: ReqAttach, InstanceID: instanceID, AgentName: opts.Agent, Command: opts.Command, AttachV2: true})
+	if err != nil {
+		return nil, err
+	}
+	if opts.Command != "" && !resp.AttachCommand {
+		// An older daemon ignored the command and attached to the agent.
+		s.Close()
+		return nil, errors.New("the daemon cannot run a command to attach to; restart it to update it")
+	}
+	return &AttachSession{s: s, fw: proto.NewFrameWriter(s), v2: resp.AttachV2}, nil
+}
+
+// Reports reports whether the daemon sends the agent's state and exit
+// status, which State and Exit return.  An older daemon sends only output.
+func (a *AttachSession) Reports() bool { return a.v2 }
+
+// Read reads the agent's output.  It returns io.EOF once the agent has
+// exited, after which Exit says how.
+func (a *AttachSession) Read(p []byte) (int, error) {
+	if !a.v2 {
+		return a.s.Read(p)
+	}
+	for len(a.pending) == 0 {
+		frameType, err := a.next()
+		if err != nil {
+			return 0, err
+		}
+		if frameType == proto.AttachFrameExit {
+			return 0, io.EOF
+		}
+	}
+	n := copy(p, a.pending)
+	a.pending = a.pending[n:]
+	return n, nil
+}
+
+// next reads and handles one frame, returning its type.  Frames of unknown
+// types, and control frames that do not decode, are skipped.
+func (a *AttachSession) next() (byte, error) {
+	frameType, payload, err := proto.ReadFrame(a.s)
+	if err != nil {
+		return 0, err
+	}
+	switch frameType {
+	case proto.AttachFrameData:
+		a.pending = payload
+	case proto.AttachFrameState:
+		var st AttachState
+		if json.Unmarshal(payload, &st) == nil {
+			a.mu.Lock()
+			a.state = st
+			a.mu.Unlock()
+		}
+	case proto.AttachFrameExit:
+		var e AttachExit
+		if json.Unmarshal(payload, &e) == nil {
+			a.mu.Lock()
+			a.exit = &e
+			a.mu.Unlock()
+		}
+	}
+	return frameType, nil
+}
+
+// Write sends p to the agent as typed input.
+func (a *AttachSession) Write(p []byte) (int, error) {
+	if err := a.fw.WriteFrame(proto.AttachFrameData, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Resize tells the agent the size of the terminal.
+func (a *AttachSession) Resize(cols, rows uint16) error {
+	return a.fw.WriteFrame(proto.AttachFrameResize, proto.ResizePayload(cols, rows))
+}
+
+// Detach asks the daemon to end the session, leaving the agent running.
+func (a *AttachSession) Detach() error {
+	return a.fw.WriteFrame(proto.AttachFrameDetach, nil)
+}
+
+// State returns the agent's latest state, as grove list would show it with
+// nobody attached.  It is the zero AttachState until the daemon reports one.
+func (a *AttachSession) State() AttachState {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.state
+}
+
+// Exit returns how the agent exited, or nil if it has not, or the session
+// ended otherwise.
+func (a *AttachSession) Exit() *AttachExit {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.exit
+}
+
+// Close closes the connection.
+func (a *AttachSession) Close() error { return a.s.Close() }
@@ -0,0 +1,587 @@
+// Package client talks to groved, the grove daemon, for tools built on top
+// of grove: dashboards, bots, scripts that outgrow --porcelain.  The grove
+// CLI itself goes through this package for every request, so it speaks the
+// same protocol the CLI does.
+//
+// A request is one JSON line over a fresh connection, answered by one JSON
+// line.  Some requests then stream: setup output after Start, log output,
+// events, check and finish output with a result trailer, and the framed
+// two-way stream of Attach.  Every method takes a context; cancelling it
+// closes the connection, and a deadline applies to the whole exchange,
+// stream included.
+//
+//	c := client.New(client.DefaultSocketPath())
+//	instances, err := c.List(ctx, client.ListOptions{})
+//
+// Unlike the CLI, a Client does not start the daemon if it is not running.
+package client
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+)
+
+// The protocol's types.  They are grove's own, from package proto, so they
+// cannot drift from what the daemon sends; the types their fields use are
+// proto's too.
+type (
+	Request       = proto.Request
+	Response      = proto.Response
+	InstanceInfo  = proto.InstanceInfo
+	Event         = proto.Event
+	StreamResult  = proto.StreamResult
+	CommandResult = proto.CommandResult
+	ChangeSummary = proto.ChangeSummary
+	AttachState   = proto.AttachState
+	AttachExit    = proto.AttachExit
+	AgentUsage    = proto.AgentUsage
+	LogLine       = proto.LogLine
+)
+
+// Request types, for Do and Open.
+const (
+	ReqPing           = proto.ReqPing
+	ReqStart          = proto.ReqStart
+	ReqCancelStart    = proto.ReqCancelStart
+	ReqList           = proto.ReqList
+	ReqAttach         = proto.ReqAttach
+	ReqLogs           = proto.ReqLogs
+	ReqLogsFollow     = proto.ReqLogsFollow
+	ReqLogsAll        = proto.ReqLogsAll
+	ReqStop           = proto.ReqStop
+	ReqDrop           = proto.ReqDrop
+	ReqFinish         = proto.ReqFinish
+	ReqRestart        = proto.ReqRestart
+	ReqCheck          = proto.ReqCheck
+	ReqNote           = proto.ReqNote
+	ReqLabel          = proto.ReqLabel
+	ReqCopy           = proto.ReqCopy
+	ReqStats          = proto.ReqStats
+	ReqEvents         = proto.ReqEvents
+	ReqReopen         = proto.ReqReopen
+	ReqShowConfig     = proto.ReqShowConfig
+	ReqGC             = proto.ReqGC
+	ReqUsage          = proto.ReqUsage
+	ReqTTL            = proto.ReqTTL
+	ReqContainerRm    = proto.ReqContainerRm
+	ReqWorktreeRm     = proto.ReqWorktreeRm
+	ReqProjects       = proto.ReqProjects
+	ReqProjectResolve = proto.ReqProjectResolve
+	ReqProjectDelete  = proto.ReqProjectDelete
+	ReqProjectFetch   = proto.ReqProjectFetch
+	ReqProjectLock    = proto.ReqProjectLock
+	ReqProjectMove    = proto.ReqProjectMove
+	ReqWorktrees      = proto.ReqWorktrees
+)
+
+// Instance states.
+const (
+	StateRunning      = proto.StateRunning
+	StateWaiting      = proto.StateWaiting
+	StateAttached     = proto.StateAttached
+	StateChecking     = proto.StateChecking
+	StateReady        = proto.StateReady
+	StateExited       = proto.StateExited
+	StateCrashed      = proto.StateCrashed
+	StateKilled       = proto.StateKilled
+	StateFinished     = proto.StateFinished
+	StateFinishFailed = proto.StateFinishFailed
+	StateFailedSetup  = proto.StateFailedSetup
+	StateStalled      = proto.StateStalled
+	StateBroken       = proto.StateBroken
+)
+
+// IsTerminal reports whether an instance in state has ended: EXITED,
+// CRASHED, KILLED, FINISHED, FINISH_FAILED, FAILED_SETUP, STALLED or
+// BROKEN.
+func IsTerminal(state string) bool { return proto.IsTerminal(state) }
+
+// Error codes, in Error.Code and StreamResult.ErrorCode.
+const (
+	ErrCodeOperationInProgress = proto.ErrCodeOperationInProgress
+	ErrCodeInsufficientDisk    = proto.ErrCodeInsufficientDisk
+	ErrCodeDataDirUnavailable  = proto.ErrCodeDataDirUnavailable
+	ErrCodeStartCancelled      = proto.ErrCodeStartCancelled
+	ErrCodeConfigMissing       = proto.ErrCodeConfigMissing
+	ErrCodeConfigInvalid       = proto.ErrCodeConfigInvalid
+	ErrCodeGitRepoURL          = proto.ErrCodeGitRepoURL
+	ErrCodeGitRepoNotFound     = proto.ErrCodeGitRepoNotFound
+	ErrCodeGitAuth             = proto.ErrCodeGitAuth
+	ErrCodeGitHostKey          = proto.ErrCodeGitHostKey
+	ErrCodeGitUnreachable      = proto.ErrCodeGitUnreachable
+)
+
+// Log sections, for LogsOptions.Section.
+const (
+	LogSectionSetup = proto.LogSectionSetup
+	LogSectionAgent = proto.LogSectionAgent
+)
+
+// Dialer opens a connection to the daemon.  New dials a Unix socket; other
+// transports plug in through NewWithDialer.
+type Dialer func(ctx context.Context) (net.Conn, error)
+
+// UnixDialer dials the daemon's Unix socket at socketPath.
+func UnixDialer(socketPath string) Dialer {
+	return func(ctx context.Context) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", socketPath)
+	}
+}
+
+// DefaultSocketPath returns the socket of the daemon the grove CLI would
+// use without --profile: $GROVE_SOCKET, or else groved.sock in $GROVE_ROOT,
+// in ~/.grove-$GROVE_PROFILE, or in ~/.grove.
+func DefaultSocketPath() string {
+	if sock := os.Getenv("GROVE_SOCKET"); sock != "" {
+		if abs, err := filepath.Abs(sock); err == nil {
+			return abs
+		}
+		return sock
+	}
+	root := os.Getenv("GROVE_ROOT")
+	if root == "" {
+		home, _ := os.UserHomeDir()
+		root = filepath.Join(home, ".grove")
+		if profile := os.Getenv("GROVE_PROFILE"); profile != "" && profile != "default" {
+			root += "-" + profile
+		}
+	} else if abs, err := filepath.Abs(root); err == nil {
+		root = abs
+	}
+	return filepath.Join(root, "groved.sock")
+}
+
+// Client sends requests to a daemon.  It holds no connection of its own, so
+// it is safe for concurrent use.
+type Client struct {
+	dial Dialer
+}
+
+// New returns a Client for the daemon listening on the Unix socket at
+// socketPath.
+func New(socketPath string) *Client {
+	return NewWithDialer(UnixDialer(socketPath))
+}
+
+// NewWithDialer returns a Client that reaches the daemon through dial.
+func NewWithDialer(dial Dialer) *Client {
+	return &Client{dial: dial}
+}
+
+// Error is a request the daemon refused.
+type Error struct {
+	Message string
+	Code    string // ErrCode*, for errors a client may act on; often empty
+	Hint    string // what the user can do about it, if the daemon knows
+
+	// Response is the whole refusal, for the fields some refusals carry,
+	// e.g. InitPath when a project has no grove.yaml.
+	Response Response
+}
+
+func (e *Error) Error() string { return e.Message }
+
+// responseError returns the *Error for a response that is not OK.
+func responseError(resp Response) error {
+	if resp.OK {
+		return nil
+	}
+	msg := resp.Error
+	if msg == "" {
+		msg = "request failed"
+	}
+	return &Error{Message: msg, Code: resp.ErrorCode, Hint: resp.Hint, Response: resp}
+}
+
+// Stream is a connection left open after the daemon's response, carrying
+// whatever the request streams.  Closing it, or cancelling the context it
+// was opened with, ends the stream.
+type Stream struct {
+	conn net.Conn
+	stop func() bool
+	ctx  context.Context
+}
+
+// Read reads the stream.  Once the context is done it returns the
+// context's error.
+func (s *Stream) Read(p []byte) (int, error) {
+	n, err := s.conn.Read(p)
+	return n, s.wrap(err)
+}
+
+// Write writes to the daemon.
+func (s *Stream) Write(p []byte) (int, error) {
+	n, err := s.conn.Write(p)
+	return n, s.wrap(err)
+}
+
+// ReadResponse reads another JSON response line, for requests that answer
+// twice, e.g. a copy into an instance.
+func (s *Stream) ReadResponse() (Response, error) {
+	resp, err := readResponse(s.conn)
+	return resp, s.wrap(err)
+}
+
+// Close closes the connection.
+func (s *Stream) Close() error {
+	s.stop()
+	return s.conn.Close()
+}
+
+// wrap reports a failure caused by the context as the context's error.
+func (s *Stream) wrap(err error) error {
+	if err != nil && s.ctx.Err() != nil {
+		return s.ctx.Err()
+	}
+	return err
+}
+
+// Open sends req and reads the daemon's response.  If the daemon accepted
+// it, the connection stays open as a Stream for what follows; otherwise
+// the error is an *Error and the connection is closed.
+func (c *Client) Open(ctx context.Context, req Request) (Response, *Stream, error) {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return Response{}, nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	s := &Stream{conn: conn, ctx: ctx, stop: context.AfterFunc(ctx, func() { conn.Close() })}
+	if err := writeRequest(conn, req); err != nil {
+		s.Close()
+		return Response{}, nil, s.wrap(err)
+	}
+	resp, err := readResponse(conn)
+	if err != nil {
+		s.Close()
+		return Response{}, nil, s.wrap(err)
+	}
+	if err := responseError(resp); err != nil {
+		s.Close()
+		return resp, nil, err
+	}
+	return resp, s, nil
+}
+
+// Do sends req and returns the daemon's response.  A refusal is returned
+// as an *Error along with the response.
+func (c *Client) Do(ctx context.Context, req Request) (Response, error) {
+	resp, s, err := c.Open(ctx, req)
+	if err != nil {
+		return resp, err
+	}
+	s.Close()
+	return resp, nil
+}
+
+func writeRequest(w io.Writer, req Request) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	_, err = w.Write(data)
+	return err
+}
+
+// readResponse reads one newline-terminated JSON response.  It reads a byte
+// at a time rather than through a bufio.Reader: several requests stream raw
+// data (logs, setup output, tar archives) immediately after the response,
+// and a buffered reader would swallow the start of that stream.
+func readResponse(r io.Reader) (Response, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := r.Read(b)
+		if n > 0 {
+			if b[0] == '\n' {
+				break
+			}
+			line = append(line, b[0])
+		}
+		if err != nil {
+			if err == io.EOF && len(line) > 0 {
+				break
+			}
+			return Response{}, err
+		}
+	}
+	var resp Response
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return Response{}, fmt.Errorf("bad response: %w", err)
+	}
+	return resp, nil
+}
+
+// Ping checks that the daemon is up and answering.
+func (c *Client) Ping(ctx context.Context) error {
+	_, err := c.Do(ctx, Request{Type: ReqPing})
+	return err
+}
+
+// ListOptions narrows List.
+type ListOptions struct {
+	InstanceID string // only this instance
+	Changes    bool   // fill in each instance's ChangeSummary (runs git)
+	MainAhead  bool   // fill in each instance's MainAhead (runs git)
+}
+
+// List returns the daemon's instances, oldest first.
+func (c *Client) List(ctx context.Context, opts ListOptions) ([]InstanceInfo, error) {
+	resp, err := c.Do(ctx, Request{Type: ReqList, InstanceID: opts.InstanceID, Changes: opts.Changes, MainAhead: opts.MainAhead})
+	return resp.Instances, err
+}
+
+// Start starts an instance as req describes (Project and Branch, or
+// Scratch, and the optional fields of a start) and returns its ID.  The
+// setup output the daemon streams, clone and start commands included, is
+// copied to setup, which may be nil.  Cancelling ctx cancels the start:
+// the daemon removes what it made.
+func (c *Client) Start(ctx context.Context, req Request, setup io.Writer) (string, error) {
+	req.Type = ReqStart
+	resp, s, err := c.Open(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	defer s.Close()
+	if setup == nil {
+		setup = io.Discard
+	}
+	_, err = io.Copy(setup, s)
+	return resp.InstanceID, err
+}
+
+// CancelStart cancels the start under way whose Request.StartID is
+// startID.  That start's call then fails with an *Error whose Code is
+// ErrCodeStartCancelled, once the daemon has removed what it made.
+func (c *Client) CancelStart(ctx context.Context, startID string) error {
+	_, err := c.Do(ctx, Request{Type: ReqCancelStart, StartID: startID})
+	return err
+}
+
+// Stop kills an instance's agent; the instance stays, as KILLED.
+func (c *Client) Stop(ctx context.Context, instanceID string) error {
+	_, err := c.Do(ctx, Request{Type: ReqStop, InstanceID: instanceID})
+	return err
+}
+
+// Drop deletes an instance with its container, worktree and branch.  If
+// the daemon could not remove all of them, the instance is gone but Drop
+// returns an error naming what is left.
+func (c *Client) Drop(ctx context.Context, instanceID string) error {
+	resp, err := c.Do(ctx, Request{Type: ReqDrop, InstanceID: instanceID})
+	if err == nil && len(resp.Residue) > 0 {
+		err = fmt.Errorf("dropped instance %s, but: %s", instanceID, strings.Join(resp.Residue, "; "))
+	}
+	return err
+}
+
+// LogsOptions selects what Logs returns.
+type LogsOptions struct {
+	Follow  bool   // keep streaming new output until the agent exits
+	Section string // LogSectionSetup or LogSectionAgent: part of the log file instead
+	Helper  string // a helper agent's output instead of the primary agent's
+	Tail    int    // only about the last Tail bytes of the output, from a line start
+}
+
+// Logs returns an instance's output as a stream, which the caller closes.
+// A large log comes gzipped and is decompressed on the way; a stream cut
+// short then fails with io.ErrUnexpectedEOF rather than ending early.
+func (c *Client) Logs(ctx context.Context, instanceID string, opts LogsOptions) (io.ReadCloser, error) {
+	req := Request{Type: ReqLogs, InstanceID: instanceID, LogSection: opts.Section, AgentName: opts.Helper, LogTail: opts.Tail, AcceptGzip: true}
+	if opts.Follow {
+		req.Type, req.AcceptGzip = ReqLogsFollow, false
+	}
+	resp, s, err := c.Open(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.Encoding {
+	case "":
+		return s, nil
+	case proto.EncodingGzip:
+		zr, err := gzip.NewReader(s)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("logs: %w", err)
+		}
+		return &gzipStream{Reader: zr, s: s}, nil
+	}
+	s.Close()
+	return nil, fmt.Errorf("logs: unknown encoding %q", resp.Encoding)
+}
+
+// gzipStream decompresses a Stream.
+type gzipStream struct {
+	*gzip.Reader
+	s *Stream
+}
+
+// Close closes the stream.
+func (g *gzipStream) Close() error {
+	g.Reader.Close()
+	return g.s.Close()
+}
+
+// RunOptions are the options of Check and Finish.
+type RunOptions struct {
+	CurrentConfig bool // run the commands the project's config has now, not those the instance started with
+	Force         bool // Check only: clear a CHECKING state left by a check that is no longer running
+
+	// Output receives the commands' output as they run; nil discards it.
+	Output io.Writer
+	// Input, if set, is passed to commands marked tty: true in grove.yaml,
+	// which then get a terminal.  EOF on it detaches.
+	Input io.Reader
+}
+
+// Check runs an instance's check commands and returns how they went.  A
+// failed command is reported in the result, not as an error.
+func (c *Client) Check(ctx context.Context, instanceID string, opts RunOptions) (*StreamResult, error) {
+	res, _, err := c.RunStream(ctx, Request{Type: ReqCheck, InstanceID: instanceID, CurrentConfig: opts.CurrentConfig, Force: opts.Force}, opts.Output, opts.Input)
+	return res, err
+}
+
+// Finish runs an instance's finish steps and returns how they went.
+func (c *Client) Finish(ctx context.Context, instanceID string, opts RunOptions) (*StreamResult, error) {
+	res, _, err := c.RunStream(ctx, Request{Type: ReqFinish, InstanceID: instanceID, CurrentConfig: opts.CurrentConfig}, opts.Output, opts.Input)
+	return res, err
+}
+
+// RunStream sends a request whose output ends in a result trailer (check,
+// finish, project delete), copies the output to out and returns the result
+// along with its raw JSON.  With in set, the request is marked interactive
+// and in is forwarded to the daemon as it is read.
+func (c *Client) RunStream(ctx context.Context, req Request, out io.Writer, in io.Reader) (*StreamResult, []byte, error) {
+	req.Interactive = req.Interactive || in != nil
+	_, s, err := c.Open(ctx, req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer s.Close()
+	if in != nil {
+		go forwardInput(s, in)
+	}
+	if out == nil {
+		out = io.Discard
+	}
+	res, raw, err := proto.SplitResult(s, out)
+	if err != nil {
+		return nil, raw, err
+	}
+	if res == nil {
+		return nil, nil, errors.New("connection closed before the daemon reported a result")
+	}
+	return res, raw, nil
+}
+
+// forwardInput sends what is read from in to w as attach data frames, and a
+// detach frame at EOF.
+func forwardInput(w io.Writer, in io.Reader) {
+	fw := proto.NewFrameWriter(w)
+	buf := make([]byte, 1024)
+	for {
+		n, err := in.Read(buf)
+		if n > 0 {
+			if fw.WriteFrame(proto.AttachFrameData, buf[:n]) != nil {
+				return
+			}
+		}
+		if err != nil {
+			fw.WriteFrame(proto.AttachFrameDetach, nil)
+			return
+		}
+	}
+}
+
+// LogsAllOptions selects what LogsAll returns.
+type LogsAllOptions struct {
+	Project string    // only this project's instances; empty for all
+	Since   time.Time // only output from then on; zero for all the daemon holds
+	Follow  bool      // keep streaming new output until the stream is closed
+}
+
+// LogLineStream is the stream of lines LogsAll returns.
+type LogLineStream struct {
+	s   *Stream
+	dec *json.Decoder
+}
+
+// LogsAll returns the agent output of every instance, or of one project's,
+// as lines merged in the order they were output.
+func (c *Client) LogsAll(ctx context.Context, opts LogsAllOptions) (*LogLineStream, error) {
+	req := Request{Type: ReqLogsAll, Project: opts.Project, Follow: opts.Follow}
+	if !opts.Since.IsZero() {
+		req.Since = opts.Since.Unix()
+	}
+	_, s, err := c.Open(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return &LogLineStream{s: s, dec: json.NewDecoder(s)}, nil
+}
+
+// Next blocks until the next line.  It returns io.EOF at the end of the
+// stream.
+func (l *LogLineStream) Next() (LogLine, error) {
+	var line LogLine
+	if err := l.dec.Decode(&line); err != nil {
+		return LogLine{}, l.s.wrap(err)
+	}
+	return line, nil
+}
+
+// Close ends the stream.
+func (l *LogLineStream) Close() error { return l.s.Close() }
+
+// EventStream is the stream of instance events Events returns.
+type EventStream struct {
+	s   *Stream
+	dec *json.Decoder
+	raw json.RawMessage
+}
+
+// Events subscribes to instance events (an agent reporting READY, a check
+// or finish ending, ...) as they happen.
+func (c *Client) Events(ctx context.Context) (*EventStream, error) {
+	_, s, err := c.Open(ctx, Request{Type: ReqEvents})
+	if err != nil {
+		return nil, err
+	}
+	return &EventStream{s: s, dec: json.NewDecoder(s)}, nil
+}
+
+// Next blocks until the next event.  It returns io.EOF if the daemon
+// closes the stream.
+func (e *EventStream) Next() (Event, error) {
+	for {
+		e.raw = nil
+		if err := e.dec.Decode(&e.raw); err != nil {
+			return Event{}, e.s.wrap(err)
+		}
+		var ev Event
+		if err := json.Unmarshal(e.raw, &ev); err == nil {
+			return ev, nil
+		}
+	}
+}
+
+// Raw returns the JSON of the event Next last returned, including any
+// fields Event does not know about.
+func (e *EventStream) Raw() []byte { return e.raw }
+
+// Close ends the subscription.
+func (e *EventStream) Close() error { return e.s.Close() }
@@ -0,0 +1,508 @@
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"go/importer"
+	"go/token"
+	"go/types"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDaemon serves each connection with handle, given the request read
+// from it, on a Unix socket in a temporary directory.
+func fakeDaemon(t *testing.T, handle func(conn net.Conn, req Request)) *Client {
+	t.Helper()
+	sock := filepath.Join(t.TempDir(), "groved.sock")
+	ln, err := net.Listen("unix", sock)
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req, err := readRequest(conn)
+				if err != nil {
+					return
+				}
+				handle(conn, req)
+			}()
+		}
+	}()
+	return New(sock)
+}
+
+// readRequest reads one request line a byte at a time, as the daemon does,
+// leaving any stream that follows unread.
+func readRequest(r io.Reader) (Request, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		if _, err := r.Read(b); err != nil {
+			return Request{}, err
+		}
+		if b[0] == '\n' {
+			break
+		}
+		line = append(line, b[0])
+	}
+	var req Request
+	err := json.Unmarshal(line, &req)
+	return req, err
+}
+
+func respond(conn net.Conn, resp Response) {
+	data, _ := json.Marshal(resp)
+	conn.Write(append(data, '\n'))
+}
+
+func TestDo(t *testing.T) {
+	c := fakeDaemon(t, func(conn net.Conn, req Request) {
+		switch req.Type {
+		case ReqList:
+			respond(conn, Response{OK: true, Instances: []InstanceInfo{{ID: "1", Project: "app"}, {ID: req.InstanceID}}})
+		case ReqStart:
+			respond(conn, Response{OK: false, Error: "git clone failed", ErrorCode: ErrCodeGitAuth, Hint: "ssh-add", InitPath: "/x"})
+		default:
+			respond(conn, Response{OK: true})
+		}
+	})
+	ctx := context.Background()
+
+	require.NoError(t, c.Ping(ctx))
+	instances, err := c.List(ctx, ListOptions{InstanceID: "2"})
+	require.NoError(t, err)
+	assert.Equal(t, []InstanceInfo{{ID: "1", Project: "app"}, {ID: "2"}}, instances)
+
+	_, err = c.Start(ctx, Request{Project: "app", Branch: "b"}, nil)
+	var refused *Error
+	require.ErrorAs(t, err, &refused)
+	assert.Equal(t, "git clone failed", refused.Error())
+	assert.Equal(t, ErrCodeGitAuth, refused.Code)
+	assert.Equal(t, "ssh-add", refused.Hint)
+	assert.Equal(t, "/x", refused.Response.InitPath)
+
+	resp, err := c.Do(ctx, Request{Type: ReqStart})
+	assert.Error(t, err)
+	assert.Equal(t, "/x", resp.InitPath, "Do returns a refusal's response too")
+}
+
+func TestDropResidue(t *testing.T) {
+	c := fakeDaemon(t, func(conn net.Conn, req Request) {
+		if req.InstanceID == "2" {
+			respond(conn, Response{OK: true, Residue: []string{"could not remove worktree /w: left directory /w"}})
+			return
+		}
+		respond(conn, Response{OK: true})
+	})
+	require.NoError(t, c.Drop(context.Background(), "1"))
+	assert.EqualError(t, c.Drop(context.Background(), "2"), "dropped instance 2, but: could not remove worktree /w: left directory /w")
+}
+
+func TestStartStreamsSetup(t *testing.T) {
+	c := fakeDaemon(t, func(conn net.Conn, req Request) {
+		if req.Type != ReqStart || req.Project != "app" {
+			respond(conn, Response{Error: "unexpected " + req.Type})
+			return
+		}
+		respond(conn, Response{OK: true, InstanceID: "7"})
+		io.WriteString(conn, "Cloning…\ndone\n")
+	})
+	var setup bytes.Buffer
+	id, err := c.Start(context.Background(), Request{Project: "app", Branch: "b"}, &setup)
+	require.NoError(t, err)
+	assert.Equal(t, "7", id)
+	assert.Equal(t, "Cloning…\ndone\n", setup.String(), "nothing after the response is lost")
+}
+
+func TestCancelStart(t *testing.T) {
+	c := fakeDaemon(t, func(conn net.Conn, req Request) {
+		if req.Type != ReqCancelStart || req.StartID != "s1" {
+			respond(conn, Response{Error: "no start under way with ID " + req.StartID})
+			return
+		}
+		respond(conn, Response{OK: true})
+	})
+	require.NoError(t, c.CancelStart(context.Background(), "s1"))
+	assert.EqualError(t, c.CancelStart(context.Background(), "s2"), "no start under way with ID s2")
+}
+
+func TestLogs(t *testing.T) {
+	c := fakeDaemon(t, func(conn net.Conn, req Request) {
+		respond(conn, Response{OK: true})
+		io.WriteString(conn, req.Type+" "+req.LogSection+" "+req.AgentName)
+	})
+	for opts, want := range map[LogsOptions]string{
+		{}:                             "logs  ",
+		{Follow: true}:                 "logs_follow  ",
+		{Section: LogSectionSetup}:     "logs setup ",
+		{Helper: "reviewer"}:           "logs  reviewer",
+		{Follow: true, Helper: "docs"}: "logs_follow  docs",
+	} {
+		logs, err := c.Logs(context.Background(), "3", opts)
+		require.NoError(t, err)
+		out, err := io.ReadAll(logs)
+		require.NoError(t, err)
+		logs.Close()
+		assert.Equal(t, want, string(out))
+	}
+
+	c = fakeDaemon(t, func(conn net.Conn, req Request) {
+		assert.Equal(t, 4096, req.LogTail)
+		respond(conn, Response{OK: true})
+	})
+	logs, err := c.Logs(context.Background(), "3", LogsOptions{Tail: 4096})
+	require.NoError(t, err)
+	logs.Close()
+}
+
+func TestLogsGzip(t *testing.T) {
+	var zipped bytes.Buffer
+	zw := gzip.NewWriter(&zipped)
+	zw.Write(bytes.Repeat([]byte("output\n"), 1000))
+	zw.Close()
+
+	for _, tc := range []struct {
+		name string
+		body []byte
+		err  error
+	}{
+		{"whole", zipped.Bytes(), nil},
+		{"truncated", zipped.Bytes()[:zipped.Len()/2], io.ErrUnexpectedEOF},
+		{"trailer cut", zipped.Bytes()[:zipped.Len()-4], io.ErrUnexpectedEOF},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := fakeDaemon(t, func(conn net.Conn, req Request) {
+				assert.True(t, req.AcceptGzip)
+				respond(conn, Response{OK: true, Encoding: proto.EncodingGzip})
+				conn.Write(tc.body)
+			})
+			logs, err := c.Logs(context.Background(), "3", LogsOptions{})
+			require.NoError(t, err)
+			defer logs.Close()
+			out, err := io.ReadAll(logs)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, strings.Repeat("output\n", 1000), string(out))
+		})
+	}
+
+	c := fakeDaemon(t, func(conn net.Conn, req Request) {
+		assert.False(t, req.AcceptGzip, "a follow streams as output comes")
+		respond(conn, Response{OK: true, Encoding: "br"})
+	})
+	_, err := c.Logs(context.Background(), "3", LogsOptions{Follow: true})
+	assert.ErrorContains(t, err, `unknown encoding "br"`)
+}
+
+func TestEvents(t *testing.T) {
+	c := fakeDaemon(t, func(conn net.Conn, req Request) {
+		respond(conn, Response{OK: true})
+		io.WriteString(conn, `{"type":"ready","instance_id":"1","state":"READY","future":true}`+"\n")
+		io.WriteString(conn, `{"type":"check","instance_id":"2","result":{"ok":true,"commands":null,"duration_ms":5}}`+"\n")
+	})
+	events, err := c.Events(context.Background())
+	require.NoError(t, err)
+	defer events.Close()
+
+	ev, err := events.Next()
+	require.NoError(t, err)
+	assert.Equal(t, Event{Type: "ready", InstanceID: "1", State: StateReady}, ev)
+	assert.Contains(t, string(events.Raw()), `"future":true`, "Raw keeps fields Event does not know")
+	ev, err = events.Next()
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), ev.Result.DurationMs)
+	_, err = events.Next()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestLogsAll(t *testing.T) {
+	since := time.Unix(1700000000, 0)
+	c := fakeDaemon(t, func(conn net.Conn, req Request) {
+		assert.Equal(t, Request{Type: ReqLogsAll, Project: "api", Since: since.Unix(), Follow: true}, req)
+		respond(conn, Response{OK: true})
+		io.WriteString(conn, `{"instance_id":"1","project":"api","branch":"feat","time_ms":1700000000500,"text":"hello"}`+"\n")
+	})
+	lines, err := c.LogsAll(context.Background(), LogsAllOptions{Project: "api", Since: since, Follow: true})
+	require.NoError(t, err)
+	defer lines.Close()
+	line, err := lines.Next()
+	require.NoError(t, err)
+	assert.Equal(t, LogLine{InstanceID: "1", Project: "api", Branch: "feat", TimeMs: 1700000000500, Text: "hello"}, line)
+	_, err = lines.Next()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestRunStream(t *testing.T) {
+	c := fakeDaemon(t, func(conn net.Conn, req Request) {
+		respond(conn, Response{OK: true})
+		io.WriteString(conn, "go test ./...\nok\n")
+		if req.Interactive {
+			// Echo what the user types, up to the detach at EOF.
+			for {
+				frameType, payload, err := proto.ReadFrame(conn)
+				if err != nil || frameType == proto.AttachFrameDetach {
+					break
+				}
+				conn.Write(payload)
+			}
+		}
+		proto.WriteResultTrailer(conn, proto.StreamResult{
+			OK:       req.Type == ReqCheck && req.Force,
+			Commands: []proto.CommandResult{{Command: "go test ./...", ExitCode: 0}},
+		})
+	})
+	ctx := context.Background()
+
+	var out bytes.Buffer
+	res, err := c.Check(ctx, "1", RunOptions{Force: true, Output: &out})
+	require.NoError(t, err)
+	assert.True(t, res.OK)
+	assert.Equal(t, "go test ./...\nok\n", out.String(), "the trailer is not output")
+
+	res, err = c.Finish(ctx, "1", RunOptions{})
+	require.NoError(t, err)
+	assert.False(t, res.OK, "a failed run is a result, not an error")
+
+	out.Reset()
+	res, raw, err := c.RunStream(ctx, Request{Type: ReqCheck, Force: true}, &out, strings.NewReader("y\n"))
+	require.NoError(t, err)
+	assert.True(t, res.OK)
+	assert.Equal(t, "go test ./...\nok\ny\n", out.String(), "input is forwarded")
+	assert.True(t, json.Valid(raw))
+}
+
+func TestRunStreamWithoutTrailer(t *testing.T) {
+	c := fakeDaemon(t, func(conn net.Conn, req Request) {
+		respond(conn, Response{OK: true})
+		io.WriteString(conn, "partial")
+	})
+	_, err := c.Check(context.Background(), "1", RunOptions{})
+	assert.ErrorContains(t, err, "before the daemon reported a result")
+}
+
+func TestAttach(t *testing.T) {
+	typed := make(chan string, 1)
+	resized := make(chan [2]uint16, 1)
+	c := fakeDaemon(t, func(conn net.Conn, req Request) {
+		if req.AgentName != "reviewer" || !req.AttachV2 {
+			respond(conn, Response{Error: "bad attach"})
+			return
+		}
+		respond(conn, Response{OK: true, AttachV2: true})
+		proto.WriteFrame(conn, proto.AttachFrameData, []byte("hello "))
+		proto.WriteFrame(conn, proto.AttachFrameState, []byte(`{"state":"WAITING","state_since":100}`))
+		proto.WriteFrame(conn, proto.AttachFramePing, nil)
+		proto.WriteFrame(conn, 0x7f, []byte("from a newer daemon"))
+		proto.WriteFrame(conn, proto.AttachFrameData, []byte("world"))
+		for i := 0; i < 2; i++ {
+			frameType, payload, err := proto.ReadFrame(conn)
+			if err != nil {
+				return
+			}
+			switch frameType {
+			case proto.AttachFrameData:
+				typed <- string(payload)
+			case proto.AttachFrameResize:
+				cols, rows, _ := proto.ParseResizePayload(payload)
+				resized <- [2]uint16{cols, rows}
+			}
+		}
+		proto.WriteFrame(conn, proto.AttachFrameExit, []byte(`{"state":"CRASHED","exit_code":2}`))
+	})
+
+	sess, err := c.Attach(context.Background(), "1", AttachOptions{Agent: "reviewer"})
+	require.NoError(t, err)
+	defer sess.Close()
+	assert.True(t, sess.Reports())
+
+	_, err = sess.Write([]byte("ls\r"))
+	require.NoError(t, err)
+	require.NoError(t, sess.Resize(120, 40))
+	out, err := io.ReadAll(sess)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(out), "control frames are not output")
+	assert.Equal(t, "ls\r", <-typed)
+	assert.Equal(t, [2]uint16{120, 40}, <-resized)
+	assert.Equal(t, AttachState{State: StateWaiting, StateSince: 100}, sess.State())
+	assert.Equal(t, &AttachExit{State: StateCrashed, ExitCode: 2}, sess.Exit())
+}
+
+func TestAttachOldDaemon(t *testing.T) {
+	c := fakeDaemon(t, func(conn net.Conn, req Request) {
+		respond(conn, Response{OK: true})
+		io.WriteString(conn, "raw output")
+	})
+	sess, err := c.Attach(context.Background(), "1", AttachOptions{})
+	require.NoError(t, err)
+	defer sess.Close()
+	assert.False(t, sess.Reports())
+	out, err := io.ReadAll(sess)
+	require.NoError(t, err)
+	assert.Equal(t, "raw output", string(out))
+	assert.Nil(t, sess.Exit())
+}
+
+func TestAttachCommand(t *testing.T) {
+	c := fakeDaemon(t, func(conn net.Conn, req Request) {
+		if req.Command != "git add -p" {
+			// An older daemon, which attaches to the agent.
+			respond(conn, Response{OK: true, AttachV2: true})
+			return
+		}
+		respond(conn, Response{OK: true, AttachV2: true, AttachCommand: true})
+		proto.WriteFrame(conn, proto.AttachFrameData, []byte("diff"))
+		proto.WriteFrame(conn, proto.AttachFrameExit, []byte(`{"state":"CRASHED","exit_code":3}`))
+	})
+
+	sess, err := c.Attach(context.Background(), "1", AttachOptions{Command: "git add -p"})
+	require.NoError(t, err)
+	defer sess.Close()
+	out, err := io.ReadAll(sess)
+	require.NoError(t, err)
+	assert.Equal(t, "diff", string(out))
+	assert.Equal(t, &AttachExit{State: StateCrashed, ExitCode: 3}, sess.Exit())
+
+	_, err = c.Attach(context.Background(), "1", AttachOptions{Command: "tig"})
+	assert.ErrorContains(t, err, "restart it")
+}
+
+func TestContext(t *testing.T) {
+	c := fakeDaemon(t, func(conn net.Conn, req Request) {
+		if req.Type == ReqLogsFollow {
+			respond(conn, Response{OK: true})
+		}
+		time.Sleep(5 * time.Second) // never answers, or never ends
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := c.List(ctx, ListOptions{})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 2*time.Second)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	logs, err := c.Logs(ctx, "1", LogsOptions{Follow: true})
+	require.NoError(t, err)
+	defer logs.Close()
+	time.AfterFunc(50*time.Millisecond, cancel)
+	_, err = io.ReadAll(logs)
+	assert.ErrorIs(t, err, context.Canceled, "cancelling ends a stream")
+}
+
+func TestDialer(t *testing.T) {
+	dialErr := errors.New("no route to daemon")
+	c := NewWithDialer(func(context.Context) (net.Conn, error) { return nil, dialErr })
+	assert.ErrorIs(t, c.Ping(context.Background()), dialErr)
+
+	// Any net.Conn will do, e.g. one end of a pipe.
+	c = NewWithDialer(func(context.Context) (net.Conn, error) {
+		client, server := net.Pipe()
+		go func() {
+			defer server.Close()
+			if _, err := readRequest(server); err == nil {
+				respond(server, Response{OK: true})
+			}
+		}()
+		return client, nil
+	})
+	assert.NoError(t, c.Ping(context.Background()))
+}
+
+func TestDefaultSocketPath(t *testing.T) {
+	t.Setenv("GROVE_ROOT", "/srv/grove")
+	assert.Equal(t, "/srv/grove/groved.sock", DefaultSocketPath())
+	t.Setenv("GROVE_ROOT", "")
+	t.Setenv("HOME", "/home/me")
+	assert.Equal(t, "/home/me/.grove/groved.sock", DefaultSocketPath())
+	t.Setenv("GROVE_PROFILE", "dev")
+	assert.Equal(t, "/home/me/.grove-dev/groved.sock", DefaultSocketPath())
+	t.Setenv("GROVE_SOCKET", "/tmp/other.sock")
+	assert.Equal(t, "/tmp/other.sock", DefaultSocketPath())
+}
+
+// TestPublicTypes fails if anything the package exports reaches a type from
+// an internal package, which users of the client could not name.
+func TestPublicTypes(t *testing.T) {
+	pkg, err := importer.ForCompiler(token.NewFileSet(), "source", nil).Import("github.com/gandalfthegui/grove/pkg/client")
+	require.NoError(t, err)
+	seen := make(map[types.Type]bool)
+	var walk func(typ types.Type, path string)
+	walk = func(typ types.Type, path string) {
+		if seen[typ] {
+			return
+		}
+		seen[typ] = true
+		switch typ := typ.(type) {
+		case *types.Named:
+			if p := typ.Obj().Pkg(); p != nil && strings.Contains(p.Path()+"/", "/internal/") {
+				t.Errorf("%s is %s, from %s", path, typ.Obj().Name(), p.Path())
+				return
+			}
+			for i := 0; i < typ.NumMethods(); i++ {
+				if m := typ.Method(i); m.Exported() {
+					walk(m.Type(), path+"."+m.Name())
+				}
+			}
+			walk(typ.Underlying(), path)
+		case *types.Alias:
+			walk(types.Unalias(typ), path)
+		case *types.Pointer:
+			walk(typ.Elem(), path)
+		case *types.Slice:
+			walk(typ.Elem(), path)
+		case *types.Array:
+			walk(typ.Elem(), path)
+		case *types.Map:
+			walk(typ.Key(), path)
+			walk(typ.Elem(), path)
+		case *types.Chan:
+			walk(typ.Elem(), path)
+		case *types.Struct:
+			for i := 0; i < typ.NumFields(); i++ {
+				if f := typ.Field(i); f.Exported() {
+					walk(f.Type(), path+"."+f.Name())
+				}
+			}
+		case *types.Signature:
+			for _, tuple := range []*types.Tuple{typ.Params(), typ.Results()} {
+				for i := 0; i < tuple.Len(); i++ {
+					walk(tuple.At(i).Type(), path)
+				}
+			}
+		case *types.Interface:
+			for i := 0; i < typ.NumMethods(); i++ {
+				if m := typ.Method(i); m.Exported() {
+					walk(m.Type(), path+"."+m.Name())
+				}
+			}
+		}
+	}
+	scope := pkg.Scope()
+	for _, name := range scope.Names() {
+		if obj := scope.Lookup(name); obj.Exported() {
+			walk(obj.Type(), name)
+		}
+	}
+	assert.True(t, seen[types.Unalias(scope.Lookup("InstanceInfo").Type())])
+}
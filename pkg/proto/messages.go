@@ -0,0 +1,1213 @@
+// Package proto defines the IPC message types and attach-stream framing
+// used between grove (client) and groved (daemon) over a Unix domain socket.
+//
+// Normal commands use newline-delimited JSON: client sends one Request,
+// daemon sends one Response, then the connection closes.
+//
+// The attach command is special: after the JSON handshake the connection
+// enters a streaming mode where the server sends raw PTY output and the
+// client sends framed control messages (data, resize, detach).
+//
+// check and finish stream command output after the response and end it with
+// a result trailer (see StreamResult).
+//
+// The package is public because package client's types are its types, so
+// changing one changes the client's API.
+package proto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Request type constants.
+const (
+	ReqPing       = "ping"
+	ReqStart      = "start"
+	ReqList       = "list"
+	ReqAttach     = "attach"
+	ReqLogs       = "logs"
+	ReqLogsFollow = "logs_follow"
+	ReqLogsAll    = "logs_all"
+	ReqStop       = "stop"
+	ReqDrop       = "drop"
+	ReqFinish     = "finish"
+	ReqRestart    = "restart"
+	ReqCheck      = "check"
+	ReqNote       = "note"
+	ReqLabel      = "label"
+	ReqCopy       = "copy"
+	ReqStats      = "stats"
+	ReqEvents     = "events"
+	ReqReopen     = "reopen"
+	ReqShowConfig = "show_config"
+	ReqGC         = "gc"
+	ReqUsage      = "usage"
+	ReqTTL        = "ttl"
+	ReqPin        = "pin"
+
+	ReqCancelStart = "cancel_start"
+
+	ReqContainerRm = "container_rm"
+	ReqWorktreeRm  = "worktree_rm"
+
+	ReqProjects       = "projects"
+	ReqProjectResolve = "project_resolve"
+	ReqProjectDelete  = "project_delete"
+	ReqProjectFetch   = "project_fetch"
+	ReqProjectLock    = "project_lock"
+	ReqProjectMove    = "project_move"
+	ReqWorktrees      = "worktrees"
+)
+
+// Copy direction constants for ReqCopy.
+const (
+	CopyOut = "out" // worktree → client
+	CopyIn  = "in"  // client → worktree
+)
+
+// EncodingGzip is Response.Encoding for a gzip-compressed stream.
+const EncodingGzip = "gzip"
+
+// Instance state constants.
+const (
+	StateRunning  = "RUNNING"
+	StateWaiting  = "WAITING"
+	StateAttached = "ATTACHED"
+	StateExited   = "EXITED"
+	StateCrashed  = "CRASHED"
+	StateKilled   = "KILLED"
+	StateFinished = "FINISHED"
+	StateChecking = "CHECKING"
+	StateReady    = "READY" // agent reported its task done; awaiting review
+
+	// StateFinishFailed marks an instance whose finish commands were cut
+	// short by a daemon restart and could not be resumed.
+	StateFinishFailed = "FINISH_FAILED"
+
+	// StateFailedSetup marks an instance whose container could not be
+	// recreated (grove restart --recreate-container, or reopen).  It has
+	// no container until a recreate succeeds.
+	StateFailedSetup = "FAILED_SETUP"
+
+	// StateStalled marks an instance whose agent was caught in a loop:
+	// printing the same error over and over, or crashing right after each
+	// launch.  grove restart relaunches it.
+	StateStalled = "STALLED"
+
+	// StateBroken marks an instance whose worktree directory disappeared
+	// while it was live.  The daemon stops its agent; grove drop removes it.
+	StateBroken = "BROKEN"
+)
+
+// End reason constants: why an instance's agent stopped, recorded in
+// InstanceInfo.EndReason alongside the state it ended in.
+const (
+	// EndStopped: grove stop, drop or finish killed it.  The instance is
+	// KILLED (or FINISHED) whatever the exit status.
+	EndStopped = "stopped"
+	// EndExited: the agent exited by itself, EXITED on status 0 and
+	// CRASHED otherwise; InstanceInfo.ExitCode holds the status.
+	EndExited = "exited"
+	// EndDaemonExit: the agent was still running when the daemon went
+	// away.  The instance is CRASHED.
+	EndDaemonExit = "daemon_exit"
+	// EndStalled: the daemon caught the agent in a loop.  The instance is
+	// STALLED.
+	EndStalled = "stalled"
+	// EndBroken: the instance's worktree disappeared and the daemon stopped
+	// the agent.  The instance is BROKEN.
+	EndBroken = "broken"
+)
+
+// TTL actions: what the daemon does with an instance whose time to live
+// has run out.
+const (
+	TTLFinish = "finish" // grove finish it; the default
+	TTLDrop   = "drop"   // stop and drop it
+)
+
+// Agent modes: how much the agent may do without review.  grove start
+// --mode translates them into arguments for the agents it knows.
+const (
+	ModePlan   = "plan"   // propose changes only
+	ModeNormal = "normal" // ask before changing files, as the agent does by default
+	ModeAuto   = "auto"   // change files and run commands without asking
+)
+
+// IsTerminal reports whether state is a terminal (non-restartable) state:
+// EXITED, CRASHED, KILLED, FINISHED, FINISH_FAILED, FAILED_SETUP, STALLED
+// or BROKEN.
+func IsTerminal(state string) bool {
+	switch state {
+	case StateExited, StateCrashed, StateKilled, StateFinished, StateFinishFailed, StateFailedSetup, StateStalled, StateBroken:
+		return true
+	}
+	return false
+}
+
+// Request is the JSON payload sent from grove to groved.
+type Request struct {
+	Type       string `json:"type"`
+	Project    string `json:"project,omitempty"`
+	Branch     string `json:"branch,omitempty"`
+	InstanceID string `json:"instance_id,omitempty"`
+
+	// AgentEnv carries environment variables that the client extracted on the
+	// host (e.g. OAuth tokens from the macOS Keychain) and that must be
+	// injected into the agent's docker exec session.
+	AgentEnv map[string]string `json:"agent_env,omitempty"`
+
+	// Cols and Rows carry the client's terminal size on ReqStart and
+	// ReqRestart (even when detaching) so the agent's PTY starts at a
+	// realistic size instead of the library default.  Zero means unknown.
+	Cols uint16 `json:"cols,omitempty"`
+	Rows uint16 `json:"rows,omitempty"`
+
+	// Fields used by ReqNote: Text is appended as a new note; Clear removes
+	// all existing notes instead.
+	Text  string `json:"text,omitempty"`
+	Clear bool   `json:"clear,omitempty"`
+
+	// Labels are key=value tags.  On ReqStart they are applied to the new
+	// instance; on ReqLabel they are merged into the existing set, after
+	// RemoveLabels keys have been deleted.
+	Labels       map[string]string `json:"labels,omitempty"`
+	RemoveLabels []string          `json:"remove_labels,omitempty"`
+
+	// Fields used by ReqStart: the new branch is created from Base (a
+	// branch, tag or commit) rather than the main checkout's HEAD; Agent
+	// is a command line run instead of grove.yaml's agent.command and
+	// agent.args; Task describes the work, for the agent (as GROVE_TASK)
+	// and grove status.
+	Base  string `json:"base,omitempty"`
+	Agent string `json:"agent,omitempty"`
+	Task  string `json:"task,omitempty"`
+
+	// TTL, for ReqStart, is how long the instance may live, in seconds,
+	// before the daemon does TTLAction (TTLFinish or TTLDrop; empty means
+	// finish) with it; zero means no limit.  For ReqTTL it is the new
+	// time to live, counted from now; zero removes the limit.
+	TTL       int64  `json:"ttl,omitempty"`
+	TTLAction string `json:"ttl_action,omitempty"`
+
+	// Mode, for ReqStart, is the agent's mode (ModePlan, ModeNormal or
+	// ModeAuto); empty means ModeNormal.
+	Mode string `json:"mode,omitempty"`
+
+	// Pin, for ReqStart, starts the instance pinned; for ReqPin it pins
+	// the instance, or unpins it if false.
+	Pin bool `json:"pin,omitempty"`
+
+	// StartID, for ReqStart, names the start so that ReqCancelStart can
+	// cancel it while it is under way; for ReqCancelStart it is the start
+	// to cancel.  The client chooses it, unique among the starts under way.
+	StartID string `json:"start_id,omitempty"`
+
+	// DryRun, for ReqStart, checks what the start would need instead of
+	// starting anything: a report streams after the response, ending with a
+	// result trailer whose OK says whether every check passed.
+	DryRun bool `json:"dry_run,omitempty"`
+
+	// Scratch, for ReqStart, starts a scratch instance instead of one of
+	// Project: from a repo URL or an absolute path to a local directory,
+	// without registering a project.  Branch may be empty; InPlace works in
+	// the local directory itself instead of a worktree of a clone of it.
+	Scratch string `json:"scratch,omitempty"`
+	InPlace bool   `json:"in_place,omitempty"`
+
+	// Fields used by ReqCopy: Path is relative to the instance worktree and
+	// Direction is CopyOut or CopyIn.  After the handshake a tar stream
+	// follows (daemon → client for CopyOut, client → daemon for CopyIn);
+	// for CopyIn the daemon then sends a second Response with the result.
+	Path      string `json:"path,omitempty"`
+	Direction string `json:"direction,omitempty"`
+
+	// For ReqProjects, DiskUsage also measures the disk each project's main
+	// checkout and worktrees take.  That walks them, which takes seconds
+	// for big ones, so the daemon reuses a measurement for a few minutes.
+	DiskUsage bool `json:"disk_usage,omitempty"`
+
+	// For ReqProjectResolve, Project holds the argument to resolve: a
+	// project name or a 1-based index into the ReqProjects list.  Checkout
+	// clones the project's main checkout first if there is none yet, so
+	// that the reply describes its grove.yaml (grove start wants its
+	// defaults).
+	Checkout bool `json:"checkout,omitempty"`
+
+	// For ReqProjectDelete, KeepVolumes and KeepImages leave the project's
+	// grove-labelled Docker volumes and grove-build/<project> images alone.
+	// Progress is streamed after the response and ends with a result
+	// trailer, as for check and finish.
+	KeepVolumes bool `json:"keep_volumes,omitempty"`
+	KeepImages  bool `json:"keep_images,omitempty"`
+
+	// For ReqProjectFetch, Project names the project (name or index) whose
+	// main checkout to clone, or pull if it is cloned already.  Progress is
+	// streamed after the response and ends with a result trailer.  Prefetch
+	// marks the request grove project create sends instead: the daemon
+	// replies at once and fetches in the background, and only if its
+	// config sets prefetch_on_register.
+	Prefetch bool `json:"prefetch,omitempty"`
+
+	// For ReqProjectLock, Project names the project (name or index) whose
+	// lock on its main checkout to take, for grove project shell.  A
+	// successful response means the lock is held; it is released when the
+	// client closes the connection.  If another operation holds it, the
+	// daemon says which rather than wait.
+
+	// For ReqProjectMove, Project names the project (name or index) whose
+	// main checkout and worktrees to move into DataDir, an existing
+	// directory, which becomes its data_dir.  Progress is streamed after
+	// the response and ends with a result trailer.
+	DataDir string `json:"data_dir,omitempty"`
+
+	// For ReqWorktrees, Project names the project (name or index) whose git
+	// worktrees to list.  PruneWorktrees names worktrees, from an earlier
+	// listing, to remove first; any that are no longer orphans are left
+	// alone.
+	PruneWorktrees []string `json:"prune_worktrees,omitempty"`
+
+	// Fresh, for ReqRestart, skips the agent's resume args so it starts
+	// a new session instead of continuing the previous one.
+	Fresh bool `json:"fresh,omitempty"`
+
+	// CurrentConfig, for ReqRestart and ReqReopen, relaunches with the
+	// project's config as it is now instead of the config the instance was
+	// started with; for ReqCheck and ReqFinish it runs the commands the
+	// project's config has now.
+	CurrentConfig bool `json:"current_config,omitempty"`
+
+	// RecreateContainer, for ReqRestart, replaces the instance's container
+	// with a new one from the project's config as it is now (it implies
+	// CurrentConfig), keeping the worktree.  Setup output is streamed
+	// after the response, as for ReqReopen.
+	RecreateContainer bool `json:"recreate_container,omitempty"`
+
+	// KeepWorktree and KeepContainer, for ReqDrop, leave the instance's
+	// worktree (and its branch) or its container in place while the
+	// instance record goes.
+	KeepWorktree  bool `json:"keep_worktree,omitempty"`
+	KeepContainer bool `json:"keep_container,omitempty"`
+
+	// StopStack, for ReqStop, also stops the services of a compose
+	// instance (docker compose stop), as stop.stack in grove.yaml does.
+	StopStack bool `json:"stop_stack,omitempty"`
+
+	// Force, for ReqCheck, runs the check even though the instance is
+	// CHECKING, provided no check is actually running: it clears a
+	// CHECKING state left behind by a check that never finished.
+	Force bool `json:"force,omitempty"`
+
+	// Watch, for ReqCheck, keeps the stream open and runs the checks again
+	// whenever files in the worktree change, until the client hangs up.
+	// Each run's output is streamed as it is for a single check; a result
+	// trailer only ends the stream if the daemon stops watching.
+	Watch bool `json:"watch,omitempty"`
+
+	// Interactive, for ReqCheck and ReqFinish, says the client is at a
+	// terminal.  After the response it sends its stdin as attach data
+	// frames (ending with a detach frame at EOF), which are passed to
+	// commands marked tty: true.  Without it such commands are refused.
+	Interactive bool `json:"interactive,omitempty"`
+
+	// LogSection, for ReqLogs, selects part of the instance's log file
+	// instead of the agent's recent output: LogSectionSetup or
+	// LogSectionAgent.
+	LogSection string `json:"log_section,omitempty"`
+
+	// LogTail, for ReqLogs without LogSection, asks for only the last
+	// LogTail bytes of the agent's recent output, from the first line that
+	// starts in them.
+	LogTail int `json:"log_tail,omitempty"`
+
+	// AcceptGzip, for ReqLogs, lets the daemon gzip the log it sends after
+	// the response if it is large; Response.Encoding then says so.
+	AcceptGzip bool `json:"accept_gzip,omitempty"`
+
+	// Changes, for ReqList, fills in each instance's InstanceInfo.Changes
+	// from a summary at most a minute old; with InstanceID set, only that
+	// instance is listed.
+	Changes bool `json:"changes,omitempty"`
+
+	// MainAhead, for ReqList, fills in each instance's
+	// InstanceInfo.MainAhead.
+	MainAhead bool `json:"main_ahead,omitempty"`
+
+	// For ReqLogsAll, Project (empty for all) selects the instances whose
+	// agent output to merge, and Since, a unix timestamp, drops what they
+	// output before it.  Follow keeps the stream open for new output.
+	Since  int64 `json:"since,omitempty"`
+	Follow bool  `json:"follow,omitempty"`
+
+	// AgentName, for ReqAttach, ReqLogs and ReqLogsFollow, selects one of
+	// the instance's agents by its name in grove.yaml's agents list; empty
+	// means the primary agent.
+	AgentName string `json:"agent_name,omitempty"`
+
+	// AttachV2, for ReqAttach, asks the daemon to frame its side of the
+	// stream too, so that control frames can be sent along with the PTY
+	// output.  Without it the client gets raw bytes, as older clients
+	// expect.
+	AttachV2 bool `json:"attach_v2,omitempty"`
+
+	// Command, for ReqAttach, is a shell command line to run in a PTY of
+	// its own in the instance's container, attaching to it instead of to
+	// an agent.  It needs AttachV2: the exit frame carries its status.  It
+	// is killed if the client detaches or goes away.
+	Command string `json:"command,omitempty"`
+}
+
+// Log sections for Request.LogSection.
+const (
+	LogSectionSetup = "setup" // clone, container start, start commands, agent install
+	LogSectionAgent = "agent" // everything else: agent output, check and finish output
+)
+
+// LogRange is a byte range [Start, End) of an instance's log file.
+type LogRange struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+// Note is a timestamped free-form annotation attached to an instance.
+type Note struct {
+	Time int64  `json:"time"` // unix timestamp
+	Text string `json:"text"`
+}
+
+// InstanceInfo is a point-in-time snapshot of an instance's metadata.
+type InstanceInfo struct {
+	ID             string            `json:"id"`
+	Project        string            `json:"project"`
+	State          string            `json:"state"`
+	StateSince     int64             `json:"state_since,omitempty"` // unix timestamp the instance entered State; 0 if unknown
+	Branch         string            `json:"branch"`
+	WorktreeDir    string            `json:"worktree_dir"`
+	MainDir        string            `json:"main_dir,omitempty"`
+	CreatedAt      int64             `json:"created_at"`
+	Seq            int64             `json:"seq,omitempty"`        // creation order, counting up from 1; 0 for instances recorded by older daemons
+	EndedAt        int64             `json:"ended_at,omitempty"`   // unix timestamp; 0 if still running
+	EndReason      string            `json:"end_reason,omitempty"` // EndStopped etc.; set once a stop is requested or the agent ends
+	ExitCode       int               `json:"exit_code,omitempty"`  // the agent's exit status when EndReason is EndExited; -1 if killed by a signal
+	PID            int               `json:"pid"`
+	ContainerID    string            `json:"container_id,omitempty"`
+	ComposeProject string            `json:"compose_project,omitempty"`
+	Notes          []Note            `json:"notes,omitempty"`
+	Labels         map[string]string `json:"labels,omitempty"`
+	Summary        string            `json:"summary,omitempty"` // agent's summary from its last "done" report
+	Task           string            `json:"task,omitempty"`    // from grove start --task or the project's task_template
+	Mode           string            `json:"mode,omitempty"`    // the agent's mode; empty for agents without modes
+	LastCheck      *CheckResult      `json:"last_check,omitempty"`
+	LastFinish     *CheckResult      `json:"last_finish,omitempty"`
+	Runs           []AgentRun        `json:"runs,omitempty"`        // agent launches, oldest first
+	Remote         *RemoteStatus     `json:"remote,omitempty"`      // nil until the remote was first queried
+	Launch         *LaunchConfig     `json:"launch,omitempty"`      // nil for instances recorded by older daemons
+	Environment    *AgentEnvironment `json:"environment,omitempty"` // what the agent was last launched with
+	PendingFinish  *PendingFinish    `json:"pending_finish,omitempty"`
+	Checking       *CheckProgress    `json:"checking,omitempty"`      // while the instance is CHECKING
+	Running        []CommandProgress `json:"running,omitempty"`       // check and finish commands running now, oldest first
+	SetupLog       []LogRange        `json:"setup_log,omitempty"`     // setup output in the log file, one range per start or reopen
+	StartTimings   []StageTiming     `json:"start_timings,omitempty"` // how long each stage of grove start took, in order
+	Agents         []AgentInfo       `json:"agents,omitempty"`        // helper agents running beside the primary one
+	Changes        *ChangeSummary    `json:"changes,omitempty"`       // only in a ReqList reply with Request.Changes set
+	Scratch        *ScratchInfo      `json:"scratch,omitempty"`       // set for instances started by grove scratch
+	Image          *ContainerImage   `json:"image,omitempty"`         // what the container was created from; nil for instances recorded by older daemons
+	ImageDrift     string            `json:"image_drift,omitempty"`   // only in a ReqList reply: the image the project's config names now, if not Image.Ref
+	StackStopped   bool              `json:"stack_stopped,omitempty"` // the compose services were stopped with the agent; restart starts them
+	Usage          *AgentUsage       `json:"usage,omitempty"`         // nil until the agent's usage was first measured
+
+	// ContainerRemoved and WorktreeRemoved are set once ReqContainerRm or
+	// ReqWorktreeRm has removed that part of the instance; restart and
+	// reopen create it again.
+	ContainerRemoved bool `json:"container_removed,omitempty"`
+	WorktreeRemoved  bool `json:"worktree_removed,omitempty"`
+
+	// ExpiresAt is the unix time the instance's time to live runs out, at
+	// which the daemon does TTLAction with it; 0 if it has none.
+	ExpiresAt int64  `json:"expires_at,omitempty"`
+	TTLAction string `json:"ttl_action,omitempty"`
+
+	// Pinned instances are left alone by grove prune, grove stop --all and
+	// their time to live; only an explicit grove drop removes them.
+	Pinned bool `json:"pinned,omitempty"`
+
+	// BaseSHA is the commit the instance's branch shared with the main
+	// checkout's HEAD when its worktree was created: what the agent started
+	// from.  Empty for instances recorded by older daemons and in-place
+	// scratch instances.  MainAhead, only in a ReqList reply with
+	// Request.MainAhead set, is how many commits the main checkout's HEAD
+	// has gained since; 0 if it could not be worked out.
+	BaseSHA   string `json:"base_sha,omitempty"`
+	MainAhead int    `json:"main_ahead,omitempty"`
+}
+
+// ShortBaseSHA returns the first 7 hex digits of BaseSHA, as git log
+// --oneline shows them, or "" if it is unknown.
+func (i InstanceInfo) ShortBaseSHA() string {
+	if len(i.BaseSHA) > 7 {
+		return i.BaseSHA[:7]
+	}
+	return i.BaseSHA
+}
+
+// AgentUsage is what an instance's agent has cost, as reported by the
+// project's agent.usage_command.  It is measured when the agent exits and
+// on demand (ReqUsage).
+type AgentUsage struct {
+	Known   bool    `json:"known"`              // false if the command failed or printed no cost
+	CostUSD float64 `json:"cost_usd,omitempty"` // total cost in US dollars, when Known
+	Reason  string  `json:"reason,omitempty"`   // why it is not known
+	Time    int64   `json:"time"`               // unix time it was measured
+}
+
+// ScratchInfo describes a scratch instance: one started from a path or URL
+// rather than a registered project.  Its Project is "scratch:<name>".
+type ScratchInfo struct {
+	Source  string `json:"source"`             // the repo URL or local directory it was started from
+	DataDir string `json:"data_dir"`           // <root>/scratch/<id>: its clone and worktree, removed on drop
+	InPlace bool   `json:"in_place,omitempty"` // Source itself is the worktree; drop leaves it alone
+}
+
+// ContainerImage is the image an instance's container was created from.
+type ContainerImage struct {
+	Ref    string `json:"ref"`              // as docker run or compose was given it, e.g. node:20
+	Digest string `json:"digest,omitempty"` // the image ID docker resolved Ref to, e.g. sha256:3f2a…
+	// Platform is the image's os/arch, e.g. linux/amd64, if docker says.
+	Platform string `json:"platform,omitempty"`
+	// HostPlatform is set when the host's architecture differs from
+	// Platform's: the container runs under emulation, and slowly.
+	HostPlatform string `json:"host_platform,omitempty"`
+}
+
+// ShortDigest returns the first 12 hex digits of the image ID, as docker
+// images shows it, or "" if it is unknown.
+func (c ContainerImage) ShortDigest() string {
+	d := strings.TrimPrefix(c.Digest, "sha256:")
+	if len(d) > 12 {
+		d = d[:12]
+	}
+	return d
+}
+
+// ScratchPrefix starts the project name of every scratch instance.  Project
+// names cannot contain ':', so it never clashes with a registered one.
+const ScratchPrefix = "scratch:"
+
+// AgentInfo describes a helper agent: an entry after the first in
+// grove.yaml's agents list, run in its own PTY in the instance's container.
+// The instance's own State, PID and Runs describe the primary agent.
+type AgentInfo struct {
+	Name       string   `json:"name"`
+	Argv       []string `json:"argv"` // command and arguments
+	State      string   `json:"state"`
+	StateSince int64    `json:"state_since,omitempty"` // unix timestamp
+	EndedAt    int64    `json:"ended_at,omitempty"`    // unix timestamp; 0 if still running
+	PID        int      `json:"pid,omitempty"`
+}
+
+// CreatedBefore reports whether instance a was created before b.  CreatedAt
+// is in whole seconds, so Seq orders instances created within the same one.
+func CreatedBefore(a, b InstanceInfo) bool {
+	if a.CreatedAt != b.CreatedAt {
+		return a.CreatedAt < b.CreatedAt
+	}
+	return a.Seq < b.Seq
+}
+
+// PendingFinish describes finish commands that have yet to run, while a
+// finish is in progress.
+type PendingFinish struct {
+	Started   int64    `json:"started"`           // unix timestamp of the grove finish
+	Remaining []string `json:"remaining"`         // labels of the commands still to run
+	Resumed   bool     `json:"resumed,omitempty"` // resumed after a daemon restart
+}
+
+// LaunchConfig is the agent and container configuration an instance was
+// started with.  Restart, reopen and drop use it rather than the project's
+// current config, so they keep working if the registration changes or is
+// deleted.
+type LaunchConfig struct {
+	AgentName    string   `json:"agent_name,omitempty"` // primary's name in grove.yaml's agents list, if set
+	AgentCommand string   `json:"agent_command"`
+	AgentArgs    []string `json:"agent_args,omitempty"`
+	Image        string   `json:"image,omitempty"`
+	Compose      string   `json:"compose,omitempty"`
+	Service      string   `json:"service,omitempty"`
+	Workdir      string   `json:"workdir,omitempty"`
+	Platform     string   `json:"platform,omitempty"`
+	Tmpfs        []string `json:"tmpfs"`             // container.tmpfs, default applied; null in records from older daemons
+	Scratch      []string `json:"scratch,omitempty"` // container paths on anonymous volumes
+}
+
+// RemoteStatus is the last known state of an instance's branch on origin.
+// It is kept when the remote cannot be reached, so CheckedAt may be old.
+type RemoteStatus struct {
+	Pushed    bool   `json:"pushed"`
+	SHA       string `json:"sha,omitempty"` // remote head of the branch when Pushed
+	CheckedAt int64  `json:"checked_at"`    // unix timestamp of the last successful query
+}
+
+// Stages of grove start, in the order they run.  StageImage is skipped for
+// compose projects, whose images are pulled by docker compose up.
+const (
+	StageClone        = "clone" // clone or pull the main checkout
+	StageWorktree     = "worktree"
+	StageImage        = "image" // pull the container image if it is missing
+	StageContainer    = "container"
+	StageStart        = "start" // the start commands
+	StageAgentInstall = "agent-install"
+	StageAgentLaunch  = "agent-launch"
+)
+
+// StartStages lists the stages of grove start in order.
+var StartStages = []string{StageClone, StageWorktree, StageImage, StageContainer, StageStart, StageAgentInstall, StageAgentLaunch}
+
+// StageTiming is how long one stage of a start took.
+type StageTiming struct {
+	Stage    string `json:"stage"`
+	Duration int64  `json:"duration_ms"` // milliseconds
+}
+
+// StartDuration returns the total of timings.
+func StartDuration(timings []StageTiming) time.Duration {
+	var total time.Duration
+	for _, t := range timings {
+		total += time.Duration(t.Duration) * time.Millisecond
+	}
+	return total
+}
+
+// AgentRun records one launch of the agent process.
+type AgentRun struct {
+	Time    int64    `json:"time"` // unix timestamp
+	Argv    []string `json:"argv"` // agent command and arguments
+	Resumed bool     `json:"resumed,omitempty"`
+}
+
+// AgentEnvironment is how the primary agent was last launched, for
+// debugging a command the agent cannot find.  Values of secret variables
+// are replaced by "[redacted]" before it is recorded.
+type AgentEnvironment struct {
+	// Argv is the docker exec command line that launched the agent.
+	Argv []string `json:"argv"`
+	// Env is the agent's environment as KEY=value, sorted: the container's
+	// configured env overlaid with the variables grove passed.  Only
+	// grove's variables are listed if docker could not be asked.
+	Env []string `json:"env"`
+}
+
+// CheckProgress marks a check in progress.  It is persisted with the
+// instance so that a CHECKING state outliving its check, because the daemon
+// restarted or the check hung, can be recognised and cleared.
+type CheckProgress struct {
+	Started  int64  `json:"started"`        // unix timestamp
+	Deadline int64  `json:"deadline"`       // unix timestamp after which the daemon gives up on it; see check.max_duration
+	Return   string `json:"return"`         // state to return to afterwards: WAITING or READY
+	Auto     bool   `json:"auto,omitempty"` // started by check.auto rather than grove check
+}
+
+// CheckResult is the recorded outcome of an instance's most recent check or
+// finish run.
+type CheckResult struct {
+	Time   int64        `json:"time"`           // unix timestamp when the run finished
+	Auto   bool         `json:"auto,omitempty"` // started by check.auto rather than grove check
+	Result StreamResult `json:"result"`
+}
+
+// InstanceStats is a resource-usage sample for one instance's container.
+type InstanceStats struct {
+	ID         string  `json:"id"`
+	Project    string  `json:"project"`
+	Branch     string  `json:"branch"`
+	State      string  `json:"state"`
+	CPUPercent float64 `json:"cpu_percent"`
+	MemUsage   string  `json:"mem_usage"` // e.g. "512MiB / 7.6GiB"
+	MemPercent float64 `json:"mem_percent"`
+	NetIO      string  `json:"net_io"` // e.g. "1.2kB / 648B"
+}
+
+// Event type constants.
+const (
+	EventReady   = "ready"   // agent reported its task done; instance is READY
+	EventCheck   = "check"   // a check run finished; Result holds the outcome
+	EventFinish  = "finish"  // finish commands ended; Result holds the outcome
+	EventStalled = "stalled" // agent caught in a loop; Summary holds the repeated line
+	EventExpired = "expired" // time to live ran out; Summary says what the daemon did
+
+	EventCommandStarted  = "command_started"  // a check or finish command began; Command says which
+	EventCommandFinished = "command_finished" // it ended; Command.Result holds its exit code and duration
+)
+
+// Command kinds, for CommandProgress.Kind.
+const (
+	CommandKindCheck  = "check"
+	CommandKindFinish = "finish"
+)
+
+// CommandProgress is a check or finish command that is running, or, in an
+// EventCommandFinished, has just ended.
+type CommandProgress struct {
+	Kind    string         `json:"kind"`             // CommandKind*
+	Label   string         `json:"label"`            // its name: in grove.yaml, or else the command
+	Started int64          `json:"started"`          // unix timestamp
+	Result  *CommandResult `json:"result,omitempty"` // EventCommandFinished only
+}
+
+// Event is one instance lifecycle notification.  After the ReqEvents
+// handshake the daemon writes one JSON-encoded Event per line until the
+// client disconnects.
+type Event struct {
+	Time       int64            `json:"time"` // unix timestamp
+	Type       string           `json:"type"`
+	InstanceID string           `json:"instance_id"`
+	Project    string           `json:"project"`
+	Branch     string           `json:"branch"`
+	BaseSHA    string           `json:"base_sha,omitempty"`
+	State      string           `json:"state"`
+	Summary    string           `json:"summary,omitempty"`
+	Result     *StreamResult    `json:"result,omitempty"`
+	Changes    *ChangeSummary   `json:"changes,omitempty"` // READY only; nil if it could not be worked out
+	Command    *CommandProgress `json:"command,omitempty"` // EventCommandStarted and EventCommandFinished only
+}
+
+// LogLine is a line of an agent's output.  After the ReqLogsAll response
+// the daemon writes one JSON-encoded LogLine per line, from every instance
+// it covers, in the order they were output as near as it can tell.
+type LogLine struct {
+	InstanceID string `json:"instance_id"`
+	Project    string `json:"project"`
+	Branch     string `json:"branch"`
+	TimeMs     int64  `json:"time_ms"` // unix time in milliseconds when the line began
+	Text       string `json:"text"`    // without escape sequences or the newline
+}
+
+// ChangeSummary is how far an instance's worktree, committed or not, has
+// moved from the commit its branch shares with the main checkout.
+type ChangeSummary struct {
+	Shortstat    string   `json:"shortstat"` // as git diff --shortstat prints it; empty if nothing changed
+	FilesChanged int      `json:"files_changed"`
+	Insertions   int      `json:"insertions"`
+	Deletions    int      `json:"deletions"`
+	Files        []string `json:"files,omitempty"` // the most changed paths, at most three
+}
+
+// Response is the JSON payload returned by the daemon for all non-attach commands.
+type Response struct {
+	OK         bool           `json:"ok"`
+	Error      string         `json:"error,omitempty"`
+	ErrorCode  string         `json:"error_code,omitempty"` // ErrCode*; set for errors a client may act on
+	Hint       string         `json:"hint,omitempty"`       // what the user can do about the error, if known
+	Residue    []string       `json:"residue,omitempty"`    // ReqDrop: what could not be removed, though the instance is gone
+	InstanceID string         `json:"instance_id,omitempty"`
+	Instances  []InstanceInfo `json:"instances,omitempty"`
+
+	// Encoding is EncodingGzip when the raw bytes after the response are
+	// gzip-compressed (only in reply to ReqLogs with AcceptGzip), else empty.
+	Encoding string `json:"encoding,omitempty"`
+
+	// Fields used by ReqFinish response.
+	WorktreeDir string `json:"worktree_dir,omitempty"`
+	Branch      string `json:"branch,omitempty"`
+
+	// InitPath is set when the daemon cannot start an instance because the
+	// project has no grove.yaml in its repository.  The client should prompt
+	// the user and write a boilerplate file here.
+	InitPath string `json:"init_path,omitempty"`
+	// ConfigBranches, with InitPath, are branches of the repo, local or
+	// remote-tracking, that do have a grove.yaml: merging one is better
+	// than writing another.
+	ConfigBranches []string `json:"config_branches,omitempty"`
+
+	// Stats is set by ReqStats: one entry per instance with a running container.
+	Stats []InstanceStats `json:"stats,omitempty"`
+
+	// Projects is set by ReqProjects: every registered project, sorted by
+	// name.  ReqProjectResolve sets Project instead.
+	Projects []ProjectInfo `json:"projects,omitempty"`
+	Project  *ProjectInfo  `json:"project,omitempty"`
+
+	// Config and ProjectConfig are set by ReqShowConfig: the effective
+	// config (YAML) the instance was started with, and the project's config
+	// as the instance would get it now.
+	Config        string `json:"config,omitempty"`
+	ProjectConfig string `json:"project_config,omitempty"`
+
+	// Worktrees is set by ReqWorktrees: the project's main checkout, then
+	// its other worktrees in the order git lists them, then instances whose
+	// worktree git does not list.
+	Worktrees []WorktreeInfo `json:"worktrees,omitempty"`
+
+	// Prefetching is set by a ReqProjectFetch with Prefetch when the daemon
+	// has started fetching in the background.
+	Prefetching bool `json:"prefetching,omitempty"`
+
+	// Health is set by ReqPing, and on errors caused by the daemon not
+	// finding a tool it needs or running short of disk space.
+	Health *DaemonHealth `json:"health,omitempty"`
+
+	// Operation is set with ErrCodeOperationInProgress: the operation the
+	// instance is busy with.
+	Operation *Operation `json:"operation,omitempty"`
+
+	// AttachV2 is set in the ReqAttach handshake when the daemon frames its
+	// side of the stream, as asked by Request.AttachV2.  A daemon that
+	// predates it sends raw bytes.
+	AttachV2 bool `json:"attach_v2,omitempty"`
+
+	// AttachCommand is set in the ReqAttach handshake when the daemon has
+	// started Request.Command.  A daemon that predates it attaches to the
+	// agent instead, so a client that sent a command must then hang up.
+	AttachCommand bool `json:"attach_command,omitempty"`
+}
+
+// ErrCodeOperationInProgress refuses a check, finish, restart or reopen
+// because the instance is already running one of them.
+const ErrCodeOperationInProgress = "operation_in_progress"
+
+// ErrCodeInsufficientDisk refuses a start because a filesystem it would
+// fill is below the daemon's minimum of free space.  Health.Disk has the
+// numbers.
+const ErrCodeInsufficientDisk = "insufficient_disk"
+
+// ErrCodeDataDirUnavailable refuses a start, or other work on a project's
+// data, because the project's data_dir is not there: typically an external
+// volume that is not mounted.  Error names the path.
+const ErrCodeDataDirUnavailable = "data_dir_unavailable"
+
+// ErrCodeStartCancelled ends a start that was cancelled, by ReqCancelStart
+// or by its client going away, once what it had made is removed.
+const ErrCodeStartCancelled = "start_cancelled"
+
+// ErrCodeConfigMissing refuses a start because the project's main
+// checkout has no grove.yaml; InitPath is set.  ErrCodeConfigInvalid
+// refuses it because the grove.yaml there, or a file it includes, does not
+// load.
+const (
+	ErrCodeConfigMissing = "config_missing"
+	ErrCodeConfigInvalid = "config_invalid"
+)
+
+// ErrCodeGit* refuse a start or project fetch because cloning or pulling
+// the project's repo failed in a way grove recognised.  Hint says what to
+// do about it.
+const (
+	ErrCodeGitRepoURL      = "git_repo_url"         // the repo URL looks malformed
+	ErrCodeGitRepoNotFound = "git_repo_not_found"   // renamed, moved, deleted or not visible
+	ErrCodeGitAuth         = "git_auth"             // SSH key or credentials refused
+	ErrCodeGitHostKey      = "git_host_key"         // SSH host key unknown or changed
+	ErrCodeGitUnreachable  = "git_host_unreachable" // host name not resolved or host not reached
+)
+
+// Operation is a long-running operation on an instance.
+type Operation struct {
+	Name    string `json:"name"`    // "check", "finish", "restart" or "reopen"
+	Started int64  `json:"started"` // unix timestamp
+}
+
+// DaemonHealth describes the daemon's environment: the external tools it
+// runs and where it found them, and the free space on the filesystems
+// instances fill.
+type DaemonHealth struct {
+	Path    string            `json:"path"`              // the daemon's PATH
+	Tools   map[string]string `json:"tools"`             // absolute path of each tool found
+	Missing []string          `json:"missing,omitempty"` // tools not found; the daemon is degraded
+
+	Disk     []DiskSpace `json:"disk,omitempty"`
+	DiskWarn uint64      `json:"disk_warn,omitempty"` // starts warn below this many free bytes; 0: never
+	DiskMin  uint64      `json:"disk_min,omitempty"`  // starts are refused below it; 0: never
+
+	Web string `json:"web,omitempty"` // host:port the web UI listens on; empty if it is off
+
+	Locks []LockInfo `json:"locks,omitempty"` // project locks held or waited for now, by project
+}
+
+// LockInfo is a project lock that is held, with the operations waiting for
+// it.
+type LockInfo struct {
+	Project string       `json:"project"`
+	Holder  string       `json:"holder,omitempty"`  // the operation holding it, e.g. "start 4"; empty if it is just released
+	Since   int64        `json:"since,omitempty"`   // unix timestamp it was taken
+	Waiting []LockWaiter `json:"waiting,omitempty"` // oldest first
+}
+
+// LockWaiter is an operation waiting for a project lock.
+type LockWaiter struct {
+	What  string `json:"what"`
+	Since int64  `json:"since"` // unix timestamp it began waiting
+}
+
+// DiskSpace is the free space on one filesystem the daemon writes to.
+type DiskSpace struct {
+	Name  string `json:"name"` // "grove" (worktrees, logs) or "docker" (images, containers)
+	Path  string `json:"path"`
+	Free  uint64 `json:"free"` // bytes available to the daemon
+	Total uint64 `json:"total"`
+	Error string `json:"error,omitempty"` // why Free is unknown, e.g. Docker runs in a VM
+}
+
+// Low reports whether s is known to have less than min bytes free.
+func (s DiskSpace) Low(min uint64) bool {
+	return s.Error == "" && min > 0 && s.Free < min
+}
+
+// byteUnits are the suffixes FormatBytes and ParseBytes use, in powers of
+// 1024.
+var byteUnits = []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB"}
+
+// FormatBytes renders n in the largest binary unit it has at least one
+// of, e.g. "1.5 GiB".
+func FormatBytes(n uint64) string {
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+	v, i := float64(n), 0
+	for v >= 1024 && i < len(byteUnits)-1 {
+		v /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f %s", v, byteUnits[i])
+}
+
+// ParseBytes parses a size such as "2GiB", "500M" or "1048576".  Units are
+// powers of 1024 whether written K, KB or KiB.
+func ParseBytes(s string) (uint64, error) {
+	num := strings.TrimSpace(s)
+	unit := strings.TrimLeft(num, "0123456789.")
+	num = strings.TrimSpace(num[:len(num)-len(unit)])
+	unit = strings.ToUpper(strings.TrimSpace(unit))
+	unit = strings.TrimSuffix(strings.TrimSuffix(unit, "B"), "I")
+	mult := -1
+	for i, u := range []string{"", "K", "M", "G", "T", "P"} {
+		if unit == u {
+			mult = i
+		}
+	}
+	v, err := strconv.ParseFloat(num, 64)
+	if err != nil || mult < 0 || v < 0 {
+		return 0, fmt.Errorf("invalid size %q (want e.g. 500M or 2GiB)", s)
+	}
+	for ; mult > 0; mult-- {
+		v *= 1024
+	}
+	return uint64(v), nil
+}
+
+// ProjectInfo describes a registered project as the daemon sees it.
+type ProjectInfo struct {
+	Name         string         `json:"name"`
+	Repo         string         `json:"repo,omitempty"`
+	Source       string         `json:"source"`                  // projects directory holding the registration
+	HasConfig    bool           `json:"has_config"`              // grove.yaml exists in the main checkout
+	Cloned       bool           `json:"cloned"`                  // the main checkout exists
+	FetchedAt    int64          `json:"fetched_at,omitempty"`    // unix time the main checkout was last cloned or pulled; 0 if unknown
+	AgentCommand string         `json:"agent_command,omitempty"` // agent.command from grove.yaml, if known
+	Defaults     *StartDefaults `json:"defaults,omitempty"`      // grove.yaml's defaults section, if any
+	Instances    int            `json:"instances"`               // instances of this project, in any state
+
+	InstanceStates map[string]int `json:"instance_states,omitempty"` // how many of its instances are in each state
+	LastStartAt    int64          `json:"last_start_at,omitempty"`   // unix time the newest of its instances was started
+
+	// Set for ReqProjects with DiskUsage: the bytes on disk its main
+	// checkout and its worktrees directory take, and when they were
+	// measured, which may be a few minutes ago.
+	MainBytes      int64 `json:"main_bytes,omitempty"`
+	WorktreesBytes int64 `json:"worktrees_bytes,omitempty"`
+	MeasuredAt     int64 `json:"measured_at,omitempty"`
+}
+
+// Worktree statuses for WorktreeInfo.Status.
+const (
+	WorktreeMain     = "main"     // the project's main checkout
+	WorktreeOK       = "ok"       // an instance's worktree
+	WorktreeOrphan   = "orphan"   // a worktree no instance refers to
+	WorktreeMissing  = "missing"  // an instance's worktree whose directory is gone
+	WorktreeUnlisted = "unlisted" // an instance's directory that git does not list as a worktree
+)
+
+// WorktreeInfo is one of a project's git worktrees, or an instance's
+// worktree that git has lost track of.
+type WorktreeInfo struct {
+	Path       string `json:"path"`
+	Branch     string `json:"branch,omitempty"` // empty if detached
+	Head       string `json:"head,omitempty"`
+	InstanceID string `json:"instance_id,omitempty"`
+	Status     string `json:"status"`           // Worktree*
+	Locked     bool   `json:"locked,omitempty"` // git worktree lock; prune leaves it alone
+}
+
+// StartDefaults are the grove start options in the defaults section of a
+// project's grove.yaml.  The CLI applies them before its own flags, which
+// win.  TaskTemplate may use {{project}} and {{branch}}.
+type StartDefaults struct {
+	Detach       bool              `json:"detach,omitempty"`
+	Base         string            `json:"base,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	Agent        string            `json:"agent,omitempty"`
+	TaskTemplate string            `json:"task_template,omitempty"`
+	TTL          int64             `json:"ttl,omitempty"` // seconds
+	TTLAction    string            `json:"ttl_action,omitempty"`
+	Mode         string            `json:"mode,omitempty"`
+}
+
+// ─── Attach stream framing ────────────────────────────────────────────────────
+//
+// After the JSON handshake the attach connection becomes asymmetric:
+//
+//   Server → Client : raw PTY output bytes (no framing; terminal handles escapes)
+//   Client → Server : length-prefixed frames:
+//
+//     [1 byte type][4 bytes big-endian length][payload]
+//
+//     0x00  data    – stdin bytes to write into the PTY
+//     0x01  resize  – payload: 2-byte cols + 2-byte rows (big-endian uint16)
+//     0x02  detach  – no payload; client wants to detach cleanly
+//
+// A client that sets Request.AttachV2 gets frames from the server as well:
+//
+//     0x00  data    – PTY output bytes
+//     0x03  state   – payload: AttachState as JSON, whenever it changes
+//     0x04  exit    – payload: AttachExit as JSON; the agent has exited and
+//                     the server closes the connection
+//     0x05  ping    – no payload; sent when there is nothing else to say
+//
+// Clients ignore frame types they do not know, so more can be added.
+
+const (
+	AttachFrameData   byte = 0x00
+	AttachFrameResize byte = 0x01
+	AttachFrameDetach byte = 0x02
+	AttachFrameState  byte = 0x03
+	AttachFrameExit   byte = 0x04
+	AttachFramePing   byte = 0x05
+)
+
+// AttachState is the payload of an AttachFrameState frame: what the agent
+// is doing, as grove list would show it if no client were attached.
+type AttachState struct {
+	State      string `json:"state"`                 // e.g. RUNNING, WAITING or CHECKING
+	StateSince int64  `json:"state_since,omitempty"` // unix timestamp the agent entered State
+}
+
+// AttachExit is the payload of an AttachFrameExit frame.
+type AttachExit struct {
+	State    string `json:"state"`     // EXITED, CRASHED or KILLED
+	ExitCode int    `json:"exit_code"` // -1 if killed by a signal
+}
+
+// ResizePayload encodes a terminal size as the payload of an
+// AttachFrameResize frame.
+func ResizePayload(cols, rows uint16) []byte {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint16(payload[0:2], cols)
+	binary.BigEndian.PutUint16(payload[2:4], rows)
+	return payload
+}
+
+// ParseResizePayload decodes the payload of an AttachFrameResize frame; ok
+// is false if it is malformed.
+func ParseResizePayload(payload []byte) (cols, rows uint16, ok bool) {
+	if len(payload) != 4 {
+		return 0, 0, false
+	}
+	return binary.BigEndian.Uint16(payload[0:2]), binary.BigEndian.Uint16(payload[2:4]), true
+}
+
+// MaxFramePayload is the largest payload ReadFrame accepts (1 MiB).
+const MaxFramePayload = 1 << 20
+
+// frameHeaderLen is the size of the type byte plus the length prefix.
+const frameHeaderLen = 5
+
+// WriteFrame writes a single framed message to w.  Header and payload are
+// sent in one Write call so a frame is never split by another writer, but
+// callers sharing w across goroutines should still use a FrameWriter.
+func WriteFrame(w io.Writer, frameType byte, payload []byte) error {
+	buf := make([]byte, frameHeaderLen+len(payload))
+	buf[0] = frameType
+	binary.BigEndian.PutUint32(buf[1:frameHeaderLen], uint32(len(payload)))
+	copy(buf[frameHeaderLen:], payload)
+	_, err := w.Write(buf)
+	return err
+}
+
+// ReadFrame reads a single framed message from r.
+// Returns (frameType, payload, error).
+//
+// The payload is read incrementally rather than allocated up front from the
+// untrusted length prefix, so a bogus header followed by a short stream
+// cannot force a large allocation.
+func ReadFrame(r io.Reader) (byte, []byte, error) {
+	hdr := make([]byte, frameHeaderLen)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return 0, nil, err
+	}
+	frameType := hdr[0]
+	n := binary.BigEndian.Uint32(hdr[1:])
+	if n > MaxFramePayload {
+		return 0, nil, fmt.Errorf("attach frame too large: %d bytes", n)
+	}
+	if n == 0 {
+		return frameType, nil, nil
+	}
+	payload, err := io.ReadAll(io.LimitReader(r, int64(n)))
+	if err != nil {
+		return 0, nil, err
+	}
+	if uint32(len(payload)) != n {
+		return 0, nil, io.ErrUnexpectedEOF
+	}
+	return frameType, payload, nil
+}
+
+// FrameWriter serialises frames onto a shared writer.  It is safe for
+// concurrent use, e.g. by the stdin and resize goroutines of an attach
+// session.
+type FrameWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewFrameWriter returns a FrameWriter that writes to w.
+func NewFrameWriter(w io.Writer) *FrameWriter {
+	return &FrameWriter{w: w}
+}
+
+// WriteFrame writes one frame, holding the lock for the whole frame.
+func (fw *FrameWriter) WriteFrame(frameType byte, payload []byte) error {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	return WriteFrame(fw.w, frameType, payload)
+}
+
+// ─── Command result trailer ───────────────────────────────────────────────────
+//
+// check and finish stream raw command output after the JSON response (as does
+// project delete, with progress messages).  Once every command has run, the
+// daemon appends one trailer line:
+//
+//	"\x1egrove-result " + JSON(StreamResult) + "\n"
+//
+// The ASCII record separator keeps the marker out of ordinary command output.
+// A stream that closes without a trailer means the daemon went away mid-run.
+
+// ResultTrailerPrefix introduces the trailer line.
+const ResultTrailerPrefix = "\x1egrove-result "
+
+// CommandResult records how one check or finish command ended.
+type CommandResult struct {
+	Command    string `json:"command"`
+	Name       string `json:"name,omitempty"` // name: given to the command in grove.yaml
+	ExitCode   int    `json:"exit_code"`      // -1 if the command could not be run or timed out
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// Label names the command in summaries: its name if it has one, else the
+// command itself.
+func (c CommandResult) Label() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return c.Command
+}
+
+// StreamResult is the trailer sent at the end of a check or finish stream.
+type StreamResult struct {
+	OK         bool            `json:"ok"`
+	Error      string          `json:"error,omitempty"`
+	ErrorCode  string          `json:"error_code,omitempty"` // as in Response
+	Hint       string          `json:"hint,omitempty"`
+	Commands   []CommandResult `json:"commands"`
+	DurationMs int64           `json:"duration_ms"`
+}
+
+// WriteResultTrailer writes r as a trailer line to w.
+func WriteResultTrailer(w io.Writer, r StreamResult) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 0, len(ResultTrailerPrefix)+len(data)+1)
+	buf = append(buf, ResultTrailerPrefix...)
+	buf = append(buf, data...)
+	buf = append(buf, '\n')
+	_, err = w.Write(buf)
+	return err
+}
+
+// SplitResult copies the command output in r to out as it arrives and
+// returns the trailer that ends the stream, together with its raw JSON.
+// It returns a nil result if r ends without a trailer.
+func SplitResult(r io.Reader, out io.Writer) (*StreamResult, []byte, error) {
+	s := &resultSplitter{out: out}
+	if _, err := io.Copy(s, r); err != nil {
+		return nil, nil, err
+	}
+	if !s.matched {
+		// A lone partial marker at EOF was output after all.
+		if len(s.pending) > 0 {
+			if _, err := out.Write(s.pending); err != nil {
+				return nil, nil, err
+			}
+		}
+		return nil, nil, nil
+	}
+	raw := bytes.TrimRight(s.trailer, "\n")
+	var res StreamResult
+	if err := json.Unmarshal(raw, &res); err != nil {
+		return nil, raw, fmt.Errorf("malformed result trailer: %w", err)
+	}
+	return &res, raw, nil
+}
+
+// resultSplitter passes bytes through to out until it sees
+// ResultTrailerPrefix, then collects everything after it.  A possible
+// prefix split across writes is held back until it can be decided.
+type resultSplitter struct {
+	out     io.Writer
+	pending []byte
+	matched bool
+	trailer []byte
+}
+
+func (s *resultSplitter) Write(p []byte) (int, error) {
+	n := len(p)
+	if s.matched {
+		s.trailer = append(s.trailer, p...)
+		return n, nil
+	}
+	data := append(s.pending, p...)
+	s.pending = nil
+	for {
+		i := bytes.IndexByte(data, ResultTrailerPrefix[0])
+		if i < 0 {
+			_, err := s.out.Write(data)
+			return n, err
+		}
+		if _, err := s.out.Write(data[:i]); err != nil {
+			return n, err
+		}
+		rest := data[i:]
+		switch {
+		case bytes.HasPrefix(rest, []byte(ResultTrailerPrefix)):
+			s.matched = true
+			s.trailer = append(s.trailer, rest[len(ResultTrailerPrefix):]...)
+			return n, nil
+		case len(rest) < len(ResultTrailerPrefix) && strings.HasPrefix(ResultTrailerPrefix, string(rest)):
+			s.pending = append([]byte(nil), rest...)
+			return n, nil
+		}
+		// Not our marker: emit the separator byte and keep scanning.
+		if _, err := s.out.Write(rest[:1]); err != nil {
+			return n, err
+		}
+		data = rest[1:]
+	}
+}
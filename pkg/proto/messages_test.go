@@ -0,0 +1,305 @@
+package proto_test
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+
+	"github.com/gandalfthegui/grove/pkg/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteReadFrameRoundTrip(t *testing.T) {
+	cases := []struct {
+		name      string
+		frameType byte
+		payload   []byte
+	}{
+		{"data with payload", proto.AttachFrameData, []byte("hello world")},
+		{"resize payload", proto.AttachFrameResize, []byte{0, 80, 0, 24}},
+		{"detach no payload", proto.AttachFrameDetach, nil},
+		{"data empty payload", proto.AttachFrameData, []byte{}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := proto.WriteFrame(&buf, tc.frameType, tc.payload)
+			require.NoError(t, err)
+
+			ft, payload, err := proto.ReadFrame(&buf)
+			require.NoError(t, err)
+			assert.Equal(t, tc.frameType, ft)
+			// Both nil and empty slice represent "no payload".
+			if len(tc.payload) == 0 {
+				assert.Empty(t, payload)
+			} else {
+				assert.Equal(t, tc.payload, payload)
+			}
+		})
+	}
+}
+
+func TestReadFrameMultiple(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, proto.WriteFrame(&buf, proto.AttachFrameData, []byte("first")))
+	require.NoError(t, proto.WriteFrame(&buf, proto.AttachFrameData, []byte("second")))
+
+	_, p1, err := proto.ReadFrame(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("first"), p1)
+
+	_, p2, err := proto.ReadFrame(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("second"), p2)
+}
+
+func TestReadFrameMixedPartialReads(t *testing.T) {
+	frames := []struct {
+		frameType byte
+		payload   []byte
+	}{
+		{proto.AttachFrameData, []byte("\033[1mhello\033[0m")},
+		{proto.AttachFrameState, []byte(`{"state":"WAITING","state_since":100}`)},
+		{proto.AttachFramePing, nil},
+		{proto.AttachFrameData, bytes.Repeat([]byte("x"), 4096)},
+		{proto.AttachFrameExit, []byte(`{"state":"EXITED","exit_code":0}`)},
+	}
+	var buf bytes.Buffer
+	for _, f := range frames {
+		require.NoError(t, proto.WriteFrame(&buf, f.frameType, f.payload))
+	}
+	data := buf.Bytes()
+
+	readers := map[string]func() io.Reader{
+		"one byte at a time": func() io.Reader { return iotest.OneByteReader(bytes.NewReader(data)) },
+		"half reads":         func() io.Reader { return iotest.HalfReader(bytes.NewReader(data)) },
+		"split mid-header": func() io.Reader {
+			// A pipe delivers the stream in arbitrary writes, as a socket does.
+			pr, pw := io.Pipe()
+			go func() {
+				for _, chunk := range [][]byte{data[:3], data[3:20], data[20:]} {
+					pw.Write(chunk)
+				}
+				pw.Close()
+			}()
+			return pr
+		},
+	}
+	for name, newReader := range readers {
+		t.Run(name, func(t *testing.T) {
+			r := newReader()
+			for _, want := range frames {
+				frameType, payload, err := proto.ReadFrame(r)
+				require.NoError(t, err)
+				assert.Equal(t, want.frameType, frameType)
+				assert.Equal(t, len(want.payload), len(payload))
+				assert.True(t, bytes.Equal(want.payload, payload))
+			}
+			_, _, err := proto.ReadFrame(r)
+			assert.Equal(t, io.EOF, err)
+		})
+	}
+}
+
+func TestResizePayload(t *testing.T) {
+	cols, rows, ok := proto.ParseResizePayload(proto.ResizePayload(200, 50))
+	require.True(t, ok)
+	assert.Equal(t, uint16(200), cols)
+	assert.Equal(t, uint16(50), rows)
+
+	_, _, ok = proto.ParseResizePayload([]byte{0, 80})
+	assert.False(t, ok)
+}
+
+func TestReadFrameErrors(t *testing.T) {
+	cases := []struct {
+		name  string
+		input []byte
+	}{
+		{"empty", nil},
+		{"truncated header", []byte{proto.AttachFrameData, 0, 0}},
+		{"oversized length", []byte{proto.AttachFrameData, 0x7f, 0xff, 0xff, 0xff}},
+		{"truncated payload", []byte{proto.AttachFrameData, 0, 0, 0, 10, 'a', 'b'}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, _, err := proto.ReadFrame(bytes.NewReader(tc.input))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestFrameWriterConcurrent(t *testing.T) {
+	var buf bytes.Buffer
+	fw := proto.NewFrameWriter(&buf)
+
+	const writers, frames = 4, 50
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(b byte) {
+			defer wg.Done()
+			for j := 0; j < frames; j++ {
+				require.NoError(t, fw.WriteFrame(proto.AttachFrameData, bytes.Repeat([]byte{b}, 64)))
+			}
+		}(byte('a' + i))
+	}
+	wg.Wait()
+
+	// Every frame must decode intact: no interleaved headers or payloads.
+	for i := 0; i < writers*frames; i++ {
+		ft, payload, err := proto.ReadFrame(&buf)
+		require.NoError(t, err)
+		assert.Equal(t, proto.AttachFrameData, ft)
+		require.Len(t, payload, 64)
+		assert.Equal(t, bytes.Repeat(payload[:1], 64), payload)
+	}
+	assert.Zero(t, buf.Len())
+}
+
+func FuzzReadFrame(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte{proto.AttachFrameDetach, 0, 0, 0, 0})
+	f.Add([]byte{proto.AttachFrameResize, 0, 0, 0, 4, 0, 80, 0, 24})
+	f.Add([]byte{proto.AttachFrameData, 0, 0})
+	f.Add([]byte{proto.AttachFrameData, 0xff, 0xff, 0xff, 0xff})
+	f.Add([]byte{proto.AttachFrameData, 0, 0, 0, 8, 'x'})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ft, payload, err := proto.ReadFrame(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		assert.LessOrEqual(t, len(payload), proto.MaxFramePayload)
+
+		// Anything ReadFrame accepts must round-trip through WriteFrame.
+		var buf bytes.Buffer
+		require.NoError(t, proto.WriteFrame(&buf, ft, payload))
+		assert.Equal(t, data[:buf.Len()], buf.Bytes())
+	})
+}
+
+// chunkReader yields its data n bytes at a time to exercise markers that
+// straddle reads.
+type chunkReader struct {
+	data []byte
+	n    int
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	if len(c.data) == 0 {
+		return 0, io.EOF
+	}
+	k := c.n
+	if k > len(p) {
+		k = len(p)
+	}
+	if k > len(c.data) {
+		k = len(c.data)
+	}
+	copy(p, c.data[:k])
+	c.data = c.data[k:]
+	return k, nil
+}
+
+func TestSplitResult(t *testing.T) {
+	want := proto.StreamResult{
+		OK:         false,
+		Commands:   []proto.CommandResult{{Command: "make test", ExitCode: 2, DurationMs: 1500}},
+		DurationMs: 1500,
+	}
+	var stream bytes.Buffer
+	stream.WriteString("$ make test\nFAIL \x1b[31mred\x1b[0m \x1e not a marker\n")
+	require.NoError(t, proto.WriteResultTrailer(&stream, want))
+
+	for _, chunk := range []int{1, 3, 7, 4096} {
+		var out bytes.Buffer
+		res, raw, err := proto.SplitResult(&chunkReader{data: stream.Bytes(), n: chunk}, &out)
+		require.NoError(t, err, "chunk=%d", chunk)
+		require.NotNil(t, res, "chunk=%d", chunk)
+		assert.Equal(t, want, *res)
+		assert.JSONEq(t, `{"ok":false,"commands":[{"command":"make test","exit_code":2,"duration_ms":1500}],"duration_ms":1500}`, string(raw))
+		assert.Equal(t, "$ make test\nFAIL \x1b[31mred\x1b[0m \x1e not a marker\n", out.String(), "chunk=%d", chunk)
+	}
+}
+
+func TestSplitResultNoTrailer(t *testing.T) {
+	var out bytes.Buffer
+	res, _, err := proto.SplitResult(strings.NewReader("partial output\x1egrove-res"), &out)
+	require.NoError(t, err)
+	assert.Nil(t, res)
+	assert.Equal(t, "partial output\x1egrove-res", out.String())
+}
+
+func TestSplitResultMalformed(t *testing.T) {
+	var out bytes.Buffer
+	_, _, err := proto.SplitResult(strings.NewReader(proto.ResultTrailerPrefix+"{oops\n"), &out)
+	assert.Error(t, err)
+}
+
+func TestCreatedBefore(t *testing.T) {
+	older := proto.InstanceInfo{ID: "2", CreatedAt: 100, Seq: 9}
+	newer := proto.InstanceInfo{ID: "1", CreatedAt: 200, Seq: 3}
+	assert.True(t, proto.CreatedBefore(older, newer), "seconds first; Seq may predate a clock change")
+	assert.False(t, proto.CreatedBefore(newer, older))
+
+	a := proto.InstanceInfo{ID: "5", CreatedAt: 100, Seq: 1}
+	b := proto.InstanceInfo{ID: "1", CreatedAt: 100, Seq: 2}
+	assert.True(t, proto.CreatedBefore(a, b), "same second: by Seq")
+	assert.False(t, proto.CreatedBefore(b, a))
+}
+
+func TestFormatBytes(t *testing.T) {
+	cases := map[uint64]string{
+		0:                 "0 B",
+		1023:              "1023 B",
+		1536:              "1.5 KiB",
+		10 << 30:          "10.0 GiB",
+		3<<40 + 512<<30:   "3.5 TiB",
+		5 << 50:           "5.0 PiB",
+		2048 << 50:        "2048.0 PiB",
+		(1 << 20) - 1<<10: "1023.0 KiB",
+	}
+	for n, want := range cases {
+		assert.Equal(t, want, proto.FormatBytes(n), "%d", n)
+	}
+}
+
+func TestParseBytes(t *testing.T) {
+	cases := map[string]uint64{
+		"0":        0,
+		"1048576":  1 << 20,
+		"500M":     500 << 20,
+		"2GiB":     2 << 30,
+		"2 GB":     2 << 30,
+		"1.5g":     3 << 29,
+		"1T":       1 << 40,
+		" 10KiB ":  10 << 10,
+		"512b":     512,
+		"0.5 PiB":  1 << 49,
+		"100 kib ": 100 << 10,
+	}
+	for s, want := range cases {
+		n, err := proto.ParseBytes(s)
+		if assert.NoError(t, err, s) {
+			assert.Equal(t, want, n, s)
+		}
+	}
+	for _, s := range []string{"", "GiB", "2XB", "-1G", "1.2.3M"} {
+		_, err := proto.ParseBytes(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestDiskSpaceLow(t *testing.T) {
+	s := proto.DiskSpace{Free: 5 << 30}
+	assert.True(t, s.Low(10<<30))
+	assert.False(t, s.Low(5<<30))
+	assert.False(t, s.Low(0), "0 disables the threshold")
+	assert.False(t, proto.DiskSpace{Error: "no such file"}.Low(10<<30), "unknown is not low")
+}
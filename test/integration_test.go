@@ -5,6 +5,10 @@
 // Each test builds the binaries once (via TestMain), creates an isolated
 // GROVE_ROOT temp directory, injects a mock `docker` script so no real Docker
 // daemon is required, and then runs actual `grove` / `groved` processes.
+// Tests that need the agent to really run (attach, logs -f, finish output)
+// have the mock run what it is asked to exec on the host (hostExec), and
+// drive grove at a pseudo-terminal (groveTTY) or speak the attach framing
+// to the daemon directly (attachFrames).
 //
 // Run with:
 //
@@ -15,14 +19,25 @@
 package integration_test
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"testing"
 	"time"
 
+	"github.com/creack/pty"
+	"github.com/gandalfthegui/grove/pkg/proto"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -39,32 +54,68 @@ const mockDockerScript = `#!/bin/sh
 subcmd="$1"; shift
 case "$subcmd" in
   info)
+    # The host's architecture is the first word of $MOCK_DOCKER_LOG.arch,
+    # if a test wrote one.
+    case "$*" in
+      *Architecture*)
+        [ -f "$MOCK_DOCKER_LOG.arch" ] && printf 'linux/%s\n' "$(cut -d' ' -f1 "$MOCK_DOCKER_LOG.arch")"
+        ;;
+    esac
     exit 0
     ;;
 
   run)
-    # docker run -d --name <name> ... — echo the name so startContainer gets it back.
-    name=""
+    # docker run -d --name <name> ... <image> sleep infinity — echo the name
+    # so startContainer gets it back, and remember the image for inspect.
+    name=""; image=""; prev=""
     while [ $# -gt 0 ]; do
       if [ "$1" = "--name" ]; then name="$2"; shift; fi
+      if [ "$1" = "sleep" ]; then image="$prev"; fi
+      prev="$1"
       shift
     done
+    [ -n "$MOCK_DOCKER_LOG" ] && echo "$name $image" >> "$MOCK_DOCKER_LOG.images"
     echo "$name"
     exit 0
     ;;
 
+  inspect)
+    # Containers never count as running; their image is the one docker run
+    # was last given for the name.
+    case "$*" in
+      *Config.Image*)
+        for name; do :; done
+        image=$(grep "^$name " "$MOCK_DOCKER_LOG.images" 2>/dev/null | tail -n 1 | cut -d' ' -f2)
+        [ -n "$image" ] || exit 1
+        printf '%s\tsha256:%s\n' "$image" "$(printf %s "$image" | cksum | cut -d' ' -f1)0000000000000"
+        exit 0
+        ;;
+    esac
+    exit 1
+    ;;
+
   exec)
+    [ -n "$MOCK_DOCKER_LOG" ] && echo "$subcmd $*" >> "$MOCK_DOCKER_LOG"
     # Skip all flags (-it, -i, -t, -e KEY=VAL) then skip the container name.
     while [ $# -gt 0 ]; do
       case "$1" in
         -i|-t|-it) shift ;;
-        -e) shift; shift ;;
+        -e|-w|-u) shift; shift ;;
         --*) shift ;;
         -*) shift ;;
         *) shift; break ;;   # container name — consume it and stop
       esac
     done
-    # Whatever command follows, just succeed silently.
+    # With a $MOCK_DOCKER_LOG.host directory (see hostExec), the command
+    # really runs, on the host, in that directory.
+    if [ -d "$MOCK_DOCKER_LOG.host" ]; then
+      cd "$MOCK_DOCKER_LOG.host" && exec "$@"
+    fi
+    # Commands containing "exit N" fail with that code; anything else
+    # succeeds silently.
+    case "$*" in
+      *"exit 3"*) exit 3 ;;
+    esac
     exit 0
     ;;
 
@@ -72,7 +123,36 @@ case "$subcmd" in
     exit 0
     ;;
 
+  ps|volume|image)
+    # Listing finds nothing; record the call so tests can check the sweep.
+    # Images named unpulled/* are missing until pulled.
+    [ -n "$MOCK_DOCKER_LOG" ] && echo "$subcmd $*" >> "$MOCK_DOCKER_LOG"
+    case "$subcmd $*" in
+      "image inspect"*unpulled/*|"image inspect"*missing/*) exit 1 ;;
+      "image inspect"*Architecture*)
+        # Every image's platform is the second word of $MOCK_DOCKER_LOG.arch.
+        [ -f "$MOCK_DOCKER_LOG.arch" ] && cut -d' ' -f2 "$MOCK_DOCKER_LOG.arch"
+        ;;
+    esac
+    exit 0
+    ;;
+
+  pull)
+    [ -n "$MOCK_DOCKER_LOG" ] && echo "$subcmd $*" >> "$MOCK_DOCKER_LOG"
+    echo "pulled $1"
+    exit 0
+    ;;
+
+  manifest)
+    # Images named missing/* are in no registry either.
+    case "$*" in
+      *missing/*) echo "no such manifest: $2" >&2; exit 1 ;;
+    esac
+    exit 0
+    ;;
+
   compose)
+    [ -n "$MOCK_DOCKER_LOG" ] && echo "$subcmd $*" >> "$MOCK_DOCKER_LOG"
     exit 0
     ;;
 
@@ -128,6 +208,41 @@ type testEnv struct {
 	binDir    string // contains mock docker, appears first on PATH
 	sockPath  string
 	daemon    *exec.Cmd
+	daemonLog *lockedBuffer // groved's trace output, printed if the test fails
+}
+
+// daemonLogLines is how much of the daemon's trace a failing test prints.
+const daemonLogLines = 100
+
+// lockedBuffer is a bytes.Buffer the daemon's output can be copied into
+// while the test reads it.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// String returns everything written so far.
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// tail returns the last n lines written.
+func (b *lockedBuffer) tail(n int) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	lines := strings.Split(strings.TrimRight(b.buf.String(), "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
 }
 
 func newTestEnv(t *testing.T) *testEnv {
@@ -148,13 +263,18 @@ func newTestEnv(t *testing.T) *testEnv {
 	return env
 }
 
-// startDaemon starts groved and blocks until its Unix socket appears.
-func (e *testEnv) startDaemon() {
+// startDaemon starts groved with flags and blocks until its Unix socket
+// appears.  It runs with --debug=trace, and without the free disk space
+// checks unless flags set them; if the test fails, the end of its log is
+// added to the test output.
+func (e *testEnv) startDaemon(flags ...string) {
 	e.t.Helper()
-	cmd := exec.Command(grovedBin, "--root", e.groveRoot)
+	args := append([]string{"--root", e.groveRoot, "--debug=trace", "--disk-warn=0", "--disk-min=0"}, flags...)
+	cmd := exec.Command(grovedBin, args...)
 	cmd.Env = e.envVars()
-	cmd.Stdout = os.Stderr
-	cmd.Stderr = os.Stderr
+	e.daemonLog = &lockedBuffer{}
+	cmd.Stdout = e.daemonLog
+	cmd.Stderr = e.daemonLog
 	require.NoError(e.t, cmd.Start(), "start groved")
 	e.daemon = cmd
 
@@ -172,13 +292,39 @@ func (e *testEnv) envVars() []string {
 	return append(os.Environ(),
 		"GROVE_ROOT="+e.groveRoot,
 		"PATH="+e.binDir+":"+os.Getenv("PATH"),
+		"MOCK_DOCKER_LOG="+e.dockerLog(),
 	)
 }
 
+// dockerLog is where the mock docker records the listing calls it gets.
+func (e *testEnv) dockerLog() string {
+	return filepath.Join(e.binDir, "docker.log")
+}
+
 // grove runs a grove subcommand and returns (trimmed output, error).
 func (e *testEnv) grove(args ...string) (string, error) {
+	return e.groveInput("", args...)
+}
+
+// groveStdout runs a grove subcommand and returns its stdout alone,
+// failing the test if it errors.
+func (e *testEnv) groveStdout(args ...string) string {
+	e.t.Helper()
+	cmd := exec.Command(groveBin, args...)
+	cmd.Env = e.envVars()
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	require.NoError(e.t, err, "grove %v\n%s", args, stderr.String())
+	return string(out)
+}
+
+// groveInput runs a grove subcommand with input on stdin, e.g. to answer a
+// confirmation prompt.
+func (e *testEnv) groveInput(input string, args ...string) (string, error) {
 	cmd := exec.Command(groveBin, args...)
 	cmd.Env = e.envVars()
+	cmd.Stdin = strings.NewReader(input)
 	out, err := cmd.CombinedOutput()
 	return strings.TrimSpace(string(out)), err
 }
@@ -195,12 +341,254 @@ func (e *testEnv) cleanup() {
 	if e.daemon != nil && e.daemon.Process != nil {
 		_ = e.daemon.Process.Signal(syscall.SIGTERM)
 		_ = e.daemon.Wait()
+		if e.t.Failed() {
+			e.t.Logf("last %d lines of the groved log:\n%s", daemonLogLines, e.daemonLog.tail(daemonLogLines))
+		}
+		return
+	}
+	// A daemon grove started on demand: its PID is in the root lock file.
+	if pid := e.daemonPID(); pid > 0 {
+		_ = syscall.Kill(pid, syscall.SIGTERM)
+	}
+}
+
+// daemonPID returns the PID recorded by the daemon holding the root lock,
+// or 0 if there is none.
+func (e *testEnv) daemonPID() int {
+	data, err := os.ReadFile(filepath.Join(e.groveRoot, "groved.lock"))
+	if err != nil {
+		return 0
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	return pid
+}
+
+// hostExec makes the mock docker run the commands it is asked to exec on
+// the host instead of succeeding at once, so that an agent such as sh
+// really runs and answers.  It returns the directory they run in.
+func (e *testEnv) hostExec() string {
+	e.t.Helper()
+	dir := e.dockerLog() + ".host"
+	require.NoError(e.t, os.Mkdir(dir, 0o755))
+	return dir
+}
+
+// outputTimeout is how long the PTY and frame harnesses wait for expected
+// output.
+const outputTimeout = 10 * time.Second
+
+// ttySession is grove running at a terminal of its own, as a person would
+// run it: its output is collected for expect, and send types into it.
+type ttySession struct {
+	t    *testing.T
+	env  *testEnv
+	cmd  *exec.Cmd
+	ptm  *os.File
+	out  *lockedBuffer
+	done chan struct{} // closed when the output has been read to the end
+	seen int           // how much of out earlier expects have matched
+}
+
+// groveTTY starts a grove subcommand at an 80x24 terminal.
+func (e *testEnv) groveTTY(args ...string) *ttySession {
+	e.t.Helper()
+	cmd := exec.Command(groveBin, args...)
+	cmd.Env = e.envVars()
+	ptm, err := pty.StartWithSize(cmd, &pty.Winsize{Cols: 80, Rows: 24})
+	require.NoError(e.t, err, "grove %v", args)
+	s := &ttySession{t: e.t, env: e, cmd: cmd, ptm: ptm, out: &lockedBuffer{}, done: make(chan struct{})}
+	go func() {
+		// Reading fails with EIO once grove has exited and closed the
+		// terminal.
+		_, _ = io.Copy(s.out, ptm)
+		close(s.done)
+	}()
+	e.t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		ptm.Close()
+	})
+	return s
+}
+
+// send types input at the terminal.
+func (s *ttySession) send(input string) {
+	s.t.Helper()
+	_, err := s.ptm.WriteString(input)
+	require.NoError(s.t, err)
+}
+
+// expect waits for want to appear in the output after what earlier calls
+// matched.
+func (s *ttySession) expect(want string) {
+	s.t.Helper()
+	deadline := time.Now().Add(outputTimeout)
+	for time.Now().Before(deadline) {
+		if out := s.out.String(); strings.Contains(out[s.seen:], want) {
+			s.seen += strings.Index(out[s.seen:], want) + len(want)
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	s.t.Fatalf("%q did not appear within %s; the terminal shows:\n%s", want, outputTimeout, s.out.String()[s.seen:])
+}
+
+// resize changes the terminal's size, which sends grove a SIGWINCH, and
+// waits for grove attach to pass it on: the daemon, started by startDaemon,
+// traces the resize frame.
+func (s *ttySession) resize(cols, rows uint16) {
+	s.t.Helper()
+	const traced = "attach in: resize frame"
+	before := strings.Count(s.env.daemonLog.String(), traced)
+	require.NoError(s.t, pty.Setsize(s.ptm, &pty.Winsize{Cols: cols, Rows: rows}))
+	deadline := time.Now().Add(outputTimeout)
+	for strings.Count(s.env.daemonLog.String(), traced) == before {
+		if time.Now().After(deadline) {
+			s.t.Fatalf("no resize frame within %s", outputTimeout)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// wait waits for grove to exit and returns its error.
+func (s *ttySession) wait() error {
+	s.t.Helper()
+	exited := make(chan error, 1)
+	go func() { exited <- s.cmd.Wait() }()
+	select {
+	case err := <-exited:
+		<-s.done
+		return err
+	case <-time.After(outputTimeout):
+		s.t.Fatalf("grove did not exit within %s; the terminal shows:\n%s", outputTimeout, s.out.String())
+		return nil
+	}
+}
+
+// frameSession is an attach connection made by hand, frame by frame, for
+// assertions about the protocol itself rather than about grove attach.
+type frameSession struct {
+	t      *testing.T
+	conn   net.Conn
+	r      *bufio.Reader
+	out    strings.Builder     // the output the data frames carried
+	seen   int                 // how much of out earlier expects have matched
+	states []proto.AttachState // the state frames received, in order
+	exit   *proto.AttachExit   // the exit frame, once received
+}
+
+// attachFrames attaches to an instance's agent as a v2 client (see
+// proto.AttachFrameData) and checks the handshake.
+func (e *testEnv) attachFrames(instanceID string) *frameSession {
+	e.t.Helper()
+	conn, err := net.Dial("unix", e.sockPath)
+	require.NoError(e.t, err)
+	e.t.Cleanup(func() { conn.Close() })
+	require.NoError(e.t, conn.SetDeadline(time.Now().Add(outputTimeout)))
+
+	req, err := json.Marshal(proto.Request{Type: proto.ReqAttach, InstanceID: instanceID, AttachV2: true})
+	require.NoError(e.t, err)
+	_, err = conn.Write(append(req, '\n'))
+	require.NoError(e.t, err)
+	r := bufio.NewReader(conn)
+	line, err := r.ReadBytes('\n')
+	require.NoError(e.t, err)
+	var resp proto.Response
+	require.NoError(e.t, json.Unmarshal(line, &resp), "handshake: %s", line)
+	require.True(e.t, resp.OK, resp.Error)
+	require.True(e.t, resp.AttachV2, "the daemon frames its side")
+	return &frameSession{t: e.t, conn: conn, r: r}
+}
+
+// send sends the client frame frameType with payload.
+func (f *frameSession) send(frameType byte, payload []byte) {
+	f.t.Helper()
+	require.NoError(f.t, proto.WriteFrame(f.conn, frameType, payload))
+}
+
+// read reads and records one frame from the daemon, returning its type.
+func (f *frameSession) read() (byte, error) {
+	f.t.Helper()
+	frameType, payload, err := proto.ReadFrame(f.r)
+	if err != nil {
+		return 0, err
+	}
+	switch frameType {
+	case proto.AttachFrameData:
+		f.out.Write(payload)
+	case proto.AttachFrameState:
+		var st proto.AttachState
+		require.NoError(f.t, json.Unmarshal(payload, &st), "state frame: %s", payload)
+		f.states = append(f.states, st)
+	case proto.AttachFrameExit:
+		var exit proto.AttachExit
+		require.NoError(f.t, json.Unmarshal(payload, &exit), "exit frame: %s", payload)
+		f.exit = &exit
+	case proto.AttachFramePing:
+		require.Empty(f.t, payload, "ping frames are empty")
+	default:
+		f.t.Fatalf("unknown frame type 0x%02x", frameType)
+	}
+	return frameType, nil
+}
+
+// expect reads frames until the output after what earlier calls matched
+// contains want.
+func (f *frameSession) expect(want string) {
+	f.t.Helper()
+	require.NoError(f.t, f.conn.SetReadDeadline(time.Now().Add(outputTimeout)))
+	for {
+		if out := f.out.String(); strings.Contains(out[f.seen:], want) {
+			f.seen += strings.Index(out[f.seen:], want) + len(want)
+			return
+		}
+		_, err := f.read()
+		require.NoError(f.t, err, "waiting for %q; the output was:\n%s", want, f.out.String()[f.seen:])
+	}
+}
+
+// expectExit reads frames until the exit frame, which must be the last.
+func (f *frameSession) expectExit() proto.AttachExit {
+	f.t.Helper()
+	require.NoError(f.t, f.conn.SetReadDeadline(time.Now().Add(outputTimeout)))
+	for f.exit == nil {
+		_, err := f.read()
+		require.NoError(f.t, err, "waiting for the exit frame")
+	}
+	f.expectClosed()
+	return *f.exit
+}
+
+// expectClosed reads frames until the daemon hangs up.
+func (f *frameSession) expectClosed() {
+	f.t.Helper()
+	require.NoError(f.t, f.conn.SetReadDeadline(time.Now().Add(outputTimeout)))
+	for {
+		if _, err := f.read(); err != nil {
+			require.ErrorIs(f.t, err, io.EOF, "the daemon hangs up")
+			return
+		}
 	}
 }
 
 // makeGitRepo creates a local git repo with a minimal grove.yaml committed.
 // Returns the repo path, which can be used as the --repo argument.
 func makeGitRepo(t *testing.T) string {
+	t.Helper()
+	// grove.yaml: use `sh` as the agent (always present in containers).
+	// start is empty so we don't need real commands to succeed.
+	return makeGitRepoWithConfig(t, "container:\n  image: alpine\nstart: []\nagent:\n  command: sh\n  args: []\n")
+}
+
+// makeGitRepoWithConfig is makeGitRepo with a caller-supplied grove.yaml.
+func makeGitRepoWithConfig(t *testing.T, groveYAML string) string {
+	t.Helper()
+	return makeGitRepoWithFiles(t, map[string]string{"grove.yaml": groveYAML})
+}
+
+// makeGitRepoWithFiles creates a local git repo with files (path → content)
+// committed on main.
+func makeGitRepoWithFiles(t *testing.T, files map[string]string) string {
 	t.Helper()
 	dir := t.TempDir()
 
@@ -217,10 +605,10 @@ func makeGitRepo(t *testing.T) string {
 	run("git", "config", "user.email", "test@grove.test")
 	run("git", "config", "user.name", "Grove Integration Test")
 
-	// grove.yaml: use `sh` as the agent (always present in containers).
-	// start is empty so we don't need real commands to succeed.
-	groveYAML := "container:\n  image: alpine\nstart: []\nagent:\n  command: sh\n  args: []\n"
-	require.NoError(t, os.WriteFile(filepath.Join(dir, "grove.yaml"), []byte(groveYAML), 0o644))
+	for name, content := range files {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+	}
 
 	run("git", "add", ".")
 	run("git", "commit", "-m", "init")
@@ -260,6 +648,33 @@ func TestInstanceListEmpty(t *testing.T) {
 	_ = out
 }
 
+// TestInstanceNotFound checks that a mistyped instance ID is answered with
+// the instances it may have meant and the live ones.
+func TestInstanceNotFound(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in -short mode")
+	}
+
+	env := newTestEnv(t)
+	repoDir := makeGitRepo(t)
+	env.startDaemon()
+
+	out, err := env.grove("status", "3")
+	assert.Error(t, err)
+	assert.Contains(t, out, "no instance 3; there are no instances")
+
+	env.groveOK("project", "create", "typo-app", "--repo", repoDir)
+	env.groveOK("start", "typo-app", "fix-login", "-d")
+	out, err = env.grove("stop", "11")
+	assert.Error(t, err)
+	assert.Contains(t, out, "grove: no instance 11\n\ndid you mean:\n  1    typo-app/fix-login")
+	assert.Contains(t, out, "no live instances; grove list shows them all")
+	out, err = env.grove("logs", "fix")
+	assert.Error(t, err)
+	assert.Contains(t, out, "did you mean:\n  1    typo-app/fix-login")
+	assert.Contains(t, env.groveOK("status", "#1"), "fix-login", "normalised")
+}
+
 // TestFullLifecycle exercises the full start → list → stop → drop path.
 // Uses a real local git repo and a mock docker so no Docker daemon is needed.
 func TestFullLifecycle(t *testing.T) {
@@ -309,6 +724,102 @@ func TestMultipleInstances(t *testing.T) {
 	out := env.groveOK("list")
 	assert.Contains(t, out, "feat/a")
 	assert.Contains(t, out, "feat/b")
+
+	assert.Equal(t, "1\tfeat/a\n2\tfeat/b\n", env.groveStdout("list", "--format", `{{.ID}}\t{{.Branch}}`))
+	assert.Equal(t, "2\n1\n", env.groveStdout("list", "--reverse", "--format", "{{.ID}}"))
+	assert.Contains(t, env.groveOK("list"), "CREATED")
+	out, err := env.grove("list", "--sort", "branch")
+	require.Error(t, err)
+	assert.Contains(t, out, "unknown sort key")
+	out, err = env.grove("list", "--format", "{{.Nope}}")
+	require.Error(t, err)
+	assert.Contains(t, out, "can't evaluate field Nope")
+	assert.Contains(t, out, "available fields: .ID .Project")
+}
+
+// TestConcurrentStarts runs two starts on one project at once: both must
+// succeed, each with its own worktree and instance ID.
+func TestConcurrentStarts(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in -short mode")
+	}
+
+	env := newTestEnv(t)
+	repoDir := makeGitRepo(t)
+	env.startDaemon()
+
+	env.groveOK("project", "create", "race-app", "--repo", repoDir)
+
+	// Both starts clone/pull and add a worktree to the same main checkout.
+	branches := []string{"feat/one", "feat/two"}
+	outs := make([]string, len(branches))
+	errs := make([]error, len(branches))
+	var wg sync.WaitGroup
+	for i, b := range branches {
+		wg.Add(1)
+		go func(i int, b string) {
+			defer wg.Done()
+			outs[i], errs[i] = env.grove("start", "race-app", b, "-d")
+		}(i, b)
+	}
+	wg.Wait()
+	for i := range branches {
+		require.NoError(t, errs[i], "start %s: %s", branches[i], outs[i])
+	}
+
+	out := env.groveOK("list")
+	assert.Contains(t, out, "feat/one")
+	assert.Contains(t, out, "feat/two")
+	assert.Equal(t, 2, strings.Count(out, "race-app"), "each start needs its own instance ID:\n%s", out)
+}
+
+// TestStaleSocketTakeover leaves behind the socket of a daemon that died
+// hard and runs several commands at once: one of them starts a new daemon,
+// which replaces the stale socket, and every command reaches it.
+func TestStaleSocketTakeover(t *testing.T) {
+	env := newTestEnv(t)
+
+	dead, err := net.Listen("unix", env.sockPath)
+	require.NoError(t, err)
+	dead.(*net.UnixListener).SetUnlinkOnClose(false)
+	dead.Close()
+	require.FileExists(t, env.sockPath)
+
+	const clients = 4
+	outs := make([]string, clients)
+	errs := make([]error, clients)
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			outs[i], errs[i] = env.grove("list")
+		}(i)
+	}
+	wg.Wait()
+	for i := 0; i < clients; i++ {
+		require.NoError(t, errs[i], "client %d: %s", i, outs[i])
+	}
+
+	pid := env.daemonPID()
+	require.Positive(t, pid, "the daemon should record its PID in the root lock")
+	assert.NoError(t, syscall.Kill(pid, 0), "daemon %d should be running", pid)
+	env.groveOK("list")
+}
+
+// TestDaemonOnDemandArgs checks that a daemon grove starts on demand gets
+// the flags grove daemon install would give it, and logs them.
+func TestDaemonOnDemandArgs(t *testing.T) {
+	projects := t.TempDir()
+	t.Setenv("GROVE_PROJECTS_PATH", projects)
+	env := newTestEnv(t)
+	require.NoError(t, os.MkdirAll(env.groveRoot, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(env.groveRoot, "config.yaml"), []byte("daemon_args: [--disk-min, \"0\"]\n"), 0o644))
+
+	env.groveOK("list")
+	log, err := os.ReadFile(filepath.Join(env.groveRoot, "daemon.log"))
+	require.NoError(t, err, "the daemon's output goes to daemon.log")
+	assert.Contains(t, string(log), fmt.Sprintf(`"--root" %q "--projects-dir" %q "--disk-min" "0"]`, env.groveRoot, projects))
 }
 
 // TestStopAndRestart verifies that stop transitions the instance to KILLED
@@ -338,6 +849,198 @@ func TestStopAndRestart(t *testing.T) {
 	env.groveOK("restart", "1", "-d")
 }
 
+// TestShowConfig checks that an instance keeps the config it was started
+// with after grove.yaml changes, and that restart --current-config adopts
+// the new one.
+func TestShowConfig(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in -short mode")
+	}
+
+	env := newTestEnv(t)
+	repoDir := makeGitRepo(t)
+	env.startDaemon()
+
+	env.groveOK("project", "create", "cfg-app", "--repo", repoDir)
+	env.groveOK("start", "cfg-app", "feat/cfg", "-d")
+
+	out := env.groveOK("show-config", "1")
+	assert.Contains(t, out, "image: alpine")
+	assert.Contains(t, out, "command: sh")
+
+	worktree := env.groveOK("dir", "1")
+	require.NoError(t, os.WriteFile(filepath.Join(worktree, "grove.yaml"),
+		[]byte("container:\n  image: alpine:3.20\nstart: []\nagent:\n  command: sh\n  args: []\n"), 0o644))
+
+	assert.Contains(t, env.groveOK("show-config", "1"), "image: alpine\n", "the recorded config does not change")
+	out = env.groveOK("show-config", "1", "--diff")
+	assert.Contains(t, out, "-    image: alpine")
+	assert.Contains(t, out, "+    image: alpine:3.20")
+
+	time.Sleep(100 * time.Millisecond)
+	_, _ = env.grove("stop", "1")
+	env.groveOK("restart", "1", "-d", "--current-config")
+	assert.Contains(t, env.groveOK("show-config", "1", "--diff"), "no changes")
+
+	env.groveOK("drop", "-f", "1")
+	_, err := os.Stat(filepath.Join(env.groveRoot, "instances", "1.config.yaml"))
+	assert.True(t, os.IsNotExist(err), "drop removes the recorded config")
+}
+
+// TestRecreateContainer checks that the image an instance runs is recorded,
+// that a change to the configured image is flagged, and that restart
+// --recreate-container moves the instance to it, or leaves it FAILED_SETUP.
+func TestRecreateContainer(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in -short mode")
+	}
+
+	env := newTestEnv(t)
+	repoDir := makeGitRepo(t)
+	env.startDaemon()
+
+	env.groveOK("project", "create", "img-app", "--repo", repoDir)
+	env.groveOK("start", "img-app", "feat/img", "-d")
+	status := env.groveOK("status", "1")
+	assert.Regexp(t, `alpine \([0-9a-f]{12}\)`, status)
+	assert.Contains(t, status, "/tmp (size=1g)", "container.tmpfs defaults to /tmp")
+	assert.Contains(t, env.groveOK("list", "-o", "wide"), "alpine (")
+	assert.NotContains(t, env.groveOK("list"), "1*")
+
+	worktree := env.groveOK("dir", "1")
+	writeConfig := func(yaml string) {
+		t.Helper()
+		require.NoError(t, os.WriteFile(filepath.Join(worktree, "grove.yaml"), []byte(yaml), 0o644))
+	}
+	writeConfig("container:\n  image: alpine:3.20\nstart: []\nagent:\n  command: sh\n  args: []\n")
+	out := env.groveOK("list")
+	assert.Contains(t, out, "1*")
+	assert.Contains(t, out, "--recreate-container")
+	assert.Contains(t, env.groveOK("status", "1"), "alpine:3.20 is configured now")
+
+	time.Sleep(100 * time.Millisecond)
+	_, _ = env.grove("stop", "1")
+	env.groveOK("restart", "1", "-d", "--recreate-container")
+	assert.Contains(t, env.groveOK("status", "1"), "alpine:3.20 (")
+	assert.NotContains(t, env.groveOK("list"), "1*")
+
+	// A failing start command leaves the instance FAILED_SETUP, which only
+	// another recreate gets out of.
+	writeConfig("container:\n  image: alpine:3.20\nstart:\n  - exit 3\nagent:\n  command: sh\n  args: []\n")
+	time.Sleep(100 * time.Millisecond)
+	_, _ = env.grove("stop", "1")
+	out, err := env.grove("restart", "1", "-d", "--recreate-container")
+	assert.Error(t, err)
+	assert.Contains(t, out, "FAILED_SETUP")
+	assert.Contains(t, env.groveOK("list"), "FAILED_SETUP")
+	out, err = env.grove("restart", "1", "-d")
+	assert.Error(t, err)
+	assert.Contains(t, out, "--recreate-container")
+
+	writeConfig("container:\n  image: alpine:3.20\nstart: []\nagent:\n  command: sh\n  args: []\n")
+	env.groveOK("restart", "1", "-d", "--recreate-container")
+	assert.NotContains(t, env.groveOK("list"), "FAILED_SETUP")
+}
+
+// TestBaseCommit checks that an instance records the commit it branched
+// from, and that grove status and grove list -o wide count the commits the
+// main checkout gains after it.
+func TestBaseCommit(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in -short mode")
+	}
+
+	env := newTestEnv(t)
+	repoDir := makeGitRepo(t)
+	env.startDaemon()
+
+	env.groveOK("project", "create", "base-app", "--repo", repoDir)
+	env.groveOK("start", "base-app", "feat/base", "-d")
+	main := filepath.Join(env.groveRoot, "projects", "base-app", "main")
+	head, err := exec.Command("git", "-C", main, "rev-parse", "HEAD").Output()
+	require.NoError(t, err)
+	base := strings.TrimSpace(string(head))
+
+	var info proto.InstanceInfo
+	require.NoError(t, json.Unmarshal([]byte(env.groveOK("status", "1", "--json")), &info))
+	assert.Equal(t, base, info.BaseSHA)
+	status := env.groveOK("status", "1")
+	assert.Contains(t, status, "Base:")
+	assert.Contains(t, status, base)
+	assert.NotContains(t, status, "commit(s) since")
+
+	out, err := exec.Command("git", "-C", main, "-c", "user.name=t", "-c", "user.email=t@t",
+		"commit", "-q", "--allow-empty", "-m", "landed on main").CombinedOutput()
+	require.NoError(t, err, string(out))
+	assert.Contains(t, env.groveOK("status", "1"), "the main checkout has 1 commit(s) since")
+	assert.Contains(t, env.groveOK("list", "-o", "wide"), base[:7]+" +1")
+}
+
+// TestStopStack checks that grove stop --stack stops a compose instance's
+// services and that restart starts them again.
+func TestStopStack(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in -short mode")
+	}
+
+	env := newTestEnv(t)
+	repoDir := makeGitRepoWithFiles(t, map[string]string{
+		"grove.yaml":         "container:\n  compose: docker-compose.yml\nstart: []\nagent:\n  command: sh\n  args: []\n",
+		"docker-compose.yml": "services:\n  app:\n    image: alpine\n",
+	})
+	env.startDaemon()
+
+	env.groveOK("project", "create", "stack-app", "--repo", repoDir)
+	env.groveOK("start", "stack-app", "feat/stack", "-d")
+	env.groveOK("project", "create", "plain-app", "--repo", makeGitRepo(t))
+	env.groveOK("start", "plain-app", "feat/plain", "-d")
+
+	out, err := env.grove("stop", "2", "--stack")
+	assert.Error(t, err)
+	assert.Contains(t, out, "no compose stack")
+
+	env.groveOK("stop", "1", "--stack")
+	assert.Contains(t, env.groveOK("list"), "(stack stopped)")
+	assert.Contains(t, env.groveOK("status", "1"), "stopped; grove restart starts it")
+
+	env.groveOK("restart", "1", "-d")
+	assert.NotContains(t, env.groveOK("list"), "(stack stopped)")
+
+	calls, err := os.ReadFile(env.dockerLog())
+	require.NoError(t, err)
+	assert.Contains(t, string(calls), "compose -p grove-1 stop")
+	assert.Contains(t, string(calls), "compose -p grove-1 start")
+}
+
+// TestLastInstance checks that "-" and --last stand for the instance used
+// most recently, and that a stale one is reported.
+func TestLastInstance(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in -short mode")
+	}
+
+	env := newTestEnv(t)
+	repoDir := makeGitRepo(t)
+	env.startDaemon()
+
+	_, err := env.grove("dir", "-")
+	assert.Error(t, err, "nothing used yet")
+
+	env.groveOK("project", "create", "last-app", "--repo", repoDir)
+	env.groveOK("start", "last-app", "feat/one", "-d")
+	env.groveOK("start", "last-app", "feat/two", "-d")
+
+	two := env.groveOK("dir", "2")
+	assert.Equal(t, two, env.groveOK("dir", "-"))
+	assert.Equal(t, two, env.groveOK("dir", "--last"))
+
+	env.groveOK("drop", "-f", "--last")
+	out, err := env.grove("dir", "-")
+	assert.Error(t, err)
+	assert.Contains(t, out, "the last instance used (2) no longer exists")
+	assert.Contains(t, out, "feat/one", "current instances are listed")
+}
+
 // TestLogs verifies that `grove logs` returns output without error.
 func TestLogs(t *testing.T) {
 	if testing.Short() {
@@ -345,7 +1048,8 @@ func TestLogs(t *testing.T) {
 	}
 
 	env := newTestEnv(t)
-	repoDir := makeGitRepo(t)
+	repoDir := makeGitRepoWithConfig(t, "container:\n  image: alpine\nstart:\n  - make deps\n"+
+		"agent:\n  command: sh\n  args: []\nfinish:\n  - make release\n")
 	env.startDaemon()
 
 	env.groveOK("project", "create", "my-app", "--repo", repoDir)
@@ -355,5 +1059,1175 @@ func TestLogs(t *testing.T) {
 	time.Sleep(100 * time.Millisecond)
 
 	out := env.groveOK("logs", "1")
-	_ = out
+	assert.NotContains(t, out, "===grove:setup", "markers stay in the log file")
+
+	env.groveOK("finish", "1")
+	setup := env.groveOK("logs", "1", "--setup")
+	assert.Contains(t, setup, "Start: make deps")
+	assert.NotContains(t, setup, "make release")
+	assert.NotContains(t, setup, "===grove:setup")
+
+	agent := env.groveOK("logs", "1", "--agent")
+	assert.Contains(t, agent, "$ make release")
+	assert.NotContains(t, agent, "Start: make deps")
+	assert.NotContains(t, agent, "===grove:setup")
+
+	_, err := env.grove("logs", "1", "--setup", "-f")
+	assert.Error(t, err)
+}
+
+// TestAttach drives grove attach at a terminal against a real sh: what is
+// typed reaches the agent, a resize reaches its terminal, and Ctrl-]
+// detaches and leaves it running.  It then attaches frame by frame for the
+// protocol itself: the replay, state frames, resize and detach frames, and
+// the exit frame when the agent exits.
+func TestAttach(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in -short mode")
+	}
+
+	env := newTestEnv(t)
+	env.hostExec()
+	repoDir := makeGitRepo(t)
+	env.startDaemon()
+
+	env.groveOK("project", "create", "my-app", "--repo", repoDir)
+	env.groveOK("start", "my-app", "feat/attach", "-d")
+
+	s := env.groveTTY("attach", "1")
+	s.expect("attached to 1")
+	s.send("echo $((40+2))\r")
+	s.expect("42")
+	s.resize(101, 33)
+	s.send("stty size\r")
+	s.expect("33 101")
+	s.send("\x1d") // Ctrl-]
+	s.expect("detached from 1")
+	require.NoError(t, s.wait())
+	assert.NotContains(t, env.groveOK("list"), "EXITED", "detaching leaves the agent running")
+
+	f := env.attachFrames("1")
+	f.expect("33 101") // replayed
+	f.send(proto.AttachFrameResize, proto.ResizePayload(120, 40))
+	f.send(proto.AttachFrameData, []byte("stty size\r"))
+	f.expect("40 120")
+	require.NotEmpty(t, f.states, "a state frame as soon as attached")
+	assert.Contains(t, []string{proto.StateRunning, proto.StateWaiting}, f.states[0].State)
+	f.send(proto.AttachFrameDetach, nil)
+	f.expectClosed()
+	assert.Nil(t, f.exit)
+
+	f = env.attachFrames("1")
+	f.send(proto.AttachFrameData, []byte("exit 7\r"))
+	assert.Equal(t, proto.AttachExit{State: proto.StateCrashed, ExitCode: 7}, f.expectExit())
+	assert.Contains(t, env.groveOK("list"), "CRASHED")
+}
+
+// TestConfigBoilerplate checks the offer grove start makes for a project
+// without a grove.yaml: a boilerplate, committed if asked, or a merge of
+// the branch that has one.
+func TestConfigBoilerplate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in -short mode")
+	}
+	for _, kv := range []string{"GIT_AUTHOR_NAME=t", "GIT_AUTHOR_EMAIL=t@t", "GIT_COMMITTER_NAME=t", "GIT_COMMITTER_EMAIL=t@t"} {
+		k, v, _ := strings.Cut(kv, "=")
+		t.Setenv(k, v)
+	}
+
+	env := newTestEnv(t)
+	repoDir := makeGitRepoWithFiles(t, map[string]string{"README.md": "hi\n"})
+	env.startDaemon()
+	env.groveOK("project", "create", "bare-app", "--repo", repoDir)
+
+	out, err := env.groveInput("y\n", "start", "bare-app", "feat/one", "-d", "--commit")
+	assert.Error(t, err)
+	assert.Contains(t, out, "Create a boilerplate now?")
+	assert.Contains(t, out, "Committed")
+	main := filepath.Join(env.groveRoot, "projects", "bare-app", "main")
+	log, err := exec.Command("git", "-C", main, "log", "-1", "--format=%s", "--name-only").Output()
+	require.NoError(t, err)
+	assert.Equal(t, "Add grove config\n\n.grove/grove.yaml\n", string(log))
+
+	// A grove.yaml on a branch: merging it is suggested instead.
+	other := makeGitRepoWithFiles(t, map[string]string{"README.md": "hi\n"})
+	for _, args := range [][]string{
+		{"checkout", "-q", "-b", "setup"},
+		{"commit", "-q", "--allow-empty", "-m", "wip"},
+	} {
+		require.NoError(t, exec.Command("git", append([]string{"-C", other}, args...)...).Run())
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(other, "grove.yaml"), []byte("container:\n  image: alpine\n"), 0o644))
+	require.NoError(t, exec.Command("git", "-C", other, "add", "grove.yaml").Run())
+	require.NoError(t, exec.Command("git", "-C", other, "commit", "-q", "-m", "config").Run())
+	require.NoError(t, exec.Command("git", "-C", other, "checkout", "-q", "main").Run())
+	env.groveOK("project", "create", "branchy", "--repo", other)
+
+	out, err = env.groveInput("\n", "start", "branchy", "feat/two", "-d")
+	assert.Error(t, err)
+	assert.Contains(t, out, "The repo has one on origin/setup")
+	assert.Contains(t, out, "merge origin/setup")
+	assert.Contains(t, out, "Create a boilerplate anyway?")
+	assert.Contains(t, out, "aborted")
+	assert.NoFileExists(t, filepath.Join(env.groveRoot, "projects", "branchy", "main", ".grove", "grove.yaml"))
+
+	// An invalid one is not taken for a missing one.
+	bad := makeGitRepoWithConfig(t, "container: [\n")
+	env.groveOK("project", "create", "bad-app", "--repo", bad)
+	out, err = env.grove("start", "bad-app", "feat/three", "-d")
+	assert.Error(t, err)
+	assert.Contains(t, out, "invalid grove.yaml")
+	assert.Contains(t, out, "grove validate ")
+	assert.NotContains(t, out, "boilerplate")
+}
+
+// TestLogsAll checks that grove logs --all merges the output of every
+// instance, each line prefixed with its instance.
+func TestLogsAll(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in -short mode")
+	}
+
+	env := newTestEnv(t)
+	env.hostExec()
+	agent := func(says string) string {
+		return "container:\n  image: alpine\nagent:\n  command: sh\n  args: [\"-c\", \"printf '\\\\033[1m" + says + "\\\\033[0m\\\\n'\"]\n"
+	}
+	apiRepo := makeGitRepoWithConfig(t, agent("api says hi"))
+	webRepo := makeGitRepoWithConfig(t, agent("web says hi"))
+	env.startDaemon()
+
+	env.groveOK("project", "create", "api", "--repo", apiRepo)
+	env.groveOK("project", "create", "web", "--repo", webRepo)
+	env.groveOK("start", "api", "feat/a", "-d")
+	env.groveOK("start", "web", "feat/b", "-d")
+
+	var out string
+	require.Eventually(t, func() bool {
+		out = env.groveOK("logs", "--all")
+		return strings.Contains(out, "web says hi")
+	}, 10*time.Second, 50*time.Millisecond)
+	assert.Contains(t, out, "[1/feat/a] api says hi\n", "escape sequences are stripped")
+	assert.Contains(t, out, "[2/feat/b] web says hi")
+	assert.Less(t, strings.Index(out, "api says hi"), strings.Index(out, "web says hi"), "in the order it was output")
+
+	out = env.groveOK("logs", "--all", "--project", "web", "--since", "1h")
+	assert.Equal(t, "[2/feat/b] web says hi", out)
+	out, err := env.grove("logs", "--all", "--project", "nope")
+	assert.Error(t, err)
+	assert.Contains(t, out, "project nope has no instances")
+	_, err = env.grove("logs", "--all", "1")
+	assert.Error(t, err, "--all takes no instance")
+}
+
+// TestLogsFollow checks that grove logs -f prints output as the agent
+// writes it, and ends once the agent has exited and all of it is out.
+func TestLogsFollow(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in -short mode")
+	}
+
+	env := newTestEnv(t)
+	dir := env.hostExec()
+	// The agent writes a second line only once the test creates "next".
+	repoDir := makeGitRepoWithConfig(t, "container:\n  image: alpine\nstart: []\n"+
+		"agent:\n  command: sh\n  args:\n    - -c\n    - echo tick 1; while [ ! -e next ]; do sleep 0.05; done; echo tick 2\n")
+	env.startDaemon()
+
+	env.groveOK("project", "create", "my-app", "--repo", repoDir)
+	env.groveOK("start", "my-app", "feat/follow", "-d")
+
+	cmd := exec.Command(groveBin, "logs", "1", "-f")
+	cmd.Env = env.envVars()
+	stdout, err := cmd.StdoutPipe()
+	require.NoError(t, err)
+	require.NoError(t, cmd.Start())
+	exited := make(chan error, 1)
+	lines := make(chan string)
+	go func() {
+		sc := bufio.NewScanner(stdout)
+		for sc.Scan() {
+			lines <- strings.TrimRight(sc.Text(), "\r")
+		}
+		close(lines)
+		exited <- cmd.Wait()
+	}()
+	t.Cleanup(func() { _ = cmd.Process.Kill() })
+	readUntil := func(want string) {
+		t.Helper()
+		timeout := time.After(outputTimeout)
+		for {
+			select {
+			case line, ok := <-lines:
+				require.True(t, ok, "grove logs -f ended before %q", want)
+				if line == want {
+					return
+				}
+			case <-timeout:
+				t.Fatalf("%q did not appear within %s", want, outputTimeout)
+			}
+		}
+	}
+
+	readUntil("tick 1")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "next"), nil, 0o644))
+	readUntil("tick 2")
+	for range lines {
+	}
+	select {
+	case err := <-exited:
+		require.NoError(t, err)
+	case <-time.After(outputTimeout):
+		t.Fatal("grove logs -f went on after the agent exited")
+	}
+	assert.Contains(t, env.groveOK("list"), "EXITED")
+}
+
+// TestCopy round-trips a file into and out of an instance worktree, both
+// directly and streamed through the daemon.
+func TestCopy(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in -short mode")
+	}
+
+	env := newTestEnv(t)
+	repoDir := makeGitRepo(t)
+	env.startDaemon()
+
+	env.groveOK("project", "create", "my-app", "--repo", repoDir)
+	env.groveOK("start", "my-app", "feat/cp-test", "-d")
+
+	local := filepath.Join(t.TempDir(), "fixture.txt")
+	require.NoError(t, os.WriteFile(local, []byte("seed"), 0o644))
+
+	for _, extra := range [][]string{nil, {"--via-daemon"}} {
+		env.groveOK(append([]string{"cp", local, "1:fixture.txt"}, extra...)...)
+
+		out := filepath.Join(t.TempDir(), "back.txt")
+		env.groveOK(append([]string{"cp", "1:fixture.txt", out}, extra...)...)
+		data, err := os.ReadFile(out)
+		require.NoError(t, err)
+		assert.Equal(t, "seed", string(data))
+
+		_, err = env.grove(append([]string{"cp", "1:../escape", out}, extra...)...)
+		assert.Error(t, err, "path traversal must be rejected")
+	}
+}
+
+// TestFinishResult checks the summary and the --json result trailer that end
+// a finish stream, and that a failing command makes grove exit non-zero.
+func TestFinishResult(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in -short mode")
+	}
+
+	env := newTestEnv(t)
+	repoDir := makeGitRepoWithConfig(t, "container:\n  image: alpine\nstart: []\n"+
+		"agent:\n  command: sh\n  args: []\nfinish:\n  - echo done\n  - run: exit 3\n    dir: sub\n    name: push\n  - echo never\n")
+	env.startDaemon()
+
+	env.groveOK("project", "create", "my-app", "--repo", repoDir)
+	env.groveOK("start", "my-app", "feat/a", "-d")
+	env.groveOK("start", "my-app", "feat/b", "-d")
+
+	out, err := env.grove("finish", "1")
+	assert.Error(t, err)
+	assert.Contains(t, out, "1 passed, 1 failed in")
+	assert.Contains(t, out, "exit 3")
+	assert.Contains(t, out, "push", "the summary uses the command's name")
+	calls, err := os.ReadFile(env.dockerLog())
+	require.NoError(t, err)
+	assert.Contains(t, string(calls), "exec -w /app/sub ", "dir: runs the command there")
+
+	cmd := exec.Command(groveBin, "finish", "2", "--json")
+	cmd.Env = env.envVars()
+	stdout, err := cmd.Output()
+	assert.Error(t, err)
+
+	var res struct {
+		OK       bool `json:"ok"`
+		Commands []struct {
+			Command  string `json:"command"`
+			Name     string `json:"name"`
+			ExitCode int    `json:"exit_code"`
+		} `json:"commands"`
+	}
+	require.NoError(t, json.Unmarshal(stdout, &res), "stdout: %s", stdout)
+	assert.False(t, res.OK)
+	require.Len(t, res.Commands, 2, "finish stops at the first failure")
+	assert.Equal(t, 0, res.Commands[0].ExitCode)
+	assert.Equal(t, "exit 3", res.Commands[1].Command)
+	assert.Equal(t, "push", res.Commands[1].Name)
+	assert.Equal(t, 3, res.Commands[1].ExitCode)
+
+	// Finishing again runs nothing and succeeds.
+	env.groveOK("finish", "1")
+}
+
+// TestFinishStreaming runs grove finish at a terminal with commands that
+// really run: their output streams as they go, a tty: true command gets
+// what is typed, and the first failure ends the run with the rest unrun.
+func TestFinishStreaming(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in -short mode")
+	}
+
+	env := newTestEnv(t)
+	env.hostExec()
+	repoDir := makeGitRepoWithConfig(t, "container:\n  image: alpine\nstart: []\n"+
+		"agent:\n  command: sh\n  args: []\nfinish:\n  - echo first\n"+
+		"  - run: sleep 0.2; printf 'ready? '; read answer; echo \"got $answer\"\n    name: ask\n    tty: true\n"+
+		"  - run: echo partial; exit 3\n    name: push\n  - echo never\n")
+	env.startDaemon()
+
+	env.groveOK("project", "create", "my-app", "--repo", repoDir)
+	env.groveOK("start", "my-app", "feat/finish", "-d")
+
+	s := env.groveTTY("finish", "1")
+	s.expect("first")
+	// The command's header, then its prompt.  It sleeps first so that the
+	// daemon is passing it input by then.
+	s.expect(`echo "got $answer"`)
+	s.expect("ready?")
+	s.send("yes\n")
+	s.expect("got yes")
+	s.expect("partial")
+	s.expect("2 passed, 1 failed in")
+	var exitErr *exec.ExitError
+	require.ErrorAs(t, s.wait(), &exitErr)
+	assert.NotContains(t, s.out.String(), "never")
+	assert.Contains(t, env.groveOK("list"), "FINISHED")
+}
+
+// TestTTYCommandNeedsTerminal checks that check and finish refuse, before
+// running anything, a command marked tty: true when grove is not at a
+// terminal.
+func TestTTYCommandNeedsTerminal(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in -short mode")
+	}
+
+	env := newTestEnv(t)
+	repoDir := makeGitRepoWithConfig(t, "container:\n  image: alpine\nstart: []\n"+
+		"agent:\n  command: sh\n  args: []\n"+
+		"check:\n  - go test ./...\n  - run: npm run e2e\n    tty: true\n"+
+		"finish:\n  - git push\n  - run: gh pr create\n    name: pr\n    tty: true\n")
+	env.startDaemon()
+
+	env.groveOK("project", "create", "my-app", "--repo", repoDir)
+	env.groveOK("start", "my-app", "feat/a", "-d")
+
+	out, err := env.grove("check", "1")
+	assert.Error(t, err)
+	assert.Contains(t, out, `check command "npm run e2e" needs a terminal`)
+
+	out, err = env.grove("finish", "1")
+	assert.Error(t, err)
+	assert.Contains(t, out, `finish command "pr" needs a terminal`)
+	assert.NotContains(t, env.groveOK("list"), "FINISHED", "the agent is left running")
+
+	calls, err := os.ReadFile(env.dockerLog())
+	require.NoError(t, err)
+	assert.NotContains(t, string(calls), "git push")
+	assert.NotContains(t, string(calls), "go test")
+}
+
+func TestReopen(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in -short mode")
+	}
+
+	env := newTestEnv(t)
+	repoDir := makeGitRepo(t)
+	env.startDaemon()
+
+	env.groveOK("project", "create", "my-app", "--repo", repoDir)
+	env.groveOK("start", "my-app", "feat/reopen", "-d")
+
+	// Only FINISHED instances can be reopened.
+	_, err := env.grove("reopen", "1", "-d")
+	assert.Error(t, err)
+
+	env.groveOK("finish", "1")
+	assert.Contains(t, env.groveOK("list"), "FINISHED")
+
+	out := env.groveOK("reopen", "1", "-d")
+	assert.Contains(t, out, "Reopened")
+	list := env.groveOK("list")
+	assert.Contains(t, list, "feat/reopen")
+	assert.NotContains(t, list, "FINISHED")
+}
+
+func TestSharedProjectsDir(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in -short mode")
+	}
+
+	repoDir := makeGitRepo(t)
+	shared := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(shared, "team-app"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(shared, "team-app", "project.yaml"),
+		[]byte("name: team-app\nrepo: "+repoDir+"\n"), 0o444))
+	t.Setenv("GROVE_PROJECTS_PATH", shared)
+
+	env := newTestEnv(t)
+	env.startDaemon()
+	env.groveOK("project", "create", "own-app", "--repo", repoDir)
+
+	out := env.groveOK("project", "list")
+	assert.Contains(t, out, "SOURCE")
+	assert.Contains(t, out, "own-app")
+	assert.Contains(t, out, "personal")
+	assert.Contains(t, out, "team-app")
+	assert.Contains(t, out, shared)
+
+	// "2" is team-app in the daemon's name-sorted list.
+	env.groveOK("start", "2", "feat/shared", "-d")
+	assert.Contains(t, env.groveOK("list"), "feat/shared")
+	assert.DirExists(t, filepath.Join(env.groveRoot, "projects", "team-app", "main"),
+		"the checkout lives in the personal root")
+
+	out, err := env.grove("project", "delete", "team-app")
+	assert.Error(t, err)
+	assert.Contains(t, out, "shared directory")
+
+	_, err = env.grove("start", "no-such-app", "feat/x", "-d")
+	assert.Error(t, err)
+}
+
+func TestConfigUnderDotGroveWithIncludes(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in -short mode")
+	}
+
+	env := newTestEnv(t)
+	good := makeGitRepoWithFiles(t, map[string]string{
+		".grove/grove.yaml":  "include: [ci/grove-base.yaml]\nstart: []\n",
+		"ci/grove-base.yaml": "container:\n  image: alpine\nagent:\n  command: sh\n  args: []\n",
+	})
+	bad := makeGitRepoWithFiles(t, map[string]string{
+		".grove/grove.yaml": "include: [ci/missing.yaml]\ncontainer:\n  image: alpine\n",
+	})
+	env.startDaemon()
+
+	env.groveOK("project", "create", "good-app", "--repo", good)
+	env.groveOK("project", "create", "bad-app", "--repo", bad)
+
+	env.groveOK("start", "good-app", "feat/a", "-d")
+	assert.Contains(t, env.groveOK("list"), "feat/a")
+
+	out, err := env.grove("start", "bad-app", "feat/b", "-d")
+	assert.Error(t, err)
+	assert.Contains(t, out, `included file "ci/missing.yaml" not found`)
+}
+
+// TestInstanceOutlivesRegistration verifies that restart, finish and drop
+// work from the instance record once the project registration is deleted.
+func TestInstanceOutlivesRegistration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in -short mode")
+	}
+
+	env := newTestEnv(t)
+	repoDir := makeGitRepo(t)
+	env.startDaemon()
+
+	env.groveOK("project", "create", "my-app", "--repo", repoDir)
+	env.groveOK("start", "my-app", "feat/orphan", "-d")
+	time.Sleep(100 * time.Millisecond)
+
+	require.NoError(t, os.Remove(filepath.Join(env.groveRoot, "projects", "my-app", "project.yaml")))
+
+	env.groveOK("restart", "1", "-d")
+	env.groveOK("finish", "1")
+	assert.Contains(t, env.groveOK("list"), "FINISHED")
+
+	worktree := strings.TrimSpace(env.groveOK("dir", "1"))
+	env.groveOK("drop", "-f", "1")
+	assert.NoDirExists(t, worktree)
+}
+
+func TestProjectDelete(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in -short mode")
+	}
+
+	env := newTestEnv(t)
+	repoDir := makeGitRepo(t)
+	env.startDaemon()
+
+	env.groveOK("project", "create", "my-app", "--repo", repoDir)
+	env.groveOK("start", "my-app", "feat/a", "-d")
+	env.groveOK("start", "my-app", "feat/b", "-d")
+
+	out, err := env.groveInput("n\n", "project", "delete", "my-app")
+	require.NoError(t, err)
+	assert.Contains(t, out, "aborted")
+	assert.Contains(t, env.groveOK("list"), "feat/a")
+
+	out, err = env.groveInput("y\n", "project", "delete", "my-app", "--keep-images")
+	require.NoError(t, err, out)
+	assert.Contains(t, out, "Dropping instance 1 (feat/a)")
+	assert.Contains(t, out, "Dropping instance 2 (feat/b)")
+	assert.Contains(t, out, "Keeping images")
+	assert.Contains(t, out, "Deleted project")
+
+	assert.NotContains(t, env.groveOK("list"), "feat/")
+	assert.NoDirExists(t, filepath.Join(env.groveRoot, "projects", "my-app"))
+	assert.NotContains(t, env.groveOK("project", "list"), "my-app")
+
+	calls, err := os.ReadFile(env.dockerLog())
+	require.NoError(t, err)
+	assert.Contains(t, string(calls), "ps -aq --filter label=grove.project=my-app")
+	assert.Contains(t, string(calls), "volume ls -q --filter label=grove.project=my-app")
+	assert.NotContains(t, string(calls), "image ls")
+}
+
+// TestPlatform checks that container.platform reaches docker pull, and that
+// an image running under emulation is warned about and shown by status.
+func TestPlatform(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in -short mode")
+	}
+
+	env := newTestEnv(t)
+	repoDir := makeGitRepoWithConfig(t,
+		"container:\n  image: unpulled/alpine\n  platform: linux/amd64\nstart: []\nagent:\n  command: sh\n  args: []\n")
+	require.NoError(t, os.WriteFile(env.dockerLog()+".arch", []byte("aarch64 linux/amd64\n"), 0o644))
+	env.startDaemon()
+	env.groveOK("project", "create", "my-app", "--repo", repoDir)
+
+	out := env.groveOK("start", "my-app", "feat/x86", "-d")
+	assert.Contains(t, out, "running linux/amd64 under emulation on this linux/arm64 host")
+	assert.NotContains(t, out, "hint:", "container.platform asked for it")
+
+	calls, err := os.ReadFile(env.dockerLog())
+	require.NoError(t, err)
+	assert.Contains(t, string(calls), "pull --platform linux/amd64 unpulled/alpine")
+
+	status := env.groveOK("status", "1")
+	assert.Contains(t, status, "linux/amd64")
+	assert.Contains(t, status, "under emulation on this linux/arm64 host")
+}
+
+// TestGC checks that grove gc sweeps only dangling scratch volumes.
+func TestGC(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in -short mode")
+	}
+
+	env := newTestEnv(t)
+	env.startDaemon()
+
+	out := env.groveOK("gc")
+	assert.Contains(t, out, "No volumes to remove")
+	calls, err := os.ReadFile(env.dockerLog())
+	require.NoError(t, err)
+	assert.Contains(t, string(calls), "volume ls -q --filter dangling=true --filter label=grove.scratch")
+}
+
+// TestPorcelain verifies that --porcelain leaves exactly one result line per
+// result on stdout.
+func TestPorcelain(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in -short mode")
+	}
+
+	env := newTestEnv(t)
+	repoDir := makeGitRepoWithConfig(t, "container:\n  image: alpine\nagent:\n  command: sh\n  args: []\nfinish:\n  - echo pushed\n")
+	env.startDaemon()
+
+	assert.Equal(t, "project-created my-app\n", env.groveStdout("--porcelain", "project", "create", "my-app", "--repo", repoDir))
+	// Without -d: porcelain never attaches.
+	assert.Equal(t, "started 1\n", env.groveStdout("--porcelain", "start", "my-app", "feat/a"))
+	assert.Equal(t, "started 2\n", env.groveStdout("start", "my-app", "feat/b", "-d", "--porcelain"))
+	assert.Equal(t, "noted 1\n", env.groveStdout("--porcelain", "note", "1", "hello"))
+	assert.Equal(t, "labeled 1\n", env.groveStdout("--porcelain", "label", "1", "team=x"))
+	assert.Equal(t, "finished 1 ok\n", env.groveStdout("--porcelain", "finish", "1"))
+	assert.Equal(t, "dropped 2\n", env.groveStdout("--porcelain", "drop", "-f", "2"))
+
+	// Read-only commands are unaffected.
+	assert.Contains(t, env.groveStdout("--porcelain", "project", "dir", "my-app"), filepath.Join("projects", "my-app", "main"))
+}
+
+func TestValidateAndSchema(t *testing.T) {
+	env := newTestEnv(t)
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+		return path
+	}
+	exitCode := func(err error) int {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return exitErr.ExitCode()
+		}
+		require.NoError(t, err)
+		return 0
+	}
+
+	// No daemon and no project: validate only reads the file.
+	out, err := env.grove("validate", write("ok.yaml", "container:\n  image: alpine\n"))
+	assert.Equal(t, 0, exitCode(err), out)
+	assert.Contains(t, out, "ok.yaml: ok")
+
+	out, err = env.grove("validate", write("warn.yaml", "container:\n  image: alpine\nagent:\n  command: codex\n"))
+	assert.Equal(t, 2, exitCode(err), out)
+	assert.Contains(t, out, `warning: agent.command "codex"`)
+
+	out, err = env.grove("validate", write("bad.yaml", "container:\n  image: alpine\ncheck:\n  auto: always\n"))
+	assert.Equal(t, 1, exitCode(err), out)
+	assert.Contains(t, out, `error: line 4: check.auto: unknown value "always"`)
+
+	schema := env.groveStdout("schema")
+	var parsed map[string]any
+	require.NoError(t, json.Unmarshal([]byte(schema), &parsed))
+	assert.Equal(t, "grove.yaml", parsed["title"])
+}
+
+func TestAgentContextFile(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in -short mode")
+	}
+
+	env := newTestEnv(t)
+	repoDir := makeGitRepoWithFiles(t, map[string]string{
+		"grove.yaml": "container:\n  image: alpine\nagent:\n  command: sh\n  args: []\n" +
+			"  context_files: [docs/agents.md, missing.md]\n" +
+			"  env:\n    CLAUDE_MD: \"{{context_file}}\"\n",
+		"docs/agents.md": "Commit messages: area: summary\n",
+	})
+	env.startDaemon()
+
+	env.groveOK("project", "create", "my-app", "--repo", repoDir)
+	out := env.groveOK("start", "my-app", "feat/ctx", "-d")
+	assert.Contains(t, out, `skipping context file "missing.md"`)
+
+	worktree := strings.TrimSpace(env.groveOK("dir", "1"))
+	data, err := os.ReadFile(filepath.Join(worktree, ".grove", "CONTEXT.md"))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "Commit messages: area: summary")
+
+	status, err := exec.Command("git", "-C", worktree, "status", "--porcelain").Output()
+	require.NoError(t, err)
+	assert.NotContains(t, string(status), "CONTEXT.md", "the context file is excluded from git")
+
+	calls, err := os.ReadFile(env.dockerLog())
+	require.NoError(t, err)
+	assert.Contains(t, string(calls), "CLAUDE_MD=/app/.grove/CONTEXT.md")
+}
+
+// TestOutputToClosedPipe runs commands whose stdout is a pipe nobody reads,
+// as in grove logs 1 | head -0: they must exit 0, not die of SIGPIPE.
+func TestOutputToClosedPipe(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in -short mode")
+	}
+
+	env := newTestEnv(t)
+	repoDir := makeGitRepoWithConfig(t, "container:\n  image: alpine\nstart:\n  - make deps\n"+
+		"agent:\n  command: sh\n  args: []\ncheck:\n  - make test\nfinish:\n  - make release\n")
+	env.startDaemon()
+	env.groveOK("project", "create", "my-app", "--repo", repoDir)
+	env.groveOK("start", "my-app", "feat/pipe", "-d")
+
+	for _, args := range [][]string{
+		{"logs", "1", "--setup"},
+		{"list"},
+		{"finish", "1"},
+		{"logs", "1", "--agent"},
+	} {
+		r, w, err := os.Pipe()
+		require.NoError(t, err)
+		r.Close()
+		cmd := exec.Command(groveBin, args...)
+		cmd.Env = env.envVars()
+		cmd.Stdout = w
+		var stderr strings.Builder
+		cmd.Stderr = &stderr
+		err = cmd.Run()
+		w.Close()
+		assert.NoError(t, err, "grove %v", args)
+		assert.Empty(t, stderr.String(), "grove %v", args)
+	}
+	// The finish ran to the end although nobody read its output.
+	assert.Contains(t, env.groveOK("logs", "1", "--agent"), "$ make release")
+}
+
+// TestStartDefaults checks that grove start takes its options from the
+// defaults section of grove.yaml, and that flags override them.
+func TestStartDefaults(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in -short mode")
+	}
+
+	env := newTestEnv(t)
+	repoDir := makeGitRepoWithConfig(t, "container:\n  image: alpine\nstart: []\nagent:\n  command: sh\n  args: []\n"+
+		"defaults:\n  detach: true\n  base: develop\n  labels: {team: core}\n  task_template: \"Implement {{branch}}\"\n")
+	out, err := exec.Command("git", "-C", repoDir, "branch", "develop").CombinedOutput()
+	require.NoError(t, err, "%s", out)
+	out, err = exec.Command("git", "-C", repoDir, "-c", "user.name=t", "-c", "user.email=t@t",
+		"commit", "--allow-empty", "-m", "main moves on").CombinedOutput()
+	require.NoError(t, err, "%s", out)
+	develop, err := exec.Command("git", "-C", repoDir, "rev-parse", "develop").Output()
+	require.NoError(t, err)
+	env.startDaemon()
+	env.groveOK("project", "create", "my-app", "--repo", repoDir)
+
+	shown := env.groveOK("start", "my-app", "feat/x", "--show-effective", "--label", "team=web")
+	assert.Contains(t, shown, "detach:   yes  (grove.yaml)")
+	assert.Contains(t, shown, "base:     develop  (grove.yaml)")
+	assert.Contains(t, shown, "labels:   team=web (flag)")
+	assert.Contains(t, shown, "task:     Implement feat/x  (grove.yaml)")
+	assert.Contains(t, env.groveOK("list"), "no instances", "--show-effective starts nothing")
+
+	// No -d, yet the start detaches because of defaults.detach.
+	env.groveOK("start", "my-app", "feat/x")
+	worktree := strings.TrimSpace(env.groveOK("dir", "1"))
+	head, err := exec.Command("git", "-C", worktree, "rev-parse", "HEAD").Output()
+	require.NoError(t, err)
+	assert.Equal(t, string(develop), string(head))
+	assert.Contains(t, env.groveOK("status", "1"), "Task:")
+
+	calls, err := os.ReadFile(env.dockerLog())
+	require.NoError(t, err)
+	assert.Contains(t, string(calls), "GROVE_TASK=Implement feat/x")
+
+	shown = env.groveOK("start", "my-app", "feat/y", "--show-effective", "--attach", "--base", "", "--task", "")
+	assert.Contains(t, shown, "detach:   no, attach after starting  (flag)")
+	assert.Contains(t, shown, "base:     HEAD of the main checkout  (flag)")
+	assert.Contains(t, shown, "task:     -  (flag)")
+}
+
+// TestStartDryRun checks that grove start --dry-run reports each check and
+// creates nothing, and exits 1 when a check fails.
+func TestStartDryRun(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in -short mode")
+	}
+
+	env := newTestEnv(t)
+	env.startDaemon()
+	env.groveOK("project", "create", "my-app", "--repo", makeGitRepo(t))
+	env.groveOK("project", "create", "other", "--repo", makeGitRepoWithConfig(t,
+		"container:\n  image: missing/app\nagent:\n  command: sh\n"))
+
+	out := env.groveOK("start", "my-app", "feat/x", "--dry-run")
+	for _, want := range []string{
+		"✓ config       grove.yaml loads",
+		"✓ branch       new branch feat/x from the main checkout's HEAD",
+		"✓ repo ",
+		"✓ image        alpine is present",
+		"- credentials  agent sh needs none from grove",
+		"- disk ",
+		"nothing was created",
+	} {
+		assert.Contains(t, out, want)
+	}
+	assert.Contains(t, env.groveOK("list"), "no instances")
+	assert.NoFileExists(t, env.dockerLog()+".images", "no container was run")
+
+	out, err := env.grove("start", "other", "main", "--dry-run")
+	assert.Error(t, err)
+	assert.Contains(t, out, "✗ branch       main is checked out in ")
+	assert.Contains(t, out, "✗ image        missing/app is neither present nor in its registry")
+	assert.Contains(t, out, "no such manifest: missing/app")
+	assert.Contains(t, out, "grove: 2 of 6 checks failed")
+}
+
+// TestInsufficientDisk checks that a start is refused when the disk is
+// fuller than groved's --disk-min allows, and that grove doctor says so.
+func TestInsufficientDisk(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in -short mode")
+	}
+
+	env := newTestEnv(t)
+	repoDir := makeGitRepo(t)
+	env.startDaemon("--disk-min", "1000PiB")
+	env.groveOK("project", "create", "my-app", "--repo", repoDir)
+
+	out, err := env.grove("start", "my-app", "feat/full", "-d")
+	require.Error(t, err)
+	assert.Contains(t, out, "not enough disk space to start")
+	assert.Contains(t, out, "below the minimum of 1000.0 PiB")
+	assert.Contains(t, out, "grove prune")
+	assert.Contains(t, env.groveOK("list"), "no instances")
+
+	out, err = env.grove("doctor")
+	assert.Error(t, err, "grove doctor exits 1")
+	assert.Contains(t, out, "starts are refused")
+	assert.Contains(t, out, env.groveRoot)
+}
+
+// TestHostFinishCommand checks that a finish command marked host: true runs
+// on the host, in the worktree, but only once allow_host_commands is set.
+func TestHostFinishCommand(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in -short mode")
+	}
+
+	env := newTestEnv(t)
+	repoDir := makeGitRepoWithConfig(t, "container:\n  image: alpine\nstart: []\nagent:\n  command: sh\n  args: []\n"+
+		"finish:\n  - make release\n  - run: echo \"$GROVE_BRANCH\" > host-ran\n    host: true\n")
+	env.startDaemon()
+	env.groveOK("project", "create", "my-app", "--repo", repoDir)
+	env.groveOK("start", "my-app", "feat/host", "-d")
+	worktree := strings.TrimSpace(env.groveOK("dir", "1"))
+
+	out, err := env.grove("finish", "1")
+	assert.Error(t, err)
+	assert.Contains(t, out, "$ echo \"$GROVE_BRANCH\" > host-ran")
+	assert.Contains(t, out, "allow_host_commands")
+	assert.NoFileExists(t, filepath.Join(worktree, "host-ran"))
+
+	require.NoError(t, os.WriteFile(filepath.Join(env.groveRoot, "config.yaml"), []byte("allow_host_commands: true\n"), 0o644))
+	env.groveOK("start", "my-app", "feat/host2", "-d")
+	worktree = strings.TrimSpace(env.groveOK("dir", "2"))
+	env.groveOK("finish", "2")
+	data, err := os.ReadFile(filepath.Join(worktree, "host-ran"))
+	require.NoError(t, err)
+	assert.Equal(t, "feat/host2\n", string(data))
+
+	calls, err := os.ReadFile(env.dockerLog())
+	require.NoError(t, err)
+	assert.NotContains(t, string(calls), "host-ran", "the host command did not go through docker")
+}
+
+// TestFinishUsesSnapshot verifies that finish runs the finish steps the
+// instance was started with after grove.yaml changes, and the new ones with
+// --current-config.
+func TestFinishUsesSnapshot(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in -short mode")
+	}
+
+	env := newTestEnv(t)
+	repoDir := makeGitRepoWithConfig(t, "container:\n  image: alpine\nstart: []\nagent:\n  command: sh\n  args: []\n"+
+		"finish:\n  - make release\n")
+	env.startDaemon()
+	env.groveOK("project", "create", "my-app", "--repo", repoDir)
+	env.groveOK("start", "my-app", "feat/one", "-d")
+	env.groveOK("start", "my-app", "feat/two", "-d")
+	for _, id := range []string{"1", "2"} {
+		worktree := strings.TrimSpace(env.groveOK("dir", id))
+		require.NoError(t, os.WriteFile(filepath.Join(worktree, "grove.yaml"),
+			[]byte("container:\n  image: alpine\nstart: []\nagent:\n  command: sh\n  args: []\nfinish:\n  - make deploy\n"), 0o644))
+	}
+
+	out := env.groveOK("finish", "1")
+	assert.Contains(t, out, "$ make release")
+	assert.NotContains(t, out, "make deploy")
+
+	out = env.groveOK("finish", "2", "--current-config")
+	assert.Contains(t, out, "$ make deploy")
+	assert.NotContains(t, out, "make release")
+}
+
+// TestStartTimings verifies that a start records how long each stage took,
+// that grove status shows them, and that grove stats summarises them.
+func TestStartTimings(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in -short mode")
+	}
+
+	env := newTestEnv(t)
+	repoDir := makeGitRepoWithConfig(t, "container:\n  image: unpulled/alpine\nstart: []\nagent:\n  command: sh\n  args: []\n")
+	env.startDaemon()
+	env.groveOK("project", "create", "my-app", "--repo", repoDir)
+	env.groveOK("start", "my-app", "feat/timed", "-d")
+
+	var info struct {
+		StartTimings []struct {
+			Stage    string `json:"stage"`
+			Duration int64  `json:"duration_ms"`
+		} `json:"start_timings"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(env.groveOK("status", "1", "--json")), &info))
+	var stages []string
+	for _, st := range info.StartTimings {
+		stages = append(stages, st.Stage)
+		assert.GreaterOrEqual(t, st.Duration, int64(0))
+	}
+	assert.Equal(t, []string{"clone", "worktree", "image", "container", "start", "agent-install", "agent-launch"}, stages)
+
+	calls, err := os.ReadFile(env.dockerLog())
+	require.NoError(t, err)
+	assert.Contains(t, string(calls), "pull unpulled/alpine", "a missing image is pulled before docker run")
+
+	out := env.groveOK("status", "1")
+	assert.Contains(t, out, "Start:")
+	assert.Contains(t, out, "agent-install")
+
+	out = env.groveOK("stats")
+	assert.Contains(t, out, "AGENT-LAUNCH")
+	assert.Regexp(t, `my-app\s+1\s`, out)
+}
+
+// TestProjectFetch verifies that grove project fetch clones the main
+// checkout ahead of the first start, that project list shows it, and that
+// prefetch_on_register clones right after project create.
+func TestProjectFetch(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in -short mode")
+	}
+
+	env := newTestEnv(t)
+	repoDir := makeGitRepo(t)
+	env.startDaemon()
+	env.groveOK("project", "create", "my-app", "--repo", repoDir)
+	assert.Regexp(t, `my-app\s+personal\s+no\s`, env.groveOK("project", "list"))
+
+	out := env.groveOK("project", "fetch", "1")
+	assert.Contains(t, out, "Cloning")
+	assert.Contains(t, out, "Fetched project")
+	assert.DirExists(t, filepath.Join(env.groveRoot, "projects", "my-app", "main", ".git"))
+	assert.Regexp(t, `my-app\s+personal\s+\d+s ago\s`, env.groveOK("project", "list"))
+
+	out = env.groveOK("project", "fetch", "my-app", "--porcelain")
+	assert.Contains(t, out, "project-fetched my-app")
+
+	require.NoError(t, os.WriteFile(filepath.Join(env.groveRoot, "config.yaml"), []byte("prefetch_on_register: true\n"), 0o644))
+	out = env.groveOK("project", "create", "other", "--repo", repoDir)
+	assert.Contains(t, out, "Cloning in the background")
+	assert.Eventually(t, func() bool {
+		return strings.Contains(env.daemonLog.String(), "project other: cloned")
+	}, 10*time.Second, 50*time.Millisecond)
+	assert.Regexp(t, `other\s+personal\s+\d+s ago\s`, env.groveOK("project", "list"))
+}
+
+// TestProjectListVerbose checks grove project list --verbose and --json:
+// instances by state and disk usage from the daemon, and the plain table
+// without one.
+func TestProjectListVerbose(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in -short mode")
+	}
+
+	env := newTestEnv(t)
+	repoDir := makeGitRepo(t)
+	env.groveOK("project", "create", "my-app", "--repo", repoDir)
+	env.groveOK("project", "create", "idle", "--repo", repoDir)
+	out := env.groveOK("project", "list", "--verbose")
+	assert.Contains(t, out, "REPO", "the plain table")
+	assert.Contains(t, out, "the daemon is not reachable; instances and disk usage are unknown")
+
+	env.startDaemon()
+	env.groveOK("start", "my-app", "feat/a", "-d")
+	out = env.groveOK("project", "list", "-v")
+	assert.Contains(t, out, "WORKTREES")
+	assert.Regexp(t, `my-app\s+\d+s ago\s+[\d.]+ [KMG]?i?B\s+[\d.]+ [KMG]?i?B\s+\d+s ago\s+1 \(1 [A-Z_]+\)`, out)
+	assert.Regexp(t, `idle\s+no\s+-\s+[\d.]+ [KMG]?i?B\s+-\s+.*none`, out)
+
+	var projects []proto.ProjectInfo
+	require.NoError(t, json.Unmarshal([]byte(env.groveStdout("project", "list", "--verbose", "--json")), &projects))
+	require.Len(t, projects, 2)
+	assert.Equal(t, "my-app", projects[1].Name)
+	assert.Equal(t, 1, projects[1].Instances)
+	assert.Len(t, projects[1].InstanceStates, 1)
+	assert.Positive(t, projects[1].MainBytes)
+	assert.Positive(t, projects[1].WorktreesBytes)
+	assert.NotZero(t, projects[1].LastStartAt)
+
+	projects = nil
+	require.NoError(t, json.Unmarshal([]byte(env.groveStdout("project", "list", "--json")), &projects))
+	assert.Zero(t, projects[1].MeasuredAt, "disk usage only with --verbose")
+}
+
+// TestProjectMove covers a project kept in a data_dir: moving an existing
+// project's data there, grove dir following it, an instance restarted in
+// the moved worktree, and starts refused while the directory is gone.
+func TestProjectMove(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in -short mode")
+	}
+
+	env := newTestEnv(t)
+	repoDir := makeGitRepo(t)
+	env.startDaemon()
+	env.groveOK("project", "create", "my-app", "--repo", repoDir)
+	env.groveOK("start", "my-app", "feat/a", "-d")
+	time.Sleep(100 * time.Millisecond) // the mock agent exits at once
+	_, _ = env.grove("stop", "1")
+
+	volume := filepath.Join(t.TempDir(), "ext")
+	_, err := env.grove("project", "move", "my-app", volume)
+	assert.Error(t, err, "not an existing directory")
+	require.NoError(t, os.Mkdir(volume, 0o755))
+	out := env.groveOK("project", "move", "my-app", volume, "--porcelain")
+	assert.Contains(t, out, "project-moved my-app")
+
+	assert.Equal(t, filepath.Join(volume, "main"), strings.TrimSpace(env.groveStdout("project", "dir", "my-app")))
+	worktree := strings.TrimSpace(env.groveStdout("dir", "1"))
+	assert.True(t, strings.HasPrefix(worktree, filepath.Join(volume, "worktrees")+"/"), worktree)
+	env.groveOK("restart", "1", "-d", "--recreate-container")
+
+	// The volume goes away.
+	env.groveOK("stop", "1")
+	require.NoError(t, os.Rename(volume, volume+".unmounted"))
+	out, err = env.grove("start", "my-app", "feat/b", "-d")
+	assert.Error(t, err)
+	assert.Contains(t, out, "which is not available; is its volume mounted?")
+	assert.NoDirExists(t, volume)
+}
+
+// TestHelperAgents verifies that the agents after the first in grove.yaml's
+// agents list are started beside the primary agent and reported, and that
+// attach and logs can select them by name.
+func TestHelperAgents(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in -short mode")
+	}
+
+	env := newTestEnv(t)
+	repoDir := makeGitRepoWithConfig(t, "container:\n  image: alpine\nstart: []\nagents:\n  - name: coder\n    command: sh\n"+
+		"  - name: reviewer\n    command: sh\n    args: [-c, exit 3]\n")
+	env.startDaemon()
+	env.groveOK("project", "create", "my-app", "--repo", repoDir)
+	env.groveOK("start", "my-app", "feat/pair", "-d")
+
+	var info struct {
+		Agents []struct {
+			Name  string   `json:"name"`
+			Argv  []string `json:"argv"`
+			State string   `json:"state"`
+		} `json:"agents"`
+	}
+	require.Eventually(t, func() bool {
+		info.Agents = nil
+		if err := json.Unmarshal([]byte(env.groveOK("status", "1", "--json")), &info); err != nil {
+			return false
+		}
+		return len(info.Agents) == 1 && info.Agents[0].State == "CRASHED"
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.Equal(t, "reviewer", info.Agents[0].Name)
+	assert.Equal(t, []string{"sh", "-c", "exit 3"}, info.Agents[0].Argv)
+
+	calls, err := os.ReadFile(env.dockerLog())
+	require.NoError(t, err)
+	assert.Contains(t, string(calls), "GROVE_AGENT=reviewer", "helpers are told their name")
+
+	out := env.groveOK("status", "1")
+	assert.Contains(t, out, "Agents:")
+	assert.Contains(t, out, "reviewer")
+
+	out, err = env.grove("attach", "1", "--agent", "reviewer")
+	require.Error(t, err)
+	assert.Contains(t, out, "agent reviewer has crashed")
+	out, err = env.grove("attach", "1", "--agent", "tester")
+	require.Error(t, err)
+	assert.Contains(t, out, `no agent "tester" (helper agents: reviewer)`)
+
+	env.groveOK("logs", "1", "--helper", "reviewer")
+	_, err = env.grove("logs", "1", "--helper", "tester")
+	require.Error(t, err)
+}
+
+// TestScratch starts instances from a path without registering a project:
+// one on a clone of a git repo, dropped by prune once finished, and one in
+// a plain directory, which drop leaves alone.
+func TestScratch(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in -short mode")
+	}
+
+	env := newTestEnv(t)
+	repoDir := makeGitRepo(t)
+	env.startDaemon()
+
+	assert.Equal(t, "started 1\n", env.groveStdout("--porcelain", "scratch", repoDir, "--branch", "try/it"))
+	out := env.groveOK("list")
+	assert.Contains(t, out, "scratch:"+filepath.Base(repoDir))
+	assert.Contains(t, out, "try/it")
+	dataDir := filepath.Join(env.groveRoot, "scratch", "1")
+	assert.Equal(t, dataDir, filepath.Dir(filepath.Dir(strings.TrimSpace(env.groveOK("dir", "1")))))
+	assert.NotContains(t, env.groveOK("project", "list"), "scratch")
+
+	env.groveOK("finish", "1")
+	out, err := env.groveInput("y\n", "prune")
+	require.NoError(t, err, out)
+	assert.Contains(t, out, "Dropped")
+	assert.NoDirExists(t, dataDir)
+
+	plain := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(plain, "notes.txt"), []byte("keep me\n"), 0o644))
+	_, err = env.grove("scratch", plain, "-d")
+	require.Error(t, err, "not a git repository, so only --in-place works")
+	env.groveOK("scratch", plain, "--in-place", "--agent", "sh", "-d")
+	assert.Equal(t, plain, strings.TrimSpace(env.groveOK("dir", "1")))
+	env.groveOK("drop", "-f", "1")
+	assert.FileExists(t, filepath.Join(plain, "notes.txt"))
+}
+
+// TestPin checks that prune leaves a pinned instance alone, saying so, and
+// that drop asks twice before dropping it.
+func TestPin(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in -short mode")
+	}
+
+	env := newTestEnv(t)
+	repoDir := makeGitRepo(t)
+	env.startDaemon()
+
+	env.groveOK("project", "create", "my-app", "--repo", repoDir)
+	env.groveOK("start", "my-app", "feat/keep", "-d", "--pin")
+	env.groveOK("start", "my-app", "feat/scrap", "-d")
+	env.groveOK("stop", "1")
+	env.groveOK("stop", "2")
+	assert.Contains(t, env.groveOK("list"), "feat/keep  📌")
+
+	out, err := env.groveInput("y\n", "prune")
+	require.NoError(t, err, out)
+	assert.Contains(t, out, "Dropped")
+	assert.Contains(t, out, "1 pinned instance(s) are left alone")
+	list := env.groveOK("list")
+	assert.Contains(t, list, "feat/keep")
+	assert.NotContains(t, list, "feat/scrap")
+
+	out, err = env.groveInput("y\nn\n", "drop", "1")
+	require.NoError(t, err, out)
+	assert.Contains(t, out, "is pinned. Drop it anyway?")
+	assert.Contains(t, out, "aborted")
+	assert.Contains(t, env.groveOK("list"), "feat/keep")
+
+	assert.Equal(t, "unpinned 1\n", env.groveStdout("--porcelain", "unpin", "1"))
+	out, err = env.groveInput("y\n", "drop", "1")
+	require.NoError(t, err, out)
+	assert.Contains(t, out, "Dropped")
+}
+
+// TestCancelStart checks that Ctrl-C in grove start cancels the start: the
+// start command under way is killed and what the start made is removed.
+func TestCancelStart(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in -short mode")
+	}
+
+	env := newTestEnv(t)
+	env.hostExec()
+	repoDir := makeGitRepoWithConfig(t, "container:\n  image: alpine\nstart:\n  - exec sleep 30\nagent:\n  command: sh\n  args: []\n")
+	env.startDaemon()
+	env.groveOK("project", "create", "my-app", "--repo", repoDir)
+
+	s := env.groveTTY("start", "my-app", "feat/slow", "-d")
+	s.expect("Ctrl-C cancels")
+	require.Eventually(t, func() bool {
+		calls, _ := os.ReadFile(env.dockerLog())
+		return strings.Contains(string(calls), "sleep 30")
+	}, outputTimeout, 20*time.Millisecond)
+	s.send("\x03")
+	s.expect("start cancelled during the start stage, as requested")
+	var exitErr *exec.ExitError
+	require.ErrorAs(t, s.wait(), &exitErr)
+	assert.NotContains(t, s.out.String(), "daemon logs")
+	assert.Contains(t, env.daemonLog.String(), "start cancelled: stage=start project=my-app branch=feat/slow")
+
+	assert.Contains(t, env.groveOK("list"), "no instances")
+	assert.NotContains(t, env.groveOK("worktrees", "my-app"), "feat/slow")
+
+	out, err := env.grove("cancel-start", "nope")
+	assert.Error(t, err)
+	assert.Contains(t, out, "no start under way with ID nope")
 }